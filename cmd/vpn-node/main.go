@@ -12,6 +12,36 @@
 //
 //	sudo vpn-node --connect 95.217.238.72:8443
 //
+// Split tunneling (only the given subnets go through the VPN):
+//
+//	sudo vpn-node --connect 95.217.238.72:8443 --route-subnet 10.0.0.0/8,192.168.5.0/24
+//
+// Custom address space (clients must use the same --vpn-subnet):
+//
+//	sudo vpn-node --server --vpn-subnet 10.99.0.0/24
+//	sudo vpn-node --connect 95.217.238.72:8443 --vpn-subnet 10.99.0.0/24
+//
+// Give route-all clients internet access through the server (Linux, iptables):
+//
+//	sudo vpn-node --server --enable-nat
+//
+// Let peers view this node's logs and stats (read-only control socket on
+// the VPN address; the full API stays on 127.0.0.1:9001):
+//
+//	sudo vpn-node --connect 95.217.238.72:8443 --listen-control-vpn
+//
+// Kill switch (never fall back to the open internet when the tunnel drops):
+//
+//	sudo vpn-node --connect 95.217.238.72:8443 --kill-switch
+//
+// Per-peer bandwidth caps (server mode, repeatable):
+//
+//	sudo vpn-node --server --peer-rate-limit 10.8.0.5=20 --peer-rate-limit 10.8.0.7=5
+//
+// Push metrics to a central collector every 15s (JSON POST):
+//
+//	sudo vpn-node --connect 95.217.238.72:8443 --metrics-push-url http://10.8.0.1:9100/metrics
+//
 // The node daemon runs continuously, maintaining VPN tunnels and WebSocket
 // connections to other nodes in the mesh network.
 package main
@@ -21,24 +51,36 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"net/url"
 	"os"
 	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/miguelemosreverte/vpn/internal/node"
+	"github.com/miguelemosreverte/vpn/internal/store"
+	"github.com/miguelemosreverte/vpn/internal/tunnel"
 	"github.com/miguelemosreverte/vpn/internal/ui"
 )
 
 func main() {
 	// Flags
 	name := flag.String("name", "", "Node name (default: hostname)")
-	vpnAddr := flag.String("vpn-addr", "10.8.0.1", "VPN IP address for this node")
+	vpnAddr := flag.String("vpn-addr", "10.8.0.1", "VPN IP address for this node (server mode; follows --vpn-subnet unless set)")
+	vpnSubnet := flag.String("vpn-subnet", tunnel.DefaultSubnet, "VPN address space in CIDR notation (must match on server and clients)")
+	flag.StringVar(vpnSubnet, "subnet", tunnel.DefaultSubnet, "Alias for --vpn-subnet")
 	listenVPN := flag.String("listen-vpn", ":8443", "VPN listener address (server mode)")
 	listenWS := flag.String("listen-ws", ":9000", "WebSocket listener address")
 	listenControl := flag.String("listen-control", "127.0.0.1:9001", "Control socket address")
+	listenControlVPN := flag.Bool("listen-control-vpn", false, "Also serve read-only control requests on the VPN address (port of --listen-control), so peers can view this node's logs and stats")
+	controlToken := flag.String("control-token", "", "Require this token on control socket requests (default: $VPN_CONTROL_TOKEN)")
+	readonlyToken := flag.String("readonly-token", "", "Additional token that may only query, not change, the node; also sent when querying peers (needs --control-token)")
 
 	// Mode flags
 	serverMode := flag.Bool("server", false, "Run in server mode (accept connections)")
 	connectTo := flag.String("connect", "", "Server address to connect to (client mode)")
+	enableNAT := flag.Bool("enable-nat", false, "Masquerade VPN traffic out of the external interface so route-all clients reach the internet (server mode, Linux iptables)")
 
 	// TLS flags
 	useTLS := flag.Bool("tls", false, "Use TLS encryption for VPN connections")
@@ -46,7 +88,8 @@ func main() {
 	keyFile := flag.String("key", "certs/server.key", "TLS private key file")
 
 	// Encryption flag
-	encryption := flag.Bool("encrypt", true, "Enable packet encryption (AES-256-GCM)")
+	encryption := flag.Bool("encrypt", true, "Enable packet encryption (AES-256-GCM or ChaCha20-Poly1305)")
+	cipher := flag.String("cipher", "", "Packet cipher to ask for: aes-gcm or chacha20 (default: chacha20 without AES hardware acceleration, e.g. Raspberry Pi; used if either end asks for it)")
 
 	// UI flag - serve web dashboard
 	listenUI := flag.String("listen-ui", "localhost:8080", "Web UI address (empty to disable)")
@@ -55,6 +98,49 @@ func main() {
 	// Routing flags - route-all defaults to true for VPN clients
 	routeAll := flag.Bool("route-all", true, "Route all traffic through VPN (client mode, enabled by default)")
 	noRouteAll := flag.Bool("no-route-all", false, "Disable routing all traffic through VPN (direct mode)")
+	routeSubnet := flag.String("route-subnet", "", "Comma-separated CIDRs to route through VPN instead of all traffic (split tunneling)")
+	killSwitch := flag.Bool("kill-switch", false, "Block all non-VPN traffic when the tunnel drops instead of restoring direct routing (route-all only)")
+	autoReconnect := flag.Bool("auto-reconnect", false, "Keep trying to reconnect (backing off to every ~5m after the first ~10m) instead of exiting when the server stays unreachable (client mode)")
+	dns := flag.String("dns", "", "Comma-separated DNS servers to use while routing all traffic, e.g. 10.8.0.1 (prevents DNS leaks)")
+
+	// Compression (used only when both client and server enable it)
+	compression := flag.Bool("compression", false, "Enable LZ4 packet compression (for slow links)")
+	flag.BoolVar(compression, "compress", false, "Alias for --compression")
+
+	// Transport for IP packets (UDP servers still accept TCP clients)
+	transport := flag.String("transport", "tcp", "Carry IP packets over tcp or udp (UDP uses the --listen-vpn port number; handshake and control stay on TCP)")
+
+	// Dual-stack (used only when both client and server enable it)
+	ipv6 := flag.Bool("ipv6", false, "Assign IPv6 ULA addresses (fd00::/64) alongside IPv4 and route both")
+
+	// Peer liveness (server mode)
+	peerTimeout := flag.Int("peer-timeout", 90, "Seconds without packets or pings before a client is dropped (server mode, 0 = never; clients too old to ping are kept)")
+
+	// Connection quality alerts (logged and recorded as DEGRADED lifecycle events)
+	rttAlertMs := flag.Int("rtt-alert-ms", 500, "Average RTT in ms at which a peer link is reported degraded (0 = off)")
+	lossAlertPct := flag.Float64("loss-alert-pct", 25, "PING loss in percent at which a peer link is reported degraded (0 = off)")
+
+	// Fleet monitoring
+	metricsPushURL := flag.String("metrics-push-url", "", "POST current metrics as JSON to this URL every 15s (empty = off)")
+
+	// Per-peer egress caps (server mode)
+	peerRateLimits := rateLimitFlag{}
+	flag.Var(peerRateLimits, "peer-rate-limit", "Cap traffic to a peer as <vpn-ip>=<mbps> (server mode, repeatable)")
+	peerBandwidthLimit := flag.Float64("peer-bandwidth-limit", 0, "Cap traffic to each peer without a --peer-rate-limit, in Mbps (server mode, 0 = off)")
+
+	// Minimum level recorded in the log store (changeable with 'vpn config set log_level=...')
+	logLevel := flag.String("log-level", "", "Minimum log level to record: DEBUG, INFO, WARN, ERROR (default: all)")
+
+	// Stdout log format ("json" for aggregators such as Loki or Fluentd)
+	logFormat := flag.String("log-format", "text", "Log output format: text or json")
+	logOutput := flag.String("log-output", "stdout", "Where log lines are written besides the store: stdout or stderr")
+
+	// Storage retention flags (0 = keep persisted or default policy)
+	logsRetentionDays := flag.Int("logs-retention-days", 0, "Days to keep logs (default 7)")
+	metricsRetentionHours := flag.Int("metrics-retention-hours", 0, "Hours to keep raw metrics (default 1)")
+	logRetention := flag.String("log-retention", "", "How long to keep logs, e.g. 12h, 3d, 2w (overrides --logs-retention-days)")
+	maxStorageMB := flag.Int("max-storage-mb", 0, "Database size in MB at which the oldest logs are evicted (default 50)")
+	logRateLimit := flag.Int("log-rate-limit", 0, "Log entries stored per second for each component and level; the rest are counted and summarized every minute (default 100, -1 = off)")
 
 	flag.Parse()
 
@@ -63,6 +149,109 @@ func main() {
 		*routeAll = false
 	}
 
+	// Split tunneling: --route-subnet replaces the default route-all
+	var routeSubnets []string
+	if *routeSubnet != "" {
+		for _, subnet := range strings.Split(*routeSubnet, ",") {
+			subnet = strings.TrimSpace(subnet)
+			if subnet == "" {
+				continue
+			}
+			if _, _, err := net.ParseCIDR(subnet); err != nil {
+				fmt.Printf("Error: invalid --route-subnet %q: %v\n", subnet, err)
+				os.Exit(1)
+			}
+			routeSubnets = append(routeSubnets, subnet)
+		}
+		*routeAll = false
+	}
+
+	subnet, err := tunnel.ParseSubnet(*vpnSubnet)
+	if err != nil {
+		fmt.Printf("Error: invalid --vpn-subnet %q: %v\n", *vpnSubnet, err)
+		os.Exit(1)
+	}
+	vpnAddrSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "vpn-addr" {
+			vpnAddrSet = true
+		}
+	})
+	if !vpnAddrSet {
+		*vpnAddr = tunnel.SubnetHost(subnet, 1)
+	} else if ip := net.ParseIP(*vpnAddr); ip == nil || !subnet.Contains(ip) {
+		fmt.Printf("Error: --vpn-addr %s is not in --vpn-subnet %s\n", *vpnAddr, subnet)
+		os.Exit(1)
+	}
+
+	var dnsServers []string
+	if *dns != "" {
+		for _, server := range strings.Split(*dns, ",") {
+			server = strings.TrimSpace(server)
+			if server == "" {
+				continue
+			}
+			if net.ParseIP(server) == nil {
+				fmt.Printf("Error: invalid --dns server %q\n", server)
+				os.Exit(1)
+			}
+			dnsServers = append(dnsServers, server)
+		}
+	}
+
+	var logsRetention time.Duration
+	if *logRetention != "" {
+		dur, err := store.ParseDuration(*logRetention)
+		if err != nil || dur <= 0 {
+			fmt.Printf("Error: invalid --log-retention %q (use e.g. 12h, 3d, 2w)\n", *logRetention)
+			os.Exit(1)
+		}
+		logsRetention = dur
+	}
+
+	if !store.ValidLogFormat(*logFormat) {
+		fmt.Printf("Error: invalid --log-format %q (use text or json)\n", *logFormat)
+		os.Exit(1)
+	}
+	if *logOutput != "stdout" && *logOutput != "stderr" {
+		fmt.Printf("Error: invalid --log-output %q (use stdout or stderr)\n", *logOutput)
+		os.Exit(1)
+	}
+
+	if *transport != tunnel.TransportTCP && *transport != tunnel.TransportUDP {
+		fmt.Printf("Error: invalid --transport %q (use tcp or udp)\n", *transport)
+		os.Exit(1)
+	}
+
+	if !tunnel.ValidCipher(*cipher) {
+		fmt.Printf("Error: invalid --cipher %q (use aes-gcm or chacha20)\n", *cipher)
+		os.Exit(1)
+	}
+
+	if *peerBandwidthLimit < 0 {
+		fmt.Printf("Error: invalid --peer-bandwidth-limit %g (use Mbps, 0 = off)\n", *peerBandwidthLimit)
+		os.Exit(1)
+	}
+
+	if *metricsPushURL != "" {
+		if u, err := url.Parse(*metricsPushURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fmt.Printf("Error: invalid --metrics-push-url %q (use http:// or https://)\n", *metricsPushURL)
+			os.Exit(1)
+		}
+	}
+
+	if *controlToken == "" {
+		*controlToken = os.Getenv("VPN_CONTROL_TOKEN")
+	}
+	if *readonlyToken != "" && *controlToken == "" {
+		fmt.Println("Error: --readonly-token requires --control-token")
+		os.Exit(1)
+	}
+	if *readonlyToken != "" && *readonlyToken == *controlToken {
+		fmt.Println("Error: --readonly-token must differ from --control-token")
+		os.Exit(1)
+	}
+
 	// Validate mode
 	if !*serverMode && *connectTo == "" {
 		fmt.Println("Error: must specify either --server or --connect <address>")
@@ -72,6 +261,10 @@ func main() {
 		fmt.Println("  Client mode: sudo vpn-node --connect 95.217.238.72:8443")
 		os.Exit(1)
 	}
+	if *enableNAT && !*serverMode {
+		fmt.Println("Error: --enable-nat requires --server")
+		os.Exit(1)
+	}
 
 	// Check for root/admin (required for TUN device)
 	if os.Getuid() != 0 {
@@ -97,17 +290,46 @@ func main() {
 	cfg := node.Config{
 		NodeName:      nodeName,
 		VPNAddress:    *vpnAddr,
+		Subnet:        subnet.String(),
 		ListenVPN:     *listenVPN,
 		ListenWS:      *listenWS,
 		ListenControl: *listenControl,
+		ControlToken:  *controlToken,
+		ReadonlyToken: *readonlyToken,
 		ServerMode:    *serverMode,
 		ConnectTo:     *connectTo,
+		EnableNAT:     *enableNAT,
 		UseTLS:        *useTLS,
 		CertFile:      *certFile,
 		KeyFile:       *keyFile,
 		Encryption:    *encryption,
 		EncryptionKey: encryptionKey,
+		Cipher:        *cipher,
 		RouteAll:      *routeAll,
+		RouteSubnets:  routeSubnets,
+		DNS:           dnsServers,
+		KillSwitch:    *killSwitch,
+		AutoReconnect: *autoReconnect,
+		Compression:   *compression,
+		Transport:     *transport,
+		IPv6:          *ipv6,
+
+		ListenControlVPN:   *listenControlVPN,
+		PeerTimeoutSeconds: *peerTimeout,
+		RTTAlertMs:         *rttAlertMs,
+		LossAlertPct:       *lossAlertPct,
+		MetricsPushURL:     *metricsPushURL,
+		PeerRateLimits:     peerRateLimits,
+		LogLevel:           strings.ToUpper(*logLevel),
+		LogFormat:          *logFormat,
+		LogOutput:          *logOutput,
+
+		LogsRetentionDays:     *logsRetentionDays,
+		MetricsRetentionHours: *metricsRetentionHours,
+		LogsRetention:         logsRetention,
+		MaxStorageMB:          *maxStorageMB,
+		LogRateLimit:          *logRateLimit,
+		PeerBandwidthLimitBps: int64(*peerBandwidthLimit * 1000 * 1000),
 	}
 
 	mode := "CLIENT"
@@ -115,24 +337,18 @@ func main() {
 		mode = "SERVER"
 	}
 
-	fmt.Printf(`
-╔═══════════════════════════════════════════════════╗
-║              VPN NODE DAEMON                       ║
-╠═══════════════════════════════════════════════════╣
-║  Name:       %-36s ║
-║  Mode:       %-36s ║
-║  VPN IP:     %-36s ║
-║  OS:         %-36s ║
-║  Encryption: %-36v ║
-║  TLS:        %-36v ║
-╚═══════════════════════════════════════════════════╝
-`, cfg.NodeName, mode, cfg.VPNAddress, runtime.GOOS, cfg.Encryption, cfg.UseTLS)
-
-	if cfg.ServerMode {
-		fmt.Printf("  Listening on: %s (VPN), %s (WS), %s (Control)\n\n",
-			cfg.ListenVPN, cfg.ListenWS, cfg.ListenControl)
+	// In JSON mode every log line must be JSON: no banner, and log lines
+	// are formatted from the start (the daemon adds storage later)
+	if cfg.LogFormat == "json" {
+		logWriter := store.NewLogWriter(nil, "node", "INFO")
+		logWriter.SetFormat("json")
+		logWriter.SetFields(map[string]string{"node": cfg.NodeName, "vpn_ip": cfg.VPNAddress})
+		if cfg.LogOutput == "stderr" {
+			logWriter.SetOutput(os.Stderr)
+		}
+		log.SetOutput(logWriter)
 	} else {
-		fmt.Printf("  Connecting to: %s\n\n", cfg.ConnectTo)
+		printBanner(cfg, mode)
 	}
 
 	// Start UI server if enabled
@@ -157,6 +373,7 @@ func main() {
 			// Start UI in background
 			go func() {
 				uiServer := ui.NewQuietServer(cfg.ListenControl, uiAddr)
+				uiServer.SetControlToken(*controlToken)
 				log.Printf("[ui] Web dashboard available at http://%s", uiAddr)
 				if err := uiServer.Start(); err != nil {
 					log.Printf("[ui] Web dashboard error: %v", err)
@@ -170,3 +387,53 @@ func main() {
 		log.Fatalf("daemon error: %v", err)
 	}
 }
+
+// rateLimitFlag collects repeated --peer-rate-limit <vpn-ip>=<mbps> values.
+type rateLimitFlag map[string]float64
+
+func (f rateLimitFlag) String() string {
+	var parts []string
+	for ip, mbps := range f {
+		parts = append(parts, fmt.Sprintf("%s=%g", ip, mbps))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f rateLimitFlag) Set(value string) error {
+	ip, mbps, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("expected <vpn-ip>=<mbps>, got %q", value)
+	}
+	if net.ParseIP(ip) == nil {
+		return fmt.Errorf("invalid VPN IP %q", ip)
+	}
+	rate, err := strconv.ParseFloat(mbps, 64)
+	if err != nil || rate <= 0 {
+		return fmt.Errorf("invalid rate %q (use Mbps, e.g. 20)", mbps)
+	}
+	f[ip] = rate
+	return nil
+}
+
+// printBanner prints the startup summary (text log format only).
+func printBanner(cfg node.Config, mode string) {
+	fmt.Printf(`
+╔═══════════════════════════════════════════════════╗
+║              VPN NODE DAEMON                       ║
+╠═══════════════════════════════════════════════════╣
+║  Name:       %-36s ║
+║  Mode:       %-36s ║
+║  VPN IP:     %-36s ║
+║  OS:         %-36s ║
+║  Encryption: %-36v ║
+║  TLS:        %-36v ║
+╚═══════════════════════════════════════════════════╝
+`, cfg.NodeName, mode, cfg.VPNAddress, runtime.GOOS, cfg.Encryption, cfg.UseTLS)
+
+	if cfg.ServerMode {
+		fmt.Printf("  Listening on: %s (VPN), %s (WS), %s (Control)\n\n",
+			cfg.ListenVPN, cfg.ListenWS, cfg.ListenControl)
+	} else {
+		fmt.Printf("  Connecting to: %s\n\n", cfg.ConnectTo)
+	}
+}
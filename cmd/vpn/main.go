@@ -8,16 +8,36 @@
 //
 //	status     Show node status
 //	peers      List connected peers
+//	remove-peer Force-disconnect a stale peer (server only)
 //	diagnose   Run comprehensive VPN connectivity diagnostics
 //	update     Update node(s)
+//	deploy     Trigger the deploy webhook on node(s)
+//	rollback   Revert the node to the version before its last deploy
+//	restart    Restart the node daemon in place
 //	logs       Query logs (Splunk-like)
+//	tail-peer  Stream a peer's logs live
 //	stats      Query metrics (Splunk-like)
+//	top        Live full-screen view of bandwidth and peers
+//	traffic    Show the remote hosts VPN traffic goes to
+//	topology   Show the mesh topology (--watch for live updates)
+//	benchmark  Measure tunnel throughput, loss and jitter to a peer
+//	ping       Ping a peer over the VPN and report RTT and loss
 //	verify     Verify VPN routing is working
 //	connect    Enable VPN routing (route all traffic through VPN)
 //	disconnect Disable VPN routing (restore direct traffic)
+//	restore    Repair routing after vpn-node died with route-all on (no node needed)
 //	ssh        SSH to a peer via VPN
 //	handshake  Send install handshake to server
 //	handshakes Show install handshake history
+//	selftest   Wait until the node is connected and the tunnel works
+//	qr         Print a QR code for installing a new client (server only)
+//	export     Export logs and metrics to a file
+//	retention  Show or change storage retention policy
+//	alert      Manage alerting rules
+//	config     Show or change node configuration
+//	node ls    Show the status of every node in the network
+//	node add   Install and start vpn-node on a new machine over SSH
+//	node health Check a node's health endpoint
 //
 // Global Flags:
 //
@@ -25,25 +45,49 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"math"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/skip2/go-qrcode"
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
 
 	"github.com/miguelemosreverte/vpn/internal/cli"
+	"github.com/miguelemosreverte/vpn/internal/diagnose"
+	"github.com/miguelemosreverte/vpn/internal/node"
 	"github.com/miguelemosreverte/vpn/internal/protocol"
+	"github.com/miguelemosreverte/vpn/internal/store"
+	"github.com/miguelemosreverte/vpn/internal/tunnel"
 	"github.com/miguelemosreverte/vpn/internal/ui"
 )
 
 var nodeAddr string
 
+// noCheckVersion disables fetching the latest release (no internet access).
+var noCheckVersion bool
+
 func main() {
 	rootCmd := &cobra.Command{
 		Use:   "vpn",
@@ -51,22 +95,46 @@ func main() {
 		Long: `vpn is a command-line interface for interacting with VPN nodes.
 
 By default, it connects to the local node at 127.0.0.1:9001.
-Use --node to connect to a remote node.`,
+Use --node to connect to a remote node, and --token (or VPN_CONTROL_TOKEN)
+if the node was started with --control-token.`,
 	}
 
 	rootCmd.PersistentFlags().StringVar(&nodeAddr, "node", "127.0.0.1:9001",
 		"Address of node to connect to")
+	rootCmd.PersistentFlags().StringVar(&cli.ControlToken, "token", "",
+		"Control token of the node (default: $VPN_CONTROL_TOKEN)")
+	rootCmd.PersistentFlags().BoolVar(&noCheckVersion, "no-check-version", false,
+		"Never fetch the latest release from GitHub (no internet access)")
+	cobra.OnInitialize(func() {
+		if cli.ControlToken == "" {
+			cli.ControlToken = os.Getenv("VPN_CONTROL_TOKEN")
+		}
+	})
 
 	rootCmd.AddCommand(statusCmd())
+	rootCmd.AddCommand(whoamiCmd())
 	rootCmd.AddCommand(peersCmd())
+	rootCmd.AddCommand(removePeerCmd())
+	rootCmd.AddCommand(peerCmd())
 	rootCmd.AddCommand(updateCmd())
+	rootCmd.AddCommand(deployCmd())
+	rootCmd.AddCommand(rollbackCmd())
+	rootCmd.AddCommand(restartCmd())
 	rootCmd.AddCommand(logsCmd())
+	rootCmd.AddCommand(tailPeerCmd())
 	rootCmd.AddCommand(statsCmd())
+	rootCmd.AddCommand(topCmd())
+	rootCmd.AddCommand(trafficCmd())
+	rootCmd.AddCommand(topologyCmd())
+	rootCmd.AddCommand(benchmarkCmd())
+	rootCmd.AddCommand(pingCmd())
 	rootCmd.AddCommand(verifyCmd())
 	rootCmd.AddCommand(uiCmd())
 	rootCmd.AddCommand(connectCmd())
 	rootCmd.AddCommand(disconnectCmd())
+	rootCmd.AddCommand(restoreCmd())
 	rootCmd.AddCommand(connectionStatusCmd())
+	rootCmd.AddCommand(routesCmd())
 	rootCmd.AddCommand(sshCmd())
 	rootCmd.AddCommand(networkPeersCmd())
 	rootCmd.AddCommand(versionCmd())
@@ -74,13 +142,54 @@ Use --node to connect to a remote node.`,
 	rootCmd.AddCommand(lifecycleCmd())
 	rootCmd.AddCommand(handshakeCmd())
 	rootCmd.AddCommand(handshakesCmd())
+	rootCmd.AddCommand(selftestCmd())
+	rootCmd.AddCommand(qrCmd())
 	rootCmd.AddCommand(diagnoseCmd())
+	rootCmd.AddCommand(exportCmd())
+	rootCmd.AddCommand(retentionCmd())
+	rootCmd.AddCommand(alertCmd())
+	rootCmd.AddCommand(configCmd())
+	rootCmd.AddCommand(nodeCmd())
+
+	explainControlErrors(rootCmd)
 
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
 	}
 }
 
+// explainControlErrors wraps the RunE of cmd and its subcommands so node
+// errors with a well-known cause are reported with what to do about them.
+func explainControlErrors(cmd *cobra.Command) {
+	if run := cmd.RunE; run != nil {
+		cmd.RunE = func(cmd *cobra.Command, args []string) error {
+			return explainControlError(run(cmd, args))
+		}
+	}
+	for _, sub := range cmd.Commands() {
+		explainControlErrors(sub)
+	}
+}
+
+// explainControlError replaces a node error whose code has a usual fix with
+// a message saying what to do. Other errors are returned unchanged.
+func explainControlError(err error) error {
+	var ctlErr *protocol.ControlError
+	if !errors.As(err, &ctlErr) {
+		return err
+	}
+
+	switch ctlErr.Code {
+	case protocol.ErrCodeNotConnected:
+		return fmt.Errorf("VPN tunnel is not active, run `vpn connect` first")
+	case protocol.ErrCodePeerNotFound:
+		return fmt.Errorf("%s (see `vpn peers` or `vpn node ls`)", ctlErr.Message)
+	case protocol.ErrCodeStorageUnavailable:
+		return fmt.Errorf("the node is running without storage, so logs, metrics and history are unavailable (see the \"failed to init storage\" warning in vpn-node's output)")
+	}
+	return err
+}
+
 func statusCmd() *cobra.Command {
 	return &cobra.Command{
 		Use:   "status",
@@ -107,19 +216,51 @@ Node Status
   Peers:      %d
   Traffic In: %s
   Traffic Out:%s
+  Loss:       %.1f%%
 `, status.NodeName, status.Version, status.UptimeStr,
 				status.VPNAddress, status.PeerCount,
-				formatBytes(status.BytesIn), formatBytes(status.BytesOut))
+				formatBytes(status.BytesIn), formatBytes(status.BytesOut),
+				status.PacketLoss)
+			if status.MTU > 0 {
+				fmt.Printf("  MTU:        %d\n", status.MTU)
+			}
 
 			return nil
 		},
 	}
 }
 
-func peersCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "peers",
-		Short: "List connected peers",
+// whoamiResult is the 'vpn whoami --json' output.
+type whoamiResult struct {
+	NodeName          string `json:"node_name"`
+	Version           string `json:"version"`
+	Mode              string `json:"mode"` // server, client
+	VPNAddress        string `json:"vpn_address"`
+	PublicIP          string `json:"public_ip,omitempty"`
+	Server            string `json:"server,omitempty"` // Address the client connects to
+	Connected         bool   `json:"connected"`
+	RouteAll          bool   `json:"route_all"`
+	KillSwitch        bool   `json:"kill_switch"`
+	KillSwitchEngaged bool   `json:"kill_switch_engaged"`
+}
+
+func whoamiCmd() *cobra.Command {
+	var outputJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "whoami",
+		Short: "Show this node's identity and effective routing",
+		Long: `Show who this node is on the VPN: name, VPN IP, public IP, server or
+client mode, the server it connects to, and whether all traffic is routed
+through the VPN (and the kill switch state).
+
+A lighter 'vpn diagnose' focused on identity rather than connectivity checks.
+The public IP is looked up from this machine through the current route,
+so with route-all enabled it is the VPN server's.
+
+Examples:
+  vpn whoami
+  vpn whoami --json`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			client, err := cli.NewClient(nodeAddr)
 			if err != nil {
@@ -127,42 +268,97 @@ func peersCmd() *cobra.Command {
 			}
 			defer client.Close()
 
-			result, err := client.Peers()
+			status, err := client.Status()
 			if err != nil {
 				return err
 			}
+			conn, err := client.ConnectionStatus()
+			if err != nil {
+				return err
+			}
+			publicIP, _ := diagnose.PublicIP()
+
+			result := whoamiResult{
+				NodeName:          status.NodeName,
+				Version:           status.Version,
+				Mode:              "client",
+				VPNAddress:        status.VPNAddress,
+				PublicIP:          publicIP,
+				Server:            status.ConnectTo,
+				Connected:         conn.Connected,
+				RouteAll:          conn.RouteAll,
+				KillSwitch:        conn.KillSwitch,
+				KillSwitchEngaged: conn.KillSwitchEngaged,
+			}
+			if status.ServerMode {
+				result.Mode = "server"
+			}
 
-			if len(result.Peers) == 0 {
-				fmt.Println("No peers connected.")
+			if outputJSON {
+				output, err := json.MarshalIndent(result, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(output))
 				return nil
 			}
 
-			fmt.Println("\nConnected Peers")
-			fmt.Println("───────────────────────────────────────────────────────")
-			fmt.Printf("%-15s %-15s %-18s %s\n", "NAME", "VPN IP", "PUBLIC IP", "CONNECTED")
-			fmt.Println("───────────────────────────────────────────────────────")
+			onOff := func(b bool) string {
+				if b {
+					return colorGreen + "on" + colorReset
+				}
+				return colorGray + "off" + colorReset
+			}
 
-			for _, p := range result.Peers {
-				fmt.Printf("%-15s %-15s %-18s %s\n",
-					p.Name, p.VPNAddress, p.PublicIP,
-					p.Connected.Format("2006-01-02 15:04"))
+			fmt.Println()
+			fmt.Printf("  Name:        %s (%s)\n", result.NodeName, result.Version)
+			fmt.Printf("  Mode:        %s\n", result.Mode)
+			fmt.Printf("  VPN IP:      %s\n", result.VPNAddress)
+			if result.PublicIP != "" {
+				fmt.Printf("  Public IP:   %s\n", result.PublicIP)
+			} else {
+				fmt.Printf("  Public IP:   %sunknown%s\n", colorGray, colorReset)
 			}
+			if !status.ServerMode {
+				state := colorGreen + "connected" + colorReset
+				if !result.Connected {
+					state = colorRed + "disconnected" + colorReset
+				}
+				fmt.Printf("  Server:      %s (%s)\n", result.Server, state)
+				fmt.Printf("  Route-all:   %s\n", onOff(result.RouteAll))
+				killSwitch := onOff(result.KillSwitch)
+				if result.KillSwitchEngaged {
+					killSwitch += colorRed + " (blocking traffic)" + colorReset
+				}
+				fmt.Printf("  Kill switch: %s\n", killSwitch)
+			}
+			fmt.Println()
 
 			return nil
 		},
 	}
+
+	cmd.Flags().BoolVar(&outputJSON, "json", false, "Output as JSON")
+
+	return cmd
 }
 
-func updateCmd() *cobra.Command {
-	var all, rolling bool
+func peersCmd() *cobra.Command {
+	var watch bool
 
 	cmd := &cobra.Command{
-		Use:   "update",
-		Short: "Update node(s)",
-		Long: `Update triggers a git pull and restart on the node.
+		Use:   "peers",
+		Short: "List connected peers",
+		Long: `List the peers connected to the node.
+
+With --watch the node streams the network's peer list instead, and a line
+is printed as each peer connects (+, green) or disconnects (-, red), like
+'kubectl get pods -w'. On a terminal a "(N peers connected)" summary is
+kept up to date below the events. Press Ctrl-C to stop.
 
-Use --all to update all nodes in the network.
-Use --rolling with --all to update nodes one at a time.`,
+Examples:
+  vpn peers
+  vpn --node 10.8.0.1:9001 peers --watch`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			client, err := cli.NewClient(nodeAddr)
 			if err != nil {
@@ -170,57 +366,141 @@ Use --rolling with --all to update nodes one at a time.`,
 			}
 			defer client.Close()
 
-			result, err := client.Update(all, rolling)
+			if watch {
+				return watchPeers(client)
+			}
+
+			result, err := client.Peers()
 			if err != nil {
 				return err
 			}
 
-			if result.Success {
-				fmt.Println("Update successful!")
-				fmt.Printf("Updated nodes: %v\n", result.Updated)
+			if len(result.Peers) == 0 {
+				fmt.Println("No peers connected.")
+				return nil
+			}
+
+			// Rate limits (older nodes don't support peer_rates)
+			rates := make(map[string]protocol.PeerRate)
+			if rateResult, err := client.PeerRates(); err == nil {
+				for _, r := range rateResult.Rates {
+					rates[r.VPNAddress] = r
+				}
+			}
+
+			fmt.Println("\nConnected Peers")
+			fmt.Println("──────────────────────────────────────────────────────────────────")
+			if len(rates) > 0 {
+				fmt.Printf("%-15s %-15s %-18s %-10s %-16s %s\n", "NAME", "VPN IP", "PUBLIC IP", "LATENCY", "RATE (MBPS)", "CONNECTED")
 			} else {
-				fmt.Println("Update failed:")
-				for _, e := range result.Errors {
-					fmt.Printf("  - %s\n", e)
+				fmt.Printf("%-15s %-15s %-18s %-10s %s\n", "NAME", "VPN IP", "PUBLIC IP", "LATENCY", "CONNECTED")
+			}
+			fmt.Println("──────────────────────────────────────────────────────────────────")
+
+			for _, p := range result.Peers {
+				latency := p.Latency
+				if latency == "" {
+					latency = "-"
+				}
+				if len(rates) == 0 {
+					fmt.Printf("%-15s %-15s %-18s %-10s %s\n",
+						p.Name, p.VPNAddress, p.PublicIP, latency,
+						p.Connected.Format("2006-01-02 15:04"))
+					continue
 				}
+
+				rate := "-"
+				if r, ok := rates[p.VPNAddress]; ok {
+					rate = fmt.Sprintf("%.1f / %g", r.CurrentMbps, r.LimitMbps)
+				}
+				fmt.Printf("%-15s %-15s %-18s %-10s %-16s %s\n",
+					p.Name, p.VPNAddress, p.PublicIP, latency, rate,
+					p.Connected.Format("2006-01-02 15:04"))
 			}
 
 			return nil
 		},
 	}
 
-	cmd.Flags().BoolVar(&all, "all", false, "Update all nodes in the network")
-	cmd.Flags().BoolVar(&rolling, "rolling", false, "Update nodes one at a time (requires --all)")
+	cmd.Flags().BoolVarP(&watch, "watch", "w", false, "Print peers as they connect and disconnect")
 
 	return cmd
 }
 
-func logsCmd() *cobra.Command {
-	var earliest, latest, search string
-	var levels, components []string
-	var limit int
+// watchPeers prints a timestamped line for every peer that appears in (+)
+// or leaves (-) the streamed peer list. On a terminal the last line is a
+// running summary, redrawn in place with ANSI cursor-up codes.
+func watchPeers(client *cli.Client) error {
+	tty := term.IsTerminal(int(os.Stdout.Fd()))
+	known := make(map[string]protocol.PeerListEntry)
+	summaryShown := false
+
+	return client.WatchPeers(func(result *protocol.PeersStreamResult) error {
+		now := time.Now().Format("15:04:05")
+		var lines []string
+
+		current := make(map[string]protocol.PeerListEntry, len(result.Peers))
+		for _, p := range result.Peers {
+			current[p.VPNAddress] = p
+			if _, ok := known[p.VPNAddress]; !ok {
+				lines = append(lines, fmt.Sprintf("%s %s+ %-15s %-15s %s%s",
+					now, colorGreen, p.VPNAddress, p.Name, p.OS, colorReset))
+			}
+		}
+		var removed []protocol.PeerListEntry
+		for addr, p := range known {
+			if _, ok := current[addr]; !ok {
+				removed = append(removed, p)
+			}
+		}
+		sort.Slice(removed, func(i, j int) bool { return removed[i].VPNAddress < removed[j].VPNAddress })
+		for _, p := range removed {
+			lines = append(lines, fmt.Sprintf("%s %s- %-15s %-15s %s%s",
+				now, colorRed, p.VPNAddress, p.Name, p.OS, colorReset))
+		}
+		known = current
 
-	cmd := &cobra.Command{
-		Use:   "logs",
-		Short: "Query logs (Splunk-like time syntax)",
-		Long: `Query logs with Splunk-like time range syntax.
+		if !tty {
+			for _, line := range lines {
+				fmt.Println(line)
+			}
+			return nil
+		}
+		if len(lines) == 0 && summaryShown {
+			return nil
+		}
 
-Time range examples:
-  -1h        1 hour ago
-  -30m       30 minutes ago
-  -7d        7 days ago
-  -1h@h      1 hour ago, snapped to hour boundary
-  @d         Beginning of today
-  now        Current time
-  2024-01-15 Specific date
+		// Replace the previous summary line with the new events
+		if summaryShown {
+			fmt.Print("\033[1A\033[2K\r")
+		}
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+		// The list leads with the server itself
+		connected := len(result.Peers) - 1
+		if connected < 0 {
+			connected = 0
+		}
+		fmt.Printf("%s(%d peers connected)%s\n", colorGray, connected, colorReset)
+		summaryShown = true
+		return nil
+	})
+}
 
-Usage examples:
-  vpn logs                           # Last 15 minutes
-  vpn logs --earliest=-1h            # Last hour
-  vpn logs --earliest=-24h --latest=-1h  # 24h to 1h ago
-  vpn logs --level=ERROR             # Only errors
-  vpn logs --search="connection"     # Search in message
-  vpn logs --component=conn,tun      # Filter by component`,
+func removePeerCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "remove-peer <vpn-ip>",
+		Short: "Force-disconnect a stale peer (server only)",
+		Long: `Force-disconnect a peer from the server by its VPN IP.
+
+Use this when a client was killed uncleanly and still shows up in
+'vpn peers'. The connection is closed and the updated peer list is
+sent to all clients. The client keeps its VPN IP if it reconnects.
+
+Examples:
+  vpn --node 10.8.0.1:9001 remove-peer 10.8.0.7`,
+		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			client, err := cli.NewClient(nodeAddr)
 			if err != nil {
@@ -228,86 +508,91 @@ Usage examples:
 			}
 			defer client.Close()
 
-			params := protocol.LogsParams{
-				Earliest:   earliest,
-				Latest:     latest,
-				Levels:     levels,
-				Components: components,
-				Search:     search,
-				Limit:      limit,
-			}
-
-			result, err := client.Logs(params)
+			result, err := client.RemovePeer(args[0])
 			if err != nil {
 				return err
 			}
 
-			if len(result.Entries) == 0 {
-				fmt.Println("No logs found for the specified time range.")
-				return nil
-			}
+			fmt.Printf("%s✓%s Removed peer %s (%d peers remaining)\n",
+				colorGreen, colorReset, args[0], len(result.Peers))
+			return nil
+		},
+	}
+}
 
-			fmt.Printf("\nLogs (%d of %d)\n", len(result.Entries), result.TotalCount)
-			fmt.Println("────────────────────────────────────────────────────────────────────")
+func peerCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "peer",
+		Short: "Manage individual peers (server only)",
+	}
+	cmd.AddCommand(peerLimitCmd())
+	return cmd
+}
+
+func peerLimitCmd() *cobra.Command {
+	var peer string
+	var mbps float64
+
+	cmd := &cobra.Command{
+		Use:   "limit",
+		Short: "Set a peer's bandwidth limit at runtime",
+		Long: `Cap the bandwidth of one peer, in each direction, without restarting
+the server. Packets over the limit are dropped. --mbps=0 removes the peer's
+own limit; it then falls back to vpn-node --peer-bandwidth-limit, if set.
+Limits set here last until the server restarts.
+
+Examples:
+  vpn --node 10.8.0.1:9001 peer limit --peer=10.8.0.3 --mbps=5
+  vpn --node 10.8.0.1:9001 peer limit --peer=10.8.0.3 --mbps=0`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if peer == "" {
+				return fmt.Errorf("--peer is required")
+			}
+			if !cmd.Flags().Changed("mbps") {
+				return fmt.Errorf("--mbps is required")
+			}
 
-			for _, e := range result.Entries {
-				levelColor := getLevelColor(e.Level)
-				fmt.Printf("%s %s[%-5s]%s [%s] %s\n",
-					e.Timestamp[:19], levelColor, e.Level, colorReset,
-					e.Component, e.Message)
+			client, err := cli.NewClient(nodeAddr)
+			if err != nil {
+				return err
 			}
+			defer client.Close()
 
-			if result.HasMore {
-				fmt.Printf("\n... %d more entries (use --limit to see more)\n", result.TotalCount-int64(len(result.Entries)))
+			result, err := client.SetPeerLimit(peer, mbps)
+			if err != nil {
+				return err
 			}
 
+			if mbps > 0 {
+				fmt.Printf("%s✓%s %s limited to %g Mbps\n", colorGreen, colorReset, peer, mbps)
+			} else if result.DefaultMbps > 0 {
+				fmt.Printf("%s✓%s %s limit removed (default %g Mbps applies)\n", colorGreen, colorReset, peer, result.DefaultMbps)
+			} else {
+				fmt.Printf("%s✓%s %s limit removed\n", colorGreen, colorReset, peer)
+			}
 			return nil
 		},
 	}
 
-	cmd.Flags().StringVar(&earliest, "earliest", "-15m", "Start time (Splunk syntax: -1h, -30m, @d)")
-	cmd.Flags().StringVar(&latest, "latest", "now", "End time (Splunk syntax)")
-	cmd.Flags().StringSliceVar(&levels, "level", nil, "Filter by level (DEBUG, INFO, WARN, ERROR)")
-	cmd.Flags().StringSliceVar(&components, "component", nil, "Filter by component (conn, tun, node)")
-	cmd.Flags().StringVar(&search, "search", "", "Search text in message")
-	cmd.Flags().IntVar(&limit, "limit", 100, "Max entries to return")
+	cmd.Flags().StringVar(&peer, "peer", "", "VPN IP of the peer")
+	cmd.Flags().Float64Var(&mbps, "mbps", 0, "Limit in Mbps (0 = remove the peer's own limit)")
 
 	return cmd
 }
 
-func statsCmd() *cobra.Command {
-	var earliest, latest, granularity, format string
-	var metrics []string
+func updateCmd() *cobra.Command {
+	var all, rolling, dryRun bool
 
 	cmd := &cobra.Command{
-		Use:   "stats",
-		Short: "Query metrics (Splunk-like time syntax)",
-		Long: `Query metrics with Splunk-like time range syntax.
-
-Available metrics:
-  vpn.bytes_sent, vpn.bytes_recv       Traffic counters
-  vpn.packets_sent, vpn.packets_recv   Packet counters
-  vpn.active_peers                     Connected peers
-  vpn.uptime_seconds                   Node uptime
-  bandwidth.tx_current_bps             Current TX bandwidth
-  bandwidth.rx_current_bps             Current RX bandwidth
-
-Granularity:
-  raw   High resolution (1 second)
-  1m    1-minute aggregates
-  1h    1-hour aggregates
-  auto  Auto-select based on time range
-
-Output formats:
-  text  Human-readable output (default)
-  json  JSON output with all data points (for UI/programmatic use)
+		Use:   "update",
+		Short: "Update node(s)",
+		Long: `Update pulls and rebuilds the node, restarting it if needed.
 
-Usage examples:
-  vpn stats                            # Last 5 minutes, all metrics
-  vpn stats --earliest=-1h             # Last hour
-  vpn stats --metric=bandwidth.tx_current_bps,bandwidth.rx_current_bps
-  vpn stats --granularity=1m           # Force 1-minute aggregation
-  vpn stats --format=json              # JSON output for UI consumption`,
+Use --all (against the server) to also tell every connected peer to update.
+Use --rolling with --all to update peers one at a time, waiting for each to
+be heard from again before the next; the rollout stops at the first failure.
+Use --dry-run to see what would change (files, rebuilds, restart)
+without updating anything.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			client, err := cli.NewClient(nodeAddr)
 			if err != nil {
@@ -315,340 +600,373 @@ Usage examples:
 			}
 			defer client.Close()
 
-			params := protocol.StatsParams{
-				Earliest:    earliest,
-				Latest:      latest,
-				Metrics:     metrics,
-				Granularity: granularity,
+			if dryRun {
+				result, err := client.UpdatePlan()
+				if err != nil {
+					return err
+				}
+				printUpdatePlan(result.Plan)
+				return nil
+			}
+
+			if rolling {
+				fmt.Println("Updating nodes one at a time, this can take a few minutes...")
 			}
 
-			result, err := client.Stats(params)
+			result, err := client.Update(all, rolling)
 			if err != nil {
 				return err
 			}
 
-			// JSON output for programmatic use
-			if format == "json" {
-				output, err := json.MarshalIndent(result, "", "  ")
-				if err != nil {
-					return err
+			if result.Success {
+				fmt.Println("Update successful!")
+			} else {
+				fmt.Println("Update failed:")
+				for _, e := range result.Errors {
+					fmt.Printf("  - %s\n", e)
 				}
-				fmt.Println(string(output))
-				return nil
+			}
+			if len(result.Updated) > 0 {
+				fmt.Printf("Updated nodes: %v\n", result.Updated)
 			}
 
-			// Print summary (latest values)
-			fmt.Println("\nCurrent Metrics")
-			fmt.Println("────────────────────────────────────────")
+			return nil
+		},
+	}
 
-			for name, value := range result.Summary {
-				displayName := strings.TrimPrefix(name, "vpn.")
-				displayName = strings.TrimPrefix(displayName, "bandwidth.")
+	cmd.Flags().BoolVar(&all, "all", false, "Update all nodes in the network")
+	cmd.Flags().BoolVar(&rolling, "rolling", false, "Update nodes one at a time (requires --all)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would change without updating")
 
-				// Format value based on metric type
-				var formatted string
-				if strings.Contains(name, "bytes") {
-					formatted = formatBytes(uint64(value))
-				} else if strings.Contains(name, "bps") {
-					formatted = formatBandwidth(value)
-				} else if strings.Contains(name, "uptime") {
-					formatted = formatUptime(value)
-				} else {
-					formatted = fmt.Sprintf("%.0f", value)
-				}
+	return cmd
+}
+
+func rollbackCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "rollback",
+		Short: "Revert the node to the version before its last deploy",
+		Long: `Rollback undoes the last deploy that changed the node: it resets the
+checkout to the commit the node ran before, rebuilds vpn-node and vpn,
+and restarts the node. The rollback is recorded as a ROLLBACK lifecycle
+event.
+
+Only one step is kept: after a rollback, the next deploy has to happen
+before rolling back again. Nothing is done if no previous version was
+recorded.
 
-				fmt.Printf("  %-20s %s\n", displayName+":", formatted)
+Examples:
+  vpn rollback
+  vpn --node 10.8.0.1:9001 rollback`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := cli.NewClient(nodeAddr)
+			if err != nil {
+				return err
 			}
+			defer client.Close()
 
-			// Print storage info
-			if len(result.StorageInfo) > 0 {
-				fmt.Println("\nStorage")
-				fmt.Println("────────────────────────────────────────")
-				if dbSize, ok := result.StorageInfo["db_size_mb"]; ok {
-					fmt.Printf("  %-20s %.2f MB\n", "database:", dbSize)
-				}
-				if logCount, ok := result.StorageInfo["log_count"]; ok {
-					fmt.Printf("  %-20s %.0f entries\n", "logs:", logCount)
-				}
-				if rawCount, ok := result.StorageInfo["metrics_raw_count"]; ok {
-					fmt.Printf("  %-20s %.0f points\n", "metrics (raw):", rawCount)
-				}
-			}
+			fmt.Println("Rolling back and rebuilding, this can take a minute...")
 
-			// Print time series if available
-			if len(result.Series) > 0 {
-				fmt.Printf("\nTime Series (%d series)\n", len(result.Series))
-				fmt.Println("────────────────────────────────────────")
-				for _, s := range result.Series {
-					if len(s.Points) > 0 {
-						first := s.Points[0]
-						last := s.Points[len(s.Points)-1]
-						fmt.Printf("  %s: %d points (%s to %s)\n",
-							s.Name, len(s.Points),
-							first.Timestamp[:19], last.Timestamp[:19])
-					}
-				}
+			result, err := client.Rollback()
+			if err != nil {
+				return err
 			}
 
+			fmt.Printf("%s✓%s Rolled back %s -> %s, node is restarting\n",
+				colorGreen, colorReset, shortCommit(result.From), shortCommit(result.To))
 			return nil
 		},
 	}
-
-	cmd.Flags().StringVar(&earliest, "earliest", "-5m", "Start time (Splunk syntax: -1h, -30m, @d)")
-	cmd.Flags().StringVar(&latest, "latest", "now", "End time (Splunk syntax)")
-	cmd.Flags().StringSliceVar(&metrics, "metric", nil, "Specific metrics to query")
-	cmd.Flags().StringVar(&granularity, "granularity", "auto", "Data granularity (raw, 1m, 1h, auto)")
-	cmd.Flags().StringVar(&format, "format", "text", "Output format (text, json)")
-
-	return cmd
 }
 
-func uiCmd() *cobra.Command {
-	var listenAddr string
-	var templatesDir string
+func deployCmd() *cobra.Command {
+	var all, rolling bool
+	var ref, branch string
+	var port int
 
 	cmd := &cobra.Command{
-		Use:   "ui",
-		Short: "Start web dashboard",
-		Long: `Start a web dashboard for monitoring VPN nodes.
+		Use:   "deploy",
+		Short: "Trigger the deploy webhook on node(s)",
+		Long: `Deploy POSTs to the deploy webhook (/deploy on the WebSocket port) of the
+node given by --node, as GitHub Actions does after a push. The node pulls,
+rebuilds and restarts in the background.
 
-The dashboard provides:
-  - Home: Welcome page
-  - Overview: Node status, peers, bandwidth charts
-  - Observability: Splunk-like log viewer and metrics charts
+Use --all to trigger the webhook on every peer in the network instead,
+at most 3 at a time. Use --rolling with --all to deploy one node at a time,
+waiting for each to report a finished deploy on /health before the next;
+the rollout stops at the first failure.
 
-Node selection priority:
-  1. If --node is explicitly set, use that node
-  2. Try local node at 127.0.0.1:9001 first (preferred for client perspective)
-  3. Fall back to VPN server at 95.217.238.72:9001 if local isn't available
+Nodes that do not run the webhook (it is started in server mode) are
+reported as unreachable.
 
 Examples:
-  vpn ui                           # Start on http://localhost:8080
-  vpn ui --listen :3000            # Start on port 3000
-  vpn --node 10.8.0.1:9001 ui      # Connect to remote node
-  vpn ui --templates ./internal/ui/templates  # Hot reload from disk`,
+  vpn deploy
+  vpn --node 10.8.0.1:9001 deploy --branch main
+  vpn deploy --all
+  vpn deploy --all --rolling`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			// Determine which node to connect to
-			targetNode := nodeAddr
+			if rolling && !all {
+				return fmt.Errorf("--rolling requires --all")
+			}
+			req := node.DeployRequest{Ref: ref, Branch: branch}
 
-			// Only do smart detection if --node is still the default value
-			// (the flag is on the root command, so we check value equality)
-			if nodeAddr == "127.0.0.1:9001" {
-				// Try local node first (127.0.0.1:9001)
-				localAddr := "127.0.0.1:9001"
-				client, err := cli.NewClient(localAddr)
-				if err == nil {
-					// Local node is available - use it for client perspective
-					client.Close()
-					targetNode = localAddr
-					fmt.Printf("  Using local node at %s (client perspective)\n", localAddr)
-				} else {
-					// Local not available, try the server
-					serverAddr := "95.217.238.72:9001"
-					client, err = cli.NewClient(serverAddr)
-					if err == nil {
-						client.Close()
-						targetNode = serverAddr
-						fmt.Printf("  No local node found, using server at %s\n", serverAddr)
-					} else {
-						// Neither available - use default and let it fail with proper error
-						fmt.Printf("  Warning: No VPN node found locally or on server\n")
-					}
+			if !all {
+				host, _, err := net.SplitHostPort(nodeAddr)
+				if err != nil {
+					return fmt.Errorf("invalid --node address %q: %w", nodeAddr, err)
 				}
+				resp, _, err := postDeploy(host, port, req)
+				if err != nil {
+					return err
+				}
+				if !resp.Success {
+					return fmt.Errorf("%s: %s", resp.Node, resp.Message)
+				}
+				fmt.Printf("%s✓%s %s: %s\n", colorGreen, colorReset, resp.Node, resp.Message)
+				return nil
 			}
 
-			server := ui.NewServer(targetNode, listenAddr)
-			if templatesDir != "" {
-				server.SetTemplatesDir(templatesDir)
-				fmt.Printf("  Hot reload enabled: %s\n", templatesDir)
+			client, err := cli.NewClient(nodeAddr)
+			if err != nil {
+				return err
 			}
-			return server.Start()
-		},
-	}
-
-	cmd.Flags().StringVar(&listenAddr, "listen", "localhost:8080", "Address to listen on")
-	cmd.Flags().StringVar(&templatesDir, "templates", "", "Load templates from disk for hot reload (dev mode)")
-
-	return cmd
-}
-
-func verifyCmd() *cobra.Command {
-	var expectedIP string
-
-	cmd := &cobra.Command{
-		Use:   "verify",
-		Short: "Verify VPN routing is working",
-		Long: `Verify that traffic is being routed through the VPN.
-
-This command checks your public IP address and compares it to the expected
-VPN server IP to confirm traffic is being routed correctly.
-
-Examples:
-  vpn verify                                # Check current public IP
-  vpn verify --expected=95.217.238.72       # Verify routing to specific IP`,
-		RunE: func(cmd *cobra.Command, args []string) error {
-			fmt.Println("\nVPN Routing Verification")
-			fmt.Println("────────────────────────────────────────")
-
-			// Get current public IP
-			publicIP, err := getPublicIP()
+			peers, err := client.NetworkPeers()
+			client.Close()
 			if err != nil {
-				fmt.Printf("  Public IP:     %s (error: %v)\n", colorRed+"FAILED"+colorReset, err)
+				return err
+			}
+			if len(peers.Peers) == 0 {
+				fmt.Println("No peers in network.")
 				return nil
 			}
 
-			fmt.Printf("  Public IP:     %s\n", publicIP)
-
-			// Check node status for VPN IP
-			client, err := cli.NewClient(nodeAddr)
-			if err != nil {
-				fmt.Printf("  Node Status:   %s (cannot connect to %s)\n", colorYellow+"UNKNOWN"+colorReset, nodeAddr)
+			var results []deployResult
+			if rolling {
+				fmt.Println("Deploying nodes one at a time, this can take a few minutes...")
+				results = rollingDeploy(peers.Peers, port, req)
 			} else {
-				defer client.Close()
-				status, err := client.Status()
-				if err != nil {
-					fmt.Printf("  Node Status:   %s (error: %v)\n", colorYellow+"UNKNOWN"+colorReset, err)
-				} else {
-					fmt.Printf("  VPN IP:        %s\n", status.VPNAddress)
-					fmt.Printf("  Node:          %s (v%s)\n", status.NodeName, status.Version)
-					fmt.Printf("  Uptime:        %s\n", status.UptimeStr)
-				}
+				results = parallelDeploy(peers.Peers, port, req)
 			}
 
-			// Verify against expected IP
-			if expectedIP != "" {
-				fmt.Println()
-				if publicIP == expectedIP {
-					fmt.Printf("  Routing:       %s\n", colorGreen+"VERIFIED"+colorReset)
-					fmt.Printf("                 Traffic is routed through %s\n", expectedIP)
-				} else {
-					fmt.Printf("  Routing:       %s\n", colorRed+"NOT ROUTED"+colorReset)
-					fmt.Printf("                 Expected: %s\n", expectedIP)
-					fmt.Printf("                 Actual:   %s\n", publicIP)
-					fmt.Println()
-					fmt.Println("  Possible causes:")
-					fmt.Println("    - VPN not connected with --route-all flag")
-					fmt.Println("    - NAT not configured on VPN server")
-					fmt.Println("    - Routing table not updated correctly")
+			fmt.Printf("\n%-20s %-12s %s\n", "NAME", "STATUS", "MESSAGE")
+			fmt.Println("────────────────────────────────────────────────────────────")
+			failed := 0
+			for _, r := range results {
+				color := colorGreen
+				switch r.Status {
+				case "skipped":
+					color = colorYellow
+				case "failed", "busy", "unreachable":
+					color = colorRed
+					failed++
 				}
-			} else {
-				fmt.Println()
-				fmt.Println("  Hint: Use --expected=<IP> to verify against VPN server IP")
+				fmt.Printf("%-20s %s%-12s%s %s\n", r.Name, color, r.Status, colorReset, r.Message)
 			}
+			fmt.Println()
 
+			if failed > 0 {
+				return fmt.Errorf("deploy failed on %d of %d nodes", failed, len(results))
+			}
 			return nil
 		},
 	}
 
-	cmd.Flags().StringVar(&expectedIP, "expected", "", "Expected public IP (VPN server IP)")
+	cmd.Flags().BoolVar(&all, "all", false, "Deploy every node in the network")
+	cmd.Flags().BoolVar(&rolling, "rolling", false, "Deploy nodes one at a time, waiting for each to be healthy (requires --all)")
+	cmd.Flags().StringVar(&ref, "ref", "", "Git SHA being deployed (informational)")
+	cmd.Flags().StringVar(&branch, "branch", "", "Branch being deployed (informational)")
+	cmd.Flags().IntVar(&port, "port", 9000, "Port of the deploy webhook (vpn-node --listen-ws)")
 
 	return cmd
 }
 
-// getPublicIP fetches the current public IP address.
-func getPublicIP() (string, error) {
-	client := &http.Client{Timeout: 10 * time.Second}
+// deployResult is one row of the 'vpn deploy --all' table.
+type deployResult struct {
+	Name    string
+	Status  string // accepted, deployed, busy, failed, unreachable, skipped
+	Message string
+}
 
-	// Try multiple services in case one is down
-	services := []string{
-		"https://api.ipify.org",
-		"https://ifconfig.me/ip",
-		"https://icanhazip.com",
+// maxParallelDeploys limits how many nodes 'vpn deploy --all' deploys at once.
+const maxParallelDeploys = 3
+
+// deployHealthTimeout is how long 'vpn deploy --rolling' waits for a node
+// to finish its deploy (including a restart).
+const deployHealthTimeout = 5 * time.Minute
+
+// parallelDeploy triggers the deploy webhook of every peer, at most
+// maxParallelDeploys at a time, and returns the results in peer order.
+func parallelDeploy(peers []protocol.PeerListEntry, port int, req node.DeployRequest) []deployResult {
+	results := make([]deployResult, len(peers))
+	sem := make(chan struct{}, maxParallelDeploys)
+	var wg sync.WaitGroup
+
+	for i, p := range peers {
+		wg.Add(1)
+		go func(i int, p protocol.PeerListEntry) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			results[i] = deployResultFor(p.Name, p.VPNAddress, port, req)
+		}(i, p)
 	}
+	wg.Wait()
 
-	for _, url := range services {
-		resp, err := client.Get(url)
-		if err != nil {
-			continue
-		}
-		defer resp.Body.Close()
+	return results
+}
 
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			continue
+// rollingDeploy deploys peers one at a time, waiting for each to report a
+// finished deploy on /health. Peers after the first failure are skipped.
+func rollingDeploy(peers []protocol.PeerListEntry, port int, req node.DeployRequest) []deployResult {
+	results := make([]deployResult, 0, len(peers))
+
+	for i, p := range peers {
+		fmt.Printf("  [%d/%d] %s...\n", i+1, len(peers), p.Name)
+		r := deployResultFor(p.Name, p.VPNAddress, port, req)
+		if r.Status == "accepted" {
+			if err := waitDeployed(p.VPNAddress, port); err != nil {
+				r.Status, r.Message = "failed", err.Error()
+			} else {
+				r.Status, r.Message = "deployed", "Deploy finished, node healthy"
+			}
 		}
-
-		ip := strings.TrimSpace(string(body))
-		if ip != "" {
-			return ip, nil
+		results = append(results, r)
+
+		if r.Status != "deployed" {
+			for _, rest := range peers[i+1:] {
+				results = append(results, deployResult{
+					Name:    rest.Name,
+					Status:  "skipped",
+					Message: "Rollout stopped at " + p.Name,
+				})
+			}
+			break
 		}
 	}
 
-	return "", fmt.Errorf("could not determine public IP")
+	return results
 }
 
-// ANSI color codes for log levels
-const (
-	colorReset  = "\033[0m"
-	colorRed    = "\033[31m"
-	colorGreen  = "\033[32m"
-	colorYellow = "\033[33m"
-	colorBlue   = "\033[34m"
-	colorCyan   = "\033[36m"
-	colorGray   = "\033[90m"
-)
-
-func getLevelColor(level string) string {
-	switch level {
-	case "ERROR":
-		return colorRed
-	case "WARN":
-		return colorYellow
-	case "INFO":
-		return colorBlue
-	case "DEBUG":
-		return colorGray
-	default:
-		return ""
+// deployResultFor triggers the deploy webhook of one node.
+func deployResultFor(name, host string, port int, req node.DeployRequest) deployResult {
+	resp, code, err := postDeploy(host, port, req)
+	switch {
+	case err != nil:
+		return deployResult{Name: name, Status: "unreachable", Message: err.Error()}
+	case code == http.StatusConflict:
+		return deployResult{Name: name, Status: "busy", Message: resp.Message}
+	case !resp.Success:
+		return deployResult{Name: name, Status: "failed", Message: resp.Message}
 	}
+	return deployResult{Name: name, Status: "accepted", Message: resp.Message}
 }
 
-func formatBytes(b uint64) string {
-	const unit = 1024
-	if b < unit {
-		return fmt.Sprintf("%d B", b)
+// postDeploy POSTs req to the deploy webhook on host:port and returns its
+// response and HTTP status code.
+func postDeploy(host string, port int, req node.DeployRequest) (*node.DeployResponse, int, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, 0, err
 	}
-	div, exp := uint64(unit), 0
-	for n := b / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
+
+	url := fmt.Sprintf("http://%s/deploy", net.JoinHostPort(host, strconv.Itoa(port)))
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
 	}
-	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "KMGTPE"[exp])
-}
+	defer resp.Body.Close()
 
-func formatBandwidth(bps float64) string {
-	if bps < 1024 {
-		return fmt.Sprintf("%.0f B/s", bps)
+	var result node.DeployResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result); err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("%s returned %s", url, resp.Status)
 	}
-	if bps < 1024*1024 {
-		return fmt.Sprintf("%.1f KB/s", bps/1024)
+	if result.Node == "" {
+		result.Node = host
 	}
-	return fmt.Sprintf("%.1f MB/s", bps/(1024*1024))
+	return &result, resp.StatusCode, nil
 }
 
-func formatUptime(seconds float64) string {
-	if seconds < 60 {
-		return fmt.Sprintf("%.0fs", seconds)
+// nodeHealth is the JSON served on a node's /health endpoint.
+type nodeHealth struct {
+	Status      string `json:"status"`
+	Node        string `json:"node"`
+	Uptime      string `json:"uptime"`
+	Version     string `json:"version"`
+	Deploying   bool   `json:"deploying"`
+	DeployError string `json:"deploy_error"`
+}
+
+// healthResponse is an answer of a node's /health endpoint.
+type healthResponse struct {
+	Code   int
+	Body   json.RawMessage
+	Health nodeHealth
+}
+
+// fetchNodeHealth GETs /health on host:port. Any HTTP answer with a JSON
+// body is returned, whatever its status code.
+func fetchNodeHealth(host string, port int, timeout time.Duration) (*healthResponse, error) {
+	url := fmt.Sprintf("http://%s/health", net.JoinHostPort(host, strconv.Itoa(port)))
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
 	}
-	if seconds < 3600 {
-		return fmt.Sprintf("%.0fm", seconds/60)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
 	}
-	if seconds < 86400 {
-		return fmt.Sprintf("%.1fh", seconds/3600)
+	result := &healthResponse{Code: resp.StatusCode, Body: body}
+	if err := json.Unmarshal(body, &result.Health); err != nil {
+		return nil, fmt.Errorf("%s returned %s, not health JSON", url, resp.Status)
 	}
-	return fmt.Sprintf("%.1fd", seconds/86400)
+	return result, nil
 }
 
-func connectCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "connect",
-		Short: "Enable VPN routing (route all traffic through VPN)",
-		Long: `Enable routing all traffic through the VPN connection.
+// waitDeployed polls /health on host:port until the node no longer reports
+// a deploy in progress. Errors while it restarts are retried.
+func waitDeployed(host string, port int) error {
+	deadline := time.Now().Add(deployHealthTimeout)
 
-This command enables the --route-all mode at runtime, routing all
-internet traffic through the VPN server.
+	for time.Now().Before(deadline) {
+		time.Sleep(2 * time.Second)
 
-Note: The VPN node daemon must already be running in client mode.`,
+		resp, err := fetchNodeHealth(host, port, 5*time.Second)
+		if err != nil || resp.Code != http.StatusOK || resp.Health.Deploying {
+			continue
+		}
+
+		if resp.Health.DeployError != "" {
+			return fmt.Errorf("deploy failed: %s", resp.Health.DeployError)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("not healthy after %v", deployHealthTimeout)
+}
+
+func restartCmd() *cobra.Command {
+	var force, yes bool
+
+	cmd := &cobra.Command{
+		Use:   "restart",
+		Short: "Restart the node daemon in place",
+		Long: `Restart vpn-node, e.g. to pick up a config change. The node shuts down
+gracefully (routes are restored first) and re-executes its binary with the
+same arguments, keeping its PID, then the command waits for it to answer
+again.
+
+The tunnel drops briefly: on a server, clients reconnect on their own; on
+a client the VPN connection is lost until the node is back, so --force is
+required.
+
+Examples:
+  vpn restart
+  vpn --node 10.8.0.1:9001 restart --yes
+  vpn restart --force              # On a client`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			client, err := cli.NewClient(nodeAddr)
 			if err != nil {
@@ -656,7 +974,1162 @@ Note: The VPN node daemon must already be running in client mode.`,
 			}
 			defer client.Close()
 
-			result, err := client.Connect()
+			status, err := client.Status()
+			if err != nil {
+				return err
+			}
+			if !status.ServerMode && !force {
+				fmt.Printf("%s⚠%s %s is a client: restarting drops its VPN connection until the node is back.\n",
+					colorYellow, colorReset, status.NodeName)
+				return fmt.Errorf("pass --force to restart a client")
+			}
+
+			if !yes {
+				what := "Clients will reconnect once it is back."
+				if !status.ServerMode {
+					what = "The VPN connection drops meanwhile."
+				}
+				fmt.Printf("Restart %s? %s [y/N] ", status.NodeName, what)
+				answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+				answer = strings.ToLower(strings.TrimSpace(answer))
+				if answer != "y" && answer != "yes" {
+					fmt.Println("Aborted")
+					return nil
+				}
+			}
+
+			requested := time.Now()
+			result, err := client.Restart(force)
+			if err != nil {
+				return err
+			}
+			client.Close()
+			fmt.Printf("Restarting %s...\n", result.NodeName)
+
+			// Wait for the new process: it has been up for less time than
+			// has passed since the request
+			deadline := time.Now().Add(30 * time.Second)
+			time.Sleep(2 * time.Second)
+			for time.Now().Before(deadline) {
+				if c, err := cli.NewClient(nodeAddr); err == nil {
+					status, err := c.Status()
+					c.Close()
+					if err == nil && status.Uptime < time.Since(requested) {
+						fmt.Printf("%s✓%s %s restarted (version %s)\n", colorGreen, colorReset, status.NodeName, status.Version)
+						return nil
+					}
+				}
+				time.Sleep(time.Second)
+			}
+			fmt.Printf("%s⚠%s %s has not answered yet; check 'vpn status' or its service logs\n", colorYellow, colorReset, result.NodeName)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&force, "force", false, "Restart a client even though its VPN connection drops")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Do not ask for confirmation")
+
+	return cmd
+}
+
+// shortCommit abbreviates a commit hash.
+func shortCommit(sha string) string {
+	if len(sha) > 8 {
+		return sha[:8]
+	}
+	return sha
+}
+
+// printUpdatePlan prints the result of 'vpn update --dry-run'.
+func printUpdatePlan(plan *protocol.UpdatePlan) {
+	if plan == nil {
+		fmt.Println("Node did not return an update plan (older version?)")
+		return
+	}
+
+	fmt.Println("\nUpdate Plan (dry run, nothing changed)")
+	fmt.Println("────────────────────────────────────────")
+
+	if len(plan.ChangedFiles) == 0 {
+		fmt.Println("Already up to date with origin/main")
+		return
+	}
+
+	fmt.Printf("Changed files (%d):\n", len(plan.ChangedFiles))
+	for _, f := range plan.ChangedFiles {
+		fmt.Printf("  %s\n", f)
+	}
+
+	fmt.Println("\nVERSION changes:")
+	if len(plan.Versions) == 0 {
+		fmt.Println("  none")
+	}
+	for _, v := range plan.Versions {
+		fmt.Printf("  %-10s %s -> %s\n", v.Layer, v.From, v.To)
+	}
+
+	var rebuild []string
+	if plan.RebuildNode {
+		rebuild = append(rebuild, "vpn-node")
+	}
+	if plan.RebuildCLI {
+		rebuild = append(rebuild, "vpn")
+	}
+	fmt.Println()
+	if len(rebuild) == 0 {
+		fmt.Println("Rebuild: none")
+	} else {
+		fmt.Printf("Rebuild: %s\n", strings.Join(rebuild, ", "))
+	}
+
+	switch {
+	case plan.WillRestart:
+		fmt.Printf("Restart: %sYES - the VPN will be interrupted for all peers%s\n", colorYellow, colorReset)
+	case plan.RestartNode:
+		fmt.Println("Restart: required, but clients never restart automatically")
+	default:
+		fmt.Printf("Restart: %sno%s (hot update)\n", colorGreen, colorReset)
+	}
+}
+
+func logsCmd() *cobra.Command {
+	var earliest, latest, search, peer, format, dataDir string
+	var levels, components, fields []string
+	var limit int
+	var failOnErrors bool
+
+	cmd := &cobra.Command{
+		Use:   "logs",
+		Short: "Query logs (Splunk-like time syntax)",
+		Long: `Query logs with Splunk-like time range syntax.
+
+Time range examples:
+  -1h        1 hour ago
+  -30m       30 minutes ago
+  -7d        7 days ago
+  -1h@h      1 hour ago, snapped to hour boundary
+  @d         Beginning of today
+  now        Current time
+  2024-01-15 Specific date
+
+Usage examples:
+  vpn logs                           # Last 15 minutes
+  vpn logs --earliest=-1h            # Last hour
+  vpn logs --earliest=-24h --latest=-1h  # 24h to 1h ago
+  vpn logs --level=ERROR             # Only errors
+  vpn logs --search="connection"     # Search in message
+  vpn logs --search='error AND reconnect NOT timeout'
+  vpn logs --search='"connection lost" OR (tun AND route)'
+  vpn logs --component=conn,tun      # Filter by component
+  vpn logs --field=peer_ip:10.8.0.3  # Everything about one client (server)
+  vpn logs --field=peer_ip!=10.8.0.3 # Everything else
+  vpn logs --peer=10.8.0.3           # Logs of another node (name or VPN IP)
+  vpn logs --format=jsonl | jq .message
+  vpn logs --data-dir=/root/.vpn-node --level=ERROR  # Daemon not running
+
+Output formats:
+  text   Colored terminal output (default)
+  json   JSON array of entries
+  jsonl  One JSON object per line, no colors (for jq, grep, log shippers)
+
+With --fail-on-errors the command exits with code 1 if any returned entry
+is at level ERROR, for health checks in scripts and CI:
+  vpn logs --earliest=-5m --level=ERROR --fail-on-errors
+
+--field matches the structured fields of an entry (key:value or key=value
+for equal, key!=value for different) and may be repeated; all must match.
+The server tags everything about a client with its VPN address in peer_ip.
+
+--data-dir reads the node's database (vpn.db in its data directory,
+~/.vpn-node by default) instead of asking the daemon, so logs can be read
+after a crash or while the node is stopped. It cannot be combined with
+--node or --peer.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch format {
+			case "text", "json", "jsonl":
+			default:
+				return fmt.Errorf("invalid format %q (use text, json or jsonl)", format)
+			}
+			fieldFilters, err := parseFieldFilters(fields)
+			if err != nil {
+				return err
+			}
+
+			params := protocol.LogsParams{
+				Earliest:   earliest,
+				Latest:     latest,
+				Levels:     levels,
+				Components: components,
+				Search:     search,
+				Limit:      limit,
+				Fields:     fieldFilters,
+			}
+
+			var result *protocol.LogsResult
+			if dataDir != "" {
+				st, err := openLocalStore(cmd, dataDir, "peer")
+				if err != nil {
+					return err
+				}
+				defer st.Close()
+				result, err = node.QueryLogs(st, params)
+				if err != nil {
+					return localQueryError(err)
+				}
+			} else {
+				client, err := cli.NewClient(nodeAddr)
+				if err != nil {
+					return err
+				}
+				defer client.Close()
+
+				if peer != "" {
+					addr, err := resolvePeerAddress(client, peer)
+					if err != nil {
+						return err
+					}
+					result, err = client.RemoteLogs(addr, params)
+					if err != nil {
+						return err
+					}
+					if result.Warning == protocol.LogsWarningPeerUnreachable {
+						return fmt.Errorf("peer %s is unreachable (no answer on %s:9001 within %s)", addr, addr, cli.PeerDialTimeout)
+					}
+				} else {
+					result, err = client.Logs(params)
+					if err != nil {
+						return err
+					}
+				}
+			}
+
+			switch {
+			case format == "json":
+				entries := result.Entries
+				if entries == nil {
+					entries = []protocol.LogEntry{}
+				}
+				data, err := json.MarshalIndent(entries, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(data))
+			case format == "jsonl":
+				enc := json.NewEncoder(os.Stdout)
+				for _, e := range result.Entries {
+					if err := enc.Encode(e); err != nil {
+						return err
+					}
+				}
+			case len(result.Entries) == 0:
+				fmt.Println("No logs found for the specified time range.")
+			default:
+				fmt.Printf("\nLogs (%d of %d)\n", len(result.Entries), result.TotalCount)
+				fmt.Println("────────────────────────────────────────────────────────────────────")
+
+				for _, e := range result.Entries {
+					printLogEntry(e)
+				}
+
+				if result.HasMore {
+					fmt.Printf("\n... %d more entries (use --limit to see more)\n", result.TotalCount-int64(len(result.Entries)))
+				}
+			}
+
+			if failOnErrors {
+				errorCount := 0
+				for _, e := range result.Entries {
+					if strings.EqualFold(e.Level, "ERROR") {
+						errorCount++
+					}
+				}
+				if errorCount > 0 {
+					// Exit directly: returning an error would print usage
+					fmt.Fprintf(os.Stderr, "%d ERROR log entries found\n", errorCount)
+					os.Exit(1)
+				}
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&earliest, "earliest", "-15m", "Start time (Splunk syntax: -1h, -30m, @d)")
+	cmd.Flags().StringVar(&latest, "latest", "now", "End time (Splunk syntax)")
+	cmd.Flags().StringSliceVar(&levels, "level", nil, "Filter by level (DEBUG, INFO, WARN, ERROR)")
+	cmd.Flags().StringSliceVar(&components, "component", nil, "Filter by component (conn, tun, node)")
+	cmd.Flags().StringVar(&search, "search", "", "Search in message (AND, OR, NOT, \"phrases\", parentheses)")
+	cmd.Flags().StringArrayVar(&fields, "field", nil, "Filter by structured field: key:value or key!=value (repeatable)")
+	cmd.Flags().IntVar(&limit, "limit", 100, "Max entries to return")
+	cmd.Flags().StringVar(&peer, "peer", "", "Query another node's logs (name or VPN IP)")
+	cmd.Flags().StringVar(&format, "format", "text", "Output format: text, json, jsonl")
+	cmd.Flags().BoolVar(&failOnErrors, "fail-on-errors", false, "Exit with code 1 if any entry is at level ERROR")
+	cmd.Flags().StringVar(&dataDir, "data-dir", "", "Read the node's database in this directory instead of the daemon (e.g. ~/.vpn-node)")
+
+	return cmd
+}
+
+// openLocalStore opens the node database in dataDir for --data-dir, which
+// queries logs and metrics without the daemon. The flags in conflicts (and
+// --node) need a running node, so setting any of them is an error.
+func openLocalStore(cmd *cobra.Command, dataDir string, conflicts ...string) (*store.Store, error) {
+	for _, name := range append([]string{"node"}, conflicts...) {
+		if cmd.Flags().Changed(name) {
+			return nil, fmt.Errorf("--data-dir and --%s cannot be used together", name)
+		}
+	}
+
+	// store.New would create an empty database in a mistyped directory
+	if _, err := os.Stat(filepath.Join(dataDir, store.DatabaseFile)); err != nil {
+		return nil, fmt.Errorf("no node database in %s: %w", dataDir, err)
+	}
+
+	// The store logs its own startup, which is noise in command output
+	log.SetOutput(io.Discard)
+	return store.New(dataDir, store.Options{})
+}
+
+// localQueryError drops the "server error" prefix of a query that ran
+// against a local database.
+func localQueryError(err error) error {
+	var ce *protocol.ControlError
+	if errors.As(err, &ce) {
+		return errors.New(ce.Message)
+	}
+	return err
+}
+
+// parseFieldFilters parses --field values: key:value or key=value (equal)
+// and key!=value (different).
+func parseFieldFilters(values []string) ([]protocol.LogFieldFilter, error) {
+	var filters []protocol.LogFieldFilter
+	for _, v := range values {
+		var f protocol.LogFieldFilter
+		if key, value, ok := strings.Cut(v, "!="); ok {
+			f = protocol.LogFieldFilter{Key: key, Op: "!=", Value: value}
+		} else if i := strings.IndexAny(v, ":="); i >= 0 {
+			f = protocol.LogFieldFilter{Key: v[:i], Op: "=", Value: v[i+1:]}
+		}
+		f.Key = strings.TrimSpace(f.Key)
+		if f.Key == "" {
+			return nil, fmt.Errorf("invalid --field %q (use key:value or key!=value)", v)
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
+func printLogEntry(e protocol.LogEntry) {
+	levelColor := getLevelColor(e.Level)
+	message := e.Message
+	if e.Snippet != "" {
+		// FTS5 search: the node marks matches with « »
+		message = strings.NewReplacer("«", colorYellow, "»", colorReset).Replace(e.Snippet)
+	}
+	fmt.Printf("%s %s[%-5s]%s [%s] %s\n",
+		e.Timestamp[:19], levelColor, e.Level, colorReset,
+		e.Component, message)
+}
+
+func tailPeerCmd() *cobra.Command {
+	var search string
+	var levels, components []string
+	var lines int
+
+	cmd := &cobra.Command{
+		Use:   "tail-peer <name-or-ip>",
+		Short: "Stream a peer's logs live",
+		Long: `Print a peer's most recent log lines, then stream new ones as the peer
+writes them (like tail -f).
+
+The peer is looked up by name in the network peer list (or given by VPN
+IP) and its control socket is dialed directly over the VPN on port 9001.
+An unreachable peer fails after a few seconds. Press Ctrl-C to exit.
+
+Examples:
+  vpn tail-peer mac-mini
+  vpn tail-peer 10.8.0.3 --level=WARN,ERROR
+  vpn tail-peer server --component=conn --search='reconnect NOT timeout'`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := cli.NewClient(nodeAddr)
+			if err != nil {
+				return err
+			}
+			addr, err := resolvePeerAddress(client, args[0])
+			client.Close()
+			if err != nil {
+				return err
+			}
+
+			peer, err := cli.DialPeer(addr)
+			if err != nil {
+				return err
+			}
+			defer peer.Close()
+
+			fmt.Printf("Tailing logs of %s (Ctrl-C to exit)\n", addr)
+			fmt.Println("────────────────────────────────────────────────────────────────────")
+
+			params := protocol.LogsParams{
+				Earliest:   "-1h",
+				Levels:     levels,
+				Components: components,
+				Search:     search,
+				Limit:      lines,
+			}
+			return peer.FollowLogs(params, func(e *protocol.LogEntry) error {
+				printLogEntry(*e)
+				return nil
+			})
+		},
+	}
+
+	cmd.Flags().IntVarP(&lines, "lines", "n", 20, "Recent lines to show before streaming")
+	cmd.Flags().StringSliceVar(&levels, "level", nil, "Filter by level (DEBUG, INFO, WARN, ERROR)")
+	cmd.Flags().StringSliceVar(&components, "component", nil, "Filter by component (conn, tun, node)")
+	cmd.Flags().StringVar(&search, "search", "", "Search in message (AND, OR, NOT, \"phrases\", parentheses)")
+
+	return cmd
+}
+
+func statsCmd() *cobra.Command {
+	var earliest, latest, granularity, aggregation, groupBy, fill, format, compare, dataDir string
+	var metrics, alertThresholds, tagArgs []string
+	var alertLines []string
+	var alertExit int
+
+	cmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Query metrics (Splunk-like time syntax)",
+		Long: `Query metrics with Splunk-like time range syntax.
+
+Available metrics:
+  vpn.bytes_sent, vpn.bytes_recv       Traffic counters
+  vpn.packets_sent, vpn.packets_recv   Packet counters
+  vpn.active_peers                     Connected peers
+  vpn.uptime_seconds                   Node uptime
+  vpn.mtu_bytes                        TUN MTU (negotiated with the server)
+  bandwidth.tx_current_bps             Current TX bandwidth
+  bandwidth.rx_current_bps             Current RX bandwidth
+  bandwidth.tx_p95_bps                 P95 TX bandwidth (last 5 minutes)
+  bandwidth.rx_p95_bps                 P95 RX bandwidth (last 5 minutes)
+  peer.rtt_ms, peer.bandwidth_bps      Per peer, tagged peer=<name>
+
+Tags (--tag key=value, repeatable):
+  Each metric has one series per set of tags, e.g. one per peer; --tag
+  keeps only the points carrying every given tag.
+
+Granularity:
+  raw   High resolution (1 second)
+  1m    1-minute aggregates
+  1h    1-hour aggregates
+  auto  Auto-select based on time range: raw up to 1h, 1m up to 24h,
+        1h beyond, or a coarser table if the range starts before the
+        finer one's retention (so --earliest=-7d returns hourly points)
+
+Aggregation (--aggregation, optionally per --group-by bucket):
+  avg, sum, min, max, count
+  p95   95th percentile
+  Without --group-by the whole range becomes one point. Empty buckets are
+  left out unless --fill=zero or --fill=previous.
+
+Output formats:
+  text  Human-readable output (default)
+  json  JSON output with all data points (for UI/programmatic use)
+  csv   timestamp,metric,value rows, oldest first, for spreadsheets
+        (auto granularity reads the 1m/1h aggregates for long ranges;
+        tagged metrics are named like peer.rtt_ms{peer=mac-mini})
+
+Alert thresholds (--alert-threshold, repeatable, for monitoring scripts):
+  Compare a metric's current value to a number with >, <, >=, <=, ==
+  (the short names of 'vpn alert add' work too). When every threshold
+  holds, each is printed to stderr as a Nagios/Icinga plugin line and the
+  command exits 2; a metric without a value exits 3 (UNKNOWN).
+
+Usage examples:
+  vpn stats                            # Last 5 minutes, all metrics
+  vpn stats --earliest=-1h             # Last hour
+  vpn stats --metric=bandwidth.tx_current_bps,bandwidth.rx_current_bps
+  vpn stats --granularity=1m           # Force 1-minute aggregation
+  vpn stats --earliest=-1h --aggregation=p95 --group-by=5m
+  vpn stats --metric=peer.rtt_ms --tag=peer=mac-mini
+  vpn stats --format=json              # JSON output for UI consumption
+  vpn stats --earliest=-7d --metric=bandwidth.rx_current_bps --format=csv > rx.csv
+  vpn stats --compare 10.8.0.3         # Side by side with another node
+  vpn stats --alert-threshold='bandwidth.tx_current_bps>10485760'
+  vpn stats --alert-threshold='peers<1' --alert-threshold='loss>=5'
+  vpn stats --data-dir=/root/.vpn-node --earliest=-24h  # Daemon not running
+
+--data-dir reads the node's database (vpn.db in its data directory,
+~/.vpn-node by default) instead of asking the daemon, for post-mortem
+analysis while the node is stopped. It cannot be combined with --node,
+--compare or --alert-threshold.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var thresholds []alertThreshold
+			for _, expr := range alertThresholds {
+				metric, op, value, err := parseAlertCondition(expr)
+				if err != nil {
+					return fmt.Errorf("invalid --alert-threshold: %w", err)
+				}
+				thresholds = append(thresholds, alertThreshold{metric: metric, op: op, value: value})
+			}
+
+			tags, err := parseMetricTags(tagArgs)
+			if err != nil {
+				return err
+			}
+
+			switch format {
+			case "text", "json":
+			case "csv":
+				if compare != "" {
+					return fmt.Errorf("--compare does not support --format=csv")
+				}
+			default:
+				return fmt.Errorf("invalid format %q (use text, json or csv)", format)
+			}
+
+			params := protocol.StatsParams{
+				Earliest:    earliest,
+				Latest:      latest,
+				Metrics:     metrics,
+				Granularity: granularity,
+				Aggregation: aggregation,
+				GroupBy:     groupBy,
+				Fill:        fill,
+				Tags:        tags,
+			}
+			if format == "csv" {
+				params.Format = "csv"
+			}
+
+			var client *cli.Client
+			var result *protocol.StatsResult
+			if dataDir != "" {
+				st, err := openLocalStore(cmd, dataDir, "compare", "alert-threshold")
+				if err != nil {
+					return err
+				}
+				defer st.Close()
+				result, err = node.QueryStats(st, params)
+				if err != nil {
+					return localQueryError(err)
+				}
+			} else {
+				client, err = cli.NewClient(nodeAddr)
+				if err != nil {
+					return err
+				}
+				defer client.Close()
+
+				if len(thresholds) > 0 {
+					alertLines, alertExit, err = checkAlertThresholds(client, thresholds)
+					if err != nil {
+						return err
+					}
+				}
+
+				result, err = client.Stats(params)
+				if err != nil {
+					return err
+				}
+			}
+
+			if format == "csv" {
+				fmt.Print(result.CSV)
+				return nil
+			}
+
+			if compare != "" {
+				return compareStatsWith(client, compare, params, result, format)
+			}
+
+			// JSON output for programmatic use
+			if format == "json" {
+				output, err := json.MarshalIndent(result, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(output))
+				return nil
+			}
+
+			// Print summary (latest values)
+			fmt.Println("\nCurrent Metrics")
+			fmt.Println("────────────────────────────────────────")
+
+			for name, value := range result.Summary {
+				displayName := strings.TrimPrefix(name, "vpn.")
+				displayName = strings.TrimPrefix(displayName, "bandwidth.")
+
+				fmt.Printf("  %-20s %s\n", displayName+":", formatMetricValue(name, value))
+			}
+
+			// Print storage info
+			if len(result.StorageInfo) > 0 {
+				fmt.Println("\nStorage")
+				fmt.Println("────────────────────────────────────────")
+				if dbSize, ok := result.StorageInfo["db_size_mb"]; ok {
+					fmt.Printf("  %-20s %.2f MB\n", "database:", dbSize)
+				}
+				if logCount, ok := result.StorageInfo["log_count"]; ok {
+					fmt.Printf("  %-20s %.0f entries\n", "logs:", logCount)
+				}
+				if rawCount, ok := result.StorageInfo["metrics_raw_count"]; ok {
+					fmt.Printf("  %-20s %.0f points\n", "metrics (raw):", rawCount)
+				}
+			}
+
+			// Print time series if available
+			if len(result.Series) > 0 {
+				fmt.Printf("\nTime Series (%d series)\n", len(result.Series))
+				fmt.Println("────────────────────────────────────────")
+				for _, s := range result.Series {
+					if len(s.Points) > 0 {
+						first := s.Points[0]
+						last := s.Points[len(s.Points)-1]
+						fmt.Printf("  %s: %d points (%s to %s)\n",
+							seriesLabel(s), len(s.Points),
+							first.Timestamp[:19], last.Timestamp[:19])
+						if aggregation != "" {
+							for _, p := range s.Points {
+								fmt.Printf("    %s  %s %s\n", p.Timestamp[:19], aggregation, formatMetricValue(s.Name, p.Value))
+							}
+						}
+					}
+				}
+			}
+
+			return nil
+		},
+		// Report thresholds after the regular output, with the plugin exit code
+		PostRun: func(cmd *cobra.Command, args []string) {
+			for _, line := range alertLines {
+				fmt.Fprintln(os.Stderr, line)
+			}
+			if alertExit != 0 {
+				os.Exit(alertExit)
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&earliest, "earliest", "-5m", "Start time (Splunk syntax: -1h, -30m, @d)")
+	cmd.Flags().StringVar(&latest, "latest", "now", "End time (Splunk syntax)")
+	cmd.Flags().StringSliceVar(&metrics, "metric", nil, "Specific metrics to query")
+	cmd.Flags().StringVar(&granularity, "granularity", "auto", "Data granularity (raw, 1m, 1h, auto)")
+	cmd.Flags().StringVar(&aggregation, "aggregation", "", "Aggregate points (avg, sum, min, max, count, p95)")
+	cmd.Flags().StringVar(&groupBy, "group-by", "", "Aggregation bucket size (e.g. 1m, 5m, 1h)")
+	cmd.Flags().StringVar(&fill, "fill", "null", "Empty buckets with --group-by (null, zero, previous)")
+	cmd.Flags().StringVar(&format, "format", "text", "Output format (text, json, csv)")
+	cmd.Flags().StringVar(&compare, "compare", "", "Other node to compare with (name, VPN IP, or host:port)")
+	cmd.Flags().StringArrayVar(&tagArgs, "tag", nil, "Only points with this tag, key=value, e.g. peer=mac-mini (repeatable, ANDed)")
+	cmd.Flags().StringVar(&dataDir, "data-dir", "", "Read the node's database in this directory instead of the daemon (e.g. ~/.vpn-node)")
+	cmd.Flags().StringArrayVar(&alertThresholds, "alert-threshold", nil, "Exit 2 if metric<op>value holds for the current value, e.g. bandwidth.tx_current_bps>10485760 (repeatable, ANDed)")
+
+	return cmd
+}
+
+// parseMetricTags parses --tag values ("peer=mac-mini") into the tags a
+// series must carry.
+func parseMetricTags(args []string) (map[string]string, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	tags := make(map[string]string, len(args))
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid --tag %q (use key=value)", arg)
+		}
+		tags[key] = value
+	}
+	return tags, nil
+}
+
+// seriesLabel names a series in text output: the metric, followed by its
+// tags as {key=value,...} when it has any.
+func seriesLabel(s protocol.MetricSeries) string {
+	var tags map[string]string
+	json.Unmarshal([]byte(s.Tags), &tags)
+	if len(tags) == 0 {
+		return s.Name
+	}
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return s.Name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// alertThreshold is a parsed --alert-threshold expression.
+type alertThreshold struct {
+	metric string
+	op     string // lt, le, gt, ge, eq, ne
+	value  float64
+}
+
+// holds reports whether v satisfies the threshold.
+func (t alertThreshold) holds(v float64) bool {
+	switch t.op {
+	case "lt":
+		return v < t.value
+	case "le":
+		return v <= t.value
+	case "gt":
+		return v > t.value
+	case "ge":
+		return v >= t.value
+	case "eq":
+		return v == t.value
+	case "ne":
+		return v != t.value
+	}
+	return false
+}
+
+// checkAlertThresholds compares the latest value of each threshold's metric
+// with it, Nagios plugin style: when all hold it returns a CRITICAL line per
+// threshold and exit code 2; a metric without a value gives UNKNOWN and 3.
+func checkAlertThresholds(client *cli.Client, thresholds []alertThreshold) ([]string, int, error) {
+	var names []string
+	for _, t := range thresholds {
+		names = append(names, t.metric)
+	}
+	result, err := client.Stats(protocol.StatsParams{Metrics: names})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var lines []string
+	for _, t := range thresholds {
+		value, ok := result.Summary[t.metric]
+		if !ok {
+			return []string{fmt.Sprintf("UNKNOWN - %s has no value", t.metric)}, 3, nil
+		}
+		if !t.holds(value) {
+			return nil, 0, nil
+		}
+		lines = append(lines, fmt.Sprintf("CRITICAL - %s=%s %s %s", t.metric,
+			strconv.FormatFloat(value, 'f', -1, 64), alertOpSymbols[t.op], strconv.FormatFloat(t.value, 'f', -1, 64)))
+	}
+	return lines, 2, nil
+}
+
+// formatMetricValue formats a metric value based on its name.
+func formatMetricValue(name string, value float64) string {
+	switch {
+	case strings.Contains(name, "bytes"):
+		return formatBytes(uint64(value))
+	case strings.Contains(name, "bps"):
+		return formatBandwidth(value)
+	case strings.Contains(name, "uptime"):
+		return formatUptime(value)
+	default:
+		return fmt.Sprintf("%.0f", value)
+	}
+}
+
+// StatsComparison is the output of 'vpn stats --compare': the latest value
+// of every metric on two nodes.
+type StatsComparison struct {
+	Primary string             `json:"primary"`
+	Other   string             `json:"other"`
+	Metrics []MetricComparison `json:"metrics"`
+}
+
+// MetricComparison is one metric in a StatsComparison.
+type MetricComparison struct {
+	Name    string  `json:"name"`
+	Primary float64 `json:"primary"`
+	Other   float64 `json:"other"`
+	Delta   float64 `json:"delta"` // Other - Primary
+}
+
+// newStatsComparison merges the summaries of two stats results. Metrics
+// missing on one node count as 0 there.
+func newStatsComparison(primary, other string, a, b *protocol.StatsResult) *StatsComparison {
+	names := make(map[string]bool)
+	for name := range a.Summary {
+		names[name] = true
+	}
+	for name := range b.Summary {
+		names[name] = true
+	}
+
+	c := &StatsComparison{Primary: primary, Other: other}
+	for name := range names {
+		c.Metrics = append(c.Metrics, MetricComparison{
+			Name:    name,
+			Primary: a.Summary[name],
+			Other:   b.Summary[name],
+			Delta:   b.Summary[name] - a.Summary[name],
+		})
+	}
+	sort.Slice(c.Metrics, func(i, j int) bool { return c.Metrics[i].Name < c.Metrics[j].Name })
+	return c
+}
+
+// compareStatsWith fetches the same stats from another node and prints
+// both side by side.
+func compareStatsWith(client *cli.Client, other string, params protocol.StatsParams, primary *protocol.StatsResult, format string) error {
+	var otherClient *cli.Client
+	var err error
+	if _, _, splitErr := net.SplitHostPort(other); splitErr == nil {
+		otherClient, err = cli.NewClient(other)
+	} else {
+		addr, resolveErr := resolvePeerAddress(client, other)
+		if resolveErr != nil {
+			return resolveErr
+		}
+		otherClient, err = cli.DialPeer(addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer otherClient.Close()
+
+	otherResult, err := otherClient.Stats(params)
+	if err != nil {
+		return fmt.Errorf("%s: %w", other, err)
+	}
+
+	comparison := newStatsComparison(nodeAddr, other, primary, otherResult)
+
+	if format == "json" {
+		output, err := json.MarshalIndent(comparison, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(output))
+		return nil
+	}
+
+	fmt.Println("\nMetrics Comparison")
+	fmt.Println("──────────────────────────────────────────────────────────────────────────")
+	fmt.Printf("  %-26s %-16s %-16s %s\n", "METRIC", comparison.Primary, comparison.Other, "DELTA")
+	fmt.Println("──────────────────────────────────────────────────────────────────────────")
+	for _, m := range comparison.Metrics {
+		a := fmt.Sprintf("%-16s", formatMetricValue(m.Name, m.Primary))
+		b := fmt.Sprintf("%-16s", formatMetricValue(m.Name, m.Other))
+
+		// Highlight the node with more traffic
+		if strings.Contains(m.Name, "bytes") || strings.Contains(m.Name, "bps") || strings.Contains(m.Name, "packets") {
+			if m.Primary > m.Other {
+				a = colorYellow + a + colorReset
+			} else if m.Other > m.Primary {
+				b = colorYellow + b + colorReset
+			}
+		}
+
+		sign := "+"
+		if m.Delta < 0 {
+			sign = "-"
+		}
+		delta := sign + formatMetricValue(m.Name, math.Abs(m.Delta))
+		if m.Delta == 0 {
+			delta = "="
+		}
+		fmt.Printf("  %-26s %s %s %s\n", m.Name, a, b, delta)
+	}
+	fmt.Println()
+
+	return nil
+}
+
+func uiCmd() *cobra.Command {
+	var listenAddr string
+	var templatesDir string
+	var auth, authTOTP, tlsCert, tlsKey string
+	var mockPeers, refreshMs int
+	var vncPassword string
+
+	cmd := &cobra.Command{
+		Use:   "ui",
+		Short: "Start web dashboard",
+		Long: `Start a web dashboard for monitoring VPN nodes.
+
+The dashboard provides:
+  - Home: Welcome page
+  - Overview: Node status, peers, bandwidth charts
+  - Observability: Splunk-like log viewer and metrics charts
+
+Node selection priority:
+  1. If --node is explicitly set, use that node
+  2. Try local node at 127.0.0.1:9001 first (preferred for client perspective)
+  3. Fall back to VPN server at 95.217.238.72:9001 if local isn't available
+
+With --mock-peers=N no node is contacted: the dashboard shows a synthetic
+server with N peers (random hostnames, OSes and cities) and sine-wave
+bandwidth, for demos without a live VPN. Connect/disconnect are disabled.
+
+Screen sharing reads its password from --vnc-password, else VNC_PASSWORD
+in the repository's .env file, else the VNC_PASSWORD environment variable.
+
+With --auth-totp the dashboard asks for a 6-digit code from an authenticator
+app (Google Authenticator, 1Password, ...) holding the same base32 secret;
+the API, SSH terminal, uploads and VNC config need the session it sets
+(12 hours). Generate a secret with: head -c 20 /dev/urandom | base32
+
+--refresh-interval sets how often the page polls the node, in milliseconds
+(the connection status is checked half as often): 500 while debugging, 30000
+to spare a busy node. The footer's "Pause refresh" button stops polling
+until pressed again.
+
+Examples:
+  vpn ui                           # Start on http://localhost:8080
+  vpn ui --listen :3000            # Start on port 3000
+  vpn --node 10.8.0.1:9001 ui      # Connect to remote node
+  vpn ui --templates ./internal/ui/templates  # Hot reload from disk
+  vpn ui --listen :8080 --auth=family:secret --tls-cert=ui.crt --tls-key=ui.key
+  vpn ui --listen :8080 --auth-totp=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP --tls-cert=ui.crt --tls-key=ui.key
+  vpn ui --mock-peers=5            # Demo mode with 5 synthetic peers
+  vpn ui --refresh-interval=30000  # Poll every 30s`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var authUser, authPass string
+			if auth != "" {
+				var ok bool
+				authUser, authPass, ok = strings.Cut(auth, ":")
+				if !ok || authUser == "" || authPass == "" {
+					return fmt.Errorf("--auth must be user:password")
+				}
+			}
+			if (tlsCert == "") != (tlsKey == "") {
+				return fmt.Errorf("--tls-cert and --tls-key must be given together")
+			}
+			if mockPeers < 0 || mockPeers > 250 {
+				return fmt.Errorf("--mock-peers must be between 1 and 250")
+			}
+			if refreshMs < 100 {
+				return fmt.Errorf("--refresh-interval must be at least 100 (milliseconds)")
+			}
+
+			// Determine which node to connect to
+			targetNode := nodeAddr
+
+			// Only do smart detection if --node is still the default value
+			// (the flag is on the root command, so we check value equality)
+			if nodeAddr == "127.0.0.1:9001" && mockPeers == 0 {
+				// Try local node first (127.0.0.1:9001)
+				localAddr := "127.0.0.1:9001"
+				client, err := cli.NewClient(localAddr)
+				if err == nil {
+					// Local node is available - use it for client perspective
+					client.Close()
+					targetNode = localAddr
+					fmt.Printf("  Using local node at %s (client perspective)\n", localAddr)
+				} else {
+					// Local not available, try the server
+					serverAddr := "95.217.238.72:9001"
+					client, err = cli.NewClient(serverAddr)
+					if err == nil {
+						client.Close()
+						targetNode = serverAddr
+						fmt.Printf("  No local node found, using server at %s\n", serverAddr)
+					} else {
+						// Neither available - use default and let it fail with proper error
+						fmt.Printf("  Warning: No VPN node found locally or on server\n")
+					}
+				}
+			}
+
+			server := ui.NewServer(targetNode, listenAddr)
+			if templatesDir != "" {
+				server.SetTemplatesDir(templatesDir)
+				fmt.Printf("  Hot reload enabled: %s\n", templatesDir)
+			}
+			if authUser != "" {
+				server.SetBasicAuth(authUser, authPass)
+			}
+			if authTOTP != "" {
+				if err := server.SetTOTP(authTOTP); err != nil {
+					return fmt.Errorf("--auth-totp: %w", err)
+				}
+			}
+			if tlsCert != "" {
+				server.SetTLS(tlsCert, tlsKey)
+			}
+			if mockPeers > 0 {
+				server.SetMockPeers(mockPeers)
+			}
+			if root := findSourceRoot(); root != "" {
+				server.SetEnvFile(filepath.Join(root, ".env"))
+			}
+			if vncPassword != "" {
+				server.SetVNCPassword(vncPassword)
+			}
+			server.SetRefreshInterval(time.Duration(refreshMs) * time.Millisecond)
+			return server.Start()
+		},
+	}
+
+	cmd.Flags().StringVar(&listenAddr, "listen", "localhost:8080", "Address to listen on")
+	cmd.Flags().StringVar(&templatesDir, "templates", "", "Load templates from disk for hot reload (dev mode)")
+	cmd.Flags().StringVar(&auth, "auth", "", "Require HTTP basic auth as user:password")
+	cmd.Flags().StringVar(&authTOTP, "auth-totp", "", "Require a TOTP login for this base32 secret")
+	cmd.Flags().StringVar(&tlsCert, "tls-cert", "", "TLS certificate file (serve HTTPS)")
+	cmd.Flags().StringVar(&tlsKey, "tls-key", "", "TLS private key file (serve HTTPS)")
+	cmd.Flags().IntVar(&refreshMs, "refresh-interval", int(ui.DefaultRefreshInterval/time.Millisecond), "Dashboard polling interval in milliseconds")
+	cmd.Flags().IntVar(&mockPeers, "mock-peers", 0, "Demo mode: serve N synthetic peers instead of querying a node")
+	cmd.Flags().StringVar(&vncPassword, "vnc-password", "", "Screen sharing password (default: VNC_PASSWORD from the repository's .env, then the environment)")
+
+	return cmd
+}
+
+func verifyCmd() *cobra.Command {
+	var expectedIP string
+
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Verify VPN routing is working",
+		Long: `Verify that traffic is being routed through the VPN.
+
+This command checks your public IP address and compares it to the expected
+VPN server IP to confirm traffic is being routed correctly.
+
+Examples:
+  vpn verify                                # Check current public IP
+  vpn verify --expected=95.217.238.72       # Verify routing to specific IP`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fmt.Println("\nVPN Routing Verification")
+			fmt.Println("────────────────────────────────────────")
+
+			// Get current public IP
+			publicIP, err := diagnose.PublicIP()
+			if err != nil {
+				fmt.Printf("  Public IP:     %s (error: %v)\n", colorRed+"FAILED"+colorReset, err)
+				return nil
+			}
+
+			fmt.Printf("  Public IP:     %s\n", publicIP)
+
+			// Check node status for VPN IP
+			client, err := cli.NewClient(nodeAddr)
+			if err != nil {
+				fmt.Printf("  Node Status:   %s (cannot connect to %s)\n", colorYellow+"UNKNOWN"+colorReset, nodeAddr)
+			} else {
+				defer client.Close()
+				status, err := client.Status()
+				if err != nil {
+					fmt.Printf("  Node Status:   %s (error: %v)\n", colorYellow+"UNKNOWN"+colorReset, err)
+				} else {
+					fmt.Printf("  VPN IP:        %s\n", status.VPNAddress)
+					fmt.Printf("  Node:          %s (v%s)\n", status.NodeName, status.Version)
+					fmt.Printf("  Uptime:        %s\n", status.UptimeStr)
+				}
+			}
+
+			// Verify against expected IP
+			if expectedIP != "" {
+				fmt.Println()
+				if publicIP == expectedIP {
+					fmt.Printf("  Routing:       %s\n", colorGreen+"VERIFIED"+colorReset)
+					fmt.Printf("                 Traffic is routed through %s\n", expectedIP)
+				} else {
+					fmt.Printf("  Routing:       %s\n", colorRed+"NOT ROUTED"+colorReset)
+					fmt.Printf("                 Expected: %s\n", expectedIP)
+					fmt.Printf("                 Actual:   %s\n", publicIP)
+					fmt.Println()
+					fmt.Println("  Possible causes:")
+					fmt.Println("    - VPN not connected with --route-all flag")
+					fmt.Println("    - NAT not configured on VPN server")
+					fmt.Println("    - Routing table not updated correctly")
+				}
+			} else {
+				fmt.Println()
+				fmt.Println("  Hint: Use --expected=<IP> to verify against VPN server IP")
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&expectedIP, "expected", "", "Expected public IP (VPN server IP)")
+
+	return cmd
+}
+
+// ANSI color codes for log levels
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorBlue   = "\033[34m"
+	colorCyan   = "\033[36m"
+	colorGray   = "\033[90m"
+)
+
+func getLevelColor(level string) string {
+	switch level {
+	case "ERROR":
+		return colorRed
+	case "WARN":
+		return colorYellow
+	case "INFO":
+		return colorBlue
+	case "DEBUG":
+		return colorGray
+	default:
+		return ""
+	}
+}
+
+func formatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
+func formatBandwidth(bps float64) string {
+	if bps < 1024 {
+		return fmt.Sprintf("%.0f B/s", bps)
+	}
+	if bps < 1024*1024 {
+		return fmt.Sprintf("%.1f KB/s", bps/1024)
+	}
+	return fmt.Sprintf("%.1f MB/s", bps/(1024*1024))
+}
+
+func formatUptime(seconds float64) string {
+	if seconds < 60 {
+		return fmt.Sprintf("%.0fs", seconds)
+	}
+	if seconds < 3600 {
+		return fmt.Sprintf("%.0fm", seconds/60)
+	}
+	if seconds < 86400 {
+		return fmt.Sprintf("%.1fh", seconds/3600)
+	}
+	return fmt.Sprintf("%.1fd", seconds/86400)
+}
+
+func connectCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "connect",
+		Short: "Enable VPN routing (route all traffic through VPN)",
+		Long: `Enable routing all traffic through the VPN connection.
+
+This command enables the --route-all mode at runtime, routing all
+internet traffic through the VPN server.
+
+Note: The VPN node daemon must already be running in client mode.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := cli.NewClient(nodeAddr)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			result, err := client.Connect()
 			if err != nil {
 				return err
 			}
@@ -664,33 +2137,2481 @@ Note: The VPN node daemon must already be running in client mode.`,
 			if result.Success {
 				fmt.Printf("%s VPN Connected%s\n", colorGreen, colorReset)
 				fmt.Println("────────────────────────────────────────")
-				fmt.Println(result.Message)
-				if result.Status != nil {
-					fmt.Printf("  VPN IP:    %s\n", result.Status.VPNAddress)
-					fmt.Printf("  Server:    %s\n", result.Status.ServerAddr)
-					fmt.Printf("  Route All: %v\n", result.Status.RouteAll)
+				fmt.Println(result.Message)
+				if result.Status != nil {
+					fmt.Printf("  VPN IP:    %s\n", result.Status.VPNAddress)
+					fmt.Printf("  Server:    %s\n", result.Status.ServerAddr)
+					fmt.Printf("  Route All: %v\n", result.Status.RouteAll)
+				}
+			} else {
+				fmt.Printf("%s Connection Failed%s\n", colorRed, colorReset)
+				fmt.Println("────────────────────────────────────────")
+				fmt.Println(result.Message)
+			}
+
+			return nil
+		},
+	}
+}
+
+func disconnectCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "disconnect",
+		Short: "Disable VPN routing (restore direct traffic)",
+		Long: `Disable routing all traffic through the VPN connection.
+
+This command disables the --route-all mode, restoring direct internet
+connectivity while keeping the VPN tunnel active.
+
+Note: This does NOT disconnect the VPN tunnel itself, only the route-all mode.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := cli.NewClient(nodeAddr)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			result, err := client.Disconnect()
+			if err != nil {
+				return err
+			}
+
+			if result.Success {
+				fmt.Printf("%s VPN Disconnected%s\n", colorYellow, colorReset)
+				fmt.Println("────────────────────────────────────────")
+				fmt.Println(result.Message)
+				if result.Status != nil {
+					fmt.Printf("  VPN IP:    %s\n", result.Status.VPNAddress)
+					fmt.Printf("  Server:    %s\n", result.Status.ServerAddr)
+					fmt.Printf("  Route All: %v\n", result.Status.RouteAll)
+				}
+			} else {
+				fmt.Printf("%s Disconnect Failed%s\n", colorRed, colorReset)
+				fmt.Println("────────────────────────────────────────")
+				fmt.Println(result.Message)
+			}
+
+			return nil
+		},
+	}
+}
+
+func restoreCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "restore",
+		Short: "Repair routing after vpn-node died with route-all on",
+		Long: `Restore internet access when vpn-node crashed (or was killed) while
+routing all traffic, leaving the default route pointing at a dead TUN device.
+
+This does not need a running node: it reads the routing table directly,
+deletes default routes through the VPN and re-adds the default gateway
+saved when route-all was enabled (~/.vpn-node/pre-vpn-gateway). On macOS
+it also resets DNS and IPv6 on Wi-Fi to automatic. A kill switch left
+blocking traffic (vpn-node --kill-switch) is removed as well.
+
+If the node is running, prefer 'vpn disconnect'.
+
+Examples:
+  sudo vpn restore`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if os.Getuid() != 0 {
+				return fmt.Errorf("changing routes requires root: run 'sudo vpn restore'")
+			}
+
+			result, err := tunnel.RestoreDefaultRoute()
+			if result != nil && result.KillSwitch {
+				fmt.Printf("%s✓%s Removed kill switch firewall rule\n", colorGreen, colorReset)
+			}
+			if result != nil {
+				for _, route := range result.Removed {
+					fmt.Printf("%s✓%s Removed VPN default route via %s (%s)\n",
+						colorGreen, colorReset, route.Gateway, route.Interface)
+				}
+			}
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case result.Gateway != "":
+				fmt.Printf("%s✓%s Default route restored via %s\n", colorGreen, colorReset, result.Gateway)
+			case len(result.Removed) == 0:
+				fmt.Println("Routing is fine: the default route does not go through the VPN.")
+			default:
+				fmt.Println("A direct default route is already present.")
+			}
+			return nil
+		},
+	}
+}
+
+func connectionStatusCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:     "connection-status",
+		Aliases: []string{"conn-status", "cs"},
+		Short:   "Show VPN connection status",
+		Long:    `Show the current VPN connection status including whether route-all is enabled.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := cli.NewClient(nodeAddr)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			status, err := client.ConnectionStatus()
+			if err != nil {
+				return err
+			}
+
+			fmt.Println("\nVPN Connection Status")
+			fmt.Println("────────────────────────────────────────")
+
+			if status.Connected {
+				fmt.Printf("  Status:    %sConnected%s\n", colorGreen, colorReset)
+			} else {
+				fmt.Printf("  Status:    %sDisconnected%s\n", colorRed, colorReset)
+			}
+
+			fmt.Printf("  VPN IP:    %s\n", status.VPNAddress)
+			fmt.Printf("  Server:    %s\n", status.ServerAddr)
+
+			if status.RouteAll {
+				fmt.Printf("  Route All: %sEnabled%s (all traffic through VPN)\n", colorGreen, colorReset)
+			} else {
+				fmt.Printf("  Route All: %sDisabled%s (direct traffic)\n", colorYellow, colorReset)
+			}
+
+			if len(status.RouteSubnets) > 0 {
+				fmt.Printf("  Subnets:   %s (split tunnel)\n", strings.Join(status.RouteSubnets, ", "))
+			}
+
+			switch {
+			case status.KillSwitchEngaged:
+				fmt.Printf("  On drop:   %sKill switch engaged%s (non-VPN traffic blocked until reconnect or 'vpn disconnect')\n", colorRed, colorReset)
+			case status.KillSwitch:
+				fmt.Printf("  On drop:   Kill switch (block non-VPN traffic)\n")
+			default:
+				fmt.Printf("  On drop:   Restore direct routing\n")
+			}
+
+			if status.ConnectedAt != "" {
+				fmt.Printf("  Since:     %s\n", status.ConnectedAt)
+			}
+
+			return nil
+		},
+	}
+}
+
+func routesCmd() *cobra.Command {
+	var outputJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "routes",
+		Short: "Show the OS routes the VPN installed",
+		Long: `Show the routes the node added to the OS routing table and check each one
+against the live table (netstat -rn on macOS, ip route on Linux).
+
+Purposes:
+  default  Default route through the VPN gateway (route-all)
+  server   VPN server pinned to the original gateway (prevents a routing loop)
+  subnet   Split-tunnel CIDR through the VPN (vpn-node --route-subnet)
+
+A MISSING route was added by the node but is gone from the table, e.g. after
+a partial restore or another program changed routing. The check runs on the
+node, so with --node it reflects that node's routing table.
+
+Examples:
+  vpn routes
+  vpn routes --json`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := cli.NewClient(nodeAddr)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			result, err := client.Routes()
+			if err != nil {
+				return err
+			}
+
+			if outputJSON {
+				output, err := json.MarshalIndent(result, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(output))
+				return nil
+			}
+
+			if len(result.Routes) == 0 {
+				fmt.Println("No routes installed (route-all and split tunneling are off)")
+				return nil
+			}
+
+			fmt.Printf("\n%-20s %-16s %-10s %-8s  %s\n", "DESTINATION", "GATEWAY", "INTERFACE", "PURPOSE", "STATUS")
+			fmt.Println("──────────────────────────────────────────────────────────────────────")
+			missing := 0
+			for _, r := range result.Routes {
+				iface := r.Interface
+				if iface == "" {
+					iface = "-"
+				}
+				status := fmt.Sprintf("%sinstalled%s", colorGreen, colorReset)
+				switch {
+				case result.TableError != "":
+					status = fmt.Sprintf("%sunknown%s", colorGray, colorReset)
+				case !r.Installed:
+					status = fmt.Sprintf("%sMISSING%s", colorRed, colorReset)
+					missing++
+				}
+				fmt.Printf("%-20s %-16s %-10s %-8s  %s\n", r.Destination, r.Gateway, iface, r.Purpose, status)
+			}
+			fmt.Println()
+
+			if result.TableError != "" {
+				fmt.Printf("%sCould not read the routing table: %s%s\n\n", colorYellow, result.TableError, colorReset)
+			} else if missing > 0 {
+				fmt.Printf("%s%d route(s) missing from the routing table.%s Reconnect with 'vpn disconnect' and 'vpn connect', or repair with 'vpn restore'.\n\n",
+					colorRed, missing, colorReset)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&outputJSON, "json", false, "Output as JSON")
+
+	return cmd
+}
+
+func sshCmd() *cobra.Command {
+	var user, password, keyPath, proxyJump string
+	var execSSH, copyID bool
+
+	cmd := &cobra.Command{
+		Use:   "ssh [peer]",
+		Short: "SSH to a peer via VPN",
+		Long: `SSH to a peer in the VPN network.
+
+The peer can be specified by:
+  - Name (e.g., "mac-mini", "server")
+  - VPN IP address (e.g., "10.8.0.1")
+
+If no peer is specified, shows an interactive menu to select a peer.
+
+The command will look up the peer's VPN address and construct the SSH command.
+Use --exec to actually run SSH. With --key (or when sshpass is not
+installed) the CLI connects itself using a private key, falling back to the
+password; otherwise it runs sshpass. --key without a path tries
+~/.ssh/id_ed25519, then ~/.ssh/id_rsa.
+
+--copy-id installs the matching public key (<key>.pub) in the peer's
+~/.ssh/authorized_keys, logging in with the password one last time (via
+sshpass and ssh-copy-id when installed), then checks that key-only login
+works. Afterwards 'vpn ssh <peer> --exec --key' needs no password.
+
+--proxy-jump reaches peers that are not directly SSH-reachable through
+another peer (usually the server), given like the target or as user@peer.
+The shown command uses -o ProxyJump; --exec always uses the built-in client,
+which SSHes to the jump host and dials the target through it, with the same
+key/password for both hops.
+
+Family password: osopanda
+
+Examples:
+  vpn ssh                         # Interactive peer selection
+  vpn ssh mac-mini                # Show SSH command for mac-mini
+  vpn ssh mac-mini --exec         # Actually SSH to mac-mini
+  vpn ssh 10.8.0.1                # SSH to VPN IP directly
+  vpn ssh server --user=root      # SSH as root to server
+  vpn ssh server --exec --key     # Key-based auth (~/.ssh/id_ed25519 or id_rsa)
+  vpn ssh server --exec --key=~/.ssh/family
+  vpn ssh mac-mini --copy-id      # Install ~/.ssh/id_ed25519.pub, then use --key
+  vpn ssh mac-mini --exec --proxy-jump=10.8.0.1  # Hop through the server`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Try to connect to node for peer lookup
+			client, err := cli.NewClient(nodeAddr)
+			if err != nil {
+				return fmt.Errorf("cannot connect to local node: %w", err)
+			}
+			defer client.Close()
+
+			// Get network peers
+			result, err := client.NetworkPeers()
+			if err != nil {
+				return fmt.Errorf("cannot get network peers: %w", err)
+			}
+
+			// Get our own status to filter ourselves out
+			status, _ := client.Status()
+			myVPNAddr := ""
+			if status != nil {
+				myVPNAddr = status.VPNAddress
+			}
+
+			// Filter out ourselves from the peer list
+			var availablePeers []protocol.PeerListEntry
+			for _, p := range result.Peers {
+				if p.VPNAddress != myVPNAddr {
+					availablePeers = append(availablePeers, p)
+				}
+			}
+
+			if len(availablePeers) == 0 {
+				fmt.Println("No other peers available in the network.")
+				return nil
+			}
+
+			var target string
+			if len(args) == 0 {
+				// Interactive peer selection
+				fmt.Println("\n" + colorGreen + "Select a peer to SSH into:" + colorReset)
+				fmt.Println("────────────────────────────────────────")
+				for i, p := range availablePeers {
+					osInfo := ""
+					if p.OS != "" {
+						osInfo = fmt.Sprintf(" [%s]", p.OS)
+					}
+					fmt.Printf("  %d) %s (%s)%s\n", i+1, p.Name, p.VPNAddress, osInfo)
+				}
+				fmt.Println()
+				fmt.Print("Enter number (or 'q' to quit): ")
+
+				var input string
+				fmt.Scanln(&input)
+				if input == "q" || input == "" {
+					return nil
+				}
+
+				var choice int
+				if _, err := fmt.Sscanf(input, "%d", &choice); err != nil || choice < 1 || choice > len(availablePeers) {
+					fmt.Println("Invalid selection")
+					return nil
+				}
+
+				target = availablePeers[choice-1].Name
+			} else {
+				target = args[0]
+			}
+
+			// Find the peer
+			subnetCIDR := tunnel.DefaultSubnet
+			if status != nil && status.Subnet != "" {
+				subnetCIDR = status.Subnet
+			}
+			_, vpnNet, _ := net.ParseCIDR(subnetCIDR)
+			targetIP, targetUser, peerName := findSSHPeer(target, availablePeers, vpnNet)
+
+			if targetIP == "" {
+				fmt.Printf("%sPeer not found: %s%s\n", colorRed, target, colorReset)
+				fmt.Println("\nAvailable peers:")
+				for _, p := range availablePeers {
+					fmt.Printf("  - %s (%s)\n", p.Name, p.VPNAddress)
+				}
+				return nil
+			}
+
+			// Override user if specified
+			if user != "" {
+				targetUser = user
+			}
+			if targetUser == "" {
+				targetUser = "root" // fallback
+			}
+
+			// Override password if not specified
+			if password == "" {
+				password = "osopanda"
+			}
+
+			// Resolve the jump host like the target; user@peer sets its user
+			var jump string
+			if proxyJump != "" {
+				jumpUser, jumpPeer, hasUser := strings.Cut(proxyJump, "@")
+				if !hasUser {
+					jumpPeer, jumpUser = jumpUser, ""
+				}
+				jumpIP, detectedUser, _ := findSSHPeer(jumpPeer, availablePeers, vpnNet)
+				if jumpIP == "" {
+					return fmt.Errorf("jump host not found: %s", jumpPeer)
+				}
+				if jumpUser == "" {
+					jumpUser = detectedUser
+				}
+				if jumpUser == "" {
+					jumpUser = "root"
+				}
+				jump = jumpUser + "@" + jumpIP
+			}
+
+			sshCmdStr := fmt.Sprintf("ssh %s@%s", targetUser, targetIP)
+			if jump != "" {
+				sshCmdStr = fmt.Sprintf("ssh -o ProxyJump=%s %s@%s", jump, targetUser, targetIP)
+			}
+
+			if copyID {
+				if jump != "" {
+					return fmt.Errorf("--copy-id does not support --proxy-jump")
+				}
+				fmt.Printf("\n%sInstalling SSH key on %s...%s\n", colorGreen, peerName, colorReset)
+				pubPath, err := copySSHID(targetUser, targetIP, keyPath, password)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("%s✓ Installed %s for %s@%s; key-only login works%s\n", colorGreen, pubPath, targetUser, targetIP, colorReset)
+				fmt.Println()
+				fmt.Println("Connect without the password:")
+				keyFlag := "--key"
+				if k := strings.TrimSpace(keyPath); k != "" {
+					keyFlag = "--key=" + k
+				}
+				fmt.Printf("  vpn ssh %s --exec %s\n", target, keyFlag)
+				return nil
+			}
+
+			if execSSH {
+				// Actually execute SSH using sshpass
+				fmt.Printf("\n%sConnecting to %s...%s\n\n", colorGreen, peerName, colorReset)
+
+				// Key auth, a jump host (sshpass answers only one password
+				// prompt) or no sshpass: use the built-in client
+				_, lookErr := exec.LookPath("sshpass")
+				if cmd.Flags().Changed("key") || jump != "" || lookErr != nil {
+					return runSSHSession(targetUser, targetIP, jump, keyPath, password)
+				}
+
+				// Run sshpass with SSH
+				sshCmd := exec.Command("sshpass", "-p", password, "ssh",
+					"-o", "StrictHostKeyChecking=no",
+					"-o", "UserKnownHostsFile=/dev/null",
+					fmt.Sprintf("%s@%s", targetUser, targetIP))
+				sshCmd.Stdin = os.Stdin
+				sshCmd.Stdout = os.Stdout
+				sshCmd.Stderr = os.Stderr
+
+				return sshCmd.Run()
+			}
+
+			// Just show the command
+			fmt.Printf("\n%sSSH to %s%s\n", colorGreen, peerName, colorReset)
+			fmt.Println("────────────────────────────────────────")
+			fmt.Printf("  Peer:      %s\n", peerName)
+			fmt.Printf("  VPN IP:    %s\n", targetIP)
+			fmt.Printf("  User:      %s\n", targetUser)
+			if jump != "" {
+				fmt.Printf("  Jump host: %s\n", jump)
+			}
+			fmt.Printf("  Password:  %s\n", password)
+			fmt.Println()
+			fmt.Printf("  Command:   %s%s%s\n", colorBlue, sshCmdStr, colorReset)
+			fmt.Println()
+			fmt.Println("To connect directly, use --exec flag:")
+			if proxyJump != "" {
+				fmt.Printf("  vpn ssh %s --exec --proxy-jump=%s\n", target, proxyJump)
+			} else {
+				fmt.Printf("  vpn ssh %s --exec\n", target)
+			}
+			fmt.Println()
+			fmt.Println("Or copy the command above, or use sshpass:")
+			fmt.Printf("  sshpass -p '%s' %s\n", password, sshCmdStr)
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&user, "user", "", "SSH username (auto-detected if not specified)")
+	cmd.Flags().StringVar(&password, "password", "osopanda", "SSH password (default: osopanda)")
+	cmd.Flags().BoolVar(&execSSH, "exec", false, "Actually execute SSH")
+	cmd.Flags().BoolVar(&copyID, "copy-id", false, "Install your public key on the peer (using the password once) and verify key-only login")
+	cmd.Flags().StringVar(&keyPath, "key", "", "SSH private key for --exec (default: ~/.ssh/id_ed25519, then ~/.ssh/id_rsa)")
+	cmd.Flags().Lookup("key").NoOptDefVal = " "
+	cmd.Flags().StringVar(&proxyJump, "proxy-jump", "", "Reach the peer through this jump peer (name, VPN IP, or user@peer)")
+
+	return cmd
+}
+
+// findSSHPeer resolves a peer name or VPN IP to its VPN IP, the SSH user
+// its OS suggests (root on Linux, else the hostname) and its name. A VPN IP
+// missing from peers resolves with an empty user; an unknown name to "".
+func findSSHPeer(target string, peers []protocol.PeerListEntry, vpnNet *net.IPNet) (ip, user, name string) {
+	// Check if target is already a VPN IP
+	if addr := net.ParseIP(target); addr != nil && vpnNet != nil && vpnNet.Contains(addr) {
+		// Try to find user from peer list
+		for _, p := range peers {
+			if p.VPNAddress == target {
+				if p.OS == "linux" {
+					return target, "root", p.Name
+				}
+				return target, p.Hostname, p.Name
+			}
+		}
+		return target, "", ""
+	}
+
+	// Search by name
+	for _, p := range peers {
+		if strings.EqualFold(p.Name, target) || strings.Contains(strings.ToLower(p.Name), strings.ToLower(target)) {
+			switch {
+			case p.OS == "linux":
+				user = "root"
+			case p.Hostname != "":
+				user = p.Hostname
+			default:
+				user = p.Name
+			}
+			return p.VPNAddress, user, p.Name
+		}
+	}
+	return "", "", ""
+}
+
+// sshKeyPaths returns the private keys to try: keyPath if given, else the
+// default ed25519 and RSA keys.
+func sshKeyPaths(keyPath string) []string {
+	home, _ := os.UserHomeDir()
+	keyPath = strings.TrimSpace(keyPath)
+	if keyPath != "" {
+		if strings.HasPrefix(keyPath, "~/") {
+			keyPath = filepath.Join(home, keyPath[2:])
+		}
+		return []string{keyPath}
+	}
+	return []string{
+		filepath.Join(home, ".ssh", "id_ed25519"),
+		filepath.Join(home, ".ssh", "id_rsa"),
+	}
+}
+
+// sshSigner loads the first readable private key from sshKeyPaths.
+func sshSigner(keyPath string) (ssh.Signer, string, error) {
+	var tried []string
+	for _, path := range sshKeyPaths(keyPath) {
+		tried = append(tried, path)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, path, fmt.Errorf("cannot use key %s: %w", path, err)
+		}
+		return signer, path, nil
+	}
+	return nil, "", fmt.Errorf("no SSH key found (tried %s); create one with ssh-keygen -t ed25519", strings.Join(tried, ", "))
+}
+
+// copySSHID installs the public key of keyPath (see sshKeyPaths) in
+// ~/.ssh/authorized_keys for user on host, authenticating with password,
+// like ssh-copy-id. It uses sshpass and ssh-copy-id when both are installed,
+// else the built-in client, and then verifies that the key alone logs in.
+// Returns the public key file installed.
+func copySSHID(user, host, keyPath, password string) (string, error) {
+	signer, privPath, err := sshSigner(keyPath)
+	if err != nil {
+		return "", err
+	}
+	pubPath := privPath + ".pub"
+	pubKey, err := os.ReadFile(pubPath)
+	if err != nil {
+		// The private key is enough to derive the public half
+		pubPath = privPath
+		pubKey = ssh.MarshalAuthorizedKey(signer.PublicKey())
+	}
+
+	_, sshpassErr := exec.LookPath("sshpass")
+	_, copyIDErr := exec.LookPath("ssh-copy-id")
+	if sshpassErr == nil && copyIDErr == nil && pubPath != privPath {
+		cmd := exec.Command("sshpass", "-p", password, "ssh-copy-id", "-i", pubPath,
+			"-o", "StrictHostKeyChecking=no",
+			"-o", "UserKnownHostsFile=/dev/null",
+			fmt.Sprintf("%s@%s", user, host))
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return "", fmt.Errorf("ssh-copy-id failed: %v - %s", err, strings.TrimSpace(string(out)))
+		}
+	} else {
+		conn, err := ssh.Dial("tcp", net.JoinHostPort(host, "22"), &ssh.ClientConfig{
+			User:            user,
+			Auth:            []ssh.AuthMethod{ssh.Password(password)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			Timeout:         10 * time.Second,
+		})
+		if err != nil {
+			return "", fmt.Errorf("password login to %s@%s failed: %w", user, host, err)
+		}
+		defer conn.Close()
+
+		session, err := conn.NewSession()
+		if err != nil {
+			return "", err
+		}
+		defer session.Close()
+
+		// Append the key unless it is already there, like ssh-copy-id
+		session.Stdin = strings.NewReader(string(pubKey))
+		script := `umask 077; mkdir -p ~/.ssh && k=$(cat) && { grep -qxF "$k" ~/.ssh/authorized_keys 2>/dev/null || echo "$k" >> ~/.ssh/authorized_keys; }`
+		if out, err := session.CombinedOutput(script); err != nil {
+			return "", fmt.Errorf("failed to install key on %s: %v - %s", host, err, strings.TrimSpace(string(out)))
+		}
+	}
+
+	// Verify key-only login
+	conn, err := ssh.Dial("tcp", net.JoinHostPort(host, "22"), &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         10 * time.Second,
+	})
+	if err != nil {
+		return "", fmt.Errorf("key installed but key-only login to %s@%s failed: %w (check permissions of ~/.ssh on the peer)", user, host, err)
+	}
+	conn.Close()
+	return pubPath, nil
+}
+
+// runSSHSession opens an interactive shell on host with the built-in SSH
+// client, authenticating with the first readable private key and then the
+// password. With jump ("user@host") the target is dialed through an SSH
+// connection to the jump host, which uses the same credentials. Host keys
+// are not checked, like the sshpass path.
+func runSSHSession(user, host, jump, keyPath, password string) error {
+	var auth []ssh.AuthMethod
+	var triedKeys []string
+	for _, path := range sshKeyPaths(keyPath) {
+		triedKeys = append(triedKeys, path)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return fmt.Errorf("cannot use key %s: %w", path, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+		break
+	}
+	if password != "" {
+		auth = append(auth, ssh.Password(password))
+	}
+
+	clientConfig := func(user string) *ssh.ClientConfig {
+		return &ssh.ClientConfig{
+			User:            user,
+			Auth:            auth,
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			Timeout:         10 * time.Second,
+		}
+	}
+	authHint := func(err error, user, host string) error {
+		if strings.Contains(err.Error(), "unable to authenticate") {
+			return fmt.Errorf("%w\n\nCheck the key path (tried %s) and that its public key is in ~/.ssh/authorized_keys for %s on %s",
+				err, strings.Join(triedKeys, ", "), user, host)
+		}
+		return err
+	}
+
+	addr := net.JoinHostPort(host, "22")
+	var conn *ssh.Client
+	if jump == "" {
+		c, err := ssh.Dial("tcp", addr, clientConfig(user))
+		if err != nil {
+			return authHint(err, user, host)
+		}
+		conn = c
+	} else {
+		jumpUser, jumpHost, _ := strings.Cut(jump, "@")
+		jumpConn, err := ssh.Dial("tcp", net.JoinHostPort(jumpHost, "22"), clientConfig(jumpUser))
+		if err != nil {
+			return fmt.Errorf("jump host %s: %w", jump, authHint(err, jumpUser, jumpHost))
+		}
+		defer jumpConn.Close()
+
+		// Dial the target from the jump host and run SSH over that stream
+		netConn, err := jumpConn.Dial("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("jump host %s cannot reach %s: %w", jump, addr, err)
+		}
+		c, chans, reqs, err := ssh.NewClientConn(netConn, addr, clientConfig(user))
+		if err != nil {
+			netConn.Close()
+			return authHint(err, user, host)
+		}
+		conn = ssh.NewClient(c, chans, reqs)
+	}
+	defer conn.Close()
+
+	session, err := conn.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	session.Stdin = os.Stdin
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return err
+		}
+		defer term.Restore(fd, state)
+
+		width, height, err := term.GetSize(fd)
+		if err != nil {
+			width, height = 80, 24
+		}
+		termType := os.Getenv("TERM")
+		if termType == "" {
+			termType = "xterm-256color"
+		}
+		if err := session.RequestPty(termType, height, width, ssh.TerminalModes{ssh.ECHO: 1}); err != nil {
+			return err
+		}
+	}
+
+	if err := session.Shell(); err != nil {
+		return err
+	}
+	if err := session.Wait(); err != nil {
+		if _, ok := err.(*ssh.ExitError); !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+const cliVersion = "0.6.2"
+
+const (
+	// latestReleaseURL is the GitHub API endpoint for 'vpn version --check'.
+	latestReleaseURL = "https://api.github.com/repos/miguelemosreverte/the-family-vpn/releases/latest"
+
+	// releasesURL is shown as the changelog when a release has no page URL.
+	releasesURL = "https://github.com/miguelemosreverte/the-family-vpn/releases"
+
+	// versionCheckTTL is how long a fetched release is reused.
+	versionCheckTTL = 24 * time.Hour
+)
+
+func versionCmd() *cobra.Command {
+	var check bool
+	var checkURL string
+
+	cmd := &cobra.Command{
+		Use:   "version",
+		Short: "Show CLI and node version",
+		Long: `Show the CLI version and the version of the node.
+
+With --check, also fetch the latest GitHub release and print an upgrade
+notice if the CLI is behind. The answer is cached for 24 hours in
+~/.vpn-node/version_check.json; --no-check-version disables the fetch.
+
+Examples:
+  vpn version
+  vpn version --check
+  vpn version --check --check-url=https://example.com/releases/latest`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fmt.Printf("VPN CLI version %s\n", cliVersion)
+
+			// Try to get node version
+			client, err := cli.NewClient(nodeAddr)
+			if err != nil {
+				fmt.Printf("Node version: (not connected)\n")
+			} else {
+				defer client.Close()
+
+				status, err := client.Status()
+				if err != nil {
+					fmt.Printf("Node version: (error: %v)\n", err)
+				} else {
+					fmt.Printf("Node version: %s (%s)\n", status.Version, status.NodeName)
+				}
+			}
+
+			if !check {
+				return nil
+			}
+			if noCheckVersion {
+				fmt.Printf("Latest version: (check disabled by --no-check-version)\n")
+				return nil
+			}
+
+			release, err := latestRelease(checkURL)
+			if err != nil {
+				fmt.Printf("Latest version: (check failed: %v)\n", err)
+				return nil
+			}
+
+			if compareVersions(cliVersion, release.TagName) >= 0 {
+				fmt.Printf("Latest version: %s %s(up to date)%s\n", release.TagName, colorGreen, colorReset)
+				return nil
+			}
+
+			changelog := release.HTMLURL
+			if changelog == "" {
+				changelog = releasesURL
+			}
+			fmt.Printf("Latest version: %s\n", release.TagName)
+			fmt.Printf("\n%sA newer version is available: %s (you have %s)%s\n", colorYellow, release.TagName, cliVersion, colorReset)
+			fmt.Printf("%s  Changelog: %s%s\n", colorYellow, changelog, colorReset)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&check, "check", false, "Compare with the latest GitHub release")
+	cmd.Flags().StringVar(&checkURL, "check-url", latestReleaseURL, "Release API URL (JSON with tag_name and html_url)")
+
+	return cmd
+}
+
+// releaseInfo is the part of a GitHub release we use, cached with the time
+// it was fetched and where from.
+type releaseInfo struct {
+	TagName   string    `json:"tag_name"`
+	HTMLURL   string    `json:"html_url"`
+	URL       string    `json:"url"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// versionCheckCachePath is where the last fetched release is cached.
+func versionCheckCachePath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "/tmp"
+	}
+	return filepath.Join(home, ".vpn-node", "version_check.json")
+}
+
+// latestRelease returns the latest release from url, served from the cache
+// if it was fetched from the same URL less than versionCheckTTL ago.
+func latestRelease(url string) (*releaseInfo, error) {
+	cachePath := versionCheckCachePath()
+	if data, err := os.ReadFile(cachePath); err == nil {
+		var cached releaseInfo
+		if json.Unmarshal(data, &cached) == nil && cached.URL == url &&
+			cached.TagName != "" && time.Since(cached.CheckedAt) < versionCheckTTL {
+			return &cached, nil
+		}
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("User-Agent", "vpn-cli/"+cliVersion)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+
+	var release releaseInfo
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&release); err != nil {
+		return nil, fmt.Errorf("invalid release JSON: %w", err)
+	}
+	if release.TagName == "" {
+		return nil, fmt.Errorf("release has no tag_name")
+	}
+	release.URL = url
+	release.CheckedAt = time.Now()
+
+	// A failed cache write only means fetching again next time
+	if data, err := json.MarshalIndent(release, "", "  "); err == nil {
+		if os.MkdirAll(filepath.Dir(cachePath), 0755) == nil {
+			os.WriteFile(cachePath, data, 0644)
+		}
+	}
+
+	return &release, nil
+}
+
+// compareVersions compares dotted versions like "0.6.2" and "v0.7.0"
+// numerically (a leading "v" and any "-suffix" are ignored), returning -1, 0
+// or 1.
+func compareVersions(a, b string) int {
+	parse := func(v string) []int {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+		v, _, _ = strings.Cut(v, "-")
+		var parts []int
+		for _, p := range strings.Split(v, ".") {
+			n, _ := strconv.Atoi(p)
+			parts = append(parts, n)
+		}
+		return parts
+	}
+
+	pa, pb := parse(a), parse(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func networkPeersCmd() *cobra.Command {
+	var outputJSON bool
+
+	cmd := &cobra.Command{
+		Use:     "network-peers",
+		Aliases: []string{"np", "net-peers"},
+		Short:   "List all peers in the VPN network",
+		Long: `List all peers known to the VPN network.
+
+In client mode, shows peers received from the server via PEER_LIST messages.
+In server mode, shows all connected clients.
+
+Examples:
+  vpn network-peers              # List all network peers
+  vpn network-peers --json       # JSON output for scripting`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := cli.NewClient(nodeAddr)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			result, err := client.NetworkPeers()
+			if err != nil {
+				return err
+			}
+
+			if outputJSON {
+				output, err := json.MarshalIndent(result, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(output))
+				return nil
+			}
+
+			mode := "Client"
+			if result.ServerMode {
+				mode = "Server"
+			}
+
+			fmt.Printf("\nNetwork Peers (%s mode)\n", mode)
+			fmt.Println("────────────────────────────────────────────────────────────")
+
+			if len(result.Peers) == 0 {
+				fmt.Println("No peers in network.")
+				fmt.Println("\nNote: Peers are discovered when the server broadcasts the peer list.")
+				return nil
+			}
+
+			fmt.Printf("%-20s %-15s %-25s %s\n", "NAME", "VPN IP", "HOSTNAME", "OS")
+			fmt.Println("────────────────────────────────────────────────────────────")
+
+			for _, p := range result.Peers {
+				fmt.Printf("%-20s %-15s %-25s %s\n",
+					p.Name, p.VPNAddress, p.Hostname, p.OS)
+			}
+
+			fmt.Println()
+			fmt.Println("Use 'vpn ssh <name>' to connect to a peer.")
+
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&outputJSON, "json", false, "Output as JSON")
+
+	return cmd
+}
+
+func nodeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "node",
+		Short: "Inspect the nodes of the network",
+	}
+
+	cmd.AddCommand(nodeListCmd())
+	cmd.AddCommand(nodeAddCmd())
+	cmd.AddCommand(nodeRemoveCmd())
+	cmd.AddCommand(nodeHealthCmd())
+
+	return cmd
+}
+
+func nodeRemoveCmd() *cobra.Command {
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:     "rm <peer>",
+		Aliases: []string{"remove"},
+		Short:   "Remove a decommissioned node from the network (server only)",
+		Long: `Remove a node from the server for good. <peer> is its VPN IP, name or
+hostname.
+
+If the node is connected it is told it was removed (its daemon restores
+routing, logs the reason and exits) and disconnected. Its VPN IP
+assignment and connection state are deleted, so the IP can be given to
+another node, and the updated peer list is sent to all clients.
+
+Unlike 'vpn remove-peer', which only drops a stale connection, the node
+does not get its IP back. A service manager that always restarts vpn-node
+(the installer's systemd unit and launchd daemon do) brings it back as a
+new node, so uninstall the service on the machine too.
+
+Examples:
+  vpn --node 10.8.0.1:9001 node rm old-laptop
+  vpn --node 10.8.0.1:9001 node rm 10.8.0.7 --force`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			peer := args[0]
+
+			if !force {
+				fmt.Printf("Remove node %s from the network? It is disconnected and its VPN IP is freed. [y/N] ", peer)
+				answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+				answer = strings.ToLower(strings.TrimSpace(answer))
+				if answer != "y" && answer != "yes" {
+					fmt.Println("Aborted")
+					return nil
+				}
+			}
+
+			client, err := cli.NewClient(nodeAddr)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			result, err := client.ForgetPeer(peer)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("%s✓%s Removed node %s (%d peers connected)\n",
+				colorGreen, colorReset, peer, len(result.Peers))
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVarP(&force, "force", "f", false, "Do not ask for confirmation")
+
+	return cmd
+}
+
+const (
+	// nodeHealthTimeout bounds each request of 'vpn node health'.
+	nodeHealthTimeout = 5 * time.Second
+
+	// nodeHealthPort is the port of the deploy server's /health endpoint
+	// (vpn-node --listen-ws).
+	nodeHealthPort = 9000
+)
+
+// NodeHealthEntry is the result of 'vpn node health' for one peer.
+type NodeHealthEntry struct {
+	Name       string                 `json:"name"`
+	VPNAddress string                 `json:"vpn_address"`
+	Healthy    bool                   `json:"healthy"`
+	Source     string                 `json:"source,omitempty"`      // "http" (/health) or "control" (status fallback)
+	HTTPStatus int                    `json:"http_status,omitempty"` // Status code of /health, if it answered
+	Health     json.RawMessage        `json:"health,omitempty"`
+	Status     *protocol.StatusResult `json:"status,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+func nodeHealthCmd() *cobra.Command {
+	var all, outputJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "health [peer]",
+		Short: "Check a node's health endpoint",
+		Long: `Check the /health endpoint of a node's deploy server
+(http://<vpn-ip>:9000/health) over the VPN and print the JSON it returns.
+<peer> is a name or VPN IP, looked up like 'vpn ssh' does.
+
+If the endpoint does not answer (only servers run the deploy server), the
+node's control socket (<vpn-ip>:9001) is asked for its status instead.
+Each request times out after 5 seconds.
+
+Use --all to check every network peer and print a summary table.
+
+Exits with status 1 if any peer answers with a non-200 status or is
+unreachable on both ports.
+
+Examples:
+  vpn node health server
+  vpn node health 10.8.0.5 --json
+  vpn node health --all`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if all == (len(args) == 1) {
+				return fmt.Errorf("give a peer or --all")
+			}
+
+			client, err := cli.NewClient(nodeAddr)
+			if err != nil {
+				return fmt.Errorf("cannot connect to local node: %w", err)
+			}
+			defer client.Close()
+
+			network, err := client.NetworkPeers()
+			if err != nil {
+				return fmt.Errorf("cannot get network peers: %w", err)
+			}
+
+			var entries []NodeHealthEntry
+			if all {
+				for _, p := range network.Peers {
+					entries = append(entries, NodeHealthEntry{Name: p.Name, VPNAddress: p.VPNAddress})
+				}
+				if len(entries) == 0 {
+					fmt.Println("No peers in network.")
+					return nil
+				}
+			} else {
+				subnetCIDR := tunnel.DefaultSubnet
+				if status, err := client.Status(); err == nil && status.Subnet != "" {
+					subnetCIDR = status.Subnet
+				}
+				_, vpnNet, _ := net.ParseCIDR(subnetCIDR)
+				ip, _, name := findSSHPeer(args[0], network.Peers, vpnNet)
+				if ip == "" {
+					return fmt.Errorf("peer not found: %s (see 'vpn network-peers')", args[0])
+				}
+				if name == "" {
+					name = ip
+				}
+				entries = []NodeHealthEntry{{Name: name, VPNAddress: ip}}
+			}
+			checkNodeHealths(entries)
+
+			unhealthy := 0
+			for _, e := range entries {
+				if !e.Healthy {
+					unhealthy++
+				}
+			}
+
+			switch {
+			case outputJSON:
+				var v interface{} = entries
+				if !all {
+					v = entries[0]
+				}
+				output, err := json.MarshalIndent(v, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(output))
+			case all:
+				printNodeHealthTable(entries)
+			default:
+				printNodeHealth(entries[0])
+			}
+
+			if unhealthy > 0 {
+				// Exit directly: returning an error would print usage
+				if all {
+					fmt.Fprintf(os.Stderr, "%d of %d nodes unhealthy\n", unhealthy, len(entries))
+				}
+				os.Exit(1)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&all, "all", false, "Check every network peer")
+	cmd.Flags().BoolVar(&outputJSON, "json", false, "Output as JSON")
+
+	return cmd
+}
+
+// checkNodeHealths checks each entry, up to nodeStatusWorkers at a time.
+func checkNodeHealths(entries []NodeHealthEntry) {
+	jobs := make(chan *NodeHealthEntry)
+	var wg sync.WaitGroup
+	for i := 0; i < nodeStatusWorkers && i < len(entries); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range jobs {
+				checkNodeHealth(e)
+			}
+		}()
+	}
+
+	for i := range entries {
+		jobs <- &entries[i]
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// checkNodeHealth fills in e from the node's /health endpoint, or from its
+// control socket if the endpoint does not answer.
+func checkNodeHealth(e *NodeHealthEntry) {
+	resp, err := fetchNodeHealth(e.VPNAddress, nodeHealthPort, nodeHealthTimeout)
+	if err == nil {
+		e.Source = "http"
+		e.HTTPStatus = resp.Code
+		e.Health = resp.Body
+		e.Healthy = resp.Code == http.StatusOK
+		if !e.Healthy {
+			e.Error = "health endpoint returned " + http.StatusText(resp.Code)
+		}
+		return
+	}
+
+	status, statusErr := cli.PeerStatus(e.VPNAddress, nodeHealthTimeout)
+	if statusErr != nil {
+		e.Error = fmt.Sprintf("health endpoint: %v; control socket: %v", err, statusErr)
+		return
+	}
+	e.Source = "control"
+	e.Status = status
+	e.Healthy = true
+}
+
+// printNodeHealth prints the result of 'vpn node health <peer>'.
+func printNodeHealth(e NodeHealthEntry) {
+	var body []byte
+	switch e.Source {
+	case "http":
+		var out bytes.Buffer
+		if json.Indent(&out, e.Health, "", "  ") == nil {
+			body = out.Bytes()
+		} else {
+			body = e.Health
+		}
+	case "control":
+		fmt.Printf("%s⚠%s %s has no health endpoint on port %d, status from its control socket:\n",
+			colorYellow, colorReset, e.Name, nodeHealthPort)
+		body, _ = json.MarshalIndent(e.Status, "", "  ")
+	default:
+		fmt.Printf("%s✗%s %s (%s) unreachable: %s\n", colorRed, colorReset, e.Name, e.VPNAddress, e.Error)
+		return
+	}
+
+	fmt.Println(string(body))
+	if !e.Healthy {
+		fmt.Printf("%s✗%s %s: %s\n", colorRed, colorReset, e.Name, e.Error)
+	}
+}
+
+// printNodeHealthTable prints the summary of 'vpn node health --all'.
+func printNodeHealthTable(entries []NodeHealthEntry) {
+	fmt.Printf("\n%-20s %-15s %-10s %-10s %-12s %-8s %s\n",
+		"NAME", "VPN IP", "HEALTH", "VERSION", "UPTIME", "SOURCE", "ERROR")
+	fmt.Println("──────────────────────────────────────────────────────────────────────────────────────────────")
+	for _, e := range entries {
+		version, uptime := "-", "-"
+		switch e.Source {
+		case "http":
+			var h nodeHealth
+			if json.Unmarshal(e.Health, &h) == nil {
+				version, uptime = h.Version, h.Uptime
+			}
+		case "control":
+			version, uptime = e.Status.Version, e.Status.UptimeStr
+		}
+
+		health, color := "HEALTHY", colorGreen
+		if !e.Healthy {
+			health, color = "UNHEALTHY", colorRed
+		}
+		source := e.Source
+		if source == "" {
+			source = "-"
+		}
+		fmt.Printf("%-20s %-15s %s%-10s%s %-10s %-12s %-8s %s\n",
+			e.Name, e.VPNAddress, color, health, colorReset, version, uptime, source, e.Error)
+	}
+	fmt.Println()
+}
+
+const (
+	// nodeStatusTimeout bounds each peer's status query in 'vpn node ls'.
+	nodeStatusTimeout = 3 * time.Second
+
+	// nodeStatusWorkers is how many peers 'vpn node ls' queries at once.
+	nodeStatusWorkers = 10
+)
+
+// NodeListEntry is one row of 'vpn node ls'.
+type NodeListEntry struct {
+	Name       string                 `json:"name"`
+	VPNAddress string                 `json:"vpn_address"`
+	Online     bool                   `json:"online"`
+	Status     *protocol.StatusResult `json:"status,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+func nodeListCmd() *cobra.Command {
+	var outputJSON bool
+
+	cmd := &cobra.Command{
+		Use:     "ls",
+		Aliases: []string{"list"},
+		Short:   "Show the status of every node in the network",
+		Long: `Query the status of every node in the network.
+
+The peer list comes from the local node; each peer's control socket
+(<vpn-ip>:9001) is then queried over the VPN, up to 10 at a time, with a
+3 second timeout each. Peers that don't answer are shown as OFFLINE.
+
+Examples:
+  vpn node ls
+  vpn node ls --json`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := cli.NewClient(nodeAddr)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			local, err := client.Status()
+			if err != nil {
+				return err
+			}
+			network, err := client.NetworkPeers()
+			if err != nil {
+				return err
+			}
+
+			// The local node answers over the local socket; everyone else over the VPN
+			entries := []NodeListEntry{{Name: local.NodeName, VPNAddress: local.VPNAddress, Online: true, Status: local}}
+			for _, p := range network.Peers {
+				if p.VPNAddress == local.VPNAddress {
+					continue
+				}
+				entries = append(entries, NodeListEntry{Name: p.Name, VPNAddress: p.VPNAddress})
+			}
+			queryNodeStatuses(entries[1:])
+
+			if outputJSON {
+				output, err := json.MarshalIndent(entries, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(output))
+				return nil
+			}
+
+			fmt.Printf("\n%-20s %-10s %-15s %-12s %5s %10s %10s  %s\n",
+				"NAME", "VERSION", "VPN IP", "UPTIME", "PEERS", "TX", "RX", "STATUS")
+			fmt.Println("──────────────────────────────────────────────────────────────────────────────────────────────")
+			for _, e := range entries {
+				if !e.Online {
+					fmt.Printf("%-20s %-10s %-15s %-12s %5s %10s %10s  %sOFFLINE%s\n",
+						e.Name, "-", e.VPNAddress, "-", "-", "-", "-", colorRed, colorReset)
+					continue
+				}
+				s := e.Status
+				fmt.Printf("%-20s %-10s %-15s %-12s %5d %10s %10s  %sONLINE%s\n",
+					s.NodeName, s.Version, s.VPNAddress, s.UptimeStr, s.PeerCount,
+					formatBytes(s.BytesOut), formatBytes(s.BytesIn), colorGreen, colorReset)
+			}
+			fmt.Println()
+
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&outputJSON, "json", false, "Output as JSON")
+
+	return cmd
+}
+
+// queryNodeStatuses fills in each entry's status, querying up to
+// nodeStatusWorkers peers at a time.
+func queryNodeStatuses(entries []NodeListEntry) {
+	jobs := make(chan *NodeListEntry)
+	var wg sync.WaitGroup
+	for i := 0; i < nodeStatusWorkers && i < len(entries); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range jobs {
+				status, err := cli.PeerStatus(e.VPNAddress, nodeStatusTimeout)
+				if err != nil {
+					e.Error = err.Error()
+					continue
+				}
+				e.Online = true
+				e.Status = status
+			}
+		}()
+	}
+
+	for i := range entries {
+		jobs <- &entries[i]
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+const (
+	// nodeAddBinary is where 'vpn node add' installs vpn-node on the target.
+	nodeAddBinary = "/usr/local/bin/vpn-node"
+
+	// nodeAddSystemdUnit and nodeAddLaunchdPlist match the service names
+	// used by scripts/install.sh.
+	nodeAddSystemdUnit  = "/etc/systemd/system/vpn-node.service"
+	nodeAddLaunchdPlist = "/Library/LaunchDaemons/com.family.vpn-node.plist"
+)
+
+func nodeAddCmd() *cobra.Command {
+	var sshTarget, targetOS, targetArch, connectTo, name string
+	var timeout time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "add",
+		Short: "Install and start vpn-node on a new machine over SSH",
+		Long: `Bootstrap a new node over SSH.
+
+The vpn-node binary is cross-compiled from this source tree for --os and
+--arch, copied to the target, installed as a systemd (Linux) or launchd
+(macOS) service and started. The command then waits until the new node
+shows up in the network and prints its VPN IP.
+
+SSH uses the system ssh/scp with key-based authentication; the remote user
+must be root or have passwordless sudo. The new node connects to the same
+server as this node (use --connect when running on the server itself).
+
+Examples:
+  vpn node add --ssh root@192.168.1.50
+  vpn node add --ssh admin@mac-mini.local --os darwin --arch arm64
+  vpn node add --ssh root@203.0.113.7 --connect 95.217.238.72:443 --name garage`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if sshTarget == "" {
+				return fmt.Errorf("--ssh <user@host> is required")
+			}
+			if targetOS != "linux" && targetOS != "darwin" {
+				return fmt.Errorf("invalid --os %q (use linux or darwin)", targetOS)
+			}
+			if targetArch != "amd64" && targetArch != "arm64" {
+				return fmt.Errorf("invalid --arch %q (use amd64 or arm64)", targetArch)
+			}
+
+			client, err := cli.NewClient(nodeAddr)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			if connectTo == "" {
+				config, err := client.Config()
+				if err != nil {
+					return err
+				}
+				if config.ServerMode || config.ConnectTo == "" {
+					return fmt.Errorf("this node is the server; pass --connect <public-ip:port> for the new node")
+				}
+				connectTo = config.ConnectTo
+			}
+
+			root := findSourceRoot()
+			if root == "" {
+				return fmt.Errorf("source tree not found (run from the repository checkout)")
+			}
+
+			// 1. Cross-compile
+			tmpDir, err := os.MkdirTemp("", "vpn-node-add")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(tmpDir)
+
+			binary := filepath.Join(tmpDir, "vpn-node")
+			fmt.Printf("Building vpn-node for %s/%s...\n", targetOS, targetArch)
+			build := exec.Command("go", "build", "-o", binary, "./cmd/vpn-node")
+			build.Dir = root
+			build.Env = append(os.Environ(), "GOOS="+targetOS, "GOARCH="+targetArch, "CGO_ENABLED=0")
+			if out, err := build.CombinedOutput(); err != nil {
+				return fmt.Errorf("build failed: %v\n%s", err, out)
+			}
+
+			// 2. Copy it over
+			hostname, err := sshOutput(sshTarget, "hostname")
+			if err != nil {
+				return fmt.Errorf("cannot reach %s over SSH: %w", sshTarget, err)
+			}
+			if name == "" {
+				name = hostname
+			}
+			if strings.ContainsAny(name, " \t'\"$`\\") {
+				return fmt.Errorf("invalid node name %q", name)
+			}
+
+			fmt.Printf("Copying vpn-node to %s...\n", sshTarget)
+			if out, err := exec.Command("scp", append(sshOptions(), binary, sshTarget+":/tmp/vpn-node")...).CombinedOutput(); err != nil {
+				return fmt.Errorf("scp failed: %v\n%s", err, out)
+			}
+
+			// 3. Install the service and start it
+			fmt.Printf("Installing %s service...\n", map[string]string{"linux": "systemd", "darwin": "launchd"}[targetOS])
+			sudo := "sudo -n "
+			if strings.HasPrefix(sshTarget, "root@") {
+				sudo = ""
+			}
+			script := nodeAddScript(targetOS, sudo, connectTo, name)
+			if out, err := sshOutput(sshTarget, script); err != nil {
+				return fmt.Errorf("install failed: %w\n%s", err, out)
+			}
+
+			// 4. Wait for the node to join
+			fmt.Printf("Waiting for %s to join the network", name)
+			deadline := time.Now().Add(timeout)
+			for time.Now().Before(deadline) {
+				time.Sleep(2 * time.Second)
+				fmt.Print(".")
+
+				peers, err := client.NetworkPeers()
+				if err != nil {
+					continue
+				}
+				for _, p := range peers.Peers {
+					if p.Hostname == hostname || p.Name == hostname {
+						fmt.Printf("\n\n%s✓ %s joined the network%s\n", colorGreen, name, colorReset)
+						fmt.Printf("  VPN IP:  %s\n", p.VPNAddress)
+						fmt.Printf("  OS:      %s/%s\n", targetOS, targetArch)
+						fmt.Printf("  Server:  %s\n\n", connectTo)
+						return nil
+					}
+				}
+			}
+			fmt.Println()
+
+			return fmt.Errorf("%s did not join within %s (check the service log on the target: /var/log/vpn-node.log)", name, timeout)
+		},
+	}
+
+	cmd.Flags().StringVar(&sshTarget, "ssh", "", "SSH target as user@host (required)")
+	cmd.Flags().StringVar(&targetOS, "os", "linux", "Target OS: linux or darwin")
+	cmd.Flags().StringVar(&targetArch, "arch", "amd64", "Target architecture: amd64 or arm64")
+	cmd.Flags().StringVar(&connectTo, "connect", "", "Server the new node connects to (default: this node's server)")
+	cmd.Flags().StringVar(&name, "name", "", "Node name (default: target hostname)")
+	cmd.Flags().DurationVar(&timeout, "timeout", 2*time.Minute, "How long to wait for the node to join")
+
+	return cmd
+}
+
+// sshOptions are the ssh/scp options used by 'vpn node add': key-based
+// auth only, so a missing key fails instead of prompting.
+func sshOptions() []string {
+	return []string{
+		"-o", "BatchMode=yes",
+		"-o", "StrictHostKeyChecking=accept-new",
+		"-o", "ConnectTimeout=10",
+	}
+}
+
+// sshOutput runs a shell command on the target and returns its trimmed output.
+func sshOutput(target, command string) (string, error) {
+	out, err := exec.Command("ssh", append(sshOptions(), target, command)...).CombinedOutput()
+	return strings.TrimSpace(string(out)), err
+}
+
+// nodeAddScript returns the remote shell script that installs vpn-node
+// from /tmp/vpn-node and starts it as a service.
+func nodeAddScript(targetOS, sudo, connectTo, name string) string {
+	args := fmt.Sprintf("--connect %s --name %s", connectTo, name)
+
+	var b strings.Builder
+	b.WriteString("set -e\n")
+	fmt.Fprintf(&b, "%sinstall -m 0755 /tmp/vpn-node %s\n", sudo, nodeAddBinary)
+	b.WriteString("rm -f /tmp/vpn-node\n")
+
+	if targetOS == "darwin" {
+		var argXML strings.Builder
+		for _, arg := range append([]string{nodeAddBinary}, strings.Fields(args)...) {
+			fmt.Fprintf(&argXML, "        <string>%s</string>\n", arg)
+		}
+		fmt.Fprintf(&b, `%stee %s > /dev/null << 'PLIST'
+<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+    <key>Label</key>
+    <string>com.family.vpn-node</string>
+    <key>ProgramArguments</key>
+    <array>
+%s    </array>
+    <key>RunAtLoad</key>
+    <true/>
+    <key>KeepAlive</key>
+    <true/>
+    <key>StandardOutPath</key>
+    <string>/var/log/vpn-node.log</string>
+    <key>StandardErrorPath</key>
+    <string>/var/log/vpn-node.log</string>
+</dict>
+</plist>
+PLIST
+`, sudo, nodeAddLaunchdPlist, argXML.String())
+		fmt.Fprintf(&b, "%slaunchctl unload %s 2>/dev/null || true\n", sudo, nodeAddLaunchdPlist)
+		fmt.Fprintf(&b, "%slaunchctl load -w %s\n", sudo, nodeAddLaunchdPlist)
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, `%stee %s > /dev/null << 'SERVICE'
+[Unit]
+Description=Family VPN Node
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=simple
+ExecStart=%s %s
+Restart=always
+RestartSec=5
+StandardOutput=append:/var/log/vpn-node.log
+StandardError=append:/var/log/vpn-node.log
+
+[Install]
+WantedBy=multi-user.target
+SERVICE
+`, sudo, nodeAddSystemdUnit, nodeAddBinary, args)
+	fmt.Fprintf(&b, "%ssystemctl daemon-reload\n", sudo)
+	fmt.Fprintf(&b, "%ssystemctl enable vpn-node\n", sudo)
+	fmt.Fprintf(&b, "%ssystemctl restart vpn-node\n", sudo)
+	return b.String()
+}
+
+// findSourceRoot finds the repository checkout (the directory with go.mod)
+// from the working directory or the vpn binary's location.
+func findSourceRoot() string {
+	var starts []string
+	if wd, err := os.Getwd(); err == nil {
+		starts = append(starts, wd)
+	}
+	if exe, err := os.Executable(); err == nil {
+		starts = append(starts, filepath.Dir(exe))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		starts = append(starts, filepath.Join(home, "the-family-vpn"))
+	}
+
+	for _, dir := range starts {
+		for {
+			if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+				return dir
+			}
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				break
+			}
+			dir = parent
+		}
+	}
+	return ""
+}
+
+func crashesCmd() *cobra.Command {
+	var since, until string
+	var outputJSON bool
+
+	cmd := &cobra.Command{
+		Use:     "crashes",
+		Aliases: []string{"crash", "crash-stats"},
+		Short:   "Show crash statistics and last crash details",
+		Long: `Show crash statistics and information about the last crash.
+
+This command helps diagnose VPN node stability issues by showing:
+- Total crashes in the time period
+- How many crashes had route-all enabled
+- How many times route restoration failed (which breaks internet)
+- Details of the most recent crash
+
+--since and --until use the same time syntax as 'vpn logs' (-1h, -7d, @d,
+-1d@d, 2024-01-15T14:30:00, ...).
+
+Examples:
+  vpn crashes                    # Show stats for last 24 hours
+  vpn crashes --since=-1h        # Show stats for last hour
+  vpn crashes --since=-7d        # Show stats for last week
+  vpn crashes --since=-2d@d --until=@d   # From 2 days ago until today
+  vpn crashes --json             # JSON output for scripting`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := cli.NewClient(nodeAddr)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			result, err := client.CrashStats(since, until)
+			if err != nil {
+				return err
+			}
+
+			if outputJSON {
+				output, err := json.MarshalIndent(result, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(output))
+				return nil
+			}
+
+			fmt.Println("\nCrash Statistics")
+			fmt.Println("────────────────────────────────────────")
+			if result.Start != "" {
+				fmt.Printf("  Time Period:          %s to %s\n", result.Start, result.End)
+			} else {
+				fmt.Printf("  Time Period:          %s to %s\n", since, until)
+			}
+			fmt.Printf("  Total Crashes:        %d\n", result.TotalCrashes)
+			fmt.Printf("  With Route-All:       %d\n", result.CrashesWithRouteAll)
+
+			if result.RouteRestoreFailures > 0 {
+				fmt.Printf("  %sRoute Restore Fails:   %d%s (these break internet!)\n",
+					colorRed, result.RouteRestoreFailures, colorReset)
+			} else {
+				fmt.Printf("  Route Restore Fails:  %s0%s\n", colorGreen, colorReset)
+			}
+
+			if result.LastCrash != nil {
+				fmt.Println()
+				fmt.Println("Last Crash/Shutdown")
+				fmt.Println("────────────────────────────────────────")
+				fmt.Printf("  Time:           %s\n", result.LastCrash.Timestamp)
+				fmt.Printf("  Event:          %s\n", result.LastCrash.Event)
+				// Panics record "panic: <value>" followed by the stack trace
+				reason, stack, _ := strings.Cut(result.LastCrash.Reason, "\n")
+				fmt.Printf("  Reason:         %s\n", reason)
+				fmt.Printf("  Uptime:         %s\n", formatUptime(result.LastCrash.UptimeSeconds))
+				fmt.Printf("  Route-All:      %v\n", result.LastCrash.RouteAll)
+				if result.LastCrash.RouteAll {
+					if result.LastCrash.RouteRestored {
+						fmt.Printf("  Routes:         %sRestored%s\n", colorGreen, colorReset)
+					} else {
+						fmt.Printf("  Routes:         %sNOT RESTORED%s (internet was broken!)\n", colorRed, colorReset)
+					}
+				}
+				fmt.Printf("  Version:        %s\n", result.LastCrash.Version)
+				if stack != "" {
+					fmt.Println()
+					fmt.Printf("%s%s%s\n", colorGray, strings.TrimRight(stack, "\n"), colorReset)
+				}
+			} else {
+				fmt.Println()
+				fmt.Println("No crashes recorded in this time period.")
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&since, "since", "-24h", "Start time (Splunk-like: -1h, -24h, -7d, @d)")
+	cmd.Flags().StringVar(&until, "until", "now", "End time (Splunk-like: -1d, @d, now)")
+	cmd.Flags().BoolVar(&outputJSON, "json", false, "Output as JSON")
+
+	return cmd
+}
+
+func lifecycleCmd() *cobra.Command {
+	var limit int
+	var outputJSON bool
+	var format string
+	var events []string
+	var since, until string
+	var follow bool
+
+	cmd := &cobra.Command{
+		Use:     "lifecycle",
+		Aliases: []string{"events", "history"},
+		Short:   "Show recent lifecycle events (starts, stops, crashes)",
+		Long: `Show recent lifecycle events for the VPN node.
+
+Events include:
+- START: Node started
+- STOP: Clean shutdown
+- SIGNAL: Shutdown due to signal (SIGTERM, SIGINT)
+- CONNECTION_LOST: Connection to server was lost
+- RECONNECT_ATTEMPT: A reconnect attempt failed (with the error)
+- CRASH: Unexpected termination
+- ROLLBACK: Reverted to the version before the last deploy
+- DEGRADED: A peer link crossed the RTT or loss alert threshold
+- RECOVERED: A degraded peer link is back within thresholds
+
+Time bounds use the same Splunk-like syntax as 'vpn logs'.
+
+Examples:
+  vpn lifecycle                        # Show last 20 events
+  vpn lifecycle --limit=50             # Show last 50 events
+  vpn lifecycle --event CRASH,SIGNAL   # Only crashes and signals
+  vpn lifecycle --since -7d --until -1d
+  vpn events --follow                  # Print new events as they happen
+  vpn lifecycle --format=json          # JSON output for scripting (same as --json)`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if follow && until != "" {
+				return fmt.Errorf("--follow cannot be combined with --until")
+			}
+			switch format {
+			case "text":
+			case "json":
+				outputJSON = true
+			default:
+				return fmt.Errorf("invalid format %q (use text or json)", format)
+			}
+
+			client, err := cli.NewClient(nodeAddr)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			params := protocol.LifecycleParams{
+				Limit:  limit,
+				Events: events,
+				Since:  since,
+				Until:  until,
+			}
+			result, err := client.Lifecycle(params)
+			if err != nil {
+				return err
+			}
+
+			if outputJSON && !follow {
+				output, err := json.MarshalIndent(result, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(output))
+				return nil
+			}
+
+			// Following prints oldest first so new events append at the bottom.
+			if follow {
+				for i, j := 0, len(result.Events)-1; i < j; i, j = i+1, j-1 {
+					result.Events[i], result.Events[j] = result.Events[j], result.Events[i]
+				}
+			}
+
+			printEvent := printLifecycleEvent
+			if outputJSON {
+				// One JSON object per line while following (NDJSON).
+				printEvent = func(e protocol.LifecycleEvent) {
+					line, _ := json.Marshal(e)
+					fmt.Println(string(line))
+				}
+			} else {
+				fmt.Println("\nLifecycle Events")
+				fmt.Println("────────────────────────────────────────────────────────────────────────────")
+				fmt.Printf("%-20s %-15s %-12s %-8s %s\n", "TIMESTAMP", "EVENT", "UPTIME", "ROUTES", "REASON")
+				fmt.Println("────────────────────────────────────────────────────────────────────────────")
+			}
+
+			var lastID int64
+			for _, e := range result.Events {
+				printEvent(e)
+				if e.ID > lastID {
+					lastID = e.ID
+				}
+			}
+
+			if !follow {
+				return nil
+			}
+
+			ticker := time.NewTicker(2 * time.Second)
+			defer ticker.Stop()
+
+			for range ticker.C {
+				params.Since = ""
+				params.AfterID = lastID
+				params.Limit = 100
+				result, err := client.Lifecycle(params)
+				if err != nil {
+					return err
+				}
+				for i := len(result.Events) - 1; i >= 0; i-- {
+					e := result.Events[i]
+					printEvent(e)
+					if e.ID > lastID {
+						lastID = e.ID
+					}
+				}
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().IntVar(&limit, "limit", 20, "Maximum number of events to show")
+	cmd.Flags().BoolVar(&outputJSON, "json", false, "Output as JSON (same as --format=json)")
+	cmd.Flags().StringVar(&format, "format", "text", "Output format (text, json)")
+	cmd.Flags().StringSliceVar(&events, "event", nil, "Only show these event types (e.g. CRASH,SIGNAL)")
+	cmd.Flags().StringVar(&since, "since", "", "Only show events after this time (e.g. -24h, @d)")
+	cmd.Flags().StringVar(&until, "until", "", "Only show events before this time")
+	cmd.Flags().BoolVarP(&follow, "follow", "f", false, "Keep running and print new events as they are recorded")
+
+	return cmd
+}
+
+// printLifecycleEvent prints one row of the 'vpn lifecycle' table.
+func printLifecycleEvent(e protocol.LifecycleEvent) {
+	// Parse and format timestamp
+	ts, _ := time.Parse(time.RFC3339, e.Timestamp)
+	tsStr := ts.Format("2006-01-02 15:04:05")
+
+	// Color the event
+	eventColor := ""
+	switch e.Event {
+	case "START", "RECOVERED":
+		eventColor = colorGreen
+	case "STOP":
+		eventColor = colorBlue
+	case "SIGNAL", "ROLLBACK", "DEGRADED", "RECONNECT_ATTEMPT":
+		eventColor = colorYellow
+	case "CONNECTION_LOST", "CRASH":
+		eventColor = colorRed
+	case "AUTOFIX":
+		eventColor = colorCyan
+	}
+
+	routeStatus := "-"
+	if e.RouteAll {
+		if e.RouteRestored {
+			routeStatus = colorGreen + "OK" + colorReset
+		} else {
+			routeStatus = colorRed + "FAILED" + colorReset
+		}
+	}
+
+	fmt.Printf("%-20s %s%-15s%s %-12s %-8s %s\n",
+		tsStr,
+		eventColor, e.Event, colorReset,
+		formatUptime(e.UptimeSeconds),
+		routeStatus,
+		truncate(e.Reason, 30))
+}
+
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max-3] + "..."
+}
+
+// handshakeCmd sends an install handshake to the server.
+func handshakeCmd() *cobra.Command {
+	var (
+		nodeName string
+		version  string
+		repeat   time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "handshake",
+		Short: "Send install handshake to server",
+		Long: `Send an install handshake to the VPN server.
+
+This command is typically called by install.sh after installation
+to register the client with the server and test connectivity.
+
+With --repeat it keeps running for laptops that roam between networks:
+every interval it looks up the public IP again and re-registers only if
+it changed. A change of the active network interface (polled with
+'scutil --nwi' on macOS, 'ip route show default' on Linux) triggers an
+immediate handshake.
+
+Examples:
+  vpn handshake                 # Register once
+  vpn handshake --repeat=5m     # Re-register when the public IP changes`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repeat != 0 && repeat < 30*time.Second {
+				return fmt.Errorf("--repeat must be at least 30s")
+			}
+
+			// Try to get public IP
+			publicIP, _ := diagnose.PublicIP()
+			if err := sendHandshake(nodeName, version, publicIP); err != nil || repeat == 0 {
+				return err
+			}
+
+			sigCh := make(chan os.Signal, 1)
+			signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+			defer signal.Stop(sigCh)
+
+			ticker := time.NewTicker(repeat)
+			defer ticker.Stop()
+			netTicker := time.NewTicker(5 * time.Second)
+			defer netTicker.Stop()
+
+			lastIP := publicIP
+			lastNet := networkSignature()
+			fmt.Printf("%sRe-checking every %s (Ctrl-C to stop)%s\n", colorGray, repeat, colorReset)
+
+			for {
+				force := false
+				select {
+				case <-sigCh:
+					return nil
+				case <-ticker.C:
+				case <-netTicker.C:
+					sig := networkSignature()
+					if sig == lastNet {
+						continue
+					}
+					lastNet = sig
+					force = true
+					fmt.Printf("\n%s Network changed, re-sending handshake%s\n", colorYellow, colorReset)
+				}
+
+				ip, err := diagnose.PublicIP()
+				if err != nil {
+					fmt.Printf("%s %v, will retry%s\n", colorGray, err, colorReset)
+					continue
+				}
+				if ip == lastIP && !force {
+					continue
+				}
+				if ip != lastIP {
+					fmt.Printf("\n%s Public IP changed: %s -> %s%s\n", colorYellow, lastIP, ip, colorReset)
+				}
+
+				// Keep running through failures (e.g. the node is restarting
+				// after the network change); the next change retries
+				if err := sendHandshake(nodeName, version, ip); err != nil {
+					fmt.Printf("%s Handshake failed: %v%s\n", colorRed, err, colorReset)
+					continue
+				}
+				lastIP = ip
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&nodeName, "name", "", "Node name (default: from status)")
+	cmd.Flags().StringVar(&version, "version", "", "Version string (default: from status)")
+	cmd.Flags().DurationVar(&repeat, "repeat", 0, "Keep running and re-send the handshake when the public IP changes, checking at this interval (e.g. 5m)")
+
+	return cmd
+}
+
+// sendHandshake runs the ping and SSH tests against the server and sends
+// the install handshake through the local node.
+func sendHandshake(nodeName, version, publicIP string) error {
+	client, err := cli.NewClient(nodeAddr)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// Get hostname
+	hostname, _ := os.Hostname()
+
+	// Get status for VPN address
+	status, err := client.Status()
+	if err != nil {
+		return fmt.Errorf("failed to get status: %w", err)
+	}
+
+	if nodeName == "" {
+		nodeName = status.NodeName
+	}
+	if version == "" {
+		version = status.Version
+	}
+
+	serverIP := status.ServerIP
+	if serverIP == "" {
+		serverIP = tunnel.DefaultServerIP
+	}
+
+	// Run ping test to server
+	pingOK := false
+	pingMS := 0
+	if pingOut, err := exec.Command("ping", "-c", "1", "-W", "2", serverIP).Output(); err == nil {
+		pingOK = true
+		// Extract time from ping output
+		if strings.Contains(string(pingOut), "time=") {
+			parts := strings.Split(string(pingOut), "time=")
+			if len(parts) > 1 {
+				timePart := strings.Split(parts[1], " ")[0]
+				var ms float64
+				fmt.Sscanf(timePart, "%f", &ms)
+				pingMS = int(ms)
+			}
+		}
+	}
+
+	// Run SSH test - try to connect to server port 22
+	sshOK := false
+	sshErr := ""
+	conn, err := dialWithTimeout("tcp", net.JoinHostPort(serverIP, "22"), 3*time.Second)
+	if err != nil {
+		sshErr = err.Error()
+	} else {
+		sshOK = true
+		conn.Close()
+	}
+
+	handshake := protocol.InstallHandshake{
+		NodeName:     nodeName,
+		VPNAddress:   status.VPNAddress,
+		PublicIP:     publicIP,
+		Hostname:     hostname,
+		OS:           runtime.GOOS,
+		Arch:         runtime.GOARCH,
+		Version:      version,
+		GoVersion:    runtime.Version(),
+		InstallTS:    time.Now().Format(time.RFC3339),
+		SSHTestOK:    sshOK,
+		SSHTestError: sshErr,
+		PingTestOK:   pingOK,
+		PingTestMS:   pingMS,
+	}
+
+	result, err := client.SendHandshake(handshake)
+	if err != nil {
+		return err
+	}
+
+	if result.Success {
+		fmt.Printf("%s Handshake Sent%s\n", colorGreen, colorReset)
+		fmt.Println("────────────────────────────────────────")
+		fmt.Printf("  Node:     %s\n", handshake.NodeName)
+		fmt.Printf("  VPN IP:   %s\n", handshake.VPNAddress)
+		fmt.Printf("  Public:   %s\n", handshake.PublicIP)
+		fmt.Printf("  Version:  %s\n", handshake.Version)
+		fmt.Printf("  Ping:     %v (%d ms)\n", handshake.PingTestOK, handshake.PingTestMS)
+		fmt.Printf("  SSH:      %v\n", handshake.SSHTestOK)
+		fmt.Printf("  Recorded: %v\n", result.Recorded)
+		fmt.Printf("  Server:   %s\n", result.ServerVer)
+	} else {
+		fmt.Printf("%s Handshake Failed%s\n", colorRed, colorReset)
+		fmt.Println(result.Message)
+	}
+
+	return nil
+}
+
+// selftestCmd waits until the local node is connected and the tunnel
+// carries traffic, for the installer.
+func selftestCmd() *cobra.Command {
+	var timeout time.Duration
+	var routeAll bool
+
+	cmd := &cobra.Command{
+		Use:   "selftest",
+		Short: "Wait until the node is connected and the tunnel works",
+		Long: `Wait until the local node is connected and the tunnel carries traffic,
+for installers and scripts. The checks are repeated every second until
+they all pass or --timeout expires:
+
+  1. The node answers on its control socket
+  2. It is connected to the server with a VPN IP (skipped on the server)
+  3. The server's VPN IP (10.8.0.1) answers ping through the tunnel
+  4. Route-all is active (only with --route-all)
+
+Exit codes, from the first check still failing at the timeout:
+  0  Healthy
+  1  Node not responding
+  2  Not connected to the server
+  3  Server does not answer ping
+  4  Route-all not active
+
+Examples:
+  vpn selftest                          # Wait up to 60s
+  vpn selftest --timeout=2m --route-all
+  vpn selftest || echo "VPN is not healthy"`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fmt.Printf("Waiting for the node to become healthy (timeout %s)...\n", timeout)
+
+			deadline := time.Now().Add(timeout)
+			result := runSelftest(routeAll)
+			for result.code != 0 && time.Now().Add(time.Second).Before(deadline) {
+				time.Sleep(time.Second)
+				result = runSelftest(routeAll)
+			}
+
+			for _, line := range result.passed {
+				fmt.Printf("%s✓%s %s\n", colorGreen, colorReset, line)
+			}
+			if result.code != 0 {
+				// Exit directly: the code tells the installer what failed
+				fmt.Fprintf(os.Stderr, "%s✗ %s%s\n", colorRed, result.reason, colorReset)
+				os.Exit(result.code)
+			}
+			fmt.Printf("%sHealthy%s\n", colorGreen, colorReset)
+			return nil
+		},
+	}
+
+	cmd.Flags().DurationVar(&timeout, "timeout", 60*time.Second, "How long to wait for the node to become healthy")
+	cmd.Flags().BoolVar(&routeAll, "route-all", false, "Also require route-all (all traffic through the VPN) to be active")
+
+	return cmd
+}
+
+// selftestResult is the outcome of one 'vpn selftest' attempt: the checks
+// that passed, and the exit code and reason of the first that failed.
+type selftestResult struct {
+	passed []string
+	code   int
+	reason string
+}
+
+// runSelftest runs the 'vpn selftest' checks once, in order, stopping at
+// the first failure.
+func runSelftest(routeAll bool) selftestResult {
+	var r selftestResult
+	fail := func(code int, format string, args ...interface{}) selftestResult {
+		r.code = code
+		r.reason = fmt.Sprintf(format, args...)
+		return r
+	}
+
+	client, err := cli.NewClient(nodeAddr)
+	if err != nil {
+		return fail(1, "node not responding on %s: %v", nodeAddr, err)
+	}
+	defer client.Close()
+
+	status, err := client.Status()
+	if err != nil {
+		return fail(1, "node not responding: %v", err)
+	}
+	r.passed = append(r.passed, fmt.Sprintf("Node %s (version %s) is running", status.NodeName, status.Version))
+	if status.ServerMode {
+		r.passed = append(r.passed, "Server mode: no connection to check")
+		return r
+	}
+
+	conn, err := client.ConnectionStatus()
+	if err != nil {
+		return fail(2, "connection status unavailable: %v", err)
+	}
+	if !conn.Connected || conn.VPNAddress == "" {
+		if conn.KillSwitchEngaged {
+			return fail(2, "not connected to the server (kill switch engaged)")
+		}
+		return fail(2, "not connected to the server")
+	}
+	r.passed = append(r.passed, fmt.Sprintf("Connected to %s as %s", conn.ServerAddr, conn.VPNAddress))
+
+	serverIP := status.ServerIP
+	if serverIP == "" {
+		serverIP = tunnel.DefaultServerIP
+	}
+	rtt, err := pingOnce(serverIP)
+	if err != nil {
+		return fail(3, "server %s does not answer ping through the tunnel", serverIP)
+	}
+	if rtt != "" {
+		r.passed = append(r.passed, fmt.Sprintf("Server %s answers ping (%s ms)", serverIP, rtt))
+	} else {
+		r.passed = append(r.passed, fmt.Sprintf("Server %s answers ping", serverIP))
+	}
+
+	if routeAll {
+		if !conn.RouteAll {
+			return fail(4, "route-all is not active (traffic does not go through the VPN)")
+		}
+		r.passed = append(r.passed, "Route-all is active")
+	}
+	return r
+}
+
+// pingOnce sends one ping to ip, waiting up to 2 seconds, and returns the
+// round-trip time in ms from its output ("" if not shown).
+func pingOnce(ip string) (string, error) {
+	timeout := "-W" // Seconds on Linux, but milliseconds on macOS
+	if runtime.GOOS == "darwin" {
+		timeout = "-t"
+	}
+	out, err := exec.Command("ping", "-c", "1", timeout, "2", ip).Output()
+	if err != nil {
+		return "", err
+	}
+	if _, after, ok := strings.Cut(string(out), "time="); ok {
+		if fields := strings.Fields(after); len(fields) > 0 {
+			return fields[0], nil
+		}
+	}
+	return "", nil
+}
+
+// networkSignature summarizes the active network interfaces and their
+// addresses, so a change (e.g. Wi-Fi to Ethernet, or another Wi-Fi) can be
+// noticed by polling. Returns "" where it cannot be determined.
+func networkSignature() string {
+	switch runtime.GOOS {
+	case "darwin":
+		out, err := exec.Command("scutil", "--nwi").Output()
+		if err != nil {
+			return ""
+		}
+		var sig []string
+		for _, line := range strings.Split(string(out), "\n") {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "Network interfaces:") || strings.HasPrefix(line, "address") {
+				sig = append(sig, line)
+			}
+		}
+		return strings.Join(sig, "\n")
+	case "linux":
+		out, err := exec.Command("ip", "route", "show", "default").Output()
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(out))
+	}
+	return ""
+}
+
+// handshakesCmd shows handshake history.
+func handshakesCmd() *cobra.Command {
+	var (
+		nodeName   string
+		osName     string
+		failed     bool
+		limit      int
+		outputJSON bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "handshakes",
+		Short: "Show install handshake history",
+		Long: `Show the history of install handshakes from all clients.
+
+Each install reports whether the server answered a ping and accepted SSH.
+Failed tests are shown in red, with the SSH error below the row.
+
+Examples:
+  vpn handshakes                      # All recent installs
+  vpn handshakes --failed             # Only installs whose ping or SSH test failed
+  vpn handshakes --failed --os linux  # Broken Linux installs
+  vpn handshakes --filter-node mac-mini`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := cli.NewClient(nodeAddr)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			history, err := client.HandshakeHistory(protocol.HandshakeHistoryParams{
+				NodeName: nodeName,
+				OS:       osName,
+				Failed:   failed,
+				Limit:    limit,
+			})
+			if err != nil {
+				return err
+			}
+
+			if outputJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(history)
+			}
+
+			if len(history.Entries) == 0 {
+				if failed || osName != "" || nodeName != "" {
+					fmt.Println("No handshakes match the filters.")
+				} else {
+					fmt.Println("No handshakes recorded yet.")
+				}
+				return nil
+			}
+
+			title := "Install Handshakes"
+			if failed {
+				title = "Failed Install Handshakes"
+			}
+			fmt.Printf("%s (%d total)\n", title, history.Total)
+			fmt.Println("────────────────────────────────────────────────────────────────────────────")
+			fmt.Printf("%-20s %-15s %-12s %-10s %-8s %-4s %-4s\n",
+				"TIMESTAMP", "NODE", "VPN IP", "VERSION", "OS", "PING", "SSH")
+			fmt.Println("────────────────────────────────────────────────────────────────────────────")
+
+			for _, h := range history.Entries {
+				ts, _ := time.Parse(time.RFC3339, h.Timestamp)
+				tsStr := ts.Local().Format("2006-01-02 15:04")
+
+				pingStr := colorRed + "FAIL" + colorReset
+				if h.PingTestOK {
+					pingStr = colorGreen + fmt.Sprintf("%dms", h.PingTestMS) + colorReset
+				}
+
+				sshStr := colorRed + "FAIL" + colorReset
+				if h.SSHTestOK {
+					sshStr = colorGreen + "OK" + colorReset
+				}
+
+				ver := h.Version
+				if len(ver) > 7 {
+					ver = ver[:7]
+				}
+
+				fmt.Printf("%-20s %-15s %-12s %-10s %-8s %-4s %-4s\n",
+					tsStr,
+					truncate(h.NodeName, 15),
+					h.VPNAddress,
+					ver,
+					h.OS+"/"+h.Arch,
+					pingStr,
+					sshStr)
+				if !h.SSHTestOK && h.SSHTestError != "" {
+					fmt.Printf("  %s└ ssh: %s%s\n", colorGray, h.SSHTestError, colorReset)
 				}
-			} else {
-				fmt.Printf("%s Connection Failed%s\n", colorRed, colorReset)
-				fmt.Println("────────────────────────────────────────")
-				fmt.Println(result.Message)
 			}
 
 			return nil
 		},
 	}
+
+	cmd.Flags().StringVar(&nodeName, "filter-node", "", "Filter by node name")
+	cmd.Flags().StringVar(&osName, "os", "", "Filter by OS (linux, darwin)")
+	cmd.Flags().BoolVar(&failed, "failed", false, "Only installs whose ping or SSH test failed")
+	cmd.Flags().IntVar(&limit, "limit", 50, "Maximum number of entries")
+	cmd.Flags().BoolVar(&outputJSON, "json", false, "Output as JSON")
+
+	return cmd
 }
 
-func disconnectCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "disconnect",
-		Short: "Disable VPN routing (restore direct traffic)",
-		Long: `Disable routing all traffic through the VPN connection.
+func qrCmd() *cobra.Command {
+	var (
+		out        string
+		size       int
+		server     string
+		outputJSON bool
+	)
 
-This command disables the --route-all mode, restoring direct internet
-connectivity while keeping the VPN tunnel active.
+	cmd := &cobra.Command{
+		Use:   "qr",
+		Short: "Print a QR code for installing a new client",
+		Long: `Print a QR code with what a new client needs to join this server.
 
-Note: This does NOT disconnect the VPN tunnel itself, only the route-all mode.`,
+The code holds a JSON blob with the server's public address (vpn-node
+--connect), the VPN IP the next new client will be assigned, the subnet
+(--vpn-subnet), the transport and the server's read-only control token,
+if one is configured. Scan it from a phone, or save it as a PNG to send
+along.
+
+The admin control token is never included, but the read-only token lets
+its holder query the server: only share the code with trusted devices.
+
+Examples:
+  vpn qr                              # Print the QR code in the terminal
+  vpn qr --out invite.png             # Also save it as a PNG
+  vpn qr --server vpn.example.com:443 # Override the advertised address
+  vpn qr --json                       # Print only the JSON payload`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			client, err := cli.NewClient(nodeAddr)
 			if err != nil {
@@ -698,37 +4619,608 @@ Note: This does NOT disconnect the VPN tunnel itself, only the route-all mode.`,
 			}
 			defer client.Close()
 
-			result, err := client.Disconnect()
+			invite, err := client.Invite()
 			if err != nil {
 				return err
 			}
+			if server != "" {
+				invite.Server = server
+			}
+			if invite.Server == "" {
+				return fmt.Errorf("server public address unknown (use --server host:port)")
+			}
 
-			if result.Success {
-				fmt.Printf("%s VPN Disconnected%s\n", colorYellow, colorReset)
-				fmt.Println("────────────────────────────────────────")
-				fmt.Println(result.Message)
-				if result.Status != nil {
-					fmt.Printf("  VPN IP:    %s\n", result.Status.VPNAddress)
-					fmt.Printf("  Server:    %s\n", result.Status.ServerAddr)
-					fmt.Printf("  Route All: %v\n", result.Status.RouteAll)
+			payload, err := json.Marshal(invite)
+			if err != nil {
+				return err
+			}
+
+			if outputJSON {
+				fmt.Println(string(payload))
+				return nil
+			}
+
+			code, err := qrcode.New(string(payload), qrcode.Medium)
+			if err != nil {
+				return fmt.Errorf("failed to encode QR code: %w", err)
+			}
+
+			printQRCode(code.Bitmap())
+			fmt.Println()
+			fmt.Printf("Server:      %s\n", invite.Server)
+			fmt.Printf("VPN address: %s\n", invite.VPNAddress)
+			fmt.Printf("Subnet:      %s\n", invite.Subnet)
+			if invite.Transport != "" {
+				fmt.Printf("Transport:   %s\n", invite.Transport)
+			}
+
+			if out != "" {
+				if err := code.WriteFile(size, out); err != nil {
+					return fmt.Errorf("failed to write %s: %w", out, err)
+				}
+				fmt.Printf("\n%s✓ Saved %s (%dx%d)%s\n", colorGreen, out, size, size, colorReset)
+			}
+
+			fmt.Printf("\n%sContains the shared secret: only share it with trusted devices.%s\n", colorYellow, colorReset)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&out, "out", "o", "", "Also write the QR code to this PNG file")
+	cmd.Flags().IntVar(&size, "size", 256, "PNG width and height in pixels")
+	cmd.Flags().StringVar(&server, "server", "", "Server address to advertise (default: detected public IP and port)")
+	cmd.Flags().BoolVar(&outputJSON, "json", false, "Print the JSON payload instead of the QR code")
+
+	return cmd
+}
+
+// printQRCode prints a QR bitmap with half-block characters, two modules
+// per character cell, in black on white so it scans on dark terminals too.
+func printQRCode(bitmap [][]bool) {
+	for y := 0; y < len(bitmap); y += 2 {
+		var line strings.Builder
+		line.WriteString("\033[30;47m")
+		for x := range bitmap[y] {
+			top := bitmap[y][x]
+			bottom := y+1 < len(bitmap) && bitmap[y+1][x]
+			switch {
+			case top && bottom:
+				line.WriteString("█")
+			case top:
+				line.WriteString("▀")
+			case bottom:
+				line.WriteString("▄")
+			default:
+				line.WriteString(" ")
+			}
+		}
+		line.WriteString(colorReset)
+		fmt.Println(line.String())
+	}
+}
+
+func exportCmd() *cobra.Command {
+	var earliest, latest, format, output, exportType, granularity string
+	var metrics []string
+	var maxRows int
+
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export logs and metrics to a file for offline analysis",
+		Long: `Export logs, metrics and lifecycle events for a time window, for
+offline analysis or ingestion into other tools (Grafana, Excel, etc.).
+
+Rows are streamed from the node and written as they arrive, so large
+exports are never held in memory. The node caps an export at 100000
+rows and warns when the result was truncated.
+
+Types:
+  logs       Log entries
+  metrics    Metric points (see --metric and --granularity)
+  lifecycle  Lifecycle events, plus crash stats for the window
+  (empty)    All of the above
+
+Formats:
+  json    One JSON document (default)
+  ndjson  One record per line, each tagged with a "type" field
+          (meta, log, metric, lifecycle, summary)
+  csv     One row per record with a header line (requires --type)
+
+Output is written to stdout unless --output is given. Output paths
+ending in .gz are gzip-compressed.
+
+Examples:
+  vpn export --earliest=-1h > node.json          # Everything, last hour
+  vpn export --earliest=-24h --output=node.json  # Last day to a file
+  vpn export --format=ndjson --output=node.ndjson.gz
+  vpn export --type=logs --format=csv --output=logs.csv
+  vpn export --type=metrics --metric=bandwidth.tx_current_bps --granularity=1m --format=csv
+  vpn --node 10.8.0.1:9001 export --earliest=-30m --latest=-10m`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var sections []string
+			switch exportType {
+			case "":
+				sections = []string{"logs", "metrics", "lifecycle"}
+			case "logs", "metrics", "lifecycle":
+				sections = []string{exportType}
+			default:
+				return fmt.Errorf("invalid type %q (use logs, metrics or lifecycle)", exportType)
+			}
+
+			var w io.Writer = os.Stdout
+			var file *os.File
+			var gz *gzip.Writer
+			if output != "" && output != "-" {
+				f, err := os.Create(output)
+				if err != nil {
+					return fmt.Errorf("failed to create %s: %w", output, err)
+				}
+				defer f.Close()
+				file = f
+				w = file
+
+				if strings.HasSuffix(output, ".gz") {
+					gz = gzip.NewWriter(file)
+					w = gz
+				}
+			}
+
+			var writer exportWriter
+			switch format {
+			case "json":
+				writer = &jsonExportWriter{w: w, sections: sections}
+			case "ndjson":
+				writer = &ndjsonExportWriter{w: w}
+			case "csv":
+				if exportType == "" {
+					return fmt.Errorf("csv export requires --type (logs, metrics or lifecycle)")
+				}
+				writer = &csvExportWriter{w: csv.NewWriter(w), exportType: exportType}
+			default:
+				return fmt.Errorf("invalid format %q (use json, ndjson or csv)", format)
+			}
+
+			client, err := cli.NewClient(nodeAddr)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			done, err := client.Export(protocol.ExportParams{
+				Earliest:    earliest,
+				Latest:      latest,
+				Type:        exportType,
+				Metrics:     metrics,
+				Granularity: granularity,
+				MaxRows:     maxRows,
+			}, func(chunk *protocol.ExportChunk) error {
+				if chunk.Header != nil {
+					return writer.Header(chunk.Header)
+				}
+				return writer.Row(chunk.Type, chunk.Row)
+			})
+			if err != nil {
+				return err
+			}
+
+			if err := writer.Finish(done); err != nil {
+				return fmt.Errorf("failed to write export: %w", err)
+			}
+
+			if gz != nil {
+				if err := gz.Close(); err != nil {
+					return fmt.Errorf("failed to write export: %w", err)
+				}
+			}
+
+			if file != nil {
+				if err := file.Close(); err != nil {
+					return fmt.Errorf("failed to write export: %w", err)
+				}
+				fmt.Fprintf(os.Stderr, "Exported %d rows to %s\n", done.Rows, output)
+			}
+
+			if done.Warning != "" {
+				fmt.Fprintf(os.Stderr, "%sWarning:%s %s\n", colorYellow, colorReset, done.Warning)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&earliest, "earliest", "-1h", "Start time (Splunk syntax: -1h, -30m, @d)")
+	cmd.Flags().StringVar(&latest, "latest", "now", "End time (Splunk syntax)")
+	cmd.Flags().StringVar(&exportType, "type", "", "What to export (logs, metrics, lifecycle; default all)")
+	cmd.Flags().StringSliceVar(&metrics, "metric", nil, "Specific metrics to export (metrics only)")
+	cmd.Flags().StringVar(&granularity, "granularity", "raw", "Metric granularity (raw, 1m, 1h, auto)")
+	cmd.Flags().StringVar(&format, "format", "json", "Output format (json, ndjson, csv)")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Output file (default: stdout, .gz for gzip)")
+	cmd.Flags().IntVar(&maxRows, "max-rows", 0, "Max rows to export (default and max 100000)")
+
+	return cmd
+}
+
+// exportWriter renders a streamed export in one output format.
+type exportWriter interface {
+	Header(h *protocol.ExportHeader) error
+	Row(rowType string, row json.RawMessage) error
+	Finish(done *protocol.ExportChunk) error
+}
+
+// exportSectionNames maps export row types to their section name.
+var exportSectionNames = map[string]string{
+	"log":       "logs",
+	"metric":    "metrics",
+	"lifecycle": "lifecycle",
+}
+
+// jsonExportWriter writes a single JSON document, one array per section,
+// emitting rows as they arrive.
+type jsonExportWriter struct {
+	w        io.Writer
+	sections []string // Sections to emit, in stream order
+	next     int      // Index of the next section to open
+	current  string   // Currently open section
+	rows     int      // Rows written to the current section
+}
+
+func (j *jsonExportWriter) Header(h *protocol.ExportHeader) error {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	// Open the document with the header fields, leaving it unterminated
+	_, err = fmt.Fprintf(j.w, "{%s", data[1:len(data)-1])
+	return err
+}
+
+func (j *jsonExportWriter) Row(rowType string, row json.RawMessage) error {
+	section := exportSectionNames[rowType]
+	for j.current != section {
+		if err := j.closeSection(); err != nil {
+			return err
+		}
+		if j.next >= len(j.sections) {
+			return fmt.Errorf("unexpected %s row in export", rowType)
+		}
+		if err := j.openSection(); err != nil {
+			return err
+		}
+	}
+
+	sep := ","
+	if j.rows == 0 {
+		sep = ""
+	}
+	j.rows++
+	_, err := fmt.Fprintf(j.w, "%s\n    %s", sep, row)
+	return err
+}
+
+func (j *jsonExportWriter) Finish(done *protocol.ExportChunk) error {
+	if err := j.closeSection(); err != nil {
+		return err
+	}
+	// Emit empty arrays for sections that had no rows
+	for j.next < len(j.sections) {
+		if err := j.openSection(); err != nil {
+			return err
+		}
+		if err := j.closeSection(); err != nil {
+			return err
+		}
+	}
+
+	summary, err := json.Marshal(struct {
+		Rows       int                        `json:"rows"`
+		Truncated  bool                       `json:"truncated"`
+		Warning    string                     `json:"warning,omitempty"`
+		CrashStats *protocol.CrashStatsResult `json:"crash_stats,omitempty"`
+	}{done.Rows, done.Truncated, done.Warning, done.CrashStats})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(j.w, ",%s}\n", summary[1:])
+	return err
+}
+
+func (j *jsonExportWriter) openSection() error {
+	j.current = j.sections[j.next]
+	j.next++
+	j.rows = 0
+	_, err := fmt.Fprintf(j.w, ",\n  %q: [", j.current)
+	return err
+}
+
+func (j *jsonExportWriter) closeSection() error {
+	if j.current == "" {
+		return nil
+	}
+	end := "]"
+	if j.rows > 0 {
+		end = "\n  ]"
+	}
+	j.current = ""
+	_, err := io.WriteString(j.w, end)
+	return err
+}
+
+// ndjsonExportWriter writes one JSON record per line, tagged with its type.
+type ndjsonExportWriter struct {
+	w io.Writer
+}
+
+func (n *ndjsonExportWriter) Header(h *protocol.ExportHeader) error {
+	return n.writeTagged("meta", h)
+}
+
+func (n *ndjsonExportWriter) Row(rowType string, row json.RawMessage) error {
+	tag, _ := json.Marshal(rowType)
+	if len(row) < 2 || row[0] != '{' {
+		return fmt.Errorf("unexpected %s row in export", rowType)
+	}
+	if len(row) == 2 {
+		_, err := fmt.Fprintf(n.w, "{\"type\":%s}\n", tag)
+		return err
+	}
+	_, err := fmt.Fprintf(n.w, "{\"type\":%s,%s\n", tag, row[1:])
+	return err
+}
+
+func (n *ndjsonExportWriter) Finish(done *protocol.ExportChunk) error {
+	return n.writeTagged("summary", struct {
+		Rows       int                        `json:"rows"`
+		Truncated  bool                       `json:"truncated"`
+		Warning    string                     `json:"warning,omitempty"`
+		CrashStats *protocol.CrashStatsResult `json:"crash_stats,omitempty"`
+	}{done.Rows, done.Truncated, done.Warning, done.CrashStats})
+}
+
+func (n *ndjsonExportWriter) writeTagged(rowType string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return n.Row(rowType, data)
+}
+
+// csvExportWriter writes one CSV row per record for a single export type.
+type csvExportWriter struct {
+	w          *csv.Writer
+	exportType string
+}
+
+func (c *csvExportWriter) Header(h *protocol.ExportHeader) error {
+	switch c.exportType {
+	case "logs":
+		return c.w.Write([]string{"id", "timestamp", "level", "component", "message", "fields"})
+	case "metrics":
+		return c.w.Write([]string{"timestamp", "name", "value", "granularity", "tags"})
+	default:
+		return c.w.Write([]string{"id", "timestamp", "event", "reason", "uptime_seconds", "route_all", "route_restored", "version"})
+	}
+}
+
+func (c *csvExportWriter) Row(rowType string, row json.RawMessage) error {
+	var record []string
+	switch rowType {
+	case "log":
+		var e protocol.LogEntry
+		if err := json.Unmarshal(row, &e); err != nil {
+			return err
+		}
+		record = []string{fmt.Sprint(e.ID), e.Timestamp, e.Level, e.Component, e.Message, e.Fields}
+	case "metric":
+		var p protocol.MetricPoint
+		if err := json.Unmarshal(row, &p); err != nil {
+			return err
+		}
+		record = []string{p.Timestamp, p.Name, strconv.FormatFloat(p.Value, 'f', -1, 64), p.Granularity, p.Tags}
+	case "lifecycle":
+		var e protocol.LifecycleEvent
+		if err := json.Unmarshal(row, &e); err != nil {
+			return err
+		}
+		record = []string{fmt.Sprint(e.ID), e.Timestamp, e.Event, e.Reason, strconv.FormatFloat(e.UptimeSeconds, 'f', -1, 64),
+			fmt.Sprint(e.RouteAll), fmt.Sprint(e.RouteRestored), e.Version}
+	default:
+		return fmt.Errorf("unexpected %s row in export", rowType)
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvExportWriter) Finish(done *protocol.ExportChunk) error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// topSamples is how many raw bandwidth samples the top sparklines show.
+const topSamples = 60
+
+func topCmd() *cobra.Command {
+	var interval time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "top",
+		Short: "Live full-screen view of bandwidth and peers",
+		Long: `Show a live, htop-style view of the node without opening the web UI.
+
+Displays current bandwidth, peer count, total bytes in/out and sparklines
+of the last 60 raw bandwidth samples. Press q or Ctrl-C to exit.
+
+Examples:
+  vpn top
+  vpn top --interval=5s
+  vpn --node 10.8.0.1:9001 top`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := cli.NewClient(nodeAddr)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			// Read keys without waiting for Enter, hide the cursor, and undo both on exit
+			restoreTerminal := rawTerminal()
+			fmt.Print("\033[?25l")
+			defer func() {
+				fmt.Print("\033[?25h\n")
+				restoreTerminal()
+			}()
+
+			sigCh := make(chan os.Signal, 1)
+			signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+			defer signal.Stop(sigCh)
+
+			keys := make(chan byte)
+			go func() {
+				buf := make([]byte, 1)
+				for {
+					if _, err := os.Stdin.Read(buf); err != nil {
+						return
+					}
+					keys <- buf[0]
+				}
+			}()
+
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for {
+				if err := drawTop(client); err != nil {
+					return err
+				}
+
+				select {
+				case <-sigCh:
+					return nil
+				case key := <-keys:
+					if key == 'q' || key == 'Q' || key == 3 { // 3 = Ctrl-C when signals are off
+						return nil
+					}
+				case <-ticker.C:
 				}
-			} else {
-				fmt.Printf("%s Disconnect Failed%s\n", colorRed, colorReset)
-				fmt.Println("────────────────────────────────────────")
-				fmt.Println(result.Message)
 			}
+		},
+	}
+
+	cmd.Flags().DurationVar(&interval, "interval", time.Second, "Refresh interval")
+
+	return cmd
+}
+
+// rawTerminal switches stdin to unbuffered, no-echo mode via stty and
+// returns a function that restores the previous settings. It is a no-op
+// when stdin is not a terminal.
+func rawTerminal() func() {
+	stty := func(args ...string) (string, error) {
+		c := exec.Command("stty", args...)
+		c.Stdin = os.Stdin
+		out, err := c.Output()
+		return strings.TrimSpace(string(out)), err
+	}
+
+	saved, err := stty("-g")
+	if err != nil {
+		return func() {}
+	}
+	if _, err := stty("-icanon", "-echo", "min", "1"); err != nil {
+		return func() {}
+	}
+	return func() { stty(saved) }
+}
+
+// drawTop redraws the full-screen top view.
+func drawTop(client *cli.Client) error {
+	status, err := client.Status()
+	if err != nil {
+		return err
+	}
+
+	stats, err := client.Stats(protocol.StatsParams{
+		Earliest:    "-5m",
+		Metrics:     []string{"bandwidth.tx_current_bps", "bandwidth.rx_current_bps"},
+		Granularity: "raw",
+	})
+	if err != nil {
+		return err
+	}
+
+	samples := make(map[string][]float64)
+	for _, s := range stats.Series {
+		points := s.Points
+		if len(points) > topSamples {
+			points = points[len(points)-topSamples:]
+		}
+		for _, p := range points {
+			samples[s.Name] = append(samples[s.Name], p.Value)
+		}
+	}
+	tx := stats.Summary["bandwidth.tx_current_bps"]
+	rx := stats.Summary["bandwidth.rx_current_bps"]
+
+	var b strings.Builder
+	b.WriteString("\033[H\033[2J") // Cursor home, clear screen
+	fmt.Fprintf(&b, "%svpn top%s - %s (%s)  up %s  %s\n\n",
+		colorCyan, colorReset, status.NodeName, status.VPNAddress, status.UptimeStr, time.Now().Format("15:04:05"))
+	fmt.Fprintf(&b, "  %-12s %d\n", "Peers:", status.PeerCount)
+	fmt.Fprintf(&b, "  %-12s %s\n", "Bytes in:", formatBytes(status.BytesIn))
+	fmt.Fprintf(&b, "  %-12s %s\n\n", "Bytes out:", formatBytes(status.BytesOut))
+	fmt.Fprintf(&b, "  %-12s %s%-12s%s %s\n", "TX:", colorGreen, formatBandwidth(tx), colorReset,
+		sparkline(samples["bandwidth.tx_current_bps"]))
+	fmt.Fprintf(&b, "  %-12s %s%-12s%s %s\n\n", "RX:", colorBlue, formatBandwidth(rx), colorReset,
+		sparkline(samples["bandwidth.rx_current_bps"]))
+	fmt.Fprintf(&b, "%sLast %d samples. Press q to quit.%s\n", colorGray, topSamples, colorReset)
+
+	fmt.Print(b.String())
+	return nil
+}
+
+// sparkline renders values as a row of block characters scaled to the maximum.
+func sparkline(values []float64) string {
+	if len(values) == 0 {
+		return colorGray + "(no samples)" + colorReset
+	}
+
+	blocks := []rune("▁▂▃▄▅▆▇█")
+	max := 0.0
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+
+	var b strings.Builder
+	for _, v := range values {
+		idx := 0
+		if max > 0 {
+			idx = int(v / max * float64(len(blocks)-1))
+		}
+		b.WriteRune(blocks[idx])
+	}
+	return b.String()
+}
+
+func trafficCmd() *cobra.Command {
+	var (
+		earliest   string
+		latest     string
+		limit      int
+		outputJSON bool
+	)
 
-			return nil
-		},
-	}
-}
+	cmd := &cobra.Command{
+		Use:   "traffic",
+		Short: "Show the remote hosts VPN traffic goes to",
+		Long: `Show the remote hosts VPN clients exchanged the most bytes with.
 
-func connectionStatusCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:     "connection-status",
-		Aliases: []string{"conn-status", "cs"},
-		Short:   "Show VPN connection status",
-		Long:    `Show the current VPN connection status including whether route-all is enabled.`,
+The server counts bytes per remote IP address as it forwards packets and
+records the top talkers every minute; the bytes of all other hosts are
+summed as "other". On a client the query is answered by the server.
+
+Examples:
+  vpn traffic                     # Top 10 over the last hour
+  vpn traffic --earliest=-24h --limit=25
+  vpn traffic --earliest=@d --json`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			client, err := cli.NewClient(nodeAddr)
 			if err != nil {
@@ -736,292 +5228,420 @@ func connectionStatusCmd() *cobra.Command {
 			}
 			defer client.Close()
 
-			status, err := client.ConnectionStatus()
+			result, err := client.Traffic(protocol.TrafficParams{
+				Earliest: earliest,
+				Latest:   latest,
+				Limit:    limit,
+			})
 			if err != nil {
 				return err
 			}
 
-			fmt.Println("\nVPN Connection Status")
-			fmt.Println("────────────────────────────────────────")
-
-			if status.Connected {
-				fmt.Printf("  Status:    %sConnected%s\n", colorGreen, colorReset)
-			} else {
-				fmt.Printf("  Status:    %sDisconnected%s\n", colorRed, colorReset)
+			if outputJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(result)
 			}
 
-			fmt.Printf("  VPN IP:    %s\n", status.VPNAddress)
-			fmt.Printf("  Server:    %s\n", status.ServerAddr)
+			if len(result.Destinations) == 0 {
+				fmt.Println("No traffic recorded for the specified time range.")
+				return nil
+			}
 
-			if status.RouteAll {
-				fmt.Printf("  Route All: %sEnabled%s (all traffic through VPN)\n", colorGreen, colorReset)
-			} else {
-				fmt.Printf("  Route All: %sDisabled%s (direct traffic)\n", colorYellow, colorReset)
+			var total uint64
+			for _, t := range result.Destinations {
+				total += t.Bytes
 			}
 
-			if status.ConnectedAt != "" {
-				fmt.Printf("  Since:     %s\n", status.ConnectedAt)
+			start, _ := time.Parse(time.RFC3339, result.Start)
+			end, _ := time.Parse(time.RFC3339, result.End)
+			fmt.Printf("Top Destinations (%s to %s)\n",
+				start.Local().Format("2006-01-02 15:04"), end.Local().Format("2006-01-02 15:04"))
+			fmt.Println("────────────────────────────────────────────────────────────────")
+			fmt.Printf("%-40s %10s %10s %10s\n", "DESTINATION", "SENT", "RECEIVED", "TOTAL")
+			fmt.Println("────────────────────────────────────────────────────────────────")
+			for _, t := range result.Destinations {
+				dest := t.Dest
+				if dest == "other" {
+					dest = colorGray + fmt.Sprintf("%-40s", dest) + colorReset
+				} else {
+					dest = fmt.Sprintf("%-40s", dest)
+				}
+				fmt.Printf("%s %10s %10s %10s\n", dest,
+					formatBytes(t.BytesOut), formatBytes(t.BytesIn), formatBytes(t.Bytes))
 			}
+			fmt.Println("────────────────────────────────────────────────────────────────")
+			fmt.Printf("%-40s %32s\n", "", formatBytes(total))
 
 			return nil
 		},
 	}
-}
-
-func sshCmd() *cobra.Command {
-	var user, password string
-	var execSSH bool
 
-	cmd := &cobra.Command{
-		Use:   "ssh [peer]",
-		Short: "SSH to a peer via VPN",
-		Long: `SSH to a peer in the VPN network.
+	cmd.Flags().StringVar(&earliest, "earliest", "-1h", "Start time (Splunk syntax: -1h, -24h, @d)")
+	cmd.Flags().StringVar(&latest, "latest", "now", "End time (Splunk syntax)")
+	cmd.Flags().IntVar(&limit, "limit", 10, "Number of destinations to show")
+	cmd.Flags().BoolVar(&outputJSON, "json", false, "Output as JSON")
 
-The peer can be specified by:
-  - Name (e.g., "mac-mini", "server")
-  - VPN IP address (e.g., "10.8.0.1")
+	return cmd
+}
 
-If no peer is specified, shows an interactive menu to select a peer.
+func topologyCmd() *cobra.Command {
+	var watch bool
 
-The command will look up the peer's VPN address and construct the SSH command.
-Use --exec to actually run SSH (requires sshpass to be installed).
+	cmd := &cobra.Command{
+		Use:   "topology",
+		Short: "Show the mesh topology",
+		Long: `Show every node known to the mesh with its hop count, latency and
+measured bandwidth.
 
-Family password: osopanda
+With --watch the node pushes the topology whenever it changes (a peer
+joins or leaves, latency or location updates) and the table is redrawn.
+Press Ctrl-C to exit.
 
 Examples:
-  vpn ssh                         # Interactive peer selection
-  vpn ssh mac-mini                # Show SSH command for mac-mini
-  vpn ssh mac-mini --exec         # Actually SSH to mac-mini
-  vpn ssh 10.8.0.1                # SSH to VPN IP directly
-  vpn ssh server --user=root      # SSH as root to server`,
-		Args: cobra.MaximumNArgs(1),
+  vpn topology
+  vpn topology --watch
+  vpn --node 10.8.0.1:9001 topology --watch`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			// Try to connect to node for peer lookup
 			client, err := cli.NewClient(nodeAddr)
 			if err != nil {
-				return fmt.Errorf("cannot connect to local node: %w", err)
+				return err
 			}
 			defer client.Close()
 
-			// Get network peers
-			result, err := client.NetworkPeers()
-			if err != nil {
-				return fmt.Errorf("cannot get network peers: %w", err)
-			}
-
-			// Get our own status to filter ourselves out
-			status, _ := client.Status()
-			myVPNAddr := ""
-			if status != nil {
-				myVPNAddr = status.VPNAddress
-			}
-
-			// Filter out ourselves from the peer list
-			var availablePeers []protocol.PeerListEntry
-			for _, p := range result.Peers {
-				if p.VPNAddress != myVPNAddr {
-					availablePeers = append(availablePeers, p)
+			if !watch {
+				result, err := client.Topology()
+				if err != nil {
+					return err
 				}
+				printTopology(result)
+				return nil
 			}
 
-			if len(availablePeers) == 0 {
-				fmt.Println("No other peers available in the network.")
+			return client.WatchTopology(func(result *protocol.TopologyResult) error {
+				fmt.Print("\033[H\033[2J") // Cursor home, clear screen
+				printTopology(result)
+				fmt.Printf("\nUpdated %s (Ctrl-C to exit)\n", time.Now().Format("15:04:05"))
 				return nil
+			})
+		},
+	}
+
+	cmd.Flags().BoolVar(&watch, "watch", false, "Redraw whenever the topology changes")
+
+	return cmd
+}
+
+func printTopology(result *protocol.TopologyResult) {
+	nodes := result.Nodes
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Distance != nodes[j].Distance {
+			return nodes[i].Distance < nodes[j].Distance
+		}
+		return nodes[i].VPNAddress < nodes[j].VPNAddress
+	})
+
+	fmt.Printf("\nNetwork Topology (%d nodes, %d connections)\n", len(result.Nodes), len(result.Edges))
+	fmt.Println("──────────────────────────────────────────────────────────────────────────────")
+	fmt.Printf("%-18s %-15s %-8s %-5s %-10s %-12s %s\n", "NAME", "VPN IP", "OS", "HOPS", "LATENCY", "BANDWIDTH", "LOCATION")
+	fmt.Println("──────────────────────────────────────────────────────────────────────────────")
+
+	for _, n := range nodes {
+		name := n.Name
+		if n.IsUs {
+			name += " (you)"
+		}
+		hops := "-"
+		if n.Distance >= 0 {
+			hops = strconv.Itoa(n.Distance)
+		}
+		latency := "-"
+		if n.LatencyMs > 0 {
+			latency = fmt.Sprintf("%.1fms", n.LatencyMs)
+		}
+		bandwidth := "-"
+		if n.Bandwidth > 0 {
+			bandwidth = formatBandwidth(n.Bandwidth)
+		}
+		location := "-"
+		if n.Geo != nil && n.Geo.City != "" {
+			location = n.Geo.City
+			if n.Geo.Country != "" {
+				location += ", " + n.Geo.Country
 			}
+		}
+		fmt.Printf("%-18s %-15s %-8s %-5s %-10s %-12s %s\n",
+			name, n.VPNAddress, n.OS, hops, latency, bandwidth, location)
+	}
+}
 
-			var target string
-			if len(args) == 0 {
-				// Interactive peer selection
-				fmt.Println("\n" + colorGreen + "Select a peer to SSH into:" + colorReset)
-				fmt.Println("────────────────────────────────────────")
-				for i, p := range availablePeers {
-					osInfo := ""
-					if p.OS != "" {
-						osInfo = fmt.Sprintf(" [%s]", p.OS)
-					}
-					fmt.Printf("  %d) %s (%s)%s\n", i+1, p.Name, p.VPNAddress, osInfo)
-				}
-				fmt.Println()
-				fmt.Print("Enter number (or 'q' to quit): ")
+func benchmarkCmd() *cobra.Command {
+	var serve, store, outputJSON bool
+	var duration time.Duration
 
-				var input string
-				fmt.Scanln(&input)
-				if input == "q" || input == "" {
-					return nil
-				}
+	cmd := &cobra.Command{
+		Use:     "benchmark [peer]",
+		Aliases: []string{"bench"},
+		Short:   "Measure tunnel throughput, loss and jitter to a peer",
+		Long: `Measure VPN throughput between this node and a peer, iperf-style.
 
-				var choice int
-				if _, err := fmt.Sscanf(input, "%d", &choice); err != nil || choice < 1 || choice > len(availablePeers) {
-					fmt.Println("Invalid selection")
-					return nil
-				}
+The peer must be running a benchmark responder. Start one on the peer with
+--serve (it listens on the peer's VPN address, UDP port 9002, and stops on
+its own after --duration). Then run the test from this node.
 
-				target = availablePeers[choice-1].Name
-			} else {
-				target = args[0]
-			}
+The peer can be a name or a VPN IP address.
 
-			// Find the peer
-			var targetIP string
-			var targetUser string
-			var peerName string
-
-			// Check if target is already a VPN IP
-			if strings.HasPrefix(target, "10.8.0.") {
-				targetIP = target
-				// Try to find user from peer list
-				for _, p := range availablePeers {
-					if p.VPNAddress == target {
-						peerName = p.Name
-						if p.OS == "linux" {
-							targetUser = "root"
-						} else {
-							targetUser = p.Hostname
-						}
-						break
-					}
-				}
-				if targetUser == "" {
-					targetUser = user
-				}
-			} else {
-				// Search by name
-				for _, p := range availablePeers {
-					if strings.EqualFold(p.Name, target) || strings.Contains(strings.ToLower(p.Name), strings.ToLower(target)) {
-						targetIP = p.VPNAddress
-						peerName = p.Name
-						if p.OS == "linux" {
-							targetUser = "root"
-						} else if p.Hostname != "" {
-							targetUser = p.Hostname
-						} else {
-							targetUser = p.Name
-						}
-						break
-					}
-				}
+Use --store to record the measured bandwidth for the peer (shown in the
+topology table and stored as the peer.bandwidth_bps metric).
+
+Examples:
+  vpn benchmark --serve                  # On the peer: accept tests for 60s
+  vpn benchmark mac-mini                 # 10 second test
+  vpn benchmark 10.8.0.5 --duration=30s --store`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := cli.NewClient(nodeAddr)
+			if err != nil {
+				return err
 			}
+			defer client.Close()
 
-			if targetIP == "" {
-				fmt.Printf("%sPeer not found: %s%s\n", colorRed, target, colorReset)
-				fmt.Println("\nAvailable peers:")
-				for _, p := range availablePeers {
-					fmt.Printf("  - %s (%s)\n", p.Name, p.VPNAddress)
+			if serve {
+				if !cmd.Flags().Changed("duration") {
+					duration = 60 * time.Second
 				}
+				result, err := client.BenchServer(int(duration.Seconds()))
+				if err != nil {
+					return err
+				}
+				fmt.Printf("%s✓%s Benchmark responder listening on %s until %s\n",
+					colorGreen, colorReset, result.Address, result.Until)
 				return nil
 			}
 
-			// Override user if specified
-			if user != "" {
-				targetUser = user
-			}
-			if targetUser == "" {
-				targetUser = "root" // fallback
+			if len(args) == 0 {
+				return fmt.Errorf("specify a peer, or use --serve to accept tests")
 			}
 
-			// Override password if not specified
-			if password == "" {
-				password = "osopanda"
+			peer, err := resolvePeerAddress(client, args[0])
+			if err != nil {
+				return err
 			}
 
-			sshCmdStr := fmt.Sprintf("ssh %s@%s", targetUser, targetIP)
-
-			if execSSH {
-				// Actually execute SSH using sshpass
-				fmt.Printf("\n%sConnecting to %s...%s\n\n", colorGreen, peerName, colorReset)
+			if !outputJSON {
+				fmt.Printf("Benchmarking %s for %s...\n", peer, duration)
+			}
+			result, err := client.BenchClient(protocol.BenchClientParams{
+				Peer:        peer,
+				DurationSec: int(duration.Seconds()),
+				Store:       store,
+			})
+			if err != nil {
+				return err
+			}
 
-				// Check if sshpass is available
-				if _, err := exec.LookPath("sshpass"); err != nil {
-					fmt.Println("sshpass not found. Install it with: brew install hudochenkov/sshpass/sshpass")
-					fmt.Println("\nAlternatively, run SSH manually:")
-					fmt.Printf("  %s\n", sshCmdStr)
-					fmt.Printf("  Password: %s\n", password)
-					return nil
+			if outputJSON {
+				output, err := json.MarshalIndent(result, "", "  ")
+				if err != nil {
+					return err
 				}
-
-				// Run sshpass with SSH
-				sshCmd := exec.Command("sshpass", "-p", password, "ssh",
-					"-o", "StrictHostKeyChecking=no",
-					"-o", "UserKnownHostsFile=/dev/null",
-					fmt.Sprintf("%s@%s", targetUser, targetIP))
-				sshCmd.Stdin = os.Stdin
-				sshCmd.Stdout = os.Stdout
-				sshCmd.Stderr = os.Stderr
-
-				return sshCmd.Run()
+				fmt.Println(string(output))
+				return nil
 			}
 
-			// Just show the command
-			fmt.Printf("\n%sSSH to %s%s\n", colorGreen, peerName, colorReset)
+			fmt.Println("\nBenchmark Results")
 			fmt.Println("────────────────────────────────────────")
-			fmt.Printf("  Peer:      %s\n", peerName)
-			fmt.Printf("  VPN IP:    %s\n", targetIP)
-			fmt.Printf("  User:      %s\n", targetUser)
-			fmt.Printf("  Password:  %s\n", password)
-			fmt.Println()
-			fmt.Printf("  Command:   %s%s%s\n", colorBlue, sshCmdStr, colorReset)
-			fmt.Println()
-			fmt.Println("To connect directly, use --exec flag:")
-			fmt.Printf("  vpn ssh %s --exec\n", target)
-			fmt.Println()
-			fmt.Println("Or copy the command above, or use sshpass:")
-			fmt.Printf("  sshpass -p '%s' %s\n", password, sshCmdStr)
+			fmt.Printf("  %-14s %s\n", "Peer:", result.Peer)
+			fmt.Printf("  %-14s %.1fs\n", "Duration:", result.DurationSec)
+			fmt.Printf("  %-14s %.2f MB/s (%.1f Mbps)\n", "Throughput:", result.MBPerSec, result.Mbps)
+			fmt.Printf("  %-14s %.2f%% (%d/%d packets)\n", "Packet loss:",
+				result.LossPct, result.PacketsSent-result.PacketsRecv, result.PacketsSent)
+			fmt.Printf("  %-14s %.2f ms\n", "Jitter:", result.JitterMs)
+			fmt.Printf("  %-14s %.2f ms\n", "RTT:", result.RTTMs)
+			if result.Stored {
+				fmt.Printf("\n%s✓%s Stored as bandwidth for %s\n", colorGreen, colorReset, result.Peer)
+			}
 
 			return nil
 		},
 	}
 
-	cmd.Flags().StringVar(&user, "user", "", "SSH username (auto-detected if not specified)")
-	cmd.Flags().StringVar(&password, "password", "osopanda", "SSH password (default: osopanda)")
-	cmd.Flags().BoolVar(&execSSH, "exec", false, "Actually execute SSH (requires sshpass)")
+	cmd.Flags().BoolVar(&serve, "serve", false, "Start a responder so peers can benchmark this node")
+	cmd.Flags().DurationVar(&duration, "duration", 10*time.Second, "Test length (responder lifetime with --serve, default 60s)")
+	cmd.Flags().BoolVar(&store, "store", false, "Record the result as the peer's bandwidth")
+	cmd.Flags().BoolVar(&outputJSON, "json", false, "Output as JSON")
 
 	return cmd
 }
 
-const cliVersion = "0.6.2"
+// resolvePeerAddress returns the VPN address for a peer name or address.
+func resolvePeerAddress(client *cli.Client, peer string) (string, error) {
+	if net.ParseIP(peer) != nil {
+		return peer, nil
+	}
 
-func versionCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "version",
-		Short: "Show CLI and node version",
+	result, err := client.NetworkPeers()
+	if err != nil {
+		return "", fmt.Errorf("cannot get network peers: %w", err)
+	}
+	for _, p := range result.Peers {
+		if strings.EqualFold(p.Name, peer) || strings.EqualFold(p.Hostname, peer) {
+			return p.VPNAddress, nil
+		}
+	}
+	return "", fmt.Errorf("peer not found: %s", peer)
+}
+
+// PingResult is the output of 'vpn ping'.
+type PingResult struct {
+	Peer       string    `json:"peer"`
+	VPNAddress string    `json:"vpn_address"`
+	Sent       int       `json:"sent"`
+	Received   int       `json:"received"`
+	LossPct    float64   `json:"loss_pct"`
+	MinMs      float64   `json:"min_ms"`
+	AvgMs      float64   `json:"avg_ms"`
+	MaxMs      float64   `json:"max_ms"`
+	RTTsMs     []float64 `json:"rtts_ms,omitempty"` // One per answered ping, in order
+}
+
+func pingCmd() *cobra.Command {
+	var count int
+	var interval time.Duration
+	var outputJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "ping <peer>",
+		Short: "Ping a peer over the VPN and report RTT and loss",
+		Long: `Send ICMP echo requests from this machine to a peer's VPN IP (through
+the tunnel) and report min/avg/max round-trip time and packet loss.
+
+The peer can be a name or a VPN IP address. Each ping waits up to 2
+seconds for its answer. Exits with status 1 if no ping was answered.
+
+Examples:
+  vpn ping mac-mini
+  vpn ping 10.8.0.1 -c 10 --interval 200ms
+  vpn ping server --json`,
+		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			fmt.Printf("VPN CLI version %s\n", cliVersion)
+			if count < 1 {
+				return fmt.Errorf("--count must be at least 1")
+			}
 
-			// Try to get node version
-			client, err := cli.NewClient(nodeAddr)
-			if err != nil {
-				fmt.Printf("Node version: (not connected)\n")
-				return nil
+			// A VPN IP needs no lookup, so pinging works without the node
+			addr := args[0]
+			if net.ParseIP(addr) == nil {
+				client, err := cli.NewClient(nodeAddr)
+				if err != nil {
+					return err
+				}
+				addr, err = resolvePeerAddress(client, args[0])
+				client.Close()
+				if err != nil {
+					return err
+				}
+			}
+
+			result := PingResult{Peer: args[0], VPNAddress: addr}
+			if !outputJSON {
+				fmt.Printf("PING %s (%s): %d packets\n", args[0], addr, count)
+			}
+			for i := 0; i < count; i++ {
+				if i > 0 {
+					time.Sleep(interval)
+				}
+				result.Sent++
+
+				rtt, err := pingOnce(addr)
+				var execErr *exec.Error
+				if errors.As(err, &execErr) {
+					return fmt.Errorf("cannot run ping: %w", err)
+				}
+				ms, parseErr := strconv.ParseFloat(rtt, 64)
+				if err != nil || parseErr != nil {
+					if !outputJSON {
+						fmt.Printf("  seq=%d %stimeout%s\n", i+1, colorRed, colorReset)
+					}
+					continue
+				}
+				result.RTTsMs = append(result.RTTsMs, ms)
+				if !outputJSON {
+					fmt.Printf("  seq=%d time=%.2f ms\n", i+1, ms)
+				}
 			}
-			defer client.Close()
+			summarizePings(&result)
 
-			status, err := client.Status()
-			if err != nil {
-				fmt.Printf("Node version: (error: %v)\n", err)
-				return nil
+			if outputJSON {
+				output, err := json.MarshalIndent(result, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(output))
+			} else {
+				fmt.Printf("\n--- %s ping statistics ---\n", args[0])
+				fmt.Printf("%d packets sent, %d received, %.1f%% loss\n", result.Sent, result.Received, result.LossPct)
+				if result.Received > 0 {
+					fmt.Printf("rtt min/avg/max = %.2f/%.2f/%.2f ms\n", result.MinMs, result.AvgMs, result.MaxMs)
+				}
 			}
 
-			fmt.Printf("Node version: %s (%s)\n", status.Version, status.NodeName)
+			if result.Received == 0 {
+				// Exit directly: returning an error would print usage
+				os.Exit(1)
+			}
 			return nil
 		},
 	}
+
+	cmd.Flags().IntVarP(&count, "count", "c", 4, "Number of pings to send")
+	cmd.Flags().DurationVarP(&interval, "interval", "i", time.Second, "Wait between pings")
+	cmd.Flags().BoolVar(&outputJSON, "json", false, "Output as JSON")
+
+	return cmd
+}
+
+// summarizePings fills in the received count, loss and min/avg/max RTT
+// from r.Sent and r.RTTsMs.
+func summarizePings(r *PingResult) {
+	r.Received = len(r.RTTsMs)
+	if r.Sent > 0 {
+		r.LossPct = float64(r.Sent-r.Received) / float64(r.Sent) * 100
+	}
+	if r.Received == 0 {
+		return
+	}
+
+	r.MinMs, r.MaxMs = r.RTTsMs[0], r.RTTsMs[0]
+	var sum float64
+	for _, ms := range r.RTTsMs {
+		r.MinMs = math.Min(r.MinMs, ms)
+		r.MaxMs = math.Max(r.MaxMs, ms)
+		sum += ms
+	}
+	r.AvgMs = sum / float64(r.Received)
 }
 
-func networkPeersCmd() *cobra.Command {
+func retentionCmd() *cobra.Command {
+	var logs, metricsRaw, metrics1m, metrics1h string
 	var outputJSON bool
 
 	cmd := &cobra.Command{
-		Use:     "network-peers",
-		Aliases: []string{"np", "net-peers"},
-		Short:   "List all peers in the VPN network",
-		Long: `List all peers known to the VPN network.
+		Use:   "retention",
+		Short: "Show or change storage retention policy",
+		Long: `Show or change how long the node keeps logs and metrics.
 
-In client mode, shows peers received from the server via PEER_LIST messages.
-In server mode, shows all connected clients.
+Without flags, shows the current policy. With flags, changes the policy
+at runtime (no restart needed), persists it, and immediately deletes data
+older than the new limits.
+
+Durations: 30m, 2h, 3d, 1w
 
 Examples:
-  vpn network-peers              # List all network peers
-  vpn network-peers --json       # JSON output for scripting`,
+  vpn retention                          # Show current policy
+  vpn retention --logs=3d                # Keep logs for 3 days
+  vpn retention --logs=3d --metrics-raw=2h
+  vpn --node 10.8.0.5:9001 retention --logs=1d   # Small device`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			client, err := cli.NewClient(nodeAddr)
 			if err != nil {
@@ -1029,7 +5649,17 @@ Examples:
 			}
 			defer client.Close()
 
-			result, err := client.NetworkPeers()
+			var result *protocol.RetentionResult
+			if logs == "" && metricsRaw == "" && metrics1m == "" && metrics1h == "" {
+				result, err = client.Retention()
+			} else {
+				result, err = client.SetRetention(protocol.SetRetentionParams{
+					Logs:       logs,
+					MetricsRaw: metricsRaw,
+					Metrics1m:  metrics1m,
+					Metrics1h:  metrics1h,
+				})
+			}
 			if err != nil {
 				return err
 			}
@@ -1043,61 +5673,42 @@ Examples:
 				return nil
 			}
 
-			mode := "Client"
-			if result.ServerMode {
-				mode = "Server"
-			}
-
-			fmt.Printf("\nNetwork Peers (%s mode)\n", mode)
-			fmt.Println("────────────────────────────────────────────────────────────")
-
-			if len(result.Peers) == 0 {
-				fmt.Println("No peers in network.")
-				fmt.Println("\nNote: Peers are discovered when the server broadcasts the peer list.")
-				return nil
-			}
-
-			fmt.Printf("%-20s %-15s %-25s %s\n", "NAME", "VPN IP", "HOSTNAME", "OS")
-			fmt.Println("────────────────────────────────────────────────────────────")
-
-			for _, p := range result.Peers {
-				fmt.Printf("%-20s %-15s %-25s %s\n",
-					p.Name, p.VPNAddress, p.Hostname, p.OS)
-			}
-
-			fmt.Println()
-			fmt.Println("Use 'vpn ssh <name>' to connect to a peer.")
+			fmt.Println("\nRetention Policy")
+			fmt.Println("────────────────────────────────────────")
+			fmt.Printf("  %-20s %s\n", "logs:", result.Logs)
+			fmt.Printf("  %-20s %s\n", "metrics (raw):", result.MetricsRaw)
+			fmt.Printf("  %-20s %s\n", "metrics (1m):", result.Metrics1m)
+			fmt.Printf("  %-20s %s\n", "metrics (1h):", result.Metrics1h)
 
 			return nil
 		},
 	}
 
+	cmd.Flags().StringVar(&logs, "logs", "", "Log retention (e.g. 3d)")
+	cmd.Flags().StringVar(&metricsRaw, "metrics-raw", "", "Raw metrics retention (e.g. 2h)")
+	cmd.Flags().StringVar(&metrics1m, "metrics-1m", "", "1-minute aggregate retention (e.g. 24h)")
+	cmd.Flags().StringVar(&metrics1h, "metrics-1h", "", "1-hour aggregate retention (e.g. 30d)")
 	cmd.Flags().BoolVar(&outputJSON, "json", false, "Output as JSON")
 
 	return cmd
 }
 
-func crashesCmd() *cobra.Command {
-	var since string
+func configCmd() *cobra.Command {
 	var outputJSON bool
 
 	cmd := &cobra.Command{
-		Use:     "crashes",
-		Aliases: []string{"crash", "crash-stats"},
-		Short:   "Show crash statistics and last crash details",
-		Long: `Show crash statistics and information about the last crash.
+		Use:   "config",
+		Short: "Show or change node configuration",
+		Long: `Show the configuration the node is running with.
 
-This command helps diagnose VPN node stability issues by showing:
-- Total crashes in the time period
-- How many crashes had route-all enabled
-- How many times route restoration failed (which breaks internet)
-- Details of the most recent crash
+The encryption key is never shown, only whether one is set.
+Use 'vpn config set' to change options without restarting.
 
 Examples:
-  vpn crashes                    # Show stats for last 24 hours
-  vpn crashes --since=-1h        # Show stats for last hour
-  vpn crashes --since=-7d        # Show stats for last week
-  vpn crashes --json             # JSON output for scripting`,
+  vpn config
+  vpn config show --json
+  vpn config set log_level=WARN
+  vpn --node 10.8.0.5:9001 config`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			client, err := cli.NewClient(nodeAddr)
 			if err != nil {
@@ -1105,290 +5716,317 @@ Examples:
 			}
 			defer client.Close()
 
-			result, err := client.CrashStats(since)
+			result, err := client.Config()
 			if err != nil {
 				return err
 			}
 
-			if outputJSON {
-				output, err := json.MarshalIndent(result, "", "  ")
-				if err != nil {
-					return err
-				}
-				fmt.Println(string(output))
-				return nil
-			}
-
-			fmt.Println("\nCrash Statistics")
-			fmt.Println("────────────────────────────────────────")
-			fmt.Printf("  Time Period:          %s to now\n", since)
-			fmt.Printf("  Total Crashes:        %d\n", result.TotalCrashes)
-			fmt.Printf("  With Route-All:       %d\n", result.CrashesWithRouteAll)
-
-			if result.RouteRestoreFailures > 0 {
-				fmt.Printf("  %sRoute Restore Fails:   %d%s (these break internet!)\n",
-					colorRed, result.RouteRestoreFailures, colorReset)
-			} else {
-				fmt.Printf("  Route Restore Fails:  %s0%s\n", colorGreen, colorReset)
-			}
-
-			if result.LastCrash != nil {
-				fmt.Println()
-				fmt.Println("Last Crash/Shutdown")
-				fmt.Println("────────────────────────────────────────")
-				fmt.Printf("  Time:           %s\n", result.LastCrash.Timestamp)
-				fmt.Printf("  Event:          %s\n", result.LastCrash.Event)
-				fmt.Printf("  Reason:         %s\n", result.LastCrash.Reason)
-				fmt.Printf("  Uptime:         %s\n", formatUptime(result.LastCrash.UptimeSeconds))
-				fmt.Printf("  Route-All:      %v\n", result.LastCrash.RouteAll)
-				if result.LastCrash.RouteAll {
-					if result.LastCrash.RouteRestored {
-						fmt.Printf("  Routes:         %sRestored%s\n", colorGreen, colorReset)
-					} else {
-						fmt.Printf("  Routes:         %sNOT RESTORED%s (internet was broken!)\n", colorRed, colorReset)
-					}
-				}
-				fmt.Printf("  Version:        %s\n", result.LastCrash.Version)
-			} else {
-				fmt.Println()
-				fmt.Println("No crashes recorded in this time period.")
-			}
-
-			return nil
+			return printConfig(result, outputJSON)
 		},
 	}
 
-	cmd.Flags().StringVar(&since, "since", "-24h", "Time range (Splunk-like: -1h, -24h, -7d)")
 	cmd.Flags().BoolVar(&outputJSON, "json", false, "Output as JSON")
 
+	show := &cobra.Command{
+		Use:   "show",
+		Short: "Show the node configuration (same as 'vpn config')",
+		RunE:  cmd.RunE,
+	}
+	show.Flags().BoolVar(&outputJSON, "json", false, "Output as JSON")
+	cmd.AddCommand(show, configSetCmd())
+
 	return cmd
 }
 
-func lifecycleCmd() *cobra.Command {
-	var limit int
+func configSetCmd() *cobra.Command {
 	var outputJSON bool
 
 	cmd := &cobra.Command{
-		Use:     "lifecycle",
-		Aliases: []string{"events", "history"},
-		Short:   "Show recent lifecycle events (starts, stops, crashes)",
-		Long: `Show recent lifecycle events for the VPN node.
-
-Events include:
-- START: Node started
-- STOP: Clean shutdown
-- SIGNAL: Shutdown due to signal (SIGTERM, SIGINT)
-- CONNECTION_LOST: Connection to server was lost
-- CRASH: Unexpected termination
+		Use:   "set <key>=<value>",
+		Short: "Change a configuration option at runtime",
+		Long: `Change a configuration option without restarting the node.
+
+Mutable keys:
+  name               Node name
+  route_all          Route all traffic through the VPN (true/false, client only)
+  log_level          Minimum level recorded: DEBUG, INFO, WARN, ERROR
+  logs_retention     How long logs are kept (e.g. 3d)
+  metrics_retention  How long raw metrics are kept (e.g. 2h)
+  kill_switch        Block non-VPN traffic when the tunnel drops (true/false, client only)
+  dns                Comma-separated resolvers used while routing all traffic ("" = none)
+  peer_rate_limits   Per-peer caps as <vpn-ip>=<mbps>,... (server only, "" = none)
+  peer_bandwidth_limit  Cap in Mbps for peers not in peer_rate_limits (server only, 0 = none)
+
+Listen addresses, server mode, TLS, encryption, compression, transport and
+the data directory are fixed at startup and cannot be changed here.
 
 Examples:
-  vpn lifecycle                 # Show last 20 events
-  vpn lifecycle --limit=50      # Show last 50 events
-  vpn lifecycle --json          # JSON output for scripting`,
+  vpn config set log_level=WARN
+  vpn config set route_all=false
+  vpn config set kill_switch=true
+  vpn config set dns=10.8.0.1,1.1.1.1
+  vpn --node 10.8.0.1:9001 config set peer_rate_limits=10.8.0.5=20,10.8.0.7=5
+  vpn config set name=office-mac
+  vpn config set logs_retention=1d`,
+		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			key, value, ok := strings.Cut(args[0], "=")
+			if !ok || key == "" {
+				return fmt.Errorf("expected <key>=<value>, got %q", args[0])
+			}
+
 			client, err := cli.NewClient(nodeAddr)
 			if err != nil {
 				return err
 			}
 			defer client.Close()
 
-			result, err := client.Lifecycle(limit)
+			result, err := client.ConfigSet(key, value)
 			if err != nil {
 				return err
 			}
 
-			if outputJSON {
-				output, err := json.MarshalIndent(result, "", "  ")
-				if err != nil {
-					return err
-				}
-				fmt.Println(string(output))
-				return nil
+			if !outputJSON {
+				fmt.Printf("%s✓%s Set %s = %s\n", colorGreen, colorReset, key, value)
 			}
+			return printConfig(result, outputJSON)
+		},
+	}
 
-			fmt.Println("\nLifecycle Events")
-			fmt.Println("────────────────────────────────────────────────────────────────────────────")
-			fmt.Printf("%-20s %-15s %-12s %-8s %s\n", "TIMESTAMP", "EVENT", "UPTIME", "ROUTES", "REASON")
-			fmt.Println("────────────────────────────────────────────────────────────────────────────")
+	cmd.Flags().BoolVar(&outputJSON, "json", false, "Output as JSON")
 
-			for _, e := range result.Events {
-				// Parse and format timestamp
-				ts, _ := time.Parse(time.RFC3339, e.Timestamp)
-				tsStr := ts.Format("2006-01-02 15:04:05")
-
-				// Color the event
-				eventColor := ""
-				switch e.Event {
-				case "START":
-					eventColor = colorGreen
-				case "STOP":
-					eventColor = colorBlue
-				case "SIGNAL":
-					eventColor = colorYellow
-				case "CONNECTION_LOST", "CRASH":
-					eventColor = colorRed
-				}
-
-				routeStatus := "-"
-				if e.RouteAll {
-					if e.RouteRestored {
-						routeStatus = colorGreen + "OK" + colorReset
-					} else {
-						routeStatus = colorRed + "FAILED" + colorReset
-					}
-				}
+	return cmd
+}
 
-				fmt.Printf("%-20s %s%-15s%s %-12s %-8s %s\n",
-					tsStr,
-					eventColor, e.Event, colorReset,
-					formatUptime(e.UptimeSeconds),
-					routeStatus,
-					truncate(e.Reason, 30))
-			}
+// printConfig prints a config snapshot as JSON or as a key/value listing.
+func printConfig(c *protocol.ConfigResult, outputJSON bool) error {
+	if outputJSON {
+		output, err := json.MarshalIndent(c, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(output))
+		return nil
+	}
 
-			return nil
-		},
+	mode := "client"
+	if c.ServerMode {
+		mode = "server"
+	}
+	keyStatus := "not set"
+	if c.EncryptionKeySet {
+		keyStatus = "set"
+	}
+	logLevel := c.LogLevel
+	if logLevel == "" {
+		logLevel = "all"
 	}
 
-	cmd.Flags().IntVar(&limit, "limit", 20, "Maximum number of events to show")
-	cmd.Flags().BoolVar(&outputJSON, "json", false, "Output as JSON")
+	fmt.Println("\nNode Configuration")
+	fmt.Println("────────────────────────────────────────")
+	fmt.Printf("  %-20s %s\n", "name:", c.NodeName)
+	fmt.Printf("  %-20s %s\n", "mode:", mode)
+	if c.ConnectTo != "" {
+		fmt.Printf("  %-20s %s\n", "connect_to:", c.ConnectTo)
+	}
+	fmt.Printf("  %-20s %s\n", "vpn_address:", c.VPNAddress)
+	fmt.Printf("  %-20s %s\n", "subnet:", c.Subnet)
+	fmt.Printf("  %-20s %s\n", "listen_vpn:", c.ListenVPN)
+	fmt.Printf("  %-20s %s\n", "listen_ws:", c.ListenWS)
+	fmt.Printf("  %-20s %s\n", "listen_control:", c.ListenControl)
+	if c.ListenControlVPN {
+		fmt.Printf("  %-20s %v (read-only)\n", "listen_control_vpn:", c.ListenControlVPN)
+	}
+	fmt.Printf("  %-20s %v\n", "use_tls:", c.UseTLS)
+	if c.CertFile != "" {
+		fmt.Printf("  %-20s %s\n", "cert_file:", c.CertFile)
+		fmt.Printf("  %-20s %s\n", "key_file:", c.KeyFile)
+	}
+	fmt.Printf("  %-20s %v (key %s)\n", "encryption:", c.Encryption, keyStatus)
+	if c.Cipher != "" {
+		fmt.Printf("  %-20s %s\n", "cipher:", c.Cipher)
+	}
+	fmt.Printf("  %-20s %v\n", "compression:", c.Compression)
+	if c.Transport != "" {
+		fmt.Printf("  %-20s %s\n", "transport:", c.Transport)
+	}
+	fmt.Printf("  %-20s %v\n", "ipv6:", c.IPv6)
+	fmt.Printf("  %-20s %v\n", "route_all:", c.RouteAll)
+	if len(c.RouteSubnets) > 0 {
+		fmt.Printf("  %-20s %s\n", "route_subnets:", strings.Join(c.RouteSubnets, ", "))
+	}
+	if len(c.DNS) > 0 {
+		fmt.Printf("  %-20s %s\n", "dns:", strings.Join(c.DNS, ", "))
+	}
+	if !c.ServerMode {
+		fmt.Printf("  %-20s %v\n", "kill_switch:", c.KillSwitch)
+	}
+	fmt.Printf("  %-20s %ds\n", "peer_timeout:", c.PeerTimeoutSeconds)
+	fmt.Printf("  %-20s %d ms, %.0f%% loss (0 = off)\n", "quality_alerts:", c.RTTAlertMs, c.LossAlertPct)
+	if len(c.PeerRateLimits) > 0 {
+		var limits []string
+		for ip, mbps := range c.PeerRateLimits {
+			limits = append(limits, fmt.Sprintf("%s=%gMbps", ip, mbps))
+		}
+		sort.Strings(limits)
+		fmt.Printf("  %-20s %s\n", "peer_rate_limits:", strings.Join(limits, ", "))
+	}
+	if c.PeerBandwidthLimitMbps > 0 {
+		fmt.Printf("  %-20s %g Mbps per peer\n", "peer_bw_limit:", c.PeerBandwidthLimitMbps)
+	}
+	fmt.Printf("  %-20s %s\n", "data_dir:", c.DataDir)
+	fmt.Printf("  %-20s %d MB\n", "max_storage:", c.MaxStorageMB)
+	fmt.Printf("  %-20s %s\n", "log_level:", logLevel)
+	if c.Retention != nil {
+		fmt.Printf("  %-20s %s\n", "logs_retention:", c.Retention.Logs)
+		fmt.Printf("  %-20s %s\n", "metrics_retention:", c.Retention.MetricsRaw)
+	}
 
-	return cmd
+	return nil
 }
 
-func truncate(s string, max int) string {
-	if len(s) <= max {
-		return s
+func alertCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "alert",
+		Short: "Manage alerting rules",
+		Long: `Manage rules the node evaluates after every metrics write.
+
+A rule fires once when its condition starts matching, and again only
+after the condition has cleared. Every firing is logged (component
+"alert"); the action decides what else happens:
+
+  log      Only log the alert
+  notify   Show a desktop notification on the node
+  webhook  POST the alert as JSON to --webhook
+
+Examples:
+  vpn alert add --name=peer-lost --when="peers<1" --action=log
+  vpn alert add --name=slow --when="latency>200" --action=notify
+  vpn alert add --name=busy --when="bandwidth.rx_current_bps>=10000000" \
+      --action=webhook --webhook=https://example.com/hook
+  vpn alert list
+  vpn alert rm peer-lost`,
 	}
-	return s[:max-3] + "..."
+
+	cmd.AddCommand(alertAddCmd())
+	cmd.AddCommand(alertListCmd())
+	cmd.AddCommand(alertRemoveCmd())
+
+	return cmd
 }
 
-// handshakeCmd sends an install handshake to the server.
-func handshakeCmd() *cobra.Command {
-	var (
-		nodeName string
-		version  string
-	)
+// alertMetricAliases are short names accepted in --when expressions.
+var alertMetricAliases = map[string]string{
+	"peers":   "vpn.active_peers",
+	"latency": "vpn.latency_ms",
+	"loss":    "vpn.packet_loss_pct",
+	"tx":      "bandwidth.tx_current_bps",
+	"rx":      "bandwidth.rx_current_bps",
+	"errors":  "logs.errors",
+}
 
-	cmd := &cobra.Command{
-		Use:   "handshake",
-		Short: "Send install handshake to server",
-		Long: `Send an install handshake to the VPN server.
+// alertOpSymbols maps condition operators back to their symbols.
+var alertOpSymbols = map[string]string{
+	"lt": "<", "le": "<=", "gt": ">", "ge": ">=", "eq": "==", "ne": "!=",
+}
 
-This command is typically called by install.sh after installation
-to register the client with the server and test connectivity.`,
-		RunE: func(cmd *cobra.Command, args []string) error {
-			client, err := cli.NewClient(nodeAddr)
-			if err != nil {
-				return err
-			}
-			defer client.Close()
+// parseAlertCondition parses expressions like "peers<1" or "vpn.latency_ms >= 200".
+func parseAlertCondition(expr string) (metric, op string, value float64, err error) {
+	// Two-character operators first so "<=" isn't read as "<"
+	operators := []struct{ symbol, op string }{
+		{"<=", "le"}, {">=", "ge"}, {"!=", "ne"}, {"==", "eq"},
+		{"<", "lt"}, {">", "gt"}, {"=", "eq"},
+	}
 
-			// Get hostname
-			hostname, _ := os.Hostname()
+	for _, o := range operators {
+		idx := strings.Index(expr, o.symbol)
+		if idx < 0 {
+			continue
+		}
+		metric = strings.TrimSpace(expr[:idx])
+		if alias, ok := alertMetricAliases[metric]; ok {
+			metric = alias
+		}
+		value, err = strconv.ParseFloat(strings.TrimSpace(expr[idx+len(o.symbol):]), 64)
+		if metric == "" || err != nil {
+			return "", "", 0, fmt.Errorf("invalid condition %q (expected e.g. \"peers<1\")", expr)
+		}
+		return metric, o.op, value, nil
+	}
 
-			// Get status for VPN address
-			status, err := client.Status()
-			if err != nil {
-				return fmt.Errorf("failed to get status: %w", err)
-			}
+	return "", "", 0, fmt.Errorf("invalid condition %q: no operator (use <, <=, >, >=, ==, !=)", expr)
+}
 
-			if nodeName == "" {
-				nodeName = status.NodeName
-			}
-			if version == "" {
-				version = status.Version
-			}
+func alertAddCmd() *cobra.Command {
+	var name, when, action, webhook string
 
-			// Try to get public IP
-			publicIP, _ := getPublicIP()
-
-			// Run ping test to server
-			pingOK := false
-			pingMS := 0
-			if pingOut, err := exec.Command("ping", "-c", "1", "-W", "2", "10.8.0.1").Output(); err == nil {
-				pingOK = true
-				// Extract time from ping output
-				if strings.Contains(string(pingOut), "time=") {
-					parts := strings.Split(string(pingOut), "time=")
-					if len(parts) > 1 {
-						timePart := strings.Split(parts[1], " ")[0]
-						var ms float64
-						fmt.Sscanf(timePart, "%f", &ms)
-						pingMS = int(ms)
-					}
-				}
-			}
+	cmd := &cobra.Command{
+		Use:   "add",
+		Short: "Add an alerting rule",
+		Long: `Add an alerting rule.
 
-			// Run SSH test - try to connect to server port 22
-			sshOK := false
-			sshErr := ""
-			conn, err := dialWithTimeout("tcp", "10.8.0.1:22", 3*time.Second)
-			if err != nil {
-				sshErr = err.Error()
-			} else {
-				sshOK = true
-				conn.Close()
+Conditions compare a metric to a number: <, <=, >, >=, ==, !=.
+Any metric from 'vpn stats' can be used; these short names also work:
+
+  peers    vpn.active_peers
+  latency  vpn.latency_ms
+  loss     vpn.packet_loss_pct
+  tx       bandwidth.tx_current_bps
+  rx       bandwidth.rx_current_bps
+  errors   logs.errors (ERROR log entries since the last collection)
+
+Examples:
+  vpn alert add --name=peer-lost --when="peers<1" --action=log
+  vpn alert add --name=lossy --when="loss>5" --action=notify
+  vpn alert add --name=errors --when="errors>0" --action=notify`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if name == "" || when == "" {
+				return fmt.Errorf("--name and --when are required")
 			}
 
-			handshake := protocol.InstallHandshake{
-				NodeName:     nodeName,
-				VPNAddress:   status.VPNAddress,
-				PublicIP:     publicIP,
-				Hostname:     hostname,
-				OS:           runtime.GOOS,
-				Arch:         runtime.GOARCH,
-				Version:      version,
-				GoVersion:    runtime.Version(),
-				InstallTS:    time.Now().Format(time.RFC3339),
-				SSHTestOK:    sshOK,
-				SSHTestError: sshErr,
-				PingTestOK:   pingOK,
-				PingTestMS:   pingMS,
+			metric, op, value, err := parseAlertCondition(when)
+			if err != nil {
+				return err
 			}
 
-			result, err := client.SendHandshake(handshake)
+			client, err := cli.NewClient(nodeAddr)
 			if err != nil {
 				return err
 			}
+			defer client.Close()
 
-			if result.Success {
-				fmt.Printf("%s Handshake Sent%s\n", colorGreen, colorReset)
-				fmt.Println("────────────────────────────────────────")
-				fmt.Printf("  Node:     %s\n", handshake.NodeName)
-				fmt.Printf("  VPN IP:   %s\n", handshake.VPNAddress)
-				fmt.Printf("  Version:  %s\n", handshake.Version)
-				fmt.Printf("  Ping:     %v (%d ms)\n", handshake.PingTestOK, handshake.PingTestMS)
-				fmt.Printf("  SSH:      %v\n", handshake.SSHTestOK)
-				fmt.Printf("  Recorded: %v\n", result.Recorded)
-				fmt.Printf("  Server:   %s\n", result.ServerVer)
-			} else {
-				fmt.Printf("%s Handshake Failed%s\n", colorRed, colorReset)
-				fmt.Println(result.Message)
+			rule, err := client.AlertAdd(protocol.AlertAddParams{
+				Name:       name,
+				Metric:     metric,
+				Op:         op,
+				Value:      value,
+				Action:     action,
+				WebhookURL: webhook,
+			})
+			if err != nil {
+				return err
 			}
 
+			fmt.Printf("%s✓%s Added rule %s (#%d): %s %s %g -> %s\n",
+				colorGreen, colorReset, rule.Name, rule.ID, rule.Metric, rule.Op, rule.Value, rule.Action)
 			return nil
 		},
 	}
 
-	cmd.Flags().StringVar(&nodeName, "name", "", "Node name (default: from status)")
-	cmd.Flags().StringVar(&version, "version", "", "Version string (default: from status)")
+	cmd.Flags().StringVar(&name, "name", "", "Rule name")
+	cmd.Flags().StringVar(&when, "when", "", "Condition, e.g. \"peers<1\"")
+	cmd.Flags().StringVar(&action, "action", "log", "Action: log, notify, webhook")
+	cmd.Flags().StringVar(&webhook, "webhook", "", "URL to POST to (webhook action)")
 
 	return cmd
-}
-
-// handshakesCmd shows handshake history.
-func handshakesCmd() *cobra.Command {
-	var (
-		nodeName   string
-		limit      int
-		outputJSON bool
-	)
+}
+
+func alertListCmd() *cobra.Command {
+	var outputJSON bool
 
 	cmd := &cobra.Command{
-		Use:   "handshakes",
-		Short: "Show install handshake history",
-		Long:  `Show the history of install handshakes from all clients.`,
+		Use:   "list",
+		Short: "List alerting rules",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			client, err := cli.NewClient(nodeAddr)
 			if err != nil {
@@ -1396,66 +6034,71 @@ func handshakesCmd() *cobra.Command {
 			}
 			defer client.Close()
 
-			history, err := client.HandshakeHistory(nodeName, limit)
+			result, err := client.AlertList()
 			if err != nil {
 				return err
 			}
 
 			if outputJSON {
-				enc := json.NewEncoder(os.Stdout)
-				enc.SetIndent("", "  ")
-				return enc.Encode(history)
-			}
-
-			if len(history.Entries) == 0 {
-				fmt.Println("No handshakes recorded yet.")
+				output, err := json.MarshalIndent(result, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(output))
 				return nil
 			}
 
-			fmt.Printf("Install Handshakes (%d total)\n", history.Total)
-			fmt.Println("────────────────────────────────────────────────────────────────────────────")
-			fmt.Printf("%-20s %-15s %-12s %-10s %-8s %-4s %-4s\n",
-				"TIMESTAMP", "NODE", "VPN IP", "VERSION", "OS", "PING", "SSH")
-			fmt.Println("────────────────────────────────────────────────────────────────────────────")
-
-			for _, h := range history.Entries {
-				ts, _ := time.Parse(time.RFC3339, h.Timestamp)
-				tsStr := ts.Local().Format("2006-01-02 15:04")
+			printAlertRules(result.Rules)
+			return nil
+		},
+	}
 
-				pingStr := colorRed + "FAIL" + colorReset
-				if h.PingTestOK {
-					pingStr = colorGreen + fmt.Sprintf("%dms", h.PingTestMS) + colorReset
-				}
+	cmd.Flags().BoolVar(&outputJSON, "json", false, "Output as JSON")
 
-				sshStr := colorRed + "FAIL" + colorReset
-				if h.SSHTestOK {
-					sshStr = colorGreen + "OK" + colorReset
-				}
+	return cmd
+}
 
-				ver := h.Version
-				if len(ver) > 7 {
-					ver = ver[:7]
-				}
+func alertRemoveCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "rm <id|name>",
+		Short: "Remove an alerting rule",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := cli.NewClient(nodeAddr)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
 
-				fmt.Printf("%-20s %-15s %-12s %-10s %-8s %-4s %-4s\n",
-					tsStr,
-					truncate(h.NodeName, 15),
-					h.VPNAddress,
-					ver,
-					h.OS+"/"+h.Arch,
-					pingStr,
-					sshStr)
+			result, err := client.AlertRemove(args[0])
+			if err != nil {
+				return err
 			}
 
+			fmt.Printf("%s✓%s Removed rule %s\n", colorGreen, colorReset, args[0])
+			printAlertRules(result.Rules)
 			return nil
 		},
 	}
+}
 
-	cmd.Flags().StringVar(&nodeName, "filter-node", "", "Filter by node name")
-	cmd.Flags().IntVar(&limit, "limit", 50, "Maximum number of entries")
-	cmd.Flags().BoolVar(&outputJSON, "json", false, "Output as JSON")
+// printAlertRules prints rules as a table.
+func printAlertRules(rules []protocol.AlertRule) {
+	if len(rules) == 0 {
+		fmt.Println("No alerting rules")
+		return
+	}
 
-	return cmd
+	fmt.Printf("\n%-4s %-20s %-36s %s\n", "ID", "NAME", "CONDITION", "ACTION")
+	fmt.Println("────────────────────────────────────────────────────────────────────────")
+	for _, r := range rules {
+		condition := fmt.Sprintf("%s %s %g", r.Metric, r.Op, r.Value)
+		action := r.Action
+		if r.WebhookURL != "" {
+			action += " " + r.WebhookURL
+		}
+		fmt.Printf("%-4d %-20s %-36s %s\n", r.ID, truncate(r.Name, 20), truncate(condition, 36), action)
+	}
 }
 
 func dialWithTimeout(network, addr string, timeout time.Duration) (interface{ Close() error }, error) {
@@ -1489,6 +6132,7 @@ func (d *dummyCloser) Close() error { return nil }
 func diagnoseCmd() *cobra.Command {
 	var outputJSON bool
 	var verbose bool
+	var fix, assumeYes bool
 
 	cmd := &cobra.Command{
 		Use:     "diagnose",
@@ -1498,459 +6142,64 @@ func diagnoseCmd() *cobra.Command {
 
 This command performs the following checks:
   1. Local VPN node status (process running, version, uptime)
-  2. VPN server reachability (ping to 10.8.0.1)
+  2. VPN server reachability (ping to the server's VPN IP, 10.8.0.1 by default)
   3. Peer discovery and connectivity
   4. Routing verification (public IP check)
-  5. DNS resolution test
+  5. DNS resolution test, and a DNS leak check when vpn-node runs with --dns
   6. Network interface status
 
 The output shows a summary with pass/fail status for each check,
 making it easy to identify connectivity issues.
 
+With --fix, failing checks that have a known remedy are repaired after
+asking for confirmation (--yes skips the prompt), then checked again:
+  VPN Interface          vpn connect
+  Internet Connectivity  vpn restore (default route through a dead tunnel; needs root)
+  DNS Resolution         flush the DNS cache (dscacheutil on macOS, resolvectl on Linux)
+Every attempt is recorded as an AUTOFIX event (see 'vpn lifecycle').
+
 Examples:
   vpn diagnose              # Run all diagnostics
   vpn diagnose --verbose    # Show detailed output
-  vpn diagnose --json       # Output as JSON for scripting`,
+  vpn diagnose --json       # Output as JSON for scripting
+  sudo vpn diagnose --fix   # Repair what can be repaired
+  sudo vpn diagnose --fix --yes --json`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			results := runDiagnostics(nodeAddr, verbose)
+			if assumeYes && !fix {
+				return fmt.Errorf("--yes only applies to --fix")
+			}
+			if fix && outputJSON && !assumeYes {
+				return fmt.Errorf("--fix with --json cannot prompt: add --yes")
+			}
+
+			results := diagnose.Run(nodeAddr)
 
 			if outputJSON {
+				if fix {
+					results.Fixes = runFixes(results, nodeAddr, assumeYes, true)
+				}
 				enc := json.NewEncoder(os.Stdout)
 				enc.SetIndent("", "  ")
 				return enc.Encode(results)
 			}
 
 			printDiagnostics(results, verbose)
+			if fix {
+				results.Fixes = runFixes(results, nodeAddr, assumeYes, false)
+			}
 			return nil
 		},
 	}
 
 	cmd.Flags().BoolVar(&outputJSON, "json", false, "Output as JSON")
 	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Show detailed output")
+	cmd.Flags().BoolVar(&fix, "fix", false, "Try to repair failing checks (asks before each fix)")
+	cmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Apply fixes without asking (with --fix)")
 
 	return cmd
 }
 
-// DiagnosticResult holds the result of a single diagnostic check.
-type DiagnosticResult struct {
-	Name    string `json:"name"`
-	Status  string `json:"status"` // "pass", "fail", "warn"
-	Message string `json:"message"`
-	Details string `json:"details,omitempty"`
-}
-
-// PeerDiagnostic holds diagnostic results for a single peer.
-type PeerDiagnostic struct {
-	Name       string `json:"name"`
-	VPNAddress string `json:"vpn_address"`
-	Version    string `json:"version"`
-	OS         string `json:"os"`
-	// Status checks
-	Reachable       bool   `json:"reachable"`        // Can ping the peer
-	VersionMatch    bool   `json:"version_match"`    // Version matches local node
-	RoutingVPN      bool   `json:"routing_vpn"`      // Traffic routed through VPN
-	SSHAccessible   bool   `json:"ssh_accessible"`   // SSH port 22 accessible
-	PublicIP        string `json:"public_ip"`        // Peer's public IP
-	VersionWarning  string `json:"version_warning,omitempty"`
-	RoutingWarning  string `json:"routing_warning,omitempty"`
-	SSHWarning      string `json:"ssh_warning,omitempty"`
-}
-
-// RecentEvent represents a recent lifecycle event for diagnostics.
-type RecentEvent struct {
-	Timestamp string `json:"timestamp"`
-	Event     string `json:"event"`
-	Reason    string `json:"reason"`
-}
-
-// DiagnosticsReport holds all diagnostic results.
-type DiagnosticsReport struct {
-	Timestamp   string             `json:"timestamp"`
-	NodeAddress string             `json:"node_address"`
-	// This Node section
-	LocalNode struct {
-		Name       string             `json:"name"`
-		Version    string             `json:"version"`
-		VPNAddress string             `json:"vpn_address"`
-		Checks     []DiagnosticResult `json:"checks"`
-	} `json:"local_node"`
-	// Network Peers section
-	Peers []PeerDiagnostic `json:"peers"`
-	// Recent Events (for WHY explanations)
-	RecentEvents []RecentEvent `json:"recent_events,omitempty"`
-	// Summary
-	Summary struct {
-		Passed int `json:"passed"`
-		Failed int `json:"failed"`
-		Warned int `json:"warned"`
-	} `json:"summary"`
-}
-
-func runDiagnostics(nodeAddr string, verbose bool) *DiagnosticsReport {
-	report := &DiagnosticsReport{
-		Timestamp:   time.Now().Format(time.RFC3339),
-		NodeAddress: nodeAddr,
-		Peers:       []PeerDiagnostic{},
-	}
-	report.LocalNode.Checks = []DiagnosticResult{}
-
-	// Get local node info first
-	client, err := cli.NewClient(nodeAddr)
-	var localVersion string
-	if err == nil {
-		defer client.Close()
-		if status, err := client.Status(); err == nil {
-			report.LocalNode.Name = status.NodeName
-			report.LocalNode.Version = status.Version
-			report.LocalNode.VPNAddress = status.VPNAddress
-			localVersion = status.Version
-		}
-	}
-
-	// === THIS NODE CHECKS ===
-	// Check 1: Local node status
-	report.LocalNode.Checks = append(report.LocalNode.Checks, checkLocalNode(nodeAddr))
-
-	// Check 2: VPN server reachability
-	report.LocalNode.Checks = append(report.LocalNode.Checks, checkServerPing())
-
-	// Check 3: Routing verification
-	report.LocalNode.Checks = append(report.LocalNode.Checks, checkRouting())
-
-	// Check 4: DNS resolution
-	report.LocalNode.Checks = append(report.LocalNode.Checks, checkDNS())
-
-	// Check 5: Network interface
-	report.LocalNode.Checks = append(report.LocalNode.Checks, checkNetworkInterface())
-
-	// Check 6: Internet connectivity
-	report.LocalNode.Checks = append(report.LocalNode.Checks, checkInternet())
-
-	// Check 7: SSH access (local)
-	report.LocalNode.Checks = append(report.LocalNode.Checks, checkLocalSSH())
-
-	// === NETWORK PEERS ===
-	// Get peer list and run diagnostics for each
-	report.Peers = checkNetworkPeers(nodeAddr, localVersion)
-
-	// === RECENT EVENTS ===
-	// Fetch recent lifecycle events to explain WHY something might be wrong
-	report.RecentEvents = getRecentEvents(nodeAddr)
-
-	// Calculate summary from local checks
-	for _, check := range report.LocalNode.Checks {
-		switch check.Status {
-		case "pass":
-			report.Summary.Passed++
-		case "fail":
-			report.Summary.Failed++
-		case "warn":
-			report.Summary.Warned++
-		}
-	}
-
-	// Add peer warnings to summary
-	for _, peer := range report.Peers {
-		if peer.Reachable {
-			report.Summary.Passed++
-		} else {
-			report.Summary.Failed++
-		}
-		if !peer.VersionMatch && peer.Version != "" {
-			report.Summary.Warned++
-		}
-		if !peer.RoutingVPN && peer.PublicIP != "" {
-			report.Summary.Warned++
-		}
-		if !peer.SSHAccessible {
-			report.Summary.Warned++
-		}
-	}
-
-	return report
-}
-
-func checkLocalNode(nodeAddr string) DiagnosticResult {
-	result := DiagnosticResult{Name: "Local VPN Node"}
-
-	client, err := cli.NewClient(nodeAddr)
-	if err != nil {
-		result.Status = "fail"
-		result.Message = "Cannot connect to local node"
-		result.Details = err.Error()
-		return result
-	}
-	defer client.Close()
-
-	status, err := client.Status()
-	if err != nil {
-		result.Status = "fail"
-		result.Message = "Failed to get node status"
-		result.Details = err.Error()
-		return result
-	}
-
-	result.Status = "pass"
-	result.Message = fmt.Sprintf("%s (v%s) - VPN IP: %s", status.NodeName, status.Version, status.VPNAddress)
-	result.Details = fmt.Sprintf("Uptime: %s, Peers: %d, Traffic In: %s, Out: %s",
-		status.UptimeStr, status.PeerCount,
-		formatBytes(status.BytesIn), formatBytes(status.BytesOut))
-
-	return result
-}
-
-func checkServerPing() DiagnosticResult {
-	result := DiagnosticResult{Name: "VPN Server (10.8.0.1)"}
-
-	out, err := exec.Command("ping", "-c", "2", "-W", "3", "10.8.0.1").CombinedOutput()
-	if err != nil {
-		result.Status = "fail"
-		result.Message = "Server unreachable"
-		result.Details = "Ping failed - VPN tunnel may be down"
-		return result
-	}
-
-	// Extract latency
-	output := string(out)
-	if strings.Contains(output, "time=") {
-		parts := strings.Split(output, "time=")
-		if len(parts) > 1 {
-			timePart := strings.Split(parts[1], " ")[0]
-			result.Details = fmt.Sprintf("Latency: %s ms", timePart)
-		}
-	}
-
-	result.Status = "pass"
-	result.Message = "Server reachable"
-	return result
-}
-
-// checkLocalSSH checks if SSH access is enabled on this node.
-func checkLocalSSH() DiagnosticResult {
-	result := DiagnosticResult{Name: "SSH Access"}
-
-	// Check if sshd is running / port 22 is listening
-	var cmd *exec.Cmd
-	if runtime.GOOS == "darwin" {
-		// macOS: check systemsetup for remote login
-		cmd = exec.Command("sh", "-c", "systemsetup -getremotelogin 2>/dev/null | grep -i on")
-	} else {
-		// Linux: check if sshd is running
-		cmd = exec.Command("sh", "-c", "systemctl is-active sshd 2>/dev/null || pgrep sshd")
-	}
-
-	if err := cmd.Run(); err != nil {
-		result.Status = "warn"
-		result.Message = "SSH not enabled"
-		result.Details = "Remote Login (SSH) is disabled on this node"
-		return result
-	}
-
-	result.Status = "pass"
-	result.Message = "SSH enabled"
-	result.Details = "Remote Login (SSH) is available"
-	return result
-}
-
-// checkNetworkPeers runs diagnostics for all network peers.
-func checkNetworkPeers(nodeAddr string, localVersion string) []PeerDiagnostic {
-	peers := []PeerDiagnostic{}
-
-	client, err := cli.NewClient(nodeAddr)
-	if err != nil {
-		return peers
-	}
-	defer client.Close()
-
-	// Get peer list from network
-	peerList, err := client.NetworkPeers()
-	if err != nil {
-		return peers
-	}
-
-	// Also get connected peers for more detailed info (version, etc.)
-	connectedPeers, _ := client.Peers()
-	peerVersions := make(map[string]string)
-	if connectedPeers != nil {
-		for _, p := range connectedPeers.Peers {
-			peerVersions[p.VPNAddress] = p.Version
-		}
-	}
-
-	expectedIP := "95.217.238.72" // Helsinki VPN server
-
-	for _, p := range peerList.Peers {
-		pd := PeerDiagnostic{
-			Name:       p.Name,
-			VPNAddress: p.VPNAddress,
-			OS:         p.OS,
-			PublicIP:   p.PublicIP,
-		}
-
-		// Get version from connected peers if available
-		if v, ok := peerVersions[p.VPNAddress]; ok {
-			pd.Version = v
-		}
-
-		// Check 1: Reachability (ping)
-		pingCmd := exec.Command("ping", "-c", "1", "-W", "2", p.VPNAddress)
-		pd.Reachable = pingCmd.Run() == nil
-
-		// Check 2: Version match
-		if pd.Version != "" && localVersion != "" {
-			pd.VersionMatch = pd.Version == localVersion
-			if !pd.VersionMatch {
-				pd.VersionWarning = fmt.Sprintf("Version mismatch: %s (local: %s)", pd.Version, localVersion)
-			}
-		} else {
-			pd.VersionMatch = true // Can't check, assume OK
-		}
-
-		// Check 3: VPN routing (based on public IP)
-		if p.PublicIP != "" {
-			pd.RoutingVPN = p.PublicIP == expectedIP
-			if !pd.RoutingVPN {
-				pd.RoutingWarning = fmt.Sprintf("Not routing through VPN (IP: %s)", p.PublicIP)
-			}
-		}
-
-		// Check 4: SSH accessibility (try to connect to port 22)
-		sshCheck := exec.Command("nc", "-z", "-w", "2", p.VPNAddress, "22")
-		pd.SSHAccessible = sshCheck.Run() == nil
-		if !pd.SSHAccessible {
-			pd.SSHWarning = "SSH port 22 not accessible"
-		}
-
-		peers = append(peers, pd)
-	}
-
-	return peers
-}
-
-func checkRouting() DiagnosticResult {
-	result := DiagnosticResult{Name: "Traffic Routing"}
-
-	publicIP, err := getPublicIP()
-	if err != nil {
-		result.Status = "warn"
-		result.Message = "Could not determine public IP"
-		result.Details = err.Error()
-		return result
-	}
-
-	// Check if routed through VPN server
-	expectedIP := "95.217.238.72" // Helsinki server
-	if publicIP == expectedIP {
-		result.Status = "pass"
-		result.Message = "Traffic routed through VPN"
-		result.Details = fmt.Sprintf("Public IP: %s (Helsinki)", publicIP)
-	} else {
-		result.Status = "warn"
-		result.Message = "Traffic NOT routed through VPN"
-		result.Details = fmt.Sprintf("Public IP: %s (expected: %s)", publicIP, expectedIP)
-	}
-
-	return result
-}
-
-func checkDNS() DiagnosticResult {
-	result := DiagnosticResult{Name: "DNS Resolution"}
-
-	start := time.Now()
-	out, err := exec.Command("nslookup", "google.com").CombinedOutput()
-	elapsed := time.Since(start)
-
-	if err != nil {
-		result.Status = "fail"
-		result.Message = "DNS resolution failed"
-		result.Details = string(out)
-		return result
-	}
-
-	result.Status = "pass"
-	result.Message = "DNS working"
-	result.Details = fmt.Sprintf("Resolution time: %v", elapsed.Round(time.Millisecond))
-	return result
-}
-
-func checkNetworkInterface() DiagnosticResult {
-	result := DiagnosticResult{Name: "VPN Interface"}
-
-	var tunName string
-	if runtime.GOOS == "darwin" {
-		// macOS uses utun devices
-		out, err := exec.Command("sh", "-c", "ifconfig | grep -E '^utun' | head -1 | cut -d: -f1").CombinedOutput()
-		if err == nil && len(out) > 0 {
-			tunName = strings.TrimSpace(string(out))
-		}
-	} else {
-		// Linux uses tun0
-		tunName = "tun0"
-	}
-
-	if tunName == "" {
-		result.Status = "fail"
-		result.Message = "No VPN interface found"
-		return result
-	}
-
-	// Check if interface is up
-	out, err := exec.Command("ifconfig", tunName).CombinedOutput()
-	if err != nil {
-		result.Status = "fail"
-		result.Message = fmt.Sprintf("Interface %s not found", tunName)
-		result.Details = err.Error()
-		return result
-	}
-
-	output := string(out)
-	if strings.Contains(output, "UP") {
-		result.Status = "pass"
-		result.Message = fmt.Sprintf("Interface %s is UP", tunName)
-
-		// Extract IP if present
-		if strings.Contains(output, "inet ") {
-			lines := strings.Split(output, "\n")
-			for _, line := range lines {
-				if strings.Contains(line, "inet ") && strings.Contains(line, "10.8.0") {
-					parts := strings.Fields(line)
-					if len(parts) >= 2 {
-						result.Details = fmt.Sprintf("IP: %s", parts[1])
-					}
-				}
-			}
-		}
-	} else {
-		result.Status = "fail"
-		result.Message = fmt.Sprintf("Interface %s is DOWN", tunName)
-	}
-
-	return result
-}
-
-func checkInternet() DiagnosticResult {
-	result := DiagnosticResult{Name: "Internet Connectivity"}
-
-	// Try to reach a reliable external host
-	start := time.Now()
-	resp, err := http.Get("https://www.google.com")
-	elapsed := time.Since(start)
-
-	if err != nil {
-		result.Status = "fail"
-		result.Message = "No internet connectivity"
-		result.Details = err.Error()
-		return result
-	}
-	resp.Body.Close()
-
-	result.Status = "pass"
-	result.Message = "Internet reachable"
-	result.Details = fmt.Sprintf("Response time: %v", elapsed.Round(time.Millisecond))
-	return result
-}
-
-func printDiagnostics(report *DiagnosticsReport, verbose bool) {
+func printDiagnostics(report *diagnose.Report, verbose bool) {
 	fmt.Println()
 	fmt.Println(colorBlue + "VPN Connectivity Diagnostics" + colorReset)
 	fmt.Println("═══════════════════════════════════════════════════════════════")
@@ -2005,8 +6254,8 @@ func printDiagnostics(report *DiagnosticsReport, verbose bool) {
 	if report.Summary.Failed > 0 {
 		fmt.Println(colorYellow + "Recommendations:" + colorReset)
 		for _, check := range report.LocalNode.Checks {
-			if check.Status == "fail" {
-				printRecommendation(check.Name)
+			for _, rec := range check.Recommendations {
+				fmt.Println("  - " + rec)
 			}
 		}
 		fmt.Println()
@@ -2021,7 +6270,7 @@ func printDiagnostics(report *DiagnosticsReport, verbose bool) {
 	fmt.Println()
 }
 
-func printCheck(check DiagnosticResult, verbose bool) {
+func printCheck(check diagnose.Check, verbose bool) {
 	var statusIcon, statusColor string
 	switch check.Status {
 	case "pass":
@@ -2042,7 +6291,7 @@ func printCheck(check DiagnosticResult, verbose bool) {
 	}
 }
 
-func printPeerDiagnostic(peer PeerDiagnostic, verbose bool) {
+func printPeerDiagnostic(peer diagnose.Peer, verbose bool) {
 	// Status icon based on reachability
 	var statusIcon, statusColor string
 	if peer.Reachable {
@@ -2105,56 +6354,152 @@ func printPeerDiagnostic(peer PeerDiagnostic, verbose bool) {
 	fmt.Println()
 }
 
-func printRecommendation(checkName string) {
-	switch checkName {
-	case "Local VPN Node":
-		fmt.Println("  - Check if vpn-node daemon is running: ps aux | grep vpn-node")
-		fmt.Println("  - Restart the VPN service: sudo launchctl bootout/bootstrap")
-	case "VPN Server (10.8.0.1)":
-		fmt.Println("  - VPN server may be down - check server status")
-		fmt.Println("  - Restart local VPN client to reconnect")
-	case "VPN Interface":
-		fmt.Println("  - VPN tunnel not established - restart VPN client")
-	case "Internet Connectivity":
-		fmt.Println("  - Check if route-all is enabled but VPN is disconnected")
-		fmt.Println("  - Try: vpn disconnect to restore direct routing")
-	case "DNS Resolution":
-		fmt.Println("  - DNS may be misconfigured - check /etc/resolv.conf")
-		fmt.Println("  - Try flushing DNS: sudo dscacheutil -flushcache")
-	}
+// diagnosticFix is an automated remedy for a failing check. Fixes must be
+// safe to run again when the problem is already gone.
+type diagnosticFix struct {
+	action  string
+	apply   func() error
+	recheck func() diagnose.Check
 }
 
-// getRecentEvents fetches recent lifecycle events to help explain issues.
-func getRecentEvents(nodeAddr string) []RecentEvent {
-	events := []RecentEvent{}
-
-	client, err := cli.NewClient(nodeAddr)
-	if err != nil {
-		return events
+// diagnosticFixes returns the remedies by check name.
+func diagnosticFixes(nodeAddr, subnet string) map[string]diagnosticFix {
+	flush := "resolvectl flush-caches"
+	if runtime.GOOS == "darwin" {
+		flush = "sudo dscacheutil -flushcache"
 	}
-	defer client.Close()
 
-	result, err := client.Lifecycle(5) // Get last 5 events
-	if err != nil {
-		return events
+	return map[string]diagnosticFix{
+		"VPN Interface": {
+			action: "vpn connect (route traffic through the VPN)",
+			apply: func() error {
+				client, err := cli.NewClient(nodeAddr)
+				if err != nil {
+					return err
+				}
+				defer client.Close()
+				result, err := client.Connect()
+				if err != nil {
+					return err
+				}
+				if !result.Success {
+					return fmt.Errorf("%s", result.Message)
+				}
+				return nil
+			},
+			recheck: func() diagnose.Check { return diagnose.CheckNetworkInterface(subnet) },
+		},
+		"Internet Connectivity": {
+			action: "vpn restore (remove default routes through a dead tunnel)",
+			apply: func() error {
+				if os.Getuid() != 0 {
+					return fmt.Errorf("changing routes requires root: run 'sudo vpn diagnose --fix'")
+				}
+				_, err := tunnel.RestoreDefaultRoute()
+				return err
+			},
+			recheck: diagnose.CheckInternet,
+		},
+		"DNS Resolution": {
+			action: flush,
+			apply: func() error {
+				if runtime.GOOS == "darwin" {
+					if out, err := exec.Command("dscacheutil", "-flushcache").CombinedOutput(); err != nil {
+						return fmt.Errorf("dscacheutil failed: %v - %s", err, strings.TrimSpace(string(out)))
+					}
+					exec.Command("killall", "-HUP", "mDNSResponder").Run()
+					return nil
+				}
+				if _, err := exec.LookPath("resolvectl"); err != nil {
+					return fmt.Errorf("no DNS cache to flush (resolvectl not found)")
+				}
+				if out, err := exec.Command("resolvectl", "flush-caches").CombinedOutput(); err != nil {
+					return fmt.Errorf("resolvectl failed: %v - %s", err, strings.TrimSpace(string(out)))
+				}
+				return nil
+			},
+			recheck: diagnose.CheckDNS,
+		},
 	}
+}
 
-	for _, e := range result.Events {
-		// Only include events that might explain issues
-		if e.Event == "CRASH" || e.Event == "CONNECTION_LOST" || e.Event == "SIGNAL" {
-			events = append(events, RecentEvent{
-				Timestamp: e.Timestamp,
-				Event:     e.Event,
-				Reason:    e.Reason,
-			})
+// runFixes tries to repair the failing local checks of a report, asking
+// before each fix unless assumeYes. Attempts are recorded as AUTOFIX
+// lifecycle events on the node.
+func runFixes(report *diagnose.Report, nodeAddr string, assumeYes, quiet bool) []diagnose.FixResult {
+	fixes := diagnosticFixes(nodeAddr, report.LocalNode.Subnet)
+	results := []diagnose.FixResult{}
+	stdin := bufio.NewReader(os.Stdin)
+
+	for _, check := range report.LocalNode.Checks {
+		fix, ok := fixes[check.Name]
+		if check.Status != "fail" || !ok {
+			continue
+		}
+
+		result := diagnose.FixResult{Check: check.Name, Action: fix.action}
+		if !quiet {
+			fmt.Printf("%sFix:%s %s → %s\n", colorCyan, colorReset, check.Name, fix.action)
+		}
+		if !assumeYes {
+			fmt.Print("  Apply? [y/N] ")
+			answer, _ := stdin.ReadString('\n')
+			answer = strings.ToLower(strings.TrimSpace(answer))
+			if answer != "y" && answer != "yes" {
+				fmt.Println("  Skipped")
+				results = append(results, result)
+				continue
+			}
+		}
+
+		result.Applied = true
+		if err := fix.apply(); err != nil {
+			result.Error = err.Error()
+		}
+		recheck := fix.recheck()
+		result.Recheck = &recheck
+		result.Fixed = recheck.Status == "pass"
+
+		if !quiet {
+			switch {
+			case result.Fixed:
+				fmt.Printf("  %s✓ Fixed%s: %s\n", colorGreen, colorReset, recheck.Message)
+			case result.Error != "":
+				fmt.Printf("  %s✗ Fix failed%s: %s\n", colorRed, colorReset, result.Error)
+			default:
+				fmt.Printf("  %s✗ Still failing%s: %s\n", colorRed, colorReset, recheck.Message)
+			}
 		}
+		recordAutofix(nodeAddr, result)
+		results = append(results, result)
+	}
+
+	if len(results) == 0 && !quiet {
+		fmt.Println("Nothing to fix automatically.")
 	}
+	return results
+}
 
-	return events
+// recordAutofix stores a fix attempt as an AUTOFIX lifecycle event. The node
+// may be the thing that is broken, so failures are only warned about.
+func recordAutofix(nodeAddr string, result diagnose.FixResult) {
+	client, err := cli.NewClient(nodeAddr)
+	if err == nil {
+		defer client.Close()
+		err = client.RecordAutofix(protocol.AutofixParams{
+			Check:  result.Check,
+			Action: result.Action,
+			Fixed:  result.Fixed,
+			Error:  result.Error,
+		})
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "  %sWarning: fix not recorded in lifecycle history: %v%s\n", colorYellow, err, colorReset)
+	}
 }
 
 // printRecentEvents shows recent events that might explain issues.
-func printRecentEvents(events []RecentEvent) {
+func printRecentEvents(events []diagnose.RecentEvent) {
 	if len(events) == 0 {
 		return
 	}
@@ -2171,7 +6516,7 @@ func printRecentEvents(events []RecentEvent) {
 		switch e.Event {
 		case "CRASH":
 			eventColor = colorRed
-		case "CONNECTION_LOST":
+		case "CONNECTION_LOST", "DEGRADED":
 			eventColor = colorYellow
 		case "SIGNAL":
 			eventColor = colorBlue
@@ -2182,7 +6527,7 @@ func printRecentEvents(events []RecentEvent) {
 }
 
 // printNextSteps shows helpful CLI commands based on the diagnosis.
-func printNextSteps(report *DiagnosticsReport) {
+func printNextSteps(report *diagnose.Report) {
 	fmt.Println(colorCyan + "Explore Further (CLI Commands)" + colorReset)
 	fmt.Println("───────────────────────────────────────────────────────────────")
 	fmt.Println("  View logs:          vpn logs --earliest=-1h --level=ERROR,WARN")
@@ -7,16 +7,23 @@ import (
 	"fmt"
 	"net"
 	"sync/atomic"
+	"time"
 
 	"github.com/miguelemosreverte/vpn/internal/protocol"
 )
 
+// ControlToken is the token new clients send with every request, for nodes
+// started with --control-token or --readonly-token. Set from the vpn
+// command's --token flag; other callers use SetToken.
+var ControlToken string
+
 // Client connects to a node's control socket.
 type Client struct {
 	conn    net.Conn
 	scanner *bufio.Scanner
 	encoder *json.Encoder
 	nextID  uint64
+	token   string
 }
 
 // NewClient creates a new CLI client.
@@ -25,10 +32,53 @@ func NewClient(addr string) (*Client, error) {
 		addr = "127.0.0.1:9001"
 	}
 
-	conn, err := net.Dial("tcp", addr)
+	client, err := dial(addr, 0)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to node at %s: %w", addr, err)
 	}
+	return client, nil
+}
+
+// PeerDialTimeout bounds how long DialPeer waits for a peer to answer.
+const PeerDialTimeout = 5 * time.Second
+
+// DialPeer connects to the control socket of the peer at VPN address
+// vpnAddr (<vpnAddr>:9001, over the tunnel). An offline peer fails after
+// PeerDialTimeout instead of hanging until the TCP connect gives up.
+func DialPeer(vpnAddr string) (*Client, error) {
+	addr := net.JoinHostPort(vpnAddr, "9001")
+
+	client, err := dial(addr, PeerDialTimeout)
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return nil, fmt.Errorf("peer %s unreachable: no answer on %s within %s", vpnAddr, addr, PeerDialTimeout)
+		}
+		return nil, fmt.Errorf("peer %s unreachable: %w", vpnAddr, err)
+	}
+	return client, nil
+}
+
+// PeerStatus fetches the status of the peer at VPN address vpnAddr, giving
+// up after timeout (connecting and answering included).
+func PeerStatus(vpnAddr string, timeout time.Duration) (*protocol.StatusResult, error) {
+	addr := net.JoinHostPort(vpnAddr, "9001")
+
+	client, err := dial(addr, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("peer %s unreachable: %w", vpnAddr, err)
+	}
+	defer client.Close()
+
+	client.conn.SetDeadline(time.Now().Add(timeout))
+	return client.Status()
+}
+
+// dial connects to a control socket; a zero timeout waits indefinitely.
+func dial(addr string, timeout time.Duration) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
 
 	scanner := bufio.NewScanner(conn)
 	// Increase buffer size for large responses (e.g., metrics with many data points)
@@ -38,9 +88,15 @@ func NewClient(addr string) (*Client, error) {
 		conn:    conn,
 		scanner: scanner,
 		encoder: json.NewEncoder(conn),
+		token:   ControlToken,
 	}, nil
 }
 
+// SetToken sets the control token sent with this client's requests.
+func (c *Client) SetToken(token string) {
+	c.token = token
+}
+
 // Close closes the connection to the node.
 func (c *Client) Close() error {
 	return c.conn.Close()
@@ -48,6 +104,14 @@ func (c *Client) Close() error {
 
 // call sends a request and waits for a response.
 func (c *Client) call(method string, params interface{}) (*protocol.Response, error) {
+	if err := c.send(method, params); err != nil {
+		return nil, err
+	}
+	return c.receive()
+}
+
+// send writes a request to the node.
+func (c *Client) send(method string, params interface{}) error {
 	id := atomic.AddUint64(&c.nextID, 1)
 
 	var paramsJSON json.RawMessage
@@ -55,7 +119,7 @@ func (c *Client) call(method string, params interface{}) (*protocol.Response, er
 		var err error
 		paramsJSON, err = json.Marshal(params)
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal params: %w", err)
+			return fmt.Errorf("failed to marshal params: %w", err)
 		}
 	}
 
@@ -63,12 +127,18 @@ func (c *Client) call(method string, params interface{}) (*protocol.Response, er
 		ID:     id,
 		Method: method,
 		Params: paramsJSON,
+		Token:  c.token,
 	}
 
 	if err := c.encoder.Encode(req); err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return fmt.Errorf("failed to send request: %w", err)
 	}
 
+	return nil
+}
+
+// receive reads the next response line from the node.
+func (c *Client) receive() (*protocol.Response, error) {
 	if !c.scanner.Scan() {
 		if err := c.scanner.Err(); err != nil {
 			return nil, fmt.Errorf("failed to read response: %w", err)
@@ -92,7 +162,7 @@ func (c *Client) Status() (*protocol.StatusResult, error) {
 	}
 
 	if resp.Error != nil {
-		return nil, fmt.Errorf("server error: %s", resp.Error.Message)
+		return nil, resp.Error.Err()
 	}
 
 	var result protocol.StatusResult
@@ -111,7 +181,92 @@ func (c *Client) Peers() (*protocol.PeersResult, error) {
 	}
 
 	if resp.Error != nil {
-		return nil, fmt.Errorf("server error: %s", resp.Error.Message)
+		return nil, resp.Error.Err()
+	}
+
+	var result protocol.PeersResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse result: %w", err)
+	}
+
+	return &result, nil
+}
+
+// PeerRates returns the per-peer rate limits and current rates.
+func (c *Client) PeerRates() (*protocol.PeerRatesResult, error) {
+	resp, err := c.call("peer_rates", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Error != nil {
+		return nil, resp.Error.Err()
+	}
+
+	var result protocol.PeerRatesResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse result: %w", err)
+	}
+
+	return &result, nil
+}
+
+// SetPeerLimit sets a peer's rate limit in Mbps (0 removes it) and returns
+// the updated rates.
+func (c *Client) SetPeerLimit(vpnAddress string, mbps float64) (*protocol.PeerRatesResult, error) {
+	params := protocol.SetPeerLimitParams{VPNAddress: vpnAddress, Mbps: mbps}
+
+	resp, err := c.call("set_peer_limit", params)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Error != nil {
+		return nil, resp.Error.Err()
+	}
+
+	var result protocol.PeerRatesResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse result: %w", err)
+	}
+
+	return &result, nil
+}
+
+// RemovePeer force-disconnects a peer from the server by VPN address.
+func (c *Client) RemovePeer(vpnAddress string) (*protocol.PeersResult, error) {
+	params := protocol.RemovePeerParams{VPNAddress: vpnAddress}
+
+	resp, err := c.call("remove_peer", params)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Error != nil {
+		return nil, resp.Error.Err()
+	}
+
+	var result protocol.PeersResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse result: %w", err)
+	}
+
+	return &result, nil
+}
+
+// ForgetPeer removes a node from the server for good: it is disconnected if
+// connected, and its VPN IP assignment and connection state are deleted.
+// peer is a VPN IP, node name or hostname.
+func (c *Client) ForgetPeer(peer string) (*protocol.PeersResult, error) {
+	params := protocol.RemovePeerParams{VPNAddress: peer, Forget: true}
+
+	resp, err := c.call("remove_peer", params)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Error != nil {
+		return nil, resp.Error.Err()
 	}
 
 	var result protocol.PeersResult
@@ -124,18 +279,25 @@ func (c *Client) Peers() (*protocol.PeersResult, error) {
 
 // Update triggers a node update.
 func (c *Client) Update(all, rolling bool) (*protocol.UpdateResult, error) {
-	params := protocol.UpdateParams{
+	return c.update(protocol.UpdateParams{
 		All:     all,
 		Rolling: rolling,
-	}
+	})
+}
+
+// UpdatePlan reports what an update would change, without updating.
+func (c *Client) UpdatePlan() (*protocol.UpdateResult, error) {
+	return c.update(protocol.UpdateParams{DryRun: true})
+}
 
+func (c *Client) update(params protocol.UpdateParams) (*protocol.UpdateResult, error) {
 	resp, err := c.call("update", params)
 	if err != nil {
 		return nil, err
 	}
 
 	if resp.Error != nil {
-		return nil, fmt.Errorf("server error: %s", resp.Error.Message)
+		return nil, resp.Error.Err()
 	}
 
 	var result protocol.UpdateResult
@@ -146,6 +308,45 @@ func (c *Client) Update(all, rolling bool) (*protocol.UpdateResult, error) {
 	return &result, nil
 }
 
+// Rollback reverts the node to the commit it ran before its last deploy.
+// The node restarts after answering.
+func (c *Client) Rollback() (*protocol.RollbackResult, error) {
+	resp, err := c.call("rollback", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Error != nil {
+		return nil, resp.Error.Err()
+	}
+
+	var result protocol.RollbackResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse result: %w", err)
+	}
+
+	return &result, nil
+}
+
+// Restart asks the node to restart in place. force is required on a client.
+func (c *Client) Restart(force bool) (*protocol.RestartResult, error) {
+	resp, err := c.call("restart", protocol.RestartParams{Force: force})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Error != nil {
+		return nil, resp.Error.Err()
+	}
+
+	var result protocol.RestartResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse result: %w", err)
+	}
+
+	return &result, nil
+}
+
 // Logs retrieves logs with Splunk-like query parameters.
 func (c *Client) Logs(params protocol.LogsParams) (*protocol.LogsResult, error) {
 	resp, err := c.call("logs", params)
@@ -154,7 +355,7 @@ func (c *Client) Logs(params protocol.LogsParams) (*protocol.LogsResult, error)
 	}
 
 	if resp.Error != nil {
-		return nil, fmt.Errorf("server error: %s", resp.Error.Message)
+		return nil, resp.Error.Err()
 	}
 
 	var result protocol.LogsResult
@@ -165,6 +366,47 @@ func (c *Client) Logs(params protocol.LogsParams) (*protocol.LogsResult, error)
 	return &result, nil
 }
 
+// FollowLogs retrieves logs like Logs, then keeps streaming entries as the
+// node writes them. fn is called for every entry, oldest first, until it
+// returns an error or the connection is closed.
+func (c *Client) FollowLogs(params protocol.LogsParams, fn func(*protocol.LogEntry) error) error {
+	params.Follow = true
+	if err := c.send("logs", params); err != nil {
+		return err
+	}
+
+	for {
+		resp, err := c.receive()
+		if err != nil {
+			return err
+		}
+
+		if resp.Error != nil {
+			return resp.Error.Err()
+		}
+
+		var result protocol.LogsResult
+		if err := json.Unmarshal(resp.Result, &result); err != nil {
+			return fmt.Errorf("failed to parse result: %w", err)
+		}
+
+		// Entries arrive newest first
+		for i := len(result.Entries) - 1; i >= 0; i-- {
+			if err := fn(&result.Entries[i]); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// RemoteLogs retrieves logs from the peer at VPN address addr, fetched
+// through this node. An unreachable peer gives an empty result with
+// Warning set to protocol.LogsWarningPeerUnreachable.
+func (c *Client) RemoteLogs(addr string, params protocol.LogsParams) (*protocol.LogsResult, error) {
+	params.Peer = addr
+	return c.Logs(params)
+}
+
 // Stats retrieves metrics with Splunk-like query parameters.
 func (c *Client) Stats(params protocol.StatsParams) (*protocol.StatsResult, error) {
 	resp, err := c.call("stats", params)
@@ -173,7 +415,7 @@ func (c *Client) Stats(params protocol.StatsParams) (*protocol.StatsResult, erro
 	}
 
 	if resp.Error != nil {
-		return nil, fmt.Errorf("server error: %s", resp.Error.Message)
+		return nil, resp.Error.Err()
 	}
 
 	var result protocol.StatsResult
@@ -192,7 +434,7 @@ func (c *Client) Connect() (*protocol.ConnectionResult, error) {
 	}
 
 	if resp.Error != nil {
-		return nil, fmt.Errorf("server error: %s", resp.Error.Message)
+		return nil, resp.Error.Err()
 	}
 
 	var result protocol.ConnectionResult
@@ -211,7 +453,7 @@ func (c *Client) Disconnect() (*protocol.ConnectionResult, error) {
 	}
 
 	if resp.Error != nil {
-		return nil, fmt.Errorf("server error: %s", resp.Error.Message)
+		return nil, resp.Error.Err()
 	}
 
 	var result protocol.ConnectionResult
@@ -230,7 +472,7 @@ func (c *Client) ConnectionStatus() (*protocol.ConnectionStatus, error) {
 	}
 
 	if resp.Error != nil {
-		return nil, fmt.Errorf("server error: %s", resp.Error.Message)
+		return nil, resp.Error.Err()
 	}
 
 	var result protocol.ConnectionStatus
@@ -241,6 +483,26 @@ func (c *Client) ConnectionStatus() (*protocol.ConnectionStatus, error) {
 	return &result, nil
 }
 
+// Routes retrieves the routes the node installed and whether each is still
+// in its routing table.
+func (c *Client) Routes() (*protocol.RoutesResult, error) {
+	resp, err := c.call("routes", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Error != nil {
+		return nil, resp.Error.Err()
+	}
+
+	var result protocol.RoutesResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse result: %w", err)
+	}
+
+	return &result, nil
+}
+
 // Topology retrieves the full network topology.
 func (c *Client) Topology() (*protocol.TopologyResult, error) {
 	resp, err := c.call("topology", nil)
@@ -249,7 +511,7 @@ func (c *Client) Topology() (*protocol.TopologyResult, error) {
 	}
 
 	if resp.Error != nil {
-		return nil, fmt.Errorf("server error: %s", resp.Error.Message)
+		return nil, resp.Error.Err()
 	}
 
 	var result protocol.TopologyResult
@@ -260,6 +522,92 @@ func (c *Client) Topology() (*protocol.TopologyResult, error) {
 	return &result, nil
 }
 
+// WatchTopology streams the network topology. fn is called with the current
+// topology and again on every change until it returns an error or the
+// connection is closed.
+func (c *Client) WatchTopology(fn func(*protocol.TopologyResult) error) error {
+	if err := c.send("topology_watch", nil); err != nil {
+		return err
+	}
+
+	for {
+		resp, err := c.receive()
+		if err != nil {
+			return err
+		}
+
+		if resp.Error != nil {
+			return resp.Error.Err()
+		}
+
+		var result protocol.TopologyResult
+		if err := json.Unmarshal(resp.Result, &result); err != nil {
+			return fmt.Errorf("failed to parse result: %w", err)
+		}
+
+		if err := fn(&result); err != nil {
+			return err
+		}
+	}
+}
+
+// WatchPeers streams the network's peer list. fn is called with the current
+// list and again whenever a peer connects or disconnects, until it returns
+// an error or the connection is closed.
+func (c *Client) WatchPeers(fn func(*protocol.PeersStreamResult) error) error {
+	if err := c.send("peers_stream", nil); err != nil {
+		return err
+	}
+
+	for {
+		resp, err := c.receive()
+		if err != nil {
+			return err
+		}
+
+		if resp.Error != nil {
+			return resp.Error.Err()
+		}
+
+		var result protocol.PeersStreamResult
+		if err := json.Unmarshal(resp.Result, &result); err != nil {
+			return fmt.Errorf("failed to parse result: %w", err)
+		}
+
+		if err := fn(&result); err != nil {
+			return err
+		}
+	}
+}
+
+// WatchStats streams metric points as the node records them. fn is called
+// with each batch until it returns an error or the connection is closed.
+func (c *Client) WatchStats(params protocol.StatsWatchParams, fn func(*protocol.StatsWatchResult) error) error {
+	if err := c.send("stats_watch", params); err != nil {
+		return err
+	}
+
+	for {
+		resp, err := c.receive()
+		if err != nil {
+			return err
+		}
+
+		if resp.Error != nil {
+			return resp.Error.Err()
+		}
+
+		var result protocol.StatsWatchResult
+		if err := json.Unmarshal(resp.Result, &result); err != nil {
+			return fmt.Errorf("failed to parse result: %w", err)
+		}
+
+		if err := fn(&result); err != nil {
+			return err
+		}
+	}
+}
+
 // NetworkPeers retrieves the list of network peers (from PEER_LIST).
 func (c *Client) NetworkPeers() (*protocol.NetworkPeersResult, error) {
 	resp, err := c.call("network_peers", nil)
@@ -268,7 +616,7 @@ func (c *Client) NetworkPeers() (*protocol.NetworkPeersResult, error) {
 	}
 
 	if resp.Error != nil {
-		return nil, fmt.Errorf("server error: %s", resp.Error.Message)
+		return nil, resp.Error.Err()
 	}
 
 	var result protocol.NetworkPeersResult
@@ -279,17 +627,15 @@ func (c *Client) NetworkPeers() (*protocol.NetworkPeersResult, error) {
 	return &result, nil
 }
 
-// Lifecycle retrieves recent lifecycle events.
-func (c *Client) Lifecycle(limit int) (*protocol.LifecycleResult, error) {
-	params := protocol.LifecycleParams{Limit: limit}
-
+// Lifecycle retrieves lifecycle events matching params, newest first.
+func (c *Client) Lifecycle(params protocol.LifecycleParams) (*protocol.LifecycleResult, error) {
 	resp, err := c.call("lifecycle", params)
 	if err != nil {
 		return nil, err
 	}
 
 	if resp.Error != nil {
-		return nil, fmt.Errorf("server error: %s", resp.Error.Message)
+		return nil, resp.Error.Err()
 	}
 
 	var result protocol.LifecycleResult
@@ -300,9 +646,10 @@ func (c *Client) Lifecycle(limit int) (*protocol.LifecycleResult, error) {
 	return &result, nil
 }
 
-// CrashStats retrieves crash statistics.
-func (c *Client) CrashStats(since string) (*protocol.CrashStatsResult, error) {
-	params := protocol.CrashStatsParams{Since: since}
+// CrashStats retrieves crash statistics between since and until (Splunk
+// time syntax, parsed by the node; "" = its defaults).
+func (c *Client) CrashStats(since, until string) (*protocol.CrashStatsResult, error) {
+	params := protocol.CrashStatsParams{Since: since, Until: until}
 
 	resp, err := c.call("crash_stats", params)
 	if err != nil {
@@ -310,7 +657,7 @@ func (c *Client) CrashStats(since string) (*protocol.CrashStatsResult, error) {
 	}
 
 	if resp.Error != nil {
-		return nil, fmt.Errorf("server error: %s", resp.Error.Message)
+		return nil, resp.Error.Err()
 	}
 
 	var result protocol.CrashStatsResult
@@ -331,7 +678,7 @@ func (c *Client) SendHandshake(handshake protocol.InstallHandshake) (*protocol.I
 	}
 
 	if resp.Error != nil {
-		return nil, fmt.Errorf("server error: %s", resp.Error.Message)
+		return nil, resp.Error.Err()
 	}
 
 	var result protocol.InstallHandshakeResult
@@ -343,19 +690,14 @@ func (c *Client) SendHandshake(handshake protocol.InstallHandshake) (*protocol.I
 }
 
 // HandshakeHistory retrieves the history of install handshakes.
-func (c *Client) HandshakeHistory(nodeName string, limit int) (*protocol.HandshakeHistoryResult, error) {
-	params := protocol.HandshakeHistoryParams{
-		NodeName: nodeName,
-		Limit:    limit,
-	}
-
+func (c *Client) HandshakeHistory(params protocol.HandshakeHistoryParams) (*protocol.HandshakeHistoryResult, error) {
 	resp, err := c.call("handshake_history", params)
 	if err != nil {
 		return nil, err
 	}
 
 	if resp.Error != nil {
-		return nil, fmt.Errorf("server error: %s", resp.Error.Message)
+		return nil, resp.Error.Err()
 	}
 
 	var result protocol.HandshakeHistoryResult
@@ -365,3 +707,306 @@ func (c *Client) HandshakeHistory(nodeName string, limit int) (*protocol.Handsha
 
 	return &result, nil
 }
+
+// Traffic returns the remote hosts VPN clients exchanged the most bytes with
+// (asked of the server by client nodes).
+func (c *Client) Traffic(params protocol.TrafficParams) (*protocol.TrafficResult, error) {
+	resp, err := c.call("traffic", params)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Error != nil {
+		return nil, resp.Error.Err()
+	}
+
+	var result protocol.TrafficResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse result: %w", err)
+	}
+
+	return &result, nil
+}
+
+// Export streams an export from the node. fn is called for the header chunk
+// and for every row as it arrives; the final chunk (Done set) is returned.
+func (c *Client) Export(params protocol.ExportParams, fn func(*protocol.ExportChunk) error) (*protocol.ExportChunk, error) {
+	if err := c.send("export", params); err != nil {
+		return nil, err
+	}
+
+	for {
+		resp, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.Error != nil {
+			return nil, resp.Error.Err()
+		}
+
+		var chunk protocol.ExportChunk
+		if err := json.Unmarshal(resp.Result, &chunk); err != nil {
+			return nil, fmt.Errorf("failed to parse result: %w", err)
+		}
+
+		if chunk.Done {
+			return &chunk, nil
+		}
+
+		if err := fn(&chunk); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Retention retrieves the storage retention policy.
+func (c *Client) Retention() (*protocol.RetentionResult, error) {
+	resp, err := c.call("retention", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Error != nil {
+		return nil, resp.Error.Err()
+	}
+
+	var result protocol.RetentionResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse result: %w", err)
+	}
+
+	return &result, nil
+}
+
+// SetRetention changes the storage retention policy at runtime.
+func (c *Client) SetRetention(params protocol.SetRetentionParams) (*protocol.RetentionResult, error) {
+	resp, err := c.call("set_retention", params)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Error != nil {
+		return nil, resp.Error.Err()
+	}
+
+	var result protocol.RetentionResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse result: %w", err)
+	}
+
+	return &result, nil
+}
+
+// Preferences retrieves the dashboard preferences stored on the node.
+func (c *Client) Preferences() (*protocol.PreferencesResult, error) {
+	resp, err := c.call("preferences", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Error != nil {
+		return nil, resp.Error.Err()
+	}
+
+	var result protocol.PreferencesResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse result: %w", err)
+	}
+
+	return &result, nil
+}
+
+// SetPreferences stores dashboard preferences on the node and returns all of
+// them.
+func (c *Client) SetPreferences(prefs map[string]string) (*protocol.PreferencesResult, error) {
+	resp, err := c.call("set_preferences", protocol.SetPreferencesParams{Preferences: prefs})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Error != nil {
+		return nil, resp.Error.Err()
+	}
+
+	var result protocol.PreferencesResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse result: %w", err)
+	}
+
+	return &result, nil
+}
+
+// BenchServer starts the benchmark responder on the node for durationSec seconds.
+func (c *Client) BenchServer(durationSec int) (*protocol.BenchServerResult, error) {
+	resp, err := c.call("bench_server", protocol.BenchServerParams{DurationSec: durationSec})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Error != nil {
+		return nil, resp.Error.Err()
+	}
+
+	var result protocol.BenchServerResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse result: %w", err)
+	}
+
+	return &result, nil
+}
+
+// BenchClient runs a benchmark from the node to a peer's responder.
+func (c *Client) BenchClient(params protocol.BenchClientParams) (*protocol.BenchResult, error) {
+	resp, err := c.call("bench_client", params)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Error != nil {
+		return nil, resp.Error.Err()
+	}
+
+	var result protocol.BenchResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse result: %w", err)
+	}
+
+	return &result, nil
+}
+
+// Config returns the daemon configuration (without the encryption key).
+func (c *Client) Config() (*protocol.ConfigResult, error) {
+	resp, err := c.call("config", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Error != nil {
+		return nil, resp.Error.Err()
+	}
+
+	var result protocol.ConfigResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse result: %w", err)
+	}
+
+	return &result, nil
+}
+
+// Invite returns what a new client needs to join the server's network.
+func (c *Client) Invite() (*protocol.InviteResult, error) {
+	resp, err := c.call("invite", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Error != nil {
+		return nil, resp.Error.Err()
+	}
+
+	var result protocol.InviteResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse result: %w", err)
+	}
+
+	return &result, nil
+}
+
+// ConfigSet changes a mutable configuration option at runtime.
+func (c *Client) ConfigSet(key, value string) (*protocol.ConfigResult, error) {
+	resp, err := c.call("config_set", protocol.ConfigSetParams{Key: key, Value: value})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Error != nil {
+		return nil, resp.Error.Err()
+	}
+
+	var result protocol.ConfigResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse result: %w", err)
+	}
+
+	return &result, nil
+}
+
+// AlertAdd adds an alert rule.
+func (c *Client) AlertAdd(params protocol.AlertAddParams) (*protocol.AlertRule, error) {
+	resp, err := c.call("alert_add", params)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Error != nil {
+		return nil, resp.Error.Err()
+	}
+
+	var result protocol.AlertRule
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse result: %w", err)
+	}
+
+	return &result, nil
+}
+
+// AlertList retrieves all alert rules.
+func (c *Client) AlertList() (*protocol.AlertListResult, error) {
+	resp, err := c.call("alert_list", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Error != nil {
+		return nil, resp.Error.Err()
+	}
+
+	var result protocol.AlertListResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse result: %w", err)
+	}
+
+	return &result, nil
+}
+
+// AlertRemove deletes an alert rule by ID or name.
+func (c *Client) AlertRemove(rule string) (*protocol.AlertListResult, error) {
+	params := protocol.AlertRemoveParams{Rule: rule}
+
+	resp, err := c.call("alert_rm", params)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Error != nil {
+		return nil, resp.Error.Err()
+	}
+
+	var result protocol.AlertListResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse result: %w", err)
+	}
+
+	return &result, nil
+}
+
+// RecordAutofix records a 'vpn diagnose --fix' attempt in the node's
+// lifecycle history.
+func (c *Client) RecordAutofix(params protocol.AutofixParams) error {
+	resp, err := c.call("autofix", params)
+	if err != nil {
+		return err
+	}
+
+	if resp.Error != nil {
+		return resp.Error.Err()
+	}
+
+	var result protocol.AutofixResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return fmt.Errorf("failed to parse result: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,660 @@
+// Package diagnose runs the connectivity checks behind 'vpn diagnose' and the
+// dashboard's Health panel.
+package diagnose
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/miguelemosreverte/vpn/internal/cli"
+	"github.com/miguelemosreverte/vpn/internal/protocol"
+	"github.com/miguelemosreverte/vpn/internal/tunnel"
+)
+
+// Check holds the result of a single diagnostic check.
+type Check struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"` // "pass", "fail", "warn"
+	Message string `json:"message"`
+	Details string `json:"details,omitempty"`
+	// What to try, for failing checks
+	Recommendations []string `json:"recommendations,omitempty"`
+}
+
+// Peer holds diagnostic results for a single peer.
+type Peer struct {
+	Name       string `json:"name"`
+	VPNAddress string `json:"vpn_address"`
+	Version    string `json:"version"`
+	OS         string `json:"os"`
+	// Status checks
+	Reachable      bool   `json:"reachable"`      // Can ping the peer
+	VersionMatch   bool   `json:"version_match"`  // Version matches local node
+	RoutingVPN     bool   `json:"routing_vpn"`    // Traffic routed through VPN
+	SSHAccessible  bool   `json:"ssh_accessible"` // SSH port 22 accessible
+	PublicIP       string `json:"public_ip"`      // Peer's public IP
+	VersionWarning string `json:"version_warning,omitempty"`
+	RoutingWarning string `json:"routing_warning,omitempty"`
+	SSHWarning     string `json:"ssh_warning,omitempty"`
+}
+
+// RecentEvent represents a recent lifecycle event for diagnostics.
+type RecentEvent struct {
+	Timestamp string `json:"timestamp"`
+	Event     string `json:"event"`
+	Reason    string `json:"reason"`
+}
+
+// Report holds all diagnostic results.
+type Report struct {
+	Timestamp   string `json:"timestamp"`
+	NodeAddress string `json:"node_address"`
+	// This Node section
+	LocalNode struct {
+		Name       string  `json:"name"`
+		Version    string  `json:"version"`
+		VPNAddress string  `json:"vpn_address"`
+		Subnet     string  `json:"subnet,omitempty"`
+		Checks     []Check `json:"checks"`
+	} `json:"local_node"`
+	// Network Peers section
+	Peers []Peer `json:"peers"`
+	// Recent Events (for WHY explanations)
+	RecentEvents []RecentEvent `json:"recent_events,omitempty"`
+	// Repairs attempted with --fix
+	Fixes []FixResult `json:"fixes,omitempty"`
+	// Summary
+	Summary struct {
+		Passed int `json:"passed"`
+		Failed int `json:"failed"`
+		Warned int `json:"warned"`
+	} `json:"summary"`
+}
+
+// FixResult records one remediation attempted by 'vpn diagnose --fix'.
+type FixResult struct {
+	Check   string `json:"check"`
+	Action  string `json:"action"`
+	Applied bool   `json:"applied"` // false if declined at the prompt
+	Error   string `json:"error,omitempty"`
+	Fixed   bool   `json:"fixed"` // The check passes afterwards
+	Recheck *Check `json:"recheck,omitempty"`
+}
+
+// Run runs every check against the node at nodeAddr and the network it
+// belongs to.
+func Run(nodeAddr string) *Report {
+	report := &Report{
+		Timestamp:   time.Now().Format(time.RFC3339),
+		NodeAddress: nodeAddr,
+		Peers:       []Peer{},
+	}
+	report.LocalNode.Checks = []Check{}
+
+	// Get local node info first
+	client, err := cli.NewClient(nodeAddr)
+	var localVersion string
+	var serverMode bool
+	// Nodes before the subnet was configurable don't report it
+	serverIP, subnet := tunnel.DefaultServerIP, tunnel.DefaultSubnet
+	if err == nil {
+		defer client.Close()
+		if status, err := client.Status(); err == nil {
+			report.LocalNode.Name = status.NodeName
+			report.LocalNode.Version = status.Version
+			report.LocalNode.VPNAddress = status.VPNAddress
+			localVersion = status.Version
+			serverMode = status.ServerMode
+			if status.Subnet != "" {
+				serverIP, subnet = status.ServerIP, status.Subnet
+			}
+		}
+	}
+	report.LocalNode.Subnet = subnet
+
+	// === THIS NODE CHECKS ===
+	// Check 1: Local node status
+	report.LocalNode.Checks = append(report.LocalNode.Checks, checkLocalNode(nodeAddr))
+
+	// Check 2: VPN server reachability
+	report.LocalNode.Checks = append(report.LocalNode.Checks, checkServerPing(serverIP))
+
+	// Check 3: Routing verification
+	report.LocalNode.Checks = append(report.LocalNode.Checks, checkRouting(serverMode, subnet))
+
+	// Check 4: DNS resolution
+	report.LocalNode.Checks = append(report.LocalNode.Checks, CheckDNS())
+	if client != nil {
+		if config, err := client.Config(); err == nil && len(config.DNS) > 0 {
+			report.LocalNode.Checks = append(report.LocalNode.Checks, checkDNSLeak(config.DNS, config.RouteAll))
+		}
+	}
+
+	// Check 5: Network interface
+	report.LocalNode.Checks = append(report.LocalNode.Checks, CheckNetworkInterface(subnet))
+
+	// Check 6: Internet connectivity
+	report.LocalNode.Checks = append(report.LocalNode.Checks, CheckInternet())
+
+	// Check 7: SSH access (local)
+	report.LocalNode.Checks = append(report.LocalNode.Checks, checkLocalSSH())
+
+	// === NETWORK PEERS ===
+	// Get peer list and run diagnostics for each
+	report.Peers = checkNetworkPeers(nodeAddr, localVersion)
+
+	// === RECENT EVENTS ===
+	// Fetch recent lifecycle events to explain WHY something might be wrong
+	report.RecentEvents = recentEvents(nodeAddr)
+
+	// Calculate summary from local checks
+	for i, check := range report.LocalNode.Checks {
+		switch check.Status {
+		case "pass":
+			report.Summary.Passed++
+		case "fail":
+			report.Summary.Failed++
+			report.LocalNode.Checks[i].Recommendations = Recommendations(check.Name)
+		case "warn":
+			report.Summary.Warned++
+		}
+	}
+
+	// Add peer warnings to summary
+	for _, peer := range report.Peers {
+		if peer.Reachable {
+			report.Summary.Passed++
+		} else {
+			report.Summary.Failed++
+		}
+		if !peer.VersionMatch && peer.Version != "" {
+			report.Summary.Warned++
+		}
+		if !peer.RoutingVPN && peer.PublicIP != "" {
+			report.Summary.Warned++
+		}
+		if !peer.SSHAccessible {
+			report.Summary.Warned++
+		}
+	}
+
+	return report
+}
+
+func checkLocalNode(nodeAddr string) Check {
+	result := Check{Name: "Local VPN Node"}
+
+	client, err := cli.NewClient(nodeAddr)
+	if err != nil {
+		result.Status = "fail"
+		result.Message = "Cannot connect to local node"
+		result.Details = err.Error()
+		return result
+	}
+	defer client.Close()
+
+	status, err := client.Status()
+	if err != nil {
+		result.Status = "fail"
+		result.Message = "Failed to get node status"
+		result.Details = err.Error()
+		return result
+	}
+
+	result.Status = "pass"
+	result.Message = fmt.Sprintf("%s (v%s) - VPN IP: %s", status.NodeName, status.Version, status.VPNAddress)
+	result.Details = fmt.Sprintf("Uptime: %s, Peers: %d, Traffic In: %s, Out: %s",
+		status.UptimeStr, status.PeerCount,
+		formatBytes(status.BytesIn), formatBytes(status.BytesOut))
+
+	return result
+}
+
+func checkServerPing(serverIP string) Check {
+	result := Check{Name: "VPN Server"}
+
+	out, err := exec.Command("ping", "-c", "2", "-W", "3", serverIP).CombinedOutput()
+	if err != nil {
+		result.Status = "fail"
+		result.Message = fmt.Sprintf("Server %s unreachable", serverIP)
+		result.Details = "Ping failed - VPN tunnel may be down"
+		return result
+	}
+
+	// Extract latency
+	output := string(out)
+	if strings.Contains(output, "time=") {
+		parts := strings.Split(output, "time=")
+		if len(parts) > 1 {
+			timePart := strings.Split(parts[1], " ")[0]
+			result.Details = fmt.Sprintf("Latency: %s ms", timePart)
+		}
+	}
+
+	result.Status = "pass"
+	result.Message = fmt.Sprintf("Server %s reachable", serverIP)
+	return result
+}
+
+// checkLocalSSH checks if SSH access is enabled on this node.
+func checkLocalSSH() Check {
+	result := Check{Name: "SSH Access"}
+
+	// Check if sshd is running / port 22 is listening
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		// macOS: check systemsetup for remote login
+		cmd = exec.Command("sh", "-c", "systemsetup -getremotelogin 2>/dev/null | grep -i on")
+	} else {
+		// Linux: check if sshd is running
+		cmd = exec.Command("sh", "-c", "systemctl is-active sshd 2>/dev/null || pgrep sshd")
+	}
+
+	if err := cmd.Run(); err != nil {
+		result.Status = "warn"
+		result.Message = "SSH not enabled"
+		result.Details = "Remote Login (SSH) is disabled on this node"
+		return result
+	}
+
+	result.Status = "pass"
+	result.Message = "SSH enabled"
+	result.Details = "Remote Login (SSH) is available"
+	return result
+}
+
+// checkNetworkPeers runs diagnostics for all network peers.
+func checkNetworkPeers(nodeAddr string, localVersion string) []Peer {
+	peers := []Peer{}
+
+	client, err := cli.NewClient(nodeAddr)
+	if err != nil {
+		return peers
+	}
+	defer client.Close()
+
+	// Get peer list from network
+	peerList, err := client.NetworkPeers()
+	if err != nil {
+		return peers
+	}
+
+	// Also get connected peers for more detailed info (version, etc.)
+	connectedPeers, _ := client.Peers()
+	peerVersions := make(map[string]string)
+	if connectedPeers != nil {
+		for _, p := range connectedPeers.Peers {
+			peerVersions[p.VPNAddress] = p.Version
+		}
+	}
+
+	expectedIP := "95.217.238.72" // Helsinki VPN server
+
+	for _, p := range peerList.Peers {
+		pd := Peer{
+			Name:       p.Name,
+			VPNAddress: p.VPNAddress,
+			OS:         p.OS,
+			PublicIP:   p.PublicIP,
+		}
+
+		// Get version from connected peers if available
+		if v, ok := peerVersions[p.VPNAddress]; ok {
+			pd.Version = v
+		}
+
+		// Check 1: Reachability (ping)
+		pingCmd := exec.Command("ping", "-c", "1", "-W", "2", p.VPNAddress)
+		pd.Reachable = pingCmd.Run() == nil
+
+		// Check 2: Version match
+		if pd.Version != "" && localVersion != "" {
+			pd.VersionMatch = pd.Version == localVersion
+			if !pd.VersionMatch {
+				pd.VersionWarning = fmt.Sprintf("Version mismatch: %s (local: %s)", pd.Version, localVersion)
+			}
+		} else {
+			pd.VersionMatch = true // Can't check, assume OK
+		}
+
+		// Check 3: VPN routing (based on public IP)
+		if p.PublicIP != "" {
+			pd.RoutingVPN = p.PublicIP == expectedIP
+			if !pd.RoutingVPN {
+				pd.RoutingWarning = fmt.Sprintf("Not routing through VPN (IP: %s)", p.PublicIP)
+			}
+		}
+
+		// Check 4: SSH accessibility (try to connect to port 22)
+		sshCheck := exec.Command("nc", "-z", "-w", "2", p.VPNAddress, "22")
+		pd.SSHAccessible = sshCheck.Run() == nil
+		if !pd.SSHAccessible {
+			pd.SSHWarning = "SSH port 22 not accessible"
+		}
+
+		peers = append(peers, pd)
+	}
+
+	return peers
+}
+
+// checkRouting confirms a client's traffic leaves through the VPN server,
+// or on a server that route-all clients are NATed to the internet.
+func checkRouting(serverMode bool, subnet string) Check {
+	if serverMode {
+		return checkNAT(subnet)
+	}
+
+	result := Check{Name: "Traffic Routing"}
+
+	publicIP, err := PublicIP()
+	if err != nil {
+		result.Status = "warn"
+		result.Message = "Could not determine public IP"
+		result.Details = err.Error()
+		return result
+	}
+
+	// Check if routed through VPN server
+	expectedIP := "95.217.238.72" // Helsinki server
+	if publicIP == expectedIP {
+		result.Status = "pass"
+		result.Message = "Traffic routed through VPN"
+		result.Details = fmt.Sprintf("Public IP: %s (Helsinki)", publicIP)
+	} else {
+		result.Status = "warn"
+		result.Message = "Traffic NOT routed through VPN"
+		result.Details = fmt.Sprintf("Public IP: %s (expected: %s)", publicIP, expectedIP)
+	}
+
+	return result
+}
+
+// checkNAT checks the forwarding and MASQUERADE rule vpn-node --enable-nat
+// sets up for the VPN subnet.
+func checkNAT(subnet string) Check {
+	result := Check{Name: "Traffic Routing"}
+
+	vpnNet, err := tunnel.ParseSubnet(subnet)
+	if err != nil {
+		result.Status = "warn"
+		result.Message = "Cannot check NAT"
+		result.Details = err.Error()
+		return result
+	}
+
+	forwarding, iface, err := tunnel.NATStatus(vpnNet)
+	switch {
+	case err != nil:
+		result.Status = "warn"
+		result.Message = "Cannot check NAT"
+		result.Details = err.Error()
+	case iface == "":
+		result.Status = "fail"
+		result.Message = "No NAT for route-all clients"
+		result.Details = fmt.Sprintf("No MASQUERADE rule for %s: clients routing all traffic have no internet", vpnNet)
+	case !forwarding:
+		result.Status = "fail"
+		result.Message = "IP forwarding disabled"
+		result.Details = fmt.Sprintf("MASQUERADE for %s via %s, but net.ipv4.ip_forward is 0", vpnNet, iface)
+	default:
+		result.Status = "pass"
+		result.Message = "NAT active for route-all clients"
+		result.Details = fmt.Sprintf("%s masqueraded via %s, IP forwarding on", vpnNet, iface)
+	}
+	return result
+}
+
+// CheckDNS resolves google.com with the system resolver.
+func CheckDNS() Check {
+	result := Check{Name: "DNS Resolution"}
+
+	start := time.Now()
+	out, err := exec.Command("nslookup", "google.com").CombinedOutput()
+	elapsed := time.Since(start)
+
+	if err != nil {
+		result.Status = "fail"
+		result.Message = "DNS resolution failed"
+		result.Details = string(out)
+		return result
+	}
+
+	result.Status = "pass"
+	result.Message = "DNS working"
+	result.Details = fmt.Sprintf("Resolution time: %v", elapsed.Round(time.Millisecond))
+	return result
+}
+
+// checkDNSLeak confirms the OS resolver is the one configured with --dns.
+func checkDNSLeak(configured []string, routeAll bool) Check {
+	result := Check{Name: "DNS Leak"}
+
+	if !routeAll {
+		result.Status = "pass"
+		result.Message = "Not routing all traffic (DNS override inactive)"
+		result.Details = fmt.Sprintf("Configured: %s", strings.Join(configured, ", "))
+		return result
+	}
+
+	active, err := tunnel.ActiveDNSServers()
+	if err != nil {
+		result.Status = "warn"
+		result.Message = "Could not read the active resolver"
+		result.Details = err.Error()
+		return result
+	}
+
+	for _, server := range active {
+		for _, want := range configured {
+			if server == want {
+				result.Status = "pass"
+				result.Message = fmt.Sprintf("Using configured resolver %s", server)
+				return result
+			}
+		}
+	}
+
+	result.Status = "fail"
+	result.Message = "DNS leak: active resolver is not the configured one"
+	result.Details = fmt.Sprintf("Active: %s, configured: %s", strings.Join(active, ", "), strings.Join(configured, ", "))
+	return result
+}
+
+// CheckNetworkInterface checks that the TUN device is up with an address in
+// subnet.
+func CheckNetworkInterface(subnet string) Check {
+	result := Check{Name: "VPN Interface"}
+	_, vpnNet, _ := net.ParseCIDR(subnet)
+
+	var tunName string
+	if runtime.GOOS == "darwin" {
+		// macOS uses utun devices
+		out, err := exec.Command("sh", "-c", "ifconfig | grep -E '^utun' | head -1 | cut -d: -f1").CombinedOutput()
+		if err == nil && len(out) > 0 {
+			tunName = strings.TrimSpace(string(out))
+		}
+	} else {
+		// Linux uses tun0
+		tunName = "tun0"
+	}
+
+	if tunName == "" {
+		result.Status = "fail"
+		result.Message = "No VPN interface found"
+		return result
+	}
+
+	// Check if interface is up
+	out, err := exec.Command("ifconfig", tunName).CombinedOutput()
+	if err != nil {
+		result.Status = "fail"
+		result.Message = fmt.Sprintf("Interface %s not found", tunName)
+		result.Details = err.Error()
+		return result
+	}
+
+	output := string(out)
+	if strings.Contains(output, "UP") {
+		result.Status = "pass"
+		result.Message = fmt.Sprintf("Interface %s is UP", tunName)
+
+		// Extract IP if present
+		if strings.Contains(output, "inet ") {
+			lines := strings.Split(output, "\n")
+			for _, line := range lines {
+				parts := strings.Fields(line)
+				if len(parts) < 2 || parts[0] != "inet" {
+					continue
+				}
+				// "inet 10.8.0.5/24" (ip addr) or "inet 10.8.0.5 --> ..." (ifconfig)
+				addr, _, _ := strings.Cut(parts[1], "/")
+				if ip := net.ParseIP(addr); ip != nil && vpnNet != nil && vpnNet.Contains(ip) {
+					result.Details = fmt.Sprintf("IP: %s (subnet %s)", addr, subnet)
+				}
+			}
+		}
+	} else {
+		result.Status = "fail"
+		result.Message = fmt.Sprintf("Interface %s is DOWN", tunName)
+	}
+
+	return result
+}
+
+// CheckInternet checks that an external HTTPS site is reachable.
+func CheckInternet() Check {
+	result := Check{Name: "Internet Connectivity"}
+
+	// Try to reach a reliable external host
+	start := time.Now()
+	resp, err := http.Get("https://www.google.com")
+	elapsed := time.Since(start)
+
+	if err != nil {
+		result.Status = "fail"
+		result.Message = "No internet connectivity"
+		result.Details = err.Error()
+		return result
+	}
+	resp.Body.Close()
+
+	result.Status = "pass"
+	result.Message = "Internet reachable"
+	result.Details = fmt.Sprintf("Response time: %v", elapsed.Round(time.Millisecond))
+	return result
+}
+
+// Recommendations returns what to try when the named check fails.
+func Recommendations(checkName string) []string {
+	switch checkName {
+	case "Local VPN Node":
+		return []string{
+			"Check if vpn-node daemon is running: ps aux | grep vpn-node",
+			"Restart the VPN service: sudo launchctl bootout/bootstrap",
+		}
+	case "VPN Server":
+		return []string{
+			"VPN server may be down - check server status",
+			"Restart local VPN client to reconnect",
+		}
+	case "Traffic Routing":
+		return []string{
+			"Restart the server with NAT: sudo vpn-node --server --enable-nat",
+			"Inspect the rules: sudo iptables -t nat -S POSTROUTING; sysctl net.ipv4.ip_forward",
+		}
+	case "VPN Interface":
+		return []string{"VPN tunnel not established - restart VPN client"}
+	case "Internet Connectivity":
+		return []string{
+			"Check if route-all is enabled but VPN is disconnected",
+			"Try: vpn disconnect to restore direct routing",
+		}
+	case "DNS Resolution":
+		return []string{
+			"DNS may be misconfigured - check /etc/resolv.conf",
+			"Try flushing DNS: sudo dscacheutil -flushcache",
+		}
+	}
+	return nil
+}
+
+// recentEvents fetches recent lifecycle events to help explain issues.
+func recentEvents(nodeAddr string) []RecentEvent {
+	events := []RecentEvent{}
+
+	client, err := cli.NewClient(nodeAddr)
+	if err != nil {
+		return events
+	}
+	defer client.Close()
+
+	result, err := client.Lifecycle(protocol.LifecycleParams{Limit: 5}) // Get last 5 events
+	if err != nil {
+		return events
+	}
+
+	for _, e := range result.Events {
+		// Only include events that might explain issues
+		if e.Event == "CRASH" || e.Event == "CONNECTION_LOST" || e.Event == "SIGNAL" || e.Event == "DEGRADED" {
+			events = append(events, RecentEvent{
+				Timestamp: e.Timestamp,
+				Event:     e.Event,
+				Reason:    e.Reason,
+			})
+		}
+	}
+
+	return events
+}
+
+// PublicIP fetches the current public IP address.
+func PublicIP() (string, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	// Try multiple services in case one is down
+	services := []string{
+		"https://api.ipify.org",
+		"https://ifconfig.me/ip",
+		"https://icanhazip.com",
+	}
+
+	for _, url := range services {
+		resp, err := client.Get(url)
+		if err != nil {
+			continue
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			continue
+		}
+
+		ip := strings.TrimSpace(string(body))
+		if ip != "" {
+			return ip, nil
+		}
+	}
+
+	return "", fmt.Errorf("could not determine public IP")
+}
+
+func formatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "KMGTPE"[exp])
+}
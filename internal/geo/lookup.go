@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"time"
 
@@ -16,8 +17,59 @@ const (
 	ipAPIURL = "http://ip-api.com/json/%s?fields=status,message,country,city,lat,lon,isp,query"
 	// Timeout for geolocation lookup
 	lookupTimeout = 5 * time.Second
+	// How long a cached lookup is trusted
+	cacheTTL = 24 * time.Hour
 )
 
+// Cache persists lookups by IP (implemented by store.Store).
+type Cache interface {
+	GetGeoCache(ip string, maxAge time.Duration) (string, error)
+	SaveGeoCache(ip, data string) error
+}
+
+// Resolver looks up IPs, reusing cached results for up to 24 hours.
+type Resolver struct {
+	cache Cache // nil disables caching
+}
+
+// NewResolver creates a resolver. cache may be nil.
+func NewResolver(cache Cache) *Resolver {
+	return &Resolver{cache: cache}
+}
+
+// Lookup returns the geolocation of a public IP, from the cache when fresh.
+// Private and loopback addresses have no location and return an error.
+func (r *Resolver) Lookup(ip string) (*protocol.GeoLocation, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil, fmt.Errorf("invalid IP: %s", ip)
+	}
+	if parsed.IsPrivate() || parsed.IsLoopback() || parsed.IsUnspecified() || parsed.IsLinkLocalUnicast() {
+		return nil, fmt.Errorf("no location for non-public IP %s", ip)
+	}
+
+	if r.cache != nil {
+		if data, err := r.cache.GetGeoCache(ip, cacheTTL); err == nil && data != "" {
+			var loc protocol.GeoLocation
+			if err := json.Unmarshal([]byte(data), &loc); err == nil {
+				return &loc, nil
+			}
+		}
+	}
+
+	loc, err := LookupIP(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.cache != nil {
+		if data, err := json.Marshal(loc); err == nil {
+			r.cache.SaveGeoCache(ip, string(data))
+		}
+	}
+	return loc, nil
+}
+
 // ipAPIResponse is the response from ip-api.com
 type ipAPIResponse struct {
 	Status  string  `json:"status"`
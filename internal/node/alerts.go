@@ -0,0 +1,191 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/miguelemosreverte/vpn/internal/store"
+)
+
+// alertEngine evaluates alert rules against each batch of collected metrics.
+// Rules are edge-triggered: the action runs once when a condition starts
+// matching and again only after it has cleared.
+type alertEngine struct {
+	store    *store.Store
+	nodeName func() string // Current node name (it can change at runtime)
+	batches  chan []store.MetricPoint
+
+	mu     sync.Mutex
+	rules  []store.AlertRule
+	firing map[int64]bool // rule ID -> condition currently matching
+}
+
+// alertPayload is the JSON body posted to webhook actions.
+type alertPayload struct {
+	Rule      string               `json:"rule"`
+	Node      string               `json:"node"`
+	Metric    string               `json:"metric"`
+	Value     float64              `json:"value"`
+	Condition store.AlertCondition `json:"condition"`
+	Timestamp time.Time            `json:"timestamp"`
+}
+
+func newAlertEngine(s *store.Store, nodeName func() string) *alertEngine {
+	return &alertEngine{
+		store:    s,
+		nodeName: nodeName,
+		batches:  make(chan []store.MetricPoint, 10),
+		firing:   make(map[int64]bool),
+	}
+}
+
+// enqueue hands a metrics batch to the evaluation goroutine. It never blocks
+// the collector; batches are dropped if evaluation falls behind.
+func (a *alertEngine) enqueue(metrics []store.MetricPoint) {
+	select {
+	case a.batches <- metrics:
+	default:
+	}
+}
+
+// reload re-reads the rules from the store.
+func (a *alertEngine) reload() error {
+	rules, err := a.store.ListAlertRules()
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rules = rules
+
+	// Forget state for rules that no longer exist
+	active := make(map[int64]bool, len(rules))
+	for _, r := range rules {
+		active[r.ID] = true
+	}
+	for id := range a.firing {
+		if !active[id] {
+			delete(a.firing, id)
+		}
+	}
+	return nil
+}
+
+// run evaluates batches until ctx is cancelled.
+func (a *alertEngine) run(ctx context.Context) {
+	if err := a.reload(); err != nil {
+		log.Printf("[alert] Failed to load rules: %v", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case metrics := <-a.batches:
+			a.evaluate(metrics)
+		}
+	}
+}
+
+// evaluate checks every rule against the metrics in a batch.
+func (a *alertEngine) evaluate(metrics []store.MetricPoint) {
+	values := make(map[string]float64, len(metrics))
+	for _, m := range metrics {
+		values[m.Name] = m.Value
+	}
+
+	a.mu.Lock()
+	var triggered []alertPayload
+	var actions []store.AlertRule
+	for _, rule := range a.rules {
+		value, ok := values[rule.Condition.Metric]
+		if !ok {
+			continue
+		}
+
+		matches := rule.Condition.Matches(value)
+		wasFiring := a.firing[rule.ID]
+		a.firing[rule.ID] = matches
+		if !matches || wasFiring {
+			continue
+		}
+
+		triggered = append(triggered, alertPayload{
+			Rule:      rule.Name,
+			Node:      a.nodeName(),
+			Metric:    rule.Condition.Metric,
+			Value:     value,
+			Condition: rule.Condition,
+			Timestamp: time.Now(),
+		})
+		actions = append(actions, rule)
+	}
+	a.mu.Unlock()
+
+	for i, rule := range actions {
+		a.fire(rule, triggered[i])
+	}
+}
+
+// fire runs a rule's action.
+func (a *alertEngine) fire(rule store.AlertRule, p alertPayload) {
+	message := fmt.Sprintf("%s: %s = %g (%s %g)", rule.Name, p.Metric, p.Value, rule.Condition.Op, rule.Condition.Value)
+
+	// Every alert is recorded, whatever its action
+	log.Printf("[alert] Warning: rule triggered: %s", message)
+
+	switch rule.Action {
+	case store.AlertActionNotify:
+		if err := sendDesktopNotification("VPN alert", message); err != nil {
+			log.Printf("[alert] Failed to send notification for %s: %v", rule.Name, err)
+		}
+	case store.AlertActionWebhook:
+		go func() {
+			if err := postAlertWebhook(rule.WebhookURL, p); err != nil {
+				log.Printf("[alert] Failed to call webhook for %s: %v", rule.Name, err)
+			}
+		}()
+	}
+}
+
+// sendDesktopNotification shows a notification on the local desktop.
+func sendDesktopNotification(title, message string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %q with title %q", message, title)
+		return exec.Command("osascript", "-e", script).Run()
+	case "linux":
+		return exec.Command("notify-send", title, message).Run()
+	default:
+		return fmt.Errorf("desktop notifications not supported on %s", runtime.GOOS)
+	}
+}
+
+// postAlertWebhook posts the alert as JSON to url.
+func postAlertWebhook(url string, p alertPayload) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package node
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"time"
+
+	"github.com/miguelemosreverte/vpn/internal/protocol"
+)
+
+const (
+	// benchPort is the UDP port the benchmark responder listens on (VPN address only)
+	benchPort = 9002
+
+	// benchPacketSize fits in one tunnel packet (MTU 1400 minus IP/UDP headers)
+	benchPacketSize = 1200
+
+	// benchWindow is how many unanswered packets the sender allows in flight
+	benchWindow = 64
+
+	// benchLossTimeout is how long a packet may go unanswered before it counts as lost
+	benchLossTimeout = time.Second
+
+	// benchMaxDuration caps both the test and the responder window
+	benchMaxDuration = 5 * time.Minute
+)
+
+// startBenchResponder echoes benchmark packets on our VPN address until
+// the deadline. A running responder is extended rather than restarted.
+func (d *Daemon) startBenchResponder(duration time.Duration) (string, time.Time, error) {
+	d.benchMu.Lock()
+	defer d.benchMu.Unlock()
+
+	until := time.Now().Add(duration)
+	addr := net.JoinHostPort(d.config.VPNAddress, fmt.Sprint(benchPort))
+
+	if d.benchConn != nil {
+		d.benchConn.SetReadDeadline(until)
+		return addr, until, nil
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	conn.SetReadDeadline(until)
+	d.benchConn = conn
+
+	log.Printf("[bench] Responder listening on %s until %s", addr, until.Format("15:04:05"))
+
+	go func() {
+		defer func() {
+			d.benchMu.Lock()
+			d.benchConn = nil
+			d.benchMu.Unlock()
+			conn.Close()
+			log.Printf("[bench] Responder on %s stopped", addr)
+		}()
+
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return // Deadline reached
+			}
+			conn.WriteToUDP(buf[:n], from)
+		}
+	}()
+
+	return addr, until, nil
+}
+
+// runBenchmark sends packets to a peer's responder for duration, keeping at
+// most benchWindow packets in flight, and measures the echoes.
+func (d *Daemon) runBenchmark(peer string, duration time.Duration) (*protocol.BenchResult, error) {
+	raddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(peer, fmt.Sprint(benchPort)))
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	log.Printf("[bench] Benchmarking %s for %s", peer, duration)
+
+	// Packets are [8B seq][8B send time][padding]; the echo carries its own RTT
+	acks := make(chan time.Duration, benchWindow)
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				close(acks)
+				return
+			}
+			if n < 16 {
+				continue
+			}
+			sentAt := time.Unix(0, int64(binary.BigEndian.Uint64(buf[8:16])))
+			select {
+			case acks <- time.Since(sentAt):
+			default: // Late echo after the test ended
+			}
+		}
+	}()
+
+	var sent, received int
+	var rttTotal, jitterTotal, lastRTT float64 // Milliseconds
+	inFlight := 0
+	packet := make([]byte, benchPacketSize)
+	start := time.Now()
+	deadline := start.Add(duration)
+
+	// Jitter is the mean difference between consecutive RTTs (as in RFC 3550)
+	record := func(rtt time.Duration) {
+		ms := float64(rtt.Microseconds()) / 1000
+		if received > 0 {
+			jitterTotal += math.Abs(ms - lastRTT)
+		}
+		lastRTT = ms
+		rttTotal += ms
+		received++
+		inFlight--
+	}
+
+	for time.Now().Before(deadline) {
+		if inFlight >= benchWindow {
+			select {
+			case rtt, ok := <-acks:
+				if !ok {
+					return nil, fmt.Errorf("benchmark connection closed")
+				}
+				record(rtt)
+			case <-time.After(benchLossTimeout):
+				// Nothing came back: give up on the oldest packet
+				inFlight--
+			}
+			continue
+		}
+
+		binary.BigEndian.PutUint64(packet[0:8], uint64(sent))
+		binary.BigEndian.PutUint64(packet[8:16], uint64(time.Now().UnixNano()))
+		if _, err := conn.Write(packet); err != nil {
+			// ICMP port unreachable: no responder on the peer
+			if received == 0 {
+				return nil, fmt.Errorf("no benchmark responder on %s (run 'vpn benchmark --serve' there): %w", peer, err)
+			}
+			return nil, err
+		}
+		sent++
+		inFlight++
+	}
+	elapsed := time.Since(start)
+
+	// Collect stragglers
+	drain := time.After(benchLossTimeout)
+collect:
+	for inFlight > 0 {
+		select {
+		case rtt, ok := <-acks:
+			if !ok {
+				break collect
+			}
+			record(rtt)
+		case <-drain:
+			break collect
+		}
+	}
+
+	if received == 0 {
+		return nil, fmt.Errorf("no replies from %s (run 'vpn benchmark --serve' there)", peer)
+	}
+
+	result := &protocol.BenchResult{
+		Peer:        peer,
+		DurationSec: elapsed.Seconds(),
+		PacketsSent: sent,
+		PacketsRecv: received,
+		BytesPerSec: float64(received*benchPacketSize) / elapsed.Seconds(),
+		LossPct:     float64(sent-received) / float64(sent) * 100,
+		RTTMs:       rttTotal / float64(received),
+	}
+	result.MBPerSec = result.BytesPerSec / (1024 * 1024)
+	result.Mbps = result.BytesPerSec * 8 / 1000 / 1000
+	if received > 1 {
+		result.JitterMs = jitterTotal / float64(received-1)
+	}
+
+	log.Printf("[bench] %s: %.2f MB/s, %.1f%% loss, %.2f ms jitter, %.2f ms RTT",
+		peer, result.MBPerSec, result.LossPct, result.JitterMs, result.RTTMs)
+
+	return result, nil
+}
+
+// storeBenchResult records a benchmark as the peer's bandwidth.
+func (d *Daemon) storeBenchResult(result *protocol.BenchResult) {
+	if d.topology != nil {
+		d.topology.UpdatePeerBandwidth(result.Peer, result.BytesPerSec)
+	}
+
+	if d.store != nil {
+		if err := d.store.WriteMetric("peer.bandwidth_bps", result.BytesPerSec, d.peerTags(result.Peer)); err != nil {
+			log.Printf("[store] Failed to write peer.bandwidth_bps: %v", err)
+		}
+	}
+}
@@ -2,23 +2,32 @@ package node
 
 import (
 	"bufio"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/miguelemosreverte/vpn/internal/cli"
 	"github.com/miguelemosreverte/vpn/internal/protocol"
 	"github.com/miguelemosreverte/vpn/internal/store"
+	"github.com/miguelemosreverte/vpn/internal/tunnel"
 )
 
 // Version is set at build time via -ldflags
 var Version = "dev"
 
-// handleControlConnection processes commands from a CLI client.
-func (d *Daemon) handleControlConnection(conn net.Conn) {
+// handleControlConnection processes commands from a CLI client. A readOnly
+// connection (the VPN control listener) may only call readOnlyMethods.
+func (d *Daemon) handleControlConnection(conn net.Conn, readOnly bool) {
+	defer d.recoverCrash()
 	defer conn.Close()
 
 	log.Printf("[control] New connection from %s", conn.RemoteAddr())
@@ -26,6 +35,11 @@ func (d *Daemon) handleControlConnection(conn net.Conn) {
 	scanner := bufio.NewScanner(conn)
 	encoder := json.NewEncoder(conn)
 
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		host = conn.RemoteAddr().String()
+	}
+
 	for scanner.Scan() {
 		var req protocol.Request
 		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
@@ -33,6 +47,25 @@ func (d *Daemon) handleControlConnection(conn net.Conn) {
 			continue
 		}
 
+		if ok, wait := d.controlLimiter.allow(host); !ok {
+			d.countControlRequest(true)
+			d.sendRateLimited(encoder, req.ID, fmt.Sprintf("rate limit exceeded (%d requests/s)", controlRequestsPerSec), wait)
+			continue
+		}
+		d.countControlRequest(false)
+
+		if code, msg := d.authorizeRequest(&req); code != 0 {
+			log.Printf("[control] WARN: %s %s: %s", host, req.Method, msg)
+			d.sendError(encoder, req.ID, code, msg)
+			continue
+		}
+		if readOnly && !readOnlyMethods[req.Method] {
+			log.Printf("[control] WARN: %s %s: refused on the VPN control listener", host, req.Method)
+			d.sendError(encoder, req.ID, protocol.ErrCodeForbidden,
+				fmt.Sprintf("%s not allowed over the VPN (use the node's local control socket)", req.Method))
+			continue
+		}
+
 		d.handleRequest(encoder, &req)
 	}
 
@@ -41,6 +74,77 @@ func (d *Daemon) handleControlConnection(conn net.Conn) {
 	}
 }
 
+// readOnlyMethods only read state. Clients using the read-only token or the
+// VPN control listener may call nothing else, so a new method is refused to
+// them until it is added here.
+var readOnlyMethods = map[string]bool{
+	"status":            true,
+	"peers":             true,
+	"peers_stream":      true,
+	"peer_rates":        true,
+	"logs":              true,
+	"stats":             true,
+	"stats_watch":       true,
+	"connection_status": true,
+	"routes":            true,
+	"topology":          true,
+	"topology_watch":    true,
+	"network_peers":     true,
+	"lifecycle":         true,
+	"crash_stats":       true,
+	"traffic":           true,
+	"handshake_history": true,
+	"export":            true,
+	"retention":         true,
+	"preferences":       true,
+	"config":            true,
+	"config_get":        true,
+	"alert_list":        true,
+}
+
+// authorizeRequest checks the request token against the configured control
+// tokens. It returns a zero code if the request may proceed, otherwise the
+// error code and message to send.
+func (d *Daemon) authorizeRequest(req *protocol.Request) (int, string) {
+	if d.config.ControlToken == "" {
+		return 0, ""
+	}
+	if tokenMatches(req.Token, d.config.ControlToken) {
+		return 0, ""
+	}
+	if d.config.ReadonlyToken != "" && tokenMatches(req.Token, d.config.ReadonlyToken) {
+		if !readOnlyMethods[req.Method] {
+			return protocol.ErrCodeForbidden, fmt.Sprintf("%s not allowed with the read-only token", req.Method)
+		}
+		return 0, ""
+	}
+	if req.Token == "" {
+		return protocol.ErrCodeUnauthorized, "control token required (use --token or VPN_CONTROL_TOKEN)"
+	}
+	return protocol.ErrCodeUnauthorized, "invalid control token"
+}
+
+// tokenMatches compares tokens in constant time.
+func tokenMatches(got, want string) bool {
+	return subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// rejectControlConnection answers a connection over controlMaxConns with a
+// rate limit error and closes it.
+func (d *Daemon) rejectControlConnection(conn net.Conn) {
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	log.Printf("[control] WARN: rejecting connection from %s: %d connections open", conn.RemoteAddr(), controlMaxConns)
+	d.sendRateLimited(json.NewEncoder(conn), 0, fmt.Sprintf("too many control connections (max %d)", controlMaxConns), time.Second)
+}
+
+// countControlRequest feeds the control.* request counters.
+func (d *Daemon) countControlRequest(limited bool) {
+	if d.standardMetrics != nil {
+		d.standardMetrics.IncrementControlRequests(limited)
+	}
+}
+
 // handleRequest dispatches a request to the appropriate handler.
 func (d *Daemon) handleRequest(enc *json.Encoder, req *protocol.Request) {
 	switch req.Method {
@@ -48,30 +152,78 @@ func (d *Daemon) handleRequest(enc *json.Encoder, req *protocol.Request) {
 		d.handleStatus(enc, req)
 	case "peers":
 		d.handlePeers(enc, req)
+	case "peers_stream":
+		d.handlePeersStream(enc, req)
+	case "peer_rates":
+		d.handlePeerRates(enc, req)
+	case "set_peer_limit":
+		d.handleSetPeerLimit(enc, req)
+	case "remove_peer":
+		d.handleRemovePeer(enc, req)
 	case "update":
 		d.handleUpdate(enc, req)
+	case "restart":
+		d.handleRestart(enc, req)
+	case "rollback":
+		d.handleRollback(enc, req)
 	case "logs":
 		d.handleLogs(enc, req)
 	case "stats":
 		d.handleStats(enc, req)
+	case "stats_watch":
+		d.handleStatsWatch(enc, req)
 	case "connect":
 		d.handleConnect(enc, req)
 	case "disconnect":
 		d.handleDisconnect(enc, req)
 	case "connection_status":
 		d.handleConnectionStatus(enc, req)
+	case "routes":
+		d.handleRoutes(enc, req)
 	case "topology":
 		d.handleTopology(enc, req)
+	case "topology_watch":
+		d.handleTopologyWatch(enc, req)
 	case "network_peers":
 		d.handleNetworkPeers(enc, req)
 	case "lifecycle":
 		d.handleLifecycle(enc, req)
 	case "crash_stats":
 		d.handleCrashStats(enc, req)
+	case "autofix":
+		d.handleAutofix(enc, req)
 	case "handshake":
 		d.handleHandshake(enc, req)
+	case "traffic":
+		d.handleTraffic(enc, req)
 	case "handshake_history":
 		d.handleHandshakeHistory(enc, req)
+	case "export":
+		d.handleExport(enc, req)
+	case "retention":
+		d.handleRetention(enc, req)
+	case "set_retention":
+		d.handleSetRetention(enc, req)
+	case "preferences":
+		d.handlePreferences(enc, req)
+	case "set_preferences":
+		d.handleSetPreferences(enc, req)
+	case "bench_server":
+		d.handleBenchServer(enc, req)
+	case "bench_client":
+		d.handleBenchClient(enc, req)
+	case "config", "config_get":
+		d.handleConfig(enc, req)
+	case "invite":
+		d.handleInvite(enc, req)
+	case "config_set":
+		d.handleConfigSet(enc, req)
+	case "alert_add":
+		d.handleAlertAdd(enc, req)
+	case "alert_list":
+		d.handleAlertList(enc, req)
+	case "alert_rm":
+		d.handleAlertRemove(enc, req)
 	default:
 		d.sendError(enc, req.ID, protocol.ErrCodeInvalidMethod,
 			fmt.Sprintf("unknown method: %s", req.Method))
@@ -93,7 +245,14 @@ func (d *Daemon) handleStatus(enc *json.Encoder, req *protocol.Request) {
 		BytesIn:        bytesIn,
 		BytesOut:       bytesOut,
 		ServerMode:     d.config.ServerMode,
+		ConnectTo:      d.config.ConnectTo,
 		ReconnectCount: d.config.ReconnectCount,
+		PacketLoss:     d.PacketLoss(),
+		Subnet:         d.config.Subnet,
+		ServerIP:       d.serverIP(),
+	}
+	if d.tun != nil {
+		result.MTU = d.tun.MTU()
 	}
 
 	d.sendResult(enc, req.ID, result)
@@ -128,6 +287,105 @@ func (d *Daemon) handlePeers(enc *json.Encoder, req *protocol.Request) {
 	d.sendResult(enc, req.ID, protocol.PeersResult{Peers: peerInfos})
 }
 
+// handlePeerRates returns the configured limits and current rates.
+func (d *Daemon) handlePeerRates(enc *json.Encoder, req *protocol.Request) {
+	d.sendResult(enc, req.ID, d.peerRates())
+}
+
+// peerRates lists the rate limited peers, sorted by VPN IP.
+func (d *Daemon) peerRates() protocol.PeerRatesResult {
+	limiters := d.peerRateLimiters()
+	rates := make([]protocol.PeerRate, 0, len(limiters))
+	for vpnIP, limiter := range limiters {
+		limit, current, dropped := limiter.stats()
+		rates = append(rates, protocol.PeerRate{
+			VPNAddress:   vpnIP,
+			LimitMbps:    limit,
+			CurrentMbps:  current,
+			DroppedBytes: dropped,
+			Default:      limiter.isDefault(),
+		})
+	}
+	sort.Slice(rates, func(i, j int) bool { return rates[i].VPNAddress < rates[j].VPNAddress })
+
+	return protocol.PeerRatesResult{Rates: rates, DefaultMbps: d.defaultRateLimitMbps()}
+}
+
+// handleSetPeerLimit sets or clears one peer's rate limit at runtime and
+// returns the updated rates.
+func (d *Daemon) handleSetPeerLimit(enc *json.Encoder, req *protocol.Request) {
+	var params protocol.SetPeerLimitParams
+	if req.Params != nil {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, "invalid params")
+			return
+		}
+	}
+	if net.ParseIP(params.VPNAddress) == nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, fmt.Sprintf("invalid VPN IP %q", params.VPNAddress))
+		return
+	}
+	if params.Mbps < 0 {
+		d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, "mbps must not be negative")
+		return
+	}
+
+	limits := make(map[string]float64, len(d.config.PeerRateLimits)+1)
+	for vpnIP, mbps := range d.config.PeerRateLimits {
+		limits[vpnIP] = mbps
+	}
+	if params.Mbps > 0 {
+		limits[params.VPNAddress] = params.Mbps
+		log.Printf("[node] Rate limit for %s set to %g Mbps", params.VPNAddress, params.Mbps)
+	} else {
+		delete(limits, params.VPNAddress)
+		log.Printf("[node] Rate limit for %s removed", params.VPNAddress)
+	}
+	d.setRateLimits(limits)
+
+	d.sendResult(enc, req.ID, d.peerRates())
+}
+
+// handleRemovePeer force-disconnects a client by VPN address (server mode)
+// and returns the remaining peers.
+func (d *Daemon) handleRemovePeer(enc *json.Encoder, req *protocol.Request) {
+	if !d.config.ServerMode {
+		d.sendError(enc, req.ID, protocol.ErrCodeInternal, "remove_peer is only available on the server")
+		return
+	}
+
+	var params protocol.RemovePeerParams
+	if req.Params != nil {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, "invalid params")
+			return
+		}
+	}
+	if params.VPNAddress == "" {
+		d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, "vpn_address is required")
+		return
+	}
+
+	if params.Forget {
+		vpnIP := d.resolvePeerAddress(params.VPNAddress)
+		if vpnIP == "" || !d.forgetPeer(vpnIP) {
+			d.sendError(enc, req.ID, protocol.ErrCodePeerNotFound, fmt.Sprintf("no known node %s", params.VPNAddress))
+			return
+		}
+		log.Printf("[vpn] Removed node %s (%s) from the network (requested via control socket)", params.VPNAddress, vpnIP)
+		d.handlePeers(enc, req)
+		return
+	}
+
+	if !d.unregisterPeer(params.VPNAddress, nil) {
+		d.sendError(enc, req.ID, protocol.ErrCodePeerNotFound, fmt.Sprintf("no peer with VPN address %s", params.VPNAddress))
+		return
+	}
+
+	log.Printf("[vpn] Removed peer %s (requested via control socket)", params.VPNAddress)
+	d.handlePeers(enc, req)
+}
+
 // handleUpdate triggers a node update.
 func (d *Daemon) handleUpdate(enc *json.Encoder, req *protocol.Request) {
 	var params protocol.UpdateParams
@@ -138,28 +396,107 @@ func (d *Daemon) handleUpdate(enc *json.Encoder, req *protocol.Request) {
 		}
 	}
 
+	if params.DryRun {
+		plan, err := d.planUpdate()
+		if err != nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeInternal, err.Error())
+			return
+		}
+		d.sendResult(enc, req.ID, protocol.UpdateResult{
+			Success: true,
+			Updated: []string{},
+			Plan:    plan,
+		})
+		return
+	}
+
 	if params.All {
 		log.Printf("[control] Update requested for ALL nodes (rolling=%v)", params.Rolling)
 	} else {
 		log.Printf("[control] Update requested for this node")
 	}
 
-	// Perform actual deployment: git pull, check versions, rebuild if needed
-	go d.performDeploy(DeployRequest{
+	// Update this node first: git pull, check versions, rebuild if needed
+	result := protocol.UpdateResult{Updated: []string{}}
+	updates, err := d.deployLocal(DeployRequest{
 		Ref:    "HEAD",
 		Branch: "main",
 	})
-
-	// Return success immediately (deployment runs async)
-	result := protocol.UpdateResult{
-		Success: true,
-		Updated: []string{d.config.NodeName},
+	if err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", d.config.NodeName, err))
+	} else {
+		result.Updated = append(result.Updated, d.config.NodeName)
 	}
 
-	// If --all flag, the server will broadcast UPDATE_AVAILABLE to peers
-	// via broadcastUpdate() called from performDeploy()
+	// Then the peers, which pull and rebuild on UPDATE_AVAILABLE
+	if params.All && err == nil {
+		switch {
+		case !d.config.ServerMode:
+			result.Errors = append(result.Errors, "--all only reaches peers when sent to the server (vpn --node 10.8.0.1:9001 update --all)")
+		case params.Rolling:
+			d.updatePeersRolling(&result)
+		default:
+			d.updatePeers(&result)
+		}
+	}
 
+	result.Success = len(result.Errors) == 0
 	d.sendResult(enc, req.ID, result)
+
+	// A restart (server only) must wait until the reply is sent
+	if err == nil {
+		go d.finishDeploy(updates)
+	}
+}
+
+// handleRollback reverts the node to the commit it ran before the last
+// deploy, rebuilds and restarts it.
+func (d *Daemon) handleRollback(enc *json.Encoder, req *protocol.Request) {
+	log.Printf("[control] Rollback requested")
+
+	from, to, err := d.performRollback()
+	if err != nil {
+		log.Printf("[control] Rollback failed: %v", err)
+		d.sendError(enc, req.ID, protocol.ErrCodeInternal, err.Error())
+		return
+	}
+
+	d.sendResult(enc, req.ID, protocol.RollbackResult{From: from, To: to})
+
+	// Restart after the reply is sent
+	go func() {
+		time.Sleep(time.Second)
+		d.scheduleRestart("rollback")
+	}()
+}
+
+// handleRestart restarts the node in place, e.g. to pick up a config
+// change. A client drops its tunnel meanwhile, so it needs Force.
+func (d *Daemon) handleRestart(enc *json.Encoder, req *protocol.Request) {
+	var params protocol.RestartParams
+	if req.Params != nil {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, "invalid params")
+			return
+		}
+	}
+
+	if !d.config.ServerMode && !params.Force {
+		d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, "restarting a client drops its VPN connection; pass force to confirm")
+		return
+	}
+
+	log.Printf("[control] Restart requested")
+	d.sendResult(enc, req.ID, protocol.RestartResult{
+		NodeName: d.config.NodeName,
+		Version:  Version,
+	})
+
+	// Restart after the reply is sent
+	go func() {
+		time.Sleep(time.Second)
+		d.scheduleRestart("requested")
+	}()
 }
 
 // sendResult sends a successful response.
@@ -184,10 +521,23 @@ func (d *Daemon) sendError(enc *json.Encoder, id uint64, code int, message strin
 	enc.Encode(resp)
 }
 
+// sendRateLimited sends an ErrCodeRateLimited error telling the client when to retry.
+func (d *Daemon) sendRateLimited(enc *json.Encoder, id uint64, message string, retryAfter time.Duration) {
+	resp := protocol.Response{
+		ID: id,
+		Error: &protocol.Error{
+			Code:       protocol.ErrCodeRateLimited,
+			Message:    message,
+			RetryAfter: retryAfter.Seconds(),
+		},
+	}
+	enc.Encode(resp)
+}
+
 // handleLogs returns logs based on Splunk-like query parameters.
 func (d *Daemon) handleLogs(enc *json.Encoder, req *protocol.Request) {
 	if d.store == nil {
-		d.sendError(enc, req.ID, protocol.ErrCodeInternal, "storage not initialized")
+		d.sendError(enc, req.ID, protocol.ErrCodeStorageUnavailable, "storage not initialized")
 		return
 	}
 
@@ -199,33 +549,32 @@ func (d *Daemon) handleLogs(enc *json.Encoder, req *protocol.Request) {
 		}
 	}
 
-	// Default time range: last 15 minutes
-	earliest := params.Earliest
-	if earliest == "" {
-		earliest = "-15m"
-	}
-	latest := params.Latest
-	if latest == "" {
-		latest = "now"
+	// Another node's logs: proxy the query to its control socket
+	if params.Peer != "" && params.Peer != d.config.VPNAddress {
+		if params.Follow {
+			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, "follow is not proxied; connect to the peer directly")
+			return
+		}
+		if !d.isVPNPeer(params.Peer) {
+			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams,
+				fmt.Sprintf("peer %q is not a VPN address in %s", params.Peer, d.subnet))
+			return
+		}
+		d.proxyLogs(enc, req, params)
+		return
 	}
 
-	// Parse time range
-	timeRange, err := store.ParseTimeRange(earliest, latest)
+	query, err := logQuery(params)
 	if err != nil {
-		d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, fmt.Sprintf("invalid time range: %v", err))
+		d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, err.Error())
 		return
 	}
 
-	// Build query
-	query := &store.LogQuery{
-		TimeRange:  timeRange,
-		Levels:     params.Levels,
-		Components: params.Components,
-		Search:     params.Search,
-		Limit:      params.Limit,
-	}
-	if query.Limit <= 0 {
-		query.Limit = 100
+	// Subscribe before querying so nothing written in between is missed
+	var sub chan *store.LogEntry
+	if params.Follow {
+		sub = d.store.SubscribeLogs()
+		defer d.store.UnsubscribeLogs(sub)
 	}
 
 	// Execute query
@@ -235,123 +584,177 @@ func (d *Daemon) handleLogs(enc *json.Encoder, req *protocol.Request) {
 		return
 	}
 
-	// Convert to protocol format
-	entries := make([]protocol.LogEntry, len(result.Entries))
-	for i, e := range result.Entries {
-		entries[i] = protocol.LogEntry{
-			ID:        e.ID,
-			Timestamp: e.Timestamp.Format(time.RFC3339),
-			Level:     e.Level,
-			Component: e.Component,
-			Message:   e.Message,
-			Fields:    e.Fields,
-		}
-	}
-
-	d.sendResult(enc, req.ID, protocol.LogsResult{
-		Entries:    entries,
-		TotalCount: result.TotalCount,
-		HasMore:    result.HasMore,
-	})
-}
+	logs := toLogsResult(result)
+	var lastID int64
+	for _, e := range result.Entries {
+		if e.ID > lastID {
+			lastID = e.ID
+		}
+	}
+	if !params.Follow {
+		d.sendResult(enc, req.ID, logs)
+		return
+	}
 
-// handleStats returns metrics based on Splunk-like query parameters.
-func (d *Daemon) handleStats(enc *json.Encoder, req *protocol.Request) {
-	if d.store == nil {
-		d.sendError(enc, req.ID, protocol.ErrCodeInternal, "storage not initialized")
+	if err := d.sendChunk(enc, req.ID, logs); err != nil {
 		return
 	}
+	d.followLogs(enc, req, params, sub, lastID)
+}
 
-	var params protocol.StatsParams
-	if req.Params != nil {
-		if err := json.Unmarshal(req.Params, &params); err != nil {
-			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, "invalid params")
-			return
+// toFieldFilters converts the field filters of a logs request.
+func toFieldFilters(fields []protocol.LogFieldFilter) ([]store.FieldFilter, error) {
+	var filters []store.FieldFilter
+	for _, f := range fields {
+		op := f.Op
+		if op == "" {
+			op = "="
+		}
+		if !store.ValidFieldOp(op) {
+			return nil, fmt.Errorf("unknown field operator %q (use = or !=)", f.Op)
 		}
+		if f.Key == "" {
+			return nil, fmt.Errorf("field filter without a name")
+		}
+		filters = append(filters, store.FieldFilter{Key: f.Key, Op: op, Value: f.Value})
 	}
+	return filters, nil
+}
 
-	// Default time range: last 5 minutes
-	earliest := params.Earliest
-	if earliest == "" {
-		earliest = "-5m"
+// followHeartbeat is how often a quiet log stream sends an empty result,
+// so clients that went away are noticed.
+const followHeartbeat = 30 * time.Second
+
+// followLogs streams entries from sub that match params, skipping those up
+// to afterID (already sent), until the client disconnects.
+func (d *Daemon) followLogs(enc *json.Encoder, req *protocol.Request, params protocol.LogsParams, sub chan *store.LogEntry, afterID int64) {
+	// Nothing may be logged here: each line would come straight back
+	ticker := time.NewTicker(followHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		var chunk protocol.LogsResult
+		select {
+		case e := <-sub:
+			if e.ID <= afterID || !matchesLogsParams(e, params) {
+				continue
+			}
+			chunk.Entries = []protocol.LogEntry{toProtocolLogEntry(e)}
+			chunk.TotalCount = 1
+		case <-ticker.C:
+			chunk.Entries = []protocol.LogEntry{}
+		}
+
+		if err := d.sendChunk(enc, req.ID, chunk); err != nil {
+			return // Client went away
+		}
 	}
-	latest := params.Latest
-	if latest == "" {
-		latest = "now"
+}
+
+// matchesLogsParams applies the level, component, field and search filters
+// of a logs query to a single entry.
+func matchesLogsParams(e *store.LogEntry, params protocol.LogsParams) bool {
+	if len(params.Levels) > 0 && !containsFold(params.Levels, e.Level) {
+		return false
+	}
+	if len(params.Components) > 0 && !containsFold(params.Components, e.Component) {
+		return false
 	}
+	fieldFilters, _ := toFieldFilters(params.Fields)
+	for _, f := range fieldFilters {
+		if !f.Matches(e.Fields) {
+			return false
+		}
+	}
+	return params.Search == "" || store.MatchesSearch(params.Search, e.Message)
+}
 
-	// Parse time range
-	timeRange, err := store.ParseTimeRange(earliest, latest)
-	if err != nil {
-		d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, fmt.Sprintf("invalid time range: %v", err))
-		return
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
 	}
+	return false
+}
 
-	// Build query
-	query := &store.MetricQuery{
-		TimeRange:   timeRange,
-		Names:       params.Metrics,
-		Granularity: params.Granularity,
+// toProtocolLogEntry converts a stored log entry to protocol format.
+func toProtocolLogEntry(e *store.LogEntry) protocol.LogEntry {
+	return protocol.LogEntry{
+		ID:        e.ID,
+		Timestamp: e.Timestamp.Format(time.RFC3339),
+		Level:     e.Level,
+		Component: e.Component,
+		Message:   e.Message,
+		Fields:    e.Fields,
+		Snippet:   e.Snippet,
 	}
+}
 
-	// Execute query
-	result, err := d.store.QueryMetrics(query)
+// isVPNPeer reports whether addr is a connected peer's VPN IP or lies inside
+// the VPN subnet, so proxied queries never leave the VPN.
+func (d *Daemon) isVPNPeer(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	d.mu.RLock()
+	_, connected := d.peers[ip.String()]
+	d.mu.RUnlock()
+	return connected || d.subnet.Contains(ip)
+}
+
+// proxyLogs runs a logs query on a peer's control socket (<peer>:9001).
+func (d *Daemon) proxyLogs(enc *json.Encoder, req *protocol.Request, params protocol.LogsParams) {
+	peer := params.Peer
+	params.Peer = "" // The peer answers with its own logs
+
+	client, err := cli.DialPeer(peer)
 	if err != nil {
-		d.sendError(enc, req.ID, protocol.ErrCodeInternal, fmt.Sprintf("query failed: %v", err))
+		log.Printf("[control] Logs from %s unavailable: %v", peer, err)
+		d.sendResult(enc, req.ID, protocol.LogsResult{
+			Entries: []protocol.LogEntry{},
+			Warning: protocol.LogsWarningPeerUnreachable,
+		})
 		return
 	}
+	defer client.Close()
+	// Peers only get read queries: send the read-only token, never the
+	// control token, so the admin secret stays on this node
+	client.SetToken(d.config.ReadonlyToken)
 
-	// Convert to protocol format
-	series := make([]protocol.MetricSeries, len(result.Series))
-	for i, s := range result.Series {
-		points := make([]protocol.MetricPoint, len(s.Points))
-		for j, p := range s.Points {
-			points[j] = protocol.MetricPoint{
-				Timestamp:   p.Timestamp.Format(time.RFC3339),
-				Name:        p.Name,
-				Value:       p.Value,
-				Granularity: p.Granularity,
-			}
-		}
-		series[i] = protocol.MetricSeries{
-			Name:   s.Name,
-			Points: points,
-		}
+	result, err := client.Logs(params)
+	if err != nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeInternal, fmt.Sprintf("peer query failed: %v", err))
+		return
 	}
 
-	// Get latest values as summary
-	summary := make(map[string]float64)
-	if len(params.Metrics) == 0 {
-		// Default metrics
-		params.Metrics = []string{
-			"vpn.bytes_sent", "vpn.bytes_recv",
-			"vpn.packets_sent", "vpn.packets_recv",
-			"vpn.active_peers", "vpn.uptime_seconds",
-			"bandwidth.tx_current_bps", "bandwidth.rx_current_bps",
-		}
-	}
-	latestValues, _ := d.store.GetLatestMetrics(params.Metrics)
-	for k, v := range latestValues {
-		summary[k] = v
+	d.sendResult(enc, req.ID, *result)
+}
+
+// handleStats returns metrics based on Splunk-like query parameters.
+func (d *Daemon) handleStats(enc *json.Encoder, req *protocol.Request) {
+	if d.store == nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeStorageUnavailable, "storage not initialized")
+		return
 	}
 
-	// Get storage info
-	storageInfo := make(map[string]float64)
-	if stats, err := d.store.GetStorageStats(); err == nil {
-		for k, v := range stats {
-			if f, ok := v.(float64); ok {
-				storageInfo[k] = f
-			} else if i, ok := v.(int64); ok {
-				storageInfo[k] = float64(i)
-			}
+	var params protocol.StatsParams
+	if req.Params != nil {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, "invalid params")
+			return
 		}
 	}
 
-	d.sendResult(enc, req.ID, protocol.StatsResult{
-		Series:      series,
-		Summary:     summary,
-		StorageInfo: storageInfo,
-	})
+	result, err := QueryStats(d.store, params)
+	if err != nil {
+		var ce *protocol.ControlError
+		errors.As(err, &ce)
+		d.sendError(enc, req.ID, ce.Code, ce.Message)
+		return
+	}
+	d.sendResult(enc, req.ID, result)
 }
 
 // formatDuration formats a duration in a human-readable way.
@@ -393,7 +796,7 @@ func (d *Daemon) handleConnect(enc *json.Encoder, req *protocol.Request) {
 // us to re-enable routing after a server restart.
 func (d *Daemon) handleDisconnect(enc *json.Encoder, req *protocol.Request) {
 	// Only send intent if we're connected to a server and have routing enabled
-	if d.vpnConn != nil && d.config.RouteAll {
+	if conn := d.vpnConn.Load(); conn != nil && d.config.RouteAll {
 		// Send DISCONNECT_INTENT to server (Connection Intent Protocol)
 		hostname, _ := os.Hostname()
 		intent := protocol.DisconnectIntent{
@@ -403,7 +806,7 @@ func (d *Daemon) handleDisconnect(enc *json.Encoder, req *protocol.Request) {
 			RouteAll:   d.config.RouteAll,
 		}
 		intentMsg := protocol.MakeDisconnectIntentMessage(intent)
-		if err := d.vpnConn.WritePacket(intentMsg); err != nil {
+		if err := conn.WritePacket(intentMsg); err != nil {
 			log.Printf("[vpn] Failed to send DISCONNECT_INTENT: %v", err)
 			// Continue anyway - disconnection is more important than the intent protocol
 		} else {
@@ -449,9 +852,42 @@ func (d *Daemon) getConnectionStatus() *protocol.ConnectionStatus {
 		status.ConnectedAt = d.startTime.Format(time.RFC3339)
 	}
 
+	if d.tun != nil {
+		status.RouteSubnets = d.tun.SubnetRoutes()
+	}
+
+	status.KillSwitch = d.config.KillSwitch
+	status.KillSwitchEngaged = d.KillSwitchEngaged()
+
 	return status
 }
 
+// handleRoutes returns the routes the TUN device installed, each checked
+// against the live routing table.
+func (d *Daemon) handleRoutes(enc *json.Encoder, req *protocol.Request) {
+	result := protocol.RoutesResult{Routes: []protocol.RouteEntry{}}
+	if d.tun == nil {
+		d.sendResult(enc, req.ID, result)
+		return
+	}
+
+	table, err := tunnel.SystemRoutes()
+	if err != nil {
+		result.TableError = err.Error()
+	}
+	for _, r := range d.tun.Routes() {
+		result.Routes = append(result.Routes, protocol.RouteEntry{
+			Destination: r.Destination,
+			Gateway:     r.Gateway,
+			Interface:   r.Interface,
+			Purpose:     r.Purpose,
+			Installed:   err == nil && r.InTable(table),
+		})
+	}
+
+	d.sendResult(enc, req.ID, result)
+}
+
 // handleTopology returns the full network topology.
 // The node returns raw data; the UI/CLI layer decides how to display it.
 func (d *Daemon) handleTopology(enc *json.Encoder, req *protocol.Request) {
@@ -460,6 +896,42 @@ func (d *Daemon) handleTopology(enc *json.Encoder, req *protocol.Request) {
 		return
 	}
 
+	d.sendResult(enc, req.ID, d.topologyResult())
+}
+
+// topologyWatchInterval is how often topology_watch resends the topology
+// when nothing changed, refreshing traffic counters and detecting clients
+// that went away.
+const topologyWatchInterval = 30 * time.Second
+
+// handleTopologyWatch streams the topology: the current graph first, then
+// again on every change, until the client disconnects.
+func (d *Daemon) handleTopologyWatch(enc *json.Encoder, req *protocol.Request) {
+	if d.topology == nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeInternal, "topology not initialized")
+		return
+	}
+
+	sub := d.topology.Subscribe()
+	defer d.topology.Unsubscribe(sub)
+
+	ticker := time.NewTicker(topologyWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.sendChunk(enc, req.ID, d.topologyResult()); err != nil {
+			return // Client went away
+		}
+
+		select {
+		case <-sub:
+		case <-ticker.C:
+		}
+	}
+}
+
+// topologyResult converts the current topology to protocol format.
+func (d *Daemon) topologyResult() protocol.TopologyResult {
 	nodes := d.topology.GetAllNodes()
 	edges := d.topology.GetAllEdges()
 
@@ -497,10 +969,10 @@ func (d *Daemon) handleTopology(enc *json.Encoder, req *protocol.Request) {
 		}
 	}
 
-	d.sendResult(enc, req.ID, protocol.TopologyResult{
+	return protocol.TopologyResult{
 		Nodes: protoNodes,
 		Edges: protoEdges,
-	})
+	}
 }
 
 // handleNetworkPeers returns the list of network peers (for client mode).
@@ -519,7 +991,7 @@ func (d *Daemon) handleNetworkPeers(enc *json.Encoder, req *protocol.Request) {
 			Name:       d.config.NodeName,
 			VPNAddress: d.config.VPNAddress,
 			Hostname:   hostname,
-			OS:         "linux",
+			OS:         runtime.GOOS,
 		})
 
 		// Add connected peers
@@ -543,28 +1015,95 @@ func (d *Daemon) handleNetworkPeers(enc *json.Encoder, req *protocol.Request) {
 	})
 }
 
-// handleLifecycle returns recent lifecycle events.
-func (d *Daemon) handleLifecycle(enc *json.Encoder, req *protocol.Request) {
+// handleAutofix records a 'vpn diagnose --fix' attempt as an AUTOFIX
+// lifecycle event.
+func (d *Daemon) handleAutofix(enc *json.Encoder, req *protocol.Request) {
 	if d.store == nil {
-		d.sendError(enc, req.ID, protocol.ErrCodeInternal, "storage not initialized")
+		d.sendError(enc, req.ID, protocol.ErrCodeStorageUnavailable, "storage not initialized")
 		return
 	}
 
-	var params protocol.LifecycleParams
+	var params protocol.AutofixParams
 	if req.Params != nil {
 		if err := json.Unmarshal(req.Params, &params); err != nil {
 			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, "invalid params")
 			return
 		}
 	}
-
-	if params.Limit <= 0 {
-		params.Limit = 20
-	}
-
-	events, err := d.store.GetLifecycleEvents(params.Limit)
-	if err != nil {
-		d.sendError(enc, req.ID, protocol.ErrCodeInternal, fmt.Sprintf("query failed: %v", err))
+	if params.Check == "" || params.Action == "" {
+		d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, "check and action are required")
+		return
+	}
+
+	// "DNS Resolution: flush DNS cache - fixed"
+	outcome := "not fixed"
+	if params.Fixed {
+		outcome = "fixed"
+	}
+	reason := fmt.Sprintf("%s: %s - %s", params.Check, params.Action, outcome)
+	if params.Error != "" {
+		reason += " (" + params.Error + ")"
+	}
+	if err := d.store.WriteLifecycleEvent("AUTOFIX", reason, d.Uptime().Seconds(), d.config.RouteAll, false, Version); err != nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeInternal, fmt.Sprintf("failed to record: %v", err))
+		return
+	}
+	log.Printf("[node] Autofix: %s", reason)
+
+	d.sendResult(enc, req.ID, protocol.AutofixResult{Recorded: true})
+}
+
+// handleLifecycle returns recent lifecycle events.
+func (d *Daemon) handleLifecycle(enc *json.Encoder, req *protocol.Request) {
+	if d.store == nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeStorageUnavailable, "storage not initialized")
+		return
+	}
+
+	var params protocol.LifecycleParams
+	if req.Params != nil {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, "invalid params")
+			return
+		}
+	}
+
+	if params.Limit <= 0 {
+		params.Limit = 20
+	}
+
+	filter := store.LifecycleFilter{AfterID: params.AfterID}
+	for _, e := range params.Events {
+		if e = strings.ToUpper(strings.TrimSpace(e)); e != "" {
+			filter.Events = append(filter.Events, e)
+		}
+	}
+	// Either bound may be omitted; unlike crash_stats there is no default
+	// window, the limit alone bounds the history.
+	if params.Since != "" {
+		since, err := store.ParseRelativeTime(params.Since)
+		if err != nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, fmt.Sprintf("invalid since time '%s': %v", params.Since, err))
+			return
+		}
+		filter.Since = since
+	}
+	if params.Until != "" {
+		until, err := store.ParseRelativeTime(params.Until)
+		if err != nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, fmt.Sprintf("invalid until time '%s': %v", params.Until, err))
+			return
+		}
+		filter.Until = until
+	}
+	if !filter.Since.IsZero() && !filter.Until.IsZero() && filter.Since.After(filter.Until) {
+		d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, "invalid time range: since is after until")
+		return
+	}
+
+	events, err := d.store.GetLifecycleEvents(filter, params.Limit)
+	if err != nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeInternal, fmt.Sprintf("query failed: %v", err))
 		return
 	}
 
@@ -589,7 +1128,7 @@ func (d *Daemon) handleLifecycle(enc *json.Encoder, req *protocol.Request) {
 // handleCrashStats returns crash statistics.
 func (d *Daemon) handleCrashStats(enc *json.Encoder, req *protocol.Request) {
 	if d.store == nil {
-		d.sendError(enc, req.ID, protocol.ErrCodeInternal, "storage not initialized")
+		d.sendError(enc, req.ID, protocol.ErrCodeStorageUnavailable, "storage not initialized")
 		return
 	}
 
@@ -606,22 +1145,26 @@ func (d *Daemon) handleCrashStats(enc *json.Encoder, req *protocol.Request) {
 	if since == "" {
 		since = "-24h"
 	}
+	until := params.Until
+	if until == "" {
+		until = "now"
+	}
 
 	// Parse time range
-	timeRange, err := store.ParseTimeRange(since, "now")
+	timeRange, err := store.ParseTimeRange(since, until)
 	if err != nil {
 		d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, fmt.Sprintf("invalid time range: %v", err))
 		return
 	}
 
-	total, withRouteAll, restoreFailures, err := d.store.GetCrashStats(timeRange.Start)
+	total, withRouteAll, restoreFailures, err := d.store.GetCrashStats(timeRange.Start, timeRange.End)
 	if err != nil {
 		d.sendError(enc, req.ID, protocol.ErrCodeInternal, fmt.Sprintf("query failed: %v", err))
 		return
 	}
 
 	// Get last crash
-	lastCrash, err := d.store.GetLastCrash()
+	lastCrash, err := d.store.GetLastCrash(timeRange.End)
 	if err != nil {
 		d.sendError(enc, req.ID, protocol.ErrCodeInternal, fmt.Sprintf("query failed: %v", err))
 		return
@@ -631,6 +1174,8 @@ func (d *Daemon) handleCrashStats(enc *json.Encoder, req *protocol.Request) {
 		TotalCrashes:         total,
 		CrashesWithRouteAll:  withRouteAll,
 		RouteRestoreFailures: restoreFailures,
+		Start:                timeRange.Start.Format(time.RFC3339),
+		End:                  timeRange.End.Format(time.RFC3339),
 	}
 
 	if lastCrash != nil {
@@ -704,7 +1249,7 @@ func (d *Daemon) handleHandshakeHistory(enc *json.Encoder, req *protocol.Request
 
 	// In client mode, proxy the request to the server
 	if !d.config.ServerMode {
-		serverAddr := "10.8.0.1:9001"
+		serverAddr := net.JoinHostPort(d.serverIP(), "9001")
 		client, err := cli.NewClient(serverAddr)
 		if err != nil {
 			// Return empty result if can't reach server
@@ -715,8 +1260,9 @@ func (d *Daemon) handleHandshakeHistory(enc *json.Encoder, req *protocol.Request
 			return
 		}
 		defer client.Close()
+		client.SetToken(d.config.ReadonlyToken)
 
-		history, err := client.HandshakeHistory(params.NodeName, params.Limit)
+		history, err := client.HandshakeHistory(params)
 		if err != nil {
 			d.sendError(enc, req.ID, protocol.ErrCodeInternal, fmt.Sprintf("server query failed: %v", err))
 			return
@@ -728,11 +1274,16 @@ func (d *Daemon) handleHandshakeHistory(enc *json.Encoder, req *protocol.Request
 
 	// Server mode: query local store
 	if d.store == nil {
-		d.sendError(enc, req.ID, protocol.ErrCodeInternal, "storage not initialized")
+		d.sendError(enc, req.ID, protocol.ErrCodeStorageUnavailable, "storage not initialized")
 		return
 	}
 
-	records, total, err := d.store.GetHandshakeHistory(params.NodeName, params.Limit)
+	filter := store.HandshakeFilter{
+		NodeName: params.NodeName,
+		OS:       params.OS,
+		Failed:   params.Failed,
+	}
+	records, total, err := d.store.GetHandshakeHistory(filter, params.Limit)
 	if err != nil {
 		d.sendError(enc, req.ID, protocol.ErrCodeInternal, fmt.Sprintf("query failed: %v", err))
 		return
@@ -755,6 +1306,8 @@ func (d *Daemon) handleHandshakeHistory(enc *json.Encoder, req *protocol.Request
 			SSHTestOK:  r.SSHTestOK,
 			PingTestOK: r.PingTestOK,
 			PingTestMS: r.PingTestMS,
+
+			SSHTestError: r.SSHTestError,
 		}
 	}
 
@@ -763,3 +1316,736 @@ func (d *Daemon) handleHandshakeHistory(enc *json.Encoder, req *protocol.Request
 		Total:   total,
 	})
 }
+
+// maxExportRows caps how many rows a single export may stream.
+const maxExportRows = 100000
+
+// handleExport streams logs, metrics and/or lifecycle events for a time window.
+// Rows are sent one per line as they are read so large exports never have to
+// be buffered in memory on either side.
+func (d *Daemon) handleExport(enc *json.Encoder, req *protocol.Request) {
+	if d.store == nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeStorageUnavailable, "storage not initialized")
+		return
+	}
+
+	var params protocol.ExportParams
+	if req.Params != nil {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, "invalid params")
+			return
+		}
+	}
+
+	switch params.Type {
+	case "", "logs", "metrics", "lifecycle":
+	default:
+		d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, fmt.Sprintf("invalid export type: %s", params.Type))
+		return
+	}
+
+	maxRows := params.MaxRows
+	if maxRows <= 0 || maxRows > maxExportRows {
+		maxRows = maxExportRows
+	}
+
+	// Default time range: last hour
+	earliest := params.Earliest
+	if earliest == "" {
+		earliest = "-1h"
+	}
+	latest := params.Latest
+	if latest == "" {
+		latest = "now"
+	}
+
+	timeRange, err := store.ParseTimeRange(earliest, latest)
+	if err != nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, fmt.Sprintf("invalid time range: %v", err))
+		return
+	}
+
+	// Header first, then rows (millisecond timestamps for offline analysis)
+	header := protocol.ExportChunk{Header: &protocol.ExportHeader{
+		NodeName: d.config.NodeName,
+		Version:  Version,
+		Start:    timeRange.Start.Format(time.RFC3339Nano),
+		End:      timeRange.End.Format(time.RFC3339Nano),
+		Type:     params.Type,
+	}}
+	if err := d.sendChunk(enc, req.ID, header); err != nil {
+		return
+	}
+
+	sendRow := func(rowType string, row interface{}) error {
+		data, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		return d.sendChunk(enc, req.ID, protocol.ExportChunk{Type: rowType, Row: data})
+	}
+
+	done := protocol.ExportChunk{Done: true}
+	var crashStats store.ExportCrashStats
+
+	// wants reports whether a section should be exported. Once the row cap is
+	// used up, remaining sections are skipped and the export marked truncated.
+	wants := func(section string) bool {
+		if params.Type != "" && params.Type != section {
+			return false
+		}
+		if done.Truncated || done.Rows >= maxRows {
+			done.Truncated = true
+			return false
+		}
+		return true
+	}
+
+	if wants("logs") {
+		n, truncated, err := d.store.StreamLogs(timeRange, maxRows-done.Rows, func(e *store.LogEntry) error {
+			return sendRow("log", protocol.LogEntry{
+				ID:        e.ID,
+				Timestamp: e.Timestamp.Format(time.RFC3339Nano),
+				Level:     e.Level,
+				Component: e.Component,
+				Message:   e.Message,
+				Fields:    e.Fields,
+			})
+		})
+		done.Rows += n
+		done.Truncated = done.Truncated || truncated
+		if err != nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeInternal, fmt.Sprintf("export failed: %v", err))
+			return
+		}
+	}
+
+	if wants("metrics") {
+		n, truncated, err := d.store.StreamMetrics(timeRange, params.Metrics, params.Granularity, maxRows-done.Rows, func(p store.MetricPoint) error {
+			return sendRow("metric", protocol.MetricPoint{
+				Timestamp:   p.Timestamp.Format(time.RFC3339Nano),
+				Name:        p.Name,
+				Value:       p.Value,
+				Granularity: p.Granularity,
+				Tags:        p.Tags,
+			})
+		})
+		done.Rows += n
+		done.Truncated = done.Truncated || truncated
+		if err != nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeInternal, fmt.Sprintf("export failed: %v", err))
+			return
+		}
+	}
+
+	if wants("lifecycle") {
+		n, truncated, err := d.store.StreamLifecycle(timeRange, maxRows-done.Rows, func(e store.LifecycleEvent) error {
+			crashStats.Add(e)
+			return sendRow("lifecycle", toProtocolLifecycleEvent(e))
+		})
+		done.Rows += n
+		done.Truncated = done.Truncated || truncated
+		if err != nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeInternal, fmt.Sprintf("export failed: %v", err))
+			return
+		}
+
+		done.CrashStats = &protocol.CrashStatsResult{
+			TotalCrashes:         crashStats.TotalCrashes,
+			CrashesWithRouteAll:  crashStats.CrashesWithRouteAll,
+			RouteRestoreFailures: crashStats.RouteRestoreFailures,
+		}
+		if crashStats.LastCrash != nil {
+			lastCrash := toProtocolLifecycleEvent(*crashStats.LastCrash)
+			done.CrashStats.LastCrash = &lastCrash
+		}
+	}
+
+	if done.Truncated {
+		done.Warning = fmt.Sprintf("export truncated at %d rows; narrow the time range or --type", maxRows)
+	}
+
+	log.Printf("[control] Export %s to %s (type=%q): %d rows, truncated=%v",
+		earliest, latest, params.Type, done.Rows, done.Truncated)
+
+	d.sendChunk(enc, req.ID, done)
+}
+
+// sendChunk sends one line of a streamed response, reporting write failures
+// so the stream can stop when the client goes away.
+func (d *Daemon) sendChunk(enc *json.Encoder, id uint64, chunk interface{}) error {
+	data, err := json.Marshal(chunk)
+	if err != nil {
+		return err
+	}
+	return enc.Encode(protocol.Response{
+		ID:     id,
+		Result: data,
+	})
+}
+
+// toProtocolLifecycleEvent converts a stored lifecycle event to protocol format.
+func toProtocolLifecycleEvent(e store.LifecycleEvent) protocol.LifecycleEvent {
+	return protocol.LifecycleEvent{
+		ID:            e.ID,
+		Timestamp:     e.Timestamp.Format(time.RFC3339Nano),
+		Event:         e.Event,
+		Reason:        e.Reason,
+		UptimeSeconds: e.UptimeSeconds,
+		RouteAll:      e.RouteAll,
+		RouteRestored: e.RouteRestored,
+		Version:       e.Version,
+	}
+}
+
+// handleRetention returns the retention policy currently in effect.
+func (d *Daemon) handleRetention(enc *json.Encoder, req *protocol.Request) {
+	if d.store == nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeStorageUnavailable, "storage not initialized")
+		return
+	}
+
+	d.sendResult(enc, req.ID, retentionResult(d.store.RetentionPolicy()))
+}
+
+// handleSetRetention changes the retention policy without restarting the daemon.
+// The store persists the new policy and immediately enforces it.
+func (d *Daemon) handleSetRetention(enc *json.Encoder, req *protocol.Request) {
+	if d.store == nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeStorageUnavailable, "storage not initialized")
+		return
+	}
+
+	var params protocol.SetRetentionParams
+	if req.Params != nil {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, "invalid params")
+			return
+		}
+	}
+
+	var policy store.RetentionPolicy
+	fields := []struct {
+		name  string
+		value string
+		dest  *time.Duration
+	}{
+		{"logs", params.Logs, &policy.Logs},
+		{"metrics_raw", params.MetricsRaw, &policy.MetricsRaw},
+		{"metrics_1m", params.Metrics1m, &policy.Metrics1m},
+		{"metrics_1h", params.Metrics1h, &policy.Metrics1h},
+	}
+	for _, f := range fields {
+		if f.value == "" {
+			continue
+		}
+		dur, err := store.ParseDuration(f.value)
+		if err != nil || dur <= 0 {
+			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, fmt.Sprintf("invalid %s retention: %s", f.name, f.value))
+			return
+		}
+		*f.dest = dur
+	}
+
+	current, err := d.store.SetRetentionPolicy(policy)
+	if err != nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeInternal, err.Error())
+		return
+	}
+
+	d.sendResult(enc, req.ID, retentionResult(current))
+}
+
+// preferenceKeys are the dashboard preferences the node stores.
+var preferenceKeys = map[string]bool{
+	"theme":                 true, // dark, light
+	"default_metrics_range": true, // Splunk time spec, e.g. -1h
+	"default_log_range":     true,
+}
+
+// handlePreferences returns the dashboard preferences.
+func (d *Daemon) handlePreferences(enc *json.Encoder, req *protocol.Request) {
+	if d.store == nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeStorageUnavailable, "storage not initialized")
+		return
+	}
+
+	prefs, err := d.store.GetPreferences()
+	if err != nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeInternal, fmt.Sprintf("query failed: %v", err))
+		return
+	}
+
+	d.sendResult(enc, req.ID, protocol.PreferencesResult{Preferences: prefs})
+}
+
+// handleSetPreferences stores dashboard preferences, so the theme and
+// default time ranges follow the user across browsers and devices.
+func (d *Daemon) handleSetPreferences(enc *json.Encoder, req *protocol.Request) {
+	if d.store == nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeStorageUnavailable, "storage not initialized")
+		return
+	}
+
+	var params protocol.SetPreferencesParams
+	if req.Params != nil {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, "invalid params")
+			return
+		}
+	}
+
+	for key, value := range params.Preferences {
+		if !preferenceKeys[key] {
+			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, fmt.Sprintf("unknown preference: %s", key))
+			return
+		}
+		switch {
+		case key == "theme" && value != "" && value != "dark" && value != "light":
+			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, fmt.Sprintf("invalid theme %q (want dark or light)", value))
+			return
+		case key != "theme" && value != "":
+			if _, err := store.ParseRelativeTime(value); err != nil {
+				d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, fmt.Sprintf("invalid %s %q: %v", key, value, err))
+				return
+			}
+		}
+	}
+
+	if err := d.store.SetPreferences(params.Preferences); err != nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeInternal, err.Error())
+		return
+	}
+
+	d.handlePreferences(enc, req)
+}
+
+// retentionResult converts a store retention policy to protocol format.
+func retentionResult(p store.RetentionPolicy) protocol.RetentionResult {
+	return protocol.RetentionResult{
+		Logs:       store.FormatDuration(p.Logs),
+		MetricsRaw: store.FormatDuration(p.MetricsRaw),
+		Metrics1m:  store.FormatDuration(p.Metrics1m),
+		Metrics1h:  store.FormatDuration(p.Metrics1h),
+	}
+}
+
+// handleBenchServer starts (or extends) the benchmark responder.
+func (d *Daemon) handleBenchServer(enc *json.Encoder, req *protocol.Request) {
+	params := protocol.BenchServerParams{DurationSec: 60}
+	if req.Params != nil {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, "invalid params")
+			return
+		}
+	}
+
+	duration := time.Duration(params.DurationSec) * time.Second
+	if duration <= 0 || duration > benchMaxDuration {
+		d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, fmt.Sprintf("duration must be between 1s and %s", benchMaxDuration))
+		return
+	}
+
+	addr, until, err := d.startBenchResponder(duration)
+	if err != nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeInternal, err.Error())
+		return
+	}
+
+	d.sendResult(enc, req.ID, protocol.BenchServerResult{
+		Address: addr,
+		Until:   until.Format(time.RFC3339),
+	})
+}
+
+// handleBenchClient measures throughput, loss and jitter to a peer's responder.
+// It blocks for the length of the test.
+func (d *Daemon) handleBenchClient(enc *json.Encoder, req *protocol.Request) {
+	params := protocol.BenchClientParams{DurationSec: 10}
+	if req.Params != nil {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, "invalid params")
+			return
+		}
+	}
+
+	if net.ParseIP(params.Peer) == nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, fmt.Sprintf("invalid peer address: %q", params.Peer))
+		return
+	}
+	duration := time.Duration(params.DurationSec) * time.Second
+	if duration <= 0 || duration > benchMaxDuration {
+		d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, fmt.Sprintf("duration must be between 1s and %s", benchMaxDuration))
+		return
+	}
+
+	if !d.config.ServerMode && !d.IsConnected() {
+		d.sendError(enc, req.ID, protocol.ErrCodeNotConnected, errNotConnected.Error())
+		return
+	}
+
+	result, err := d.runBenchmark(params.Peer, duration)
+	if err != nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeInternal, err.Error())
+		return
+	}
+
+	if params.Store {
+		d.storeBenchResult(result)
+		result.Stored = true
+	}
+
+	d.sendResult(enc, req.ID, result)
+}
+
+// handleConfig returns the daemon configuration.
+func (d *Daemon) handleConfig(enc *json.Encoder, req *protocol.Request) {
+	d.sendResult(enc, req.ID, d.configResult())
+}
+
+// handleInvite returns the parameters a new client installs with (server
+// mode). The VPN address is the one assignIP would hand out next. The
+// secret is the read-only token: the invite is handed to other devices, so
+// it must never carry the admin control token.
+func (d *Daemon) handleInvite(enc *json.Encoder, req *protocol.Request) {
+	if !d.config.ServerMode {
+		d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, "invite is only available on the server")
+		return
+	}
+
+	result := protocol.InviteResult{
+		VPNAddress: d.peekNextIP(),
+		Subnet:     d.subnet.String(),
+		Secret:     d.config.ReadonlyToken,
+		Version:    Version,
+	}
+	if d.config.Transport == tunnel.TransportUDP {
+		result.Transport = tunnel.TransportUDP
+	}
+	if _, port, err := net.SplitHostPort(d.config.ListenVPN); err == nil && d.ourPublicIP != "" {
+		result.Server = net.JoinHostPort(d.ourPublicIP, port)
+	}
+
+	d.sendResult(enc, req.ID, result)
+}
+
+// immutableConfigKeys are options that require a restart to change.
+var immutableConfigKeys = map[string]bool{
+	"listen_vpn":     true,
+	"listen_ws":      true,
+	"listen_control": true,
+	"server_mode":    true,
+	"connect_to":     true,
+	"vpn_address":    true,
+	"subnet":         true,
+	"use_tls":        true,
+	"cert_file":      true,
+	"key_file":       true,
+	"encryption":     true,
+	"cipher":         true,
+	"compression":    true,
+	"transport":      true,
+	"data_dir":       true,
+}
+
+// handleConfigSet changes a mutable option at runtime.
+func (d *Daemon) handleConfigSet(enc *json.Encoder, req *protocol.Request) {
+	var params protocol.ConfigSetParams
+	if req.Params != nil {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, "invalid params")
+			return
+		}
+	}
+
+	key := strings.ToLower(params.Key)
+	if immutableConfigKeys[key] {
+		d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, fmt.Sprintf("%s cannot be changed at runtime (restart the node with the new value)", key))
+		return
+	}
+
+	switch key {
+	case "name", "node_name":
+		if params.Value == "" {
+			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, "name cannot be empty")
+			return
+		}
+		d.config.NodeName = params.Value
+		if d.topology != nil {
+			d.topology.SetOurName(params.Value)
+		}
+		log.Printf("[node] Node name changed to %s", params.Value)
+
+	case "route_all":
+		enable, err := strconv.ParseBool(params.Value)
+		if err != nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, fmt.Sprintf("invalid route_all value: %s (use true or false)", params.Value))
+			return
+		}
+		if enable {
+			err = d.EnableRouteAll()
+		} else {
+			err = d.DisableRouteAll()
+		}
+		if errors.Is(err, errNotConnected) {
+			d.sendError(enc, req.ID, protocol.ErrCodeNotConnected, err.Error())
+			return
+		}
+		if err != nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeInternal, err.Error())
+			return
+		}
+
+	case "kill_switch":
+		enable, err := strconv.ParseBool(params.Value)
+		if err != nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, fmt.Sprintf("invalid kill_switch value: %s (use true or false)", params.Value))
+			return
+		}
+		d.config.KillSwitch = enable
+		log.Printf("[node] Kill switch %s", map[bool]string{true: "enabled", false: "disabled"}[enable])
+
+	case "dns":
+		var servers []string
+		for _, server := range strings.Split(params.Value, ",") {
+			server = strings.TrimSpace(server)
+			if server == "" {
+				continue
+			}
+			if net.ParseIP(server) == nil {
+				d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, fmt.Sprintf("invalid DNS server: %s", server))
+				return
+			}
+			servers = append(servers, server)
+		}
+		d.config.DNS = servers
+		if d.tun != nil {
+			d.tun.SetDNS(servers, d.config.RouteAll)
+		}
+		log.Printf("[node] DNS override set to [%s]", strings.Join(servers, ", "))
+
+	case "peer_rate_limits":
+		limits, err := ParseRateLimits(params.Value)
+		if err != nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, err.Error())
+			return
+		}
+		d.setRateLimits(limits)
+		log.Printf("[node] Peer rate limits set to [%s]", params.Value)
+
+	case "peer_bandwidth_limit":
+		mbps, err := strconv.ParseFloat(params.Value, 64)
+		if err != nil || mbps < 0 {
+			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, fmt.Sprintf("invalid rate %q (use Mbps, 0 = off)", params.Value))
+			return
+		}
+		d.setDefaultRateLimit(int64(mbps * 1000 * 1000))
+		log.Printf("[node] Default peer rate limit set to %g Mbps", mbps)
+
+	case "log_level":
+		if d.logWriter == nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeStorageUnavailable, "storage not initialized")
+			return
+		}
+		level := strings.ToUpper(params.Value)
+		if err := d.logWriter.SetMinLevel(level); err != nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, err.Error())
+			return
+		}
+		d.config.LogLevel = level
+
+	case "logs_retention", "metrics_retention":
+		if d.store == nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeStorageUnavailable, "storage not initialized")
+			return
+		}
+		dur, err := store.ParseDuration(params.Value)
+		if err != nil || dur <= 0 {
+			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, fmt.Sprintf("invalid %s: %s", key, params.Value))
+			return
+		}
+		var policy store.RetentionPolicy
+		if key == "logs_retention" {
+			policy.Logs = dur
+		} else {
+			policy.MetricsRaw = dur
+		}
+		if _, err := d.store.SetRetentionPolicy(policy); err != nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeInternal, err.Error())
+			return
+		}
+
+	default:
+		d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, fmt.Sprintf("unknown config key: %s", params.Key))
+		return
+	}
+
+	d.sendResult(enc, req.ID, d.configResult())
+}
+
+// configResult builds the config snapshot returned to the CLI.
+func (d *Daemon) configResult() protocol.ConfigResult {
+	result := protocol.ConfigResult{
+		NodeName:           d.config.NodeName,
+		VPNAddress:         d.config.VPNAddress,
+		Subnet:             d.config.Subnet,
+		ListenVPN:          d.config.ListenVPN,
+		ListenWS:           d.config.ListenWS,
+		ListenControl:      d.config.ListenControl,
+		ListenControlVPN:   d.config.ListenControlVPN,
+		ServerMode:         d.config.ServerMode,
+		ConnectTo:          d.config.ConnectTo,
+		UseTLS:             d.config.UseTLS,
+		CertFile:           d.config.CertFile,
+		KeyFile:            d.config.KeyFile,
+		Encryption:         d.config.Encryption,
+		EncryptionKeySet:   len(d.config.EncryptionKey) > 0,
+		Cipher:             d.preferredCipher(),
+		Compression:        d.config.Compression,
+		Transport:          d.config.Transport,
+		IPv6:               d.config.IPv6,
+		RouteAll:           d.config.RouteAll,
+		RouteSubnets:       d.config.RouteSubnets,
+		DNS:                d.config.DNS,
+		KillSwitch:         d.config.KillSwitch,
+		DataDir:            d.config.DataDir,
+		LogLevel:           d.config.LogLevel,
+		PeerTimeoutSeconds: d.config.PeerTimeoutSeconds,
+		RTTAlertMs:         d.config.RTTAlertMs,
+		LossAlertPct:       d.config.LossAlertPct,
+		MaxStorageMB:       d.config.MaxStorageMB,
+		PeerRateLimits:     d.config.PeerRateLimits,
+
+		PeerBandwidthLimitMbps: d.defaultRateLimitMbps(),
+	}
+	if result.MaxStorageMB == 0 {
+		result.MaxStorageMB = store.MaxStorageBytes / (1024 * 1024)
+	}
+	if d.store != nil {
+		retention := retentionResult(d.store.RetentionPolicy())
+		result.Retention = &retention
+	}
+	return result
+}
+
+// handleAlertAdd stores a new alert rule.
+func (d *Daemon) handleAlertAdd(enc *json.Encoder, req *protocol.Request) {
+	if d.store == nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeStorageUnavailable, "storage not initialized")
+		return
+	}
+
+	var params protocol.AlertAddParams
+	if req.Params != nil {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, "invalid params")
+			return
+		}
+	}
+
+	rule := &store.AlertRule{
+		Name: params.Name,
+		Condition: store.AlertCondition{
+			Metric: params.Metric,
+			Op:     params.Op,
+			Value:  params.Value,
+		},
+		Action:     params.Action,
+		WebhookURL: params.WebhookURL,
+	}
+	if err := rule.Validate(); err != nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, err.Error())
+		return
+	}
+
+	id, err := d.store.AddAlertRule(rule)
+	if err != nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeInternal, err.Error())
+		return
+	}
+	rule.ID = id
+	rule.CreatedAt = time.Now()
+
+	if d.alerts != nil {
+		if err := d.alerts.reload(); err != nil {
+			log.Printf("[alert] Failed to reload rules: %v", err)
+		}
+	}
+
+	log.Printf("[alert] Added rule %q (%s %s %g -> %s)", rule.Name, rule.Condition.Metric, rule.Condition.Op, rule.Condition.Value, rule.Action)
+	d.sendResult(enc, req.ID, toProtocolAlertRule(*rule))
+}
+
+// handleAlertList returns all alert rules.
+func (d *Daemon) handleAlertList(enc *json.Encoder, req *protocol.Request) {
+	if d.store == nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeStorageUnavailable, "storage not initialized")
+		return
+	}
+
+	rules, err := d.store.ListAlertRules()
+	if err != nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeInternal, err.Error())
+		return
+	}
+
+	result := protocol.AlertListResult{Rules: make([]protocol.AlertRule, len(rules))}
+	for i, r := range rules {
+		result.Rules[i] = toProtocolAlertRule(r)
+	}
+
+	d.sendResult(enc, req.ID, result)
+}
+
+// handleAlertRemove deletes an alert rule by ID or name and returns the remaining rules.
+func (d *Daemon) handleAlertRemove(enc *json.Encoder, req *protocol.Request) {
+	if d.store == nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeStorageUnavailable, "storage not initialized")
+		return
+	}
+
+	var params protocol.AlertRemoveParams
+	if req.Params != nil {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, "invalid params")
+			return
+		}
+	}
+	if params.Rule == "" {
+		d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, "rule is required")
+		return
+	}
+
+	removed, err := d.store.DeleteAlertRule(params.Rule)
+	if err != nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeInternal, err.Error())
+		return
+	}
+	if !removed {
+		d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, fmt.Sprintf("no such rule: %s", params.Rule))
+		return
+	}
+
+	if d.alerts != nil {
+		if err := d.alerts.reload(); err != nil {
+			log.Printf("[alert] Failed to reload rules: %v", err)
+		}
+	}
+
+	log.Printf("[alert] Removed rule %s", params.Rule)
+	d.handleAlertList(enc, req)
+}
+
+// toProtocolAlertRule converts a store alert rule to protocol format.
+func toProtocolAlertRule(r store.AlertRule) protocol.AlertRule {
+	return protocol.AlertRule{
+		ID:         r.ID,
+		Name:       r.Name,
+		Metric:     r.Condition.Metric,
+		Op:         r.Condition.Op,
+		Value:      r.Condition.Value,
+		Action:     r.Action,
+		WebhookURL: r.WebhookURL,
+		CreatedAt:  r.CreatedAt.Format(time.RFC3339),
+	}
+}
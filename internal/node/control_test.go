@@ -0,0 +1,46 @@
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/miguelemosreverte/vpn/internal/protocol"
+)
+
+func TestLogsRefusesPeersOutsideVPN(t *testing.T) {
+	d := startTestServer(t, t.TempDir())
+	defer stopTestServer(t, d)
+
+	for _, peer := range []string{"198.51.100.7", "example.com", "127.0.0.1"} {
+		params, _ := json.Marshal(protocol.LogsParams{Peer: peer})
+		var out bytes.Buffer
+		d.handleLogs(json.NewEncoder(&out), &protocol.Request{ID: 1, Method: "logs", Params: params})
+
+		var resp protocol.Response
+		if err := json.Unmarshal(out.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: bad response %q: %v", peer, out.String(), err)
+		}
+		if resp.Error == nil || resp.Error.Code != protocol.ErrCodeInvalidParams {
+			t.Errorf("%s: got %s, want an invalid params error", peer, out.String())
+		}
+	}
+}
+
+func TestIsVPNPeer(t *testing.T) {
+	d := New(Config{Subnet: "10.8.0.0/24"})
+	d.peers["10.9.0.5"] = &Peer{VPNAddress: "10.9.0.5"}
+
+	for addr, want := range map[string]bool{
+		"10.8.0.7":     true,
+		"10.9.0.5":     true, // Connected
+		"10.9.0.6":     false,
+		"192.168.1.10": false,
+		"peer.local":   false,
+		"":             false,
+	} {
+		if got := d.isVPNPeer(addr); got != want {
+			t.Errorf("isVPNPeer(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package node
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+)
+
+// recoverCrash must be deferred directly at the top of Run and of every
+// long-lived goroutine: an unrecovered panic in any goroutine kills the
+// process without running deferred cleanup elsewhere, leaving the default
+// route pointing at a TUN device that no longer exists. It restores routing,
+// records a CRASH lifecycle event with the stack trace and re-panics, so the
+// process still dies with the original panic output.
+func (d *Daemon) recoverCrash() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	d.recordCrash(r, debug.Stack())
+	panic(r)
+}
+
+// recordCrash restores routing and writes the CRASH event. Only the first
+// panic is recorded; others racing it are already doomed.
+func (d *Daemon) recordCrash(r interface{}, stack []byte) {
+	d.crashOnce.Do(func() {
+		log.Printf("[node] PANIC: %v\n%s", r, stack)
+
+		routeRestored := false
+		if d.tun != nil && d.hasVPNRoutes() {
+			log.Printf("[node] Restoring network routes after panic...")
+			if err := d.tun.RestoreRouting(); err != nil {
+				log.Printf("[node] ERROR: Failed to restore routing: %v", err)
+				log.Printf("[node] Manual fix: sudo route delete default; sudo route add default <your-gateway>")
+			} else {
+				routeRestored = true
+				log.Printf("[node] Network routes restored successfully")
+			}
+		}
+
+		if d.store != nil {
+			reason := fmt.Sprintf("panic: %v\n%s", r, stack)
+			if err := d.store.WriteLifecycleEvent("CRASH", reason, d.Uptime().Seconds(), d.config.RouteAll, routeRestored, Version); err != nil {
+				log.Printf("[node] Failed to record crash: %v", err)
+			}
+		}
+	})
+}
@@ -3,13 +3,18 @@ package node
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -26,8 +31,20 @@ type Config struct {
 	ListenWS      string `yaml:"listen_ws"`
 	ListenControl string `yaml:"listen_control"`
 	VPNAddress    string `yaml:"vpn_address"`
+
+	// ListenControlVPN adds a second control listener on the VPN address
+	// (port of ListenControl) that only serves read-only methods, so peers
+	// can query status, logs and stats without the full API
+	ListenControlVPN bool `yaml:"listen_control_vpn"`
 	Subnet        string `yaml:"subnet"`
 
+	// Control socket tokens: when ControlToken is set every request must
+	// carry it or ReadonlyToken, which cannot change state (see
+	// readOnlyMethods). Queries to other nodes send ReadonlyToken, so
+	// ControlToken never leaves the node
+	ControlToken  string `yaml:"control_token"`
+	ReadonlyToken string `yaml:"readonly_token"`
+
 	// TLS configuration
 	UseTLS   bool   `yaml:"use_tls"`
 	CertFile string `yaml:"cert_file"`
@@ -37,20 +54,99 @@ type Config struct {
 	EncryptionKey []byte `yaml:"-"`
 	Encryption    bool   `yaml:"encryption"`
 
+	// Cipher: packet cipher to ask for, "aes-gcm" or "chacha20" ("" picks
+	// the faster one here, see tunnel.DefaultCipher). ChaCha20-Poly1305 is
+	// used if either end asks for it and both support it.
+	Cipher string `yaml:"cipher"`
+
 	// Server mode: if true, this node accepts connections and assigns IPs
 	// If false, this node connects to a server
 	ServerMode    bool   `yaml:"server_mode"`
 	ConnectTo     string `yaml:"connect_to"` // Server address to connect to (client mode)
 
+	// EnableNAT: masquerade VPN traffic leaving through the external
+	// interface so route-all clients reach the internet (server mode, Linux)
+	EnableNAT bool `yaml:"enable_nat"`
+
 	// RouteAll: if true, route all traffic through VPN (client mode)
 	RouteAll bool `yaml:"route_all"`
 
+	// RouteSubnets: CIDRs to route through VPN when RouteAll is off (split tunneling)
+	RouteSubnets []string `yaml:"route_subnets"`
+
+	// DNS: resolvers the OS uses while RouteAll is on, to prevent DNS leaks
+	// (client mode; empty = OS default on Linux, 1.1.1.1/8.8.8.8 on macOS)
+	DNS []string `yaml:"dns"`
+
+	// KillSwitch: when the tunnel drops with RouteAll on, block all non-VPN
+	// traffic (pf/nftables) instead of restoring direct routing, until the
+	// node reconnects or 'vpn disconnect' (client mode)
+	KillSwitch bool `yaml:"kill_switch"`
+
+	// AutoReconnect keeps retrying a lost server connection indefinitely
+	// instead of shutting down once the reconnect attempts run out (client
+	// mode, for unattended machines)
+	AutoReconnect bool `yaml:"auto_reconnect"`
+
 	// ReconnectCount tracks how many times we've reconnected this session
 	// Used for uptime statistics to detect excessive reconnections
 	ReconnectCount int `yaml:"-"`
 
 	// Data directory for SQLite storage
 	DataDir string `yaml:"data_dir"`
+
+	// Compression: offer/accept LZ4 packet compression (used only if both ends enable it)
+	Compression bool `yaml:"compression"`
+
+	// Transport: "tcp" (default) or "udp". A UDP server also accepts TCP
+	// clients; a UDP client falls back to TCP against older servers.
+	Transport string `yaml:"transport"`
+
+	// IPv6: give nodes an IPv6 ULA address (fd00::/64) alongside their IPv4
+	// one and route both (used only if both ends enable it)
+	IPv6 bool `yaml:"ipv6"`
+
+	// Retention overrides (0 = use persisted policy or store defaults)
+	LogsRetentionDays     int           `yaml:"logs_retention_days"`
+	MetricsRetentionHours int           `yaml:"metrics_retention_hours"` // Raw metrics
+	LogsRetention         time.Duration `yaml:"logs_retention"`          // Takes precedence over LogsRetentionDays
+
+	// MaxStorageMB: database size at which old logs are evicted (0 = store default, 50MB)
+	MaxStorageMB int `yaml:"max_storage_mb"`
+
+	// LogRateLimit: log entries stored per second for each component and
+	// level (0 = store default, 100; negative = no sampling)
+	LogRateLimit int `yaml:"log_rate_limit"`
+
+	// PeerTimeoutSeconds: server reaps clients that negotiated keepalive
+	// PINGs once they are silent for this long (0 = never)
+	PeerTimeoutSeconds int `yaml:"peer_timeout_seconds"`
+
+	// LogLevel: minimum level recorded (DEBUG, INFO, WARN, ERROR; "" = all)
+	LogLevel string `yaml:"log_level"`
+
+	// LogFormat: stdout log format, "text" (default) or "json" for log aggregators
+	LogFormat string `yaml:"log_format"`
+
+	// LogOutput: where log lines are echoed, "stdout" (default) or "stderr"
+	LogOutput string `yaml:"log_output"`
+
+	// Connection quality alerts: a peer link is DEGRADED when its average
+	// RTT or PING loss over recent PINGs crosses these (0 = no check)
+	RTTAlertMs   int     `yaml:"rtt_alert_ms"`
+	LossAlertPct float64 `yaml:"loss_alert_pct"`
+
+	// PeerRateLimits caps egress to individual peers (server mode):
+	// VPN IP -> Mbps. Packets over the limit are dropped.
+	PeerRateLimits map[string]float64 `yaml:"peer_rate_limits"`
+
+	// PeerBandwidthLimitBps caps every peer without an entry in
+	// PeerRateLimits, in bits per second (server mode; 0 = no cap)
+	PeerBandwidthLimitBps int64 `yaml:"peer_bandwidth_limit_bps"`
+
+	// MetricsPushURL: collector that current metrics are POSTed to as JSON
+	// every 15s ("" = off)
+	MetricsPushURL string `yaml:"metrics_push_url"`
 }
 
 // IsRoutingAllTraffic returns whether all traffic is being routed through VPN.
@@ -69,35 +165,78 @@ type Daemon struct {
 	// VPN listener (server mode)
 	vpnListener *tunnel.Listener
 
-	// VPN connection (client mode)
-	vpnConn *tunnel.Conn
+	// VPN connection (client mode). attemptReconnect swaps it while the
+	// forwarders, keepalives and control handlers read it
+	vpnConn atomic.Pointer[tunnel.Conn]
+
+	// killSwitchEngaged is set while the kill switch blocks traffic after
+	// the tunnel dropped; VPN routes are left in place meanwhile
+	killSwitchEngaged atomic.Bool
+
+	// natIface is the external interface NAT rules were added for (server
+	// mode, "" if NAT is off); keepNAT leaves them in place on shutdown
+	// for a deploy restart, whose new process re-applies them
+	natIface string
+	keepNAT  atomic.Bool
+
+	// deploying is set while a deploy pulls and rebuilds (one at a time);
+	// deployErr is why the last one failed (nil if it succeeded)
+	deploying atomic.Bool
+	deployErr atomic.Pointer[string]
 
 	// Peer connections (server mode)
 	peerConns   map[string]*tunnel.Conn // key: VPN IP
 	peerConnsMu sync.RWMutex
 
+	// Bytes per remote host (server mode), flushed by trafficLoop
+	traffic *trafficCounter
+
+	// Egress rate limits (server mode), key: VPN IP; replaced as a whole
+	// by 'vpn config set peer_rate_limits=...'
+	rateLimiters atomic.Pointer[map[string]*rateLimiter]
+
 	// Statistics
-	mu       sync.RWMutex
-	bytesIn  uint64
-	bytesOut uint64
-	peers    map[string]*Peer
+	mu         sync.RWMutex
+	bytesIn    uint64
+	bytesOut   uint64
+	packetLoss float64 // Percent over the last packetLossInterval
+	peers      map[string]*Peer
 
 	// Network peers (client mode - received from server via PEER_LIST)
 	networkPeers   []protocol.PeerListEntry
 	networkPeersMu sync.RWMutex
 
+	// Peer list changes, for peers_stream
+	peersHub *peersHub
+
 	// IP assignment (server mode)
-	nextIP       int               // Next IP to assign (starts at 2 for 10.8.0.2)
-	hostnameToIP map[string]string // Persistent IP assignment
+	subnet       *net.IPNet        // VPN subnet (Config.Subnet)
+	nextIP       int               // Host number of the next IP to assign (starts at 2 for 10.8.0.2)
+	hostnameToIP map[string]string // IP assignment cache (persisted in store)
+
+	// Clients that were routing when the server last stopped (server mode),
+	// consumed as each one reconnects and is sent a RECONNECT_INVITE
+	reconnectInvites   map[string]bool
+	reconnectInvitesMu sync.Mutex
 
 	// Control socket
 	controlListener net.Listener
+	controlLimiter  *controlLimiter
+	controlConns    atomic.Int32 // Open control connections
+
+	// Read-only control socket on the VPN address (Config.ListenControlVPN),
+	// rebound when the address changes
+	vpnControlListener   net.Listener
+	vpnControlListenerMu sync.Mutex
 
 	// Storage and metrics
 	store            *store.Store
 	metricsCollector *store.Collector
 	standardMetrics  *store.StandardMetrics
 	bandwidthTracker *store.BandwidthTracker
+	alerts           *alertEngine
+	logWriter        *store.LogWriter
+	metricsHub       *metricsHub // Live metrics for stats_watch
 
 	// Network topology
 	topology *NetworkTopology
@@ -105,6 +244,19 @@ type Daemon struct {
 	// Connection failure detection (client mode)
 	connFailed     chan struct{} // Signals that VPN connection has failed
 	connFailedOnce sync.Once     // Ensures we only signal failure once
+	missedPongs    atomic.Int32  // PINGs sent since the last PONG
+	pongSeen       atomic.Bool   // Server answered a PING on this connection
+
+	// Connection quality per peer VPN IP, judged by qualityWatchdog
+	linkQuality   map[string]*linkQuality
+	linkQualityMu sync.Mutex
+
+	// Server identity from SERVER_INFO (client mode, nil until received)
+	serverInfo atomic.Pointer[protocol.ServerInfo]
+
+	// Benchmark responder (see bench.go), nil when not running
+	benchConn *net.UDPConn
+	benchMu   sync.Mutex
 
 	// Server restart notification (client mode)
 	serverRestarting bool       // Set to true when server sends RESTARTING message
@@ -113,11 +265,13 @@ type Daemon struct {
 	// Geolocation (looked up before VPN connects)
 	ourGeo      *protocol.GeoLocation // Our geolocation (real, before VPN)
 	ourPublicIP string                // Our public IP (real, before VPN)
+	geoResolver *geo.Resolver         // Peer lookups, cached in the store
 
 	// Shutdown
 	ctx          context.Context
 	cancel       context.CancelFunc
 	shutdownOnce sync.Once // Ensures shutdown only runs once
+	crashOnce    sync.Once // Ensures only the first panic is recorded
 }
 
 // Peer represents a connected peer node.
@@ -127,6 +281,7 @@ type Peer struct {
 	PublicAddr string
 	OS         string
 	Connected  time.Time
+	LastSeen   time.Time // Last packet or ping (server mode)
 	BytesIn    uint64
 	BytesOut   uint64
 	Geo        *protocol.GeoLocation // Peer's geolocation (from handshake)
@@ -134,21 +289,38 @@ type Peer struct {
 
 // New creates a new Daemon instance.
 func New(cfg Config) *Daemon {
-	ctx, cancel := context.WithCancel(context.Background())
-	return &Daemon{
-		config:       cfg,
-		startTime:    time.Now(),
-		peers:        make(map[string]*Peer),
-		peerConns:    make(map[string]*tunnel.Conn),
-		hostnameToIP: make(map[string]string),
-		nextIP:       2, // Start from 10.8.0.2
-		ctx:          ctx,
-		cancel:       cancel,
+	subnet, err := tunnel.ParseSubnet(cfg.Subnet)
+	if err != nil {
+		log.Printf("[node] Warning: invalid subnet %q: %v (using %s)", cfg.Subnet, err, tunnel.DefaultSubnet)
+		subnet, _ = tunnel.ParseSubnet(tunnel.DefaultSubnet)
 	}
+	cfg.Subnet = subnet.String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Daemon{
+		config:         cfg,
+		startTime:      time.Now(),
+		peers:          make(map[string]*Peer),
+		peerConns:      make(map[string]*tunnel.Conn),
+		hostnameToIP:   make(map[string]string),
+		linkQuality:    make(map[string]*linkQuality),
+		traffic:        newTrafficCounter(),
+		peersHub:       newPeersHub(),
+		controlLimiter: newControlLimiter(),
+		subnet:         subnet,
+		nextIP:         2, // Start from 10.8.0.2
+		geoResolver:    geo.NewResolver(nil),
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+	d.setRateLimits(cfg.PeerRateLimits)
+	return d
 }
 
 // Run starts the daemon and blocks until shutdown.
 func (d *Daemon) Run() error {
+	defer d.recoverCrash()
+
 	log.Printf("[node] Starting VPN node: %s", d.config.NodeName)
 	log.Printf("[node] VPN Address: %s", d.config.VPNAddress)
 	log.Printf("[node] Mode: %s", map[bool]string{true: "SERVER", false: "CLIENT"}[d.config.ServerMode])
@@ -160,6 +332,7 @@ func (d *Daemon) Run() error {
 
 	// Initialize network topology tracker
 	d.topology = NewNetworkTopology(d.config.VPNAddress, d.config.NodeName)
+	d.topology.SetOurInfo(d.config.NodeName, d.config.VPNAddress, "", runtime.GOOS, Version)
 
 	// Initialize storage
 	if err := d.initStorage(); err != nil {
@@ -175,7 +348,7 @@ func (d *Daemon) Run() error {
 	if err := d.startControlServer(); err != nil {
 		return fmt.Errorf("failed to start control server: %w", err)
 	}
-	log.Printf("[node] Control socket listening on %s", d.config.ListenControl)
+	log.Printf("[node] Control socket listening on %s", d.controlAddr())
 
 	if d.config.ServerMode {
 		// Server mode: create TUN, listen for connections
@@ -196,6 +369,12 @@ func (d *Daemon) Run() error {
 
 	// Start metrics update goroutine
 	go d.metricsLoop()
+	if d.config.MetricsPushURL != "" {
+		go d.metricsPushLoop()
+	}
+
+	// Retry geolocation that failed at connect time (e.g. rate limited)
+	go d.geoRefreshLoop()
 
 	log.Printf("[node] Node is ready")
 
@@ -215,6 +394,10 @@ func (d *Daemon) Run() error {
 
 // startServer initializes server mode.
 func (d *Daemon) startServer() error {
+	// Resume IP allocation where the previous run left off
+	d.restoreNextIP()
+	d.loadReconnectInvites()
+
 	// Lookup our geolocation (server's location)
 	log.Printf("[node] Looking up server geolocation...")
 	ourGeo, ourPublicIP, err := geo.LookupSelf()
@@ -235,12 +418,29 @@ func (d *Daemon) startServer() error {
 	tunCfg := tunnel.Config{
 		LocalIP:   d.config.VPNAddress,
 		GatewayIP: d.config.VPNAddress, // Server is its own gateway
+		Subnet:    d.config.Subnet,
 	}
 	tun, err := tunnel.New(tunCfg)
 	if err != nil {
 		return fmt.Errorf("failed to create TUN: %w", err)
 	}
 	d.tun = tun
+	d.recordMTU()
+
+	if d.config.IPv6 {
+		if err := d.tun.AddIPv6(tunnel.DefaultServerIP6); err != nil {
+			log.Printf("[node] Warning: IPv6 disabled: %v", err)
+			d.config.IPv6 = false
+		}
+	}
+
+	if d.config.EnableNAT {
+		if err := d.enableNAT(); err != nil {
+			log.Printf("[node] Warning: NAT not enabled: %v (route-all clients will have no internet)", err)
+		}
+	}
+
+	d.startVPNControlServer()
 
 	// Start VPN listener
 	listenCfg := tunnel.ListenConfig{
@@ -250,6 +450,9 @@ func (d *Daemon) startServer() error {
 		KeyFile:    d.config.KeyFile,
 		Key:        d.config.EncryptionKey,
 		Encryption: d.config.Encryption,
+
+		Compression: d.config.Compression,
+		Transport:   d.config.Transport,
 	}
 	listener, err := tunnel.Listen(listenCfg)
 	if err != nil {
@@ -265,6 +468,11 @@ func (d *Daemon) startServer() error {
 
 	// Route TUN packets to peers
 	go d.routeTUNPackets()
+	go d.trafficLoop()
+
+	// Measure RTT to each client
+	go d.pingPeersLoop()
+	go d.qualityWatchdog()
 
 	return nil
 }
@@ -316,6 +524,9 @@ func (d *Daemon) startClient() error {
 			UseTLS:     d.config.UseTLS,
 			Key:        d.config.EncryptionKey,
 			Encryption: d.config.Encryption,
+
+			Compression: d.config.Compression,
+			Transport:   d.config.Transport,
 		}
 		conn, err := tunnel.Dial(dialCfg)
 		if err != nil {
@@ -332,52 +543,83 @@ func (d *Daemon) startClient() error {
 		hostname, _ := os.Hostname()
 		peerInfo := protocol.PeerInfo{
 			Hostname: hostname,
-			OS:       "darwin", // TODO: detect OS
+			OS:       runtime.GOOS,
 			Version:  Version,
 			Geo:      d.ourGeo,
 			PublicIP: d.ourPublicIP,
 			RouteAll: d.config.RouteAll, // Connection Intent Protocol: tell server if routing is enabled
+			Cipher:   d.preferredCipher(),
 		}
-		if err := protocol.WriteHandshake(conn.NetConn, d.config.Encryption, peerInfo); err != nil {
+		if err := protocol.WriteHandshake(conn.NetConn, d.handshakeFlags(), peerInfo); err != nil {
 			conn.Close()
 			log.Printf("[node] Handshake write failed (attempt %d/%d): %v", attempt, maxRetries, err)
 			continue
 		}
 
 		// Read assigned IP
-		assignedIP, err := protocol.ReadAssignedIP(conn.NetConn)
+		assignedIP, serverMTU, cipher, err := protocol.ReadAssignedIP(conn.NetConn)
 		if err != nil {
 			conn.Close()
 			log.Printf("[node] Handshake read failed (attempt %d/%d): %v", attempt, maxRetries, err)
 			continue
 		}
+		if err := conn.SetCipher(cipher); err != nil {
+			conn.Close()
+			log.Printf("[node] Handshake failed (attempt %d/%d): %v", attempt, maxRetries, err)
+			continue
+		}
+		log.Printf("[node] Packet cipher: %s", conn.CipherName())
 
 		// Clear deadline after successful handshake
 		if err := conn.NetConn.SetDeadline(time.Time{}); err != nil {
 			log.Printf("[node] Warning: failed to clear deadline: %v", err)
 		}
 
-		d.vpnConn = conn
+		d.vpnConn.Store(conn)
 		d.config.VPNAddress = assignedIP
 		log.Printf("[node] Connected to server successfully (attempt %d)", attempt)
-		return d.completeClientSetup(assignedIP)
+		return d.completeClientSetup(assignedIP, serverMTU)
 	}
 
 	return fmt.Errorf("failed to connect after %d attempts", maxRetries)
 }
 
+// addServerPeer adds the server to the topology as a direct peer, named from
+// its SERVER_INFO if received, otherwise from its address.
+func (d *Daemon) addServerPeer() {
+	node := &NetworkNode{
+		Name:        "server",
+		VPNAddress:  d.serverIP(),
+		PublicAddr:  d.config.ConnectTo,
+		IsDirect:    true,
+		ConnectedAt: time.Now(),
+	}
+	if info := d.serverInfo.Load(); info != nil {
+		node.Name = info.Name
+		node.OS = info.OS
+		node.Version = info.Version
+	} else if host, _, err := net.SplitHostPort(d.config.ConnectTo); err == nil {
+		// Older servers don't send SERVER_INFO
+		node.Name = host
+	}
+	d.topology.AddDirectPeer(node)
+}
+
 // completeClientSetup finishes client initialization after handshake.
-func (d *Daemon) completeClientSetup(assignedIP string) error {
+// serverMTU is the server's TUN MTU (0 if it did not send one).
+func (d *Daemon) completeClientSetup(assignedIP string, serverMTU int) error {
 	log.Printf("[node] Assigned VPN IP: %s", assignedIP)
 
 	// Create TUN device with assigned IP
 	tunCfg := tunnel.Config{
 		LocalIP:   assignedIP,
-		GatewayIP: tunnel.DefaultServerIP,
+		GatewayIP: d.serverIP(),
+		Subnet:    d.config.Subnet,
+		DNS:       d.config.DNS,
 	}
 	tun, err := tunnel.New(tunCfg)
 	if err != nil {
-		d.vpnConn.Close()
+		d.vpnConn.Load().Close()
 		return fmt.Errorf("failed to create TUN: %w", err)
 	}
 	d.tun = tun
@@ -394,29 +636,25 @@ func (d *Daemon) completeClientSetup(assignedIP string) error {
 		} else {
 			log.Printf("[node] All traffic now routed through VPN")
 		}
+	} else if len(d.config.RouteSubnets) > 0 {
+		d.routeSubnets()
 	}
 
+	// A kill switch left by a previous run blocks the new TUN device
+	if err := tunnel.DisableKillSwitch(); err != nil {
+		log.Printf("[node] Warning: %v", err)
+	}
+
+	d.startVPNControlServer()
+
 	// Update topology with ourselves and the server
-	d.topology.SetOurInfo(d.config.NodeName, assignedIP, "", "darwin", Version)
+	d.topology.SetOurInfo(d.config.NodeName, assignedIP, "", runtime.GOOS, Version)
 	if d.ourGeo != nil {
 		d.topology.SetOurGeo(d.ourGeo)
 	}
 
-	// Add server as direct peer
-	// TODO: Server should send its name in the handshake response
-	// For now, derive name from server address or use "server"
-	serverName := "server"
-	if host, _, err := net.SplitHostPort(d.config.ConnectTo); err == nil {
-		// Use IP as name for now - will be replaced when server sends its name
-		serverName = host
-	}
-	d.topology.AddDirectPeer(&NetworkNode{
-		Name:       serverName,
-		VPNAddress: tunnel.DefaultServerIP, // 10.8.0.1
-		PublicAddr: d.config.ConnectTo,
-		IsDirect:   true,
-		ConnectedAt: time.Now(),
-	})
+	// Add server as direct peer (renamed when its SERVER_INFO arrives)
+	d.addServerPeer()
 
 	// Start packet forwarding
 	go d.forwardTUNToServer()
@@ -425,11 +663,430 @@ func (d *Daemon) completeClientSetup(assignedIP string) error {
 	// Start connection failure monitor (restores routes if connection drops)
 	go d.monitorConnectionFailure()
 
+	// Detect dead tunnels and keep the server from reaping us while idle
+	// (one loop for the whole session; it survives reconnects)
+	go d.pingLoop()
+	go d.qualityWatchdog()
+
+	d.negotiateMTU(serverMTU)
+
 	return nil
 }
 
+// negotiateMTU sets the TUN MTU to the server's if that is below
+// tunnel.MTU, then probes the path MTU to the server's public IP (outside
+// the tunnel) in the background and lowers the TUN MTU further if
+// encapsulated packets would not fit (client mode). Run on every
+// (re)connect, since the path may have changed.
+func (d *Daemon) negotiateMTU(serverMTU int) {
+	mtu := tunnel.MTU
+	if serverMTU > 0 && serverMTU < mtu {
+		log.Printf("[node] Server MTU is %d", serverMTU)
+		mtu = serverMTU
+	}
+	d.setMTU(mtu)
+
+	go func() {
+		defer d.recoverCrash()
+
+		serverIP := d.serverPublicIP()
+		pathMTU, err := tunnel.ProbePathMTU(serverIP, d.tun.MTU()+tunnel.TunnelOverhead)
+		if err != nil {
+			log.Printf("[node] Path MTU probe skipped: %v (keeping MTU %d)", err, d.tun.MTU())
+			return
+		}
+		mtu := tunnel.TunnelMTU(pathMTU)
+		log.Printf("[node] Path MTU to %s: %d bytes (TUN MTU %d)", serverIP, pathMTU, mtu)
+		if mtu < d.tun.MTU() {
+			d.setMTU(mtu)
+		}
+	}()
+}
+
+// setMTU changes the TUN MTU and records it as the vpn.mtu_bytes metric.
+func (d *Daemon) setMTU(mtu int) {
+	if err := d.tun.SetMTU(mtu); err != nil {
+		log.Printf("[node] Warning: %v", err)
+		return
+	}
+	d.recordMTU()
+}
+
+// recordMTU records the TUN MTU as the vpn.mtu_bytes metric.
+func (d *Daemon) recordMTU() {
+	if d.standardMetrics != nil && d.tun != nil {
+		d.standardMetrics.SetMTU(d.tun.MTU())
+	}
+}
+
+// pingPeersLoop PINGs every connected client periodically (server mode).
+// Their PONGs are turned into RTT measurements by recordPong.
+func (d *Daemon) pingPeersLoop() {
+	defer d.recoverCrash()
+
+	ticker := time.NewTicker(protocol.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		sentAt := time.Now()
+		msg := protocol.MakePingMessage(sentAt)
+
+		// Write outside the lock: a stuck peer socket must not block
+		// unregisterPeer or routeTUNPackets
+		type target struct {
+			vpnIP string
+			conn  *tunnel.Conn
+		}
+		d.peerConnsMu.RLock()
+		targets := make([]target, 0, len(d.peerConns))
+		for vpnIP, conn := range d.peerConns {
+			targets = append(targets, target{vpnIP, conn})
+		}
+		d.peerConnsMu.RUnlock()
+
+		for _, t := range targets {
+			if err := t.conn.WritePacket(msg); err != nil {
+				log.Printf("[vpn] Failed to send PING to %s: %v", t.vpnIP, err)
+				continue
+			}
+			d.recordPing(t.vpnIP, sentAt)
+		}
+	}
+}
+
+// recordPong records the round-trip time from a PONG as the peer's latency:
+// in the topology, as a peer.rtt_ms metric and, for the client's link to
+// the server, as vpn.latency_ms.
+func (d *Daemon) recordPong(vpnIP, cmd string) {
+	sentAt, err := protocol.ParsePongMessage(cmd)
+	if err != nil {
+		log.Printf("[vpn] Bad PONG from %s: %v", vpnIP, err)
+		return
+	}
+	rttMs := float64(time.Since(sentAt).Microseconds()) / 1000
+	d.recordPingAnswer(vpnIP, sentAt, rttMs)
+
+	if d.topology != nil {
+		d.topology.UpdatePeerLatency(vpnIP, rttMs)
+	}
+
+	if d.store != nil {
+		if err := d.store.WriteMetric("peer.rtt_ms", rttMs, d.peerTags(vpnIP)); err != nil {
+			log.Printf("[store] Failed to write peer.rtt_ms: %v", err)
+		}
+	}
+
+	if !d.config.ServerMode && d.standardMetrics != nil {
+		d.standardMetrics.SetLatency(rttMs)
+	}
+}
+
+// handshakeFlags returns the capabilities we announce in the client handshake.
+func (d *Daemon) handshakeFlags() protocol.HandshakeFlags {
+	var flags protocol.HandshakeFlags
+	if d.config.Encryption {
+		flags |= protocol.HandshakeEncryption
+	}
+	if d.config.Compression {
+		flags |= protocol.HandshakeCompression
+	}
+	if d.config.IPv6 {
+		flags |= protocol.HandshakeIPv6
+	}
+	flags |= protocol.HandshakeSequence
+	flags |= protocol.HandshakeMTU
+	if d.config.Transport == tunnel.TransportUDP {
+		flags |= protocol.HandshakeUDP
+	}
+	if d.config.Encryption {
+		flags |= protocol.HandshakeCipher
+	}
+	flags |= protocol.HandshakePing
+	return flags
+}
+
+// preferredCipher returns the packet cipher this node asks for: --cipher,
+// or the faster one on this machine.
+func (d *Daemon) preferredCipher() string {
+	if d.config.Cipher != "" {
+		return d.config.Cipher
+	}
+	return tunnel.DefaultCipher()
+}
+
+// negotiateCipher picks the packet cipher for a client that sent
+// HandshakeCipher: ChaCha20-Poly1305 if either end prefers it (it is fast
+// everywhere, AES-GCM only with hardware support), AES-256-GCM otherwise.
+func (d *Daemon) negotiateCipher(clientCipher string) string {
+	if clientCipher == tunnel.CipherChaCha20 || d.preferredCipher() == tunnel.CipherChaCha20 {
+		return tunnel.CipherChaCha20
+	}
+	return tunnel.CipherAESGCM
+}
+
+// pingLoop PINGs the server periodically and signals a connection failure
+// after MaxMissedPongs consecutive PINGs go unanswered (client mode).
+// Servers that predate PING never answer, so detection only starts once the
+// server has sent a PONG on the current connection.
+func (d *Daemon) pingLoop() {
+	defer d.recoverCrash()
+
+	ticker := time.NewTicker(protocol.PingInterval)
+	defer ticker.Stop()
+
+	var lastConn *tunnel.Conn
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// May be nil during reconnect
+		conn := d.vpnConn.Load()
+		if conn != lastConn {
+			lastConn = conn
+			d.missedPongs.Store(0)
+			d.pongSeen.Store(false)
+		}
+		if conn == nil {
+			continue
+		}
+
+		if missed := d.missedPongs.Load(); missed >= protocol.MaxMissedPongs && d.pongSeen.Load() {
+			log.Printf("[vpn] No PONG for %d pings, connection to server is dead", missed)
+			d.missedPongs.Store(0)
+			d.signalConnectionFailure()
+			continue
+		}
+
+		sentAt := time.Now()
+		if err := conn.WritePacket(protocol.MakePingMessage(sentAt)); err != nil {
+			log.Printf("[vpn] Failed to send ping: %v", err)
+		} else {
+			d.recordPing(d.serverIP(), sentAt)
+		}
+		d.missedPongs.Add(1)
+	}
+}
+
+const (
+	// qualityWindow is how many recent PINGs per peer the watchdog judges.
+	qualityWindow = 8
+
+	// qualityMinSamples is how many settled PINGs a peer needs to be judged.
+	qualityMinSamples = 4
+)
+
+// pingSample is one PING sent to a peer and its PONG, if any.
+type pingSample struct {
+	sentAt   int64 // Unix nanoseconds, as echoed in the PONG
+	answered bool
+	rttMs    float64
+}
+
+// linkQuality is the recent PING history of one peer.
+type linkQuality struct {
+	samples  []pingSample // Oldest first
+	answered bool         // Ever answered; peers that predate PING never do
+	degraded bool
+}
+
+// recordPing notes a PING sent to a peer.
+func (d *Daemon) recordPing(vpnIP string, sentAt time.Time) {
+	d.linkQualityMu.Lock()
+	defer d.linkQualityMu.Unlock()
+
+	q := d.linkQuality[vpnIP]
+	if q == nil {
+		q = &linkQuality{}
+		d.linkQuality[vpnIP] = q
+	}
+	q.samples = append(q.samples, pingSample{sentAt: sentAt.UnixNano()})
+	// Keep the window plus the PING still waiting for its PONG
+	if len(q.samples) > qualityWindow+1 {
+		q.samples = q.samples[len(q.samples)-qualityWindow-1:]
+	}
+}
+
+// recordPingAnswer matches a PONG to the PING it answers.
+func (d *Daemon) recordPingAnswer(vpnIP string, sentAt time.Time, rttMs float64) {
+	d.linkQualityMu.Lock()
+	defer d.linkQualityMu.Unlock()
+
+	q := d.linkQuality[vpnIP]
+	if q == nil {
+		return
+	}
+	for i := range q.samples {
+		if q.samples[i].sentAt == sentAt.UnixNano() {
+			q.samples[i].answered = true
+			q.samples[i].rttMs = rttMs
+			q.answered = true
+			return
+		}
+	}
+}
+
+// qualityChange is a peer link entering or leaving the DEGRADED state.
+type qualityChange struct {
+	vpnIP    string
+	degraded bool
+	lossy    bool // Degraded by loss (ERROR) rather than RTT alone (WARN)
+	reason   string
+}
+
+// qualityWatchdog checks every peer link once per PING interval and logs,
+// and records a DEGRADED lifecycle event, when RTT or PING loss crosses the
+// configured thresholds; it logs again when the link recovers.
+func (d *Daemon) qualityWatchdog() {
+	defer d.recoverCrash()
+
+	if d.config.RTTAlertMs <= 0 && d.config.LossAlertPct <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(protocol.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, c := range d.checkLinkQuality(time.Now()) {
+			name := d.peerDisplayName(c.vpnIP)
+			if !c.degraded {
+				log.Printf("[quality] Link to %s recovered: %s", name, c.reason)
+				if d.store != nil {
+					d.store.WriteLifecycleEvent("RECOVERED", fmt.Sprintf("Link to %s recovered: %s", name, c.reason),
+						d.Uptime().Seconds(), d.config.RouteAll, false, Version)
+				}
+				continue
+			}
+
+			if c.lossy {
+				log.Printf("[quality] ERROR: link to %s degraded: %s", name, c.reason)
+			} else {
+				log.Printf("[quality] WARN: link to %s degraded: %s", name, c.reason)
+			}
+			if d.store != nil {
+				d.store.WriteLifecycleEvent("DEGRADED", fmt.Sprintf("Link to %s degraded: %s", name, c.reason),
+					d.Uptime().Seconds(), d.config.RouteAll, false, Version)
+			}
+		}
+	}
+}
+
+// checkLinkQuality judges each peer's settled PINGs (sent at least a PING
+// interval ago) and returns the links whose state changed. Peers no longer
+// being PINGed are forgotten.
+func (d *Daemon) checkLinkQuality(now time.Time) []qualityChange {
+	d.linkQualityMu.Lock()
+	defer d.linkQualityMu.Unlock()
+
+	settledBefore := now.Add(-protocol.PingInterval).UnixNano()
+	goneBefore := now.Add(-3 * protocol.PingInterval).UnixNano()
+
+	var changes []qualityChange
+	for vpnIP, q := range d.linkQuality {
+		if len(q.samples) == 0 || q.samples[len(q.samples)-1].sentAt < goneBefore {
+			delete(d.linkQuality, vpnIP)
+			continue
+		}
+		if !q.answered {
+			continue
+		}
+
+		var settled, answered int
+		var rttTotal float64
+		for i := len(q.samples) - 1; i >= 0 && settled < qualityWindow; i-- {
+			s := q.samples[i]
+			if s.sentAt > settledBefore {
+				continue
+			}
+			settled++
+			if s.answered {
+				answered++
+				rttTotal += s.rttMs
+			}
+		}
+		if settled < qualityMinSamples {
+			continue
+		}
+
+		lossPct := float64(settled-answered) * 100 / float64(settled)
+		var avgRTT float64
+		if answered > 0 {
+			avgRTT = rttTotal / float64(answered)
+		}
+
+		lossy := d.config.LossAlertPct > 0 && lossPct >= d.config.LossAlertPct
+		slow := d.config.RTTAlertMs > 0 && avgRTT > float64(d.config.RTTAlertMs)
+		degraded := lossy || slow
+		if degraded == q.degraded {
+			continue
+		}
+		q.degraded = degraded
+
+		reason := fmt.Sprintf("avg RTT %.0f ms, %.0f%% loss over %d pings", avgRTT, lossPct, settled)
+		switch {
+		case lossy:
+			reason += fmt.Sprintf(" (loss threshold %.0f%%)", d.config.LossAlertPct)
+		case slow:
+			reason += fmt.Sprintf(" (RTT threshold %d ms)", d.config.RTTAlertMs)
+		}
+		changes = append(changes, qualityChange{vpnIP: vpnIP, degraded: degraded, lossy: lossy, reason: reason})
+	}
+	return changes
+}
+
+// peerDisplayName returns "name (vpnIP)" for a connected peer, else vpnIP.
+func (d *Daemon) peerDisplayName(vpnIP string) string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if p, ok := d.peers[vpnIP]; ok && p.Name != "" {
+		return fmt.Sprintf("%s (%s)", p.Name, vpnIP)
+	}
+	return vpnIP
+}
+
+// peerTags returns the tags of a per-peer metric, {"peer":"<name>"}. The
+// name comes from the connected clients (server) or the peer list sent by
+// the server (client); while it is unknown the peer is named by vpnIP.
+func (d *Daemon) peerTags(vpnIP string) string {
+	name := vpnIP
+	d.mu.RLock()
+	if p, ok := d.peers[vpnIP]; ok && p.Name != "" {
+		name = p.Name
+	}
+	d.mu.RUnlock()
+
+	if name == vpnIP {
+		d.networkPeersMu.RLock()
+		for _, p := range d.networkPeers {
+			if p.VPNAddress == vpnIP && p.Name != "" {
+				name = p.Name
+				break
+			}
+		}
+		d.networkPeersMu.RUnlock()
+	}
+	return store.FormatTags(map[string]string{"peer": name})
+}
+
 // acceptVPNConnections accepts incoming VPN connections (server mode).
 func (d *Daemon) acceptVPNConnections() {
+	defer d.recoverCrash()
+
 	for {
 		conn, err := d.vpnListener.Accept()
 		if err != nil {
@@ -447,11 +1104,13 @@ func (d *Daemon) acceptVPNConnections() {
 
 // handleVPNClient handles a connected VPN client (server mode).
 func (d *Daemon) handleVPNClient(conn *tunnel.Conn) {
+	defer d.recoverCrash()
+
 	remoteAddr := conn.RemoteAddr()
 	log.Printf("[vpn] New client connection from %s", remoteAddr)
 
 	// Read handshake
-	encryption, peerInfo, err := protocol.ReadHandshake(conn.NetConn)
+	flags, peerInfo, err := protocol.ReadHandshake(conn.NetConn)
 	if err != nil {
 		log.Printf("[vpn] Handshake failed from %s: %v", remoteAddr, err)
 		conn.Close()
@@ -467,21 +1126,82 @@ func (d *Daemon) handleVPNClient(conn *tunnel.Conn) {
 	// Assign IP (using public IP for stable tracking across hostname changes)
 	vpnIP := d.assignIP(peerInfo.Hostname, publicIP)
 
-	// Send assigned IP
-	if err := protocol.WriteAssignedIP(conn.NetConn, vpnIP); err != nil {
-		log.Printf("[vpn] Failed to send IP to %s: %v", remoteAddr, err)
+	// Send assigned IP, with our MTU and the packet cipher for clients that
+	// can read them
+	mtu := 0
+	if flags.Has(protocol.HandshakeMTU) && d.tun != nil {
+		mtu = d.tun.MTU()
+	}
+	cipher := ""
+	if flags.Has(protocol.HandshakeCipher|protocol.HandshakeEncryption) && d.config.Encryption {
+		cipher = d.negotiateCipher(peerInfo.Cipher)
+	}
+	if err := protocol.WriteAssignedIP(conn.NetConn, vpnIP, mtu, cipher); err != nil {
+		d.peerLogf(vpnIP, "[vpn] Failed to send IP to %s: %v", remoteAddr, err)
 		conn.Close()
 		return
 	}
+	if err := conn.SetCipher(cipher); err != nil {
+		d.peerLogf(vpnIP, "[vpn] Failed to switch %s to %s: %v", remoteAddr, cipher, err)
+		conn.Close()
+		return
+	}
+
+	// Tell the client who we are
+	serverInfo := protocol.ServerInfo{
+		Name:    d.config.NodeName,
+		OS:      runtime.GOOS,
+		Version: Version,
+	}
+	if err := conn.WritePacket(protocol.MakeServerInfoMessage(serverInfo)); err != nil {
+		d.peerLogf(vpnIP, "[vpn] Failed to send SERVER_INFO to %s: %v", remoteAddr, err)
+	}
+
+	// Accept compression if both ends support it (sent before any compressed packet)
+	if flags.Has(protocol.HandshakeCompression) && conn.CompressionCapable() {
+		if err := conn.WritePacket(protocol.MakeCompressionMessage()); err != nil {
+			d.peerLogf(vpnIP, "[vpn] Failed to send COMPRESSION to %s: %v", remoteAddr, err)
+		} else {
+			conn.SetCompression(true)
+		}
+	}
+
+	// Give the client its IPv6 address if both ends run dual-stack
+	if flags.Has(protocol.HandshakeIPv6) && d.config.IPv6 {
+		if addr := tunnel.IPv6For(vpnIP, d.subnet); addr != "" {
+			if err := conn.WritePacket(protocol.MakeIPv6Message(addr)); err != nil {
+				d.peerLogf(vpnIP, "[vpn] Failed to send IPV6 to %s: %v", remoteAddr, err)
+			}
+		}
+	}
+
+	// Number our packets so the client can measure loss; it answers with
+	// its own SEQUENCE once it is reading numbered frames
+	if flags.Has(protocol.HandshakeSequence) {
+		if err := conn.StartSequencing(protocol.MakeSequenceMessage()); err != nil {
+			d.peerLogf(vpnIP, "[vpn] Failed to send SEQUENCE to %s: %v", remoteAddr, err)
+		}
+	}
+
+	// Move the client's IP packets to UDP if it asked and we listen on UDP
+	if flags.Has(protocol.HandshakeUDP) && d.vpnListener.Transport() == tunnel.TransportUDP {
+		port, session, key, err := d.vpnListener.OfferUDP(conn)
+		if err == nil {
+			err = conn.WritePacket(protocol.MakeUDPMessage(protocol.UDPOffer{Port: port, Session: session, Key: key}))
+		}
+		if err != nil {
+			d.peerLogf(vpnIP, "[vpn] Failed to offer UDP to %s: %v", remoteAddr, err)
+		}
+	}
 
 	// If peer didn't send geo, try to lookup from their public IP
 	peerGeo := peerInfo.Geo
 	if peerGeo == nil {
 		// Extract IP from remote address (host:port)
 		if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
-			if lookedUp, err := geo.LookupIP(host); err == nil {
+			if lookedUp, err := d.geoResolver.Lookup(host); err == nil {
 				peerGeo = lookedUp
-				log.Printf("[vpn] Looked up geo for %s: %s, %s", host, lookedUp.City, lookedUp.Country)
+				d.peerLogf(vpnIP, "[vpn] Looked up geo for %s: %s, %s", host, lookedUp.City, lookedUp.Country)
 			}
 		}
 	}
@@ -494,6 +1214,7 @@ func (d *Daemon) handleVPNClient(conn *tunnel.Conn) {
 		PublicAddr: remoteAddr,
 		OS:         peerInfo.OS,
 		Connected:  time.Now(),
+		LastSeen:   time.Now(),
 		Geo:        peerGeo,
 	}
 	d.mu.Unlock()
@@ -502,8 +1223,8 @@ func (d *Daemon) handleVPNClient(conn *tunnel.Conn) {
 	d.peerConns[vpnIP] = conn
 	d.peerConnsMu.Unlock()
 
-	log.Printf("[vpn] Client registered: %s (%s) -> %s (encryption: %v)",
-		peerInfo.Hostname, peerInfo.OS, vpnIP, encryption)
+	d.peerLogf(vpnIP, "[vpn] Client registered: %s (%s) -> %s (encryption: %v, cipher: %s, compression: %v, transport: %s)",
+		peerInfo.Hostname, peerInfo.OS, vpnIP, flags.Has(protocol.HandshakeEncryption), conn.CipherName(), conn.CompressionActive(), conn.Transport())
 
 	// Add peer to topology
 	if d.topology != nil {
@@ -529,43 +1250,80 @@ func (d *Daemon) handleVPNClient(conn *tunnel.Conn) {
 
 		// Record current connection state
 		if err := d.store.SetClientConnected(vpnIP, peerInfo.Hostname, peerInfo.RouteAll); err != nil {
-			log.Printf("[vpn] Failed to record client connection: %v", err)
+			d.peerLogf(vpnIP, "[vpn] Failed to record client connection: %v", err)
 		}
 
 		// Send RECONNECT_INVITE if:
 		// 1. Client previously had routing enabled (state was connected_routing)
 		// 2. Client did NOT intentionally disconnect
 		// 3. Client is not currently routing (so they need the invite)
+		afterRestart := d.takeReconnectInvite(vpnIP)
 		if err == nil && prevState != nil &&
 		   prevState.State == store.ClientStateConnectedRouting &&
 		   !peerInfo.RouteAll {
-			log.Printf("[vpn] Client %s was previously routing, sending RECONNECT_INVITE", vpnIP)
+			d.peerLogf(vpnIP, "[vpn] Client %s was previously routing, sending RECONNECT_INVITE", vpnIP)
+			reason := "reconnection"
+			if afterRestart {
+				reason = "server_restart"
+			}
 			invite := protocol.ReconnectInvite{
 				ServerName:          d.config.NodeName,
-				Reason:              "reconnection",
+				Reason:              reason,
 				ShouldEnableRouting: true,
 			}
 			inviteMsg := protocol.MakeReconnectInviteMessage(invite)
 			if err := conn.WritePacket(inviteMsg); err != nil {
-				log.Printf("[vpn] Failed to send RECONNECT_INVITE to %s: %v", vpnIP, err)
+				d.peerLogf(vpnIP, "[vpn] Failed to send RECONNECT_INVITE to %s: %v", vpnIP, err)
 			} else {
-				log.Printf("[vpn] Sent RECONNECT_INVITE to %s", vpnIP)
+				d.peerLogf(vpnIP, "[vpn] Sent RECONNECT_INVITE to %s", vpnIP)
 			}
 		}
 	}
 
 	// Handle packets from this client
-	d.handleClientPackets(conn, vpnIP)
+	d.handleClientPackets(conn, vpnIP, flags.Has(protocol.HandshakePing))
 
-	// Cleanup on disconnect
-	d.mu.Lock()
-	delete(d.peers, vpnIP)
-	d.mu.Unlock()
+	// Cleanup on disconnect (unless the peer was already removed, or a
+	// reconnect of the same client has taken over its VPN IP)
+	if d.unregisterPeer(vpnIP, conn) {
+		d.peerLogf(vpnIP, "[vpn] Client disconnected: %s (%s)", peerInfo.Hostname, vpnIP)
+	}
+}
+
+// peerLogf logs like log.Printf, tagging the stored entry with the peer's
+// VPN IP in the peer_ip field, so 'vpn logs --field=peer_ip:<ip>' finds
+// everything about one client.
+func (d *Daemon) peerLogf(vpnIP, format string, args ...interface{}) {
+	if d.logWriter == nil {
+		log.Printf(format, args...)
+		return
+	}
+	fields := map[string]string{"peer_ip": vpnIP}
+	log.New(d.logWriter.WithFields(fields), log.Prefix(), log.Flags()).Printf(format, args...)
+}
 
+// unregisterPeer removes a client's peer entry and connection, closes the
+// connection and broadcasts the updated peer list. conn guards against removing
+// a newer connection for the same VPN IP; pass nil to remove whatever is
+// registered. It reports whether anything was removed.
+func (d *Daemon) unregisterPeer(vpnIP string, conn *tunnel.Conn) bool {
 	d.peerConnsMu.Lock()
+	current, ok := d.peerConns[vpnIP]
+	if !ok || (conn != nil && current != conn) {
+		d.peerConnsMu.Unlock()
+		return false
+	}
 	delete(d.peerConns, vpnIP)
 	d.peerConnsMu.Unlock()
 
+	current.Close()
+
+	d.mu.Lock()
+	delete(d.peers, vpnIP)
+	d.mu.Unlock()
+
+	d.removeDefaultRateLimiter(vpnIP)
+
 	// Remove peer from topology
 	if d.topology != nil {
 		d.topology.RemovePeer(vpnIP)
@@ -573,12 +1331,78 @@ func (d *Daemon) handleVPNClient(conn *tunnel.Conn) {
 
 	// Broadcast updated peer list after disconnect
 	d.broadcastPeerList()
+	return true
+}
+
+// resolvePeerAddress returns the VPN IP of a peer given as VPN IP, node name
+// or hostname (connected, or with a recorded IP assignment), or "".
+func (d *Daemon) resolvePeerAddress(peer string) string {
+	if net.ParseIP(peer) != nil {
+		return peer
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for vpnIP, p := range d.peers {
+		if p.Name == peer {
+			return vpnIP
+		}
+	}
+	if ip, ok := d.lookupAssignedIP(peer); ok {
+		return ip
+	}
+	return ""
+}
+
+// forgetPeer removes a node from the network ('vpn node rm'): a connected
+// client is sent REMOVED and disconnected, and its IP assignments and
+// connection state are deleted so the IP can go to another node. It reports
+// whether the node was connected or had an IP assigned.
+func (d *Daemon) forgetPeer(vpnIP string) bool {
+	d.peerConnsMu.RLock()
+	conn := d.peerConns[vpnIP]
+	d.peerConnsMu.RUnlock()
+
+	known := false
+	if conn != nil {
+		if err := conn.WritePacket(protocol.MakeRemovedMessage("removed from the network by the server operator")); err != nil {
+			log.Printf("[vpn] Failed to send REMOVED to %s: %v", vpnIP, err)
+		}
+		known = d.unregisterPeer(vpnIP, conn)
+	}
+
+	d.mu.Lock()
+	for key, ip := range d.hostnameToIP {
+		if ip == vpnIP {
+			delete(d.hostnameToIP, key)
+			known = true
+		}
+	}
+	d.mu.Unlock()
 
-	log.Printf("[vpn] Client disconnected: %s (%s)", peerInfo.Hostname, vpnIP)
+	if d.store != nil {
+		if deleted, err := d.store.DeleteAssignedIP(vpnIP); err != nil {
+			log.Printf("[vpn] Failed to delete IP assignment of %s: %v", vpnIP, err)
+		} else if deleted {
+			known = true
+		}
+		if err := d.store.DeleteClientState(vpnIP); err != nil {
+			log.Printf("[vpn] Failed to delete client state of %s: %v", vpnIP, err)
+		}
+	}
+	return known
 }
 
 // handleClientPackets reads packets from a client and writes to TUN.
-func (d *Daemon) handleClientPackets(conn *tunnel.Conn, vpnIP string) {
+// It returns when the connection fails or, for a client that pings (see
+// protocol.HandshakePing), when it stays silent for longer than the
+// configured peer timeout.
+func (d *Daemon) handleClientPackets(conn *tunnel.Conn, vpnIP string, pings bool) {
+	var timeout time.Duration
+	if pings {
+		timeout = time.Duration(d.config.PeerTimeoutSeconds) * time.Second
+	}
+
 	for {
 		select {
 		case <-d.ctx.Done():
@@ -586,12 +1410,28 @@ func (d *Daemon) handleClientPackets(conn *tunnel.Conn, vpnIP string) {
 		default:
 		}
 
+		// Reap peers that go silent (clients PING every protocol.PingInterval)
+		if timeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(timeout))
+		}
+
 		packet, err := conn.ReadPacket()
 		if err != nil {
-			log.Printf("[vpn] Read error from %s: %v", vpnIP, err)
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				d.peerLogf(vpnIP, "[vpn] Peer %s timed out (no packets or pings for %s)", vpnIP, timeout)
+			} else {
+				d.peerLogf(vpnIP, "[vpn] Read error from %s: %v", vpnIP, err)
+			}
 			return
 		}
 
+		d.mu.Lock()
+		if peer, ok := d.peers[vpnIP]; ok {
+			peer.LastSeen = time.Now()
+		}
+		d.mu.Unlock()
+
 		// Check for control messages
 		if protocol.IsControlMessage(packet) {
 			cmd := protocol.ExtractControlCommand(packet)
@@ -601,14 +1441,20 @@ func (d *Daemon) handleClientPackets(conn *tunnel.Conn, vpnIP string) {
 
 		// Validate IP packet
 		if !tunnel.IsValidIPPacket(packet) {
-			log.Printf("[vpn] Invalid packet from %s", vpnIP)
+			d.peerLogf(vpnIP, "[vpn] Invalid packet from %s", vpnIP)
+			continue
+		}
+
+		// Enforce the peer's ingress cap, if any
+		if limiter := d.peerRateLimiter(vpnIP); limiter != nil && !limiter.allowIngress(len(packet)) {
 			continue
 		}
 
 		// Write to TUN (goes to kernel for routing)
 		if _, err := d.tun.Write(packet); err != nil {
-			log.Printf("[vpn] TUN write error: %v", err)
+			d.peerLogf(vpnIP, "[vpn] TUN write error: %v", err)
 		}
+		d.traffic.add(tunnel.GetDestinationIP(packet), len(packet), 0)
 
 		// Update stats
 		d.mu.Lock()
@@ -627,36 +1473,58 @@ func (d *Daemon) handleServerControlMessage(conn *tunnel.Conn, vpnIP, cmd string
 	if protocol.IsDisconnectIntentMessage(cmd) {
 		intent, err := protocol.ParseDisconnectIntentMessage(packet)
 		if err != nil {
-			log.Printf("[vpn] Failed to parse DISCONNECT_INTENT from %s: %v", vpnIP, err)
+			d.peerLogf(vpnIP, "[vpn] Failed to parse DISCONNECT_INTENT from %s: %v", vpnIP, err)
 			return
 		}
 
-		log.Printf("[vpn] Received DISCONNECT_INTENT from %s (node: %s, reason: %s, had_routing: %v)",
+		d.peerLogf(vpnIP, "[vpn] Received DISCONNECT_INTENT from %s (node: %s, reason: %s, had_routing: %v)",
 			vpnIP, intent.NodeName, intent.Reason, intent.RouteAll)
 
 		// Record intentional disconnect in store (if server has store)
 		if d.store != nil {
 			if err := d.store.SetClientDisconnectedIntentional(vpnIP, intent.Reason); err != nil {
-				log.Printf("[vpn] Failed to record disconnect intent: %v", err)
+				d.peerLogf(vpnIP, "[vpn] Failed to record disconnect intent: %v", err)
 			}
 		}
 
 		// Send acknowledgement
 		ack := protocol.MakeDisconnectAckMessage()
 		if err := conn.WritePacket(ack); err != nil {
-			log.Printf("[vpn] Failed to send DISCONNECT_ACK to %s: %v", vpnIP, err)
+			d.peerLogf(vpnIP, "[vpn] Failed to send DISCONNECT_ACK to %s: %v", vpnIP, err)
 		} else {
-			log.Printf("[vpn] Sent DISCONNECT_ACK to %s", vpnIP)
+			d.peerLogf(vpnIP, "[vpn] Sent DISCONNECT_ACK to %s", vpnIP)
 		}
 		return
 	}
 
+	// PING: echo it back (the caller has already refreshed LastSeen)
+	if protocol.IsPingMessage(cmd) {
+		if err := conn.WritePacket(protocol.MakePongMessage(cmd)); err != nil {
+			d.peerLogf(vpnIP, "[vpn] Failed to send PONG to %s: %v", vpnIP, err)
+		}
+		return
+	}
+
+	// PONG: reply to our pingPeersLoop
+	if protocol.IsPongMessage(cmd) {
+		d.recordPong(vpnIP, cmd)
+		return
+	}
+
+	// SEQUENCE: the client numbers every packet after this one
+	if protocol.IsSequenceMessage(cmd) {
+		conn.ExpectSequence()
+		return
+	}
+
 	// Log other control messages
-	log.Printf("[vpn] Control message from %s: %s", vpnIP, cmd)
+	d.peerLogf(vpnIP, "[vpn] Control message from %s: %s", vpnIP, cmd)
 }
 
 // routeTUNPackets reads from TUN and routes to the correct peer (server mode).
 func (d *Daemon) routeTUNPackets() {
+	defer d.recoverCrash()
+
 	buf := make([]byte, tunnel.MTU)
 
 	for {
@@ -680,7 +1548,13 @@ func (d *Daemon) routeTUNPackets() {
 			continue
 		}
 
+		// Peers are keyed by IPv4; IPv6 addresses mirror it (fd00::5 -> 10.8.0.5)
 		destStr := destIP.String()
+		if destIP.To4() == nil {
+			if destStr = tunnel.IPv4For(destIP, d.subnet); destStr == "" {
+				continue
+			}
+		}
 
 		// Find peer connection for this destination
 		d.peerConnsMu.RLock()
@@ -692,6 +1566,11 @@ func (d *Daemon) routeTUNPackets() {
 			continue
 		}
 
+		// Enforce the peer's egress cap, if any
+		if limiter := d.peerRateLimiter(destStr); limiter != nil && !limiter.allow(len(packet)) {
+			continue
+		}
+
 		// Send to peer
 		if err := peerConn.WritePacket(packet); err != nil {
 			log.Printf("[tun] Failed to send to %s: %v", destStr, err)
@@ -699,6 +1578,7 @@ func (d *Daemon) routeTUNPackets() {
 		}
 
 		// Update stats
+		d.traffic.add(tunnel.GetSourceIP(packet), 0, len(packet))
 		d.mu.Lock()
 		d.bytesOut += uint64(len(packet))
 		if peer, ok := d.peers[destStr]; ok {
@@ -710,6 +1590,8 @@ func (d *Daemon) routeTUNPackets() {
 
 // forwardTUNToServer reads from TUN and sends to server (client mode).
 func (d *Daemon) forwardTUNToServer() {
+	defer d.recoverCrash()
+
 	buf := make([]byte, tunnel.MTU)
 
 	for {
@@ -720,7 +1602,7 @@ func (d *Daemon) forwardTUNToServer() {
 		}
 
 		// Check if connection is still valid (may be nil during reconnect)
-		if d.vpnConn == nil {
+		if d.vpnConn.Load() == nil {
 			log.Printf("[vpn] Connection not available, stopping TUN->Server forwarder")
 			return
 		}
@@ -732,12 +1614,13 @@ func (d *Daemon) forwardTUNToServer() {
 		}
 
 		// Double-check connection before write (race condition protection)
-		if d.vpnConn == nil {
+		conn := d.vpnConn.Load()
+		if conn == nil {
 			log.Printf("[vpn] Connection lost during read, stopping TUN->Server forwarder")
 			return
 		}
 
-		if err := d.vpnConn.WritePacket(buf[:n]); err != nil {
+		if err := conn.WritePacket(buf[:n]); err != nil {
 			log.Printf("[vpn] Send error: %v", err)
 			log.Printf("[vpn] Connection to server lost (send failed)")
 			d.signalConnectionFailure()
@@ -752,6 +1635,8 @@ func (d *Daemon) forwardTUNToServer() {
 
 // forwardServerToTUN reads from server and writes to TUN (client mode).
 func (d *Daemon) forwardServerToTUN() {
+	defer d.recoverCrash()
+
 	for {
 		select {
 		case <-d.ctx.Done():
@@ -760,12 +1645,13 @@ func (d *Daemon) forwardServerToTUN() {
 		}
 
 		// Check if connection is still valid (may be nil during reconnect)
-		if d.vpnConn == nil {
+		conn := d.vpnConn.Load()
+		if conn == nil {
 			log.Printf("[vpn] Connection not available, stopping Server->TUN forwarder")
 			return
 		}
 
-		packet, err := d.vpnConn.ReadPacket()
+		packet, err := conn.ReadPacket()
 		if err != nil {
 			log.Printf("[vpn] Read error: %v", err)
 			log.Printf("[vpn] Connection to server lost (read failed)")
@@ -815,6 +1701,101 @@ func (d *Daemon) forwardServerToTUN() {
 				continue
 			}
 
+			// Handle PONG from server: the tunnel is alive
+			if protocol.IsPongMessage(cmd) {
+				d.missedPongs.Store(0)
+				d.pongSeen.Store(true)
+				d.recordPong(d.serverIP(), cmd)
+				continue
+			}
+
+			// Handle PING from server (RTT measurement): echo it back
+			if protocol.IsPingMessage(cmd) {
+				if conn := d.vpnConn.Load(); conn != nil {
+					if err := conn.WritePacket(protocol.MakePongMessage(cmd)); err != nil {
+						log.Printf("[vpn] Failed to send PONG: %v", err)
+					}
+				}
+				continue
+			}
+
+			// Handle SERVER_INFO from server: name the server in the topology
+			if protocol.IsServerInfoMessage(cmd) {
+				info, err := protocol.ParseServerInfoMessage(packet)
+				if err != nil {
+					log.Printf("[vpn] Failed to parse SERVER_INFO: %v", err)
+				} else {
+					log.Printf("[vpn] Connected to server %s (%s, version %s)", info.Name, info.OS, info.Version)
+					d.serverInfo.Store(info)
+					d.addServerPeer()
+				}
+				continue
+			}
+
+			// Handle COMPRESSION from server: it accepted our compression offer
+			if protocol.IsCompressionMessage(cmd) {
+				if conn := d.vpnConn.Load(); conn != nil {
+					conn.SetCompression(true)
+					log.Printf("[vpn] Server accepted compression (LZ4)")
+				}
+				continue
+			}
+
+			// Handle SEQUENCE from server: it numbers its packets from now on,
+			// so read numbered frames and start numbering ours
+			if protocol.IsSequenceMessage(cmd) {
+				if conn := d.vpnConn.Load(); conn != nil {
+					conn.ExpectSequence()
+					if err := conn.StartSequencing(protocol.MakeSequenceMessage()); err != nil {
+						log.Printf("[vpn] Failed to send SEQUENCE: %v", err)
+					}
+				}
+				continue
+			}
+
+			// Handle UDP from server: send IP packets over its UDP session
+			if protocol.IsUDPMessage(cmd) {
+				offer, err := protocol.ParseUDPMessage(packet)
+				if err != nil {
+					log.Printf("[vpn] %v", err)
+				} else if conn := d.vpnConn.Load(); conn != nil {
+					if err := conn.StartUDP(offer.Port, offer.Session, offer.Key); err != nil {
+						log.Printf("[vpn] Warning: UDP transport unavailable, staying on TCP: %v", err)
+					} else {
+						log.Printf("[vpn] Server accepted UDP transport (port %d)", offer.Port)
+					}
+				}
+				continue
+			}
+
+			// Handle IPV6 from server: our IPv6 address (dual-stack)
+			if protocol.IsIPv6Message(cmd) {
+				addr, err := protocol.ParseIPv6Message(cmd)
+				if err != nil {
+					log.Printf("[vpn] %v", err)
+				} else if d.config.IPv6 && d.tun != nil {
+					if err := d.tun.AddIPv6(addr); err != nil {
+						log.Printf("[vpn] Warning: failed to configure IPv6: %v", err)
+					} else {
+						log.Printf("[vpn] Assigned VPN IPv6: %s", addr)
+					}
+				}
+				continue
+			}
+
+			// Handle REMOVED from server: this node was removed from the
+			// network ('vpn node rm'), so stop instead of reconnecting
+			if protocol.IsRemovedMessage(cmd) {
+				reason := protocol.ParseRemovedMessage(cmd)
+				log.Printf("[vpn] ========================================")
+				log.Printf("[vpn] REMOVED FROM THE NETWORK BY THE SERVER")
+				log.Printf("[vpn] ========================================")
+				log.Printf("[vpn] Reason: %s", reason)
+				log.Printf("[vpn] Exiting; re-run the installer to join again")
+				d.shutdownWithReason("removed by server: " + reason)
+				return
+			}
+
 			// Handle DISCONNECT_ACK from server (Connection Intent Protocol)
 			// Server acknowledges our DISCONNECT_INTENT
 			if protocol.IsDisconnectAckMessage(cmd) {
@@ -831,79 +1812,228 @@ func (d *Daemon) forwardServerToTUN() {
 			continue
 		}
 
-		if _, err := d.tun.Write(packet); err != nil {
-			log.Printf("[tun] Write error: %v", err)
+		if _, err := d.tun.Write(packet); err != nil {
+			log.Printf("[tun] Write error: %v", err)
+		}
+
+		d.mu.Lock()
+		d.bytesIn += uint64(len(packet))
+		d.mu.Unlock()
+	}
+}
+
+// assignIP assigns a VPN IP to a client (with persistence by public IP and hostname).
+// publicIP is the client's public IP address (used for stable identification).
+// With IPv6 the client's IPv6 address follows from it (tunnel.IPv6For).
+func (d *Daemon) assignIP(hostname string, publicIP string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// First, check if the public IP already has an assigned VPN IP
+	// This handles cases where hostname changes (e.g., network changes)
+	if publicIP != "" {
+		if ip, exists := d.lookupAssignedIP("ip:" + publicIP); exists {
+			// Verify IP is not in use by a different connection
+			if peer, inUse := d.peers[ip]; !inUse || (inUse && peer.Name == hostname) {
+				// Update hostname mapping too
+				d.recordAssignedIP(hostname, ip)
+				return ip
+			}
+		}
+	}
+
+	// Check if hostname already has an IP
+	if ip, exists := d.lookupAssignedIP(hostname); exists {
+		// Verify IP is not in use
+		if _, inUse := d.peers[ip]; !inUse {
+			// Also store by public IP for future lookups
+			if publicIP != "" {
+				d.recordAssignedIP("ip:"+publicIP, ip)
+			}
+			return ip
+		}
+	}
+
+	// Assign new IP (with wrap-around to prevent overflow)
+	// Skip host 1 (server) and wrap at the last host (.254 in a /24)
+	lastHost := tunnel.SubnetHostCount(d.subnet)
+	if d.nextIP > lastHost {
+		d.nextIP = 2
+	}
+
+	// Find an unused IP (in case of wrap-around)
+	startIP := d.nextIP
+	for {
+		ip := tunnel.SubnetHost(d.subnet, d.nextIP)
+		d.nextIP++
+		if d.nextIP > lastHost {
+			d.nextIP = 2
+		}
+
+		// Check if this IP is in use
+		if _, inUse := d.peers[ip]; !inUse {
+			d.saveNextIP()
+			d.recordAssignedIP(hostname, ip)
+			if publicIP != "" {
+				d.recordAssignedIP("ip:"+publicIP, ip)
+			}
+			return ip
+		}
+
+		// Prevent infinite loop if all IPs are in use
+		if d.nextIP == startIP {
+			// All IPs exhausted, assign anyway (will fail later)
+			ip := tunnel.SubnetHost(d.subnet, d.nextIP)
+			d.nextIP++
+			return ip
+		}
+	}
+}
+
+// peekNextIP returns the IP assignIP would give a new client, without
+// reserving it.
+func (d *Daemon) peekNextIP() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	lastHost := tunnel.SubnetHostCount(d.subnet)
+	n := d.nextIP
+	for i := 0; i < lastHost; i++ {
+		if n < 2 || n > lastHost {
+			n = 2
+		}
+		ip := tunnel.SubnetHost(d.subnet, n)
+		if _, inUse := d.peers[ip]; !inUse {
+			return ip
+		}
+		n++
+	}
+	return tunnel.SubnetHost(d.subnet, n)
+}
+
+// lookupAssignedIP returns the IP assigned to a key (hostname or "ip:<public IP>"),
+// consulting the store when it is not cached in memory. Caller must hold d.mu.
+func (d *Daemon) lookupAssignedIP(key string) (string, bool) {
+	if ip, exists := d.hostnameToIP[key]; exists {
+		return ip, true
+	}
+	if d.store == nil {
+		return "", false
+	}
+
+	ip, err := d.store.GetAssignedIP(key)
+	if err != nil {
+		log.Printf("[vpn] Failed to look up stored IP for %s: %v", key, err)
+		return "", false
+	}
+	if ip == "" {
+		return "", false
+	}
+	d.hostnameToIP[key] = ip
+	return ip, true
+}
+
+// recordAssignedIP caches and persists an IP assignment. Caller must hold d.mu.
+func (d *Daemon) recordAssignedIP(key, ip string) {
+	if d.hostnameToIP[key] == ip {
+		return
+	}
+	d.hostnameToIP[key] = ip
+	if d.store != nil {
+		if err := d.store.SaveAssignedIP(key, ip); err != nil {
+			log.Printf("[vpn] Failed to persist IP assignment %s -> %s: %v", key, ip, err)
+		}
+	}
+}
+
+// saveNextIP persists the allocator position. Caller must hold d.mu.
+func (d *Daemon) saveNextIP() {
+	if d.store == nil {
+		return
+	}
+	if err := d.store.SaveNextIPOctet(d.nextIP); err != nil {
+		log.Printf("[vpn] Failed to persist next IP: %v", err)
+	}
+}
+
+// restoreNextIP resumes IP allocation where it left off before a restart, so
+// new clients don't collide with IPs handed out previously. Stores written
+// before the allocator position was persisted fall back to the highest
+// recorded assignment.
+func (d *Daemon) restoreNextIP() {
+	if d.store == nil {
+		return
+	}
+
+	next, err := d.store.GetNextIPOctet()
+	if err != nil {
+		log.Printf("[vpn] Failed to read persisted next IP: %v", err)
+	}
+	if next == 0 {
+		highest, err := d.store.GetHighestAssignedOctet()
+		if err != nil {
+			log.Printf("[vpn] Failed to read persisted IP assignments: %v", err)
+			return
+		}
+		if highest > 0 {
+			next = highest + 1
 		}
-
-		d.mu.Lock()
-		d.bytesIn += uint64(len(packet))
-		d.mu.Unlock()
 	}
-}
 
-// assignIP assigns a VPN IP to a client (with persistence by public IP and hostname).
-// publicIP is the client's public IP address (used for stable identification).
-func (d *Daemon) assignIP(hostname string, publicIP string) string {
 	d.mu.Lock()
 	defer d.mu.Unlock()
+	if next >= 2 && next <= tunnel.SubnetHostCount(d.subnet) {
+		d.nextIP = next
+		log.Printf("[vpn] Resuming IP assignment at %s", tunnel.SubnetHost(d.subnet, d.nextIP))
+	}
+}
 
-	// First, check if the public IP already has an assigned VPN IP
-	// This handles cases where hostname changes (e.g., network changes)
-	if publicIP != "" {
-		if ip, exists := d.hostnameToIP["ip:"+publicIP]; exists {
-			// Verify IP is not in use by a different connection
-			if peer, inUse := d.peers[ip]; !inUse || (inUse && peer.Name == hostname) {
-				// Update hostname mapping too
-				d.hostnameToIP[hostname] = ip
-				return ip
-			}
-		}
+// loadReconnectInvites reads the clients that were routing when the server
+// last stopped and did not send a DISCONNECT_INTENT. The server cannot dial
+// them, so each one is invited to restore routing once it reconnects.
+func (d *Daemon) loadReconnectInvites() {
+	if d.store == nil {
+		return
 	}
 
-	// Check if hostname already has an IP
-	if ip, exists := d.hostnameToIP[hostname]; exists {
-		// Verify IP is not in use
-		if _, inUse := d.peers[ip]; !inUse {
-			// Also store by public IP for future lookups
-			if publicIP != "" {
-				d.hostnameToIP["ip:"+publicIP] = ip
-			}
-			return ip
-		}
+	clients, err := d.store.GetClientsForReconnectInvite()
+	if err != nil {
+		log.Printf("[vpn] Failed to read client states: %v", err)
+		return
 	}
 
-	// Assign new IP (with wrap-around to prevent overflow)
-	// Skip .1 (server) and wrap at .254
-	if d.nextIP > 254 {
-		d.nextIP = 2
+	invites := make(map[string]bool, len(clients))
+	for _, c := range clients {
+		invites[c.VPNAddress] = true
 	}
 
-	// Find an unused IP (in case of wrap-around)
-	startIP := d.nextIP
-	for {
-		ip := fmt.Sprintf("10.8.0.%d", d.nextIP)
-		d.nextIP++
-		if d.nextIP > 254 {
-			d.nextIP = 2
-		}
+	d.reconnectInvitesMu.Lock()
+	d.reconnectInvites = invites
+	d.reconnectInvitesMu.Unlock()
 
-		// Check if this IP is in use
-		if _, inUse := d.peers[ip]; !inUse {
-			d.hostnameToIP[hostname] = ip
-			if publicIP != "" {
-				d.hostnameToIP["ip:"+publicIP] = ip
-			}
-			return ip
-		}
+	if len(clients) > 0 {
+		log.Printf("[vpn] %d client(s) were routing before the restart; they will get a RECONNECT_INVITE when they reconnect", len(clients))
+	}
+}
 
-		// Prevent infinite loop if all IPs are in use
-		if d.nextIP == startIP {
-			// All IPs exhausted, assign anyway (will fail later)
-			ip := fmt.Sprintf("10.8.0.%d", d.nextIP)
-			d.nextIP++
-			return ip
-		}
+// takeReconnectInvite reports whether vpnIP was routing before the server
+// restarted, and forgets it so later reconnects are not attributed to the
+// restart.
+func (d *Daemon) takeReconnectInvite(vpnIP string) bool {
+	d.reconnectInvitesMu.Lock()
+	defer d.reconnectInvitesMu.Unlock()
+
+	if !d.reconnectInvites[vpnIP] {
+		return false
 	}
+	delete(d.reconnectInvites, vpnIP)
+	return true
+}
+
+// serverIP returns the server's VPN IP: the first host of the subnet
+// (10.8.0.1 by default).
+func (d *Daemon) serverIP() string {
+	return tunnel.SubnetHost(d.subnet, 1)
 }
 
 // initStorage initializes the SQLite storage and metrics collection.
@@ -917,11 +2047,24 @@ func (d *Daemon) initStorage() error {
 		dataDir = filepath.Join(homeDir, ".vpn-node")
 	}
 
-	s, err := store.New(dataDir)
+	policy := store.RetentionPolicy{
+		Logs:       time.Duration(d.config.LogsRetentionDays) * 24 * time.Hour,
+		MetricsRaw: time.Duration(d.config.MetricsRetentionHours) * time.Hour,
+	}
+	if d.config.LogsRetention > 0 {
+		policy.Logs = d.config.LogsRetention
+	}
+
+	s, err := store.New(dataDir, store.Options{
+		Retention:       policy,
+		MaxStorageBytes: int64(d.config.MaxStorageMB) * 1024 * 1024,
+		LogRateLimit:    d.config.LogRateLimit,
+	})
 	if err != nil {
 		return err
 	}
 	d.store = s
+	d.geoResolver = geo.NewResolver(s)
 
 	// Initialize metrics trackers
 	d.standardMetrics = store.NewStandardMetrics()
@@ -931,11 +2074,33 @@ func (d *Daemon) initStorage() error {
 	d.metricsCollector = store.NewCollector(d.store, time.Second)
 	d.metricsCollector.RegisterSource("standard", d.standardMetrics.Source())
 	d.metricsCollector.RegisterSource("bandwidth", d.bandwidthTracker.Source())
+	d.metricsCollector.RegisterSource("store", d.store.Source())
+
+	// Evaluate alert rules after each metrics write
+	d.alerts = newAlertEngine(d.store, func() string { return d.config.NodeName })
+	d.metricsHub = newMetricsHub()
+	d.metricsCollector.OnWrite(func(points []store.MetricPoint) {
+		d.alerts.enqueue(points)
+		d.metricsHub.publish(points)
+	})
+	go d.alerts.run(d.ctx)
+
 	d.metricsCollector.Start()
 
 	// Redirect log output to store
-	logWriter := store.NewLogWriter(d.store, "node", "INFO")
-	log.SetOutput(store.MultiWriter(logWriter))
+	d.logWriter = store.NewLogWriter(d.store, "node", "INFO")
+	if err := d.logWriter.SetMinLevel(d.config.LogLevel); err != nil {
+		log.Printf("[store] Warning: %v (recording all levels)", err)
+	}
+	if d.config.LogFormat == "json" {
+		d.logWriter.SetFormat("json")
+		// Aggregators collect many nodes, so say which one this is
+		d.logWriter.SetFields(map[string]string{"node": d.config.NodeName, "vpn_ip": d.config.VPNAddress})
+	}
+	if d.config.LogOutput == "stderr" {
+		d.logWriter.SetOutput(os.Stderr)
+	}
+	log.SetOutput(store.MultiWriter(d.logWriter))
 
 	log.Printf("[store] Metrics collection started (interval: 1s)")
 	return nil
@@ -955,29 +2120,122 @@ func (d *Daemon) updateMetrics() {
 
 	// Get packet counts from VPN connection
 	var packetsSent, packetsRecv uint64
-	if d.vpnConn != nil {
-		_, _, packetsSent, packetsRecv = d.vpnConn.Stats()
+	if conn := d.vpnConn.Load(); conn != nil {
+		_, _, packetsSent, packetsRecv = conn.Stats()
 	}
 
 	d.standardMetrics.Update(bytesOut, bytesIn, packetsSent, packetsRecv, peerCount)
 	d.bandwidthTracker.Record(bytesOut, bytesIn)
+
+	if d.config.Compression {
+		d.standardMetrics.SetCompression(d.compressionStats())
+	}
+
+	if limiters := d.peerRateLimiters(); len(limiters) > 0 {
+		now := time.Now()
+		points := make([]store.MetricPoint, 0, len(limiters))
+		for vpnIP, limiter := range limiters {
+			limiter.sample(now)
+			_, currentMbps, _ := limiter.stats()
+			points = append(points, store.MetricPoint{
+				Timestamp: now,
+				Name:      "peer.rate_mbps",
+				Value:     currentMbps,
+				Tags:      d.peerTags(vpnIP),
+			})
+		}
+		d.standardMetrics.SetRateLimitDropped(d.rateLimitDroppedBytes())
+		if err := d.store.WriteBatchMetrics(points); err != nil {
+			log.Printf("[store] Failed to write peer.rate_mbps: %v", err)
+		}
+	}
+}
+
+// compressionStats sums compression statistics over the current connections.
+func (d *Daemon) compressionStats() (rawBytes, compressedBytes uint64) {
+	if conn := d.vpnConn.Load(); conn != nil {
+		rawBytes, compressedBytes = conn.CompressionStats()
+	}
+
+	d.peerConnsMu.RLock()
+	defer d.peerConnsMu.RUnlock()
+	for _, conn := range d.peerConns {
+		raw, compressed := conn.CompressionStats()
+		rawBytes += raw
+		compressedBytes += compressed
+	}
+	return rawBytes, compressedBytes
+}
+
+// packetLossInterval is how often vpn.packet_loss_pct is recomputed.
+const packetLossInterval = 10 * time.Second
+
+// updatePacketLoss computes the share of sequence numbers skipped by the
+// peers since the last call, over all current connections.
+func (d *Daemon) updatePacketLoss() {
+	var received, lost uint64
+	if conn := d.vpnConn.Load(); conn != nil {
+		received, lost = conn.TakeLossStats()
+	}
+
+	d.peerConnsMu.RLock()
+	for _, conn := range d.peerConns {
+		r, l := conn.TakeLossStats()
+		received += r
+		lost += l
+	}
+	d.peerConnsMu.RUnlock()
+
+	var loss float64
+	if received+lost > 0 {
+		loss = float64(lost) / float64(received+lost) * 100
+	}
+
+	d.mu.Lock()
+	d.packetLoss = loss
+	d.mu.Unlock()
+
+	if d.standardMetrics != nil {
+		d.standardMetrics.SetPacketLoss(loss)
+	}
 }
 
 // metricsLoop periodically updates metrics.
 func (d *Daemon) metricsLoop() {
+	defer d.recoverCrash()
+
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
 
+	lossTicker := time.NewTicker(packetLossInterval)
+	defer lossTicker.Stop()
+
 	for {
 		select {
 		case <-d.ctx.Done():
 			return
 		case <-ticker.C:
 			d.updateMetrics()
+		case <-lossTicker.C:
+			d.updatePacketLoss()
 		}
 	}
 }
 
+// enableNAT sets up masquerading for the VPN subnet on the interface
+// internet traffic leaves through.
+func (d *Daemon) enableNAT() error {
+	iface, err := tunnel.ExternalInterface()
+	if err != nil {
+		return fmt.Errorf("cannot detect external interface: %w", err)
+	}
+	if err := tunnel.EnableNAT(d.subnet, iface); err != nil {
+		return err
+	}
+	d.natIface = iface
+	return nil
+}
+
 // shutdown gracefully stops the daemon. Safe to call multiple times.
 func (d *Daemon) shutdown() error {
 	return d.shutdownWithReason("unknown")
@@ -1001,7 +2259,7 @@ func (d *Daemon) shutdownWithReason(reason string) error {
 
 		// CRITICAL: Restore routing FIRST before anything else
 		// This ensures that even if subsequent cleanup fails, the user has internet
-		if d.tun != nil && d.config.RouteAll {
+		if d.tun != nil && d.hasVPNRoutes() {
 			log.Printf("[node] Restoring network routes...")
 			routeRestoreErr = d.tun.RestoreRouting()
 			if routeRestoreErr != nil {
@@ -1013,6 +2271,12 @@ func (d *Daemon) shutdownWithReason(reason string) error {
 			}
 		}
 
+		if d.natIface != "" && !d.keepNAT.Load() {
+			if err := tunnel.DisableNAT(d.subnet, d.natIface); err != nil {
+				log.Printf("[node] Warning: %v", err)
+			}
+		}
+
 		// Record shutdown event to database
 		if d.store != nil {
 			uptime := d.Uptime().Seconds()
@@ -1036,8 +2300,8 @@ func (d *Daemon) shutdownWithReason(reason string) error {
 		d.vpnListener.Close()
 	}
 
-	if d.vpnConn != nil {
-		d.vpnConn.Close()
+	if conn := d.vpnConn.Load(); conn != nil {
+		conn.Close()
 	}
 
 	// Close all peer connections
@@ -1054,6 +2318,11 @@ func (d *Daemon) shutdownWithReason(reason string) error {
 	if d.controlListener != nil {
 		d.controlListener.Close()
 	}
+	d.vpnControlListenerMu.Lock()
+	if d.vpnControlListener != nil {
+		d.vpnControlListener.Close()
+	}
+	d.vpnControlListenerMu.Unlock()
 
 	// Close storage LAST so lifecycle events are written
 	if d.store != nil {
@@ -1064,37 +2333,97 @@ func (d *Daemon) shutdownWithReason(reason string) error {
 	return routeRestoreErr
 }
 
-// startControlServer starts the control socket server.
-func (d *Daemon) startControlServer() error {
-	addr := d.config.ListenControl
-	if addr == "" {
-		addr = "127.0.0.1:9001"
+// controlAddr returns the control socket address.
+func (d *Daemon) controlAddr() string {
+	if d.config.ListenControl == "" {
+		return "127.0.0.1:9001"
 	}
+	return d.config.ListenControl
+}
 
-	listener, err := net.Listen("tcp", addr)
+// startControlServer starts the control socket server.
+func (d *Daemon) startControlServer() error {
+	listener, err := net.Listen("tcp", d.controlAddr())
 	if err != nil {
 		return err
 	}
 	d.controlListener = listener
 
-	go d.acceptControlConnections()
+	go d.acceptControlConnections(listener, false)
 	return nil
 }
 
-// acceptControlConnections handles incoming control connections.
-func (d *Daemon) acceptControlConnections() {
+// startVPNControlServer opens the read-only control listener on the current
+// VPN address (Config.ListenControlVPN), replacing one bound to an older
+// address. Failures are logged: peers only lose access to this node's logs.
+func (d *Daemon) startVPNControlServer() {
+	if !d.config.ListenControlVPN {
+		return
+	}
+
+	host, port, err := net.SplitHostPort(d.controlAddr())
+	if err != nil {
+		log.Printf("[control] Warning: no VPN control listener: invalid listen_control %q", d.controlAddr())
+		return
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		log.Printf("[control] Warning: no VPN control listener: listen_control %s already accepts VPN connections with full access", d.controlAddr())
+		return
+	}
+	addr := net.JoinHostPort(d.config.VPNAddress, port)
+
+	d.vpnControlListenerMu.Lock()
+	defer d.vpnControlListenerMu.Unlock()
+
+	if d.vpnControlListener != nil {
+		if d.vpnControlListener.Addr().String() == addr {
+			return
+		}
+		d.vpnControlListener.Close()
+		d.vpnControlListener = nil
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Printf("[control] Warning: no VPN control listener on %s: %v", addr, err)
+		return
+	}
+	d.vpnControlListener = listener
+	log.Printf("[control] Read-only control socket listening on %s", addr)
+
+	go d.acceptControlConnections(listener, true)
+}
+
+// acceptControlConnections handles incoming control connections. On a
+// read-only listener, only readOnlyMethods are accepted whatever the token.
+func (d *Daemon) acceptControlConnections(listener net.Listener, readOnly bool) {
+	defer d.recoverCrash()
+
 	for {
-		conn, err := d.controlListener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
 			select {
 			case <-d.ctx.Done():
 				return
 			default:
+				if errors.Is(err, net.ErrClosed) {
+					return // Rebound to a new VPN address
+				}
 				log.Printf("[control] Accept error: %v", err)
 				continue
 			}
 		}
-		go d.handleControlConnection(conn)
+
+		if d.controlConns.Add(1) > controlMaxConns {
+			d.controlConns.Add(-1)
+			d.countControlRequest(true)
+			go d.rejectControlConnection(conn)
+			continue
+		}
+		go func() {
+			defer d.controlConns.Add(-1)
+			d.handleControlConnection(conn, readOnly)
+		}()
 	}
 }
 
@@ -1110,6 +2439,14 @@ func (d *Daemon) Stats() (bytesIn, bytesOut uint64) {
 	return d.bytesIn, d.bytesOut
 }
 
+// PacketLoss returns the tunnel packet loss in percent over the last
+// packetLossInterval.
+func (d *Daemon) PacketLoss() float64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.packetLoss
+}
+
 // PeerCount returns the number of connected peers.
 func (d *Daemon) PeerCount() int {
 	d.mu.RLock()
@@ -1155,13 +2492,9 @@ func (d *Daemon) broadcastRestartNotification() {
 	time.Sleep(100 * time.Millisecond)
 }
 
-// broadcastPeerList sends the current peer list to all connected clients.
-func (d *Daemon) broadcastPeerList() {
-	if !d.config.ServerMode {
-		return // Only server broadcasts peer lists
-	}
-
-	// Build peer list (include server itself)
+// peerList returns the connected clients, led by the server itself
+// (server mode).
+func (d *Daemon) peerList() []protocol.PeerListEntry {
 	d.mu.RLock()
 	peers := make([]protocol.PeerListEntry, 0, len(d.peers)+1)
 
@@ -1171,7 +2504,7 @@ func (d *Daemon) broadcastPeerList() {
 		Name:       d.config.NodeName,
 		VPNAddress: d.config.VPNAddress,
 		Hostname:   hostname,
-		OS:         "linux",
+		OS:         runtime.GOOS,
 		PublicIP:   d.ourPublicIP,
 		Geo:        d.ourGeo,
 	})
@@ -1188,6 +2521,22 @@ func (d *Daemon) broadcastPeerList() {
 		})
 	}
 	d.mu.RUnlock()
+	return peers
+}
+
+// broadcastPeerList sends the current peer list to all connected clients.
+func (d *Daemon) broadcastPeerList() {
+	if !d.config.ServerMode {
+		return // Only server broadcasts peer lists
+	}
+
+	peers := d.peerList()
+	d.peersHub.publish(peers)
+
+	// Peer names, OS and geo shown in the topology may have changed
+	if d.topology != nil {
+		d.topology.Notify()
+	}
 
 	// Create the message
 	msg := protocol.MakePeerListMessage(peers)
@@ -1216,6 +2565,7 @@ func (d *Daemon) handlePeerListMessage(packet []byte) {
 	d.networkPeersMu.Lock()
 	d.networkPeers = peers
 	d.networkPeersMu.Unlock()
+	d.peersHub.publish(peers)
 
 	log.Printf("[vpn] Received peer list with %d peers:", len(peers))
 	for _, p := range peers {
@@ -1233,9 +2583,83 @@ func (d *Daemon) handlePeerListMessage(packet []byte) {
 				Name:       p.Name,
 				VPNAddress: p.VPNAddress,
 				OS:         p.OS,
-				IsDirect:   p.VPNAddress == "10.8.0.1", // Only server is direct
+				IsDirect:   p.VPNAddress == d.serverIP(), // Only server is direct
 				Geo:        p.Geo,
 			})
+			if p.Geo == nil && p.PublicIP != "" {
+				d.resolvePeerGeo(p.VPNAddress, p.PublicIP)
+			}
+		}
+	}
+}
+
+// resolvePeerGeo looks up a peer's location from its public address in the
+// background and adds it to the topology.
+func (d *Daemon) resolvePeerGeo(vpnAddr, publicAddr string) {
+	host := publicAddr
+	if h, _, err := net.SplitHostPort(publicAddr); err == nil {
+		host = h
+	}
+
+	go func() {
+		loc, err := d.geoResolver.Lookup(host)
+		if err != nil {
+			return
+		}
+		d.topology.UpdatePeerGeo(vpnAddr, loc)
+	}()
+}
+
+// geoRefreshInterval is how often nodes missing a location are looked up again.
+const geoRefreshInterval = 10 * time.Minute
+
+// geoRefreshLoop periodically resolves the location of topology nodes that
+// have a public address but no geo, so the map fills in once a lookup that
+// failed at connect time succeeds. A server also retries its own location;
+// a client can't, since after connecting it would get the VPN exit's.
+func (d *Daemon) geoRefreshLoop() {
+	defer d.recoverCrash()
+
+	ticker := time.NewTicker(geoRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if d.config.ServerMode && d.ourGeo == nil {
+			if loc, _, err := geo.LookupSelf(); err == nil {
+				d.ourGeo = loc
+				d.topology.SetOurGeo(loc)
+				log.Printf("[node] Our location: %s, %s", loc.City, loc.Country)
+			}
+		}
+
+		// One at a time: ip-api.com allows 45 lookups a minute
+		for _, n := range d.topology.GetAllNodes() {
+			if n.IsUs || n.Geo != nil || n.PublicAddr == "" {
+				continue
+			}
+			host := n.PublicAddr
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			loc, err := d.geoResolver.Lookup(host)
+			if err != nil {
+				continue
+			}
+			d.topology.UpdatePeerGeo(n.VPNAddress, loc)
+
+			// Server: PEER_LIST carries peer locations to clients
+			d.mu.Lock()
+			if p, ok := d.peers[n.VPNAddress]; ok && p.Geo == nil {
+				p.Geo = loc
+			}
+			d.mu.Unlock()
+			log.Printf("[node] Located %s (%s): %s, %s", n.Name, n.VPNAddress, loc.City, loc.Country)
 		}
 	}
 }
@@ -1299,7 +2723,7 @@ func (d *Daemon) GetBandwidth() (txCurrent, rxCurrent, txAvg, rxAvg float64) {
 
 // IsConnected returns true if VPN connection is active.
 func (d *Daemon) IsConnected() bool {
-	return d.vpnConn != nil
+	return d.vpnConn.Load() != nil
 }
 
 // IsRouteAll returns true if all traffic is routed through VPN.
@@ -1307,13 +2731,34 @@ func (d *Daemon) IsRouteAll() bool {
 	return d.config.RouteAll
 }
 
+// hasVPNRoutes returns true if any traffic (all or split subnets) is routed through VPN.
+func (d *Daemon) hasVPNRoutes() bool {
+	return d.config.RouteAll || (d.tun != nil && len(d.tun.SubnetRoutes()) > 0)
+}
+
+// routeSubnets installs split-tunnel routes for the configured subnets.
+func (d *Daemon) routeSubnets() {
+	serverIP := d.config.ConnectTo
+	if host, _, err := net.SplitHostPort(serverIP); err == nil {
+		serverIP = host
+	}
+	if err := d.tun.RouteSubnets(serverIP, d.config.RouteSubnets); err != nil {
+		log.Printf("[node] Warning: failed to route subnets: %v", err)
+	} else {
+		log.Printf("[node] Split tunneling: %s routed through VPN", strings.Join(d.config.RouteSubnets, ", "))
+	}
+}
+
+// errNotConnected is returned by operations that need the VPN tunnel.
+var errNotConnected = errors.New("VPN not connected")
+
 // EnableRouteAll enables routing all traffic through VPN.
 func (d *Daemon) EnableRouteAll() error {
 	if d.config.ServerMode {
 		return fmt.Errorf("route-all is only supported in client mode")
 	}
-	if d.vpnConn == nil || d.tun == nil {
-		return fmt.Errorf("VPN not connected")
+	if d.vpnConn.Load() == nil || d.tun == nil {
+		return errNotConnected
 	}
 	if d.config.RouteAll {
 		return nil // Already enabled
@@ -1342,7 +2787,7 @@ func (d *Daemon) DisableRouteAll() error {
 	if d.tun == nil {
 		return fmt.Errorf("TUN device not available")
 	}
-	if !d.config.RouteAll {
+	if !d.hasVPNRoutes() {
 		return nil // Already disabled
 	}
 
@@ -1352,9 +2797,36 @@ func (d *Daemon) DisableRouteAll() error {
 
 	d.config.RouteAll = false
 	log.Printf("[node] Traffic routing restored to direct")
+
+	if d.killSwitchEngaged.Load() {
+		if err := tunnel.DisableKillSwitch(); err != nil {
+			return err
+		}
+		d.killSwitchEngaged.Store(false)
+	}
 	return nil
 }
 
+// KillSwitchEngaged reports whether the kill switch is blocking traffic.
+func (d *Daemon) KillSwitchEngaged() bool {
+	return d.killSwitchEngaged.Load()
+}
+
+// serverPublicIP returns the server's public IP: the address the tunnel is
+// connected to, so a hostname in --connect is already resolved.
+func (d *Daemon) serverPublicIP() string {
+	if conn := d.vpnConn.Load(); conn != nil {
+		if addr, ok := conn.NetConn.RemoteAddr().(*net.TCPAddr); ok {
+			return addr.IP.String()
+		}
+	}
+	host, _, err := net.SplitHostPort(d.config.ConnectTo)
+	if err != nil {
+		return d.config.ConnectTo
+	}
+	return host
+}
+
 // GetConnectTo returns the server address for client mode.
 func (d *Daemon) GetConnectTo() string {
 	return d.config.ConnectTo
@@ -1375,6 +2847,8 @@ func (d *Daemon) signalConnectionFailure() {
 // internet connectivity is restored by removing VPN routes.
 // If auto-reconnect is enabled, it will attempt to reconnect with exponential backoff.
 func (d *Daemon) monitorConnectionFailure() {
+	defer d.recoverCrash()
+
 	select {
 	case <-d.ctx.Done():
 		// Normal shutdown - routes will be restored in shutdown()
@@ -1395,10 +2869,19 @@ func (d *Daemon) monitorConnectionFailure() {
 		log.Printf("[vpn] ========================================")
 		log.Printf("[vpn] VPN connection to server has been lost")
 
-		// Restore routing first
+		// Restore routing first (or block everything with the kill switch)
 		routeRestored := false
 		wasRoutingAll := d.config.RouteAll
-		if d.tun != nil && d.config.RouteAll {
+		if d.config.KillSwitch && wasRoutingAll && d.tun != nil {
+			if err := d.tun.EnableKillSwitch(d.serverPublicIP()); err != nil {
+				log.Printf("[vpn] ERROR: Failed to engage kill switch: %v", err)
+				log.Printf("[vpn] Falling back to restoring direct routing")
+			} else {
+				d.killSwitchEngaged.Store(true)
+				log.Printf("[vpn] KILL SWITCH: non-VPN traffic blocked until reconnection or 'vpn disconnect'")
+			}
+		}
+		if d.tun != nil && d.hasVPNRoutes() && !d.killSwitchEngaged.Load() {
 			log.Printf("[vpn] Restoring network routes to prevent internet loss...")
 			if err := d.tun.RestoreRouting(); err != nil {
 				log.Printf("[vpn] ERROR: Failed to restore routing: %v", err)
@@ -1419,6 +2902,11 @@ func (d *Daemon) monitorConnectionFailure() {
 			if serverRestarting {
 				reason = "Server restart notification received"
 			}
+			if d.killSwitchEngaged.Load() {
+				reason += " (kill switch engaged: non-VPN traffic blocked)"
+			} else if routeRestored {
+				reason += " (direct routing restored)"
+			}
 			d.store.WriteLifecycleEvent("CONNECTION_LOST", reason, uptime, wasRoutingAll, routeRestored, Version)
 		}
 
@@ -1433,16 +2921,40 @@ func (d *Daemon) monitorConnectionFailure() {
 	}
 }
 
+// Reconnect backoff: the delay starts at reconnectBaseDelay and doubles per
+// attempt, up to reconnectMaxDelay for the regular attempts and up to
+// autoReconnectMaxDelay with --auto-reconnect once those are used up.
+const (
+	reconnectBaseDelay    = time.Second
+	reconnectMaxDelay     = 30 * time.Second
+	autoReconnectMaxDelay = 5 * time.Minute
+)
+
+// reconnectDelay returns the wait before the given attempt (1-based): capped
+// exponential backoff with jitter, so clients cut off by the same server
+// restart don't all come back at the same instant.
+func reconnectDelay(attempt, maxRetries int) time.Duration {
+	var delay time.Duration
+	if attempt <= maxRetries {
+		delay = min(reconnectBaseDelay<<min(attempt-1, 16), reconnectMaxDelay)
+	} else {
+		delay = min(reconnectMaxDelay<<min(attempt-maxRetries, 16), autoReconnectMaxDelay)
+	}
+
+	// Equal jitter: half fixed, half random
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
 // attemptReconnect tries to reconnect to the server with exponential backoff.
-// Auto-reconnect is always enabled for client mode.
+// Auto-reconnect is always enabled for client mode; with AutoReconnect it
+// never gives up. Each attempt is recorded as a RECONNECT_ATTEMPT event.
 func (d *Daemon) attemptReconnect(restoreRouteAll bool) {
 	// Increment reconnection count for statistics
 	d.config.ReconnectCount++
-	maxRetries := 30 // Try for up to ~5 minutes with exponential backoff
-	baseDelay := time.Second
-	maxDelay := 30 * time.Second
+	maxRetries := 30 // Try for up to ~10 minutes with exponential backoff
 
-	for attempt := 1; attempt <= maxRetries; attempt++ {
+	for attempt := 1; attempt <= maxRetries || d.config.AutoReconnect; attempt++ {
 		select {
 		case <-d.ctx.Done():
 			log.Printf("[vpn] Reconnect cancelled - shutdown requested")
@@ -1450,14 +2962,16 @@ func (d *Daemon) attemptReconnect(restoreRouteAll bool) {
 		default:
 		}
 
-		// Calculate delay with exponential backoff
-		delay := baseDelay * time.Duration(1<<uint(attempt-1))
-		if delay > maxDelay {
-			delay = maxDelay
+		delay := reconnectDelay(attempt, maxRetries)
+		if attempt > maxRetries {
+			log.Printf("[vpn] Reconnect attempt %d (auto-reconnect) in %v...", attempt, delay.Round(time.Millisecond))
+		} else {
+			log.Printf("[vpn] Reconnect attempt %d/%d in %v...", attempt, maxRetries, delay.Round(time.Millisecond))
+		}
+		select {
+		case <-d.ctx.Done():
+		case <-time.After(delay):
 		}
-
-		log.Printf("[vpn] Reconnect attempt %d/%d in %v...", attempt, maxRetries, delay)
-		time.Sleep(delay)
 
 		select {
 		case <-d.ctx.Done():
@@ -1467,9 +2981,8 @@ func (d *Daemon) attemptReconnect(restoreRouteAll bool) {
 		}
 
 		// Close old connection if it exists
-		if d.vpnConn != nil {
-			d.vpnConn.Close()
-			d.vpnConn = nil
+		if old := d.vpnConn.Swap(nil); old != nil {
+			old.Close()
 		}
 
 		// Reset connection failure state for new connection
@@ -1485,10 +2998,14 @@ func (d *Daemon) attemptReconnect(restoreRouteAll bool) {
 			UseTLS:     d.config.UseTLS,
 			Key:        d.config.EncryptionKey,
 			Encryption: d.config.Encryption,
+
+			Compression: d.config.Compression,
+			Transport:   d.config.Transport,
 		}
 		conn, err := tunnel.Dial(dialCfg)
 		if err != nil {
 			log.Printf("[vpn] Reconnect failed: %v", err)
+			d.recordReconnectAttempt(attempt, err)
 			continue
 		}
 
@@ -1496,27 +3013,36 @@ func (d *Daemon) attemptReconnect(restoreRouteAll bool) {
 		hostname, _ := os.Hostname()
 		peerInfo := protocol.PeerInfo{
 			Hostname: hostname,
-			OS:       "darwin",
+			OS:       runtime.GOOS,
 			Version:  Version,
 			Geo:      d.ourGeo,
 			PublicIP: d.ourPublicIP,
 			RouteAll: d.config.RouteAll, // Connection Intent Protocol: tell server if routing is enabled
+			Cipher:   d.preferredCipher(),
 		}
-		if err := protocol.WriteHandshake(conn.NetConn, d.config.Encryption, peerInfo); err != nil {
+		if err := protocol.WriteHandshake(conn.NetConn, d.handshakeFlags(), peerInfo); err != nil {
 			log.Printf("[vpn] Handshake failed: %v", err)
+			d.recordReconnectAttempt(attempt, fmt.Errorf("handshake: %w", err))
 			conn.Close()
 			continue
 		}
 
 		// Read assigned IP
-		assignedIP, err := protocol.ReadAssignedIP(conn.NetConn)
+		assignedIP, serverMTU, cipher, err := protocol.ReadAssignedIP(conn.NetConn)
 		if err != nil {
 			log.Printf("[vpn] Failed to read assigned IP: %v", err)
+			d.recordReconnectAttempt(attempt, fmt.Errorf("reading assigned IP: %w", err))
+			conn.Close()
+			continue
+		}
+		if err := conn.SetCipher(cipher); err != nil {
+			log.Printf("[vpn] Failed to switch cipher: %v", err)
+			d.recordReconnectAttempt(attempt, fmt.Errorf("switching cipher: %w", err))
 			conn.Close()
 			continue
 		}
 
-		d.vpnConn = conn
+		d.vpnConn.Store(conn)
 		oldIP := d.config.VPNAddress
 		d.config.VPNAddress = assignedIP
 
@@ -1532,10 +3058,14 @@ func (d *Daemon) attemptReconnect(restoreRouteAll bool) {
 				log.Printf("[vpn] Warning: failed to reconfigure TUN: %v", err)
 				log.Printf("[vpn] Will attempt to continue with existing configuration")
 			}
+			d.startVPNControlServer()
 		}
 
-		// Restore route-all if it was enabled before
-		if restoreRouteAll && d.tun != nil {
+		// Restore route-all if it was enabled before (the kill switch kept
+		// the VPN routes in place)
+		if d.killSwitchEngaged.Load() {
+			log.Printf("[vpn] All traffic still routed through VPN")
+		} else if restoreRouteAll && d.tun != nil {
 			serverIP := d.config.ConnectTo
 			if host, _, err := net.SplitHostPort(serverIP); err == nil {
 				serverIP = host
@@ -1546,11 +3076,23 @@ func (d *Daemon) attemptReconnect(restoreRouteAll bool) {
 				d.config.RouteAll = true
 				log.Printf("[vpn] All traffic now routed through VPN")
 			}
+		} else if len(d.config.RouteSubnets) > 0 && d.tun != nil {
+			d.routeSubnets()
+		}
+
+		reason := fmt.Sprintf("Reconnected after %d attempts", attempt)
+		if d.killSwitchEngaged.Load() {
+			if err := tunnel.DisableKillSwitch(); err != nil {
+				log.Printf("[vpn] Warning: %v", err)
+			} else {
+				d.killSwitchEngaged.Store(false)
+				reason += " (kill switch released)"
+			}
 		}
 
 		// Record reconnection success
 		if d.store != nil {
-			d.store.WriteLifecycleEvent("RECONNECTED", fmt.Sprintf("Reconnected after %d attempts", attempt), 0, d.config.RouteAll, false, Version)
+			d.store.WriteLifecycleEvent("RECONNECTED", reason, 0, d.config.RouteAll, false, Version)
 		}
 
 		// Restart packet forwarding goroutines
@@ -1560,6 +3102,10 @@ func (d *Daemon) attemptReconnect(restoreRouteAll bool) {
 		// Restart connection failure monitor (recursive, but will only run once)
 		go d.monitorConnectionFailure()
 
+		if d.tun != nil {
+			d.negotiateMTU(serverMTU)
+		}
+
 		return
 	}
 
@@ -1568,7 +3114,10 @@ func (d *Daemon) attemptReconnect(restoreRouteAll bool) {
 	log.Printf("[vpn] RECONNECT FAILED")
 	log.Printf("[vpn] ========================================")
 	log.Printf("[vpn] All %d reconnect attempts failed", maxRetries)
-	log.Printf("[vpn] Giving up. Restart vpn-node manually to reconnect.")
+	log.Printf("[vpn] Giving up. Restart vpn-node manually to reconnect (or run it with --auto-reconnect).")
+	if d.killSwitchEngaged.Load() {
+		log.Printf("[vpn] Kill switch stays engaged: run 'sudo vpn restore' to get direct internet back")
+	}
 
 	// Record failure
 	if d.store != nil {
@@ -1578,3 +3127,13 @@ func (d *Daemon) attemptReconnect(restoreRouteAll bool) {
 	// Trigger daemon shutdown
 	d.cancel()
 }
+
+// recordReconnectAttempt records a failed reconnect attempt as a
+// RECONNECT_ATTEMPT lifecycle event.
+func (d *Daemon) recordReconnectAttempt(attempt int, err error) {
+	if d.store == nil {
+		return
+	}
+	reason := fmt.Sprintf("Attempt %d failed: %v", attempt, err)
+	d.store.WriteLifecycleEvent("RECONNECT_ATTEMPT", reason, 0, false, false, Version)
+}
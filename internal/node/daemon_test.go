@@ -0,0 +1,197 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miguelemosreverte/vpn/internal/protocol"
+	"github.com/miguelemosreverte/vpn/internal/store"
+	"github.com/miguelemosreverte/vpn/internal/tunnel"
+)
+
+// startTestServer starts a server daemon whose store lives in dataDir,
+// accepting VPN clients on a loopback port (no TUN device), and restores IP
+// allocation the way Run does on startup.
+func startTestServer(t *testing.T, dataDir string) *Daemon {
+	t.Helper()
+	return startTestServerConfig(t, Config{DataDir: dataDir})
+}
+
+// startTestServerConfig is startTestServer with more settings than DataDir.
+func startTestServerConfig(t *testing.T, cfg Config) *Daemon {
+	t.Helper()
+
+	cfg.NodeName = "server"
+	cfg.ServerMode = true
+	d := New(cfg)
+	s, err := store.New(cfg.DataDir, store.Options{})
+	if err != nil {
+		t.Fatalf("store.New: %v", err)
+	}
+	d.store = s
+	d.restoreNextIP()
+
+	listener, err := tunnel.Listen(tunnel.ListenConfig{Address: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatalf("tunnel.Listen: %v", err)
+	}
+	d.vpnListener = listener
+	go d.acceptVPNConnections()
+	return d
+}
+
+func stopTestServer(t *testing.T, d *Daemon) {
+	t.Helper()
+	d.cancel()
+	d.vpnListener.Close()
+	if err := d.store.Close(); err != nil {
+		t.Fatalf("closing store: %v", err)
+	}
+}
+
+// connectTestClient runs a client handshake with flags against d and waits
+// until d has registered the client, returning the connection and the
+// assigned IP.
+func connectTestClient(t *testing.T, d *Daemon, hostname string, flags protocol.HandshakeFlags) (*tunnel.Conn, string) {
+	t.Helper()
+
+	conn, err := tunnel.Dial(tunnel.DialConfig{Address: d.vpnListener.Addr().String()})
+	if err != nil {
+		t.Fatalf("tunnel.Dial: %v", err)
+	}
+	conn.NetConn.SetDeadline(time.Now().Add(5 * time.Second))
+	info := protocol.PeerInfo{Hostname: hostname, OS: "linux", Version: "test"}
+	if err := protocol.WriteHandshake(conn.NetConn, flags, info); err != nil {
+		t.Fatalf("WriteHandshake: %v", err)
+	}
+	ip, _, _, err := protocol.ReadAssignedIP(conn.NetConn)
+	if err != nil {
+		t.Fatalf("ReadAssignedIP: %v", err)
+	}
+
+	waitFor(t, hostname+" registered", func() bool {
+		d.peerConnsMu.RLock()
+		defer d.peerConnsMu.RUnlock()
+		return d.peerConns[ip] != nil
+	})
+	return conn, ip
+}
+
+// disconnectTestClient closes a client connection and waits until d has
+// dropped the peer, so nothing is left writing to d's store.
+func disconnectTestClient(t *testing.T, d *Daemon, conn *tunnel.Conn, ip string) {
+	t.Helper()
+	conn.Close()
+	waitFor(t, ip+" unregistered", func() bool {
+		d.peerConnsMu.RLock()
+		defer d.peerConnsMu.RUnlock()
+		return d.peerConns[ip] == nil
+	})
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAssignedIPStableAcrossRestart(t *testing.T) {
+	dataDir := t.TempDir()
+
+	d := startTestServer(t, dataDir)
+	conn, laptop := connectTestClient(t, d, "laptop", 0)
+	disconnectTestClient(t, d, conn, laptop)
+	stopTestServer(t, d)
+
+	d = startTestServer(t, dataDir)
+	defer stopTestServer(t, d)
+
+	conn, got := connectTestClient(t, d, "laptop", 0)
+	defer disconnectTestClient(t, d, conn, got)
+	if got != laptop {
+		t.Errorf("laptop after restart: got %s, want %s", got, laptop)
+	}
+
+	// A new client must not be handed the IP assigned before the restart
+	conn, phone := connectTestClient(t, d, "phone", 0)
+	defer disconnectTestClient(t, d, conn, phone)
+	if phone == laptop {
+		t.Errorf("phone got %s, already assigned before the restart", phone)
+	}
+}
+
+func TestAssignIPStableAcrossReconnect(t *testing.T) {
+	d := startTestServer(t, t.TempDir())
+	defer stopTestServer(t, d)
+
+	ip := d.assignIP("laptop", "203.0.113.10")
+
+	// While connected the IP is not handed to anyone else
+	d.peers[ip] = &Peer{Name: "laptop", VPNAddress: ip}
+	if got := d.assignIP("phone", "203.0.113.20"); got == ip {
+		t.Fatalf("phone got %s while laptop holds it", got)
+	}
+
+	// Dropped (e.g. a network change), then back
+	delete(d.peers, ip)
+
+	if got := d.assignIP("laptop", "203.0.113.10"); got != ip {
+		t.Errorf("reconnect: got %s, want %s", got, ip)
+	}
+	// A new public IP (another network) still maps back by hostname
+	if got := d.assignIP("laptop", "198.51.100.7"); got != ip {
+		t.Errorf("reconnect from a new network: got %s, want %s", got, ip)
+	}
+}
+
+func TestPeerTimeoutOnlyReapsPingingClients(t *testing.T) {
+	d := startTestServerConfig(t, Config{DataDir: t.TempDir(), PeerTimeoutSeconds: 1})
+	defer stopTestServer(t, d)
+
+	oldConn, oldIP := connectTestClient(t, d, "old-client", 0)
+	defer disconnectTestClient(t, d, oldConn, oldIP)
+	_, newIP := connectTestClient(t, d, "new-client", protocol.HandshakePing)
+
+	// Both stay silent: the client that promised PINGs is dropped
+	waitFor(t, "silent pinging client dropped", func() bool {
+		d.peerConnsMu.RLock()
+		defer d.peerConnsMu.RUnlock()
+		return d.peerConns[newIP] == nil
+	})
+	d.peerConnsMu.RLock()
+	kept := d.peerConns[oldIP] != nil
+	d.peerConnsMu.RUnlock()
+	if !kept {
+		t.Error("client without PING support was dropped")
+	}
+}
+
+func TestReconnectDelay(t *testing.T) {
+	const maxRetries = 30
+	tests := []struct {
+		attempt int
+		want    time.Duration // Before jitter
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{5, 16 * time.Second},
+		{6, reconnectMaxDelay},
+		{maxRetries, reconnectMaxDelay},
+		{maxRetries + 1, 2 * reconnectMaxDelay},
+		{maxRetries + 3, 8 * reconnectMaxDelay},
+		{maxRetries + 4, autoReconnectMaxDelay},
+		{1000, autoReconnectMaxDelay},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			got := reconnectDelay(tt.attempt, maxRetries)
+			if got < tt.want/2 || got > tt.want {
+				t.Fatalf("attempt %d: delay %v outside [%v, %v]", tt.attempt, got, tt.want/2, tt.want)
+			}
+		}
+	}
+}
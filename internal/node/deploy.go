@@ -10,6 +10,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
@@ -48,15 +49,22 @@ func (d *Daemon) StartDeployServer(addr string) error {
 	return nil
 }
 
-// handleHealth returns a simple health check.
+// handleHealth returns a simple health check. 'vpn deploy --rolling' waits
+// for deploying to clear before moving on to the next node.
 func (d *Daemon) handleHealth(w http.ResponseWriter, r *http.Request) {
+	health := map[string]interface{}{
+		"status":    "healthy",
+		"node":      d.config.NodeName,
+		"uptime":    d.Uptime().String(),
+		"version":   Version,
+		"deploying": d.deploying.Load(),
+	}
+	if err := d.deployErr.Load(); err != nil {
+		health["deploy_error"] = *err
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":  "healthy",
-		"node":    d.config.NodeName,
-		"uptime":  d.Uptime().String(),
-		"version": Version,
-	})
+	json.NewEncoder(w).Encode(health)
 }
 
 // handleDeploy handles the deploy webhook.
@@ -79,8 +87,18 @@ func (d *Daemon) handleDeploy(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("[deploy] Received deploy request: ref=%s branch=%s", req.Ref, req.Branch)
 
-	// Respond immediately (async deployment)
 	w.Header().Set("Content-Type", "application/json")
+	if !d.deploying.CompareAndSwap(false, true) {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(DeployResponse{
+			Success: false,
+			Message: "Deploy already in progress",
+			Node:    d.config.NodeName,
+		})
+		return
+	}
+
+	// Respond immediately (async deployment)
 	w.WriteHeader(http.StatusAccepted)
 	json.NewEncoder(w).Encode(DeployResponse{
 		Success: true,
@@ -92,14 +110,35 @@ func (d *Daemon) handleDeploy(w http.ResponseWriter, r *http.Request) {
 	go d.performDeploy(req)
 }
 
-// performDeploy does the actual deployment work.
+// performDeploy does the actual deployment work. The caller has set
+// d.deploying, which stays set through a restart.
 func (d *Daemon) performDeploy(req DeployRequest) {
+	defer d.deploying.Store(false)
+
+	d.deployErr.Store(nil)
+	updates, err := d.deployLocal(req)
+	if err != nil {
+		msg := err.Error()
+		d.deployErr.Store(&msg)
+		return
+	}
+
+	// 4. Server-only: Broadcast UPDATE_AVAILABLE to all connected peers
+	if d.config.ServerMode {
+		d.broadcastUpdate()
+	}
+
+	d.finishDeploy(updates)
+}
+
+// deployLocal pulls and rebuilds this node (deploy steps 1-3).
+func (d *Daemon) deployLocal(req DeployRequest) (VersionUpdates, error) {
 	log.Printf("[deploy] Starting deployment on %s (server=%v)", d.config.NodeName, d.config.ServerMode)
 
 	// 1. Git pull
 	if err := d.gitPull(); err != nil {
 		log.Printf("[deploy] Git pull failed: %v", err)
-		return
+		return VersionUpdates{}, fmt.Errorf("git pull failed: %w", err)
 	}
 
 	// 2. Check what needs updating based on VERSION files
@@ -109,17 +148,17 @@ func (d *Daemon) performDeploy(req DeployRequest) {
 	if updates.RebuildNode || updates.RebuildCLI {
 		if err := d.rebuildBinariesSelective(updates); err != nil {
 			log.Printf("[deploy] Rebuild failed: %v", err)
-			return
+			return updates, fmt.Errorf("rebuild failed: %w", err)
 		}
 	} else {
 		log.Printf("[deploy] No rebuilds needed")
 	}
 
-	// 4. Server-only: Broadcast UPDATE_AVAILABLE to all connected peers
-	if d.config.ServerMode {
-		d.broadcastUpdate()
-	}
+	return updates, nil
+}
 
+// finishDeploy restarts the node if the deploy requires it (deploy step 5).
+func (d *Daemon) finishDeploy(updates VersionUpdates) {
 	// 5. Restart logic:
 	// - SERVER: Restart if frozen/cold layer changed (core/websocket)
 	// - CLIENT: NEVER restart automatically. VPN stability is more important.
@@ -130,7 +169,7 @@ func (d *Daemon) performDeploy(req DeployRequest) {
 			log.Printf("[deploy] Node restart required (core/websocket changed), scheduling...")
 			// Give peers time to receive the update notification
 			time.Sleep(2 * time.Second)
-			d.scheduleRestart()
+			d.scheduleRestart("deploy")
 		} else {
 			// Client mode: DO NOT restart. Log that a restart would be needed.
 			log.Printf("[deploy] Core/websocket updated but client will NOT restart automatically")
@@ -151,6 +190,31 @@ type VersionUpdates struct {
 	RestartNode bool // Restart vpn-node service (interrupts VPN)
 }
 
+// add merges the requirements of other into u.
+func (u *VersionUpdates) add(other VersionUpdates) {
+	u.RebuildNode = u.RebuildNode || other.RebuildNode
+	u.RebuildCLI = u.RebuildCLI || other.RebuildCLI
+	u.RestartNode = u.RestartNode || other.RestartNode
+}
+
+// versionLayers are the service layers with a services/<layer>/VERSION file.
+var versionLayers = []string{"core", "websocket", "cli", "ui"}
+
+// layerUpdates returns what a VERSION change in a service layer requires.
+func layerUpdates(layer string) VersionUpdates {
+	switch layer {
+	case "core":
+		// CLI depends on some node packages
+		return VersionUpdates{RebuildNode: true, RebuildCLI: true, RestartNode: true}
+	case "websocket":
+		return VersionUpdates{RebuildNode: true, RestartNode: true}
+	case "cli", "ui":
+		// HOT update: UI is built into the CLI binary, NO node restart
+		return VersionUpdates{RebuildCLI: true}
+	}
+	return VersionUpdates{}
+}
+
 // checkVersionChanges checks VERSION files to determine what changed.
 // Service layers:
 //   - core, websocket: FROZEN/COLD - requires node restart
@@ -179,9 +243,7 @@ func (d *Daemon) checkVersionChanges() VersionUpdates {
 		} else if coreVersion != storedCoreVersion {
 			// Version actually changed
 			log.Printf("[deploy] Core version changed: %s -> %s", storedCoreVersion, coreVersion)
-			updates.RebuildNode = true
-			updates.RebuildCLI = true // CLI depends on some node packages
-			updates.RestartNode = true
+			updates.add(layerUpdates("core"))
 			d.storeVersion("core", coreVersion)
 		}
 	}
@@ -196,8 +258,7 @@ func (d *Daemon) checkVersionChanges() VersionUpdates {
 			d.storeVersion("websocket", wsVersion)
 		} else if wsVersion != storedWSVersion {
 			log.Printf("[deploy] WebSocket version changed: %s -> %s", storedWSVersion, wsVersion)
-			updates.RebuildNode = true
-			updates.RestartNode = true
+			updates.add(layerUpdates("websocket"))
 			d.storeVersion("websocket", wsVersion)
 		}
 	}
@@ -215,8 +276,7 @@ func (d *Daemon) checkVersionChanges() VersionUpdates {
 			d.storeVersion("cli", cliVersion)
 		} else if cliVersion != storedCLIVersion {
 			log.Printf("[deploy] CLI version changed: %s -> %s (HOT update, no restart)", storedCLIVersion, cliVersion)
-			updates.RebuildCLI = true
-			// NO RestartNode - this is a hot update!
+			updates.add(layerUpdates("cli"))
 			d.storeVersion("cli", cliVersion)
 		}
 	}
@@ -231,8 +291,7 @@ func (d *Daemon) checkVersionChanges() VersionUpdates {
 			d.storeVersion("ui", uiVersion)
 		} else if uiVersion != storedUIVersion {
 			log.Printf("[deploy] UI version changed: %s -> %s (HOT update, no restart)", storedUIVersion, uiVersion)
-			updates.RebuildCLI = true // UI is built into CLI binary
-			// NO RestartNode - this is a hot update!
+			updates.add(layerUpdates("ui"))
 			d.storeVersion("ui", uiVersion)
 		}
 	}
@@ -248,6 +307,68 @@ func (d *Daemon) checkVersionChanges() VersionUpdates {
 	return updates
 }
 
+// planUpdate reports what an update would do without changing anything:
+// it fetches origin/main and compares its VERSION files with the versions
+// recorded by the last deploy, using the same rules as checkVersionChanges.
+func (d *Daemon) planUpdate() (*protocol.UpdatePlan, error) {
+	projectRoot := d.findProjectRoot()
+	if projectRoot == "" {
+		return nil, fmt.Errorf("could not find project root")
+	}
+
+	git := func(args ...string) (string, error) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = projectRoot
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("git %s failed: %w: %s", args[0], err, output)
+		}
+		return strings.TrimSpace(string(output)), nil
+	}
+
+	log.Printf("[deploy] Dry run: fetching origin/main in %s", projectRoot)
+	if _, err := git("fetch", "origin", "main"); err != nil {
+		return nil, err
+	}
+
+	diff, err := git("diff", "--name-only", "HEAD", "origin/main")
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &protocol.UpdatePlan{ChangedFiles: []string{}}
+	if diff != "" {
+		plan.ChangedFiles = strings.Split(diff, "\n")
+	}
+
+	var updates VersionUpdates
+	for _, layer := range versionLayers {
+		newVersion, err := git("show", "origin/main:services/"+layer+"/VERSION")
+		if err != nil || newVersion == "" {
+			continue // Layer has no VERSION file upstream
+		}
+		// A layer seen for the first time is only initialized, never rebuilt
+		oldVersion := d.readStoredVersion(layer)
+		if oldVersion == "" || oldVersion == newVersion {
+			continue
+		}
+		plan.Versions = append(plan.Versions, protocol.VersionChange{
+			Layer: layer,
+			From:  oldVersion,
+			To:    newVersion,
+		})
+		updates.add(layerUpdates(layer))
+	}
+
+	plan.RebuildNode = updates.RebuildNode
+	plan.RebuildCLI = updates.RebuildCLI
+	plan.RestartNode = updates.RestartNode
+	// Clients never restart automatically (see performDeploy)
+	plan.WillRestart = updates.RestartNode && d.config.ServerMode
+
+	return plan, nil
+}
+
 // gitPull performs git pull in the project directory.
 func (d *Daemon) gitPull() error {
 	projectRoot := d.findProjectRoot()
@@ -255,6 +376,9 @@ func (d *Daemon) gitPull() error {
 		return fmt.Errorf("could not find project root")
 	}
 
+	// Remember the current commit so a bad deploy can be rolled back
+	before, _ := d.gitHead(projectRoot)
+
 	log.Printf("[deploy] Running git pull in %s", projectRoot)
 
 	cmd := exec.Command("git", "pull", "origin", "main")
@@ -267,9 +391,92 @@ func (d *Daemon) gitPull() error {
 		return fmt.Errorf("git pull failed: %w: %s", err, output)
 	}
 
+	// Only a pull that moved HEAD replaces the rollback target
+	if after, _ := d.gitHead(projectRoot); before != "" && after != before && d.store != nil {
+		if err := d.store.SavePreviousDeploy(before); err != nil {
+			log.Printf("[deploy] Failed to record previous commit: %v", err)
+		}
+	}
+
 	return nil
 }
 
+// gitHead returns the commit checked out in projectRoot.
+func (d *Daemon) gitHead(projectRoot string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = projectRoot
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// performRollback resets the checkout to the commit recorded before the
+// last deploy, rebuilds both binaries and records a ROLLBACK lifecycle
+// event. The caller restarts the node. It returns the commits rolled back
+// from and to.
+func (d *Daemon) performRollback() (string, string, error) {
+	if d.store == nil {
+		return "", "", fmt.Errorf("storage not initialized")
+	}
+	projectRoot := d.findProjectRoot()
+	if projectRoot == "" {
+		return "", "", fmt.Errorf("could not find project root")
+	}
+
+	previous, err := d.store.GetPreviousDeploy()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read previous commit: %w", err)
+	}
+	if previous == "" {
+		return "", "", fmt.Errorf("no previous version recorded (no deploy has changed this node since it started recording)")
+	}
+
+	current, err := d.gitHead(projectRoot)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read current commit: %w", err)
+	}
+	if current == previous {
+		return "", "", fmt.Errorf("already at previous version %s", shortSHA(previous))
+	}
+
+	log.Printf("[deploy] Rolling back %s -> %s", shortSHA(current), shortSHA(previous))
+
+	// Reset (not checkout) so the branch stays attached and the next
+	// deploy can pull again
+	cmd := exec.Command("git", "reset", "--hard", previous)
+	cmd.Dir = projectRoot
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", "", fmt.Errorf("git reset failed: %w: %s", err, output)
+	}
+
+	// Record the rolled-back VERSION files; rebuild everything regardless,
+	// since code can change without a VERSION bump
+	d.checkVersionChanges()
+	if err := d.rebuildBinariesSelective(VersionUpdates{RebuildNode: true, RebuildCLI: true, RestartNode: true}); err != nil {
+		return "", "", fmt.Errorf("rebuild failed: %w", err)
+	}
+
+	// One step back only: a second rollback needs a new deploy first
+	if err := d.store.SavePreviousDeploy(""); err != nil {
+		log.Printf("[deploy] Failed to clear previous commit: %v", err)
+	}
+
+	reason := fmt.Sprintf("Rolled back %s -> %s", shortSHA(current), shortSHA(previous))
+	d.store.WriteLifecycleEvent("ROLLBACK", reason, d.Uptime().Seconds(), d.config.RouteAll, false, Version)
+
+	return current, previous, nil
+}
+
+// shortSHA abbreviates a commit hash for logs and messages.
+func shortSHA(sha string) string {
+	if len(sha) > 8 {
+		return sha[:8]
+	}
+	return sha
+}
+
 // rebuildBinariesSelective rebuilds only the binaries that changed.
 func (d *Daemon) rebuildBinariesSelective(updates VersionUpdates) error {
 	projectRoot := d.findProjectRoot()
@@ -392,11 +599,110 @@ func (d *Daemon) broadcastUpdate() {
 	}
 }
 
+// notifyPeerUpdate sends UPDATE_AVAILABLE to one connected peer.
+func (d *Daemon) notifyPeerUpdate(vpnIP string) error {
+	d.peerConnsMu.RLock()
+	conn, ok := d.peerConns[vpnIP]
+	d.peerConnsMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("not connected")
+	}
+	return conn.WritePacket(protocol.MakeControlMessage(protocol.CmdUpdateAvailable))
+}
+
+const (
+	// rollingSettleTime lets a notified peer start its deploy before it is checked
+	rollingSettleTime = 10 * time.Second
+
+	// rollingHealthTimeout is how long a notified peer may stay silent
+	rollingHealthTimeout = 2 * time.Minute
+)
+
+// updatePeersRolling tells connected peers to update one at a time, waiting
+// for each to be healthy before the next. The rollout stops at the first
+// peer that fails.
+func (d *Daemon) updatePeersRolling(result *protocol.UpdateResult) {
+	peers := d.connectedPeers()
+	for i, p := range peers {
+		log.Printf("[deploy] Rolling update %d/%d: %s (%s)", i+1, len(peers), p.Name, p.VPNAddress)
+
+		notifiedAt := time.Now()
+		err := d.notifyPeerUpdate(p.VPNAddress)
+		if err == nil {
+			err = d.waitPeerHealthy(p.VPNAddress, notifiedAt)
+		}
+		if err != nil {
+			log.Printf("[deploy] Rolling update stopped at %s: %v", p.Name, err)
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", p.Name, err))
+			for _, rest := range peers[i+1:] {
+				result.Errors = append(result.Errors, fmt.Sprintf("%s: skipped (rollout stopped)", rest.Name))
+			}
+			return
+		}
+		result.Updated = append(result.Updated, p.Name)
+	}
+}
+
+// updatePeers tells all connected peers to update at once.
+func (d *Daemon) updatePeers(result *protocol.UpdateResult) {
+	for _, p := range d.connectedPeers() {
+		if err := d.notifyPeerUpdate(p.VPNAddress); err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", p.Name, err))
+			continue
+		}
+		result.Updated = append(result.Updated, p.Name)
+	}
+}
+
+// waitPeerHealthy waits until a peer told to update at since is heard from
+// again after rollingSettleTime, still or again connected. Clients serve no
+// /health endpoint and their control socket is loopback-only, so traffic
+// over the tunnel (at least the keepalive PONGs) is the health signal.
+func (d *Daemon) waitPeerHealthy(vpnIP string, since time.Time) error {
+	settled := since.Add(rollingSettleTime)
+	deadline := settled.Add(rollingHealthTimeout)
+
+	for {
+		d.mu.RLock()
+		peer, ok := d.peers[vpnIP]
+		healthy := ok && peer.LastSeen.After(settled)
+		d.mu.RUnlock()
+
+		if healthy {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("not heard from within %s of the update", rollingSettleTime+rollingHealthTimeout)
+		}
+
+		select {
+		case <-d.ctx.Done():
+			return fmt.Errorf("node shutting down")
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// connectedPeers returns a snapshot of the connected peers sorted by VPN address.
+func (d *Daemon) connectedPeers() []Peer {
+	d.mu.RLock()
+	peers := make([]Peer, 0, len(d.peers))
+	for _, p := range d.peers {
+		peers = append(peers, *p)
+	}
+	d.mu.RUnlock()
+
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].VPNAddress < peers[j].VPNAddress
+	})
+	return peers
+}
+
 // scheduleRestart performs a graceful restart of the node by exec'ing the new binary.
 // This replaces the current process with the newly built binary while preserving
-// command-line arguments and environment.
-func (d *Daemon) scheduleRestart() {
-	log.Printf("[deploy] Preparing to restart node with new binary...")
+// command-line arguments and environment. reason is recorded with the STOP event.
+func (d *Daemon) scheduleRestart(reason string) {
+	log.Printf("[deploy] Preparing to restart node (%s)...", reason)
 
 	// Get the path to the currently running executable
 	executable, err := os.Executable()
@@ -414,8 +720,13 @@ func (d *Daemon) scheduleRestart() {
 
 	log.Printf("[deploy] Restarting: %s %v", executable, os.Args[1:])
 
-	// Perform graceful shutdown first
-	d.shutdown()
+	// Keep forwarding route-all clients while we restart; the new process
+	// re-applies NAT (EnableNAT skips rules that exist) and removes it on
+	// its own shutdown. If exec fails, the service manager's restart does.
+	d.keepNAT.Store(true)
+
+	// Perform graceful shutdown first (restores routes before anything else)
+	d.shutdownWithReason("restart: " + reason)
 
 	// Small delay to ensure cleanup completes
 	time.Sleep(500 * time.Millisecond)
@@ -502,6 +813,11 @@ func (d *Daemon) storeVersion(name, version string) {
 func (d *Daemon) HandleUpdateMessage() {
 	log.Printf("[deploy] Received UPDATE_AVAILABLE from server")
 
+	if !d.deploying.CompareAndSwap(false, true) {
+		log.Printf("[deploy] Deploy already in progress, ignoring")
+		return
+	}
+
 	// Perform the same deployment steps
 	go d.performDeploy(DeployRequest{})
 }
@@ -0,0 +1,112 @@
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// metricsPushInterval is how often metrics are pushed to MetricsPushURL.
+	metricsPushInterval = 15 * time.Second
+
+	// metricsPushMaxBackoff caps the wait between pushes while the
+	// collector is failing.
+	metricsPushMaxBackoff = 5 * time.Minute
+)
+
+// metricsPushPayload is the JSON body posted to MetricsPushURL.
+type metricsPushPayload struct {
+	Node       string             `json:"node"`
+	VPNAddress string             `json:"vpn_address"`
+	Version    string             `json:"version"`
+	Timestamp  time.Time          `json:"timestamp"`
+	Metrics    map[string]float64 `json:"metrics"`
+}
+
+// metricsPushLoop posts the current standard and bandwidth metrics to
+// MetricsPushURL every metricsPushInterval. While the collector fails, the
+// interval doubles up to metricsPushMaxBackoff; samples missed meanwhile are
+// not resent. It runs on its own goroutine, so a slow or unreachable
+// collector never delays metrics collection.
+func (d *Daemon) metricsPushLoop() {
+	defer d.recoverCrash()
+
+	url := d.config.MetricsPushURL
+	log.Printf("[metrics] Pushing metrics to %s every %s", url, metricsPushInterval)
+
+	wait := metricsPushInterval
+	failing := false
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		err := postMetrics(url, d.metricsPushPayload())
+		if err == nil {
+			if failing {
+				log.Printf("[metrics] Push to %s recovered", url)
+			}
+			failing = false
+			wait = metricsPushInterval
+			continue
+		}
+
+		wait *= 2
+		if wait > metricsPushMaxBackoff {
+			wait = metricsPushMaxBackoff
+		}
+		if !failing {
+			log.Printf("[metrics] WARN: push to %s failed: %v (retrying with backoff, up to %s)", url, err, metricsPushMaxBackoff)
+		}
+		failing = true
+	}
+}
+
+// metricsPushPayload snapshots the metrics the collector records.
+func (d *Daemon) metricsPushPayload() metricsPushPayload {
+	metrics := make(map[string]float64)
+	if d.standardMetrics != nil {
+		for k, v := range d.standardMetrics.Source()() {
+			metrics[k] = v
+		}
+	}
+	if d.bandwidthTracker != nil {
+		for k, v := range d.bandwidthTracker.Source()() {
+			metrics[k] = v
+		}
+	}
+
+	return metricsPushPayload{
+		Node:       d.config.NodeName,
+		VPNAddress: d.config.VPNAddress,
+		Version:    Version,
+		Timestamp:  time.Now(),
+		Metrics:    metrics,
+	}
+}
+
+// postMetrics posts the payload as JSON to url.
+func postMetrics(url string, p metricsPushPayload) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
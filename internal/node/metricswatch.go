@@ -0,0 +1,101 @@
+package node
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/miguelemosreverte/vpn/internal/protocol"
+	"github.com/miguelemosreverte/vpn/internal/store"
+)
+
+// metricsHub fans out each batch the collector writes to stats_watch
+// subscribers.
+type metricsHub struct {
+	mu   sync.Mutex
+	subs map[chan []store.MetricPoint]struct{}
+}
+
+func newMetricsHub() *metricsHub {
+	return &metricsHub{subs: make(map[chan []store.MetricPoint]struct{})}
+}
+
+// subscribe returns a channel receiving every new batch of metrics. A
+// subscriber that falls behind misses batches rather than blocking the
+// collector.
+func (h *metricsHub) subscribe() chan []store.MetricPoint {
+	ch := make(chan []store.MetricPoint, 8)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *metricsHub) unsubscribe(ch chan []store.MetricPoint) {
+	h.mu.Lock()
+	delete(h.subs, ch)
+	h.mu.Unlock()
+}
+
+// publish is the collector's OnWrite callback, so it must not block.
+func (h *metricsHub) publish(points []store.MetricPoint) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- points:
+		default:
+		}
+	}
+}
+
+// handleStatsWatch streams metric points as the collector writes them (every
+// second), until the client disconnects.
+func (d *Daemon) handleStatsWatch(enc *json.Encoder, req *protocol.Request) {
+	if d.store == nil || d.metricsHub == nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeStorageUnavailable, "storage not initialized")
+		return
+	}
+
+	var params protocol.StatsWatchParams
+	if req.Params != nil {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, "invalid params")
+			return
+		}
+	}
+	wanted := make(map[string]bool, len(params.Metrics))
+	for _, name := range params.Metrics {
+		wanted[name] = true
+	}
+
+	sub := d.metricsHub.subscribe()
+	defer d.metricsHub.unsubscribe(sub)
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case batch := <-sub:
+			points := make([]protocol.MetricPoint, 0, len(batch))
+			for _, p := range batch {
+				if len(wanted) > 0 && !wanted[p.Name] {
+					continue
+				}
+				points = append(points, protocol.MetricPoint{
+					Timestamp:   p.Timestamp.Format(time.RFC3339Nano),
+					Name:        p.Name,
+					Value:       p.Value,
+					Granularity: "raw",
+					Tags:        p.Tags,
+				})
+			}
+			if len(points) == 0 {
+				continue
+			}
+			if err := d.sendChunk(enc, req.ID, protocol.StatsWatchResult{Points: points}); err != nil {
+				return // Client went away
+			}
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package node
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/miguelemosreverte/vpn/internal/protocol"
+)
+
+// peersWatchInterval is how often peers_stream resends the list when
+// nothing changed, so clients that went away are noticed.
+const peersWatchInterval = 30 * time.Second
+
+// peersHub fans out the peer list to peers_stream subscribers whenever it
+// changes: on the server when broadcastPeerList runs, on a client when the
+// server's PEER_LIST arrives.
+type peersHub struct {
+	mu   sync.Mutex
+	subs map[chan []protocol.PeerListEntry]struct{}
+}
+
+func newPeersHub() *peersHub {
+	return &peersHub{subs: make(map[chan []protocol.PeerListEntry]struct{})}
+}
+
+// subscribe returns a channel receiving every new peer list. A subscriber
+// that falls behind misses lists rather than blocking the publisher; the
+// next one is complete anyway.
+func (h *peersHub) subscribe() chan []protocol.PeerListEntry {
+	ch := make(chan []protocol.PeerListEntry, 4)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *peersHub) unsubscribe(ch chan []protocol.PeerListEntry) {
+	h.mu.Lock()
+	delete(h.subs, ch)
+	h.mu.Unlock()
+}
+
+// publish must not block: it runs on the connection paths.
+func (h *peersHub) publish(peers []protocol.PeerListEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- peers:
+		default:
+		}
+	}
+}
+
+// currentPeerList returns the peer list peers_stream starts from: the one
+// the server broadcasts, or the last one a client received.
+func (d *Daemon) currentPeerList() []protocol.PeerListEntry {
+	if d.config.ServerMode {
+		return d.peerList()
+	}
+	d.networkPeersMu.RLock()
+	defer d.networkPeersMu.RUnlock()
+	return append([]protocol.PeerListEntry(nil), d.networkPeers...)
+}
+
+// handlePeersStream sends the peer list now and again whenever a peer
+// connects or disconnects, until the client disconnects.
+func (d *Daemon) handlePeersStream(enc *json.Encoder, req *protocol.Request) {
+	sub := d.peersHub.subscribe()
+	defer d.peersHub.unsubscribe(sub)
+
+	ticker := time.NewTicker(peersWatchInterval)
+	defer ticker.Stop()
+
+	peers := d.currentPeerList()
+	for {
+		if peers == nil {
+			peers = []protocol.PeerListEntry{}
+		}
+		if err := d.sendChunk(enc, req.ID, protocol.PeersStreamResult{Peers: peers}); err != nil {
+			return // Client went away
+		}
+
+		select {
+		case <-d.ctx.Done():
+			return
+		case peers = <-sub:
+		case <-ticker.C:
+			peers = d.currentPeerList()
+		}
+	}
+}
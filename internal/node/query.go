@@ -0,0 +1,201 @@
+package node
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/miguelemosreverte/vpn/internal/protocol"
+	"github.com/miguelemosreverte/vpn/internal/store"
+)
+
+// QueryLogs answers a "logs" request (without Peer or Follow) from st, the
+// way the control socket does. It lets 'vpn logs --data-dir' read the
+// database of a node that is not running. Errors are *protocol.ControlError.
+func QueryLogs(st *store.Store, params protocol.LogsParams) (*protocol.LogsResult, error) {
+	query, err := logQuery(params)
+	if err != nil {
+		return nil, invalidParams("%v", err)
+	}
+	result, err := st.QueryLogs(query)
+	if err != nil {
+		return nil, queryFailed(err)
+	}
+	logs := toLogsResult(result)
+	return &logs, nil
+}
+
+// logQuery builds the store query of a logs request. The time range
+// defaults to the last 15 minutes and the limit to 100 entries.
+func logQuery(params protocol.LogsParams) (*store.LogQuery, error) {
+	earliest := params.Earliest
+	if earliest == "" {
+		earliest = "-15m"
+	}
+	latest := params.Latest
+	if latest == "" {
+		latest = "now"
+	}
+
+	timeRange, err := store.ParseTimeRange(earliest, latest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time range: %v", err)
+	}
+
+	fieldFilters, err := toFieldFilters(params.Fields)
+	if err != nil {
+		return nil, err
+	}
+
+	query := &store.LogQuery{
+		TimeRange:    timeRange,
+		Levels:       params.Levels,
+		Components:   params.Components,
+		Search:       params.Search,
+		FieldFilters: fieldFilters,
+		Limit:        params.Limit,
+	}
+	if query.Limit <= 0 {
+		query.Limit = 100
+	}
+	return query, nil
+}
+
+// toLogsResult converts a store result to the protocol format.
+func toLogsResult(result *store.LogQueryResult) protocol.LogsResult {
+	entries := make([]protocol.LogEntry, len(result.Entries))
+	for i, e := range result.Entries {
+		entries[i] = toProtocolLogEntry(e)
+	}
+	return protocol.LogsResult{
+		Entries:    entries,
+		TotalCount: result.TotalCount,
+		HasMore:    result.HasMore,
+	}
+}
+
+// QueryStats answers a "stats" request from st, the way the control socket
+// does, for 'vpn stats --data-dir'. Errors are *protocol.ControlError.
+func QueryStats(st *store.Store, params protocol.StatsParams) (*protocol.StatsResult, error) {
+	// Default time range: last 5 minutes
+	earliest := params.Earliest
+	if earliest == "" {
+		earliest = "-5m"
+	}
+	latest := params.Latest
+	if latest == "" {
+		latest = "now"
+	}
+
+	// Parse time range
+	timeRange, err := store.ParseTimeRange(earliest, latest)
+	if err != nil {
+		return nil, invalidParams("invalid time range: %v", err)
+	}
+
+	if !store.ValidAggregation(params.Aggregation) {
+		return nil, invalidParams("invalid aggregation %q (use avg, sum, min, max, count or p95)", params.Aggregation)
+	}
+	if !store.ValidFill(params.Fill) {
+		return nil, invalidParams("invalid fill %q (use null, zero or previous)", params.Fill)
+	}
+	var groupBy time.Duration
+	if params.GroupBy != "" {
+		groupBy, err = store.ParseDuration(params.GroupBy)
+		if err != nil || groupBy <= 0 {
+			return nil, invalidParams("invalid group_by %q", params.GroupBy)
+		}
+	}
+	if params.Format != "" && params.Format != "csv" {
+		return nil, invalidParams("invalid format %q (only csv)", params.Format)
+	}
+
+	// Build query
+	query := &store.MetricQuery{
+		TimeRange:   timeRange,
+		Names:       params.Metrics,
+		Granularity: params.Granularity,
+		Aggregation: params.Aggregation,
+		GroupBy:     groupBy,
+		Fill:        params.Fill,
+		Tags:        params.Tags,
+	}
+
+	if params.Format == "csv" {
+		var buf bytes.Buffer
+		if err := st.QueryMetricsCSV(query, &buf); err != nil {
+			return nil, queryFailed(err)
+		}
+		return &protocol.StatsResult{CSV: buf.String()}, nil
+	}
+
+	// Execute query
+	result, err := st.QueryMetrics(query)
+	if err != nil {
+		return nil, queryFailed(err)
+	}
+
+	// Convert to protocol format
+	series := make([]protocol.MetricSeries, len(result.Series))
+	for i, s := range result.Series {
+		points := make([]protocol.MetricPoint, len(s.Points))
+		for j, p := range s.Points {
+			points[j] = protocol.MetricPoint{
+				Timestamp:   p.Timestamp.Format(time.RFC3339),
+				Name:        p.Name,
+				Value:       p.Value,
+				Granularity: p.Granularity,
+				Tags:        p.Tags,
+			}
+		}
+		series[i] = protocol.MetricSeries{
+			Name:   s.Name,
+			Tags:   s.Tags,
+			Points: points,
+		}
+	}
+
+	// Get latest values as summary
+	summary := make(map[string]float64)
+	if len(params.Metrics) == 0 {
+		// Default metrics
+		params.Metrics = []string{
+			"vpn.bytes_sent", "vpn.bytes_recv",
+			"vpn.packets_sent", "vpn.packets_recv",
+			"vpn.active_peers", "vpn.uptime_seconds",
+			"bandwidth.tx_current_bps", "bandwidth.rx_current_bps",
+		}
+	}
+	latestValues, _ := st.GetLatestMetrics(params.Metrics, params.Tags)
+	for k, v := range latestValues {
+		summary[k] = v
+	}
+
+	// Get storage info
+	storageInfo := make(map[string]float64)
+	if stats, err := st.GetStorageStats(); err == nil {
+		for k, v := range stats {
+			if f, ok := v.(float64); ok {
+				storageInfo[k] = f
+			} else if i, ok := v.(int64); ok {
+				storageInfo[k] = float64(i)
+			}
+		}
+	}
+
+	return &protocol.StatsResult{
+		Series:      series,
+		Summary:     summary,
+		StorageInfo: storageInfo,
+	}, nil
+}
+
+// invalidParams returns an ErrCodeInvalidParams error.
+func invalidParams(format string, args ...interface{}) error {
+	return &protocol.ControlError{Code: protocol.ErrCodeInvalidParams, Message: fmt.Sprintf(format, args...)}
+}
+
+// queryFailed returns the ErrCodeInternal error of a failed store query.
+func queryFailed(err error) error {
+	return &protocol.ControlError{Code: protocol.ErrCodeInternal, Message: fmt.Sprintf("query failed: %v", err)}
+}
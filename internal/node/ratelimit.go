@@ -0,0 +1,325 @@
+package node
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimiter caps traffic to and from one peer with a token bucket per
+// direction. Packets that find the bucket empty are dropped (TCP inside the
+// tunnel backs off), so a single peer cannot saturate the server link.
+type rateLimiter struct {
+	mu        sync.Mutex
+	limitMbps float64
+	bucket    *rate.Limiter // Egress, in bytes
+	ingress   *rate.Limiter // Ingress, in bytes
+
+	// byDefault is set for limiters created for PeerBandwidthLimitBps rather
+	// than an explicit per-peer limit
+	byDefault bool
+
+	// Throughput accounting
+	sentBytes    uint64
+	droppedBytes uint64
+	sampleBytes  uint64    // sentBytes at the last sample
+	sampleAt     time.Time // time of the last sample
+	currentBps   float64   // bytes per second between the last two samples
+}
+
+// rateLimitBurst is how many seconds of traffic the bucket holds, so short
+// bursts (page loads) aren't cut to the average rate.
+const rateLimitBurst = 0.25
+
+func newRateLimiter(mbps float64) *rateLimiter {
+	limit, burst := rateLimitBytes(mbps)
+	return &rateLimiter{
+		limitMbps: mbps,
+		bucket:    rate.NewLimiter(limit, burst),
+		ingress:   rate.NewLimiter(limit, burst),
+		sampleAt:  time.Now(),
+	}
+}
+
+// rateLimitBytes converts a limit in Mbps to a byte rate and burst.
+func rateLimitBytes(mbps float64) (rate.Limit, int) {
+	bytesPerSec := mbps * 1000 * 1000 / 8
+	return rate.Limit(bytesPerSec), int(bytesPerSec * rateLimitBurst)
+}
+
+// allow takes n bytes from the bucket, or counts them as dropped if there
+// aren't enough tokens.
+func (r *rateLimiter) allow(n int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.bucket.AllowN(time.Now(), n) {
+		r.droppedBytes += uint64(n)
+		return false
+	}
+	r.sentBytes += uint64(n)
+	return true
+}
+
+// allowIngress is allow for packets received from the peer. They count
+// toward the dropped bytes but not the current rate, which is egress.
+func (r *rateLimiter) allowIngress(n int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.ingress.AllowN(time.Now(), n) {
+		r.droppedBytes += uint64(n)
+		return false
+	}
+	return true
+}
+
+// setLimit changes the limit, keeping the throughput accounting.
+func (r *rateLimiter) setLimit(mbps float64, byDefault bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	limit, burst := rateLimitBytes(mbps)
+	r.limitMbps = mbps
+	r.byDefault = byDefault
+	now := time.Now()
+	for _, b := range []*rate.Limiter{r.bucket, r.ingress} {
+		b.SetLimitAt(now, limit)
+		b.SetBurstAt(now, burst)
+	}
+}
+
+// isDefault reports whether the limiter applies PeerBandwidthLimitBps.
+func (r *rateLimiter) isDefault() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.byDefault
+}
+
+// sample updates the current rate from the bytes sent since the last call.
+func (r *rateLimiter) sample(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if elapsed := now.Sub(r.sampleAt).Seconds(); elapsed > 0 {
+		r.currentBps = float64(r.sentBytes-r.sampleBytes) / elapsed
+	}
+	r.sampleBytes = r.sentBytes
+	r.sampleAt = now
+}
+
+// stats returns the configured limit, the current rate (both in Mbps) and
+// the bytes dropped so far.
+func (r *rateLimiter) stats() (limitMbps, currentMbps float64, droppedBytes uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.limitMbps, r.currentBps * 8 / 1000 / 1000, r.droppedBytes
+}
+
+// ParseRateLimits parses per-peer limits written as
+// "<vpn-ip>=<mbps>,<vpn-ip>=<mbps>" ("" = no limits).
+func ParseRateLimits(value string) (map[string]float64, error) {
+	limits := make(map[string]float64)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		ip, mbps, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected <vpn-ip>=<mbps>, got %q", part)
+		}
+		if net.ParseIP(ip) == nil {
+			return nil, fmt.Errorf("invalid VPN IP %q", ip)
+		}
+		rate, err := strconv.ParseFloat(mbps, 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("invalid rate %q (use Mbps, e.g. 20)", mbps)
+		}
+		limits[ip] = rate
+	}
+	return limits, nil
+}
+
+// peerRateLimiters returns the current limiters (VPN IP -> limiter).
+func (d *Daemon) peerRateLimiters() map[string]*rateLimiter {
+	if limiters := d.rateLimiters.Load(); limiters != nil {
+		return *limiters
+	}
+	return nil
+}
+
+// setRateLimits replaces the per-peer limits at runtime. Peers that stay
+// limited keep their limiter and its statistics; peers without an explicit
+// limit fall back to PeerBandwidthLimitBps.
+func (d *Daemon) setRateLimits(limits map[string]float64) {
+	d.config.PeerRateLimits = limits
+	d.rebuildRateLimiters()
+}
+
+// setDefaultRateLimit changes PeerBandwidthLimitBps (0 = none) at runtime.
+func (d *Daemon) setDefaultRateLimit(bps int64) {
+	d.config.PeerBandwidthLimitBps = bps
+	d.rebuildRateLimiters()
+}
+
+// defaultRateLimitMbps returns PeerBandwidthLimitBps in Mbps.
+func (d *Daemon) defaultRateLimitMbps() float64 {
+	return float64(d.config.PeerBandwidthLimitBps) / 1000 / 1000
+}
+
+// rebuildRateLimiters recreates the limiter map from PeerRateLimits and
+// PeerBandwidthLimitBps, reusing existing limiters.
+func (d *Daemon) rebuildRateLimiters() {
+	current := d.peerRateLimiters()
+	limiters := make(map[string]*rateLimiter, len(current))
+	for vpnIP, mbps := range d.config.PeerRateLimits {
+		if mbps <= 0 {
+			continue
+		}
+		if limiter, ok := current[vpnIP]; ok {
+			limiter.setLimit(mbps, false)
+			limiters[vpnIP] = limiter
+			continue
+		}
+		limiters[vpnIP] = newRateLimiter(mbps)
+	}
+
+	// Peers limited by default only so far keep their limiter
+	if defaultMbps := d.defaultRateLimitMbps(); defaultMbps > 0 {
+		for vpnIP, limiter := range current {
+			if _, ok := limiters[vpnIP]; ok {
+				continue
+			}
+			limiter.setLimit(defaultMbps, true)
+			limiters[vpnIP] = limiter
+		}
+	}
+	d.rateLimiters.Store(&limiters)
+}
+
+// peerRateLimiter returns the limiter for a connected peer's VPN IP, or nil
+// if the peer is not rate limited. With PeerBandwidthLimitBps set, peers
+// without an explicit limit get one on first use.
+func (d *Daemon) peerRateLimiter(vpnIP string) *rateLimiter {
+	if limiter := d.peerRateLimiters()[vpnIP]; limiter != nil {
+		return limiter
+	}
+	defaultMbps := d.defaultRateLimitMbps()
+	if defaultMbps <= 0 {
+		return nil
+	}
+
+	limiter := newRateLimiter(defaultMbps)
+	limiter.byDefault = true
+	for {
+		current := d.rateLimiters.Load()
+		limiters := make(map[string]*rateLimiter)
+		if current != nil {
+			for ip, l := range *current {
+				limiters[ip] = l
+			}
+		}
+		if existing := limiters[vpnIP]; existing != nil {
+			return existing
+		}
+		limiters[vpnIP] = limiter
+		if d.rateLimiters.CompareAndSwap(current, &limiters) {
+			return limiter
+		}
+	}
+}
+
+// removeDefaultRateLimiter forgets the default limiter of a peer that
+// disconnected; explicit limits are kept for when it reconnects.
+func (d *Daemon) removeDefaultRateLimiter(vpnIP string) {
+	for {
+		current := d.rateLimiters.Load()
+		if current == nil {
+			return
+		}
+		limiter, ok := (*current)[vpnIP]
+		if !ok || !limiter.isDefault() {
+			return
+		}
+		limiters := make(map[string]*rateLimiter, len(*current))
+		for ip, l := range *current {
+			if ip != vpnIP {
+				limiters[ip] = l
+			}
+		}
+		if d.rateLimiters.CompareAndSwap(current, &limiters) {
+			return
+		}
+	}
+}
+
+// rateLimitDroppedBytes sums the bytes dropped by all peer limiters.
+func (d *Daemon) rateLimitDroppedBytes() uint64 {
+	var total uint64
+	for _, limiter := range d.peerRateLimiters() {
+		_, _, dropped := limiter.stats()
+		total += dropped
+	}
+	return total
+}
+
+const (
+	// controlRequestsPerSec caps control requests from one remote address.
+	controlRequestsPerSec = 100
+
+	// controlMaxConns caps simultaneous control connections.
+	controlMaxConns = 20
+
+	// controlLimiterIdle is how long an address's bucket is kept unused.
+	controlLimiterIdle = time.Minute
+)
+
+// controlLimiter rate limits control requests per remote address, so a
+// runaway CLI script cannot starve the daemon.
+type controlLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*controlBucket
+}
+
+type controlBucket struct {
+	limiter  *rate.Limiter // In requests
+	lastUsed time.Time
+}
+
+func newControlLimiter() *controlLimiter {
+	return &controlLimiter{buckets: make(map[string]*controlBucket)}
+}
+
+// allow counts a request from addr. If the address is over its limit it
+// returns false and how long the client should wait.
+func (l *controlLimiter) allow(addr string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[addr]
+	if !ok {
+		// Forget addresses that went quiet before adding a new one
+		for a, old := range l.buckets {
+			if now.Sub(old.lastUsed) > controlLimiterIdle {
+				delete(l.buckets, a)
+			}
+		}
+		b = &controlBucket{limiter: rate.NewLimiter(controlRequestsPerSec, controlRequestsPerSec)}
+		l.buckets[addr] = b
+	}
+	b.lastUsed = now
+
+	r := b.limiter.ReserveN(now, 1)
+	if wait := r.DelayFrom(now); wait > 0 {
+		r.CancelAt(now)
+		return false, wait
+	}
+	return true, 0
+}
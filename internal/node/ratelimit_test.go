@@ -0,0 +1,56 @@
+package node
+
+import (
+	"testing"
+	"time"
+)
+
+func TestControlLimiter(t *testing.T) {
+	l := newControlLimiter()
+
+	for i := 0; i < controlRequestsPerSec; i++ {
+		if ok, _ := l.allow("10.8.0.2"); !ok {
+			t.Fatalf("request %d refused within the burst", i+1)
+		}
+	}
+	ok, wait := l.allow("10.8.0.2")
+	if ok {
+		t.Fatal("request over the limit allowed")
+	}
+	if wait <= 0 || wait > time.Second/controlRequestsPerSec+time.Millisecond {
+		t.Errorf("wait %v, want about %v", wait, time.Second/controlRequestsPerSec)
+	}
+
+	// Other addresses have their own budget
+	if ok, _ := l.allow("10.8.0.3"); !ok {
+		t.Error("second address refused")
+	}
+}
+
+func TestPeerRateLimiter(t *testing.T) {
+	r := newRateLimiter(8) // 1 MB/s, 250 KB burst
+
+	sent := 0
+	for r.allow(1400) {
+		sent += 1400
+		if sent > 1000*1000 {
+			t.Fatal("limiter never ran out")
+		}
+	}
+	if burst := 250 * 1000; sent < burst-1400 || sent > burst+1400 {
+		t.Errorf("sent %d bytes before the first drop, want about %d", sent, burst)
+	}
+	if _, _, dropped := r.stats(); dropped != 1400 {
+		t.Errorf("dropped %d bytes, want 1400", dropped)
+	}
+
+	// Ingress has its own bucket
+	if !r.allowIngress(1400) {
+		t.Error("ingress refused after egress ran out")
+	}
+
+	r.setLimit(80, false)
+	if limit, _, _ := r.stats(); limit != 80 {
+		t.Errorf("limit %v Mbps after setLimit, want 80", limit)
+	}
+}
@@ -48,8 +48,16 @@ type NetworkTopology struct {
 	// Our identity
 	ourVPNAddr string
 	ourName    string
+
+	// Subscribers notified on changes
+	subs   map[TopologySubscriber]struct{}
+	subsMu sync.Mutex
 }
 
+// TopologySubscriber receives a signal when the topology changes. Signals
+// are coalesced: a subscriber that falls behind sees one pending signal.
+type TopologySubscriber chan struct{}
+
 // NewNetworkTopology creates a new topology tracker.
 func NewNetworkTopology(ourVPNAddr, ourName string) *NetworkTopology {
 	t := &NetworkTopology{
@@ -57,6 +65,7 @@ func NewNetworkTopology(ourVPNAddr, ourName string) *NetworkTopology {
 		edges:      make(map[string]*NetworkEdge),
 		ourVPNAddr: ourVPNAddr,
 		ourName:    ourName,
+		subs:       make(map[TopologySubscriber]struct{}),
 	}
 
 	// Add ourselves
@@ -98,6 +107,8 @@ func (t *NetworkTopology) AddDirectPeer(node *NetworkNode) {
 		us.Connections = t.getConnectionsFor(t.ourVPNAddr)
 	}
 	node.Connections = append(node.Connections, t.ourVPNAddr)
+
+	t.Notify()
 }
 
 // RemovePeer removes a peer from the topology.
@@ -117,6 +128,8 @@ func (t *NetworkTopology) RemovePeer(vpnAddr string) {
 
 	// Recalculate distances
 	t.recalculateDistances()
+
+	t.Notify()
 }
 
 // MergePeerTopology merges topology information received from a peer.
@@ -157,6 +170,8 @@ func (t *NetworkTopology) MergePeerTopology(sourceAddr string, nodes []*NetworkN
 
 	// Recalculate distances from us
 	t.recalculateDistances()
+
+	t.Notify()
 }
 
 // GetAllNodes returns all known nodes in the network.
@@ -276,6 +291,37 @@ func (t *NetworkTopology) UpdatePeerLatency(vpnAddr string, latencyMs float64) {
 	if edge, ok := t.edges[edgeKey]; ok {
 		edge.LatencyMs = latencyMs
 	}
+
+	t.Notify()
+}
+
+// UpdatePeerBandwidth records a measured bandwidth (bytes/sec) for a peer.
+func (t *NetworkTopology) UpdatePeerBandwidth(vpnAddr string, bandwidth float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if node, ok := t.nodes[vpnAddr]; ok {
+		node.Bandwidth = bandwidth
+	}
+
+	edgeKey := t.edgeKey(t.ourVPNAddr, vpnAddr)
+	if edge, ok := t.edges[edgeKey]; ok {
+		edge.Bandwidth = bandwidth
+	}
+
+	t.Notify()
+}
+
+// UpdatePeerGeo sets a peer's geolocation.
+func (t *NetworkTopology) UpdatePeerGeo(vpnAddr string, geo *protocol.GeoLocation) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if node, ok := t.nodes[vpnAddr]; ok {
+		node.Geo = geo
+	}
+
+	t.Notify()
 }
 
 // UpdatePeerStats updates traffic stats for a peer.
@@ -317,6 +363,17 @@ func (t *NetworkTopology) SetOurInfo(name, vpnAddr, publicAddr, os, version stri
 	}
 }
 
+// SetOurName updates our own node's name.
+func (t *NetworkTopology) SetOurName(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.ourName = name
+	if node, ok := t.nodes[t.ourVPNAddr]; ok {
+		node.Name = name
+	}
+}
+
 // SetOurGeo updates our own node's geolocation.
 func (t *NetworkTopology) SetOurGeo(geo *protocol.GeoLocation) {
 	t.mu.Lock()
@@ -325,6 +382,8 @@ func (t *NetworkTopology) SetOurGeo(geo *protocol.GeoLocation) {
 	if node, ok := t.nodes[t.ourVPNAddr]; ok {
 		node.Geo = geo
 	}
+
+	t.Notify()
 }
 
 // GetNode returns a copy of a node by VPN address, or nil if not found.
@@ -338,3 +397,35 @@ func (t *NetworkTopology) GetNode(vpnAddr string) *NetworkNode {
 	}
 	return nil
 }
+
+// Subscribe returns a channel signalled whenever the topology changes.
+func (t *NetworkTopology) Subscribe() TopologySubscriber {
+	sub := make(TopologySubscriber, 1)
+	t.subsMu.Lock()
+	t.subs[sub] = struct{}{}
+	t.subsMu.Unlock()
+	return sub
+}
+
+// Unsubscribe removes a subscription.
+func (t *NetworkTopology) Unsubscribe(sub TopologySubscriber) {
+	t.subsMu.Lock()
+	delete(t.subs, sub)
+	t.subsMu.Unlock()
+	close(sub)
+}
+
+// Notify signals all subscribers that the topology changed. Changes made
+// outside the topology (e.g. a new peer list) can call it directly.
+func (t *NetworkTopology) Notify() {
+	t.subsMu.Lock()
+	defer t.subsMu.Unlock()
+
+	for sub := range t.subs {
+		select {
+		case sub <- struct{}{}:
+		default:
+			// A signal is already pending
+		}
+	}
+}
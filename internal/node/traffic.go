@@ -0,0 +1,194 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/miguelemosreverte/vpn/internal/cli"
+	"github.com/miguelemosreverte/vpn/internal/protocol"
+	"github.com/miguelemosreverte/vpn/internal/store"
+)
+
+const (
+	// trafficFlushInterval is how often per-destination counts are written
+	// to the store.
+	trafficFlushInterval = time.Minute
+
+	// trafficMaxDests bounds the destinations counted between flushes;
+	// bytes for new ones beyond it go to store.TrafficOther.
+	trafficMaxDests = 4096
+
+	// trafficTopN is how many destinations are kept per flush; the rest are
+	// summed into store.TrafficOther.
+	trafficTopN = 100
+)
+
+// trafficCounter counts bytes between clients and each remote host (server
+// mode), keyed by netip.Addr so the packet path does not allocate.
+type trafficCounter struct {
+	mu     sync.Mutex
+	counts map[netip.Addr]*store.TrafficCount
+	other  store.TrafficCount
+}
+
+func newTrafficCounter() *trafficCounter {
+	return &trafficCounter{
+		counts: make(map[netip.Addr]*store.TrafficCount),
+		other:  store.TrafficCount{Dest: store.TrafficOther},
+	}
+}
+
+// add counts out bytes sent to ip and in bytes received from it.
+func (t *trafficCounter) add(ip net.IP, out, in int) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return
+	}
+	addr = addr.Unmap()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c, ok := t.counts[addr]
+	if !ok {
+		if len(t.counts) >= trafficMaxDests {
+			c = &t.other
+		} else {
+			c = &store.TrafficCount{Dest: addr.String()}
+			t.counts[addr] = c
+		}
+	}
+	c.BytesOut += uint64(out)
+	c.BytesIn += uint64(in)
+}
+
+// take resets the counter and returns the top n destinations by bytes, the
+// rest summed into a store.TrafficOther entry.
+func (t *trafficCounter) take(n int) []store.TrafficCount {
+	t.mu.Lock()
+	counts := make([]store.TrafficCount, 0, len(t.counts))
+	for _, c := range t.counts {
+		counts = append(counts, *c)
+	}
+	other := t.other
+	t.counts = make(map[netip.Addr]*store.TrafficCount)
+	t.other = store.TrafficCount{Dest: store.TrafficOther}
+	t.mu.Unlock()
+
+	sort.Slice(counts, func(i, j int) bool { return counts[i].Bytes() > counts[j].Bytes() })
+	if len(counts) > n {
+		for _, c := range counts[n:] {
+			other.BytesOut += c.BytesOut
+			other.BytesIn += c.BytesIn
+		}
+		counts = counts[:n]
+	}
+	if other.Bytes() > 0 {
+		counts = append(counts, other)
+	}
+	return counts
+}
+
+// trafficLoop writes per-destination counts to the store every
+// trafficFlushInterval (server mode).
+func (d *Daemon) trafficLoop() {
+	defer d.recoverCrash()
+
+	ticker := time.NewTicker(trafficFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case now := <-ticker.C:
+			counts := d.traffic.take(trafficTopN)
+			if d.store == nil {
+				continue
+			}
+			// Bytes counted over the last interval belong to the minute it started
+			if err := d.store.WriteTraffic(now.Add(-trafficFlushInterval), counts); err != nil {
+				log.Printf("[node] Failed to record traffic: %v", err)
+			}
+		}
+	}
+}
+
+// handleTraffic returns the destinations clients exchanged the most bytes
+// with. Only the server counts traffic, so clients ask it.
+func (d *Daemon) handleTraffic(enc *json.Encoder, req *protocol.Request) {
+	var params protocol.TrafficParams
+	if req.Params != nil {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, "invalid params")
+			return
+		}
+	}
+
+	if !d.config.ServerMode {
+		client, err := cli.NewClient(net.JoinHostPort(d.serverIP(), "9001"))
+		if err != nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeNotConnected, fmt.Sprintf("cannot reach server: %v", err))
+			return
+		}
+		defer client.Close()
+		client.SetToken(d.config.ReadonlyToken)
+
+		result, err := client.Traffic(params)
+		if err != nil {
+			d.sendError(enc, req.ID, protocol.ErrCodeInternal, fmt.Sprintf("server query failed: %v", err))
+			return
+		}
+		d.sendResult(enc, req.ID, *result)
+		return
+	}
+
+	if d.store == nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeStorageUnavailable, "storage not initialized")
+		return
+	}
+
+	earliest := params.Earliest
+	if earliest == "" {
+		earliest = "-1h"
+	}
+	latest := params.Latest
+	if latest == "" {
+		latest = "now"
+	}
+	timeRange, err := store.ParseTimeRange(earliest, latest)
+	if err != nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeInvalidParams, fmt.Sprintf("invalid time range: %v", err))
+		return
+	}
+	if params.Limit <= 0 {
+		params.Limit = 10
+	}
+
+	counts, err := d.store.QueryTraffic(timeRange, params.Limit)
+	if err != nil {
+		d.sendError(enc, req.ID, protocol.ErrCodeInternal, fmt.Sprintf("query failed: %v", err))
+		return
+	}
+
+	result := protocol.TrafficResult{
+		Destinations: make([]protocol.TrafficDest, len(counts)),
+		Start:        timeRange.Start.Format(time.RFC3339),
+		End:          timeRange.End.Format(time.RFC3339),
+	}
+	for i, c := range counts {
+		result.Destinations[i] = protocol.TrafficDest{
+			Dest:     c.Dest,
+			Bytes:    c.Bytes(),
+			BytesOut: c.BytesOut,
+			BytesIn:  c.BytesIn,
+		}
+	}
+	d.sendResult(enc, req.ID, result)
+}
@@ -13,6 +13,7 @@ type Request struct {
 	ID     uint64          `json:"id"`
 	Method string          `json:"method"`
 	Params json.RawMessage `json:"params,omitempty"`
+	Token  string          `json:"token,omitempty"` // Required when the node has a control token
 }
 
 // Response represents a node response to the CLI.
@@ -24,8 +25,26 @@ type Response struct {
 
 // Error represents an error response.
 type Error struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	Code       int     `json:"code"`
+	Message    string  `json:"message"`
+	RetryAfter float64 `json:"retry_after,omitempty"` // Seconds (ErrCodeRateLimited)
+}
+
+// ControlError is an error response returned as a Go error, so callers can
+// inspect the code with errors.As.
+type ControlError struct {
+	Code       int
+	Message    string
+	RetryAfter float64
+}
+
+func (e *ControlError) Error() string {
+	return "server error: " + e.Message
+}
+
+// Err returns the error response as a *ControlError.
+func (e *Error) Err() error {
+	return &ControlError{Code: e.Code, Message: e.Message, RetryAfter: e.RetryAfter}
 }
 
 // StatusResult is returned by the "status" method.
@@ -38,8 +57,13 @@ type StatusResult struct {
 	PeerCount      int           `json:"peer_count"`
 	BytesIn        uint64        `json:"bytes_in"`
 	BytesOut       uint64        `json:"bytes_out"`
-	ServerMode     bool          `json:"server_mode"`     // True if this is a server node
-	ReconnectCount int           `json:"reconnect_count"` // Number of reconnections this session
+	ServerMode     bool          `json:"server_mode"`          // True if this is a server node
+	ConnectTo      string        `json:"connect_to,omitempty"` // Server address (client mode)
+	ReconnectCount int           `json:"reconnect_count"`      // Number of reconnections this session
+	PacketLoss     float64       `json:"packet_loss"`          // Tunnel packet loss in percent (last 10s)
+	Subnet         string        `json:"subnet"`               // VPN subnet, e.g. 10.8.0.0/24
+	ServerIP       string        `json:"server_ip"`            // VPN IP of the server (first host of the subnet)
+	MTU            int           `json:"mtu,omitempty"`        // TUN MTU, negotiated with the server (0 before the tunnel is up)
 }
 
 // PeerInfo represents a connected peer.
@@ -57,6 +81,7 @@ type PeerInfo struct {
 	Bandwidth  float64      `json:"bandwidth_bps,omitempty"`
 	Geo        *GeoLocation `json:"geo,omitempty"`
 	RouteAll   bool         `json:"route_all,omitempty"` // Whether routing is enabled (Connection Intent Protocol)
+	Cipher     string       `json:"cipher,omitempty"`    // Packet cipher the client prefers (handshake, with HandshakeCipher)
 }
 
 // PeersResult is returned by the "peers" method.
@@ -64,6 +89,38 @@ type PeersResult struct {
 	Peers []PeerInfo `json:"peers"`
 }
 
+// PeerRate is a peer's egress rate limit and current throughput.
+type PeerRate struct {
+	VPNAddress   string  `json:"vpn_address"`
+	LimitMbps    float64 `json:"limit_mbps"`
+	CurrentMbps  float64 `json:"current_mbps"`
+	DroppedBytes uint64  `json:"dropped_bytes"`     // Dropped for exceeding the limit
+	Default      bool    `json:"default,omitempty"` // Limited by --peer-bandwidth-limit, not its own limit
+}
+
+// PeerRatesResult is returned by the "peer_rates" and "set_peer_limit"
+// methods.
+type PeerRatesResult struct {
+	Rates       []PeerRate `json:"rates"`
+	DefaultMbps float64    `json:"default_mbps,omitempty"` // Limit for peers without their own (0 = none)
+}
+
+// SetPeerLimitParams are parameters for the "set_peer_limit" method.
+type SetPeerLimitParams struct {
+	VPNAddress string  `json:"vpn_address"`
+	Mbps       float64 `json:"mbps"` // 0 removes the peer's own limit
+}
+
+// RemovePeerParams are parameters for the "remove_peer" method.
+type RemovePeerParams struct {
+	VPNAddress string `json:"vpn_address"` // With Forget, a node name or hostname also works
+
+	// Forget removes the node for good ('vpn node rm'): its VPN IP
+	// assignment and connection state are deleted, and if connected it is
+	// sent REMOVED so its daemon exits instead of reconnecting.
+	Forget bool `json:"forget,omitempty"`
+}
+
 // NetworkNode represents a node in the mesh network topology.
 type NetworkNode struct {
 	Name        string       `json:"name"`
@@ -103,13 +160,50 @@ type TopologyResult struct {
 type UpdateParams struct {
 	All     bool `json:"all,omitempty"`
 	Rolling bool `json:"rolling,omitempty"`
+	DryRun  bool `json:"dry_run,omitempty"` // Report what would change without updating
 }
 
 // UpdateResult is returned by the "update" method.
 type UpdateResult struct {
-	Success bool     `json:"success"`
-	Updated []string `json:"updated"` // List of node names updated
-	Errors  []string `json:"errors,omitempty"`
+	Success bool        `json:"success"`
+	Updated []string    `json:"updated"` // List of node names updated
+	Errors  []string    `json:"errors,omitempty"`
+	Plan    *UpdatePlan `json:"plan,omitempty"` // Set for dry runs
+}
+
+// RollbackResult is returned by the "rollback" method.
+type RollbackResult struct {
+	From string `json:"from"` // Commit rolled back from
+	To   string `json:"to"`   // Commit now checked out
+}
+
+// RestartParams are parameters for the "restart" method.
+type RestartParams struct {
+	Force bool `json:"force,omitempty"` // Required on a client, whose tunnel drops meanwhile
+}
+
+// RestartResult is returned by the "restart" method, just before the node
+// restarts.
+type RestartResult struct {
+	NodeName string `json:"node_name"`
+	Version  string `json:"version"` // Version restarting (the binary on disk may be newer)
+}
+
+// UpdatePlan describes what an update would do (see UpdateParams.DryRun).
+type UpdatePlan struct {
+	ChangedFiles []string        `json:"changed_files"`      // Files that differ from origin/main
+	Versions     []VersionChange `json:"versions,omitempty"` // VERSION files that would change
+	RebuildNode  bool            `json:"rebuild_node"`
+	RebuildCLI   bool            `json:"rebuild_cli"`
+	RestartNode  bool            `json:"restart_node"` // A node restart is required
+	WillRestart  bool            `json:"will_restart"` // The node would restart itself (servers only)
+}
+
+// VersionChange is a service layer whose VERSION file would change.
+type VersionChange struct {
+	Layer string `json:"layer"` // core, websocket, cli, ui
+	From  string `json:"from"`
+	To    string `json:"to"`
 }
 
 // LogsParams are parameters for the "logs" method.
@@ -120,7 +214,17 @@ type LogsParams struct {
 	Components []string `json:"components,omitempty"` // conn, tun, node, etc.
 	Search     string   `json:"search,omitempty"`     // Full-text search
 	Limit      int      `json:"limit,omitempty"`      // Max results
-	Follow     bool     `json:"follow,omitempty"`     // Real-time streaming
+	Follow     bool     `json:"follow,omitempty"`     // Keep streaming new entries (see "logs" below)
+	Peer       string   `json:"peer,omitempty"`       // VPN address of a peer to query instead
+
+	Fields []LogFieldFilter `json:"fields,omitempty"` // Structured field filters, all must match
+}
+
+// LogFieldFilter matches entries by a structured field, e.g. peer_ip = 10.8.0.3.
+type LogFieldFilter struct {
+	Key   string `json:"key"`
+	Op    string `json:"op"` // "=" or "!="
+	Value string `json:"value"`
 }
 
 // LogEntry represents a single log entry.
@@ -131,13 +235,23 @@ type LogEntry struct {
 	Component string `json:"component"`
 	Message   string `json:"message"`
 	Fields    string `json:"fields,omitempty"`
+	Snippet   string `json:"snippet,omitempty"` // Message with search matches between « and » (FTS5 searches)
 }
 
+// With Follow set, the "logs" method answers with the usual LogsResult and
+// then keeps sending one LogsResult per line, sharing the request ID, as
+// matching entries are written. Results with no entries are heartbeats.
+
+// LogsWarningPeerUnreachable is set on a LogsResult when the requested
+// peer could not be reached; the result is then empty.
+const LogsWarningPeerUnreachable = "peer_unreachable"
+
 // LogsResult is returned by the "logs" method.
 type LogsResult struct {
 	Entries    []LogEntry `json:"entries"`
 	TotalCount int64      `json:"total_count"`
 	HasMore    bool       `json:"has_more"`
+	Warning    string     `json:"warning,omitempty"` // e.g. LogsWarningPeerUnreachable
 }
 
 // StatsParams are parameters for the "stats" method.
@@ -146,6 +260,23 @@ type StatsParams struct {
 	Latest      string   `json:"latest,omitempty"`      // Time range end
 	Metrics     []string `json:"metrics,omitempty"`     // Metric names to query
 	Granularity string   `json:"granularity,omitempty"` // raw, 1m, 1h, auto
+	Aggregation string   `json:"aggregation,omitempty"` // avg, sum, min, max, count, p95
+	GroupBy     string   `json:"group_by,omitempty"`    // Bucket size for Aggregation, e.g. "5m"
+	Fill        string   `json:"fill,omitempty"`        // Empty buckets: null, zero, previous
+	Format      string   `json:"format,omitempty"`      // "csv": return StatsResult.CSV instead of series
+
+	Tags map[string]string `json:"tags,omitempty"` // Only points with these tags, e.g. {"peer": "mac-mini"}
+}
+
+// StatsWatchParams are parameters for the "stats_watch" method.
+type StatsWatchParams struct {
+	Metrics []string `json:"metrics,omitempty"` // Metric names to stream (default all)
+}
+
+// StatsWatchResult is one chunk of the "stats_watch" stream: the points of
+// one collector run (every second).
+type StatsWatchResult struct {
+	Points []MetricPoint `json:"points"`
 }
 
 // MetricPoint represents a single metric data point.
@@ -154,11 +285,14 @@ type MetricPoint struct {
 	Name        string  `json:"name"`
 	Value       float64 `json:"value"`
 	Granularity string  `json:"granularity"`
+	Tags        string  `json:"tags,omitempty"`
 }
 
-// MetricSeries represents a time series of metric values.
+// MetricSeries represents a time series of metric values: the points of
+// one metric with the same tags (e.g. one series per peer).
 type MetricSeries struct {
 	Name   string        `json:"name"`
+	Tags   string        `json:"tags,omitempty"` // JSON object, e.g. {"peer":"mac-mini"}
 	Points []MetricPoint `json:"points"`
 }
 
@@ -167,6 +301,7 @@ type StatsResult struct {
 	Series      []MetricSeries     `json:"series"`
 	Summary     map[string]float64 `json:"summary,omitempty"`     // Latest values
 	StorageInfo map[string]float64 `json:"storage_info,omitempty"` // DB stats
+	CSV         string             `json:"csv,omitempty"`          // timestamp,metric,value rows (Format "csv")
 }
 
 // ConnectionStatus represents the current VPN connection state.
@@ -176,6 +311,28 @@ type ConnectionStatus struct {
 	ServerAddr  string `json:"server_addr,omitempty"`
 	RouteAll    bool   `json:"route_all"`
 	ConnectedAt string `json:"connected_at,omitempty"`
+
+	RouteSubnets []string `json:"route_subnets,omitempty"` // Split-tunnel CIDRs routed through VPN
+
+	// Behavior when the tunnel drops: restore direct routing, or (kill
+	// switch) block all non-VPN traffic until reconnection
+	KillSwitch        bool `json:"kill_switch"`
+	KillSwitchEngaged bool `json:"kill_switch_engaged"` // Currently blocking traffic
+}
+
+// RouteEntry is a route the node added to its OS routing table.
+type RouteEntry struct {
+	Destination string `json:"destination"` // "default", host IP or CIDR
+	Gateway     string `json:"gateway"`
+	Interface   string `json:"interface,omitempty"` // Empty when the OS picks it
+	Purpose     string `json:"purpose"`             // "default", "server" or "subnet"
+	Installed   bool   `json:"installed"`           // Present in the live routing table
+}
+
+// RoutesResult is returned by the "routes" method.
+type RoutesResult struct {
+	Routes     []RouteEntry `json:"routes"`
+	TableError string       `json:"table_error,omitempty"` // Reading the live table failed; Installed is unknown
 }
 
 // ConnectionResult is returned by connect/disconnect methods.
@@ -191,11 +348,18 @@ type NetworkPeersResult struct {
 	ServerMode bool            `json:"server_mode"`
 }
 
+// PeersStreamResult is sent by the "peers_stream" method: the whole peer
+// list (the server first), now and again whenever a peer connects or
+// disconnects. Unchanged lists are resent every 30s as heartbeats.
+type PeersStreamResult struct {
+	Peers []PeerListEntry `json:"peers"`
+}
+
 // LifecycleEvent represents a node lifecycle event (start, stop, crash).
 type LifecycleEvent struct {
 	ID             int64   `json:"id"`
 	Timestamp      string  `json:"timestamp"`
-	Event          string  `json:"event"`           // START, STOP, CRASH, SIGNAL, CONNECTION_LOST
+	Event          string  `json:"event"`           // START, STOP, CRASH, SIGNAL, CONNECTION_LOST, RECONNECT_ATTEMPT, ROLLBACK, DEGRADED, RECOVERED, AUTOFIX
 	Reason         string  `json:"reason"`          // Detailed reason
 	UptimeSeconds  float64 `json:"uptime_seconds"`  // How long the node was running
 	RouteAll       bool    `json:"route_all"`       // Was route-all enabled
@@ -205,7 +369,11 @@ type LifecycleEvent struct {
 
 // LifecycleParams are parameters for the "lifecycle" method.
 type LifecycleParams struct {
-	Limit int `json:"limit,omitempty"` // Max events to return
+	Limit   int      `json:"limit,omitempty"`    // Max events to return
+	Events  []string `json:"events,omitempty"`   // Only these event types, e.g. CRASH, SIGNAL
+	Since   string   `json:"since,omitempty"`    // Time range start: -1h, -24h, -7d, @d
+	Until   string   `json:"until,omitempty"`    // Time range end, same syntax
+	AfterID int64    `json:"after_id,omitempty"` // Only events newer than this ID (for --follow)
 }
 
 // LifecycleResult is returned by the "lifecycle" method.
@@ -213,9 +381,24 @@ type LifecycleResult struct {
 	Events []LifecycleEvent `json:"events"`
 }
 
+// AutofixParams are parameters for the "autofix" method, which records a
+// 'vpn diagnose --fix' attempt as an AUTOFIX lifecycle event.
+type AutofixParams struct {
+	Check  string `json:"check"`  // Diagnostic check that failed
+	Action string `json:"action"` // Remediation attempted
+	Fixed  bool   `json:"fixed"`  // The check passes after the fix
+	Error  string `json:"error,omitempty"`
+}
+
+// AutofixResult is returned by the "autofix" method.
+type AutofixResult struct {
+	Recorded bool `json:"recorded"`
+}
+
 // CrashStatsParams are parameters for the "crash_stats" method.
 type CrashStatsParams struct {
-	Since string `json:"since,omitempty"` // Time range: -1h, -24h, -7d
+	Since string `json:"since,omitempty"` // Time range start: -1h, -24h, -7d, @d (default -24h)
+	Until string `json:"until,omitempty"` // Time range end, same syntax (default now)
 }
 
 // CrashStatsResult is returned by the "crash_stats" method.
@@ -223,7 +406,181 @@ type CrashStatsResult struct {
 	TotalCrashes        int              `json:"total_crashes"`
 	CrashesWithRouteAll int              `json:"crashes_with_route_all"`
 	RouteRestoreFailures int             `json:"route_restore_failures"`
-	LastCrash           *LifecycleEvent  `json:"last_crash,omitempty"`
+	LastCrash           *LifecycleEvent  `json:"last_crash,omitempty"` // Latest up to End
+	Start               string           `json:"start,omitempty"`      // Resolved time range (RFC3339)
+	End                 string           `json:"end,omitempty"`
+}
+
+// ExportParams are parameters for the "export" method.
+type ExportParams struct {
+	Earliest    string   `json:"earliest,omitempty"`    // Splunk-like: -1h, -30m, @d
+	Latest      string   `json:"latest,omitempty"`      // Splunk-like: now, -5m
+	Type        string   `json:"type,omitempty"`        // logs, metrics, lifecycle (empty = all)
+	Metrics     []string `json:"metrics,omitempty"`     // Metric names (metrics only)
+	Granularity string   `json:"granularity,omitempty"` // raw, 1m, 1h, auto (metrics only, default raw)
+	MaxRows     int      `json:"max_rows,omitempty"`    // Row cap (default 100000)
+}
+
+// ExportChunk is one line of the streamed "export" response.
+// The node sends a chunk carrying the Header, one chunk per row, and a
+// final chunk with Done set. All chunks share the request ID.
+type ExportChunk struct {
+	Header *ExportHeader   `json:"header,omitempty"`
+	Type   string          `json:"type,omitempty"` // log, metric, lifecycle
+	Row    json.RawMessage `json:"row,omitempty"`  // LogEntry, MetricPoint or LifecycleEvent
+
+	// Final chunk
+	Done       bool              `json:"done,omitempty"`
+	Rows       int               `json:"rows,omitempty"`
+	Truncated  bool              `json:"truncated,omitempty"`
+	Warning    string            `json:"warning,omitempty"`
+	CrashStats *CrashStatsResult `json:"crash_stats,omitempty"` // When lifecycle events are exported
+}
+
+// ExportHeader describes an export before its rows are streamed.
+type ExportHeader struct {
+	NodeName string `json:"node_name"`
+	Version  string `json:"version"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Type     string `json:"type,omitempty"`
+}
+
+// SetRetentionParams are parameters for the "set_retention" method.
+// Durations use the form 30m, 2h, 3d or 1w; empty fields are left unchanged.
+type SetRetentionParams struct {
+	Logs       string `json:"logs,omitempty"`
+	MetricsRaw string `json:"metrics_raw,omitempty"`
+	Metrics1m  string `json:"metrics_1m,omitempty"`
+	Metrics1h  string `json:"metrics_1h,omitempty"`
+}
+
+// RetentionResult is returned by the "retention" and "set_retention" methods.
+type RetentionResult struct {
+	Logs       string `json:"logs"`
+	MetricsRaw string `json:"metrics_raw"`
+	Metrics1m  string `json:"metrics_1m"`
+	Metrics1h  string `json:"metrics_1h"`
+}
+
+// PreferencesResult is returned by the "preferences" and "set_preferences"
+// methods. Recognized keys: theme, default_metrics_range, default_log_range.
+type PreferencesResult struct {
+	Preferences map[string]string `json:"preferences"`
+}
+
+// SetPreferencesParams are parameters for the "set_preferences" method.
+// Keys not given are left unchanged; an empty value removes the key.
+type SetPreferencesParams struct {
+	Preferences map[string]string `json:"preferences"`
+}
+
+// ConfigResult is returned by the "config" and "config_set" methods.
+// It is a snapshot of the daemon configuration without the encryption key.
+type ConfigResult struct {
+	NodeName           string             `json:"node_name"`
+	VPNAddress         string             `json:"vpn_address"`
+	Subnet             string             `json:"subnet"`
+	ListenVPN          string             `json:"listen_vpn"`
+	ListenWS           string             `json:"listen_ws"`
+	ListenControl      string             `json:"listen_control"`
+	ListenControlVPN   bool               `json:"listen_control_vpn,omitempty"` // Read-only control listener on the VPN address
+	ServerMode         bool               `json:"server_mode"`
+	ConnectTo          string             `json:"connect_to,omitempty"`
+	UseTLS             bool               `json:"use_tls"`
+	CertFile           string             `json:"cert_file,omitempty"`
+	KeyFile            string             `json:"key_file,omitempty"`
+	Encryption         bool               `json:"encryption"`
+	EncryptionKeySet   bool               `json:"encryption_key_set"` // The key itself is never exposed
+	Cipher             string             `json:"cipher,omitempty"`   // Preferred packet cipher (aes-gcm or chacha20); empty from older nodes
+	Compression        bool               `json:"compression"`
+	Transport          string             `json:"transport,omitempty"` // "tcp" or "udp"; empty from older nodes
+	IPv6               bool               `json:"ipv6"`
+	RouteAll           bool               `json:"route_all"`
+	RouteSubnets       []string           `json:"route_subnets,omitempty"`
+	DNS                []string           `json:"dns,omitempty"`
+	KillSwitch         bool               `json:"kill_switch"`
+	DataDir            string             `json:"data_dir"`
+	LogLevel           string             `json:"log_level,omitempty"`
+	PeerTimeoutSeconds int                `json:"peer_timeout_seconds"`
+	RTTAlertMs         int                `json:"rtt_alert_ms"`               // 0 = no RTT alert
+	LossAlertPct       float64            `json:"loss_alert_pct"`             // 0 = no loss alert
+	PeerRateLimits     map[string]float64 `json:"peer_rate_limits,omitempty"` // VPN IP -> Mbps
+	MaxStorageMB       int                `json:"max_storage_mb"`
+
+	PeerBandwidthLimitMbps float64 `json:"peer_bandwidth_limit_mbps,omitempty"` // Peers without their own limit
+	Retention          *RetentionResult   `json:"retention,omitempty"`
+}
+
+// ConfigSetParams are parameters for the "config_set" method.
+type ConfigSetParams struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// BenchServerParams are parameters for the "bench_server" method.
+type BenchServerParams struct {
+	DurationSec int `json:"duration_sec,omitempty"` // How long to accept tests (default 60)
+}
+
+// BenchServerResult is returned by the "bench_server" method.
+type BenchServerResult struct {
+	Address string `json:"address"` // UDP address the responder listens on
+	Until   string `json:"until"`   // When the responder stops
+}
+
+// BenchClientParams are parameters for the "bench_client" method.
+type BenchClientParams struct {
+	Peer        string `json:"peer"`                   // Peer VPN address
+	DurationSec int    `json:"duration_sec,omitempty"` // Test length (default 10)
+	Store       bool   `json:"store,omitempty"`        // Record the result as the peer's bandwidth
+}
+
+// BenchResult is returned by the "bench_client" method.
+type BenchResult struct {
+	Peer        string  `json:"peer"`
+	DurationSec float64 `json:"duration_sec"`
+	PacketsSent int     `json:"packets_sent"`
+	PacketsRecv int     `json:"packets_recv"`
+	BytesPerSec float64 `json:"bytes_per_sec"`
+	MBPerSec    float64 `json:"mb_per_sec"`
+	Mbps        float64 `json:"mbps"`
+	LossPct     float64 `json:"loss_pct"`
+	JitterMs    float64 `json:"jitter_ms"`
+	RTTMs       float64 `json:"rtt_ms"`
+	Stored      bool    `json:"stored"`
+}
+
+// AlertRule is an alert rule as returned by the "alert_list" method.
+type AlertRule struct {
+	ID         int64   `json:"id"`
+	Name       string  `json:"name"`
+	Metric     string  `json:"metric"`
+	Op         string  `json:"op"` // lt, le, gt, ge, eq, ne
+	Value      float64 `json:"value"`
+	Action     string  `json:"action"` // log, notify, webhook
+	WebhookURL string  `json:"webhook_url,omitempty"`
+	CreatedAt  string  `json:"created_at"`
+}
+
+// AlertAddParams are parameters for the "alert_add" method.
+type AlertAddParams struct {
+	Name       string  `json:"name"`
+	Metric     string  `json:"metric"`
+	Op         string  `json:"op"`
+	Value      float64 `json:"value"`
+	Action     string  `json:"action"`
+	WebhookURL string  `json:"webhook_url,omitempty"`
+}
+
+// AlertRemoveParams are parameters for the "alert_rm" method.
+type AlertRemoveParams struct {
+	Rule string `json:"rule"` // Rule ID or name
+}
+
+// AlertListResult is returned by the "alert_list" and "alert_rm" methods.
+type AlertListResult struct {
+	Rules []AlertRule `json:"rules"`
 }
 
 // InstallHandshake represents a handshake sent after install.sh runs.
@@ -261,6 +618,8 @@ type InstallHandshakeResult struct {
 // HandshakeHistoryParams are parameters for the "handshake_history" method.
 type HandshakeHistoryParams struct {
 	NodeName string `json:"node_name,omitempty"` // Filter by node name
+	OS       string `json:"os,omitempty"`        // Filter by OS (linux, darwin)
+	Failed   bool   `json:"failed,omitempty"`    // Only installs whose ping or SSH test failed
 	Limit    int    `json:"limit,omitempty"`     // Max results
 }
 
@@ -279,6 +638,19 @@ type HandshakeEntry struct {
 	SSHTestOK  bool   `json:"ssh_test_ok"`
 	PingTestOK bool   `json:"ping_test_ok"`
 	PingTestMS int    `json:"ping_test_ms"`
+
+	SSHTestError string `json:"ssh_test_error,omitempty"`
+}
+
+// InviteResult is returned by the "invite" method (server mode): what a new
+// client needs to join. 'vpn qr' encodes it.
+type InviteResult struct {
+	Server     string `json:"server"`              // Public address to --connect to (host:port, "" if unknown)
+	VPNAddress string `json:"vpn_address"`         // VPN IP the next new client will be assigned
+	Subnet     string `json:"subnet"`              // VPN subnet (--vpn-subnet for the client)
+	Transport  string `json:"transport,omitempty"` // "udp" if the server offers it
+	Secret     string `json:"secret,omitempty"`    // Server's read-only control token ("" if none)
+	Version    string `json:"version"`             // Server version
 }
 
 // HandshakeHistoryResult is returned by the "handshake_history" method.
@@ -287,9 +659,39 @@ type HandshakeHistoryResult struct {
 	Total   int              `json:"total"`
 }
 
+// TrafficParams are parameters for the "traffic" method.
+type TrafficParams struct {
+	Earliest string `json:"earliest,omitempty"` // Splunk-like: -1h (default), -24h, @d
+	Latest   string `json:"latest,omitempty"`   // Default now
+	Limit    int    `json:"limit,omitempty"`    // Top N destinations (default 10)
+}
+
+// TrafficDest is the traffic between VPN clients and one remote host.
+type TrafficDest struct {
+	Dest     string `json:"dest"`      // Remote IP address, or "other" for hosts outside the top talkers
+	Bytes    uint64 `json:"bytes"`     // Both directions
+	BytesOut uint64 `json:"bytes_out"` // Sent by clients
+	BytesIn  uint64 `json:"bytes_in"`  // Received by clients
+}
+
+// TrafficResult is returned by the "traffic" method (answered by the
+// server), largest first.
+type TrafficResult struct {
+	Destinations []TrafficDest `json:"destinations"`
+	Start        string        `json:"start"` // Resolved time range (RFC3339)
+	End          string        `json:"end"`
+}
+
 // Common error codes.
 const (
 	ErrCodeInvalidMethod = -32601
 	ErrCodeInvalidParams = -32602
 	ErrCodeInternal      = -32603
+	ErrCodeUnauthorized  = 401 // Missing or wrong control token
+	ErrCodeForbidden     = 403 // Read-only token used for a state-changing method
+	ErrCodeRateLimited   = 429
+
+	ErrCodePeerNotFound       = 404 // No peer or node with that address
+	ErrCodeNotConnected       = 409 // Needs the VPN tunnel, which is down
+	ErrCodeStorageUnavailable = 503 // The node runs without its store
 )
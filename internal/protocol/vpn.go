@@ -6,23 +6,76 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Note: PeerInfo is defined in control.go
 
 // Handshake is the initial exchange when connecting to a node.
-// Client sends: [1 byte: encryption flag][4 bytes: peer info length][peer info JSON]
+// Client sends: [1 byte: flags][4 bytes: peer info length][peer info JSON]
 // Server responds: [4 bytes: assigned IP length][assigned IP string]
+// (with HandshakeMTU the string is "<ip>;mtu=<server TUN MTU>", and with
+// HandshakeCipher ";cipher=<name>" follows)
+//
+// The flags byte was originally a plain encryption flag (0/1), so bit 0 keeps
+// that meaning and new capabilities use the higher bits.
+
+// HandshakeFlags are the capability bits sent in the client handshake.
+type HandshakeFlags byte
+
+const (
+	// HandshakeEncryption: client encrypts packets
+	HandshakeEncryption HandshakeFlags = 1 << 0
+
+	// HandshakeCompression: client can send and receive LZ4-compressed packets.
+	// The server confirms with a COMPRESSION control message before either
+	// side compresses, so older servers simply never enable it.
+	HandshakeCompression HandshakeFlags = 1 << 1
+
+	// HandshakeIPv6: client wants an IPv6 address alongside its IPv4 one.
+	// Servers running with IPv6 reply with an IPV6 control message; older
+	// servers ignore the bit and the client stays IPv4-only.
+	HandshakeIPv6 HandshakeFlags = 1 << 2
+
+	// HandshakeSequence: client can read and write sequence-numbered frames
+	// (used to measure packet loss). Each side sends a SEQUENCE control
+	// message before numbering its own frames; older servers never send it,
+	// so neither side numbers anything.
+	HandshakeSequence HandshakeFlags = 1 << 3
+
+	// HandshakeUDP: client wants IP packets over UDP (see tunnel.DialUDP).
+	// Servers listening on UDP reply with a UDP control message; older or
+	// TCP-only servers ignore the bit and everything stays on TCP.
+	HandshakeUDP HandshakeFlags = 1 << 4
+
+	// HandshakeMTU: client can read the server's TUN MTU appended to the
+	// assigned IP. Older servers ignore the bit and send the IP alone, so
+	// the client keeps its default MTU.
+	HandshakeMTU HandshakeFlags = 1 << 5
+
+	// HandshakeCipher: client can switch to the packet cipher the server
+	// names after the assigned IP (its own preference is PeerInfo.Cipher).
+	// Older servers ignore the bit and both ends keep AES-256-GCM.
+	HandshakeCipher HandshakeFlags = 1 << 6
+
+	// HandshakePing: client sends a PING every PingInterval, so the server
+	// may drop it after --peer-timeout without packets. Older clients are
+	// silent while idle and are never dropped for it.
+	HandshakePing HandshakeFlags = 1 << 7
+)
+
+// Has reports whether all bits of flag are set.
+func (f HandshakeFlags) Has(flag HandshakeFlags) bool {
+	return f&flag == flag
+}
 
 // WriteHandshake sends the client handshake.
-func WriteHandshake(w io.Writer, encryption bool, info PeerInfo) error {
-	// Encryption flag
-	encByte := byte(0)
-	if encryption {
-		encByte = 1
-	}
-	if _, err := w.Write([]byte{encByte}); err != nil {
-		return fmt.Errorf("failed to write encryption flag: %w", err)
+func WriteHandshake(w io.Writer, flags HandshakeFlags, info PeerInfo) error {
+	if _, err := w.Write([]byte{byte(flags)}); err != nil {
+		return fmt.Errorf("failed to write handshake flags: %w", err)
 	}
 
 	// Peer info
@@ -45,40 +98,56 @@ func WriteHandshake(w io.Writer, encryption bool, info PeerInfo) error {
 }
 
 // ReadHandshake reads the client handshake.
-func ReadHandshake(r io.Reader) (encryption bool, info PeerInfo, err error) {
-	// Encryption flag
-	encByte := make([]byte, 1)
-	if _, err := io.ReadFull(r, encByte); err != nil {
-		return false, PeerInfo{}, fmt.Errorf("failed to read encryption flag: %w", err)
+func ReadHandshake(r io.Reader) (flags HandshakeFlags, info PeerInfo, err error) {
+	// Flags
+	flagsByte := make([]byte, 1)
+	if _, err := io.ReadFull(r, flagsByte); err != nil {
+		return 0, PeerInfo{}, fmt.Errorf("failed to read handshake flags: %w", err)
 	}
-	encryption = encByte[0] == 1
+	flags = HandshakeFlags(flagsByte[0])
 
 	// Peer info length
 	lengthBuf := make([]byte, 4)
 	if _, err := io.ReadFull(r, lengthBuf); err != nil {
-		return false, PeerInfo{}, fmt.Errorf("failed to read peer info length: %w", err)
+		return 0, PeerInfo{}, fmt.Errorf("failed to read peer info length: %w", err)
 	}
 	length := binary.BigEndian.Uint32(lengthBuf)
 
 	if length > 4096 { // Sanity check
-		return false, PeerInfo{}, fmt.Errorf("peer info too large: %d", length)
+		return 0, PeerInfo{}, fmt.Errorf("peer info too large: %d", length)
 	}
 
 	// Peer info
 	infoBuf := make([]byte, length)
 	if _, err := io.ReadFull(r, infoBuf); err != nil {
-		return false, PeerInfo{}, fmt.Errorf("failed to read peer info: %w", err)
+		return 0, PeerInfo{}, fmt.Errorf("failed to read peer info: %w", err)
 	}
 
 	if err := json.Unmarshal(infoBuf, &info); err != nil {
-		return false, PeerInfo{}, fmt.Errorf("failed to parse peer info: %w", err)
+		return 0, PeerInfo{}, fmt.Errorf("failed to parse peer info: %w", err)
 	}
 
-	return encryption, info, nil
+	return flags, info, nil
 }
 
-// WriteAssignedIP sends the assigned VPN IP to the client.
-func WriteAssignedIP(w io.Writer, vpnIP string) error {
+// assignedIPMTUSuffix separates the server MTU from the assigned IP, and
+// assignedIPCipherSuffix the negotiated cipher from both.
+const (
+	assignedIPMTUSuffix    = ";mtu="
+	assignedIPCipherSuffix = ";cipher="
+)
+
+// WriteAssignedIP sends the assigned VPN IP to the client, followed by the
+// server's TUN MTU if mtu > 0 (only for clients that sent HandshakeMTU) and
+// the packet cipher if cipher is set (only for clients that sent
+// HandshakeCipher).
+func WriteAssignedIP(w io.Writer, vpnIP string, mtu int, cipher string) error {
+	if mtu > 0 {
+		vpnIP += assignedIPMTUSuffix + strconv.Itoa(mtu)
+	}
+	if cipher != "" {
+		vpnIP += assignedIPCipherSuffix + cipher
+	}
 	ipBytes := []byte(vpnIP)
 	lengthBuf := make([]byte, 4)
 	binary.BigEndian.PutUint32(lengthBuf, uint32(len(ipBytes)))
@@ -93,24 +162,33 @@ func WriteAssignedIP(w io.Writer, vpnIP string) error {
 	return nil
 }
 
-// ReadAssignedIP reads the assigned VPN IP from the server.
-func ReadAssignedIP(r io.Reader) (string, error) {
+// ReadAssignedIP reads the assigned VPN IP from the server, the server's
+// TUN MTU if it sent one (0 otherwise) and the packet cipher if it named
+// one ("" otherwise, meaning AES-256-GCM).
+func ReadAssignedIP(r io.Reader) (vpnIP string, mtu int, cipher string, err error) {
 	lengthBuf := make([]byte, 4)
 	if _, err := io.ReadFull(r, lengthBuf); err != nil {
-		return "", fmt.Errorf("failed to read IP length: %w", err)
+		return "", 0, "", fmt.Errorf("failed to read IP length: %w", err)
 	}
 	length := binary.BigEndian.Uint32(lengthBuf)
 
 	if length > 64 { // Sanity check
-		return "", fmt.Errorf("IP too long: %d", length)
+		return "", 0, "", fmt.Errorf("IP too long: %d", length)
 	}
 
 	ipBuf := make([]byte, length)
 	if _, err := io.ReadFull(r, ipBuf); err != nil {
-		return "", fmt.Errorf("failed to read IP: %w", err)
+		return "", 0, "", fmt.Errorf("failed to read IP: %w", err)
 	}
 
-	return string(ipBuf), nil
+	vpnIP, cipher, _ = strings.Cut(string(ipBuf), assignedIPCipherSuffix)
+	vpnIP, mtuStr, ok := strings.Cut(vpnIP, assignedIPMTUSuffix)
+	if ok {
+		if mtu, err = strconv.Atoi(mtuStr); err != nil {
+			return "", 0, "", fmt.Errorf("invalid server MTU %q", mtuStr)
+		}
+	}
+	return vpnIP, mtu, cipher, nil
 }
 
 // ControlMessage is a message sent over the VPN tunnel for signaling.
@@ -187,6 +265,54 @@ const (
 	// Sent by server to confirm receipt of DISCONNECT_INTENT (at-least-once delivery)
 	// Format: "DISCONNECT_ACK"
 	CmdDisconnectAck = "DISCONNECT_ACK"
+
+	// Server -> Client: Compression accepted (reply to HandshakeCompression)
+	// Both sides may compress packets after this message.
+	// Format: "COMPRESSION"
+	CmdCompression = "COMPRESSION"
+
+	// Server -> Client: Server identity, sent right after the assigned IP
+	// Older clients log and ignore it; they keep naming the server by its address.
+	// Format: "SERVER_INFO:" + JSON {"name": "...", "os": "linux", "version": "..."}
+	CmdServerInfo = "SERVER_INFO:"
+
+	// Server -> Client: IPv6 address assigned alongside the IPv4 one (reply to HandshakeIPv6)
+	// Format: "IPV6:" + address, e.g. "IPV6:fd00::5"
+	CmdIPv6 = "IPV6:"
+
+	// Either direction: every frame after this one is prefixed with a 4-byte
+	// sequence number (reply to HandshakeSequence, then echoed by the client)
+	// Format: "SEQUENCE"
+	CmdSequence = "SEQUENCE"
+
+	// Server -> Client: UDP session for IP packets (reply to HandshakeUDP)
+	// Control messages keep using the TCP connection.
+	// Format: "UDP:" + JSON {"port": 8443, "session": 123, "key": "<base64>"}
+	CmdUDP = "UDP:"
+
+	// Liveness probe, sent every PingInterval in both directions
+	// Lets the client detect a dead tunnel without waiting for TCP to fail, lets
+	// the server reap peers whose process died without closing the connection,
+	// and gives both ends a round-trip time measurement.
+	// Format: "PING:" + send time (unix nanoseconds)
+	CmdPing = "PING:"
+
+	// Reply to PING, echoing its send time
+	// Format: "PONG:" + send time from the PING
+	CmdPong = "PONG:"
+
+	// Server -> Client: the node was removed from the network ('vpn node rm')
+	// The client daemon restores routing and exits instead of reconnecting.
+	// Format: "REMOVED:" + human-readable reason
+	CmdRemoved = "REMOVED:"
+)
+
+const (
+	// PingInterval is how often clients PING the server and the server PINGs each client.
+	PingInterval = 15 * time.Second
+
+	// MaxMissedPongs is how many unanswered PINGs mark the tunnel as dead.
+	MaxMissedPongs = 3
 )
 
 // GeoLocation represents geographical coordinates and location info.
@@ -314,3 +440,153 @@ func MakeDisconnectAckMessage() []byte {
 func IsDisconnectAckMessage(cmd string) bool {
 	return cmd == CmdDisconnectAck
 }
+
+// MakePingMessage creates a PING control message carrying its send time.
+func MakePingMessage(sentAt time.Time) []byte {
+	return MakeControlMessage(CmdPing + strconv.FormatInt(sentAt.UnixNano(), 10))
+}
+
+// IsPingMessage checks if a command is a PING message.
+func IsPingMessage(cmd string) bool {
+	return strings.HasPrefix(cmd, CmdPing)
+}
+
+// MakePongMessage creates the PONG reply to a PING command.
+func MakePongMessage(pingCmd string) []byte {
+	return MakeControlMessage(CmdPong + strings.TrimPrefix(pingCmd, CmdPing))
+}
+
+// IsPongMessage checks if a command is a PONG message.
+func IsPongMessage(cmd string) bool {
+	return strings.HasPrefix(cmd, CmdPong)
+}
+
+// ParsePongMessage returns the send time of the PING a PONG answers.
+func ParsePongMessage(cmd string) (time.Time, error) {
+	nanos, err := strconv.ParseInt(strings.TrimPrefix(cmd, CmdPong), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid PONG: %w", err)
+	}
+	return time.Unix(0, nanos), nil
+}
+
+// ServerInfo identifies the server to a newly connected client.
+type ServerInfo struct {
+	Name    string `json:"name"`
+	OS      string `json:"os"`
+	Version string `json:"version,omitempty"`
+}
+
+// MakeServerInfoMessage creates a SERVER_INFO control message.
+func MakeServerInfoMessage(info ServerInfo) []byte {
+	data, _ := json.Marshal(info)
+	return MakeControlMessage(CmdServerInfo + string(data))
+}
+
+// ParseServerInfoMessage extracts the server info from a SERVER_INFO message.
+func ParseServerInfoMessage(data []byte) (*ServerInfo, error) {
+	cmd := ExtractControlCommand(data)
+	if !IsServerInfoMessage(cmd) {
+		return nil, fmt.Errorf("not a server info message")
+	}
+
+	var info ServerInfo
+	if err := json.Unmarshal([]byte(cmd[len(CmdServerInfo):]), &info); err != nil {
+		return nil, fmt.Errorf("failed to parse server info: %w", err)
+	}
+	return &info, nil
+}
+
+// IsServerInfoMessage checks if a command is a SERVER_INFO message.
+func IsServerInfoMessage(cmd string) bool {
+	return strings.HasPrefix(cmd, CmdServerInfo)
+}
+
+// MakeCompressionMessage creates a COMPRESSION control message.
+func MakeCompressionMessage() []byte {
+	return MakeControlMessage(CmdCompression)
+}
+
+// IsCompressionMessage checks if a command is a COMPRESSION message.
+func IsCompressionMessage(cmd string) bool {
+	return cmd == CmdCompression
+}
+
+// MakeIPv6Message creates an IPV6 control message.
+func MakeIPv6Message(addr string) []byte {
+	return MakeControlMessage(CmdIPv6 + addr)
+}
+
+// IsIPv6Message checks if a command is an IPV6 message.
+func IsIPv6Message(cmd string) bool {
+	return strings.HasPrefix(cmd, CmdIPv6)
+}
+
+// ParseIPv6Message returns the address carried by an IPV6 message.
+func ParseIPv6Message(cmd string) (string, error) {
+	addr := strings.TrimPrefix(cmd, CmdIPv6)
+	if ip := net.ParseIP(addr); ip == nil || ip.To4() != nil {
+		return "", fmt.Errorf("invalid IPV6 address: %q", addr)
+	}
+	return addr, nil
+}
+
+// MakeSequenceMessage creates a SEQUENCE control message.
+func MakeSequenceMessage() []byte {
+	return MakeControlMessage(CmdSequence)
+}
+
+// IsSequenceMessage checks if a command is a SEQUENCE message.
+func IsSequenceMessage(cmd string) bool {
+	return cmd == CmdSequence
+}
+
+// UDPOffer is the UDP session a server offers in a UDP message.
+type UDPOffer struct {
+	Port    int    `json:"port"`
+	Session uint32 `json:"session"`
+	Key     []byte `json:"key"` // AES-256 key of the session
+}
+
+// MakeUDPMessage creates a UDP control message.
+func MakeUDPMessage(offer UDPOffer) []byte {
+	data, _ := json.Marshal(offer)
+	return MakeControlMessage(CmdUDP + string(data))
+}
+
+// IsUDPMessage checks if a command is a UDP message.
+func IsUDPMessage(cmd string) bool {
+	return strings.HasPrefix(cmd, CmdUDP)
+}
+
+// ParseUDPMessage extracts the offer from a UDP message.
+func ParseUDPMessage(data []byte) (*UDPOffer, error) {
+	cmd := ExtractControlCommand(data)
+	if !IsUDPMessage(cmd) {
+		return nil, fmt.Errorf("not a UDP message")
+	}
+
+	var offer UDPOffer
+	if err := json.Unmarshal([]byte(cmd[len(CmdUDP):]), &offer); err != nil {
+		return nil, fmt.Errorf("failed to parse UDP offer: %w", err)
+	}
+	if len(offer.Key) != 32 || offer.Port <= 0 || offer.Port > 65535 {
+		return nil, fmt.Errorf("invalid UDP offer")
+	}
+	return &offer, nil
+}
+
+// MakeRemovedMessage creates a REMOVED control message.
+func MakeRemovedMessage(reason string) []byte {
+	return MakeControlMessage(CmdRemoved + reason)
+}
+
+// IsRemovedMessage checks if a command is a REMOVED message.
+func IsRemovedMessage(cmd string) bool {
+	return strings.HasPrefix(cmd, CmdRemoved)
+}
+
+// ParseRemovedMessage returns the reason carried by a REMOVED message.
+func ParseRemovedMessage(cmd string) string {
+	return strings.TrimPrefix(cmd, CmdRemoved)
+}
@@ -0,0 +1,160 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Alert actions.
+const (
+	AlertActionLog     = "log"
+	AlertActionNotify  = "notify"
+	AlertActionWebhook = "webhook"
+)
+
+// AlertCondition is a threshold on a metric, e.g. {"metric":"vpn.active_peers","op":"lt","value":1}.
+type AlertCondition struct {
+	Metric string  `json:"metric"`
+	Op     string  `json:"op"` // lt, le, gt, ge, eq, ne
+	Value  float64 `json:"value"`
+}
+
+// Validate checks that the condition names a metric and a known operator.
+func (c AlertCondition) Validate() error {
+	if c.Metric == "" {
+		return fmt.Errorf("condition has no metric")
+	}
+	switch c.Op {
+	case "lt", "le", "gt", "ge", "eq", "ne":
+		return nil
+	default:
+		return fmt.Errorf("unknown operator: %q", c.Op)
+	}
+}
+
+// Matches reports whether value satisfies the condition.
+func (c AlertCondition) Matches(value float64) bool {
+	switch c.Op {
+	case "lt":
+		return value < c.Value
+	case "le":
+		return value <= c.Value
+	case "gt":
+		return value > c.Value
+	case "ge":
+		return value >= c.Value
+	case "eq":
+		return value == c.Value
+	case "ne":
+		return value != c.Value
+	}
+	return false
+}
+
+// AlertRule is a condition evaluated against every metrics write, with the
+// action to take when it starts matching.
+type AlertRule struct {
+	ID         int64          `json:"id"`
+	Name       string         `json:"name"`
+	Condition  AlertCondition `json:"condition"`
+	Action     string         `json:"action"` // log, notify, webhook
+	WebhookURL string         `json:"webhook_url,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
+
+// Validate checks the rule before it is stored.
+func (r *AlertRule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("rule has no name")
+	}
+	if err := r.Condition.Validate(); err != nil {
+		return err
+	}
+	switch r.Action {
+	case AlertActionLog, AlertActionNotify:
+	case AlertActionWebhook:
+		if r.WebhookURL == "" {
+			return fmt.Errorf("webhook action requires a webhook URL")
+		}
+	default:
+		return fmt.Errorf("unknown action: %q", r.Action)
+	}
+	return nil
+}
+
+// AddAlertRule validates and stores a rule, returning its ID.
+func (s *Store) AddAlertRule(rule *AlertRule) (int64, error) {
+	if err := rule.Validate(); err != nil {
+		return 0, err
+	}
+
+	condition, err := json.Marshal(rule.Condition)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result, err := s.db.Exec(
+		"INSERT INTO rules (name, condition, action, webhook_url, created_at) VALUES (?, ?, ?, ?, ?)",
+		rule.Name, string(condition), rule.Action, rule.WebhookURL, time.Now().UnixMilli(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to add rule %q: %w", rule.Name, err)
+	}
+	return result.LastInsertId()
+}
+
+// ListAlertRules returns all alert rules, oldest first.
+func (s *Store) ListAlertRules() ([]AlertRule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows, err := s.db.Query("SELECT id, name, condition, action, webhook_url, created_at FROM rules ORDER BY id ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []AlertRule{}
+	for rows.Next() {
+		var r AlertRule
+		var condition string
+		var webhookURL sql.NullString
+		var createdMs int64
+		if err := rows.Scan(&r.ID, &r.Name, &condition, &r.Action, &webhookURL, &createdMs); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(condition), &r.Condition); err != nil {
+			return nil, fmt.Errorf("rule %q has invalid condition: %w", r.Name, err)
+		}
+		r.WebhookURL = webhookURL.String
+		r.CreatedAt = time.UnixMilli(createdMs)
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// DeleteAlertRule removes a rule by ID or name. It reports whether a rule was removed.
+func (s *Store) DeleteAlertRule(idOrName string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result sql.Result
+	var err error
+	if id, convErr := strconv.ParseInt(idOrName, 10, 64); convErr == nil {
+		result, err = s.db.Exec("DELETE FROM rules WHERE id = ?", id)
+	} else {
+		result, err = s.db.Exec("DELETE FROM rules WHERE name = ?", idOrName)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
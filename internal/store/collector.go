@@ -1,6 +1,8 @@
 package store
 
 import (
+	"math"
+	"sort"
 	"sync"
 	"time"
 )
@@ -16,6 +18,9 @@ type Collector struct {
 	// Metric sources (registered callbacks)
 	sources   map[string]MetricSource
 	sourcesMu sync.RWMutex
+
+	// Called with each batch after it has been written
+	onWrite func([]MetricPoint)
 }
 
 // MetricSource is a callback that returns current metric values.
@@ -48,6 +53,13 @@ func (c *Collector) UnregisterSource(name string) {
 	delete(c.sources, name)
 }
 
+// OnWrite sets a callback invoked with each batch of metrics after it has
+// been written. It runs on the collector goroutine, so it must not block.
+// Must be called before Start.
+func (c *Collector) OnWrite(fn func([]MetricPoint)) {
+	c.onWrite = fn
+}
+
 // Start begins collecting metrics.
 func (c *Collector) Start() {
 	c.wg.Add(1)
@@ -100,7 +112,9 @@ func (c *Collector) collect() {
 	}
 
 	if len(metrics) > 0 {
-		c.store.WriteBatchMetrics(metrics)
+		if err := c.store.WriteBatchMetrics(metrics); err == nil && c.onWrite != nil {
+			c.onWrite(metrics)
+		}
 	}
 }
 
@@ -117,9 +131,21 @@ type StandardMetrics struct {
 	TotalConns    uint64
 	FailedConns   uint64
 
+	// Control socket requests
+	ControlRequests    uint64
+	ControlRateLimited uint64
+
 	// Performance
 	LatencyMs     float64
 	PacketLoss    float64
+	MTU           int // TUN device MTU (0 until the tunnel is up)
+
+	// Compression (outgoing packets on current connections)
+	CompressionRawBytes        uint64
+	CompressionCompressedBytes uint64
+
+	// Bytes dropped by per-peer egress rate limits
+	RateLimitDroppedBytes uint64
 
 	// System
 	StartTime     time.Time
@@ -155,6 +181,32 @@ func (m *StandardMetrics) IncrementConns(success bool) {
 	}
 }
 
+// IncrementControlRequests counts a control request, and whether it was
+// rejected by the rate limit.
+func (m *StandardMetrics) IncrementControlRequests(limited bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ControlRequests++
+	if limited {
+		m.ControlRateLimited++
+	}
+}
+
+// SetCompression sets outgoing byte counts before and after compression.
+func (m *StandardMetrics) SetCompression(rawBytes, compressedBytes uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.CompressionRawBytes = rawBytes
+	m.CompressionCompressedBytes = compressedBytes
+}
+
+// SetRateLimitDropped sets the bytes dropped by per-peer rate limits.
+func (m *StandardMetrics) SetRateLimitDropped(droppedBytes uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.RateLimitDroppedBytes = droppedBytes
+}
+
 // SetLatency sets the current latency measurement.
 func (m *StandardMetrics) SetLatency(latencyMs float64) {
 	m.mu.Lock()
@@ -169,13 +221,26 @@ func (m *StandardMetrics) SetPacketLoss(loss float64) {
 	m.PacketLoss = loss
 }
 
+// SetMTU sets the TUN device's current MTU.
+func (m *StandardMetrics) SetMTU(mtu int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.MTU = mtu
+}
+
 // Source returns the metrics as a MetricSource for the collector.
 func (m *StandardMetrics) Source() MetricSource {
 	return func() map[string]float64 {
 		m.mu.RLock()
 		defer m.mu.RUnlock()
 
-		return map[string]float64{
+		// Ratio of original to compressed size (1 = no savings)
+		ratio := 1.0
+		if m.CompressionCompressedBytes > 0 {
+			ratio = float64(m.CompressionRawBytes) / float64(m.CompressionCompressedBytes)
+		}
+
+		metrics := map[string]float64{
 			"vpn.bytes_sent":      float64(m.BytesSent),
 			"vpn.bytes_recv":      float64(m.BytesRecv),
 			"vpn.packets_sent":    float64(m.PacketsSent),
@@ -186,7 +251,19 @@ func (m *StandardMetrics) Source() MetricSource {
 			"vpn.latency_ms":      m.LatencyMs,
 			"vpn.packet_loss_pct": m.PacketLoss,
 			"vpn.uptime_seconds":  time.Since(m.StartTime).Seconds(),
+
+			"compression.ratio":         ratio,
+			"compression.savings_bytes": float64(m.CompressionRawBytes) - float64(m.CompressionCompressedBytes),
+
+			"ratelimit.dropped_bytes": float64(m.RateLimitDroppedBytes),
+
+			"control.requests_total":     float64(m.ControlRequests),
+			"control.rate_limited_total": float64(m.ControlRateLimited),
 		}
+		if m.MTU > 0 {
+			metrics["vpn.mtu_bytes"] = float64(m.MTU)
+		}
+		return metrics
 	}
 }
 
@@ -302,12 +379,46 @@ func (b *BandwidthTracker) Peak() (txBps, rxBps float64) {
 	return maxTx, maxRx
 }
 
+// Percentile returns the pth percentile (0-100) of the bandwidth samples in
+// the window, using the nearest-rank method. Unlike Average, it is not
+// flattened by idle seconds, so short spikes still show up at P95/P99.
+func (b *BandwidthTracker) Percentile(p float64) (txBps, rxBps float64) {
+	b.mu.RLock()
+	n := len(b.samples)
+	tx := make([]float64, n)
+	rx := make([]float64, n)
+	for i, s := range b.samples {
+		tx[i] = s.txBps
+		rx[i] = s.rxBps
+	}
+	b.mu.RUnlock()
+
+	if n == 0 {
+		return 0, 0
+	}
+	if p < 0 {
+		p = 0
+	} else if p > 100 {
+		p = 100
+	}
+
+	sort.Float64s(tx)
+	sort.Float64s(rx)
+
+	rank := int(math.Ceil(p / 100 * float64(n)))
+	if rank < 1 {
+		rank = 1
+	}
+	return tx[rank-1], rx[rank-1]
+}
+
 // Source returns bandwidth metrics as a MetricSource.
 func (b *BandwidthTracker) Source() MetricSource {
 	return func() map[string]float64 {
 		txCur, rxCur := b.Current()
 		txAvg, rxAvg := b.Average()
 		txPeak, rxPeak := b.Peak()
+		txP95, rxP95 := b.Percentile(95)
 
 		return map[string]float64{
 			"bandwidth.tx_current_bps": txCur,
@@ -316,6 +427,8 @@ func (b *BandwidthTracker) Source() MetricSource {
 			"bandwidth.rx_avg_bps":     rxAvg,
 			"bandwidth.tx_peak_bps":    txPeak,
 			"bandwidth.rx_peak_bps":    rxPeak,
+			"bandwidth.tx_p95_bps":     txP95,
+			"bandwidth.rx_p95_bps":     rxP95,
 		}
 	}
 }
@@ -0,0 +1,328 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// exportPageSize is how many rows are read per query while streaming.
+// The store lock is released between pages so a slow consumer never
+// blocks log and metric writes.
+const exportPageSize = 1000
+
+// ExportBundle contains everything recorded within a time window, for offline analysis.
+type ExportBundle struct {
+	Start      time.Time        `json:"start"`
+	End        time.Time        `json:"end"`
+	Logs       []*LogEntry      `json:"logs"`
+	Metrics    []MetricPoint    `json:"metrics"`
+	Lifecycle  []LifecycleEvent `json:"lifecycle"`
+	CrashStats ExportCrashStats `json:"crash_stats"`
+}
+
+// ExportCrashStats summarizes crashes within an export window.
+type ExportCrashStats struct {
+	TotalCrashes         int             `json:"total_crashes"`
+	CrashesWithRouteAll  int             `json:"crashes_with_route_all"`
+	RouteRestoreFailures int             `json:"route_restore_failures"`
+	LastCrash            *LifecycleEvent `json:"last_crash,omitempty"`
+}
+
+// Add counts a lifecycle event towards the crash stats, using the same
+// classification as GetCrashStats. Events must be added oldest first.
+func (c *ExportCrashStats) Add(e LifecycleEvent) {
+	switch e.Event {
+	case "CRASH", "SIGNAL", "CONNECTION_LOST":
+	default:
+		return
+	}
+
+	c.TotalCrashes++
+	if e.RouteAll {
+		c.CrashesWithRouteAll++
+		if !e.RouteRestored {
+			c.RouteRestoreFailures++
+		}
+	}
+	last := e
+	c.LastCrash = &last
+}
+
+// ExportRange reads logs, raw metrics and lifecycle events within the time range,
+// oldest first, along with crash statistics for the same window.
+func (s *Store) ExportRange(tr *TimeRange) (*ExportBundle, error) {
+	bundle := &ExportBundle{
+		Start:     tr.Start,
+		End:       tr.End,
+		Logs:      []*LogEntry{},
+		Metrics:   []MetricPoint{},
+		Lifecycle: []LifecycleEvent{},
+	}
+
+	if _, _, err := s.StreamLogs(tr, 0, func(e *LogEntry) error {
+		bundle.Logs = append(bundle.Logs, e)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if _, _, err := s.StreamMetrics(tr, nil, "raw", 0, func(p MetricPoint) error {
+		bundle.Metrics = append(bundle.Metrics, p)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if _, _, err := s.StreamLifecycle(tr, 0, func(e LifecycleEvent) error {
+		bundle.Lifecycle = append(bundle.Lifecycle, e)
+		bundle.CrashStats.Add(e)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return bundle, nil
+}
+
+// StreamLogs calls fn for each log entry in the time range, oldest first.
+// At most maxRows entries are delivered (0 = unlimited). It returns the number
+// of entries delivered and whether more were available.
+func (s *Store) StreamLogs(tr *TimeRange, maxRows int, fn func(*LogEntry) error) (int, bool, error) {
+	var lastID int64
+	delivered := 0
+
+	for {
+		pageSize := nextPageSize(maxRows, delivered)
+
+		s.mu.RLock()
+		rows, err := s.db.Query(`
+			SELECT id, timestamp, level, component, message, fields
+			FROM logs
+			WHERE timestamp >= ? AND timestamp <= ? AND id > ?
+			ORDER BY id ASC
+			LIMIT ?
+		`, tr.Start.UnixMilli(), tr.End.UnixMilli(), lastID, pageSize)
+		if err != nil {
+			s.mu.RUnlock()
+			return delivered, false, err
+		}
+
+		var page []*LogEntry
+		for rows.Next() {
+			var e LogEntry
+			var tsMs int64
+			var fields sql.NullString
+			if err := rows.Scan(&e.ID, &tsMs, &e.Level, &e.Component, &e.Message, &fields); err != nil {
+				rows.Close()
+				s.mu.RUnlock()
+				return delivered, false, err
+			}
+			e.Timestamp = time.UnixMilli(tsMs)
+			e.Fields = fields.String
+			page = append(page, &e)
+		}
+		err = rows.Err()
+		rows.Close()
+		s.mu.RUnlock()
+		if err != nil {
+			return delivered, false, err
+		}
+
+		for _, e := range page {
+			if maxRows > 0 && delivered >= maxRows {
+				return delivered, true, nil
+			}
+			if err := fn(e); err != nil {
+				return delivered, false, err
+			}
+			delivered++
+			lastID = e.ID
+		}
+
+		if len(page) < pageSize {
+			return delivered, false, nil
+		}
+	}
+}
+
+// StreamMetrics calls fn for each metric point in the time range, oldest first.
+// Names filters by metric name (empty = all). Granularity selects the raw, 1m or
+// 1h table ("" defaults to raw, "auto" picks based on the range and retention
+// policy). At most maxRows points are delivered (0 = unlimited).
+func (s *Store) StreamMetrics(tr *TimeRange, names []string, granularity string, maxRows int, fn func(MetricPoint) error) (int, bool, error) {
+	if granularity == "" {
+		granularity = "raw"
+	}
+	if granularity == "auto" {
+		granularity = s.RetentionPolicy().Granularity(tr)
+	}
+
+	table := "metrics_raw"
+	valueCol := "value"
+	switch granularity {
+	case "raw":
+	case "1m":
+		table = "metrics_1m"
+		valueCol = "avg_value"
+	case "1h":
+		table = "metrics_1h"
+		valueCol = "avg_value"
+	default:
+		return 0, false, fmt.Errorf("unknown granularity: %s", granularity)
+	}
+
+	nameFilter := ""
+	var nameArgs []interface{}
+	if len(names) > 0 {
+		placeholders := make([]string, len(names))
+		for i, name := range names {
+			placeholders[i] = "?"
+			nameArgs = append(nameArgs, name)
+		}
+		nameFilter = fmt.Sprintf("AND name IN (%s)", strings.Join(placeholders, ","))
+	}
+
+	query := fmt.Sprintf(`
+		SELECT timestamp, name, %s, tags
+		FROM %s
+		WHERE timestamp >= ? AND timestamp <= ?
+		AND (timestamp, name, tags) > (?, ?, ?)
+		%s
+		ORDER BY timestamp ASC, name ASC, tags ASC
+		LIMIT ?
+	`, valueCol, table, nameFilter)
+
+	// Keyset pagination on the (timestamp, name, tags) primary key
+	lastTs := tr.Start.UnixMilli() - 1
+	lastName, lastTags := "", ""
+	delivered := 0
+
+	for {
+		pageSize := nextPageSize(maxRows, delivered)
+
+		args := []interface{}{tr.Start.UnixMilli(), tr.End.UnixMilli(), lastTs, lastName, lastTags}
+		args = append(args, nameArgs...)
+		args = append(args, pageSize)
+
+		s.mu.RLock()
+		rows, err := s.db.Query(query, args...)
+		if err != nil {
+			s.mu.RUnlock()
+			return delivered, false, err
+		}
+
+		var page []MetricPoint
+		for rows.Next() {
+			var p MetricPoint
+			var tsMs int64
+			var tags sql.NullString
+			if err := rows.Scan(&tsMs, &p.Name, &p.Value, &tags); err != nil {
+				rows.Close()
+				s.mu.RUnlock()
+				return delivered, false, err
+			}
+			p.Timestamp = time.UnixMilli(tsMs)
+			p.Tags = tags.String
+			p.Granularity = granularity
+			page = append(page, p)
+		}
+		err = rows.Err()
+		rows.Close()
+		s.mu.RUnlock()
+		if err != nil {
+			return delivered, false, err
+		}
+
+		for _, p := range page {
+			if maxRows > 0 && delivered >= maxRows {
+				return delivered, true, nil
+			}
+			if err := fn(p); err != nil {
+				return delivered, false, err
+			}
+			delivered++
+			lastTs = p.Timestamp.UnixMilli()
+			lastName = p.Name
+			lastTags = p.Tags
+		}
+
+		if len(page) < pageSize {
+			return delivered, false, nil
+		}
+	}
+}
+
+// StreamLifecycle calls fn for each lifecycle event in the time range, oldest first.
+// At most maxRows events are delivered (0 = unlimited).
+func (s *Store) StreamLifecycle(tr *TimeRange, maxRows int, fn func(LifecycleEvent) error) (int, bool, error) {
+	var lastID int64
+	delivered := 0
+
+	for {
+		pageSize := nextPageSize(maxRows, delivered)
+
+		s.mu.RLock()
+		rows, err := s.db.Query(`
+			SELECT id, timestamp, event, reason, uptime_seconds, route_all, route_restored, version
+			FROM lifecycle
+			WHERE timestamp >= ? AND timestamp <= ? AND id > ?
+			ORDER BY id ASC
+			LIMIT ?
+		`, tr.Start.UnixMilli(), tr.End.UnixMilli(), lastID, pageSize)
+		if err != nil {
+			s.mu.RUnlock()
+			return delivered, false, err
+		}
+
+		var page []LifecycleEvent
+		for rows.Next() {
+			var e LifecycleEvent
+			var tsMs int64
+			var routeAllInt, routeRestoredInt int
+			var reason, version sql.NullString
+			if err := rows.Scan(&e.ID, &tsMs, &e.Event, &reason, &e.UptimeSeconds, &routeAllInt, &routeRestoredInt, &version); err != nil {
+				rows.Close()
+				s.mu.RUnlock()
+				return delivered, false, err
+			}
+			e.Timestamp = time.UnixMilli(tsMs)
+			e.Reason = reason.String
+			e.Version = version.String
+			e.RouteAll = routeAllInt == 1
+			e.RouteRestored = routeRestoredInt == 1
+			page = append(page, e)
+		}
+		err = rows.Err()
+		rows.Close()
+		s.mu.RUnlock()
+		if err != nil {
+			return delivered, false, err
+		}
+
+		for _, e := range page {
+			if maxRows > 0 && delivered >= maxRows {
+				return delivered, true, nil
+			}
+			if err := fn(e); err != nil {
+				return delivered, false, err
+			}
+			delivered++
+			lastID = e.ID
+		}
+
+		if len(page) < pageSize {
+			return delivered, false, nil
+		}
+	}
+}
+
+// nextPageSize returns how many rows to fetch next. When a row cap is set it
+// fetches one row past the cap so truncation can be detected.
+func nextPageSize(maxRows, delivered int) int {
+	if maxRows > 0 && maxRows-delivered+1 < exportPageSize {
+		return maxRows - delivered + 1
+	}
+	return exportPageSize
+}
@@ -0,0 +1,58 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// FieldFilter matches log entries by one of their structured fields (the
+// JSON object in the fields column), e.g. {Key: "peer_ip", Op: "=",
+// Value: "10.8.0.3"}. Values are compared as text.
+type FieldFilter struct {
+	Key   string `json:"key"`
+	Op    string `json:"op"` // "=" or "!=" (a missing field counts as different)
+	Value string `json:"value"`
+}
+
+// ValidFieldOp reports whether op is a FieldFilter operator.
+func ValidFieldOp(op string) bool {
+	return op == "=" || op == "!="
+}
+
+// validate checks the key and operator.
+func (f FieldFilter) validate() error {
+	if f.Key == "" || strings.ContainsAny(f.Key, `"\`) {
+		return fmt.Errorf("invalid field name %q", f.Key)
+	}
+	if !ValidFieldOp(f.Op) {
+		return fmt.Errorf("unknown field operator %q (use = or !=)", f.Op)
+	}
+	return nil
+}
+
+// condition returns the SQL condition on column (fields, or tags for
+// metrics) for the filter and its arguments. Rows without fields store "",
+// which json_extract cannot parse, so the column is only read when it holds
+// valid JSON.
+func (f FieldFilter) condition(column string) (string, []interface{}) {
+	value := fmt.Sprintf(`CAST(CASE WHEN json_valid(%s) THEN json_extract(%s, ?) END AS TEXT)`, column, column)
+	path := `$."` + f.Key + `"`
+	if f.Op == "!=" {
+		return fmt.Sprintf("(%s IS NULL OR %s != ?)", value, value), []interface{}{path, path, f.Value}
+	}
+	return value + " = ?", []interface{}{path, f.Value}
+}
+
+// Matches applies the filter to an entry's JSON-encoded fields, for entries
+// that do not come from QueryLogs (e.g. streamed to 'vpn logs --follow').
+func (f FieldFilter) Matches(fieldsJSON string) bool {
+	var fields map[string]interface{}
+	json.Unmarshal([]byte(fieldsJSON), &fields)
+
+	v, ok := fields[f.Key]
+	if f.Op == "!=" {
+		return !ok || v == nil || fmt.Sprint(v) != f.Value
+	}
+	return ok && v != nil && fmt.Sprint(v) == f.Value
+}
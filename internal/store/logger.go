@@ -1,11 +1,13 @@
 package store
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -137,39 +139,168 @@ func (l *Logger) RedirectStdLog(level string) {
 	log.SetFlags(0) // Remove default timestamp since we add our own
 }
 
+// logLevels orders log levels from least to most severe.
+var logLevels = map[string]int{
+	"DEBUG": 0,
+	"INFO":  1,
+	"WARN":  2,
+	"ERROR": 3,
+}
+
+// ValidLogLevel reports whether level is DEBUG, INFO, WARN or ERROR.
+func ValidLogLevel(level string) bool {
+	_, ok := logLevels[level]
+	return ok
+}
+
 // LogWriter wraps Store to provide an io.Writer interface for existing log.* calls.
 // This intercepts standard log output and stores it.
 type LogWriter struct {
 	store     *Store
 	component string
 	level     string
+
+	// Output format ("text" or "json"), where lines are echoed (stdout by
+	// default) and extra metadata attached to every entry; set before the
+	// writer is in use
+	format string
+	out    io.Writer
+	fields map[string]string
+
+	// Messages below minLevel are dropped ("" = keep everything)
+	minLevel   string
+	minLevelMu sync.RWMutex
 }
 
-// NewLogWriter creates a writer that captures log output.
+// SetMinLevel drops messages less severe than level ("" keeps everything).
+func (w *LogWriter) SetMinLevel(level string) error {
+	if level != "" && !ValidLogLevel(level) {
+		return fmt.Errorf("invalid log level: %s (use DEBUG, INFO, WARN or ERROR)", level)
+	}
+	w.minLevelMu.Lock()
+	w.minLevel = level
+	w.minLevelMu.Unlock()
+	return nil
+}
+
+// MinLevel returns the minimum level being recorded ("" = everything).
+func (w *LogWriter) MinLevel() string {
+	w.minLevelMu.RLock()
+	defer w.minLevelMu.RUnlock()
+	return w.minLevel
+}
+
+// NewLogWriter creates a writer that captures log output. A nil store only
+// formats output.
 func NewLogWriter(store *Store, component, level string) *LogWriter {
 	return &LogWriter{
 		store:     store,
 		component: component,
 		level:     level,
+		format:    "text",
+		out:       os.Stdout,
+	}
+}
+
+// ValidLogFormat reports whether format is "text" or "json".
+func ValidLogFormat(format string) bool {
+	return format == "text" || format == "json"
+}
+
+// SetFormat selects how lines are written to stdout: "text" passes them
+// through unchanged, "json" writes one JSON object per line, e.g.
+//
+//	{"ts":"2024-01-15T14:30:00.000Z","level":"INFO","component":"node","msg":"..."}
+func (w *LogWriter) SetFormat(format string) error {
+	if !ValidLogFormat(format) {
+		return fmt.Errorf("invalid log format: %s (use text or json)", format)
 	}
+	w.format = format
+	return nil
+}
+
+// SetOutput sets where lines are echoed besides the store, e.g. os.Stderr
+// when stdout is reserved for something else.
+func (w *LogWriter) SetOutput(out io.Writer) {
+	w.out = out
+}
+
+// SetFields attaches extra metadata to every entry: stored in the fields
+// column and, in json format, added as top-level keys.
+func (w *LogWriter) SetFields(fields map[string]string) {
+	w.fields = fields
+}
+
+// parseLogLine splits a standard log line, "2024/01/15 14:30:00 [component] message"
+// (timestamp optional), into its component and message. ok is false when
+// there is no [component] prefix.
+func parseLogLine(line string) (component, msg string, ok bool) {
+	const stdTimestamp = "2006/01/02 15:04:05"
+	if len(line) > len(stdTimestamp) {
+		if _, err := time.Parse(stdTimestamp, line[:len(stdTimestamp)]); err == nil {
+			line = strings.TrimSpace(line[len(stdTimestamp):])
+		}
+	}
+
+	if !strings.HasPrefix(line, "[") {
+		return "", line, false
+	}
+	end := strings.Index(line, "]")
+	if end < 2 || strings.ContainsAny(line[1:end], " \t") {
+		return "", line, false
+	}
+	return line[1:end], strings.TrimSpace(line[end+1:]), true
 }
 
 func (w *LogWriter) Write(p []byte) (n int, err error) {
-	msg := strings.TrimSpace(string(p))
-	if msg == "" {
+	return w.write(p, nil)
+}
+
+// WithFields returns a writer that records each line like Write, with
+// fields added to the writer's own, e.g. for log.New(w.WithFields(...), "",
+// log.Flags()) to tag the lines about one peer.
+func (w *LogWriter) WithFields(fields map[string]string) io.Writer {
+	return &fieldsLogWriter{w: w, fields: fields}
+}
+
+// fieldsLogWriter is returned by LogWriter.WithFields.
+type fieldsLogWriter struct {
+	w      *LogWriter
+	fields map[string]string
+}
+
+func (f *fieldsLogWriter) Write(p []byte) (int, error) {
+	return f.w.write(p, f.fields)
+}
+
+// entryFields returns the writer's fields merged with extra (which win).
+func (w *LogWriter) entryFields(extra map[string]string) map[string]string {
+	if len(extra) == 0 {
+		return w.fields
+	}
+	fields := make(map[string]string, len(w.fields)+len(extra))
+	for k, v := range w.fields {
+		fields[k] = v
+	}
+	for k, v := range extra {
+		fields[k] = v
+	}
+	return fields
+}
+
+// write records one log line with the writer's fields and extra.
+func (w *LogWriter) write(p []byte, extra map[string]string) (n int, err error) {
+	line := strings.TrimSpace(string(p))
+	if line == "" {
 		return len(p), nil
 	}
 
-	// Extract component from [component] prefix
-	component := w.component
 	level := w.level
 
-	// Parse log format: "2024/01/15 14:30:00 [component] message"
-	if idx := strings.Index(msg, "["); idx >= 0 {
-		if endIdx := strings.Index(msg[idx:], "]"); endIdx > 0 {
-			component = msg[idx+1 : idx+endIdx]
-			msg = strings.TrimSpace(msg[idx+endIdx+1:])
-		}
+	// Extract component from [component] prefix
+	component, msg, ok := parseLogLine(line)
+	if !ok {
+		component = w.component
 	}
 
 	// Detect level from message content
@@ -182,16 +313,65 @@ func (w *LogWriter) Write(p []byte) (n int, err error) {
 		level = "DEBUG"
 	}
 
+	if minLevel := w.MinLevel(); minLevel != "" && logLevels[level] < logLevels[minLevel] {
+		return len(p), nil
+	}
+
+	fields := w.entryFields(extra)
+
 	// Write to store
 	if w.store != nil {
-		w.store.WriteLog(level, component, msg, "")
+		w.store.WriteLogWithFields(level, component, msg, fields)
 	}
 
-	// Also write to original stdout
-	os.Stdout.Write(p)
+	// Also echo to stdout (or the configured output)
+	if w.format == "json" {
+		w.out.Write(jsonLogLine(level, component, msg, fields))
+	} else {
+		w.out.Write(p)
+	}
 	return len(p), nil
 }
 
+// jsonLogLine encodes an entry as a JSON line: ts, level, component and msg
+// first, then the extra fields sorted by key.
+func jsonLogLine(level, component, msg string, fields map[string]string) []byte {
+	var buf bytes.Buffer
+	add := func(key, value string) {
+		if buf.Len() == 0 {
+			buf.WriteByte('{')
+		} else {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		v, _ := json.Marshal(value)
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+
+	add("ts", time.Now().UTC().Format("2006-01-02T15:04:05.000Z07:00"))
+	add("level", level)
+	add("component", component)
+	add("msg", msg)
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		switch k {
+		case "ts", "level", "component", "msg":
+			continue // Never shadow the standard keys
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		add(k, fields[k])
+	}
+
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
 // MultiWriter writes to multiple writers.
 func MultiWriter(writers ...io.Writer) io.Writer {
 	return io.MultiWriter(writers...)
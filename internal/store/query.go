@@ -1,29 +1,36 @@
 package store
 
 import (
+	"encoding/csv"
 	"fmt"
+	"io"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
 // LogQuery represents a query for logs.
 type LogQuery struct {
-	TimeRange  *TimeRange
-	Levels     []string // Filter by log levels
-	Components []string // Filter by components
-	Search     string   // Full-text search in message
-	Limit      int      // Max results (default 1000)
-	Offset     int      // Pagination offset
-	Reverse    bool     // If true, oldest first; default is newest first
+	TimeRange    *TimeRange
+	Levels       []string      // Filter by log levels
+	Components   []string      // Filter by components
+	Search       string        // Full-text search in message (AND/OR/NOT, "phrases"; see search.go)
+	FieldFilters []FieldFilter // Structured field filters, all of which must match
+	Limit        int           // Max results (default 1000)
+	Offset       int           // Pagination offset
+	Reverse      bool          // If true, oldest first; default is newest first
 }
 
 // MetricQuery represents a query for metrics.
 type MetricQuery struct {
 	TimeRange   *TimeRange
-	Names       []string // Metric names to query
-	Granularity string   // "raw", "1m", "1h", or "auto"
-	Aggregation string   // "avg", "min", "max", "sum", "count" (for grouping)
-	GroupBy     string   // Time grouping: "1m", "5m", "1h", etc.
+	Names       []string          // Metric names to query
+	Granularity string            // "raw", "1m", "1h", or "auto"
+	Aggregation string            // "avg", "sum", "min", "max", "count", "p95" (see ValidAggregation)
+	GroupBy     time.Duration     // Bucket size; 0 aggregates the whole range into one point
+	Fill        string            // Empty buckets with GroupBy: "null" (omit), "zero", "previous"
+	Tags        map[string]string // Only points carrying all of these tags, e.g. {"peer": "mac-mini"}
 }
 
 // LogQueryResult contains query results.
@@ -40,14 +47,65 @@ type MetricQueryResult struct {
 	Query  *MetricQuery   `json:"-"`
 }
 
-// MetricSeries represents a time series of metric values.
+// MetricSeries represents a time series of metric values: the points of
+// one metric with the same tags.
 type MetricSeries struct {
 	Name   string        `json:"name"`
+	Tags   string        `json:"tags,omitempty"` // JSON-encoded tags
 	Points []MetricPoint `json:"points"`
 }
 
+// QueryMetricsCSV runs the query and writes its points to w as CSV for
+// spreadsheets: a timestamp,metric,value header, then one row per point in
+// chronological order (by metric name at the same instant). Timestamps are
+// UTC as "2006-01-02 15:04:05", which spreadsheets recognize as dates, and
+// tagged series name their tags with the metric (see SeriesLabel).
+func (s *Store) QueryMetricsCSV(q *MetricQuery, w io.Writer) error {
+	result, err := s.QueryMetrics(q)
+	if err != nil {
+		return err
+	}
+
+	var points []MetricPoint
+	for _, series := range result.Series {
+		points = append(points, series.Points...)
+	}
+	sort.SliceStable(points, func(i, j int) bool {
+		if !points[i].Timestamp.Equal(points[j].Timestamp) {
+			return points[i].Timestamp.Before(points[j].Timestamp)
+		}
+		if points[i].Name != points[j].Name {
+			return points[i].Name < points[j].Name
+		}
+		return points[i].Tags < points[j].Tags
+	})
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"timestamp", "metric", "value"}); err != nil {
+		return err
+	}
+	for _, p := range points {
+		record := []string{
+			p.Timestamp.UTC().Format("2006-01-02 15:04:05"),
+			SeriesLabel(p.Name, p.Tags),
+			strconv.FormatFloat(p.Value, 'f', -1, 64),
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
 // QueryLogs queries logs with filters.
 func (s *Store) QueryLogs(q *LogQuery) (*LogQueryResult, error) {
+	for _, f := range q.FieldFilters {
+		if err := f.validate(); err != nil {
+			return nil, err
+		}
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -86,8 +144,15 @@ func (s *Store) QueryLogs(q *LogQuery) (*LogQueryResult, error) {
 	}
 
 	if q.Search != "" {
-		conditions = append(conditions, "message LIKE ?")
-		args = append(args, "%"+q.Search+"%")
+		condition, searchArgs := s.searchCondition(q.Search)
+		conditions = append(conditions, condition)
+		args = append(args, searchArgs...)
+	}
+
+	for _, f := range q.FieldFilters {
+		condition, fieldArgs := f.condition("fields")
+		conditions = append(conditions, condition)
+		args = append(args, fieldArgs...)
 	}
 
 	whereClause := ""
@@ -133,11 +198,18 @@ func (s *Store) QueryLogs(q *LogQuery) (*LogQueryResult, error) {
 		entries = append(entries, &e)
 	}
 
+	rows.Close()
+
 	hasMore := len(entries) > q.Limit
 	if hasMore {
 		entries = entries[:q.Limit]
 	}
 
+	// Highlight matched terms when searching the FTS5 index
+	if q.Search != "" {
+		s.addSnippets(entries, q.Search)
+	}
+
 	return &LogQueryResult{
 		Entries:    entries,
 		TotalCount: totalCount,
@@ -146,15 +218,32 @@ func (s *Store) QueryLogs(q *LogQuery) (*LogQueryResult, error) {
 	}, nil
 }
 
-// QueryMetrics queries metrics with aggregation.
+// QueryMetrics queries metrics with aggregation. Each metric has a series
+// per distinct set of tags (e.g. one per peer). Without an Aggregation or
+// GroupBy it returns the stored points unchanged; otherwise points are
+// combined per GroupBy bucket (or over the whole range if GroupBy is 0).
 func (s *Store) QueryMetrics(q *MetricQuery) (*MetricQueryResult, error) {
+	if !ValidAggregation(q.Aggregation) {
+		return nil, fmt.Errorf("unknown aggregation %q (use avg, sum, min, max, count or p95)", q.Aggregation)
+	}
+	if !ValidFill(q.Fill) {
+		return nil, fmt.Errorf("unknown fill %q (use null, zero or previous)", q.Fill)
+	}
+	if q.GroupBy < 0 {
+		return nil, fmt.Errorf("group by must be positive")
+	}
+	tagFilter, tagArgs, err := tagConditions(q.Tags)
+	if err != nil {
+		return nil, err
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	// Auto-select granularity based on time range
+	// Auto-select granularity based on time range and retention
 	granularity := q.Granularity
 	if granularity == "" || granularity == "auto" {
-		granularity = SuggestGranularity(q.TimeRange)
+		granularity = s.retention.Granularity(q.TimeRange)
 	}
 
 	// Select appropriate table
@@ -189,47 +278,224 @@ func (s *Store) QueryMetrics(q *MetricQuery) (*MetricQueryResult, error) {
 		}
 	}
 
+	aggregate := q.Aggregation != "" || q.GroupBy > 0
 	for _, name := range names {
-		series := MetricSeries{Name: name}
-
-		var rows interface{ Next() bool }
-		var err error
-
-		query := fmt.Sprintf(
-			"SELECT timestamp, %s FROM %s WHERE name = ? AND timestamp >= ? AND timestamp <= ? ORDER BY timestamp ASC",
-			valueCol, table,
-		)
-		dbRows, err := s.db.Query(query, name, q.TimeRange.Start.UnixMilli(), q.TimeRange.End.UnixMilli())
+		tagSets, err := s.metricTagSets(table, name, q.TimeRange, tagFilter, tagArgs)
 		if err != nil {
-			continue
+			return nil, fmt.Errorf("query failed: %w", err)
 		}
-		rows = dbRows
-		defer dbRows.Close()
 
-		for rows.Next() {
-			var ts int64
-			var value float64
-			if err := dbRows.Scan(&ts, &value); err != nil {
+		for _, tags := range tagSets {
+			series := MetricSeries{Name: name, Tags: tags}
+
+			var query string
+			var args []interface{}
+			if aggregate {
+				query, args = aggregateQuery(q, table, name, tags)
+			} else {
+				query = fmt.Sprintf(
+					"SELECT timestamp, %s FROM %s WHERE name = ? AND tags = ? AND timestamp >= ? AND timestamp <= ? ORDER BY timestamp ASC",
+					valueCol, table,
+				)
+				args = []interface{}{name, tags, q.TimeRange.Start.UnixMilli(), q.TimeRange.End.UnixMilli()}
+			}
+			dbRows, err := s.db.Query(query, args...)
+			if err != nil {
+				if aggregate {
+					return nil, fmt.Errorf("query failed: %w", err)
+				}
 				continue
 			}
-			series.Points = append(series.Points, MetricPoint{
-				Timestamp:   time.UnixMilli(ts),
-				Name:        name,
-				Value:       value,
-				Granularity: granularity,
-			})
-		}
 
-		if len(series.Points) > 0 {
-			result.Series = append(result.Series, series)
+			for dbRows.Next() {
+				var ts int64
+				var value float64
+				if err := dbRows.Scan(&ts, &value); err != nil {
+					continue
+				}
+				series.Points = append(series.Points, MetricPoint{
+					Timestamp:   time.UnixMilli(ts),
+					Name:        name,
+					Value:       value,
+					Tags:        tags,
+					Granularity: granularity,
+				})
+			}
+			dbRows.Close()
+
+			if q.GroupBy > 0 {
+				series.Points = fillBuckets(series.Points, q, name, tags, granularity)
+			}
+
+			if len(series.Points) > 0 {
+				result.Series = append(result.Series, series)
+			}
 		}
 	}
 
 	return result, nil
 }
 
-// GetLatestMetrics returns the latest value for each metric.
-func (s *Store) GetLatestMetrics(names []string) (map[string]float64, error) {
+// metricTagSets returns the distinct tags of a metric's points in the time
+// range that pass tagFilter (see tagConditions), one per series.
+func (s *Store) metricTagSets(table, name string, tr *TimeRange, tagFilter string, tagArgs []interface{}) ([]string, error) {
+	query := fmt.Sprintf("SELECT DISTINCT tags FROM %s WHERE name = ? AND timestamp >= ? AND timestamp <= ?%s ORDER BY tags",
+		table, tagFilter)
+	args := append([]interface{}{name, tr.Start.UnixMilli(), tr.End.UnixMilli()}, tagArgs...)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tagSets []string
+	for rows.Next() {
+		var tags string
+		if err := rows.Scan(&tags); err != nil {
+			return nil, err
+		}
+		tagSets = append(tagSets, tags)
+	}
+	return tagSets, rows.Err()
+}
+
+// ValidAggregation reports whether agg is a MetricQuery aggregation
+// ("" means none, or avg when GroupBy is set).
+func ValidAggregation(agg string) bool {
+	switch agg {
+	case "", "avg", "sum", "min", "max", "count", "p95":
+		return true
+	}
+	return false
+}
+
+// ValidFill reports whether fill is a MetricQuery fill mode ("" = null).
+func ValidFill(fill string) bool {
+	switch fill {
+	case "", "null", "zero", "previous":
+		return true
+	}
+	return false
+}
+
+// bucketOrigin returns the start of the first bucket and the bucket size in
+// milliseconds. Buckets are aligned to GroupBy (so 5m buckets start at :00,
+// :05, ...); without GroupBy the whole range is one bucket.
+func bucketOrigin(q *MetricQuery) (origin, size int64) {
+	start, end := q.TimeRange.Start.UnixMilli(), q.TimeRange.End.UnixMilli()
+	if q.GroupBy <= 0 {
+		return start, end - start + 1
+	}
+	size = q.GroupBy.Milliseconds()
+	if size < 1 {
+		size = 1
+	}
+	return start - start%size, size
+}
+
+// aggregateQuery builds the SQL that aggregates one series per bucket,
+// returning (bucket start, value) rows. Rollup tables already hold per-minute
+// or per-hour min/max/sum/count, so those columns are combined instead of
+// the averages. p95 is the nearest-rank percentile: the smallest value whose
+// CUME_DIST() within its bucket reaches 0.95.
+func aggregateQuery(q *MetricQuery, table, name, tags string) (string, []interface{}) {
+	origin, size := bucketOrigin(q)
+	bucket := fmt.Sprintf("(%d + ((timestamp - %d) / %d) * %d)", origin, origin, size, size)
+	where := "name = ? AND tags = ? AND timestamp >= ? AND timestamp <= ?"
+	args := []interface{}{name, tags, q.TimeRange.Start.UnixMilli(), q.TimeRange.End.UnixMilli()}
+
+	raw := table == "metrics_raw"
+	var expr string
+	switch q.Aggregation {
+	case "sum":
+		expr = "SUM(sum_value)"
+		if raw {
+			expr = "SUM(value)"
+		}
+	case "min":
+		expr = "MIN(min_value)"
+		if raw {
+			expr = "MIN(value)"
+		}
+	case "max":
+		expr = "MAX(max_value)"
+		if raw {
+			expr = "MAX(value)"
+		}
+	case "count":
+		expr = "SUM(count)"
+		if raw {
+			expr = "COUNT(*)"
+		}
+	case "p95":
+		valueCol := "avg_value"
+		if raw {
+			valueCol = "value"
+		}
+		return fmt.Sprintf(`
+			SELECT bucket, MIN(v) FROM (
+				SELECT %s AS bucket, %s AS v,
+					CUME_DIST() OVER (PARTITION BY %s ORDER BY %s) AS dist
+				FROM %s WHERE %s
+			) WHERE dist >= 0.95 GROUP BY bucket ORDER BY bucket ASC`,
+			bucket, valueCol, bucket, valueCol, table, where), args
+	default:
+		// Weighted by the number of raw points behind each rollup
+		expr = "SUM(sum_value) / SUM(count)"
+		if raw {
+			expr = "AVG(value)"
+		}
+	}
+
+	return fmt.Sprintf("SELECT %s AS bucket, %s FROM %s WHERE %s GROUP BY bucket ORDER BY bucket ASC",
+		bucket, expr, table, where), args
+}
+
+// fillBuckets adds a point for every empty GroupBy bucket in the range:
+// 0 for "zero", the last value seen for "previous". "null" (the default)
+// leaves empty buckets out.
+func fillBuckets(points []MetricPoint, q *MetricQuery, name, tags, granularity string) []MetricPoint {
+	if q.Fill == "" || q.Fill == "null" {
+		return points
+	}
+
+	origin, size := bucketOrigin(q)
+	end := q.TimeRange.End.UnixMilli()
+	if (end-origin)/size > 100000 {
+		return points // Too many buckets to fill sensibly
+	}
+
+	filled := make([]MetricPoint, 0, (end-origin)/size+1)
+	i := 0
+	var previous *MetricPoint
+	for ts := origin; ts <= end; ts += size {
+		if i < len(points) && points[i].Timestamp.UnixMilli() == ts {
+			filled = append(filled, points[i])
+			previous = &points[i]
+			i++
+			continue
+		}
+		point := MetricPoint{Timestamp: time.UnixMilli(ts), Name: name, Tags: tags, Granularity: granularity}
+		if q.Fill == "previous" {
+			if previous == nil {
+				continue
+			}
+			point.Value = previous.Value
+		}
+		filled = append(filled, point)
+	}
+	return filled
+}
+
+// GetLatestMetrics returns the latest value for each metric, among the
+// points carrying all of tags (nil for any).
+func (s *Store) GetLatestMetrics(names []string, tags map[string]string) (map[string]float64, error) {
+	tagFilter, tagArgs, err := tagConditions(tags)
+	if err != nil {
+		return nil, err
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -238,8 +504,8 @@ func (s *Store) GetLatestMetrics(names []string) (map[string]float64, error) {
 	for _, name := range names {
 		var value float64
 		err := s.db.QueryRow(
-			"SELECT value FROM metrics_raw WHERE name = ? ORDER BY timestamp DESC LIMIT 1",
-			name,
+			"SELECT value FROM metrics_raw WHERE name = ?"+tagFilter+" ORDER BY timestamp DESC LIMIT 1",
+			append([]interface{}{name}, tagArgs...)...,
 		).Scan(&value)
 		if err == nil {
 			result[name] = value
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := New(t.TempDir(), Options{})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// seedAggregate inserts one row per step into an aggregate metrics table.
+func seedAggregate(t *testing.T, s *Store, table, name string, from, to time.Time, step time.Duration) int {
+	t.Helper()
+	n := 0
+	for ts := from; ts.Before(to); ts = ts.Add(step) {
+		_, err := s.db.Exec(
+			"INSERT INTO "+table+" (timestamp, name, min_value, max_value, avg_value, sum_value, count, tags) VALUES (?, ?, 1, 1, 1, 1, 1, '')",
+			ts.UnixMilli(), name,
+		)
+		if err != nil {
+			t.Fatalf("seeding %s: %v", table, err)
+		}
+		n++
+	}
+	return n
+}
+
+func TestQueryMetricsThreeDaysUsesHourly(t *testing.T) {
+	s := newTestStore(t)
+
+	now := time.Now().Truncate(time.Hour)
+	hourly := seedAggregate(t, s, "metrics_1h", "vpn.bytes_in", now.Add(-72*time.Hour), now, time.Hour)
+	seedAggregate(t, s, "metrics_1m", "vpn.bytes_in", now.Add(-time.Hour), now, time.Minute)
+
+	tr, err := ParseTimeRange("-3d", "now")
+	if err != nil {
+		t.Fatalf("ParseTimeRange: %v", err)
+	}
+	if got := s.RetentionPolicy().Granularity(tr); got != "1h" {
+		t.Fatalf("granularity for 72h: got %q, want 1h", got)
+	}
+
+	result, err := s.QueryMetrics(&MetricQuery{TimeRange: tr, Names: []string{"vpn.bytes_in"}})
+	if err != nil {
+		t.Fatalf("QueryMetrics: %v", err)
+	}
+	if len(result.Series) != 1 {
+		t.Fatalf("got %d series, want 1", len(result.Series))
+	}
+	points := result.Series[0].Points
+	// The first hour may fall just before the range start
+	if len(points) < hourly-1 || len(points) > hourly {
+		t.Errorf("got %d points, want about %d hourly points", len(points), hourly)
+	}
+	for i, p := range points {
+		if p.Granularity != "1h" {
+			t.Fatalf("point %d: granularity %q, want 1h", i, p.Granularity)
+		}
+		if i > 0 && p.Timestamp.Sub(points[i-1].Timestamp) != time.Hour {
+			t.Fatalf("points %d and %d are %s apart, want 1h", i-1, i, p.Timestamp.Sub(points[i-1].Timestamp))
+		}
+	}
+}
+
+func TestQueryMetricsRecentHoursUseMinutes(t *testing.T) {
+	s := newTestStore(t)
+
+	now := time.Now().Truncate(time.Minute)
+	seedAggregate(t, s, "metrics_1h", "vpn.bytes_in", now.Add(-6*time.Hour), now, time.Hour)
+	seedAggregate(t, s, "metrics_1m", "vpn.bytes_in", now.Add(-6*time.Hour), now, time.Minute)
+
+	tr, err := ParseTimeRange("-6h", "now")
+	if err != nil {
+		t.Fatalf("ParseTimeRange: %v", err)
+	}
+	result, err := s.QueryMetrics(&MetricQuery{TimeRange: tr, Names: []string{"vpn.bytes_in"}})
+	if err != nil {
+		t.Fatalf("QueryMetrics: %v", err)
+	}
+	if len(result.Series) != 1 || len(result.Series[0].Points) == 0 {
+		t.Fatalf("got no points")
+	}
+	if got := result.Series[0].Points[0].Granularity; got != "1m" {
+		t.Errorf("granularity for 6h: got %q, want 1m", got)
+	}
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// retentionMetaKey is the meta table key holding the persisted retention policy.
+const retentionMetaKey = "retention_policy"
+
+// RetentionPolicy controls how long logs and metrics are kept.
+// Zero values fall back to the package defaults.
+type RetentionPolicy struct {
+	Logs       time.Duration `json:"logs"`
+	MetricsRaw time.Duration `json:"metrics_raw"`
+	Metrics1m  time.Duration `json:"metrics_1m"`
+	Metrics1h  time.Duration `json:"metrics_1h"`
+}
+
+// DefaultRetentionPolicy returns the built-in retention policy.
+func DefaultRetentionPolicy() RetentionPolicy {
+	return RetentionPolicy{
+		Logs:       LogsRetention,
+		MetricsRaw: MetricsRetentionRaw,
+		Metrics1m:  MetricsRetention1m,
+		Metrics1h:  MetricsRetention1h,
+	}
+}
+
+// merge returns p with any non-zero fields of other applied on top.
+func (p RetentionPolicy) merge(other RetentionPolicy) RetentionPolicy {
+	if other.Logs > 0 {
+		p.Logs = other.Logs
+	}
+	if other.MetricsRaw > 0 {
+		p.MetricsRaw = other.MetricsRaw
+	}
+	if other.Metrics1m > 0 {
+		p.Metrics1m = other.Metrics1m
+	}
+	if other.Metrics1h > 0 {
+		p.Metrics1h = other.Metrics1h
+	}
+	return p
+}
+
+// Granularity picks the metric table for a time range: raw for ranges up to
+// MetricsRetentionRaw, 1m up to MetricsRetention1m and 1h beyond. A finer
+// table is only used if it still holds the start of the range, so a short
+// range from three days ago reads the 1h aggregates rather than an empty
+// raw table.
+func (p RetentionPolicy) Granularity(tr *TimeRange) string {
+	duration := tr.End.Sub(tr.Start)
+	age := time.Since(tr.Start)
+
+	if duration <= MetricsRetentionRaw && age <= p.MetricsRaw {
+		return "raw"
+	}
+	if duration <= MetricsRetention1m && age <= p.Metrics1m {
+		return "1m"
+	}
+	return "1h"
+}
+
+// RetentionPolicy returns the retention policy currently in effect.
+func (s *Store) RetentionPolicy() RetentionPolicy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.retention
+}
+
+// SetRetentionPolicy changes the retention policy at runtime. Zero fields keep
+// their current value. The policy is persisted to the meta table and an
+// immediate retention pass is run.
+func (s *Store) SetRetentionPolicy(policy RetentionPolicy) (RetentionPolicy, error) {
+	s.mu.Lock()
+	s.retention = s.retention.merge(policy)
+	current := s.retention
+	err := s.saveRetentionPolicy(current)
+	s.mu.Unlock()
+
+	if err != nil {
+		return current, fmt.Errorf("failed to persist retention policy: %w", err)
+	}
+
+	log.Printf("[store] Retention policy updated (logs=%s, metrics_raw=%s, metrics_1m=%s, metrics_1h=%s)",
+		FormatDuration(current.Logs), FormatDuration(current.MetricsRaw),
+		FormatDuration(current.Metrics1m), FormatDuration(current.Metrics1h))
+
+	s.enforceRetention()
+	return current, nil
+}
+
+// loadRetentionPolicy reads the persisted retention policy, if any.
+func (s *Store) loadRetentionPolicy() (RetentionPolicy, error) {
+	var policy RetentionPolicy
+	var value string
+	err := s.db.QueryRow("SELECT value FROM meta WHERE key = ?", retentionMetaKey).Scan(&value)
+	if err == sql.ErrNoRows {
+		return policy, nil
+	}
+	if err != nil {
+		return policy, err
+	}
+	if err := json.Unmarshal([]byte(value), &policy); err != nil {
+		return policy, err
+	}
+	return policy, nil
+}
+
+// saveRetentionPolicy persists the retention policy. Caller must hold s.mu.
+func (s *Store) saveRetentionPolicy(policy RetentionPolicy) error {
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("INSERT OR REPLACE INTO meta (key, value) VALUES (?, ?)", retentionMetaKey, string(data))
+	return err
+}
@@ -0,0 +1,131 @@
+package store
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Log sampling: WriteLog keeps at most Options.LogRateLimit entries per
+// second (with a burst of as many) for each (component, level) pair, using a
+// rate.Limiter per pair, so a component stuck in a loop
+// cannot fill the database in minutes. Dropped entries are counted, and
+// every minute each pair that dropped some gets one INFO entry saying how
+// many ("component tun suppressed 5400 DEBUG entries in last 60s").
+
+// DefaultLogRateLimit is the default for Options.LogRateLimit.
+const DefaultLogRateLimit = 100
+
+// logLimit is the sampling state of one (component, level) pair.
+type logLimit struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+	dropped  uint64 // Entries dropped since the last report
+}
+
+// logLimitKey is the logLimits key for a (component, level) pair.
+func logLimitKey(component, level string) string {
+	return component + ":" + level
+}
+
+// allowLog reports whether an entry of component at level may be written,
+// counting it as dropped if not.
+func (s *Store) allowLog(component, level string) bool {
+	if s.logRateLimit <= 0 {
+		return true
+	}
+	now := time.Now()
+
+	s.logLimitsMu.Lock()
+	defer s.logLimitsMu.Unlock()
+
+	key := logLimitKey(component, level)
+	l := s.logLimits[key]
+	if l == nil {
+		l = &logLimit{limiter: rate.NewLimiter(rate.Limit(s.logRateLimit), s.logRateLimit)}
+		s.logLimits[key] = l
+	}
+	l.lastUsed = now
+	if !l.limiter.AllowN(now, 1) {
+		l.dropped++
+		s.logDrops.Add(1)
+		return false
+	}
+	return true
+}
+
+// logSuppression is a report of entries dropped by allowLog.
+type logSuppression struct {
+	component, level string
+	dropped          uint64
+}
+
+// reportLogDrops writes one INFO entry for each (component, level) pair that
+// dropped entries since the last call (the maintenance loop's minute), and
+// forgets pairs that have been quiet for as long.
+func (s *Store) reportLogDrops() {
+	cutoff := time.Now().Add(-time.Minute)
+
+	var reports []logSuppression
+	s.logLimitsMu.Lock()
+	for key, l := range s.logLimits {
+		if l.dropped > 0 {
+			component, level := splitLogLimitKey(key)
+			reports = append(reports, logSuppression{component, level, l.dropped})
+			l.dropped = 0
+		} else if l.lastUsed.Before(cutoff) {
+			delete(s.logLimits, key)
+		}
+	}
+	s.logLimitsMu.Unlock()
+
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].component != reports[j].component {
+			return reports[i].component < reports[j].component
+		}
+		return reports[i].level < reports[j].level
+	})
+	for _, r := range reports {
+		msg := fmt.Sprintf("component %s suppressed %d %s entries in last 60s", r.component, r.dropped, r.level)
+		if err := s.insertLog("INFO", r.component, msg, ""); err != nil {
+			log.Printf("[store] Failed to write log suppression report: %v", err)
+		}
+	}
+}
+
+// splitLogLimitKey splits a logLimits key. Components may contain ':' but
+// levels do not, so the level is after the last one.
+func splitLogLimitKey(key string) (component, level string) {
+	for i := len(key) - 1; i >= 0; i-- {
+		if key[i] == ':' {
+			return key[:i], key[i+1:]
+		}
+	}
+	return key, ""
+}
+
+// LogDrops returns how many log entries were dropped by sampling since the
+// store was opened.
+func (s *Store) LogDrops() uint64 {
+	return s.logDrops.Load()
+}
+
+// Source returns the store's own metrics as a MetricSource for the collector.
+// logs.errors is the number of ERROR entries since the previous call, so an
+// alert rule like logs.errors>0 fires when an error is logged and clears
+// after a collection interval without one.
+func (s *Store) Source() MetricSource {
+	var lastErrors uint64
+	return func() map[string]float64 {
+		errors := s.logErrors.Load()
+		newErrors := errors - lastErrors
+		lastErrors = errors
+		return map[string]float64{
+			"store.log_drops_total": float64(s.LogDrops()),
+			"logs.errors":           float64(newErrors),
+		}
+	}
+}
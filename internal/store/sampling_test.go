@@ -0,0 +1,69 @@
+package store
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteLogSampling(t *testing.T) {
+	s, err := New(t.TempDir(), Options{LogRateLimit: 100})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer s.Close()
+
+	for i := 0; i < 250; i++ {
+		if err := s.WriteLog("DEBUG", "tun", "packet", ""); err != nil {
+			t.Fatalf("WriteLog: %v", err)
+		}
+	}
+	// Another pair is not affected
+	if err := s.WriteLog("INFO", "tun", "configured", ""); err != nil {
+		t.Fatalf("WriteLog: %v", err)
+	}
+
+	// The bucket refills while the loop runs, so allow a few extra entries
+	drops := s.LogDrops()
+	if drops < 140 || drops > 150 {
+		t.Errorf("dropped %d entries, want about 150", drops)
+	}
+
+	s.reportLogDrops()
+	result, err := s.QueryLogs(&LogQuery{Components: []string{"tun"}, Levels: []string{"INFO"}})
+	if err != nil {
+		t.Fatalf("QueryLogs: %v", err)
+	}
+	var report string
+	for _, e := range result.Entries {
+		if e.Message != "configured" {
+			report = e.Message
+		}
+	}
+	if want := "component tun suppressed"; !strings.HasPrefix(report, want) {
+		t.Errorf("suppression report %q, want one starting with %q", report, want)
+	}
+}
+
+func TestLogErrorsMetric(t *testing.T) {
+	s, err := New(t.TempDir(), Options{LogRateLimit: 1})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer s.Close()
+	source := s.Source()
+
+	if got := source()["logs.errors"]; got != 0 {
+		t.Fatalf("logs.errors before any error: %g", got)
+	}
+	// Sampled-out errors still count
+	for i := 0; i < 3; i++ {
+		s.WriteLog("ERROR", "vpn", "connection lost", "")
+	}
+	s.WriteLog("WARN", "vpn", "retrying", "")
+	if got := source()["logs.errors"]; got != 3 {
+		t.Errorf("logs.errors after 3 errors: got %g, want 3", got)
+	}
+	if got := source()["logs.errors"]; got != 0 {
+		t.Errorf("logs.errors in a quiet interval: got %g, want 0", got)
+	}
+}
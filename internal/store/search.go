@@ -0,0 +1,375 @@
+package store
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Log search syntax:
+//
+//	error reconnect              both words (AND is implied)
+//	error AND reconnect NOT timeout
+//	"connection lost" OR timeout quoted phrases
+//	error AND (tun OR route)     parentheses group
+//
+// Operators are uppercase. NOT binds tightest, then AND, then OR.
+// With FTS5 a term matches words starting with it; without FTS5 a term
+// matches anywhere in the message (LIKE).
+
+// Markers around matched terms in LogEntry.Snippet.
+const (
+	SnippetStart = "«"
+	SnippetEnd   = "»"
+)
+
+// searchNode is a parsed log search expression.
+type searchNode struct {
+	op       string // "term", "and", "or", "not"
+	term     string
+	children []*searchNode
+}
+
+// parseSearch parses a log search expression.
+func parseSearch(query string) (*searchNode, error) {
+	tokens, err := tokenizeSearch(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty search")
+	}
+
+	p := &searchParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	return node, nil
+}
+
+// searchToken is a word, phrase, operator or parenthesis.
+type searchToken struct {
+	text   string
+	phrase bool // Quoted: never an operator
+}
+
+func tokenizeSearch(query string) ([]searchToken, error) {
+	var tokens []searchToken
+	for i := 0; i < len(query); {
+		switch c := query[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, searchToken{text: string(c)})
+			i++
+		case c == '"':
+			end := strings.IndexByte(query[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+			tokens = append(tokens, searchToken{text: query[i+1 : i+1+end], phrase: true})
+			i += end + 2
+		default:
+			start := i
+			for i < len(query) && !strings.ContainsRune(" \t()\"", rune(query[i])) {
+				i++
+			}
+			tokens = append(tokens, searchToken{text: query[start:i]})
+		}
+	}
+	return tokens, nil
+}
+
+type searchParser struct {
+	tokens []searchToken
+	pos    int
+}
+
+// peek returns the next token's operator ("AND", "OR", "NOT", "(", ")") or "".
+func (p *searchParser) peek() string {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].phrase {
+		return ""
+	}
+	switch t := p.tokens[p.pos].text; t {
+	case "AND", "OR", "NOT", "(", ")":
+		return t
+	}
+	return ""
+}
+
+func (p *searchParser) parseOr() (*searchNode, error) {
+	node, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "OR" {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		node = combineSearch("or", node, right)
+	}
+	return node, nil
+}
+
+func (p *searchParser) parseAnd() (*searchNode, error) {
+	node, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.pos < len(p.tokens) {
+		switch p.peek() {
+		case "OR", ")":
+			return node, nil
+		case "AND":
+			p.pos++
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		node = combineSearch("and", node, right)
+	}
+	return node, nil
+}
+
+func (p *searchParser) parseUnary() (*searchNode, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("search ends with an operator")
+	}
+
+	switch p.peek() {
+	case "NOT":
+		p.pos++
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &searchNode{op: "not", children: []*searchNode{child}}, nil
+	case "(":
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return node, nil
+	case "":
+		term := p.tokens[p.pos].text
+		p.pos++
+		if term == "" {
+			return nil, fmt.Errorf("empty phrase")
+		}
+		return &searchNode{op: "term", term: term}, nil
+	default:
+		return nil, fmt.Errorf("unexpected %s", p.tokens[p.pos].text)
+	}
+}
+
+// combineSearch joins two nodes, flattening runs of the same operator.
+func combineSearch(op string, left, right *searchNode) *searchNode {
+	if left.op == op {
+		left.children = append(left.children, right)
+		return left
+	}
+	return &searchNode{op: op, children: []*searchNode{left, right}}
+}
+
+// MatchesSearch reports whether a log message matches a search, for
+// filtering entries that never go through QueryLogs (e.g. live streams).
+// Terms match anywhere in the message, ignoring case, as with LIKE.
+func MatchesSearch(query, message string) bool {
+	node, err := parseSearch(query)
+	if err != nil {
+		node = &searchNode{op: "term", term: query}
+	}
+	return node.matches(strings.ToLower(message))
+}
+
+// matches evaluates the expression against a lowercased message.
+func (n *searchNode) matches(message string) bool {
+	switch n.op {
+	case "term":
+		return strings.Contains(message, strings.ToLower(n.term))
+	case "not":
+		return !n.children[0].matches(message)
+	case "and":
+		for _, child := range n.children {
+			if !child.matches(message) {
+				return false
+			}
+		}
+		return true
+	default:
+		for _, child := range n.children {
+			if child.matches(message) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// searchCondition turns a search into a WHERE condition on the logs table.
+// A search that does not parse is matched literally, as before.
+func (s *Store) searchCondition(query string) (string, []interface{}) {
+	node, err := parseSearch(query)
+	if err != nil {
+		node = &searchNode{op: "term", term: query}
+	}
+
+	var args []interface{}
+	return s.searchSQL(node, &args), args
+}
+
+// addSnippets sets Snippet on entries matching a search: the FTS5 snippet of
+// the message with the search's terms (except those under NOT) between
+// SnippetStart and SnippetEnd. It does nothing without FTS5. Snippets are
+// fetched for the returned page in one query; a snippet column in the main
+// query would re-run the MATCH for every matching row before LIMIT applies.
+func (s *Store) addSnippets(entries []*LogEntry, query string) {
+	if !s.hasFTS || len(entries) == 0 {
+		return
+	}
+	node, err := parseSearch(query)
+	if err != nil {
+		node = &searchNode{op: "term", term: query}
+	}
+
+	var terms []string
+	node.positiveTerms(&terms)
+	if len(terms) == 0 {
+		return
+	}
+	for i, term := range terms {
+		terms[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"*`
+	}
+
+	// FTS5 seeks to a rowid range but scans the whole doclist for IN, so
+	// bound the page by range and pick its entries out of the result
+	byID := make(map[int64]*LogEntry, len(entries))
+	minID, maxID := entries[0].ID, entries[0].ID
+	for _, e := range entries {
+		byID[e.ID] = e
+		if e.ID < minID {
+			minID = e.ID
+		}
+		if e.ID > maxID {
+			maxID = e.ID
+		}
+	}
+
+	rows, err := s.db.Query(
+		"SELECT rowid, snippet(logs_fts, 0, ?, ?, '…', 64) FROM logs_fts WHERE logs_fts MATCH ? AND rowid BETWEEN ? AND ?",
+		SnippetStart, SnippetEnd, strings.Join(terms, " OR "), minID, maxID)
+	if err != nil {
+		return // Snippets are optional
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var snippet string
+		if rows.Scan(&id, &snippet) == nil && byID[id] != nil {
+			byID[id].Snippet = snippet
+		}
+	}
+}
+
+// positiveTerms collects the terms that are not under a NOT.
+func (n *searchNode) positiveTerms(terms *[]string) {
+	switch n.op {
+	case "term":
+		*terms = append(*terms, n.term)
+	case "not":
+	default:
+		for _, child := range n.children {
+			child.positiveTerms(terms)
+		}
+	}
+}
+
+func (s *Store) searchSQL(n *searchNode, args *[]interface{}) string {
+	switch n.op {
+	case "term":
+		if s.hasFTS {
+			// Quoted FTS5 phrase, prefix-matched
+			*args = append(*args, `"`+strings.ReplaceAll(n.term, `"`, `""`)+`"*`)
+			return "id IN (SELECT rowid FROM logs_fts WHERE logs_fts MATCH ?)"
+		}
+		escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(n.term)
+		*args = append(*args, "%"+escaped+"%")
+		return `message LIKE ? ESCAPE '\'`
+	case "not":
+		return "NOT (" + s.searchSQL(n.children[0], args) + ")"
+	default:
+		parts := make([]string, len(n.children))
+		for i, child := range n.children {
+			parts[i] = "(" + s.searchSQL(child, args) + ")"
+		}
+		return strings.Join(parts, " "+strings.ToUpper(n.op)+" ")
+	}
+}
+
+// initFTS creates an FTS5 index over log messages, kept in sync by triggers.
+// It returns false when SQLite was built without FTS5 (go-sqlite3 needs the
+// sqlite_fts5 build tag); searches then fall back to LIKE.
+func (s *Store) initFTS() bool {
+	// Without the triggers the index is missing or stale and must be rebuilt
+	var triggers int
+	s.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'logs_fts_%'").Scan(&triggers)
+
+	// CREATE ... IF NOT EXISTS succeeds without FTS5 when the table already
+	// exists, so ask SQLite directly
+	var enabled int
+	s.db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled)
+	if enabled == 0 {
+		// Triggers left by an FTS5-enabled build would make every log insert fail
+		s.db.Exec(`
+		DROP TRIGGER IF EXISTS logs_fts_insert;
+		DROP TRIGGER IF EXISTS logs_fts_delete;
+		DROP TRIGGER IF EXISTS logs_fts_update;
+		`)
+		log.Printf("[store] FTS5 not available, log search uses LIKE")
+		return false
+	}
+
+	_, err := s.db.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS logs_fts USING fts5(message, content='logs', content_rowid='id')")
+	if err != nil {
+		log.Printf("[store] Failed to create FTS5 index (%v), log search uses LIKE", err)
+		return false
+	}
+
+	_, err = s.db.Exec(`
+	CREATE TRIGGER IF NOT EXISTS logs_fts_insert AFTER INSERT ON logs BEGIN
+		INSERT INTO logs_fts(rowid, message) VALUES (new.id, new.message);
+	END;
+	CREATE TRIGGER IF NOT EXISTS logs_fts_delete AFTER DELETE ON logs BEGIN
+		INSERT INTO logs_fts(logs_fts, rowid, message) VALUES ('delete', old.id, old.message);
+	END;
+	CREATE TRIGGER IF NOT EXISTS logs_fts_update AFTER UPDATE ON logs BEGIN
+		INSERT INTO logs_fts(logs_fts, rowid, message) VALUES ('delete', old.id, old.message);
+		INSERT INTO logs_fts(rowid, message) VALUES (new.id, new.message);
+	END;
+	`)
+	if err != nil {
+		log.Printf("[store] Failed to create FTS5 triggers (%v), log search uses LIKE", err)
+		return false
+	}
+
+	if triggers < 3 {
+		if _, err := s.db.Exec("INSERT INTO logs_fts(logs_fts) VALUES ('rebuild')"); err != nil {
+			log.Printf("[store] Failed to build FTS5 index (%v), log search uses LIKE", err)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// seedLogs inserts n log rows in one transaction. Every 5th message
+// mentions a reconnect, and one row each holds "zzyzx" and the phrase
+// "connection lost", so searches range from one match to a fifth of the
+// table.
+func seedLogs(tb testing.TB, s *Store, n int) {
+	tb.Helper()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		tb.Fatalf("begin: %v", err)
+	}
+	stmt, err := tx.Prepare("INSERT INTO logs (timestamp, level, component, message, fields) VALUES (?, ?, ?, ?, '')")
+	if err != nil {
+		tb.Fatalf("prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	start := time.Now().Add(-time.Duration(n) * time.Millisecond)
+	for i := 0; i < n; i++ {
+		var msg string
+		switch {
+		case i == n/2:
+			msg = "peer zzyzx sent an unknown control message"
+		case i == n/3:
+			msg = "connection lost to server, retrying"
+		case i%5 == 0:
+			msg = fmt.Sprintf("reconnect attempt %d after timeout", i)
+		default:
+			msg = fmt.Sprintf("forwarded packet %d from peer 10.8.0.%d", i, i%250+2)
+		}
+		ts := start.Add(time.Duration(i) * time.Millisecond).UnixMilli()
+		if _, err := stmt.Exec(ts, "INFO", "vpn", msg); err != nil {
+			tb.Fatalf("insert: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		tb.Fatalf("commit: %v", err)
+	}
+}
+
+// BenchmarkSearchLogs compares FTS5 with LIKE over 1M log rows (100k with
+// -short). The FTS5 cases need the sqlite_fts5 build tag:
+//
+//	go test -tags sqlite_fts5 -run '^$' -bench SearchLogs ./internal/store
+func BenchmarkSearchLogs(b *testing.B) {
+	rows := 1_000_000
+	if testing.Short() {
+		rows = 100_000
+	}
+
+	s, err := New(b.TempDir(), Options{})
+	if err != nil {
+		b.Fatalf("New: %v", err)
+	}
+	defer s.Close()
+	seedLogs(b, s, rows)
+	hasFTS := s.hasFTS
+
+	searches := []struct {
+		name  string
+		query string
+	}{
+		{"rare", "zzyzx"},
+		{"phrase", `"connection lost"`},
+		{"common", "reconnect"},
+		{"boolean", "reconnect AND timeout NOT peer"},
+	}
+	for _, mode := range []string{"fts", "like"} {
+		for _, search := range searches {
+			b.Run(mode+"/"+search.name, func(b *testing.B) {
+				if mode == "fts" && !hasFTS {
+					b.Skip("FTS5 unavailable (build with -tags sqlite_fts5)")
+				}
+				s.hasFTS = mode == "fts"
+				defer func() { s.hasFTS = hasFTS }()
+
+				for i := 0; i < b.N; i++ {
+					result, err := s.QueryLogs(&LogQuery{Search: search.query, Limit: 100})
+					if err != nil {
+						b.Fatalf("QueryLogs: %v", err)
+					}
+					if len(result.Entries) == 0 {
+						b.Fatalf("no results for %s", search.query)
+					}
+				}
+			})
+		}
+	}
+}
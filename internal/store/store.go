@@ -3,18 +3,25 @@ package store
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 const (
-	// MaxStorageBytes is the maximum storage size (50MB)
+	// DatabaseFile is the name of the SQLite database in the data directory.
+	DatabaseFile = "vpn.db"
+
+	// MaxStorageBytes is the default maximum storage size (50MB)
 	MaxStorageBytes = 50 * 1024 * 1024
 
 	// MetricsRetentionRaw is how long to keep raw metrics (1 hour)
@@ -39,9 +46,28 @@ type Store struct {
 	wg        sync.WaitGroup
 	closeOnce sync.Once // Ensures Close only runs once
 
+	// Retention policy (protected by mu)
+	retention RetentionPolicy
+
+	// Database size that triggers eviction of the oldest logs
+	maxStorageBytes int64
+
+	// hasFTS is set when log messages are indexed with FTS5 (see search.go)
+	hasFTS bool
+
 	// Subscribers for real-time streaming
 	logSubs   map[chan *LogEntry]struct{}
 	logSubsMu sync.RWMutex
+
+	// Log sampling (sampling.go): entries per second per (component, level)
+	// pair, keyed by logLimitKey
+	logRateLimit int
+	logLimits    map[string]*logLimit
+	logLimitsMu  sync.Mutex
+	logDrops     atomic.Uint64
+
+	// ERROR entries written, sampled or not (the logs.errors metric)
+	logErrors atomic.Uint64
 }
 
 // LogEntry represents a single log entry.
@@ -52,6 +78,10 @@ type LogEntry struct {
 	Component string    `json:"component"`
 	Message   string    `json:"message"`
 	Fields    string    `json:"fields,omitempty"` // JSON-encoded extra fields
+
+	// Message excerpt with matched terms between SnippetStart and SnippetEnd
+	// (QueryLogs searches with FTS5 only)
+	Snippet string `json:"snippet,omitempty"`
 }
 
 // MetricPoint represents a single metric data point.
@@ -63,13 +93,27 @@ type MetricPoint struct {
 	Granularity string    `json:"granularity"`    // raw, 1m, 1h
 }
 
+// Options configures a Store. Zero values use the defaults.
+type Options struct {
+	// Retention: non-zero fields override both the defaults and any policy
+	// previously persisted with SetRetentionPolicy.
+	Retention RetentionPolicy
+
+	// MaxStorageBytes is the database size at which the oldest logs are evicted.
+	MaxStorageBytes int64
+
+	// LogRateLimit is how many entries per second WriteLog keeps for each
+	// (component, level) pair (default DefaultLogRateLimit, negative = off).
+	LogRateLimit int
+}
+
 // New creates a new Store instance.
-func New(dataDir string) (*Store, error) {
+func New(dataDir string, opts Options) (*Store, error) {
 	if err := os.MkdirAll(dataDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create data dir: %w", err)
 	}
 
-	dbPath := filepath.Join(dataDir, "vpn.db")
+	dbPath := filepath.Join(dataDir, DatabaseFile)
 	db, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=5000")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
@@ -80,12 +124,36 @@ func New(dataDir string) (*Store, error) {
 		dbPath:   dbPath,
 		stopChan: make(chan struct{}),
 		logSubs:  make(map[chan *LogEntry]struct{}),
+
+		maxStorageBytes: opts.MaxStorageBytes,
+		logRateLimit:    opts.LogRateLimit,
+		logLimits:       make(map[string]*logLimit),
+	}
+	if s.maxStorageBytes <= 0 {
+		s.maxStorageBytes = MaxStorageBytes
+	}
+	if s.logRateLimit == 0 {
+		s.logRateLimit = DefaultLogRateLimit
 	}
 
 	if err := s.initSchema(); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("failed to init schema: %w", err)
 	}
+	if err := s.migrateMetricsKey(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate metrics: %w", err)
+	}
+	s.hasFTS = s.initFTS()
+
+	// Resolve retention: defaults < persisted policy < explicit config
+	s.retention = DefaultRetentionPolicy()
+	if persisted, err := s.loadRetentionPolicy(); err != nil {
+		log.Printf("[store] Failed to load persisted retention policy: %v", err)
+	} else {
+		s.retention = s.retention.merge(persisted)
+	}
+	s.retention = s.retention.merge(opts.Retention)
 
 	// Start background maintenance
 	s.wg.Add(1)
@@ -95,8 +163,8 @@ func New(dataDir string) (*Store, error) {
 	return s, nil
 }
 
-func (s *Store) initSchema() error {
-	schema := `
+// schema creates the tables and indexes that do not exist yet.
+const schema = `
 	-- Logs table
 	CREATE TABLE IF NOT EXISTS logs (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -115,8 +183,8 @@ func (s *Store) initSchema() error {
 		timestamp INTEGER NOT NULL,  -- Unix timestamp in milliseconds
 		name TEXT NOT NULL,
 		value REAL NOT NULL,
-		tags TEXT,
-		PRIMARY KEY (timestamp, name)
+		tags TEXT NOT NULL DEFAULT '', -- JSON object, e.g. {"peer":"mac-mini"}
+		PRIMARY KEY (timestamp, name, tags)
 	);
 	CREATE INDEX IF NOT EXISTS idx_metrics_raw_name ON metrics_raw(name);
 
@@ -129,8 +197,8 @@ func (s *Store) initSchema() error {
 		avg_value REAL NOT NULL,
 		sum_value REAL NOT NULL,
 		count INTEGER NOT NULL,
-		tags TEXT,
-		PRIMARY KEY (timestamp, name)
+		tags TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (timestamp, name, tags)
 	);
 
 	-- 1-hour aggregated metrics
@@ -142,8 +210,8 @@ func (s *Store) initSchema() error {
 		avg_value REAL NOT NULL,
 		sum_value REAL NOT NULL,
 		count INTEGER NOT NULL,
-		tags TEXT,
-		PRIMARY KEY (timestamp, name)
+		tags TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (timestamp, name, tags)
 	);
 
 	-- Storage metadata
@@ -211,13 +279,117 @@ func (s *Store) initSchema() error {
 		last_updated INTEGER NOT NULL          -- Last state update timestamp
 	);
 	CREATE INDEX IF NOT EXISTS idx_client_states_state ON client_states(state);
+
+	-- VPN IP assignments (server mode), so clients keep their IP across restarts.
+	-- Keyed like Daemon.hostnameToIP: the hostname, or "ip:<public IP>".
+	CREATE TABLE IF NOT EXISTS ip_assignments (
+		hostname TEXT PRIMARY KEY,
+		vpn_address TEXT NOT NULL,
+		updated_at INTEGER NOT NULL    -- Unix timestamp in milliseconds
+	);
+
+	-- Alert rules, evaluated by the daemon after each metrics write
+	CREATE TABLE IF NOT EXISTS rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE,
+		condition TEXT NOT NULL,       -- JSON: {"metric":"vpn.active_peers","op":"lt","value":1}
+		action TEXT NOT NULL,          -- log, notify, webhook
+		webhook_url TEXT,
+		created_at INTEGER NOT NULL    -- Unix timestamp in milliseconds
+	);
+
+	-- Geolocation lookups by public IP, so peers are not looked up again on every reconnect
+	CREATE TABLE IF NOT EXISTS geo_cache (
+		ip TEXT PRIMARY KEY,
+		data TEXT NOT NULL,            -- JSON-encoded location
+		fetched_at INTEGER NOT NULL    -- Unix timestamp in milliseconds
+	);
+
+	-- Dashboard preferences (theme, default time ranges), shared by every browser
+	CREATE TABLE IF NOT EXISTS preferences (
+		key TEXT PRIMARY KEY,
+		value TEXT
+	);
+
+	-- Bytes between VPN clients and remote hosts per minute (server mode),
+	-- only the top talkers of each minute (the rest are summed as "other")
+	CREATE TABLE IF NOT EXISTS traffic_by_dest (
+		timestamp INTEGER NOT NULL,    -- Unix timestamp (minute boundary)
+		dest TEXT NOT NULL,            -- Remote IP address
+		bytes_out INTEGER NOT NULL,    -- Sent by clients to dest
+		bytes_in INTEGER NOT NULL,     -- Received by clients from dest
+		PRIMARY KEY (timestamp, dest)
+	);
 	`
+
+func (s *Store) initSchema() error {
 	_, err := s.db.Exec(schema)
 	return err
 }
 
-// WriteLog writes a log entry.
+// migrateMetricsKey rebuilds metric tables created with a (timestamp, name)
+// primary key, under which points of one metric with different tags (one
+// per peer) replaced each other.
+func (s *Store) migrateMetricsKey() error {
+	var keyColumns int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('metrics_raw') WHERE pk > 0").Scan(&keyColumns); err != nil {
+		return err
+	}
+	if keyColumns != 2 {
+		return nil
+	}
+
+	columns := []struct{ table, names string }{
+		{"metrics_raw", "timestamp, name, value"},
+		{"metrics_1m", "timestamp, name, min_value, max_value, avg_value, sum_value, count"},
+		{"metrics_1h", "timestamp, name, min_value, max_value, avg_value, sum_value, count"},
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Move the old tables aside and let the schema create the new ones
+	stmts := []string{"DROP INDEX IF EXISTS idx_metrics_raw_name"}
+	for _, c := range columns {
+		stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s RENAME TO %s_old", c.table, c.table))
+	}
+	stmts = append(stmts, schema)
+	for _, c := range columns {
+		stmts = append(stmts,
+			fmt.Sprintf("INSERT OR REPLACE INTO %s (%s, tags) SELECT %s, COALESCE(tags, '') FROM %s_old", c.table, c.names, c.names, c.table),
+			fmt.Sprintf("DROP TABLE %s_old", c.table))
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("[store] Migrated metric tables to a (timestamp, name, tags) primary key")
+	return nil
+}
+
+// WriteLog writes a log entry, unless its (component, level) pair is over
+// the sampling rate (see sampling.go). ERROR entries are counted for the
+// logs.errors metric either way.
 func (s *Store) WriteLog(level, component, message, fields string) error {
+	if level == "ERROR" {
+		s.logErrors.Add(1)
+	}
+	if !s.allowLog(component, level) {
+		return nil
+	}
+	return s.insertLog(level, component, message, fields)
+}
+
+// insertLog writes a log entry without sampling.
+func (s *Store) insertLog(level, component, message, fields string) error {
 	entry := &LogEntry{
 		Timestamp: time.Now(),
 		Level:     level,
@@ -229,19 +401,34 @@ func (s *Store) WriteLog(level, component, message, fields string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	_, err := s.db.Exec(
+	res, err := s.db.Exec(
 		"INSERT INTO logs (timestamp, level, component, message, fields) VALUES (?, ?, ?, ?, ?)",
 		entry.Timestamp.UnixMilli(), level, component, message, fields,
 	)
 	if err != nil {
 		return err
 	}
+	entry.ID, _ = res.LastInsertId()
 
 	// Notify subscribers
 	s.notifyLogSubscribers(entry)
 	return nil
 }
 
+// WriteLogWithFields writes a log entry with structured fields, stored as a
+// JSON object that LogQuery.Fields can filter on.
+func (s *Store) WriteLogWithFields(level, component, message string, fields map[string]string) error {
+	var fieldsJSON string
+	if len(fields) > 0 {
+		data, err := json.Marshal(fields)
+		if err != nil {
+			return err
+		}
+		fieldsJSON = string(data)
+	}
+	return s.WriteLog(level, component, message, fieldsJSON)
+}
+
 // WriteMetric writes a metric data point.
 func (s *Store) WriteMetric(name string, value float64, tags string) error {
 	s.mu.Lock()
@@ -337,6 +524,7 @@ func (s *Store) maintenanceLoop() {
 		case <-ticker.C:
 			s.enforceRetention()
 			s.enforceStorageLimit()
+			s.reportLogDrops()
 		case <-aggregateTicker.C:
 			s.aggregateMetrics()
 		}
@@ -350,19 +538,20 @@ func (s *Store) enforceRetention() {
 	now := time.Now()
 
 	// Delete old raw metrics
-	cutoff := now.Add(-MetricsRetentionRaw).UnixMilli()
+	cutoff := now.Add(-s.retention.MetricsRaw).UnixMilli()
 	s.db.Exec("DELETE FROM metrics_raw WHERE timestamp < ?", cutoff)
 
 	// Delete old 1m aggregates
-	cutoff = now.Add(-MetricsRetention1m).UnixMilli()
+	cutoff = now.Add(-s.retention.Metrics1m).UnixMilli()
 	s.db.Exec("DELETE FROM metrics_1m WHERE timestamp < ?", cutoff)
 
-	// Delete old 1h aggregates
-	cutoff = now.Add(-MetricsRetention1h).UnixMilli()
+	// Delete old 1h aggregates, and traffic kept as long
+	cutoff = now.Add(-s.retention.Metrics1h).UnixMilli()
 	s.db.Exec("DELETE FROM metrics_1h WHERE timestamp < ?", cutoff)
+	s.db.Exec("DELETE FROM traffic_by_dest WHERE timestamp < ?", cutoff)
 
 	// Delete old logs
-	cutoff = now.Add(-LogsRetention).UnixMilli()
+	cutoff = now.Add(-s.retention.Logs).UnixMilli()
 	s.db.Exec("DELETE FROM logs WHERE timestamp < ?", cutoff)
 }
 
@@ -373,7 +562,7 @@ func (s *Store) enforceStorageLimit() {
 		return
 	}
 
-	if info.Size() < MaxStorageBytes {
+	if info.Size() < s.maxStorageBytes {
 		return
 	}
 
@@ -469,8 +658,18 @@ func (s *Store) WriteLifecycleEvent(event, reason string, uptimeSeconds float64,
 	return err
 }
 
-// GetLifecycleEvents returns recent lifecycle events.
-func (s *Store) GetLifecycleEvents(limit int) ([]LifecycleEvent, error) {
+// LifecycleFilter selects events in GetLifecycleEvents. Zero fields match
+// everything.
+type LifecycleFilter struct {
+	Events  []string  // Event types, e.g. "CRASH", "SIGNAL"
+	Since   time.Time // Inclusive
+	Until   time.Time // Inclusive
+	AfterID int64     // Only events recorded after this one (for following)
+}
+
+// GetLifecycleEvents returns lifecycle events matching the filter, newest
+// first.
+func (s *Store) GetLifecycleEvents(filter LifecycleFilter, limit int) ([]LifecycleEvent, error) {
 	if limit <= 0 {
 		limit = 100
 	}
@@ -478,12 +677,42 @@ func (s *Store) GetLifecycleEvents(limit int) ([]LifecycleEvent, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	rows, err := s.db.Query(`
+	var conditions []string
+	var args []interface{}
+	if len(filter.Events) > 0 {
+		placeholders := make([]string, len(filter.Events))
+		for i, e := range filter.Events {
+			placeholders[i] = "?"
+			args = append(args, e)
+		}
+		conditions = append(conditions, "event IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "timestamp >= ?")
+		args = append(args, filter.Since.UnixMilli())
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "timestamp <= ?")
+		args = append(args, filter.Until.UnixMilli())
+	}
+	if filter.AfterID > 0 {
+		conditions = append(conditions, "id > ?")
+		args = append(args, filter.AfterID)
+	}
+
+	whereClause := ""
+	if len(conditions) > 0 {
+		whereClause = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	query := fmt.Sprintf(`
 		SELECT id, timestamp, event, reason, uptime_seconds, route_all, route_restored, version
 		FROM lifecycle
-		ORDER BY timestamp DESC
-		LIMIT ?
-	`, limit)
+		%s
+		ORDER BY timestamp DESC, id DESC
+		LIMIT ?`, whereClause)
+
+	rows, err := s.db.Query(query, append(args, limit)...)
 	if err != nil {
 		return nil, err
 	}
@@ -508,8 +737,8 @@ func (s *Store) GetLifecycleEvents(limit int) ([]LifecycleEvent, error) {
 	return events, nil
 }
 
-// GetLastCrash returns the most recent crash event.
-func (s *Store) GetLastCrash() (*LifecycleEvent, error) {
+// GetLastCrash returns the most recent crash event at or before until.
+func (s *Store) GetLastCrash(until time.Time) (*LifecycleEvent, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -517,9 +746,10 @@ func (s *Store) GetLastCrash() (*LifecycleEvent, error) {
 		SELECT id, timestamp, event, reason, uptime_seconds, route_all, route_restored, version
 		FROM lifecycle
 		WHERE event IN ('CRASH', 'SIGNAL', 'CONNECTION_LOST')
+		AND timestamp <= ?
 		ORDER BY timestamp DESC
 		LIMIT 1
-	`)
+	`, until.UnixMilli())
 
 	var e LifecycleEvent
 	var tsMs int64
@@ -539,18 +769,18 @@ func (s *Store) GetLastCrash() (*LifecycleEvent, error) {
 	return &e, nil
 }
 
-// GetCrashStats returns crash statistics for a time period.
-func (s *Store) GetCrashStats(since time.Time) (total int, withRouteAll int, routeRestoreFailures int, err error) {
+// GetCrashStats returns crash statistics for the time period since..until.
+func (s *Store) GetCrashStats(since, until time.Time) (total int, withRouteAll int, routeRestoreFailures int, err error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	sinceMs := since.UnixMilli()
+	sinceMs, untilMs := since.UnixMilli(), until.UnixMilli()
 
 	err = s.db.QueryRow(`
 		SELECT COUNT(*) FROM lifecycle
 		WHERE event IN ('CRASH', 'SIGNAL', 'CONNECTION_LOST')
-		AND timestamp >= ?
-	`, sinceMs).Scan(&total)
+		AND timestamp >= ? AND timestamp <= ?
+	`, sinceMs, untilMs).Scan(&total)
 	if err != nil {
 		return
 	}
@@ -559,8 +789,8 @@ func (s *Store) GetCrashStats(since time.Time) (total int, withRouteAll int, rou
 		SELECT COUNT(*) FROM lifecycle
 		WHERE event IN ('CRASH', 'SIGNAL', 'CONNECTION_LOST')
 		AND route_all = 1
-		AND timestamp >= ?
-	`, sinceMs).Scan(&withRouteAll)
+		AND timestamp >= ? AND timestamp <= ?
+	`, sinceMs, untilMs).Scan(&withRouteAll)
 	if err != nil {
 		return
 	}
@@ -570,8 +800,8 @@ func (s *Store) GetCrashStats(since time.Time) (total int, withRouteAll int, rou
 		WHERE event IN ('CRASH', 'SIGNAL', 'CONNECTION_LOST')
 		AND route_all = 1
 		AND route_restored = 0
-		AND timestamp >= ?
-	`, sinceMs).Scan(&routeRestoreFailures)
+		AND timestamp >= ? AND timestamp <= ?
+	`, sinceMs, untilMs).Scan(&routeRestoreFailures)
 	return
 }
 
@@ -645,8 +875,17 @@ func (s *Store) WriteHandshake(nodeName, vpnAddress, publicIP, hostname, osName,
 	return err
 }
 
-// GetHandshakeHistory returns handshake history, optionally filtered by node name.
-func (s *Store) GetHandshakeHistory(nodeName string, limit int) ([]HandshakeRecord, int, error) {
+// HandshakeFilter selects handshakes in GetHandshakeHistory. Zero fields
+// match everything.
+type HandshakeFilter struct {
+	NodeName string
+	OS       string // e.g. "linux", "darwin"
+	Failed   bool   // Only installs whose ping or SSH test failed
+}
+
+// GetHandshakeHistory returns handshake history, newest first, and the
+// number of handshakes matching the filter.
+func (s *Store) GetHandshakeHistory(filter HandshakeFilter, limit int) ([]HandshakeRecord, int, error) {
 	if limit <= 0 {
 		limit = 100
 	}
@@ -654,27 +893,33 @@ func (s *Store) GetHandshakeHistory(nodeName string, limit int) ([]HandshakeReco
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	var query string
+	var conditions []string
 	var args []interface{}
+	if filter.NodeName != "" {
+		conditions = append(conditions, "node_name = ?")
+		args = append(args, filter.NodeName)
+	}
+	if filter.OS != "" {
+		conditions = append(conditions, "os = ?")
+		args = append(args, filter.OS)
+	}
+	if filter.Failed {
+		conditions = append(conditions, "(ping_test_ok = 0 OR ssh_test_ok = 0)")
+	}
 
-	if nodeName != "" {
-		query = `
-			SELECT id, timestamp, node_name, vpn_address, public_ip, hostname, os, arch, version, go_version, install_ts, ssh_test_ok, ssh_test_error, ping_test_ok, ping_test_ms
-			FROM handshakes
-			WHERE node_name = ?
-			ORDER BY timestamp DESC
-			LIMIT ?`
-		args = []interface{}{nodeName, limit}
-	} else {
-		query = `
-			SELECT id, timestamp, node_name, vpn_address, public_ip, hostname, os, arch, version, go_version, install_ts, ssh_test_ok, ssh_test_error, ping_test_ok, ping_test_ms
-			FROM handshakes
-			ORDER BY timestamp DESC
-			LIMIT ?`
-		args = []interface{}{limit}
+	whereClause := ""
+	if len(conditions) > 0 {
+		whereClause = "WHERE " + strings.Join(conditions, " AND ")
 	}
 
-	rows, err := s.db.Query(query, args...)
+	query := fmt.Sprintf(`
+		SELECT id, timestamp, node_name, vpn_address, public_ip, hostname, os, arch, version, go_version, install_ts, ssh_test_ok, ssh_test_error, ping_test_ok, ping_test_ms
+		FROM handshakes
+		%s
+		ORDER BY timestamp DESC
+		LIMIT ?`, whereClause)
+
+	rows, err := s.db.Query(query, append(args, limit)...)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -709,13 +954,7 @@ func (s *Store) GetHandshakeHistory(nodeName string, limit int) ([]HandshakeReco
 
 	// Get total count
 	var total int
-	countQuery := "SELECT COUNT(*) FROM handshakes"
-	if nodeName != "" {
-		countQuery += " WHERE node_name = ?"
-		s.db.QueryRow(countQuery, nodeName).Scan(&total)
-	} else {
-		s.db.QueryRow(countQuery).Scan(&total)
-	}
+	s.db.QueryRow("SELECT COUNT(*) FROM handshakes "+whereClause, args...).Scan(&total)
 
 	return records, total, nil
 }
@@ -904,6 +1143,16 @@ func (s *Store) GetClientState(vpnAddress string) (*ClientState, error) {
 	return &c, nil
 }
 
+// DeleteClientState forgets a client's connection state, e.g. when the node
+// is removed from the network.
+func (s *Store) DeleteClientState(vpnAddress string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.db.Exec("DELETE FROM client_states WHERE vpn_address = ?", vpnAddress)
+	return err
+}
+
 // ClearAllClientStates resets all client states (used during server shutdown/restart).
 func (s *Store) ClearAllClientStates() error {
 	s.mu.Lock()
@@ -919,3 +1168,212 @@ func (s *Store) ClearAllClientStates() error {
 	`, now, now, ClientStateDisconnectedIntent)
 	return err
 }
+
+// =============================================================================
+// IP Assignment Persistence
+// =============================================================================
+
+// GetAssignedIP returns the VPN IP previously assigned to a hostname, or ""
+// if none was recorded.
+func (s *Store) GetAssignedIP(hostname string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var ip string
+	err := s.db.QueryRow("SELECT vpn_address FROM ip_assignments WHERE hostname = ?", hostname).Scan(&ip)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return ip, err
+}
+
+// SaveAssignedIP records the VPN IP assigned to a hostname.
+func (s *Store) SaveAssignedIP(hostname, ip string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.db.Exec(
+		"INSERT OR REPLACE INTO ip_assignments (hostname, vpn_address, updated_at) VALUES (?, ?, ?)",
+		hostname, ip, time.Now().UnixMilli(),
+	)
+	return err
+}
+
+// DeleteAssignedIP removes every assignment of the given VPN IP, freeing it
+// for other nodes. It reports whether there was any.
+func (s *Store) DeleteAssignedIP(ip string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res, err := s.db.Exec("DELETE FROM ip_assignments WHERE vpn_address = ?", ip)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// GetGeoCache returns the cached geolocation JSON for an IP, or "" if there
+// is none or it is older than maxAge.
+func (s *Store) GetGeoCache(ip string, maxAge time.Duration) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var data string
+	err := s.db.QueryRow(
+		"SELECT data FROM geo_cache WHERE ip = ? AND fetched_at >= ?",
+		ip, time.Now().Add(-maxAge).UnixMilli(),
+	).Scan(&data)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return data, err
+}
+
+// SaveGeoCache caches the geolocation JSON for an IP.
+func (s *Store) SaveGeoCache(ip, data string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.db.Exec(
+		"INSERT OR REPLACE INTO geo_cache (ip, data, fetched_at) VALUES (?, ?, ?)",
+		ip, data, time.Now().UnixMilli(),
+	)
+	return err
+}
+
+// GetPreferences returns all stored dashboard preferences.
+func (s *Store) GetPreferences() (map[string]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows, err := s.db.Query("SELECT key, value FROM preferences")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prefs := make(map[string]string)
+	for rows.Next() {
+		var key string
+		var value sql.NullString
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		prefs[key] = value.String
+	}
+	return prefs, rows.Err()
+}
+
+// SetPreferences stores the given preferences, leaving other keys unchanged.
+// An empty value removes the key.
+func (s *Store) SetPreferences(prefs map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for key, value := range prefs {
+		if value == "" {
+			_, err = tx.Exec("DELETE FROM preferences WHERE key = ?", key)
+		} else {
+			_, err = tx.Exec("INSERT OR REPLACE INTO preferences (key, value) VALUES (?, ?)", key, value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// previousDeployMetaKey is the meta table key holding the git commit the
+// node ran before its last deploy changed it.
+const previousDeployMetaKey = "deploy_previous_sha"
+
+// GetPreviousDeploy returns the commit to roll back to, or "" if none.
+func (s *Store) GetPreviousDeploy() (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var sha string
+	err := s.db.QueryRow("SELECT value FROM meta WHERE key = ?", previousDeployMetaKey).Scan(&sha)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return sha, err
+}
+
+// SavePreviousDeploy records the commit to roll back to ("" clears it).
+func (s *Store) SavePreviousDeploy(sha string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sha == "" {
+		_, err := s.db.Exec("DELETE FROM meta WHERE key = ?", previousDeployMetaKey)
+		return err
+	}
+	_, err := s.db.Exec("INSERT OR REPLACE INTO meta (key, value) VALUES (?, ?)", previousDeployMetaKey, sha)
+	return err
+}
+
+// nextIPMetaKey is the meta table key holding the IP allocator position.
+const nextIPMetaKey = "ip_next_octet"
+
+// GetNextIPOctet returns the persisted last octet of the next VPN IP to
+// allocate, or 0 if none was recorded.
+func (s *Store) GetNextIPOctet() (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var value string
+	err := s.db.QueryRow("SELECT value FROM meta WHERE key = ?", nextIPMetaKey).Scan(&value)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(value)
+}
+
+// SaveNextIPOctet persists the last octet of the next VPN IP to allocate.
+func (s *Store) SaveNextIPOctet(octet int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.db.Exec("INSERT OR REPLACE INTO meta (key, value) VALUES (?, ?)", nextIPMetaKey, strconv.Itoa(octet))
+	return err
+}
+
+// GetHighestAssignedOctet returns the largest last octet among recorded IP
+// assignments, or 0 if there are none.
+func (s *Store) GetHighestAssignedOctet() (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows, err := s.db.Query("SELECT DISTINCT vpn_address FROM ip_assignments")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	highest := 0
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			return 0, err
+		}
+		idx := strings.LastIndex(ip, ".")
+		if idx < 0 {
+			continue
+		}
+		if octet, err := strconv.Atoi(ip[idx+1:]); err == nil && octet > highest {
+			highest = octet
+		}
+	}
+	return highest, rows.Err()
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FormatTags encodes metric tags as the JSON object stored with each point
+// ("" for none). Keys are sorted, so the same tags always give the same
+// string and points land in the same series.
+func FormatTags(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(tags) // Maps marshal with sorted keys
+	return string(data)
+}
+
+// ParseTags decodes tags written by FormatTags (nil for none).
+func ParseTags(tags string) map[string]string {
+	var m map[string]string
+	json.Unmarshal([]byte(tags), &m)
+	return m
+}
+
+// SeriesLabel names a series in flat output such as CSV: the metric name
+// followed by its tags, e.g. "peer.rtt_ms{peer=mac-mini}".
+func SeriesLabel(name, tags string) string {
+	m := ParseTags(tags)
+	if len(m) == 0 {
+		return name
+	}
+	pairs := make([]string, 0, len(m))
+	for k, v := range m {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// tagConditions returns the SQL conditions (ANDed, with a leading " AND ")
+// that keep points carrying every tag in tags, and their arguments.
+func tagConditions(tags map[string]string) (string, []interface{}, error) {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sql strings.Builder
+	var args []interface{}
+	for _, k := range keys {
+		f := FieldFilter{Key: k, Op: "=", Value: tags[k]}
+		if err := f.validate(); err != nil {
+			return "", nil, fmt.Errorf("invalid tag %q", k)
+		}
+		condition, tagArgs := f.condition("tags")
+		sql.WriteString(" AND " + condition)
+		args = append(args, tagArgs...)
+	}
+	return sql.String(), args, nil
+}
@@ -251,15 +251,31 @@ func FormatDuration(d time.Duration) string {
 	return fmt.Sprintf("%dd", days)
 }
 
-// SuggestGranularity suggests the best metric granularity for a time range.
-func SuggestGranularity(tr *TimeRange) string {
-	duration := tr.End.Sub(tr.Start)
+var durationRe = regexp.MustCompile(`^(\d+)([smhdw])$`)
 
-	if duration <= time.Hour {
-		return "raw"
-	}
-	if duration <= 24*time.Hour {
-		return "1m"
+// ParseDuration parses a duration such as "30m", "2h", "3d" or "1w".
+// Anything else is handed to time.ParseDuration.
+func ParseDuration(spec string) (time.Duration, error) {
+	spec = strings.TrimSpace(spec)
+	matches := durationRe.FindStringSubmatch(spec)
+	if matches == nil {
+		return time.ParseDuration(spec)
 	}
-	return "1h"
+
+	amount, _ := strconv.Atoi(matches[1])
+	unit := map[string]time.Duration{
+		"s": time.Second,
+		"m": time.Minute,
+		"h": time.Hour,
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+	}[matches[2]]
+
+	return time.Duration(amount) * unit, nil
+}
+
+// SuggestGranularity suggests the best metric granularity for a time range
+// under the default retention policy.
+func SuggestGranularity(tr *TimeRange) string {
+	return DefaultRetentionPolicy().Granularity(tr)
 }
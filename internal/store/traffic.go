@@ -0,0 +1,90 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// TrafficOther is the destination the bytes of hosts outside the top talkers
+// are recorded under.
+const TrafficOther = "other"
+
+// TrafficCount is the traffic between VPN clients and one remote host.
+type TrafficCount struct {
+	Dest     string `json:"dest"`      // Remote IP address, or TrafficOther
+	BytesOut uint64 `json:"bytes_out"` // Sent by clients to Dest
+	BytesIn  uint64 `json:"bytes_in"`  // Received by clients from Dest
+}
+
+// Bytes returns the traffic in both directions.
+func (c TrafficCount) Bytes() uint64 {
+	return c.BytesOut + c.BytesIn
+}
+
+// WriteTraffic adds counts to the minute bucket containing at.
+func (s *Store) WriteTraffic(at time.Time, counts []TrafficCount) error {
+	if len(counts) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO traffic_by_dest (timestamp, dest, bytes_out, bytes_in)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(timestamp, dest) DO UPDATE SET
+			bytes_out = bytes_out + excluded.bytes_out,
+			bytes_in = bytes_in + excluded.bytes_in
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	bucket := at.Truncate(time.Minute).UnixMilli()
+	for _, c := range counts {
+		if _, err := stmt.Exec(bucket, c.Dest, int64(c.BytesOut), int64(c.BytesIn)); err != nil {
+			return fmt.Errorf("failed to write traffic for %s: %w", c.Dest, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// QueryTraffic returns the limit destinations with the most bytes in both
+// directions over tr, largest first.
+func (s *Store) QueryTraffic(tr *TimeRange, limit int) ([]TrafficCount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows, err := s.db.Query(`
+		SELECT dest, SUM(bytes_out), SUM(bytes_in)
+		FROM traffic_by_dest
+		WHERE timestamp >= ? AND timestamp <= ?
+		GROUP BY dest
+		ORDER BY SUM(bytes_out) + SUM(bytes_in) DESC
+		LIMIT ?
+	`, tr.Start.Truncate(time.Minute).UnixMilli(), tr.End.UnixMilli(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []TrafficCount
+	for rows.Next() {
+		var c TrafficCount
+		var out, in int64
+		if err := rows.Scan(&c.Dest, &out, &in); err != nil {
+			return nil, err
+		}
+		c.BytesOut, c.BytesIn = uint64(out), uint64(in)
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
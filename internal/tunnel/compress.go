@@ -0,0 +1,57 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/pierrec/lz4/v4"
+)
+
+// compressedMarker prefixes compressed packets. It can't start an IP packet
+// (version 12) or a control message ("CTRL:"), so uncompressed packets need
+// no framing and nodes without compression are unaffected.
+//
+// Compressed packet: [1 byte: marker][2 bytes: original length][LZ4 block]
+const compressedMarker = 0xC0
+
+// compressPacket LZ4-compresses a packet, returning it unchanged if
+// compression wouldn't make it smaller. A packet that itself starts with
+// compressedMarker is always framed, so the peer can't mistake it for a
+// compressed one.
+func compressPacket(data []byte) []byte {
+	if len(data) == 0 || len(data) > math.MaxUint16 {
+		return data
+	}
+
+	// With a CompressBlockBound-sized buffer CompressBlock always writes a
+	// block, falling back to literals for incompressible data
+	out := make([]byte, 3+lz4.CompressBlockBound(len(data)))
+	n, err := lz4.CompressBlock(data, out[3:], nil)
+	if err != nil || (n+3 >= len(data) && data[0] != compressedMarker) {
+		return data
+	}
+
+	out[0] = compressedMarker
+	binary.BigEndian.PutUint16(out[1:3], uint16(len(data)))
+	return out[:3+n]
+}
+
+// isCompressedPacket checks if a packet was produced by compressPacket.
+func isCompressedPacket(data []byte) bool {
+	return len(data) > 3 && data[0] == compressedMarker
+}
+
+// decompressPacket reverses compressPacket.
+func decompressPacket(data []byte) ([]byte, error) {
+	size := int(binary.BigEndian.Uint16(data[1:3]))
+	out := make([]byte, size)
+	n, err := lz4.UncompressBlock(data[3:], out)
+	if err != nil {
+		return nil, err
+	}
+	if n != size {
+		return nil, fmt.Errorf("compressed packet decoded to %d bytes, header says %d", n, size)
+	}
+	return out, nil
+}
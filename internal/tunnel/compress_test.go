@@ -0,0 +1,116 @@
+package tunnel
+
+import (
+	"bytes"
+	"testing"
+)
+
+// receive mirrors the read side of Conn: decompress only what is marked.
+func receive(t *testing.T, packet []byte) []byte {
+	t.Helper()
+	if !isCompressedPacket(packet) {
+		return packet
+	}
+	out, err := decompressPacket(packet)
+	if err != nil {
+		t.Fatalf("decompressPacket: %v", err)
+	}
+	return out
+}
+
+func TestCompressPacketRoundTrip(t *testing.T) {
+	tests := map[string][]byte{
+		"repetitive":     bytes.Repeat([]byte("CTRL:PING "), 100),
+		"ipv4 header":    append([]byte{0x45, 0x00, 0x05, 0xdc}, bytes.Repeat([]byte{0}, 200)...),
+		"short":          []byte("abc"),
+		"incompressible": []byte("0123456789abcdefghijklmnopqrstuvwxyz"),
+		"mtu":            bytes.Repeat([]byte{1, 2, 3, 4, 5, 6, 7}, MTU/7),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := receive(t, compressPacket(data)); !bytes.Equal(got, data) {
+				t.Errorf("round trip changed the packet:\n got %x\nwant %x", got, data)
+			}
+		})
+	}
+}
+
+// A packet that starts with the marker byte but doesn't compress must not
+// reach the peer as-is, or it would be decoded as a compressed packet.
+func TestCompressPacketMarkerByte(t *testing.T) {
+	for _, data := range [][]byte{
+		{compressedMarker},
+		{compressedMarker, 0x00, 0x10, 0x41},
+		append([]byte{compressedMarker}, []byte("0123456789abcdefghijklmnopqrstuvwxyz")...),
+		append([]byte{compressedMarker, 0xff, 0xff}, bytes.Repeat([]byte{7}, 100)...),
+	} {
+		out := compressPacket(data)
+		if !isCompressedPacket(out) {
+			t.Errorf("%x: sent unframed", data)
+			continue
+		}
+		if got := receive(t, out); !bytes.Equal(got, data) {
+			t.Errorf("%x: round trip gave %x", data, got)
+		}
+	}
+}
+
+func TestDecompressPacketRejectsCorrupt(t *testing.T) {
+	for _, packet := range [][]byte{
+		{compressedMarker, 0xff, 0xff, 0x00},           // Declares more than the block holds
+		{compressedMarker, 0x00, 0x04, 0x40, 'a', 'b'}, // Literals past the end
+		{compressedMarker, 0x00, 0x08, 0x10, 'a', 0x05, 0x00},
+	} {
+		if _, err := decompressPacket(packet); err == nil {
+			t.Errorf("%x: expected an error", packet)
+		}
+	}
+}
+
+func TestCompressPacketLarge(t *testing.T) {
+	data := append([]byte{compressedMarker}, bytes.Repeat([]byte{1, 2, 3}, MTU)...)
+	out := compressPacket(data)
+	if !isCompressedPacket(out) {
+		t.Fatal("packet over MTU*2 starting with the marker sent unframed")
+	}
+	if got := receive(t, out); !bytes.Equal(got, data) {
+		t.Error("round trip changed the packet")
+	}
+}
+
+// Without negotiated compression a packet starting with the marker byte is
+// data, not a compressed packet.
+func TestConnDecompressOnlyWhenNegotiated(t *testing.T) {
+	packet := compressPacket(bytes.Repeat([]byte("CTRL:PING "), 10))
+
+	got, err := (&Conn{}).decompress(packet)
+	if err != nil || !bytes.Equal(got, packet) {
+		t.Errorf("without compression: got %x, %v; want the packet unchanged", got, err)
+	}
+
+	got, err = (&Conn{compressionCapable: true}).decompress(packet)
+	if err != nil || !bytes.Equal(got, bytes.Repeat([]byte("CTRL:PING "), 10)) {
+		t.Errorf("with compression: got %x, %v", got, err)
+	}
+}
+
+func FuzzCompressPacket(f *testing.F) {
+	f.Add([]byte("CTRL:PING 1700000000000"))
+	f.Add(bytes.Repeat([]byte("abcd"), 64))
+	f.Add([]byte{compressedMarker, 0x00, 0x04, 0x40, 'a', 'b', 'c', 'd'})
+	f.Add([]byte{0x45, 0x00, 0x00, 0x54, 0x00, 0x00, 0x40, 0x00, 0x40, 0x01})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Received bytes that look compressed must fail cleanly, not panic
+		if isCompressedPacket(data) {
+			decompressPacket(data)
+		}
+
+		if len(data) == 0 {
+			return
+		}
+		if got := receive(t, compressPacket(data)); !bytes.Equal(got, data) {
+			t.Fatalf("packet round trip changed the data:\n got %x\nwant %x", got, data)
+		}
+	})
+}
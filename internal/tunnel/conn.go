@@ -9,6 +9,7 @@ import (
 	"log"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,23 +20,53 @@ type Conn struct {
 	writer     *bufio.Writer
 	writerMu   sync.Mutex
 	cipher     *Cipher
+	key        []byte // For SetCipher
 	encryption bool
 	remoteAddr string
 
+	// Compression: capable is set from config; active once both ends agree
+	compressionCapable bool
+	compressionActive  atomic.Bool
+
+	// Sequence numbers: each direction starts once the sender announces it
+	seqSend  bool // guarded by writerMu
+	nextSend uint32
+	seqRecv  atomic.Bool
+
+	// UDP transport (udp.go): capable is set from config; udp once a session is set up
+	udpCapable bool
+	udp        atomic.Pointer[udpSession]
+	tcpWant    chan struct{}
+	tcpIn      chan frameResult
+	tcpPending bool // A TCP frame was requested (reading goroutine only)
+	closed     atomic.Bool
+
 	// Statistics
 	mu          sync.RWMutex
 	bytesSent   uint64
 	bytesRecv   uint64
 	packetsSent uint64
 	packetsRecv uint64
+
+	// Compression statistics (outgoing packets)
+	rawBytesOut        uint64
+	compressedBytesOut uint64
+
+	// Sequence statistics (incoming packets since the last TakeLossStats)
+	nextRecv    uint32
+	seqStarted  bool
+	seqReceived uint64
+	seqLost     uint64
 }
 
 // DialConfig holds configuration for dialing a VPN connection.
 type DialConfig struct {
-	Address    string
-	UseTLS     bool
-	Key        []byte // 32 bytes for AES-256
-	Encryption bool
+	Address     string
+	UseTLS      bool
+	Key         []byte // 32 bytes for AES-256
+	Encryption  bool
+	Compression bool   // Offer LZ4 compression (used once the server agrees)
+	Transport   string // TransportTCP (default) or TransportUDP
 }
 
 // Dial connects to a VPN node.
@@ -43,6 +74,10 @@ func Dial(cfg DialConfig) (*Conn, error) {
 	var netConn net.Conn
 	var err error
 
+	if !validTransport(cfg.Transport) {
+		return nil, fmt.Errorf("unknown transport %q (use tcp or udp)", cfg.Transport)
+	}
+
 	if cfg.UseTLS {
 		tlsConfig := &tls.Config{
 			InsecureSkipVerify: true, // For self-signed certs
@@ -70,7 +105,11 @@ func Dial(cfg DialConfig) (*Conn, error) {
 		reader:     bufio.NewReaderSize(netConn, 256*1024), // 256KB buffer
 		writer:     bufio.NewWriterSize(netConn, 256*1024),
 		remoteAddr: cfg.Address,
+		key:        cfg.Key,
 		encryption: cfg.Encryption,
+
+		compressionCapable: cfg.Compression,
+		udpCapable:         cfg.Transport == TransportUDP,
 	}
 
 	if cfg.Encryption && len(cfg.Key) == 32 {
@@ -118,11 +157,41 @@ func tuneTCPConn(conn net.Conn) error {
 }
 
 // WritePacket sends an encrypted packet.
-// Wire format: [4-byte length][encrypted payload]
+// Wire format: [4-byte length][encrypted payload], preceded by a 4-byte
+// sequence number once StartSequencing has been called. With a UDP session,
+// IP packets are sent as datagrams instead (see udp.go).
 func (c *Conn) WritePacket(data []byte) error {
+	return c.writePacket(data, false)
+}
+
+// StartSequencing sends the announce packet and prepends a sequence number
+// to every packet written after it. Both happen under the writer lock, so
+// the peer can switch its reader when it sees the announce packet.
+func (c *Conn) StartSequencing(announce []byte) error {
+	return c.writePacket(announce, true)
+}
+
+func (c *Conn) writePacket(data []byte, startSeq bool) error {
 	var toSend []byte
 	var err error
 
+	s := c.udp.Load()
+	datagram := s != nil && !startSeq && s.ready() && IsValidIPPacket(data)
+
+	if c.compressionActive.Load() {
+		compressed := compressPacket(data)
+		c.mu.Lock()
+		c.rawBytesOut += uint64(len(data))
+		c.compressedBytesOut += uint64(len(compressed))
+		c.mu.Unlock()
+		data = compressed
+	}
+
+	// A failed datagram is sent over TCP instead
+	if datagram && c.writeDatagram(s, data) == nil {
+		return nil
+	}
+
 	if c.encryption && c.cipher != nil {
 		toSend, err = c.cipher.Encrypt(data)
 		if err != nil {
@@ -139,6 +208,17 @@ func (c *Conn) WritePacket(data []byte) error {
 	c.writerMu.Lock()
 	defer c.writerMu.Unlock()
 
+	frameLen := len(toSend) + 4
+	if c.seqSend {
+		seqBuf := make([]byte, 4)
+		binary.BigEndian.PutUint32(seqBuf, c.nextSend)
+		c.nextSend++
+		if _, err := c.writer.Write(seqBuf); err != nil {
+			return fmt.Errorf("failed to write sequence: %w", err)
+		}
+		frameLen += 4
+	}
+
 	if _, err := c.writer.Write(lengthBuf); err != nil {
 		return fmt.Errorf("failed to write length: %w", err)
 	}
@@ -152,8 +232,12 @@ func (c *Conn) WritePacket(data []byte) error {
 		return fmt.Errorf("flush failed: %w", err)
 	}
 
+	if startSeq {
+		c.seqSend = true
+	}
+
 	c.mu.Lock()
-	c.bytesSent += uint64(len(toSend) + 4)
+	c.bytesSent += uint64(frameLen)
 	c.packetsSent++
 	c.mu.Unlock()
 
@@ -170,6 +254,25 @@ func (c *Conn) Flush() error {
 // ReadPacket reads and decrypts a packet.
 // Returns the decrypted payload.
 func (c *Conn) ReadPacket() ([]byte, error) {
+	if s := c.udp.Load(); s != nil {
+		return c.readUDP(s)
+	}
+	return c.readFrame()
+}
+
+// readFrame reads a packet from the TCP stream.
+func (c *Conn) readFrame() ([]byte, error) {
+	// Read sequence number (once the peer has started sequencing)
+	var seq uint32
+	sequenced := c.seqRecv.Load()
+	if sequenced {
+		seqBuf := make([]byte, 4)
+		if _, err := io.ReadFull(c.reader, seqBuf); err != nil {
+			return nil, fmt.Errorf("failed to read sequence: %w", err)
+		}
+		seq = binary.BigEndian.Uint32(seqBuf)
+	}
+
 	// Read length prefix
 	lengthBuf := make([]byte, 4)
 	if _, err := io.ReadFull(c.reader, lengthBuf); err != nil {
@@ -192,6 +295,10 @@ func (c *Conn) ReadPacket() ([]byte, error) {
 	c.mu.Lock()
 	c.bytesRecv += uint64(length + 4)
 	c.packetsRecv++
+	if sequenced {
+		c.bytesRecv += 4
+		c.recordSequence(seq)
+	}
 	c.mu.Unlock()
 
 	// Decrypt if needed
@@ -200,10 +307,59 @@ func (c *Conn) ReadPacket() ([]byte, error) {
 		if err != nil {
 			return nil, fmt.Errorf("decryption failed: %w", err)
 		}
-		return decrypted, nil
+		packet = decrypted
 	}
 
-	return packet, nil
+	return c.decompress(packet)
+}
+
+// decompress reverses compressPacket on a received packet. Only a
+// compression capable end can have negotiated compression, so on any other
+// connection packets are returned as sent, whatever their first byte.
+func (c *Conn) decompress(packet []byte) ([]byte, error) {
+	if !c.compressionCapable || !isCompressedPacket(packet) {
+		return packet, nil
+	}
+	decompressed, err := decompressPacket(packet)
+	if err != nil {
+		return nil, fmt.Errorf("decompression failed: %w", err)
+	}
+	return decompressed, nil
+}
+
+// recordSequence counts skipped sequence numbers as lost packets.
+// Caller must hold c.mu.
+func (c *Conn) recordSequence(seq uint32) {
+	// Unsigned difference handles wrap-around; a "negative" gap is a
+	// duplicate or reordered packet, not a loss
+	if gap := seq - c.nextRecv; c.seqStarted && gap > 0 && gap < 1<<31 {
+		c.seqLost += uint64(gap)
+	}
+	c.nextRecv = seq + 1
+	c.seqStarted = true
+	c.seqReceived++
+}
+
+// ExpectSequence makes ReadPacket read a sequence number before every
+// packet. Call it from the reading goroutine right after the peer's
+// announce packet (see StartSequencing).
+func (c *Conn) ExpectSequence() {
+	c.seqRecv.Store(true)
+}
+
+// Sequenced reports whether incoming packets carry sequence numbers.
+func (c *Conn) Sequenced() bool {
+	return c.seqRecv.Load()
+}
+
+// TakeLossStats returns the sequenced packets received and the sequence
+// numbers skipped since the previous call, and resets both counters.
+func (c *Conn) TakeLossStats() (received, lost uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	received, lost = c.seqReceived, c.seqLost
+	c.seqReceived, c.seqLost = 0, 0
+	return received, lost
 }
 
 // Stats returns connection statistics.
@@ -213,6 +369,59 @@ func (c *Conn) Stats() (bytesSent, bytesRecv, packetsSent, packetsRecv uint64) {
 	return c.bytesSent, c.bytesRecv, c.packetsSent, c.packetsRecv
 }
 
+// SetCipher switches packet encryption to the cipher the handshake agreed
+// on ("" keeps AES-256-GCM). It must be called before the first packet is
+// written or read; without encryption it does nothing.
+func (c *Conn) SetCipher(name string) error {
+	if name == "" || c.cipher == nil || c.cipher.Name() == name {
+		return nil
+	}
+	cipher, err := NewCipherFor(name, c.key)
+	if err != nil {
+		return err
+	}
+	c.cipher = cipher
+	return nil
+}
+
+// CipherName returns the packet cipher, or "none" without encryption.
+func (c *Conn) CipherName() string {
+	if !c.encryption || c.cipher == nil {
+		return "none"
+	}
+	return c.cipher.Name()
+}
+
+// CompressionCapable reports whether this end offers compression.
+func (c *Conn) CompressionCapable() bool {
+	return c.compressionCapable
+}
+
+// SetCompression turns compression of outgoing packets on or off. It has no
+// effect unless this end is compression capable. Incoming compressed packets
+// are accepted whenever this end is compression capable.
+func (c *Conn) SetCompression(enabled bool) {
+	c.compressionActive.Store(enabled && c.compressionCapable)
+}
+
+// CompressionActive reports whether outgoing packets are being compressed.
+func (c *Conn) CompressionActive() bool {
+	return c.compressionActive.Load()
+}
+
+// CompressionStats returns the bytes of outgoing packets before and after compression.
+func (c *Conn) CompressionStats() (rawBytes, compressedBytes uint64) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.rawBytesOut, c.compressedBytesOut
+}
+
+// SetReadDeadline sets the deadline for future ReadPacket calls.
+// A zero value disables the deadline.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.NetConn.SetReadDeadline(t)
+}
+
 // RemoteAddr returns the remote address.
 func (c *Conn) RemoteAddr() string {
 	return c.remoteAddr
@@ -220,6 +429,11 @@ func (c *Conn) RemoteAddr() string {
 
 // Close closes the connection.
 func (c *Conn) Close() error {
+	c.closed.Store(true)
+	if s := c.udp.Load(); s != nil {
+		s.close()
+	}
+
 	c.writerMu.Lock()
 	c.writer.Flush()
 	c.writerMu.Unlock()
@@ -228,20 +442,24 @@ func (c *Conn) Close() error {
 
 // Listener accepts incoming VPN connections.
 type Listener struct {
-	listener   net.Listener
-	tlsConfig  *tls.Config
-	key        []byte
-	encryption bool
+	listener    net.Listener
+	udp         *udpMux // nil unless Transport is TransportUDP
+	tlsConfig   *tls.Config
+	key         []byte
+	encryption  bool
+	compression bool
 }
 
 // ListenConfig holds configuration for listening.
 type ListenConfig struct {
-	Address    string
-	UseTLS     bool
-	CertFile   string
-	KeyFile    string
-	Key        []byte // Encryption key
-	Encryption bool
+	Address     string
+	UseTLS      bool
+	CertFile    string
+	KeyFile     string
+	Key         []byte // Encryption key
+	Encryption  bool
+	Compression bool   // Accept LZ4 compression from clients that offer it
+	Transport   string // TransportTCP (default) or TransportUDP (TCP clients are still accepted)
 }
 
 // Listen creates a VPN listener.
@@ -250,6 +468,10 @@ func Listen(cfg ListenConfig) (*Listener, error) {
 	var tlsConfig *tls.Config
 	var err error
 
+	if !validTransport(cfg.Transport) {
+		return nil, fmt.Errorf("unknown transport %q (use tcp or udp)", cfg.Transport)
+	}
+
 	if cfg.UseTLS {
 		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
 		if err != nil {
@@ -272,11 +494,23 @@ func Listen(cfg ListenConfig) (*Listener, error) {
 		log.Printf("[conn] Listening on %s", cfg.Address)
 	}
 
+	var udp *udpMux
+	if cfg.Transport == TransportUDP {
+		udp, err = listenUDPMux(listener.Addr())
+		if err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("UDP listen failed: %w", err)
+		}
+		log.Printf("[conn] Listening on %s/udp for IP packets", udp.sock.LocalAddr())
+	}
+
 	return &Listener{
-		listener:   listener,
-		tlsConfig:  tlsConfig,
-		key:        cfg.Key,
-		encryption: cfg.Encryption,
+		listener:    listener,
+		udp:         udp,
+		tlsConfig:   tlsConfig,
+		key:         cfg.Key,
+		encryption:  cfg.Encryption,
+		compression: cfg.Compression,
 	}, nil
 }
 
@@ -296,7 +530,10 @@ func (l *Listener) Accept() (*Conn, error) {
 		reader:     bufio.NewReaderSize(netConn, 256*1024),
 		writer:     bufio.NewWriterSize(netConn, 256*1024),
 		remoteAddr: netConn.RemoteAddr().String(),
+		key:        l.key,
 		encryption: l.encryption,
+
+		compressionCapable: l.compression,
 	}
 
 	if l.encryption && len(l.key) == 32 {
@@ -314,6 +551,9 @@ func (l *Listener) Accept() (*Conn, error) {
 
 // Close closes the listener.
 func (l *Listener) Close() error {
+	if l.udp != nil {
+		l.udp.sock.Close()
+	}
 	return l.listener.Close()
 }
 
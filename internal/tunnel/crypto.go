@@ -6,55 +6,123 @@ import (
 	"crypto/rand"
 	"fmt"
 	"io"
+	"runtime"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/sys/cpu"
+)
+
+// Packet ciphers. Connections start with AES-256-GCM; the handshake may
+// switch both ends to ChaCha20-Poly1305 (see Conn.SetCipher).
+const (
+	CipherAESGCM   = "aes-gcm"
+	CipherChaCha20 = "chacha20"
 )
 
+// ValidCipher reports whether name is a known cipher ("" means auto).
+func ValidCipher(name string) bool {
+	return name == "" || name == CipherAESGCM || name == CipherChaCha20
+}
+
+// DefaultCipher returns the faster cipher on this machine: AES-256-GCM with
+// AES hardware acceleration, ChaCha20-Poly1305 without (e.g. Raspberry Pi).
+func DefaultCipher() string {
+	if hasAESHardware() {
+		return CipherAESGCM
+	}
+	return CipherChaCha20
+}
+
+// hasAESHardware reports whether AES-GCM runs in hardware here, using the
+// same checks as crypto/tls.
+func hasAESHardware() bool {
+	switch runtime.GOARCH {
+	case "amd64":
+		return cpu.X86.HasAES && cpu.X86.HasPCLMULQDQ
+	case "arm64":
+		return cpu.ARM64.HasAES && cpu.ARM64.HasPMULL
+	case "s390x":
+		return cpu.S390X.HasAES && cpu.S390X.HasAESCTR && cpu.S390X.HasGHASH
+	}
+	return false
+}
+
 // Cipher handles encryption/decryption of VPN packets.
 type Cipher struct {
-	gcm cipher.AEAD
+	aead cipher.AEAD
+	name string
 }
 
 // NewCipher creates a new AES-256-GCM cipher.
 func NewCipher(key []byte) (*Cipher, error) {
+	return NewCipherFor(CipherAESGCM, key)
+}
+
+// NewCipherFor creates a cipher by name (CipherAESGCM or CipherChaCha20).
+func NewCipherFor(name string, key []byte) (*Cipher, error) {
+	aead, err := newAEAD(name, key)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead, name: name}, nil
+}
+
+// newAEAD creates the AEAD for a cipher name from a 32-byte key.
+func newAEAD(name string, key []byte) (cipher.AEAD, error) {
 	if len(key) != 32 {
 		return nil, fmt.Errorf("key must be 32 bytes for AES-256")
 	}
 
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
-	}
+	switch name {
+	case CipherAESGCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+		}
 
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create GCM: %w", err)
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create GCM: %w", err)
+		}
+		return gcm, nil
+	case CipherChaCha20:
+		aead, err := chacha20poly1305.New(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create ChaCha20-Poly1305: %w", err)
+		}
+		return aead, nil
 	}
+	return nil, fmt.Errorf("unknown cipher %q (use %s or %s)", name, CipherAESGCM, CipherChaCha20)
+}
 
-	return &Cipher{gcm: gcm}, nil
+// Name returns the cipher name (CipherAESGCM or CipherChaCha20).
+func (c *Cipher) Name() string {
+	return c.name
 }
 
-// Encrypt encrypts plaintext using AES-256-GCM.
+// Encrypt encrypts plaintext with the cipher's AEAD.
 // Returns nonce + ciphertext.
 func (c *Cipher) Encrypt(plaintext []byte) ([]byte, error) {
-	nonce := make([]byte, c.gcm.NonceSize())
+	nonce := make([]byte, c.aead.NonceSize())
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return nil, fmt.Errorf("failed to generate nonce: %w", err)
 	}
 
 	// Seal appends ciphertext to nonce
-	ciphertext := c.gcm.Seal(nonce, nonce, plaintext, nil)
+	ciphertext := c.aead.Seal(nonce, nonce, plaintext, nil)
 	return ciphertext, nil
 }
 
 // Decrypt decrypts ciphertext that was encrypted with Encrypt.
 // Expects nonce + ciphertext format.
 func (c *Cipher) Decrypt(ciphertext []byte) ([]byte, error) {
-	nonceSize := c.gcm.NonceSize()
+	nonceSize := c.aead.NonceSize()
 	if len(ciphertext) < nonceSize {
 		return nil, fmt.Errorf("ciphertext too short")
 	}
 
 	nonce, encrypted := ciphertext[:nonceSize], ciphertext[nonceSize:]
-	plaintext, err := c.gcm.Open(nil, nonce, encrypted, nil)
+	plaintext, err := c.aead.Open(nil, nonce, encrypted, nil)
 	if err != nil {
 		return nil, fmt.Errorf("decryption failed: %w", err)
 	}
@@ -63,7 +131,7 @@ func (c *Cipher) Decrypt(ciphertext []byte) ([]byte, error) {
 }
 
 // Overhead returns the number of bytes added by encryption.
-// This is nonce (12 bytes) + auth tag (16 bytes) = 28 bytes.
+// This is nonce (12 bytes) + auth tag (16 bytes) = 28 bytes for both ciphers.
 func (c *Cipher) Overhead() int {
-	return c.gcm.NonceSize() + c.gcm.Overhead()
+	return c.aead.NonceSize() + c.aead.Overhead()
 }
@@ -0,0 +1,235 @@
+package tunnel
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// darwinDefaultDNS are the resolvers used on macOS with route-all when no
+// DNS override is configured.
+var darwinDefaultDNS = []string{"1.1.1.1", "8.8.8.8"}
+
+const (
+	resolvConfPath = "/etc/resolv.conf"
+
+	// resolvConfBackup keeps the pre-VPN resolv.conf while route-all is on
+	// (Linux without systemd-resolved), so 'vpn restore' can put it back.
+	resolvConfBackup = "/etc/resolv.conf.vpn-backup"
+)
+
+// applyDNS points the OS resolver at the configured DNS servers while all
+// traffic goes through the VPN, remembering the previous configuration.
+func (t *TUN) applyDNS() {
+	if runtime.GOOS == "darwin" {
+		servers := t.dns
+		if len(servers) == 0 {
+			servers = darwinDefaultDNS
+		}
+		t.prevDNS = darwinDNSServers()
+
+		args := append([]string{"-setdnsservers", "Wi-Fi"}, servers...)
+		if err := execCommand("networksetup", args...).Run(); err != nil {
+			log.Printf("[tun] Warning: failed to set DNS servers: %v (DNS may leak)", err)
+			return
+		}
+		t.dnsApplied = true
+		log.Printf("[tun] DNS configured: %s through VPN", strings.Join(servers, ", "))
+		return
+	}
+
+	// Linux keeps the system resolver unless an override is configured
+	if len(t.dns) == 0 {
+		return
+	}
+
+	if systemdResolved() {
+		cmd := execCommand("resolvectl", append([]string{"dns", t.name}, t.dns...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			log.Printf("[tun] Warning: failed to set DNS servers: %v - %s (DNS may leak)", err, out)
+			return
+		}
+		// "~." makes this link the resolver for every domain
+		execCommand("resolvectl", "domain", t.name, "~.").Run()
+		execCommand("resolvectl", "default-route", t.name, "yes").Run()
+		t.dnsApplied = true
+		log.Printf("[tun] DNS configured via systemd-resolved: %s", strings.Join(t.dns, ", "))
+		return
+	}
+
+	current, err := os.ReadFile(resolvConfPath)
+	if err != nil {
+		log.Printf("[tun] Warning: failed to read %s: %v (DNS may leak)", resolvConfPath, err)
+		return
+	}
+	// Keep the oldest backup if a previous run died with route-all on
+	if _, err := os.Stat(resolvConfBackup); os.IsNotExist(err) {
+		if err := os.WriteFile(resolvConfBackup, current, 0644); err != nil {
+			log.Printf("[tun] Warning: failed to back up %s: %v (DNS not changed)", resolvConfPath, err)
+			return
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("# Written by vpn-node while routing all traffic; restored on disconnect\n")
+	for _, server := range t.dns {
+		fmt.Fprintf(&b, "nameserver %s\n", server)
+	}
+	if err := os.WriteFile(resolvConfPath, []byte(b.String()), 0644); err != nil {
+		log.Printf("[tun] Warning: failed to write %s: %v (DNS may leak)", resolvConfPath, err)
+		return
+	}
+	t.dnsApplied = true
+	log.Printf("[tun] DNS configured in %s: %s", resolvConfPath, strings.Join(t.dns, ", "))
+}
+
+// SetDNS replaces the DNS override. With apply (all traffic currently
+// routed through the VPN) the OS resolver is switched right away.
+func (t *TUN) SetDNS(servers []string, apply bool) {
+	t.restoreDNS()
+	t.dns = servers
+	if apply {
+		t.applyDNS()
+	}
+}
+
+// restoreDNS undoes applyDNS.
+func (t *TUN) restoreDNS() {
+	if !t.dnsApplied {
+		return
+	}
+	t.dnsApplied = false
+
+	if runtime.GOOS == "darwin" {
+		// "Empty" hands DNS back to DHCP
+		args := []string{"-setdnsservers", "Wi-Fi", "Empty"}
+		if len(t.prevDNS) > 0 {
+			args = append([]string{"-setdnsservers", "Wi-Fi"}, t.prevDNS...)
+		}
+		if err := execCommand("networksetup", args...).Run(); err != nil {
+			log.Printf("[tun] Warning: failed to restore DNS: %v", err)
+		} else if len(t.prevDNS) > 0 {
+			log.Printf("[tun] DNS restored to %s", strings.Join(t.prevDNS, ", "))
+		} else {
+			log.Printf("[tun] DNS restored to automatic (DHCP)")
+		}
+		return
+	}
+
+	if systemdResolved() {
+		if err := execCommand("resolvectl", "revert", t.name).Run(); err != nil {
+			log.Printf("[tun] Warning: failed to restore DNS: %v", err)
+		} else {
+			log.Printf("[tun] DNS restored (systemd-resolved)")
+		}
+		return
+	}
+
+	if err := restoreResolvConf(); err != nil {
+		log.Printf("[tun] Warning: failed to restore DNS: %v", err)
+	} else {
+		log.Printf("[tun] DNS restored from %s", resolvConfBackup)
+	}
+}
+
+// restoreResolvConf puts the pre-VPN resolv.conf back, if a backup exists.
+func restoreResolvConf() error {
+	if _, err := os.Stat(resolvConfBackup); os.IsNotExist(err) {
+		return nil
+	}
+	return os.Rename(resolvConfBackup, resolvConfPath)
+}
+
+// darwinDNSServers returns the DNS servers set manually on Wi-Fi (nil when
+// they come from DHCP).
+func darwinDNSServers() []string {
+	out, err := execCommand("networksetup", "-getdnsservers", "Wi-Fi").Output()
+	if err != nil {
+		return nil
+	}
+	// "There aren't any DNS Servers set on Wi-Fi." or one address per line
+	var servers []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if ip := net.ParseIP(strings.TrimSpace(line)); ip != nil {
+			servers = append(servers, ip.String())
+		}
+	}
+	return servers
+}
+
+// systemdResolved reports whether DNS is managed by systemd-resolved.
+func systemdResolved() bool {
+	if _, err := exec.LookPath("resolvectl"); err != nil {
+		return false
+	}
+	return execCommand("systemctl", "is-active", "--quiet", "systemd-resolved").Run() == nil
+}
+
+// ActiveDNSServers returns the resolvers the OS is currently using: the
+// first resolver of 'scutil --dns' on macOS, the nameservers in
+// resolv.conf on Linux (or systemd-resolved's servers behind its stub).
+func ActiveDNSServers() ([]string, error) {
+	if runtime.GOOS == "darwin" {
+		out, err := execCommand("scutil", "--dns").Output()
+		if err != nil {
+			return nil, fmt.Errorf("scutil failed: %w", err)
+		}
+		// resolver #1 ... nameserver[0] : 1.1.1.1 ... resolver #2
+		var servers []string
+		scanner := bufio.NewScanner(bytes.NewReader(out))
+		resolvers := 0
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if strings.HasPrefix(line, "resolver #") {
+				resolvers++
+				if resolvers > 1 && len(servers) > 0 {
+					break
+				}
+			}
+			if strings.HasPrefix(line, "nameserver[") {
+				if _, addr, ok := strings.Cut(line, ":"); ok {
+					servers = append(servers, strings.TrimSpace(addr))
+				}
+			}
+		}
+		return servers, nil
+	}
+
+	data, err := os.ReadFile(resolvConfPath)
+	if err != nil {
+		return nil, err
+	}
+	var servers []string
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+
+	// 127.0.0.53 is systemd-resolved's stub; ask it for the real servers
+	if len(servers) == 1 && servers[0] == "127.0.0.53" {
+		out, err := execCommand("resolvectl", "dns").Output()
+		if err != nil {
+			return servers, nil
+		}
+		// "Global: 1.1.1.1" / "Link 5 (tun0): 10.8.0.1"
+		servers = nil
+		for _, line := range strings.Split(string(out), "\n") {
+			_, list, ok := strings.Cut(line, "):")
+			if !ok {
+				_, list, ok = strings.Cut(line, "Global:")
+			}
+			if ok {
+				servers = append(servers, strings.Fields(list)...)
+			}
+		}
+	}
+	return servers, nil
+}
@@ -0,0 +1,103 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"os/exec"
+	"runtime"
+)
+
+// IPv6For returns the IPv6 address mirroring a VPN IPv4 address: the host
+// number within subnet becomes the interface ID (10.8.0.5 -> fd00::5), or
+// "" if ipv4 is not in subnet.
+func IPv6For(ipv4 string, subnet *net.IPNet) string {
+	ip := net.ParseIP(ipv4).To4()
+	if ip == nil || !subnet.Contains(ip) {
+		return ""
+	}
+	host := binary.BigEndian.Uint32(ip) - binary.BigEndian.Uint32(subnet.IP.To4())
+	ip6 := net.ParseIP(DefaultServerIP6)
+	binary.BigEndian.PutUint32(ip6[12:], host)
+	return ip6.String()
+}
+
+// IPv4For returns the VPN IPv4 address in subnet that an IPv6 VPN address
+// mirrors (fd00::5 -> 10.8.0.5), or "" if ip is not a VPN IPv6 address.
+func IPv4For(ip net.IP, subnet *net.IPNet) string {
+	_, vpnNet6, _ := net.ParseCIDR(DefaultSubnet6)
+	if ip.To4() != nil || !vpnNet6.Contains(ip) {
+		return ""
+	}
+	ip6 := ip.To16()
+	for _, b := range ip6[8:12] {
+		if b != 0 {
+			return ""
+		}
+	}
+	host := binary.BigEndian.Uint32(ip6[12:])
+	if host == 0 || host > uint32(SubnetHostCount(subnet)) {
+		return ""
+	}
+	return SubnetHost(subnet, int(host))
+}
+
+// LocalIP6 returns the IPv6 address of the TUN device ("" if none).
+func (t *TUN) LocalIP6() string {
+	return t.localIP6
+}
+
+// AddIPv6 assigns an IPv6 address (in DefaultSubnet6) to the TUN device
+// alongside its IPv4 one. Adding the current address again is a no-op.
+func (t *TUN) AddIPv6(addr string) error {
+	if addr == t.localIP6 {
+		return nil
+	}
+
+	if t.localIP6 != "" {
+		if runtime.GOOS == "darwin" {
+			exec.Command("ifconfig", t.name, "inet6", t.localIP6, "delete").Run()
+		} else {
+			exec.Command("ip", "-6", "addr", "del", t.localIP6+"/64", "dev", t.name).Run()
+		}
+	}
+
+	if err := t.assignIPv6(addr); err != nil {
+		return err
+	}
+	t.localIP6 = addr
+	log.Printf("[tun] Configured %s: %s/64", t.name, addr)
+	return nil
+}
+
+func (t *TUN) assignIPv6(addr string) error {
+	if runtime.GOOS == "darwin" {
+		cmd := exec.Command("ifconfig", t.name, "inet6", addr, "prefixlen", "64", "alias")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to assign IPv6: %v - %s", err, out)
+		}
+		// Route the subnet to us (ignore error if the route exists)
+		exec.Command("route", "-n", "add", "-inet6", "-net", DefaultSubnet6, "-interface", t.name).Run()
+		return nil
+	}
+
+	// TUN devices may come up with IPv6 disabled
+	exec.Command("sysctl", "-w", fmt.Sprintf("net.ipv6.conf.%s.disable_ipv6=0", t.name)).Run()
+
+	cmd := exec.Command("ip", "-6", "addr", "add", addr+"/64", "dev", t.name)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to assign IPv6: %v - %s", err, out)
+	}
+	return nil
+}
+
+// readdIPv6 restores the IPv6 address after a reconfiguration flushed it.
+func (t *TUN) readdIPv6() {
+	if t.localIP6 == "" {
+		return
+	}
+	if err := t.assignIPv6(t.localIP6); err != nil {
+		log.Printf("[tun] Warning: failed to restore IPv6 address: %v", err)
+	}
+}
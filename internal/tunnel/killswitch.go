@@ -0,0 +1,94 @@
+package tunnel
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+const (
+	// killSwitchTable is the nftables table holding the kill switch (Linux).
+	killSwitchTable = "vpn_killswitch"
+
+	// killSwitchAnchor is the pf anchor holding the kill switch (macOS).
+	// The stock pf.conf evaluates com.apple/*, so no pf.conf edit is needed.
+	killSwitchAnchor = "com.apple/vpn-killswitch"
+)
+
+// EnableKillSwitch installs a firewall rule that blocks all outgoing
+// traffic except through the TUN device, on loopback and to the VPN server
+// (so the node can reconnect). It stays in place until DisableKillSwitch,
+// even if vpn-node exits.
+func (t *TUN) EnableKillSwitch(serverIP string) error {
+	if net.ParseIP(serverIP) == nil {
+		return fmt.Errorf("server address %q is not an IP", serverIP)
+	}
+
+	var cmd *exec.Cmd
+	var rules string
+	if runtime.GOOS == "darwin" {
+		rules = fmt.Sprintf("pass out quick on lo0 all\n"+
+			"pass out quick on %s all\n"+
+			"pass out quick to %s\n"+
+			"block drop out quick all\n", t.name, serverIP)
+		cmd = exec.Command("pfctl", "-a", killSwitchAnchor, "-f", "-")
+	} else {
+		// Replace rather than append to a table left by a previous run
+		exec.Command("nft", "delete", "table", "inet", killSwitchTable).Run()
+		rules = fmt.Sprintf("table inet %s {\n"+
+			"\tchain output {\n"+
+			"\t\ttype filter hook output priority 0; policy drop;\n"+
+			"\t\toifname \"lo\" accept\n"+
+			"\t\toifname %q accept\n"+
+			"\t\tip daddr %s accept\n"+
+			"\t}\n"+
+			"}\n", killSwitchTable, t.name, serverIP)
+		cmd = exec.Command("nft", "-f", "-")
+	}
+
+	cmd.Stdin = strings.NewReader(rules)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to install kill switch: %v - %s", err, bytes.TrimSpace(out))
+	}
+	if runtime.GOOS == "darwin" {
+		// Fails harmlessly if pf is already enabled
+		exec.Command("pfctl", "-e").Run()
+	}
+
+	log.Printf("[tun] Kill switch engaged: only %s and %s are reachable", t.name, serverIP)
+	return nil
+}
+
+// DisableKillSwitch removes the kill switch firewall rule, if installed.
+func DisableKillSwitch() error {
+	if !KillSwitchActive() {
+		return nil
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		cmd = exec.Command("pfctl", "-a", killSwitchAnchor, "-F", "rules")
+	} else {
+		cmd = exec.Command("nft", "delete", "table", "inet", killSwitchTable)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to remove kill switch: %v - %s", err, bytes.TrimSpace(out))
+	}
+
+	log.Printf("[tun] Kill switch released")
+	return nil
+}
+
+// KillSwitchActive reports whether the kill switch firewall rule is
+// installed.
+func KillSwitchActive() bool {
+	if runtime.GOOS == "darwin" {
+		out, err := exec.Command("pfctl", "-a", killSwitchAnchor, "-s", "rules").Output()
+		return err == nil && len(bytes.TrimSpace(out)) > 0
+	}
+	return exec.Command("nft", "list", "table", "inet", killSwitchTable).Run() == nil
+}
@@ -0,0 +1,99 @@
+package tunnel
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"runtime"
+	"strconv"
+)
+
+const (
+	// MinMTU is the smallest MTU SetMTU accepts (the IPv4 minimum datagram
+	// size every host must handle).
+	MinMTU = 576
+
+	// icmpOverhead is the IPv4 and ICMP header bytes ping adds to its
+	// payload size.
+	icmpOverhead = 28
+
+	// TunnelOverhead is what the UDP transport adds to a packet on the
+	// wire: outer IPv4 and UDP headers, the datagram header, the AEAD tag
+	// and the compression header. Over TCP the outer connection segments
+	// packets itself, so only datagrams need to fit the path MTU.
+	TunnelOverhead = 20 + 8 + udpHeaderSize + 16 + 3
+)
+
+// MTU returns the TUN device's current MTU.
+func (t *TUN) MTU() int {
+	return int(t.mtu.Load())
+}
+
+// SetMTU changes the TUN device's MTU, e.g. to the lower MTU a server
+// advertises or ProbePathMTU finds. It cannot exceed MTU, which sizes the
+// packet buffers.
+func (t *TUN) SetMTU(mtu int) error {
+	if mtu < MinMTU || mtu > MTU {
+		return fmt.Errorf("MTU %d out of range (%d-%d)", mtu, MinMTU, MTU)
+	}
+	if mtu == t.MTU() {
+		return nil
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		cmd = exec.Command("ifconfig", t.name, "mtu", strconv.Itoa(mtu))
+	} else {
+		cmd = exec.Command("ip", "link", "set", "dev", t.name, "mtu", strconv.Itoa(mtu))
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to set MTU on %s: %v - %s", t.name, err, out)
+	}
+
+	log.Printf("[tun] %s MTU changed from %d to %d", t.name, t.MTU(), mtu)
+	t.mtu.Store(int32(mtu))
+	return nil
+}
+
+// ProbePathMTU finds the largest packet, between MinMTU and max bytes, that
+// reaches host unfragmented: it pings with the don't-fragment bit set and
+// narrows down the size by bisection, keeping the largest size answered.
+// host must be reached outside the tunnel (the server's public IP), since
+// the DF bit does not survive encapsulation; TunnelMTU turns the result
+// into a TUN MTU.
+func ProbePathMTU(host string, max int) (int, error) {
+	if !pingDF(host, MinMTU) {
+		return 0, fmt.Errorf("%s does not answer %d-byte pings", host, MinMTU)
+	}
+
+	best, lo, hi := MinMTU, MinMTU+1, max
+	for lo <= hi {
+		size := (lo + hi) / 2
+		if pingDF(host, size) {
+			best, lo = size, size+1
+		} else {
+			hi = size - 1
+		}
+	}
+	return best, nil
+}
+
+// TunnelMTU returns the TUN MTU whose packets, once encapsulated, fit a
+// path MTU of pathMTU (at least MinMTU).
+func TunnelMTU(pathMTU int) int {
+	return max(pathMTU-TunnelOverhead, MinMTU)
+}
+
+// pingDF sends one ICMP echo of size bytes (headers included) to host with
+// the don't-fragment bit set and reports whether it was answered within a
+// second.
+func pingDF(host string, size int) bool {
+	payload := strconv.Itoa(size - icmpOverhead)
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		cmd = exec.Command("ping", "-D", "-c", "1", "-t", "1", "-s", payload, host)
+	} else {
+		cmd = exec.Command("ping", "-M", "do", "-c", "1", "-W", "1", "-s", payload, host)
+	}
+	return cmd.Run() == nil
+}
@@ -0,0 +1,156 @@
+package tunnel
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// natRule is an iptables rule installed by EnableNAT.
+type natRule struct {
+	table string
+	chain string
+	spec  []string
+}
+
+// natRules returns the rules that let route-all clients reach the internet
+// through the server: VPN traffic leaving through iface is masqueraded, and
+// forwarded in both directions even if the FORWARD policy drops.
+func natRules(subnet *net.IPNet, iface string) []natRule {
+	cidr := subnet.String()
+	return []natRule{
+		{"nat", "POSTROUTING", []string{"-s", cidr, "-o", iface, "-j", "MASQUERADE"}},
+		{"filter", "FORWARD", []string{"-s", cidr, "-o", iface, "-j", "ACCEPT"}},
+		{"filter", "FORWARD", []string{"-d", cidr, "-i", iface, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"}},
+	}
+}
+
+// iptables runs iptables with op (-C, -A, -I, -D) on the rule.
+func (r natRule) iptables(op string) ([]byte, error) {
+	args := append([]string{"-t", r.table, op, r.chain}, r.spec...)
+	return exec.Command("iptables", args...).CombinedOutput()
+}
+
+// ExternalInterface returns the interface internet traffic leaves through,
+// from "ip route get 8.8.8.8" (Linux only).
+func ExternalInterface() (string, error) {
+	if runtime.GOOS != "linux" {
+		return "", fmt.Errorf("not supported on %s", runtime.GOOS)
+	}
+
+	// 8.8.8.8 via 95.217.238.65 dev eth0 src 95.217.238.72 uid 0
+	out, err := exec.Command("ip", "route", "get", "8.8.8.8").Output()
+	if err != nil {
+		return "", fmt.Errorf("ip route get failed: %w", err)
+	}
+	fields := strings.Fields(string(out))
+	for i := 0; i+1 < len(fields); i++ {
+		if fields[i] == "dev" {
+			return fields[i+1], nil
+		}
+	}
+	return "", fmt.Errorf("no interface in %q", strings.TrimSpace(string(out)))
+}
+
+// EnableNAT turns on IPv4 forwarding and masquerades traffic from subnet
+// leaving through iface, so route-all clients can reach the internet
+// through this server (Linux only). Rules already present are not added
+// again, so it is safe to call after a restart that kept them.
+func EnableNAT(subnet *net.IPNet, iface string) error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("NAT is only supported on Linux")
+	}
+
+	if out, err := exec.Command("sysctl", "-w", "net.ipv4.ip_forward=1").CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to enable IP forwarding: %v - %s", err, bytes.TrimSpace(out))
+	}
+
+	for _, rule := range natRules(subnet, iface) {
+		if _, err := rule.iptables("-C"); err == nil {
+			continue
+		}
+		// Insert FORWARD rules ahead of any DROP/REJECT rules
+		op := "-A"
+		if rule.chain == "FORWARD" {
+			op = "-I"
+		}
+		if out, err := rule.iptables(op); err != nil {
+			return fmt.Errorf("failed to add %s rule: %v - %s", rule.chain, err, bytes.TrimSpace(out))
+		}
+	}
+
+	log.Printf("[tun] NAT enabled: %s masqueraded via %s", subnet, iface)
+	return nil
+}
+
+// DisableNAT removes the rules added by EnableNAT. IP forwarding is left
+// on, since other services may rely on it.
+func DisableNAT(subnet *net.IPNet, iface string) error {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+
+	var firstErr error
+	for _, rule := range natRules(subnet, iface) {
+		if _, err := rule.iptables("-C"); err != nil {
+			continue
+		}
+		if out, err := rule.iptables("-D"); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to remove %s rule: %v - %s", rule.chain, err, bytes.TrimSpace(out))
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+
+	log.Printf("[tun] NAT disabled for %s", subnet)
+	return nil
+}
+
+// NATStatus reports whether IP forwarding is on and the interface a
+// MASQUERADE rule for subnet sends traffic through ("" if there is none).
+func NATStatus(subnet *net.IPNet) (forwarding bool, iface string, err error) {
+	if runtime.GOOS != "linux" {
+		return false, "", fmt.Errorf("not supported on %s", runtime.GOOS)
+	}
+
+	data, err := os.ReadFile("/proc/sys/net/ipv4/ip_forward")
+	if err != nil {
+		return false, "", err
+	}
+	forwarding = strings.TrimSpace(string(data)) == "1"
+
+	out, err := exec.Command("iptables", "-t", "nat", "-S", "POSTROUTING").Output()
+	if err != nil {
+		return forwarding, "", fmt.Errorf("iptables failed: %w", err)
+	}
+
+	// -A POSTROUTING -s 10.8.0.0/24 -o eth0 -j MASQUERADE
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		var source, out string
+		masquerade := false
+		for i := 0; i+1 < len(fields); i++ {
+			switch fields[i] {
+			case "-s":
+				source = fields[i+1]
+			case "-o":
+				out = fields[i+1]
+			case "-j":
+				masquerade = fields[i+1] == "MASQUERADE"
+			}
+		}
+		if masquerade && source == subnet.String() {
+			if out == "" {
+				out = "any"
+			}
+			return forwarding, out, nil
+		}
+	}
+	return forwarding, "", nil
+}
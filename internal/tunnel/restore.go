@@ -0,0 +1,198 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// PreVPNGatewayPath returns the file where RouteAllTraffic saves the default
+// gateway it replaces, so routing can be restored even if the node dies
+// before RestoreRouting runs: ~/.vpn-node/pre-vpn-gateway.
+func PreVPNGatewayPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "/tmp"
+	}
+	return filepath.Join(home, ".vpn-node", "pre-vpn-gateway")
+}
+
+// savePreVPNGateway records the pre-VPN default gateway.
+func savePreVPNGateway(gw string) error {
+	path := PreVPNGatewayPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(gw+"\n"), 0644)
+}
+
+// LoadPreVPNGateway returns the saved pre-VPN default gateway ("" if none).
+func LoadPreVPNGateway() (string, error) {
+	data, err := os.ReadFile(PreVPNGatewayPath())
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// clearPreVPNGateway forgets the saved gateway once routing is restored.
+func clearPreVPNGateway() {
+	os.Remove(PreVPNGatewayPath())
+}
+
+// DefaultRoute is a default route found in the system routing table.
+type DefaultRoute struct {
+	Gateway   string
+	Interface string
+}
+
+// IsVPN reports whether the route goes through a VPN TUN device or a
+// gateway in the VPN subnet (DefaultSubnet if nil).
+func (r DefaultRoute) IsVPN(subnet *net.IPNet) bool {
+	if strings.HasPrefix(r.Interface, "tun") || strings.HasPrefix(r.Interface, "utun") {
+		return true
+	}
+	vpnNet := subnet
+	if vpnNet == nil {
+		vpnNet, _ = ParseSubnet(DefaultSubnet)
+	}
+	ip := net.ParseIP(r.Gateway)
+	return ip != nil && vpnNet.Contains(ip)
+}
+
+// DefaultRoutes reads the IPv4 default routes straight from the routing
+// table (netstat -rn on macOS, ip route show on Linux), without the node.
+func DefaultRoutes() ([]DefaultRoute, error) {
+	if runtime.GOOS == "darwin" {
+		output, err := exec.Command("netstat", "-rn", "-f", "inet").Output()
+		if err != nil {
+			return nil, fmt.Errorf("netstat failed: %w", err)
+		}
+
+		// Destination Gateway Flags Netif Expire
+		var routes []DefaultRoute
+		for _, line := range strings.Split(string(output), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 4 || fields[0] != "default" {
+				continue
+			}
+			routes = append(routes, DefaultRoute{Gateway: fields[1], Interface: fields[3]})
+		}
+		return routes, nil
+	}
+
+	output, err := exec.Command("ip", "-4", "route", "show", "default").Output()
+	if err != nil {
+		return nil, fmt.Errorf("ip route failed: %w", err)
+	}
+
+	// default via 192.168.1.1 dev wlan0 proto dhcp metric 600
+	var routes []DefaultRoute
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != "default" {
+			continue
+		}
+		var route DefaultRoute
+		for i := 1; i+1 < len(fields); i++ {
+			switch fields[i] {
+			case "via":
+				route.Gateway = fields[i+1]
+			case "dev":
+				route.Interface = fields[i+1]
+			}
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// RestoreResult describes what RestoreDefaultRoute changed.
+type RestoreResult struct {
+	Removed    []DefaultRoute // VPN default routes deleted
+	Gateway    string         // Gateway of the restored default route ("" if none was added)
+	Existing   bool           // A non-VPN default route was already present
+	KillSwitch bool           // A kill switch firewall rule was removed
+}
+
+// RestoreDefaultRoute repairs routing left behind by a node that died with
+// route-all active: it deletes default routes through the TUN device and
+// re-adds the saved pre-VPN default gateway, releasing the kill switch if
+// one is blocking traffic. It needs root but no running node.
+func RestoreDefaultRoute() (*RestoreResult, error) {
+	result := &RestoreResult{}
+	if KillSwitchActive() {
+		if err := DisableKillSwitch(); err != nil {
+			return result, err
+		}
+		result.KillSwitch = true
+	}
+
+	routes, err := DefaultRoutes()
+	if err != nil {
+		return result, err
+	}
+
+	for _, route := range routes {
+		if !route.IsVPN(nil) {
+			result.Existing = true
+			continue
+		}
+
+		var cmd *exec.Cmd
+		if runtime.GOOS == "darwin" {
+			cmd = exec.Command("route", "-n", "delete", "default", route.Gateway)
+		} else if route.Interface != "" {
+			cmd = exec.Command("ip", "route", "del", "default", "dev", route.Interface)
+		} else {
+			cmd = exec.Command("ip", "route", "del", "default", "via", route.Gateway)
+		}
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return result, fmt.Errorf("failed to delete VPN default route via %s: %v - %s", route.Gateway, err, out)
+		}
+		result.Removed = append(result.Removed, route)
+	}
+
+	if runtime.GOOS == "darwin" && len(result.Removed) > 0 {
+		// Undo the DNS and IPv6 changes made by routeAllTrafficDarwin
+		exec.Command("networksetup", "-setdnsservers", "Wi-Fi", "Empty").Run()
+		exec.Command("networksetup", "-setv6automatic", "Wi-Fi").Run()
+	}
+	if runtime.GOOS == "linux" {
+		// Put back the resolv.conf replaced by a --dns override
+		restoreResolvConf()
+	}
+
+	if result.Existing {
+		clearPreVPNGateway()
+		return result, nil
+	}
+
+	gw, err := LoadPreVPNGateway()
+	if err != nil {
+		return result, fmt.Errorf("failed to read %s: %w", PreVPNGatewayPath(), err)
+	}
+	if gw == "" {
+		return result, fmt.Errorf("no default route left and no saved gateway in %s; reconnect to the network (e.g. toggle Wi-Fi) to get one from DHCP", PreVPNGatewayPath())
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		cmd = exec.Command("route", "-n", "add", "-net", "default", gw)
+	} else {
+		cmd = exec.Command("ip", "route", "add", "default", "via", gw)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return result, fmt.Errorf("failed to add default route via %s: %v - %s", gw, err, out)
+	}
+	result.Gateway = gw
+	clearPreVPNGateway()
+	return result, nil
+}
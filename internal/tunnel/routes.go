@@ -0,0 +1,164 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Route purposes.
+const (
+	RoutePurposeDefault = "default" // Default route through the VPN (route-all)
+	RoutePurposeServer  = "server"  // VPN server pinned to the original gateway
+	RoutePurposeSubnet  = "subnet"  // Split-tunnel CIDR through the VPN
+)
+
+// Route is a route the TUN device added to the system routing table.
+type Route struct {
+	Destination string // "default", a host IP or a CIDR
+	Gateway     string
+	Interface   string // "" when the OS picks it
+	Purpose     string // RoutePurpose*
+}
+
+// Routes returns the routes currently installed by RouteAllTraffic and
+// RouteSubnets.
+func (t *TUN) Routes() []Route {
+	t.routesMu.Lock()
+	defer t.routesMu.Unlock()
+	return append([]Route(nil), t.routes...)
+}
+
+// trackRoute records a route that was just added.
+func (t *TUN) trackRoute(r Route) {
+	t.routesMu.Lock()
+	defer t.routesMu.Unlock()
+	t.routes = append(t.routes, r)
+}
+
+// untrackRoutes forgets the routes with any of the given purposes.
+func (t *TUN) untrackRoutes(purposes ...string) {
+	t.routesMu.Lock()
+	defer t.routesMu.Unlock()
+
+	kept := t.routes[:0]
+	for _, r := range t.routes {
+		drop := false
+		for _, p := range purposes {
+			if r.Purpose == p {
+				drop = true
+			}
+		}
+		if !drop {
+			kept = append(kept, r)
+		}
+	}
+	t.routes = kept
+}
+
+// SystemRoutes reads the IPv4 routing table (netstat -rn on macOS, ip route
+// show on Linux). Destinations are normalized like Route.Destination.
+func SystemRoutes() ([]Route, error) {
+	if runtime.GOOS == "darwin" {
+		output, err := exec.Command("netstat", "-rn", "-f", "inet").Output()
+		if err != nil {
+			return nil, fmt.Errorf("netstat failed: %w", err)
+		}
+
+		// Destination Gateway Flags Netif Expire
+		var routes []Route
+		for _, line := range strings.Split(string(output), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 4 || fields[0] == "Destination" {
+				continue
+			}
+			dest := normalizeRouteDestination(fields[0])
+			if dest == "" {
+				continue
+			}
+			routes = append(routes, Route{Destination: dest, Gateway: fields[1], Interface: fields[3]})
+		}
+		return routes, nil
+	}
+
+	output, err := exec.Command("ip", "-4", "route", "show").Output()
+	if err != nil {
+		return nil, fmt.Errorf("ip route failed: %w", err)
+	}
+
+	// 10.0.0.0/8 via 10.8.0.1 dev tun0
+	var routes []Route
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		route := Route{Destination: normalizeRouteDestination(fields[0])}
+		if route.Destination == "" {
+			continue
+		}
+		for i := 1; i+1 < len(fields); i++ {
+			switch fields[i] {
+			case "via":
+				route.Gateway = fields[i+1]
+			case "dev":
+				route.Interface = fields[i+1]
+			}
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// normalizeRouteDestination turns a routing table destination into
+// "default", a host IP or a canonical CIDR. macOS abbreviates networks
+// ("10.8/16", "192.168.1" for 192.168.1.0/24). Returns "" if unparseable.
+func normalizeRouteDestination(dest string) string {
+	if dest == "default" || dest == "0.0.0.0/0" || dest == "0/0" {
+		return "default"
+	}
+
+	addr, bits, hasBits := strings.Cut(dest, "/")
+	octets := strings.Split(addr, ".")
+	if len(octets) > 4 {
+		return ""
+	}
+	if !hasBits {
+		if len(octets) == 4 {
+			if net.ParseIP(addr) == nil {
+				return ""
+			}
+			return addr
+		}
+		bits = fmt.Sprint(8 * len(octets))
+	}
+	for len(octets) < 4 {
+		octets = append(octets, "0")
+	}
+
+	_, ipNet, err := net.ParseCIDR(strings.Join(octets, ".") + "/" + bits)
+	if err != nil {
+		return ""
+	}
+	if ones, _ := ipNet.Mask.Size(); ones == 32 {
+		return ipNet.IP.String()
+	}
+	return ipNet.String()
+}
+
+// InTable reports whether the route is present in a routing table read by
+// SystemRoutes: same destination and, if the route has one, same gateway.
+func (r Route) InTable(table []Route) bool {
+	dest := normalizeRouteDestination(r.Destination)
+	for _, s := range table {
+		if s.Destination != dest {
+			continue
+		}
+		if r.Gateway == "" || s.Gateway == r.Gateway {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// ParseSubnet parses the VPN subnet ("" = DefaultSubnet). It must be an
+// IPv4 network with room for the server and at least one client.
+func ParseSubnet(cidr string) (*net.IPNet, error) {
+	if cidr == "" {
+		cidr = DefaultSubnet
+	}
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	if subnet.IP.To4() == nil {
+		return nil, fmt.Errorf("%s is not an IPv4 subnet", cidr)
+	}
+	if SubnetHostCount(subnet) < 2 {
+		return nil, fmt.Errorf("%s is too small (need at least a /30)", cidr)
+	}
+	return subnet, nil
+}
+
+// SubnetHostCount returns the number of usable host addresses in subnet
+// (254 for a /24).
+func SubnetHostCount(subnet *net.IPNet) int {
+	ones, bits := subnet.Mask.Size()
+	if bits-ones < 2 {
+		return 0
+	}
+	if bits-ones > 30 {
+		return 1<<30 - 2
+	}
+	return 1<<(bits-ones) - 2
+}
+
+// SubnetHost returns the n-th host address of subnet: 1 is the server
+// (10.8.0.1), 2 the first client, and so on.
+func SubnetHost(subnet *net.IPNet, n int) string {
+	base := binary.BigEndian.Uint32(subnet.IP.To4())
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, base+uint32(n))
+	return ip.String()
+}
+
+// SubnetPrefix returns ip with the subnet's prefix length (10.8.0.5/24).
+func SubnetPrefix(ip string, subnet *net.IPNet) string {
+	ones, _ := subnet.Mask.Size()
+	return fmt.Sprintf("%s/%d", ip, ones)
+}
@@ -8,6 +8,8 @@ import (
 	"os/exec"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/songgao/water"
 )
@@ -22,6 +24,13 @@ const (
 
 	// DefaultSubnet is the VPN subnet.
 	DefaultSubnet = "10.8.0.0/24"
+
+	// DefaultSubnet6 is the VPN's IPv6 ULA subnet (--ipv6). A node's IPv6
+	// address mirrors its IPv4 one: 10.8.0.5 <-> fd00::5.
+	DefaultSubnet6 = "fd00::/64"
+
+	// DefaultServerIP6 is the VPN gateway's IPv6 address.
+	DefaultServerIP6 = "fd00::1"
 )
 
 // TUN represents a TUN device for VPN traffic.
@@ -30,11 +39,26 @@ type TUN struct {
 	name           string
 	localIP        string
 	gatewayIP      string
-	originalGW     string // Original default gateway before VPN
-	serverPublicIP string // Server's public IP (for route cleanup)
-	ipv6WasEnabled bool   // Track if IPv6 was enabled before VPN connected
+	subnet         *net.IPNet // VPN subnet (netmask and subnet route)
+	originalGW     string     // Original default gateway before VPN
+	serverPublicIP string     // Server's public IP (for route cleanup)
+	ipv6WasEnabled bool       // Track if IPv6 was enabled before VPN connected
+	localIP6       string     // IPv6 address, "" unless AddIPv6 was called
+	dns            []string   // DNS servers to use while routing all traffic
+	prevDNS        []string   // DNS servers before applyDNS (macOS, nil = DHCP)
+	dnsApplied     bool       // applyDNS changed the system resolver
+
+	routesMu     sync.Mutex
+	routes       []Route  // Routes added by RouteAllTraffic and RouteSubnets
+	subnetRoutes []string // CIDRs routed through the VPN (split tunneling)
+
+	mtu atomic.Int32 // Device MTU: MTU, or lower after SetMTU
 }
 
+// execCommand creates the ip, route, ifconfig and networksetup commands that
+// configure the device, routing and DNS. Tests replace it to record them.
+var execCommand = exec.Command
+
 // Config holds TUN device configuration.
 type Config struct {
 	// LocalIP is the IP address assigned to this node's TUN interface.
@@ -45,10 +69,22 @@ type Config struct {
 
 	// DeviceName is the desired TUN device name (Linux only).
 	DeviceName string
+
+	// Subnet is the VPN subnet in CIDR notation (default: DefaultSubnet).
+	Subnet string
+
+	// DNS are the resolvers pushed into the OS while routing all traffic
+	// (default: unchanged on Linux, 1.1.1.1 and 8.8.8.8 on macOS).
+	DNS []string
 }
 
 // New creates a new TUN device.
 func New(cfg Config) (*TUN, error) {
+	subnet, err := ParseSubnet(cfg.Subnet)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VPN subnet: %w", err)
+	}
+
 	waterCfg := water.Config{
 		DeviceType: water.TUN,
 	}
@@ -68,7 +104,10 @@ func New(cfg Config) (*TUN, error) {
 		name:      iface.Name(),
 		localIP:   cfg.LocalIP,
 		gatewayIP: cfg.GatewayIP,
+		subnet:    subnet,
+		dns:       cfg.DNS,
 	}
+	tun.mtu.Store(MTU)
 
 	log.Printf("[tun] Created TUN device: %s", tun.name)
 
@@ -91,57 +130,57 @@ func (t *TUN) configure() error {
 // configureDarwin configures the TUN device on macOS.
 func (t *TUN) configureDarwin() error {
 	// macOS uses ifconfig with point-to-point syntax
-	cmd := exec.Command("ifconfig", t.name, t.localIP, t.gatewayIP, "up")
+	cmd := execCommand("ifconfig", t.name, t.localIP, t.gatewayIP, "up")
 	if out, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("failed to configure %s: %v - %s", t.name, err, out)
 	}
 
 	// Set MTU
-	cmd = exec.Command("ifconfig", t.name, "mtu", fmt.Sprintf("%d", MTU))
+	cmd = execCommand("ifconfig", t.name, "mtu", fmt.Sprintf("%d", t.MTU()))
 	if err := cmd.Run(); err != nil {
 		log.Printf("[tun] Warning: failed to set MTU: %v", err)
 	}
 
 	// Add route for VPN subnet
-	cmd = exec.Command("route", "-n", "add", "-net", DefaultSubnet, "-interface", t.name)
+	cmd = execCommand("route", "-n", "add", "-net", t.subnet.String(), "-interface", t.name)
 	if err := cmd.Run(); err != nil {
 		log.Printf("[tun] Warning: failed to add subnet route: %v", err)
 	}
 
-	log.Printf("[tun] Configured %s: %s -> %s (MTU=%d)", t.name, t.localIP, t.gatewayIP, MTU)
+	log.Printf("[tun] Configured %s: %s -> %s (MTU=%d)", t.name, t.localIP, t.gatewayIP, t.MTU())
 	return nil
 }
 
 // configureLinux configures the TUN device on Linux.
 func (t *TUN) configureLinux() error {
 	// Flush existing IPs
-	exec.Command("ip", "addr", "flush", "dev", t.name).Run()
+	execCommand("ip", "addr", "flush", "dev", t.name).Run()
 
 	// Assign IP address
-	cmd := exec.Command("ip", "addr", "add", t.localIP+"/24", "dev", t.name)
+	cmd := execCommand("ip", "addr", "add", SubnetPrefix(t.localIP, t.subnet), "dev", t.name)
 	if out, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("failed to assign IP: %v - %s", err, out)
 	}
 
 	// Set MTU
-	cmd = exec.Command("ip", "link", "set", "dev", t.name, "mtu", fmt.Sprintf("%d", MTU))
+	cmd = execCommand("ip", "link", "set", "dev", t.name, "mtu", fmt.Sprintf("%d", t.MTU()))
 	if err := cmd.Run(); err != nil {
 		log.Printf("[tun] Warning: failed to set MTU: %v", err)
 	}
 
 	// Increase TX queue length for high throughput
-	cmd = exec.Command("ip", "link", "set", "dev", t.name, "txqueuelen", "10000")
+	cmd = execCommand("ip", "link", "set", "dev", t.name, "txqueuelen", "10000")
 	if err := cmd.Run(); err != nil {
 		log.Printf("[tun] Warning: failed to set txqueuelen: %v", err)
 	}
 
 	// Bring interface up
-	cmd = exec.Command("ip", "link", "set", "dev", t.name, "up")
+	cmd = execCommand("ip", "link", "set", "dev", t.name, "up")
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("failed to bring interface up: %v", err)
 	}
 
-	log.Printf("[tun] Configured %s: %s/24 (MTU=%d)", t.name, t.localIP, MTU)
+	log.Printf("[tun] Configured %s: %s (MTU=%d)", t.name, SubnetPrefix(t.localIP, t.subnet), t.MTU())
 	return nil
 }
 
@@ -194,17 +233,17 @@ func (t *TUN) Reconfigure(newLocalIP string) error {
 func (t *TUN) reconfigureDarwin() error {
 	// On macOS, we need to update the point-to-point addresses
 	// First delete the old address configuration
-	cmd := exec.Command("ifconfig", t.name, "delete", t.localIP)
+	cmd := execCommand("ifconfig", t.name, "delete", t.localIP)
 	cmd.Run() // Ignore error, might fail if old IP already removed
 
 	// Reconfigure with new IP
-	cmd = exec.Command("ifconfig", t.name, t.localIP, t.gatewayIP, "up")
+	cmd = execCommand("ifconfig", t.name, t.localIP, t.gatewayIP, "up")
 	if out, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("failed to reconfigure %s: %v - %s", t.name, err, out)
 	}
 
 	// Re-add subnet route (might be lost after reconfig)
-	cmd = exec.Command("route", "-n", "add", "-net", DefaultSubnet, "-interface", t.name)
+	cmd = execCommand("route", "-n", "add", "-net", t.subnet.String(), "-interface", t.name)
 	cmd.Run() // Ignore error if route exists
 
 	log.Printf("[tun] Reconfigured %s: %s -> %s", t.name, t.localIP, t.gatewayIP)
@@ -214,15 +253,17 @@ func (t *TUN) reconfigureDarwin() error {
 // reconfigureLinux reconfigures the TUN device on Linux.
 func (t *TUN) reconfigureLinux() error {
 	// Flush existing IPs
-	exec.Command("ip", "addr", "flush", "dev", t.name).Run()
+	execCommand("ip", "addr", "flush", "dev", t.name).Run()
 
 	// Assign new IP address
-	cmd := exec.Command("ip", "addr", "add", t.localIP+"/24", "dev", t.name)
+	cmd := execCommand("ip", "addr", "add", SubnetPrefix(t.localIP, t.subnet), "dev", t.name)
 	if out, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("failed to assign IP: %v - %s", err, out)
 	}
 
-	log.Printf("[tun] Reconfigured %s: %s/24", t.name, t.localIP)
+	t.readdIPv6()
+
+	log.Printf("[tun] Reconfigured %s: %s", t.name, SubnetPrefix(t.localIP, t.subnet))
 	return nil
 }
 
@@ -231,15 +272,34 @@ func (t *TUN) LocalIP() string {
 	return t.localIP
 }
 
-// GetDefaultGateway returns the current default gateway.
+// GetDefaultGateway returns the current default gateway. On Linux it is
+// read from "ip route show default", skipping routes without a gateway
+// (e.g. "default dev wg0") and preferring one that is not through the VPN.
 func GetDefaultGateway() (string, error) {
-	var cmd *exec.Cmd
-	if runtime.GOOS == "darwin" {
-		cmd = exec.Command("sh", "-c", "route -n get default | grep gateway | awk '{print $2}'")
-	} else {
-		cmd = exec.Command("sh", "-c", "ip route | grep default | awk '{print $3}'")
+	if runtime.GOOS != "darwin" {
+		routes, err := DefaultRoutes()
+		if err != nil {
+			return "", err
+		}
+		gw := ""
+		for _, route := range routes {
+			if route.Gateway == "" {
+				continue
+			}
+			if !route.IsVPN(nil) {
+				return route.Gateway, nil
+			}
+			if gw == "" {
+				gw = route.Gateway
+			}
+		}
+		if gw == "" {
+			return "", fmt.Errorf("no default route with a gateway")
+		}
+		return gw, nil
 	}
 
+	cmd := execCommand("sh", "-c", "route -n get default | grep gateway | awk '{print $2}'")
 	output, err := cmd.Output()
 	if err != nil {
 		return "", err
@@ -262,6 +322,13 @@ func (t *TUN) RouteAllTraffic(serverPublicIP string) error {
 	t.originalGW = gw
 	log.Printf("[tun] Original gateway: %s", t.originalGW)
 
+	// Persist it for 'vpn restore' in case we die before RestoreRouting
+	if !(DefaultRoute{Gateway: gw}).IsVPN(t.subnet) {
+		if err := savePreVPNGateway(gw); err != nil {
+			log.Printf("[tun] Warning: failed to save original gateway: %v", err)
+		}
+	}
+
 	if runtime.GOOS == "darwin" {
 		return t.routeAllTrafficDarwin(serverPublicIP)
 	}
@@ -273,35 +340,33 @@ func (t *TUN) routeAllTrafficDarwin(serverPublicIP string) error {
 	t.serverPublicIP = serverPublicIP
 
 	// Route VPN server through original gateway (prevent routing loop)
-	cmd := exec.Command("route", "-n", "add", "-host", serverPublicIP, t.originalGW)
+	cmd := execCommand("route", "-n", "add", "-host", serverPublicIP, t.originalGW)
 	if err := cmd.Run(); err != nil {
 		log.Printf("[tun] Warning: failed to add server route: %v", err)
+	} else {
+		t.trackRoute(Route{Destination: serverPublicIP, Gateway: t.originalGW, Purpose: RoutePurposeServer})
 	}
 
 	// Delete default route
-	cmd = exec.Command("route", "-n", "delete", "default")
+	cmd = execCommand("route", "-n", "delete", "default")
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("failed to delete default route: %v", err)
 	}
 
 	// Add default route through VPN gateway
-	cmd = exec.Command("route", "-n", "add", "-net", "default", t.gatewayIP)
+	cmd = execCommand("route", "-n", "add", "-net", "default", t.gatewayIP)
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("failed to add VPN route: %v", err)
 	}
+	t.trackRoute(Route{Destination: "default", Gateway: t.gatewayIP, Interface: t.name, Purpose: RoutePurposeDefault})
 
-	// Configure DNS to use fast public resolvers through VPN
+	// Configure DNS to use resolvers through VPN
 	// This prevents DNS leaks and improves privacy
-	cmd = exec.Command("networksetup", "-setdnsservers", "Wi-Fi", "1.1.1.1", "8.8.8.8")
-	if err := cmd.Run(); err != nil {
-		log.Printf("[tun] Warning: failed to set DNS servers: %v (DNS may leak)", err)
-	} else {
-		log.Printf("[tun] DNS configured: 1.1.1.1 (Cloudflare), 8.8.8.8 (Google) through VPN")
-	}
+	t.applyDNS()
 
 	// Prevent IPv6 leaks by disabling IPv6 on Wi-Fi
 	// First, check if IPv6 is currently enabled
-	cmd = exec.Command("networksetup", "-getinfo", "Wi-Fi")
+	cmd = execCommand("networksetup", "-getinfo", "Wi-Fi")
 	output, err := cmd.Output()
 	if err == nil {
 		outputStr := string(output)
@@ -311,7 +376,7 @@ func (t *TUN) routeAllTrafficDarwin(serverPublicIP string) error {
 	}
 
 	// Disable IPv6 to prevent leaks
-	cmd = exec.Command("networksetup", "-setv6off", "Wi-Fi")
+	cmd = execCommand("networksetup", "-setv6off", "Wi-Fi")
 	if err := cmd.Run(); err != nil {
 		log.Printf("[tun] Warning: failed to disable IPv6: %v (IPv6 may leak)", err)
 	} else {
@@ -327,104 +392,252 @@ func (t *TUN) routeAllTrafficLinux(serverPublicIP string) error {
 	t.serverPublicIP = serverPublicIP
 
 	// Route VPN server through original gateway
-	cmd := exec.Command("ip", "route", "add", serverPublicIP, "via", t.originalGW)
+	cmd := execCommand("ip", "route", "add", serverPublicIP, "via", t.originalGW)
 	if err := cmd.Run(); err != nil {
 		log.Printf("[tun] Warning: failed to add server route: %v", err)
+	} else {
+		t.trackRoute(Route{Destination: serverPublicIP, Gateway: t.originalGW, Purpose: RoutePurposeServer})
 	}
 
 	// Delete default route
-	cmd = exec.Command("ip", "route", "del", "default")
+	cmd = execCommand("ip", "route", "del", "default")
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("failed to delete default route: %v", err)
 	}
 
 	// Add default route through VPN
-	cmd = exec.Command("ip", "route", "add", "default", "via", t.gatewayIP, "dev", t.name)
+	cmd = execCommand("ip", "route", "add", "default", "via", t.gatewayIP, "dev", t.name)
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("failed to add VPN route: %v", err)
 	}
+	t.trackRoute(Route{Destination: "default", Gateway: t.gatewayIP, Interface: t.name, Purpose: RoutePurposeDefault})
+
+	// Use the configured resolver so DNS doesn't leak
+	t.applyDNS()
 
 	log.Printf("[tun] All traffic now routed through VPN")
 	return nil
 }
 
-// RestoreRouting restores the original routing table.
-func (t *TUN) RestoreRouting() error {
-	if t.originalGW == "" {
-		return nil
+// RouteSubnets routes only the given CIDRs through the VPN (split tunneling),
+// leaving the default route untouched.
+func (t *TUN) RouteSubnets(serverPublicIP string, subnets []string) error {
+	// Validate everything before touching the routing table
+	nets := make([]*net.IPNet, 0, len(subnets))
+	for _, subnet := range subnets {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(subnet))
+		if err != nil {
+			return fmt.Errorf("invalid subnet %q: %w", subnet, err)
+		}
+		nets = append(nets, ipNet)
 	}
 
-	if runtime.GOOS == "darwin" {
-		// Delete the server-specific route that was added to prevent routing loops
-		if t.serverPublicIP != "" {
-			exec.Command("route", "-n", "delete", "-host", t.serverPublicIP).Run()
-			log.Printf("[tun] Deleted server route: %s", t.serverPublicIP)
+	// If the server itself falls inside a routed subnet, pin it to the
+	// original gateway to prevent a routing loop
+	if ip := net.ParseIP(serverPublicIP); ip != nil {
+		for _, ipNet := range nets {
+			if !ipNet.Contains(ip) {
+				continue
+			}
+			gw, err := GetDefaultGateway()
+			if err != nil {
+				return fmt.Errorf("failed to get default gateway: %w", err)
+			}
+			var cmd *exec.Cmd
+			if runtime.GOOS == "darwin" {
+				cmd = execCommand("route", "-n", "add", "-host", serverPublicIP, gw)
+			} else {
+				cmd = execCommand("ip", "route", "add", serverPublicIP, "via", gw)
+			}
+			if err := cmd.Run(); err != nil {
+				log.Printf("[tun] Warning: failed to add server route: %v", err)
+			} else {
+				t.trackRoute(Route{Destination: serverPublicIP, Gateway: gw, Purpose: RoutePurposeServer})
+			}
+			t.serverPublicIP = serverPublicIP
+			break
 		}
+	}
 
-		exec.Command("route", "-n", "delete", "default").Run()
-		cmd := exec.Command("route", "-n", "add", "-net", "default", t.originalGW)
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("failed to restore default route: %v", err)
+	for _, ipNet := range nets {
+		cidr := ipNet.String()
+		var cmd *exec.Cmd
+		if runtime.GOOS == "darwin" {
+			cmd = execCommand("route", "-n", "add", "-net", cidr, t.gatewayIP)
+		} else {
+			cmd = execCommand("ip", "route", "add", cidr, "via", t.gatewayIP, "dev", t.name)
+		}
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.RemoveSubnetRoutes()
+			return fmt.Errorf("failed to add route for %s: %v - %s", cidr, err, out)
 		}
+		t.routesMu.Lock()
+		t.subnetRoutes = append(t.subnetRoutes, cidr)
+		t.routesMu.Unlock()
+		t.trackRoute(Route{Destination: cidr, Gateway: t.gatewayIP, Interface: t.name, Purpose: RoutePurposeSubnet})
+		log.Printf("[tun] Routing %s through VPN", cidr)
+	}
+
+	return nil
+}
+
+// SubnetRoutes returns the CIDRs currently routed through the VPN.
+func (t *TUN) SubnetRoutes() []string {
+	t.routesMu.Lock()
+	defer t.routesMu.Unlock()
+	return append([]string(nil), t.subnetRoutes...)
+}
 
-		// Restore DNS to DHCP (automatic)
-		cmd = exec.Command("networksetup", "-setdnsservers", "Wi-Fi", "Empty")
+// RemoveSubnetRoutes removes exactly the routes added by RouteSubnets.
+func (t *TUN) RemoveSubnetRoutes() {
+	t.routesMu.Lock()
+	cidrs := t.subnetRoutes
+	t.subnetRoutes = nil
+	t.routesMu.Unlock()
+
+	for _, cidr := range cidrs {
+		var cmd *exec.Cmd
+		if runtime.GOOS == "darwin" {
+			cmd = execCommand("route", "-n", "delete", "-net", cidr, t.gatewayIP)
+		} else {
+			cmd = execCommand("ip", "route", "del", cidr, "via", t.gatewayIP, "dev", t.name)
+		}
 		if err := cmd.Run(); err != nil {
-			log.Printf("[tun] Warning: failed to restore DNS: %v", err)
+			log.Printf("[tun] Warning: failed to remove route for %s: %v", cidr, err)
 		} else {
-			log.Printf("[tun] DNS restored to automatic (DHCP)")
+			log.Printf("[tun] Removed route for %s", cidr)
 		}
+	}
+	t.untrackRoutes(RoutePurposeSubnet)
 
-		// Restore IPv6 if it was enabled before VPN connected
-		if t.ipv6WasEnabled {
-			cmd = exec.Command("networksetup", "-setv6automatic", "Wi-Fi")
-			if err := cmd.Run(); err != nil {
-				log.Printf("[tun] Warning: failed to restore IPv6: %v", err)
-			} else {
-				log.Printf("[tun] IPv6 restored to automatic")
-			}
+	// Server pin route is only ours to remove when route-all isn't active
+	if t.serverPublicIP != "" && t.originalGW == "" {
+		if runtime.GOOS == "darwin" {
+			execCommand("route", "-n", "delete", "-host", t.serverPublicIP).Run()
+		} else {
+			execCommand("ip", "route", "del", t.serverPublicIP).Run()
 		}
+		t.serverPublicIP = ""
+		t.untrackRoutes(RoutePurposeServer)
+	}
+}
+
+// RestoreRouting restores the original routing table.
+func (t *TUN) RestoreRouting() error {
+	if len(t.SubnetRoutes()) > 0 {
+		t.RemoveSubnetRoutes()
+	}
+
+	if t.originalGW == "" {
+		return nil
+	}
+
+	var err error
+	if runtime.GOOS == "darwin" {
+		err = t.restoreRoutingDarwin()
 	} else {
-		// Delete the server-specific route that was added to prevent routing loops
-		if t.serverPublicIP != "" {
-			exec.Command("ip", "route", "del", t.serverPublicIP).Run()
-			log.Printf("[tun] Deleted server route: %s", t.serverPublicIP)
-		}
+		err = t.restoreRoutingLinux()
+	}
+	if err != nil {
+		return err
+	}
+
+	// On failure above the routes stay tracked, so 'vpn routes' shows
+	// which of them are gone from the table
+	t.untrackRoutes(RoutePurposeDefault, RoutePurposeServer)
+	clearPreVPNGateway()
+	log.Printf("[tun] Routing restored to original gateway: %s", t.originalGW)
+	return nil
+}
+
+func (t *TUN) restoreRoutingDarwin() error {
+	// Delete the server-specific route that was added to prevent routing loops
+	if t.serverPublicIP != "" {
+		execCommand("route", "-n", "delete", "-host", t.serverPublicIP).Run()
+		log.Printf("[tun] Deleted server route: %s", t.serverPublicIP)
+	}
+
+	execCommand("route", "-n", "delete", "default").Run()
+	cmd := execCommand("route", "-n", "add", "-net", "default", t.originalGW)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to restore default route: %v", err)
+	}
+
+	// Restore the DNS servers we replaced
+	t.restoreDNS()
 
-		exec.Command("ip", "route", "del", "default", "dev", t.name).Run()
-		cmd := exec.Command("ip", "route", "add", "default", "via", t.originalGW)
+	// Restore IPv6 if it was enabled before VPN connected
+	if t.ipv6WasEnabled {
+		cmd = execCommand("networksetup", "-setv6automatic", "Wi-Fi")
 		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("failed to restore default route: %v", err)
+			log.Printf("[tun] Warning: failed to restore IPv6: %v", err)
+		} else {
+			log.Printf("[tun] IPv6 restored to automatic")
 		}
 	}
+	return nil
+}
+
+func (t *TUN) restoreRoutingLinux() error {
+	// Delete the server-specific route that was added to prevent routing loops
+	if t.serverPublicIP != "" {
+		execCommand("ip", "route", "del", t.serverPublicIP).Run()
+		log.Printf("[tun] Deleted server route: %s", t.serverPublicIP)
+	}
 
-	log.Printf("[tun] Routing restored to original gateway: %s", t.originalGW)
+	execCommand("ip", "route", "del", "default", "dev", t.name).Run()
+	cmd := execCommand("ip", "route", "add", "default", "via", t.originalGW)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to restore default route: %v", err)
+	}
+
+	t.restoreDNS()
 	return nil
 }
 
-// IsValidIPPacket checks if data is a valid IPv4 or IPv6 packet.
+// Header sizes: IPv4 without options, IPv6 fixed header.
+const (
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+)
+
+// IsValidIPPacket checks if data is a valid IPv4 or IPv6 packet: a known
+// version and at least a full header.
 func IsValidIPPacket(data []byte) bool {
 	if len(data) < 1 {
 		return false
 	}
-	version := data[0] >> 4
-	return version == 4 || version == 6
+	switch data[0] >> 4 {
+	case 4:
+		return len(data) >= ipv4HeaderLen
+	case 6:
+		return len(data) >= ipv6HeaderLen
+	}
+	return false
 }
 
-// GetDestinationIP extracts the destination IP from an IP packet.
+// GetDestinationIP extracts the destination IP from an IPv4 or IPv6 packet.
 func GetDestinationIP(packet []byte) net.IP {
-	if len(packet) < 20 {
+	if !IsValidIPPacket(packet) {
 		return nil
 	}
+	if packet[0]>>4 == 6 {
+		// IPv6 destination is at bytes 24-39
+		return net.IP(append([]byte(nil), packet[24:40]...))
+	}
 	// IPv4 destination is at bytes 16-19
 	return net.IPv4(packet[16], packet[17], packet[18], packet[19])
 }
 
-// GetSourceIP extracts the source IP from an IP packet.
+// GetSourceIP extracts the source IP from an IPv4 or IPv6 packet.
 func GetSourceIP(packet []byte) net.IP {
-	if len(packet) < 16 {
+	if !IsValidIPPacket(packet) {
 		return nil
 	}
+	if packet[0]>>4 == 6 {
+		// IPv6 source is at bytes 8-23
+		return net.IP(append([]byte(nil), packet[8:24]...))
+	}
 	// IPv4 source is at bytes 12-15
 	return net.IPv4(packet[12], packet[13], packet[14], packet[15])
 }
@@ -0,0 +1,155 @@
+package tunnel
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeCommands replaces execCommand for the test and records the ip and
+// route commands it is asked to run. Commands whose argv starts with fail
+// exit non-zero; all others succeed.
+func fakeCommands(t *testing.T, fail ...string) *[]string {
+	t.Helper()
+
+	var cmds []string
+	orig := execCommand
+	execCommand = func(name string, args ...string) *exec.Cmd {
+		argv := strings.Join(append([]string{name}, args...), " ")
+		if name == "ip" || name == "route" {
+			cmds = append(cmds, argv)
+		}
+		for _, prefix := range fail {
+			if strings.HasPrefix(argv, prefix) {
+				return exec.Command("false")
+			}
+		}
+		return exec.Command("true")
+	}
+	t.Cleanup(func() { execCommand = orig })
+	return &cmds
+}
+
+func newTestTUN(name string) *TUN {
+	subnet, _ := ParseSubnet(DefaultSubnet)
+	return &TUN{
+		name:       name,
+		localIP:    "10.8.0.2",
+		gatewayIP:  "10.8.0.1",
+		subnet:     subnet,
+		originalGW: "192.168.1.1",
+	}
+}
+
+func TestRouteCommands(t *testing.T) {
+	tests := []struct {
+		platform    string
+		device      string
+		routeAll    func(t *TUN, serverIP string) error
+		restore     func(t *TUN) error
+		wantRoute   []string
+		wantRestore []string
+	}{
+		{
+			platform: "linux",
+			device:   "tun0",
+			routeAll: (*TUN).routeAllTrafficLinux,
+			restore:  (*TUN).restoreRoutingLinux,
+			wantRoute: []string{
+				"ip route add 203.0.113.1 via 192.168.1.1",
+				"ip route del default",
+				"ip route add default via 10.8.0.1 dev tun0",
+			},
+			wantRestore: []string{
+				"ip route del 203.0.113.1",
+				"ip route del default dev tun0",
+				"ip route add default via 192.168.1.1",
+			},
+		},
+		{
+			platform: "darwin",
+			device:   "utun5",
+			routeAll: (*TUN).routeAllTrafficDarwin,
+			restore:  (*TUN).restoreRoutingDarwin,
+			wantRoute: []string{
+				"route -n add -host 203.0.113.1 192.168.1.1",
+				"route -n delete default",
+				"route -n add -net default 10.8.0.1",
+			},
+			wantRestore: []string{
+				"route -n delete -host 203.0.113.1",
+				"route -n delete default",
+				"route -n add -net default 192.168.1.1",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.platform, func(t *testing.T) {
+			cmds := fakeCommands(t)
+			tun := newTestTUN(tt.device)
+
+			if err := tt.routeAll(tun, "203.0.113.1"); err != nil {
+				t.Fatalf("route all: %v", err)
+			}
+			if !reflect.DeepEqual(*cmds, tt.wantRoute) {
+				t.Errorf("route all ran\n  %s\nwant\n  %s", strings.Join(*cmds, "\n  "), strings.Join(tt.wantRoute, "\n  "))
+			}
+			if got := len(tun.Routes()); got != 2 {
+				t.Errorf("tracked %d routes, want 2 (server and default)", got)
+			}
+
+			*cmds = nil
+			if err := tt.restore(tun); err != nil {
+				t.Fatalf("restore: %v", err)
+			}
+			if !reflect.DeepEqual(*cmds, tt.wantRestore) {
+				t.Errorf("restore ran\n  %s\nwant\n  %s", strings.Join(*cmds, "\n  "), strings.Join(tt.wantRestore, "\n  "))
+			}
+		})
+	}
+}
+
+func TestRouteAllTrafficLinuxKeepsDefaultOnFailure(t *testing.T) {
+	cmds := fakeCommands(t, "ip route del default")
+	tun := newTestTUN("tun0")
+
+	if err := tun.routeAllTrafficLinux("203.0.113.1"); err == nil {
+		t.Fatal("expected an error when the default route cannot be deleted")
+	}
+	for _, cmd := range *cmds {
+		if strings.HasPrefix(cmd, "ip route add default") {
+			t.Errorf("added a VPN default route after the delete failed: %s", cmd)
+		}
+	}
+}
+
+// Run with -race: the control socket reads SubnetRoutes while routes change.
+func TestSubnetRoutesConcurrentAccess(t *testing.T) {
+	fakeCommands(t)
+	tun := newTestTUN("tun0")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			if err := tun.RouteSubnets("203.0.113.1", []string{"192.168.50.0/24", "10.20.0.0/16"}); err != nil {
+				t.Errorf("RouteSubnets: %v", err)
+				return
+			}
+			tun.RemoveSubnetRoutes()
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			if got := tun.SubnetRoutes(); len(got) != 0 {
+				t.Errorf("routes left after removal: %v", got)
+			}
+			return
+		default:
+			tun.SubnetRoutes()
+		}
+	}
+}
@@ -0,0 +1,549 @@
+package tunnel
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// UDP transport: the TCP (or TLS) connection still carries the handshake and
+// control messages, while IP packets move to UDP datagrams on the same port
+// number once the server offers a session (Listener.OfferUDP, then
+// Conn.StartUDP on the client). Clients that never ask for it stay on TCP.
+//
+// Datagram: [4 bytes: session ID][8 bytes: counter][AEAD ciphertext]
+//
+// Every session has its own random key, sent over the TCP connection, and
+// uses the connection's cipher (AES-256-GCM or ChaCha20-Poly1305). The nonce
+// is the direction plus the counter, so it never repeats under a key, and
+// the header is authenticated as additional data. A receiver drops
+// datagrams it has already seen or that fall behind its replay window.
+
+// Transports for DialConfig and ListenConfig.
+const (
+	TransportTCP = "tcp" // Everything over the TCP stream (default)
+	TransportUDP = "udp" // IP packets over UDP, control messages over TCP
+)
+
+const (
+	udpHeaderSize   = 12
+	udpReplayWindow = 64               // Counters behind the newest one still accepted
+	udpQueueSize    = 256              // Datagrams waiting for ReadPacket before new ones are dropped
+	udpKeepalive    = 25 * time.Second // Client keepalive, refreshes NAT mappings
+	udpSocketBuffer = 1024 * 1024      // Same as the tuned TCP buffers
+	udpMaxDatagram  = 64 * 1024
+)
+
+// Nonce prefixes, one per direction of a session.
+const (
+	udpClientToServer uint32 = 0
+	udpServerToClient uint32 = 1
+)
+
+// validTransport reports whether t is a known transport ("" means TCP).
+func validTransport(t string) bool {
+	return t == "" || t == TransportTCP || t == TransportUDP
+}
+
+// udpSession is one client's datagram channel.
+type udpSession struct {
+	id      uint32
+	aead    cipher.AEAD
+	sendDir uint32
+	recvDir uint32
+	counter atomic.Uint64 // Last counter sent
+
+	sock   *net.UDPConn // Connected on the client, the shared listener socket on the server
+	dialed bool
+	peer   atomic.Pointer[net.UDPAddr] // Server: the client's address, from its latest datagram
+
+	owner  *Conn
+	replay replayWindow // Receiving goroutine only
+	in     chan []byte
+
+	writeFailed atomic.Bool // A failed write was logged (later ones fall back silently)
+	release     func()      // Server: unregister from the listener
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+func newUDPSession(id uint32, key []byte, client bool, cipherName string) (*udpSession, error) {
+	aead, err := newAEAD(cipherName, key)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &udpSession{
+		id:      id,
+		aead:    aead,
+		sendDir: udpServerToClient,
+		recvDir: udpClientToServer,
+		in:      make(chan []byte, udpQueueSize),
+		done:    make(chan struct{}),
+	}
+	if client {
+		s.sendDir, s.recvDir = udpClientToServer, udpServerToClient
+	}
+	return s, nil
+}
+
+func udpNonce(dir uint32, counter uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint32(nonce[0:4], dir)
+	binary.BigEndian.PutUint64(nonce[4:12], counter)
+	return nonce
+}
+
+// seal encrypts payload into a datagram with the next counter.
+func (s *udpSession) seal(payload []byte) []byte {
+	counter := s.counter.Add(1)
+
+	header := make([]byte, udpHeaderSize)
+	binary.BigEndian.PutUint32(header[0:4], s.id)
+	binary.BigEndian.PutUint64(header[4:12], counter)
+
+	out := make([]byte, 0, udpHeaderSize+len(payload)+s.aead.Overhead())
+	out = append(out, header...)
+	return s.aead.Seal(out, udpNonce(s.sendDir, counter), payload, header)
+}
+
+// open authenticates and decrypts a datagram, rejecting replays, and
+// records it in the owner's statistics.
+func (s *udpSession) open(datagram []byte) ([]byte, error) {
+	if len(datagram) < udpHeaderSize+s.aead.Overhead() {
+		return nil, fmt.Errorf("datagram too short")
+	}
+
+	counter := binary.BigEndian.Uint64(datagram[4:12])
+	if !s.replay.fresh(counter) {
+		return nil, fmt.Errorf("replayed or stale datagram %d", counter)
+	}
+
+	payload, err := s.aead.Open(nil, udpNonce(s.recvDir, counter), datagram[udpHeaderSize:], datagram[:udpHeaderSize])
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed: %w", err)
+	}
+
+	skipped := s.replay.record(counter)
+	s.owner.recordDatagram(len(datagram), skipped)
+	return payload, nil
+}
+
+// deliver queues a payload for ReadPacket, dropping it if the queue is full.
+// Empty payloads are keepalives.
+func (s *udpSession) deliver(payload []byte) {
+	if len(payload) == 0 {
+		return
+	}
+	select {
+	case s.in <- payload:
+	default:
+	}
+}
+
+// ready reports whether datagrams can be sent: always on the client, once
+// the client's first datagram arrived on the server.
+func (s *udpSession) ready() bool {
+	return s.dialed || s.peer.Load() != nil
+}
+
+func (s *udpSession) write(datagram []byte) error {
+	if s.dialed {
+		_, err := s.sock.Write(datagram)
+		return err
+	}
+	_, err := s.sock.WriteToUDP(datagram, s.peer.Load())
+	return err
+}
+
+func (s *udpSession) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		if s.release != nil {
+			s.release()
+		}
+		if s.dialed {
+			s.sock.Close()
+		}
+	})
+}
+
+// readLoop receives datagrams on a client's connected socket.
+func (s *udpSession) readLoop() {
+	buf := make([]byte, udpMaxDatagram)
+	for {
+		n, err := s.sock.Read(buf)
+		if err != nil {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// ICMP errors (e.g. port unreachable) surface here; keep reading
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		if n < udpHeaderSize || binary.BigEndian.Uint32(buf[0:4]) != s.id {
+			continue
+		}
+		if payload, err := s.open(buf[:n]); err == nil {
+			s.deliver(payload)
+		}
+	}
+}
+
+// keepaliveLoop sends an empty datagram periodically so NAT mappings
+// outlive quiet periods (client).
+func (s *udpSession) keepaliveLoop() {
+	ticker := time.NewTicker(udpKeepalive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.write(s.seal(nil))
+		}
+	}
+}
+
+// replayWindow tracks the newest counter received and which of the
+// udpReplayWindow counters before it have been seen.
+type replayWindow struct {
+	top  uint64
+	seen uint64 // Bit i: counter top-i was received
+}
+
+// fresh reports whether counter is new and not too old to be checked.
+func (w *replayWindow) fresh(counter uint64) bool {
+	if counter == 0 {
+		return false
+	}
+	if counter > w.top {
+		return true
+	}
+	diff := w.top - counter
+	return diff < udpReplayWindow && w.seen&(1<<diff) == 0
+}
+
+// record marks an authenticated counter as received. It returns the change
+// in skipped counters: the gap for a counter past the newest, -1 for a late
+// one that fills a gap.
+func (w *replayWindow) record(counter uint64) int64 {
+	if counter > w.top {
+		gap := counter - w.top
+		if gap >= udpReplayWindow {
+			w.seen = 1
+		} else {
+			w.seen = w.seen<<gap | 1
+		}
+		w.top = counter
+		return int64(gap - 1)
+	}
+	w.seen |= 1 << (w.top - counter)
+	return -1
+}
+
+// udpMux demultiplexes the listener's UDP socket to sessions (server).
+type udpMux struct {
+	sock *net.UDPConn
+	port int
+
+	mu       sync.Mutex
+	sessions map[uint32]*udpSession
+}
+
+func (m *udpMux) readLoop() {
+	buf := make([]byte, udpMaxDatagram)
+	for {
+		n, addr, err := m.sock.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		if n < udpHeaderSize {
+			continue
+		}
+
+		m.mu.Lock()
+		s := m.sessions[binary.BigEndian.Uint32(buf[0:4])]
+		m.mu.Unlock()
+		if s == nil {
+			continue
+		}
+
+		payload, err := s.open(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		// Authenticated, so follow the client if its address changes (NAT rebinding)
+		if prev := s.peer.Load(); prev == nil || !prev.IP.Equal(addr.IP) || prev.Port != addr.Port {
+			s.peer.Store(addr)
+			if prev == nil {
+				log.Printf("[conn] UDP session %08x from %s", s.id, addr)
+			} else {
+				log.Printf("[conn] UDP session %08x moved from %s to %s", s.id, prev, addr)
+			}
+		}
+		s.deliver(payload)
+	}
+}
+
+// DialUDP connects like Dial and offers the UDP transport. The handshake and
+// control messages still use the TCP connection; IP packets move to UDP once
+// the server answers with a session (see Conn.StartUDP).
+func DialUDP(cfg DialConfig) (*Conn, error) {
+	cfg.Transport = TransportUDP
+	return Dial(cfg)
+}
+
+// ListenUDP listens like Listen and also on UDP at the same port, so clients
+// that ask for it can send IP packets as datagrams (see Listener.OfferUDP).
+// TCP-only clients connect as before.
+func ListenUDP(cfg ListenConfig) (*Listener, error) {
+	cfg.Transport = TransportUDP
+	return Listen(cfg)
+}
+
+// listenUDPMux opens the UDP socket next to a TCP listener.
+func listenUDPMux(tcpAddr net.Addr) (*udpMux, error) {
+	host, port, err := net.SplitHostPort(tcpAddr.String())
+	if err != nil {
+		return nil, err
+	}
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, err
+	}
+	sock, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	sock.SetReadBuffer(udpSocketBuffer)
+	sock.SetWriteBuffer(udpSocketBuffer)
+
+	m := &udpMux{
+		sock:     sock,
+		port:     sock.LocalAddr().(*net.UDPAddr).Port,
+		sessions: make(map[uint32]*udpSession),
+	}
+	go m.readLoop()
+	return m, nil
+}
+
+// Transport returns TransportUDP if the listener also accepts datagrams.
+func (l *Listener) Transport() string {
+	if l.udp != nil {
+		return TransportUDP
+	}
+	return TransportTCP
+}
+
+// OfferUDP creates a UDP session for an accepted connection and returns what
+// the client needs to join it: the UDP port, the session ID and its key.
+// Packets to the client stay on TCP until its first datagram arrives. Call it
+// before reading from conn, or from the goroutine that reads it.
+func (l *Listener) OfferUDP(conn *Conn) (port int, session uint32, key []byte, err error) {
+	if l.udp == nil {
+		return 0, 0, nil, fmt.Errorf("listener has no UDP transport")
+	}
+
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return 0, 0, nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	m := l.udp
+	m.mu.Lock()
+	for session == 0 || m.sessions[session] != nil {
+		var id [4]byte
+		if _, err := rand.Read(id[:]); err != nil {
+			m.mu.Unlock()
+			return 0, 0, nil, fmt.Errorf("failed to generate session ID: %w", err)
+		}
+		session = binary.BigEndian.Uint32(id[:])
+	}
+
+	s, err := newUDPSession(session, key, false, conn.udpCipher())
+	if err != nil {
+		m.mu.Unlock()
+		return 0, 0, nil, err
+	}
+	s.sock = m.sock
+	s.owner = conn
+	s.release = func() {
+		m.mu.Lock()
+		delete(m.sessions, session)
+		m.mu.Unlock()
+	}
+	m.sessions[session] = s
+	m.mu.Unlock()
+
+	conn.enableUDP(s)
+	return m.port, session, key, nil
+}
+
+// udpCipher returns the cipher for a UDP session of c: the one the handshake
+// agreed on, AES-256-GCM without encryption (datagrams are always sealed).
+func (c *Conn) udpCipher() string {
+	if c.cipher == nil {
+		return CipherAESGCM
+	}
+	return c.cipher.Name()
+}
+
+// StartUDP joins the UDP session offered by the server and sends IP packets
+// as datagrams from now on. Control messages keep using TCP. Call it from
+// the goroutine that reads the connection.
+func (c *Conn) StartUDP(port int, session uint32, key []byte) error {
+	if c.udp.Load() != nil {
+		return fmt.Errorf("UDP transport already started")
+	}
+
+	host, _, err := net.SplitHostPort(c.NetConn.RemoteAddr().String())
+	if err != nil {
+		return err
+	}
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+
+	s, err := newUDPSession(session, key, true, c.udpCipher())
+	if err != nil {
+		return err
+	}
+	sock, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return fmt.Errorf("UDP dial failed: %w", err)
+	}
+	sock.SetReadBuffer(udpSocketBuffer)
+	sock.SetWriteBuffer(udpSocketBuffer)
+	s.sock = sock
+	s.dialed = true
+	s.owner = c
+
+	// Announce ourselves so the server learns our (NATed) address
+	if err := s.write(s.seal(nil)); err != nil {
+		sock.Close()
+		return fmt.Errorf("UDP hello failed: %w", err)
+	}
+
+	c.enableUDP(s)
+	go s.readLoop()
+	go s.keepaliveLoop()
+
+	log.Printf("[conn] UDP transport to %s (session %08x)", addr, session)
+	return nil
+}
+
+// frameResult is a TCP frame read by tcpReadLoop.
+type frameResult struct {
+	packet []byte
+	err    error
+}
+
+// enableUDP switches ReadPacket to wait for TCP frames and datagrams alike.
+func (c *Conn) enableUDP(s *udpSession) {
+	c.tcpWant = make(chan struct{}, 1)
+	c.tcpIn = make(chan frameResult, 1)
+	go c.tcpReadLoop(s)
+
+	c.udp.Store(s)
+	if c.closed.Load() {
+		s.close()
+	}
+}
+
+// tcpReadLoop reads one TCP frame per ReadPacket request. Reading only on
+// request keeps ExpectSequence working: the frame after a SEQUENCE message is
+// not read before the caller has switched to numbered frames. Errors are
+// returned like without UDP, one per request, until the session closes.
+func (c *Conn) tcpReadLoop(s *udpSession) {
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-c.tcpWant:
+		}
+
+		packet, err := c.readFrame()
+		c.tcpIn <- frameResult{packet, err}
+	}
+}
+
+// readUDP returns the next TCP frame or datagram, whichever comes first.
+func (c *Conn) readUDP(s *udpSession) ([]byte, error) {
+	if !c.tcpPending {
+		c.tcpWant <- struct{}{}
+		c.tcpPending = true
+	}
+
+	select {
+	case r := <-c.tcpIn:
+		c.tcpPending = false
+		return r.packet, r.err
+	case packet := <-s.in:
+		return c.decompress(packet)
+	}
+}
+
+// writeDatagram sends an (already compressed) IP packet over UDP.
+func (c *Conn) writeDatagram(s *udpSession, data []byte) error {
+	datagram := s.seal(data)
+	if err := s.write(datagram); err != nil {
+		if !s.writeFailed.Swap(true) {
+			log.Printf("[conn] Warning: UDP write to %s failed, falling back to TCP: %v", c.remoteAddr, err)
+		}
+		return err
+	}
+
+	c.mu.Lock()
+	c.bytesSent += uint64(len(datagram))
+	c.packetsSent++
+	c.mu.Unlock()
+	return nil
+}
+
+// recordDatagram counts a received datagram; skipped is the change in
+// missing counters reported by the replay window.
+func (c *Conn) recordDatagram(size int, skipped int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.bytesRecv += uint64(size)
+	c.packetsRecv++
+	c.seqReceived++
+	if skipped > 0 {
+		c.seqLost += uint64(skipped)
+	} else if skipped < 0 && c.seqLost > 0 {
+		c.seqLost--
+	}
+}
+
+// UDPCapable reports whether this end asked for the UDP transport.
+func (c *Conn) UDPCapable() bool {
+	return c.udpCapable
+}
+
+// Transport returns TransportUDP once IP packets use a UDP session.
+func (c *Conn) Transport() string {
+	if c.udp.Load() != nil {
+		return TransportUDP
+	}
+	return TransportTCP
+}
@@ -0,0 +1,47 @@
+package ui
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// readEnvFile parses a .env file of KEY=VALUE lines. Blank lines and lines
+// starting with # are skipped, an "export " prefix is allowed, whitespace
+// around keys and values is trimmed and matching quotes around a value are
+// removed. Unquoted values may end in a " # comment".
+func readEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	env := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if key == "" {
+			continue
+		}
+
+		if n := len(value); n >= 2 && (value[0] == '"' || value[0] == '\'') && value[n-1] == value[0] {
+			value = value[1 : n-1]
+		} else if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+		env[key] = value
+	}
+	return env, scanner.Err()
+}
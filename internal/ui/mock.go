@@ -0,0 +1,539 @@
+package ui
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miguelemosreverte/vpn/internal/diagnose"
+	"github.com/miguelemosreverte/vpn/internal/protocol"
+)
+
+// Demo mode (vpn ui --mock-peers): a synthetic server node and its peers,
+// with bandwidth that follows smooth sine waves so the charts look alive.
+
+const (
+	mockNodeName   = "demo-server"
+	mockServerIP   = "10.8.0.1"
+	mockVersion    = "demo"
+	mockPacketSize = 900 // Average bytes per packet for the packet counters
+
+	// Bandwidth of the whole node: base rate, swing, and period of the wave
+	mockBaseBps   = 200 * 1024
+	mockSwing     = 0.6
+	mockTxPeriod  = 2 * time.Minute
+	mockRxPeriod  = 3 * time.Minute
+	mockMaxPoints = 300 // Points per series returned by Stats
+)
+
+var mockHostnames = []string{
+	"alice-macbook", "bob-desktop", "carol-laptop", "dave-nas", "erin-pi",
+	"frank-imac", "grace-thinkpad", "heidi-mini", "ivan-server", "judy-air",
+}
+
+var mockOSes = []string{"darwin", "linux", "windows"}
+
+var mockCities = []protocol.GeoLocation{
+	{City: "Buenos Aires", Country: "Argentina", Latitude: -34.6037, Longitude: -58.3816},
+	{City: "Madrid", Country: "Spain", Latitude: 40.4168, Longitude: -3.7038},
+	{City: "New York", Country: "United States", Latitude: 40.7128, Longitude: -74.0060},
+	{City: "Berlin", Country: "Germany", Latitude: 52.5200, Longitude: 13.4050},
+	{City: "Tokyo", Country: "Japan", Latitude: 35.6762, Longitude: 139.6503},
+	{City: "Sydney", Country: "Australia", Latitude: -33.8688, Longitude: 151.2093},
+	{City: "São Paulo", Country: "Brazil", Latitude: -23.5505, Longitude: -46.6333},
+	{City: "London", Country: "United Kingdom", Latitude: 51.5074, Longitude: -0.1278},
+}
+
+// mockDestination is a remote host demo traffic goes to.
+type mockDestination struct {
+	ip    string
+	share float64 // Fraction of the node's bandwidth
+}
+
+var mockDestinations = []mockDestination{
+	{"142.250.184.78", 0.31}, {"151.101.1.140", 0.18}, {"104.16.132.229", 0.12},
+	{"52.94.236.248", 0.09}, {"31.13.71.36", 0.07}, {"17.253.144.10", 0.05},
+	{"140.82.121.4", 0.04}, {"185.199.108.153", 0.03}, {"other", 0.11},
+}
+
+var mockHelsinki = protocol.GeoLocation{
+	City: "Helsinki", Country: "Finland", Latitude: 60.1699, Longitude: 24.9384, ISP: "Hetzner Online GmbH",
+}
+
+// mockPeer is one synthetic peer.
+type mockPeer struct {
+	name      string
+	vpnIP     string
+	publicIP  string
+	os        string
+	geo       protocol.GeoLocation
+	latencyMs float64
+	share     float64 // Fraction of the node's bandwidth
+	connected time.Time
+}
+
+// mockNetwork is the synthetic network shared by all dashboard requests.
+type mockNetwork struct {
+	started time.Time
+	phase   float64 // Offsets the waves so each run looks different
+	peers   []mockPeer
+
+	mu    sync.Mutex
+	prefs map[string]string
+}
+
+func newMockNetwork(n int) *mockNetwork {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	now := time.Now()
+
+	m := &mockNetwork{
+		started: now.Add(-time.Duration(24+rng.Intn(48)) * time.Hour),
+		phase:   rng.Float64() * 2 * math.Pi,
+		prefs:   make(map[string]string),
+	}
+
+	var total float64
+	for i := 0; i < n; i++ {
+		name := mockHostnames[i%len(mockHostnames)]
+		if i >= len(mockHostnames) {
+			name = fmt.Sprintf("%s-%d", name, i/len(mockHostnames)+1)
+		}
+		geo := mockCities[rng.Intn(len(mockCities))]
+		p := mockPeer{
+			name:      name,
+			vpnIP:     fmt.Sprintf("10.8.0.%d", i+2),
+			publicIP:  fmt.Sprintf("%d.%d.%d.%d", 20+rng.Intn(180), rng.Intn(256), rng.Intn(256), 1+rng.Intn(254)),
+			os:        mockOSes[rng.Intn(len(mockOSes))],
+			geo:       geo,
+			latencyMs: 15 + rng.Float64()*200,
+			share:     0.2 + rng.Float64(),
+			connected: now.Add(-time.Duration(1+rng.Intn(20)) * time.Hour),
+		}
+		total += p.share
+		m.peers = append(m.peers, p)
+	}
+	for i := range m.peers {
+		m.peers[i].share /= total
+	}
+	return m
+}
+
+// client returns a client for one request; Close ends its watches.
+func (m *mockNetwork) client() *mockClient {
+	return &mockClient{net: m, done: make(chan struct{})}
+}
+
+// wave is 1 ± mockSwing, varying smoothly with period.
+func (m *mockNetwork) wave(t time.Time, period time.Duration) float64 {
+	w := 2 * math.Pi / period.Seconds()
+	return 1 + mockSwing*math.Sin(w*float64(t.Unix())+m.phase)
+}
+
+// bytes is the integral of the wave from started to t: total bytes moved.
+func (m *mockNetwork) bytes(t time.Time, period time.Duration) float64 {
+	w := 2 * math.Pi / period.Seconds()
+	integral := func(t time.Time) float64 {
+		s := float64(t.Unix())
+		return mockBaseBps * (s - mockSwing/w*math.Cos(w*s+m.phase))
+	}
+	return integral(t) - integral(m.started)
+}
+
+// metrics returns every metric the collector would record at t.
+func (m *mockNetwork) metrics(t time.Time) map[string]float64 {
+	sent := m.bytes(t, mockTxPeriod)
+	recv := m.bytes(t, mockRxPeriod)
+	return map[string]float64{
+		"bandwidth.tx_current_bps": mockBaseBps * m.wave(t, mockTxPeriod),
+		"bandwidth.rx_current_bps": mockBaseBps * m.wave(t, mockRxPeriod),
+		"bandwidth.tx_p95_bps":     mockBaseBps * (1 + 0.95*mockSwing),
+		"bandwidth.rx_p95_bps":     mockBaseBps * (1 + 0.95*mockSwing),
+		"vpn.bytes_sent":           sent,
+		"vpn.bytes_recv":           recv,
+		"vpn.packets_sent":         math.Floor(sent / mockPacketSize),
+		"vpn.packets_recv":         math.Floor(recv / mockPacketSize),
+		"vpn.active_peers":         float64(len(m.peers)),
+	}
+}
+
+func (m *mockNetwork) topology() *protocol.TopologyResult {
+	now := time.Now()
+	metrics := m.metrics(now)
+	geo := mockHelsinki
+
+	us := &protocol.NetworkNode{
+		Name:        mockNodeName,
+		VPNAddress:  mockServerIP,
+		PublicAddr:  "95.217.238.72",
+		OS:          "linux",
+		Version:     mockVersion,
+		IsUs:        true,
+		IsDirect:    true,
+		ConnectedAt: m.started,
+		LastSeen:    now,
+		BytesIn:     uint64(metrics["vpn.bytes_recv"]),
+		BytesOut:    uint64(metrics["vpn.bytes_sent"]),
+		Geo:         &geo,
+	}
+	result := &protocol.TopologyResult{Nodes: []*protocol.NetworkNode{us}}
+
+	for _, p := range m.peers {
+		geo := p.geo
+		// Latency drifts a little with the wave so the table updates
+		latency := math.Round(p.latencyMs*(0.9+0.1*m.wave(now, mockRxPeriod))*10) / 10
+		bandwidth := p.share * metrics["bandwidth.tx_current_bps"]
+		us.Connections = append(us.Connections, p.vpnIP)
+		result.Nodes = append(result.Nodes, &protocol.NetworkNode{
+			Name:        p.name,
+			VPNAddress:  p.vpnIP,
+			PublicAddr:  p.publicIP,
+			OS:          p.os,
+			Version:     mockVersion,
+			Distance:    1,
+			LatencyMs:   latency,
+			Bandwidth:   bandwidth,
+			IsDirect:    true,
+			ConnectedAt: p.connected,
+			LastSeen:    now,
+			BytesIn:     uint64(p.share * metrics["vpn.bytes_sent"]),
+			BytesOut:    uint64(p.share * metrics["vpn.bytes_recv"]),
+			Connections: []string{mockServerIP},
+			Geo:         &geo,
+		})
+		result.Edges = append(result.Edges, &protocol.NetworkEdge{
+			From:      mockServerIP,
+			To:        p.vpnIP,
+			LatencyMs: latency,
+			Bandwidth: bandwidth,
+			Direct:    true,
+		})
+	}
+	return result
+}
+
+// diagnostics is a 'vpn diagnose' report where every local check passes.
+// Peers route directly (their public IPs are random) and the last one has
+// SSH off, so the Health panel shows warnings too.
+func (m *mockNetwork) diagnostics() *diagnose.Report {
+	report := &diagnose.Report{
+		Timestamp:   time.Now().Format(time.RFC3339),
+		NodeAddress: "demo",
+		Peers:       []diagnose.Peer{},
+	}
+	report.LocalNode.Name = mockNodeName
+	report.LocalNode.Version = mockVersion
+	report.LocalNode.VPNAddress = mockServerIP
+	report.LocalNode.Subnet = "10.8.0.0/24"
+	report.LocalNode.Checks = []diagnose.Check{
+		{Name: "Local VPN Node", Status: "pass", Message: fmt.Sprintf("%s (demo) - VPN IP: %s", mockNodeName, mockServerIP)},
+		{Name: "VPN Server", Status: "pass", Message: fmt.Sprintf("Server %s reachable", mockServerIP), Details: "Latency: 0.1 ms"},
+		{Name: "Traffic Routing", Status: "pass", Message: "Traffic routed through VPN"},
+		{Name: "DNS Resolution", Status: "pass", Message: "DNS working", Details: "Resolution time: 12ms"},
+		{Name: "VPN Interface", Status: "pass", Message: "Interface tun0 is UP", Details: "IP: 10.8.0.1 (subnet 10.8.0.0/24)"},
+		{Name: "Internet Connectivity", Status: "pass", Message: "Internet reachable", Details: "Response time: 85ms"},
+		{Name: "SSH Access", Status: "pass", Message: "SSH enabled", Details: "Remote Login (SSH) is available"},
+	}
+	report.Summary.Passed = len(report.LocalNode.Checks)
+
+	for i, p := range m.peers {
+		peer := diagnose.Peer{
+			Name:          p.name,
+			VPNAddress:    p.vpnIP,
+			Version:       mockVersion,
+			OS:            p.os,
+			Reachable:     true,
+			VersionMatch:  true,
+			PublicIP:      p.publicIP,
+			SSHAccessible: i != len(m.peers)-1,
+		}
+		peer.RoutingWarning = fmt.Sprintf("Not routing through VPN (IP: %s)", p.publicIP)
+		report.Summary.Passed++
+		report.Summary.Warned++ // Direct routing
+		if !peer.SSHAccessible {
+			peer.SSHWarning = "SSH port 22 not accessible"
+			report.Summary.Warned++
+		}
+		report.Peers = append(report.Peers, peer)
+	}
+	return report
+}
+
+// mockSpan turns a relative time like "-15m" or "-7d" into a duration,
+// defaulting to 5 minutes.
+func mockSpan(earliest string) time.Duration {
+	s := strings.TrimPrefix(earliest, "-")
+	if strings.HasSuffix(s, "d") {
+		var days int
+		if _, err := fmt.Sscanf(s, "%dd", &days); err == nil && days > 0 {
+			return time.Duration(days) * 24 * time.Hour
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return d
+	}
+	return 5 * time.Minute
+}
+
+// mockClient serves the synthetic network through the nodeClient interface.
+type mockClient struct {
+	net  *mockNetwork
+	done chan struct{}
+	once sync.Once
+}
+
+func (c *mockClient) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return nil
+}
+
+func (c *mockClient) Status() (*protocol.StatusResult, error) {
+	metrics := c.net.metrics(time.Now())
+	uptime := time.Since(c.net.started)
+	return &protocol.StatusResult{
+		NodeName:   mockNodeName,
+		Version:    mockVersion,
+		Uptime:     uptime,
+		UptimeStr:  fmt.Sprintf("%dd %dh %dm", int(uptime.Hours())/24, int(uptime.Hours())%24, int(uptime.Minutes())%60),
+		VPNAddress: mockServerIP,
+		PeerCount:  len(c.net.peers),
+		BytesIn:    uint64(metrics["vpn.bytes_recv"]),
+		BytesOut:   uint64(metrics["vpn.bytes_sent"]),
+		ServerMode: true,
+		Subnet:     "10.8.0.0/24",
+		ServerIP:   mockServerIP,
+	}, nil
+}
+
+func (c *mockClient) Peers() (*protocol.PeersResult, error) {
+	result := &protocol.PeersResult{}
+	for _, n := range c.net.topology().Nodes {
+		if n.IsUs {
+			continue
+		}
+		result.Peers = append(result.Peers, protocol.PeerInfo{
+			Hostname:   n.Name,
+			Name:       n.Name,
+			VPNAddress: n.VPNAddress,
+			PublicIP:   n.PublicAddr,
+			OS:         n.OS,
+			Version:    n.Version,
+			Connected:  n.ConnectedAt,
+			BytesIn:    n.BytesIn,
+			BytesOut:   n.BytesOut,
+			Latency:    fmt.Sprintf("%.1fms", n.LatencyMs),
+			Bandwidth:  n.Bandwidth,
+			Geo:        n.Geo,
+			RouteAll:   true,
+		})
+	}
+	return result, nil
+}
+
+func (c *mockClient) Logs(params protocol.LogsParams) (*protocol.LogsResult, error) {
+	return &protocol.LogsResult{Entries: []protocol.LogEntry{}}, nil
+}
+
+func (c *mockClient) RemoteLogs(addr string, params protocol.LogsParams) (*protocol.LogsResult, error) {
+	return c.Logs(params)
+}
+
+// Stats returns up to mockMaxPoints points per metric over the requested
+// range, evenly spaced.
+func (c *mockClient) Stats(params protocol.StatsParams) (*protocol.StatsResult, error) {
+	wanted := make(map[string]bool)
+	for _, m := range params.Metrics {
+		for _, name := range strings.Split(m, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				wanted[name] = true
+			}
+		}
+	}
+
+	now := time.Now().Truncate(time.Second)
+	span := mockSpan(params.Earliest)
+	step := (span / mockMaxPoints).Truncate(time.Second)
+	if step < time.Second {
+		step = time.Second
+	}
+
+	points := make(map[string][]protocol.MetricPoint)
+	for t := now.Add(-span); !t.After(now); t = t.Add(step) {
+		if t.Before(c.net.started) {
+			continue
+		}
+		for name, v := range c.net.metrics(t) {
+			if len(wanted) > 0 && !wanted[name] {
+				continue
+			}
+			points[name] = append(points[name], protocol.MetricPoint{
+				Timestamp:   t.Format(time.RFC3339),
+				Name:        name,
+				Value:       v,
+				Granularity: "raw",
+			})
+		}
+	}
+
+	result := &protocol.StatsResult{Summary: make(map[string]float64)}
+	for name, v := range c.net.metrics(now) {
+		if len(wanted) > 0 && !wanted[name] {
+			continue
+		}
+		result.Series = append(result.Series, protocol.MetricSeries{Name: name, Points: points[name]})
+		result.Summary[name] = v
+	}
+	return result, nil
+}
+
+func (c *mockClient) Connect() (*protocol.ConnectionResult, error) {
+	return &protocol.ConnectionResult{Success: false, Message: "not available in demo mode"}, nil
+}
+
+func (c *mockClient) Disconnect() (*protocol.ConnectionResult, error) {
+	return c.Connect()
+}
+
+func (c *mockClient) ConnectionStatus() (*protocol.ConnectionStatus, error) {
+	return &protocol.ConnectionStatus{
+		Connected:   true,
+		VPNAddress:  mockServerIP,
+		RouteAll:    true,
+		ConnectedAt: c.net.started.Format(time.RFC3339),
+	}, nil
+}
+
+func (c *mockClient) Topology() (*protocol.TopologyResult, error) {
+	return c.net.topology(), nil
+}
+
+// WatchTopology sends the topology now and every 5 seconds (latencies and
+// bandwidth drift) until the client is closed.
+func (c *mockClient) WatchTopology(fn func(*protocol.TopologyResult) error) error {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		if err := fn(c.net.topology()); err != nil {
+			return err
+		}
+		select {
+		case <-c.done:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// WatchStats sends one batch of points per second, like the collector.
+func (c *mockClient) WatchStats(params protocol.StatsWatchParams, fn func(*protocol.StatsWatchResult) error) error {
+	wanted := make(map[string]bool, len(params.Metrics))
+	for _, name := range params.Metrics {
+		wanted[name] = true
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return nil
+		case t := <-ticker.C:
+			batch := &protocol.StatsWatchResult{}
+			for name, v := range c.net.metrics(t) {
+				if len(wanted) > 0 && !wanted[name] {
+					continue
+				}
+				batch.Points = append(batch.Points, protocol.MetricPoint{
+					Timestamp:   t.Format(time.RFC3339Nano),
+					Name:        name,
+					Value:       v,
+					Granularity: "raw",
+				})
+			}
+			if err := fn(batch); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (c *mockClient) NetworkPeers() (*protocol.NetworkPeersResult, error) {
+	result := &protocol.NetworkPeersResult{ServerMode: true}
+	for _, p := range c.net.peers {
+		geo := p.geo
+		result.Peers = append(result.Peers, protocol.PeerListEntry{
+			Name:       p.name,
+			VPNAddress: p.vpnIP,
+			Hostname:   p.name,
+			OS:         p.os,
+			PublicIP:   p.publicIP,
+			Geo:        &geo,
+		})
+	}
+	return result, nil
+}
+
+// Traffic splits the node's average bandwidth over the requested range
+// between mockDestinations, mostly received.
+func (c *mockClient) Traffic(params protocol.TrafficParams) (*protocol.TrafficResult, error) {
+	limit := params.Limit
+	if limit <= 0 {
+		limit = 10
+	}
+	now := time.Now()
+	span := mockSpan(params.Earliest)
+	if since := now.Sub(c.net.started); since < span {
+		span = since
+	}
+	total := mockBaseBps * span.Seconds()
+
+	result := &protocol.TrafficResult{
+		Destinations: []protocol.TrafficDest{},
+		Start:        now.Add(-span).Format(time.RFC3339),
+		End:          now.Format(time.RFC3339),
+	}
+	for _, d := range mockDestinations {
+		if len(result.Destinations) == limit {
+			break
+		}
+		bytes := uint64(total * d.share)
+		result.Destinations = append(result.Destinations, protocol.TrafficDest{
+			Dest:     d.ip,
+			Bytes:    bytes,
+			BytesOut: bytes / 5,
+			BytesIn:  bytes - bytes/5,
+		})
+	}
+	return result, nil
+}
+
+func (c *mockClient) HandshakeHistory(params protocol.HandshakeHistoryParams) (*protocol.HandshakeHistoryResult, error) {
+	return &protocol.HandshakeHistoryResult{Entries: []protocol.HandshakeEntry{}}, nil
+}
+
+// Preferences are kept in memory for the life of the demo.
+func (c *mockClient) Preferences() (*protocol.PreferencesResult, error) {
+	c.net.mu.Lock()
+	defer c.net.mu.Unlock()
+
+	prefs := make(map[string]string, len(c.net.prefs))
+	for k, v := range c.net.prefs {
+		prefs[k] = v
+	}
+	return &protocol.PreferencesResult{Preferences: prefs}, nil
+}
+
+func (c *mockClient) SetPreferences(prefs map[string]string) (*protocol.PreferencesResult, error) {
+	c.net.mu.Lock()
+	for k, v := range prefs {
+		if v == "" {
+			delete(c.net.prefs, k)
+		} else {
+			c.net.prefs[k] = v
+		}
+	}
+	c.net.mu.Unlock()
+	return c.Preferences()
+}
@@ -2,18 +2,22 @@
 package ui
 
 import (
+	"crypto/subtle"
 	"embed"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/fs"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/miguelemosreverte/vpn/internal/cli"
+	"github.com/miguelemosreverte/vpn/internal/diagnose"
 	"github.com/miguelemosreverte/vpn/internal/protocol"
 )
 
@@ -27,9 +31,58 @@ var templateFiles embed.FS
 type Server struct {
 	nodeAddr     string
 	listenAddr   string
+	controlToken string // Sent to the node's control socket
 	client       *cli.Client
 	quiet        bool   // suppress startup banner
 	templatesDir string // directory containing template files for hot reload
+
+	// HTTP Basic Auth credentials (empty user = no auth)
+	authUser string
+	authPass string
+
+	// TLS certificate and key (empty = plain HTTP)
+	tlsCert string
+	tlsKey  string
+
+	// TOTP login page in front of the dashboard (nil = none)
+	totp *totpAuth
+
+	// How often the dashboard polls the node (0 = DefaultRefreshInterval);
+	// the connection status is checked half as often
+	refreshInterval time.Duration
+
+	// Synthetic network served instead of a node (nil = use nodeAddr)
+	mock *mockNetwork
+
+	// Screen sharing password: vncPassword overrides VNC_PASSWORD from
+	// envFile, which overrides the VNC_PASSWORD environment variable
+	envFile     string
+	vncPassword string
+}
+
+// DefaultRefreshInterval is how often the dashboard polls the node.
+const DefaultRefreshInterval = 5 * time.Second
+
+// nodeClient is the part of cli.Client the dashboard uses, so demo mode can
+// serve synthetic data in its place.
+type nodeClient interface {
+	Close() error
+	Status() (*protocol.StatusResult, error)
+	Peers() (*protocol.PeersResult, error)
+	Logs(params protocol.LogsParams) (*protocol.LogsResult, error)
+	RemoteLogs(addr string, params protocol.LogsParams) (*protocol.LogsResult, error)
+	Stats(params protocol.StatsParams) (*protocol.StatsResult, error)
+	Connect() (*protocol.ConnectionResult, error)
+	Disconnect() (*protocol.ConnectionResult, error)
+	ConnectionStatus() (*protocol.ConnectionStatus, error)
+	Topology() (*protocol.TopologyResult, error)
+	WatchTopology(fn func(*protocol.TopologyResult) error) error
+	WatchStats(params protocol.StatsWatchParams, fn func(*protocol.StatsWatchResult) error) error
+	NetworkPeers() (*protocol.NetworkPeersResult, error)
+	HandshakeHistory(params protocol.HandshakeHistoryParams) (*protocol.HandshakeHistoryResult, error)
+	Traffic(params protocol.TrafficParams) (*protocol.TrafficResult, error)
+	Preferences() (*protocol.PreferencesResult, error)
+	SetPreferences(prefs map[string]string) (*protocol.PreferencesResult, error)
 }
 
 // NewServer creates a new UI server.
@@ -54,6 +107,75 @@ func (s *Server) SetTemplatesDir(dir string) {
 	s.templatesDir = dir
 }
 
+// SetControlToken sets the token sent to the node, for nodes started with
+// --control-token.
+func (s *Server) SetControlToken(token string) {
+	s.controlToken = token
+}
+
+// SetBasicAuth requires HTTP Basic Auth with these credentials on every
+// request.
+func (s *Server) SetBasicAuth(user, password string) {
+	s.authUser = user
+	s.authPass = password
+}
+
+// SetMockPeers serves a synthetic network of n peers instead of querying a
+// node, for demos without a live VPN.
+func (s *Server) SetMockPeers(n int) {
+	s.mock = newMockNetwork(n)
+}
+
+// SetEnvFile sets the .env file /api/vnc-config reads VNC_PASSWORD from.
+// The file is re-read on every request, so edits apply without a restart.
+func (s *Server) SetEnvFile(path string) {
+	s.envFile = path
+}
+
+// SetVNCPassword sets the screen sharing password, overriding the .env file
+// and the environment.
+func (s *Server) SetVNCPassword(password string) {
+	s.vncPassword = password
+}
+
+// SetTOTP requires logging in with a code for the base32 secret before
+// /api/* and /ws/* can be used.
+func (s *Server) SetTOTP(secret string) error {
+	auth, err := newTOTPAuth(secret)
+	if err != nil {
+		return err
+	}
+	s.totp = auth
+	return nil
+}
+
+// SetRefreshInterval sets how often the dashboard polls the node.
+func (s *Server) SetRefreshInterval(d time.Duration) {
+	s.refreshInterval = d
+}
+
+// SetTLS serves HTTPS with the given certificate and key files.
+func (s *Server) SetTLS(certFile, keyFile string) {
+	s.tlsCert = certFile
+	s.tlsKey = keyFile
+}
+
+// requireAuth wraps next with HTTP Basic Auth, comparing credentials in
+// constant time.
+func (s *Server) requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		userOK := subtle.ConstantTimeCompare([]byte(user), []byte(s.authUser)) == 1
+		passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(s.authPass)) == 1
+		if !ok || !userOK || !passOK {
+			w.Header().Set("WWW-Authenticate", `Basic realm="VPN Dashboard", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // Start starts the web server.
 func (s *Server) Start() error {
 	mux := http.NewServeMux()
@@ -64,14 +186,20 @@ func (s *Server) Start() error {
 	mux.HandleFunc("/api/stats", s.handleStats)
 	mux.HandleFunc("/api/logs", s.handleLogs)
 	mux.HandleFunc("/api/verify", s.handleVerify)
+	mux.HandleFunc("/api/diagnose", s.handleDiagnose)
 	mux.HandleFunc("/api/connection", s.handleConnection)
 	mux.HandleFunc("/api/topology", s.handleTopology)
+	mux.HandleFunc("/api/topology/stream", s.handleTopologyStream)
 	mux.HandleFunc("/api/network_peers", s.handleNetworkPeers)
 	mux.HandleFunc("/api/vnc-config", s.handleVNCConfig)
 	mux.HandleFunc("/api/handshakes", s.handleHandshakes)
+	mux.HandleFunc("/api/traffic", s.handleTraffic)
+	mux.HandleFunc("/api/preferences", s.handlePreferences)
 
-	// WebSocket terminal
+	// WebSocket terminal and live metrics
 	mux.HandleFunc("/ws/terminal", s.handleTerminal)
+	mux.HandleFunc("/ws/upload", s.handleUpload)
+	mux.HandleFunc("/ws/metrics", s.handleMetricsStream)
 
 	// Static files and SPA
 	staticFS, err := fs.Sub(staticFiles, "static")
@@ -81,21 +209,67 @@ func (s *Server) Start() error {
 	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(staticFS))))
 	mux.HandleFunc("/", s.handleIndex)
 
+	var handler http.Handler = mux
+	if s.totp != nil {
+		s.totp.secure = s.tlsCert != ""
+		handler = s.totp.require(handler)
+		if s.tlsCert == "" {
+			log.Printf("[ui] WARN: TOTP login without TLS: the session cookie is sent in plaintext (use --tls-cert/--tls-key)")
+		}
+	}
+	if s.authUser != "" {
+		handler = s.requireAuth(handler)
+		if s.tlsCert == "" {
+			log.Printf("[ui] WARN: basic auth without TLS: credentials are sent in plaintext (use --tls-cert/--tls-key)")
+		}
+	}
+
+	scheme := "http"
+	if s.tlsCert != "" {
+		scheme = "https"
+	}
+
 	if !s.quiet {
 		fmt.Printf("\n")
 		fmt.Printf("  VPN Dashboard starting...\n")
 		fmt.Printf("  ────────────────────────────────────────\n")
-		fmt.Printf("  URL:  http://%s\n", s.listenAddr)
-		fmt.Printf("  Node: %s\n", s.nodeAddr)
+		fmt.Printf("  URL:  %s://%s\n", scheme, s.listenAddr)
+		if s.mock != nil {
+			fmt.Printf("  Node: demo mode (%d mock peers)\n", len(s.mock.peers))
+		} else {
+			fmt.Printf("  Node: %s\n", s.nodeAddr)
+		}
+		if s.authUser != "" {
+			fmt.Printf("  Auth: %s (basic auth)\n", s.authUser)
+		}
+		if s.totp != nil {
+			fmt.Printf("  Auth: TOTP login at %s://%s/login\n", scheme, s.listenAddr)
+		}
+		if s.refreshInterval > 0 && s.refreshInterval != DefaultRefreshInterval {
+			fmt.Printf("  Refresh: every %s\n", s.refreshInterval)
+		}
 		fmt.Printf("  ────────────────────────────────────────\n")
 		fmt.Printf("  Press Ctrl+C to stop\n\n")
 	}
 
-	return http.ListenAndServe(s.listenAddr, mux)
+	if s.tlsCert != "" {
+		return http.ListenAndServeTLS(s.listenAddr, s.tlsCert, s.tlsKey, handler)
+	}
+	return http.ListenAndServe(s.listenAddr, handler)
 }
 
-func (s *Server) getClient() (*cli.Client, error) {
-	return cli.NewClient(s.nodeAddr)
+func (s *Server) getClient() (nodeClient, error) {
+	if s.mock != nil {
+		return s.mock.client(), nil
+	}
+	client, err := cli.NewClient(s.nodeAddr)
+	if err != nil {
+		return nil, err
+	}
+	if s.controlToken != "" {
+		client.SetToken(s.controlToken)
+	}
+	return client, nil
 }
 
 func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
@@ -104,6 +278,15 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+
+	refresh := s.refreshInterval
+	if refresh <= 0 {
+		refresh = DefaultRefreshInterval
+	}
+	html = strings.NewReplacer(
+		"{{REFRESH_MS}}", strconv.FormatInt(refresh.Milliseconds(), 10),
+		"{{CONNECTION_REFRESH_MS}}", strconv.FormatInt(2*refresh.Milliseconds(), 10),
+	).Replace(html)
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.Write([]byte(html))
 }
@@ -217,6 +400,9 @@ func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
 		Earliest:    r.URL.Query().Get("earliest"),
 		Latest:      r.URL.Query().Get("latest"),
 		Granularity: r.URL.Query().Get("granularity"),
+		Aggregation: r.URL.Query().Get("aggregation"),
+		GroupBy:     r.URL.Query().Get("group_by"),
+		Fill:        r.URL.Query().Get("fill"),
 	}
 	if params.Earliest == "" {
 		params.Earliest = "-5m"
@@ -231,6 +417,17 @@ func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
 	if metrics := r.URL.Query().Get("metrics"); metrics != "" {
 		params.Metrics = []string{metrics}
 	}
+	for _, tag := range r.URL.Query()["tag"] {
+		key, value, ok := strings.Cut(tag, "=")
+		if !ok || key == "" {
+			http.Error(w, fmt.Sprintf("invalid tag %q (use key=value)", tag), http.StatusBadRequest)
+			return
+		}
+		if params.Tags == nil {
+			params.Tags = make(map[string]string)
+		}
+		params.Tags[key] = value
+	}
 
 	stats, err := client.Stats(params)
 	if err != nil {
@@ -246,31 +443,10 @@ func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
 	// Check if we're querying a specific peer's logs
 	peerAddr := r.URL.Query().Get("peer")
 
-	var client *cli.Client
-	var err error
-
-	if peerAddr != "" {
-		// Connect to the remote peer's control socket via VPN
-		// Peers listen on port 9001 by default
-		remoteAddr := peerAddr + ":9001"
-		client, err = cli.NewClient(remoteAddr)
-		if err != nil {
-			// If we can't connect to the remote peer, return an error with helpful message
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(protocol.LogsResult{
-				Entries:    []protocol.LogEntry{},
-				TotalCount: 0,
-				HasMore:    false,
-			})
-			return
-		}
-	} else {
-		// Query local node
-		client, err = s.getClient()
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusServiceUnavailable)
-			return
-		}
+	client, err := s.getClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
 	}
 	defer client.Close()
 
@@ -294,7 +470,13 @@ func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
 		params.Components = []string{component}
 	}
 
-	logs, err := client.Logs(params)
+	// The local node proxies peer queries to the peer's control socket
+	var logs *protocol.LogsResult
+	if peerAddr != "" {
+		logs, err = client.RemoteLogs(peerAddr, params)
+	} else {
+		logs, err = client.Logs(params)
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -335,6 +517,20 @@ func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleDiagnose runs the 'vpn diagnose' checks on this machine and returns
+// the report. It takes several seconds (pings, DNS and HTTP probes).
+func (s *Server) handleDiagnose(w http.ResponseWriter, r *http.Request) {
+	var report *diagnose.Report
+	if s.mock != nil {
+		report = s.mock.diagnostics()
+	} else {
+		report = diagnose.Run(s.nodeAddr)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
 func (s *Server) handleConnection(w http.ResponseWriter, r *http.Request) {
 	client, err := s.getClient()
 	if err != nil {
@@ -394,6 +590,83 @@ func (s *Server) handleTopology(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(topology)
 }
 
+// handleTopologyStream sends the topology as Server-Sent Events: once on
+// connect and again whenever the node reports a change.
+func (s *Server) handleTopologyStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	client, err := s.getClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer client.Close()
+
+	// Closing the control connection ends the watch when the browser goes away
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-r.Context().Done():
+			client.Close()
+		case <-done:
+		}
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	client.WatchTopology(func(topology *protocol.TopologyResult) error {
+		data, err := json.Marshal(topology)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+}
+
+// handleMetricsStream pushes metric points to the dashboard over a WebSocket
+// as the node's collector records them, one JSON message per batch.
+func (s *Server) handleMetricsStream(w http.ResponseWriter, r *http.Request) {
+	client, err := s.getClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer client.Close()
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade error: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	// The browser never sends anything; reading only detects the close, which
+	// ends the watch by closing the control connection
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				client.Close()
+				return
+			}
+		}
+	}()
+
+	client.WatchStats(protocol.StatsWatchParams{}, func(batch *protocol.StatsWatchResult) error {
+		return conn.WriteJSON(batch)
+	})
+}
+
 func (s *Server) handleNetworkPeers(w http.ResponseWriter, r *http.Request) {
 	client, err := s.getClient()
 	if err != nil {
@@ -412,10 +685,21 @@ func (s *Server) handleNetworkPeers(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(peers)
 }
 
-// handleVNCConfig returns VNC configuration for screen sharing.
-// The password is read from the VNC_PASSWORD environment variable (loaded from .env file).
+// handleVNCConfig returns VNC configuration for screen sharing: the
+// password set with SetVNCPassword, else VNC_PASSWORD from the .env file,
+// else the VNC_PASSWORD environment variable. The password is never logged.
 func (s *Server) handleVNCConfig(w http.ResponseWriter, r *http.Request) {
-	password := os.Getenv("VNC_PASSWORD")
+	password := s.vncPassword
+	if password == "" && s.envFile != "" {
+		if env, err := readEnvFile(s.envFile); err == nil {
+			password = env["VNC_PASSWORD"]
+		} else if !os.IsNotExist(err) {
+			log.Printf("[ui] Failed to read %s: %v", s.envFile, err)
+		}
+	}
+	if password == "" {
+		password = os.Getenv("VNC_PASSWORD")
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
@@ -438,10 +722,12 @@ func (s *Server) handleHandshakes(w http.ResponseWriter, r *http.Request) {
 	}
 	defer client.Close()
 
-	nodeName := r.URL.Query().Get("node")
-	limit := 100
-
-	history, err := client.HandshakeHistory(nodeName, limit)
+	history, err := client.HandshakeHistory(protocol.HandshakeHistoryParams{
+		NodeName: r.URL.Query().Get("node"),
+		OS:       r.URL.Query().Get("os"),
+		Failed:   r.URL.Query().Get("failed") == "true",
+		Limit:    100,
+	})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -451,6 +737,76 @@ func (s *Server) handleHandshakes(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(history)
 }
 
+// handleTraffic returns the top destinations by bytes, e.g.
+// /api/traffic?earliest=-24h&limit=20 (defaults: last hour, top 10).
+func (s *Server) handleTraffic(w http.ResponseWriter, r *http.Request) {
+	client, err := s.getClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer client.Close()
+
+	params := protocol.TrafficParams{
+		Earliest: r.URL.Query().Get("earliest"),
+		Latest:   r.URL.Query().Get("latest"),
+	}
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			http.Error(w, fmt.Sprintf("invalid limit %q", limit), http.StatusBadRequest)
+			return
+		}
+		params.Limit = n
+	}
+
+	traffic, err := client.Traffic(params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(traffic)
+}
+
+// handlePreferences reads (GET) or updates (PUT) the dashboard preferences
+// stored on the node, e.g. {"theme":"light","default_log_range":"-1h"}.
+// A PUT only changes the keys it contains and returns all preferences.
+func (s *Server) handlePreferences(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	client, err := s.getClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer client.Close()
+
+	var result *protocol.PreferencesResult
+	if r.Method == http.MethodPut {
+		var prefs map[string]string
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&prefs); err != nil {
+			http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		result, err = client.SetPreferences(prefs)
+	} else {
+		result, err = client.Preferences()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result.Preferences)
+}
+
 func init() {
 	// Initialize time location
 	time.Local = time.UTC
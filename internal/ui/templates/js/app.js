@@ -8,13 +8,31 @@
         let currentMetricsRange = '-5m';
         let currentLogRange = '-15m';
         let refreshInterval = null;
+        let connectionInterval = null;
+        let refreshPaused = false;
+
+        // Polling intervals, set by the server (vpn ui --refresh-interval)
+        const REFRESH_MS = {{REFRESH_MS}};
+        const CONNECTION_REFRESH_MS = {{CONNECTION_REFRESH_MS}};
+        let metricsStreamLive = false;  // Charts are fed by /ws/metrics instead of polling
         let vpnConnected = false;  // Whether tunnel is actually connected
         let vpnRouteAllEnabled = false;  // Whether route_all is requested
         let vpnToggleLoading = false;
         let isServerMode = false;  // True if viewing a server node (toggle not applicable)
+        let connectTo = '';  // Server address the viewed client node connects to
 
         const HELSINKI_IP = '95.217.238.72';
 
+        // With --auth-totp an expired session answers 401: back to the login page
+        const nativeFetch = window.fetch.bind(window);
+        window.fetch = async (...args) => {
+            const res = await nativeFetch(...args);
+            if (res.status === 401 && res.headers.get('X-Login')) {
+                window.location.href = res.headers.get('X-Login');
+            }
+            return res;
+        };
+
         // Chart.js global defaults - prevent infinite growth
         Chart.defaults.maintainAspectRatio = false;
         Chart.defaults.responsive = true;
@@ -30,6 +48,7 @@
                 document.getElementById('home-version').textContent = 'v' + (status.version || '0.1.0');
                 document.getElementById('footer-version').textContent = 'v' + (status.version || '0.1.0');
                 isServerMode = status.server_mode || false;
+                connectTo = status.connect_to || '';
 
                 // Load VPN connection status for footer
                 await loadConnectionStatus();
@@ -217,6 +236,88 @@
             }
         }
 
+        // Toggle the Health panel; diagnostics run the first time it opens
+        let healthLoaded = false;
+        function toggleHealthPanel() {
+            const panel = document.getElementById('health-panel');
+            const open = panel.style.display === 'none';
+            panel.style.display = open ? '' : 'none';
+            document.getElementById('health-caret').textContent = open ? '▾' : '▸';
+            if (open && !healthLoaded) runDiagnostics();
+        }
+
+        // Run 'vpn diagnose' on the dashboard's machine (takes a few seconds)
+        async function runDiagnostics() {
+            const panel = document.getElementById('health-panel');
+            const container = document.getElementById('health-checks');
+            const summaryEl = document.getElementById('health-summary');
+            const btn = document.getElementById('health-run-btn');
+
+            panel.style.display = '';
+            document.getElementById('health-caret').textContent = '▾';
+            healthLoaded = true;
+            btn.disabled = true;
+            btn.textContent = 'Running...';
+            summaryEl.textContent = '';
+
+            try {
+                const res = await fetch('/api/diagnose');
+                if (!res.ok) throw new Error('Failed to run diagnostics');
+                const report = await res.json();
+
+                const s = report.summary;
+                summaryEl.textContent = `${s.passed} passed, ${s.failed} failed, ${s.warned} warnings`;
+
+                const row = (status, name, message, details, recommendations) => `
+                    <div class="health-check">
+                        <span class="health-status ${status}">${status.toUpperCase()}</span>
+                        <span>${escapeHtml(name)}</span>
+                        <div>
+                            ${escapeHtml(message)}
+                            ${details ? `<div class="health-details">${escapeHtml(details)}</div>` : ''}
+                            ${recommendations && recommendations.length
+                                ? `<ul class="health-recommendations">${recommendations.map(r => `<li>${escapeHtml(r)}</li>`).join('')}</ul>`
+                                : ''}
+                        </div>
+                    </div>
+                `;
+
+                let html = '<div class="health-group">This Node</div>';
+                html += (report.local_node.checks || []).map(c =>
+                    row(c.status, c.name, c.message, c.details, c.recommendations)).join('');
+
+                const peers = report.peers || [];
+                html += '<div class="health-group">Network Peers</div>';
+                if (peers.length === 0) {
+                    html += row('warn', 'Peers', 'No network peers discovered', '', null);
+                }
+                html += peers.map(p => {
+                    const warnings = [p.version_warning, p.routing_warning, p.ssh_warning].filter(w => w);
+                    let status = p.reachable ? 'pass' : 'fail';
+                    if (status === 'pass' && warnings.length > 0) status = 'warn';
+                    const message = (p.reachable ? 'Reachable' : 'Unreachable') +
+                        (p.version ? ' · v' + p.version : '') + (p.os ? ' · ' + p.os : '');
+                    return row(status, `${p.name} (${p.vpn_address})`, message, warnings.join(' · '), null);
+                }).join('');
+
+                const events = report.recent_events || [];
+                if (events.length > 0) {
+                    html += '<div class="health-group">Recent Events</div>';
+                    html += events.map(e =>
+                        row(e.event === 'CRASH' ? 'fail' : 'warn', e.event, e.reason,
+                            new Date(e.timestamp).toLocaleString(), null)).join('');
+                }
+
+                container.innerHTML = html;
+            } catch (err) {
+                console.error('Failed to run diagnostics:', err);
+                container.innerHTML = '<div class="health-check"><span class="health-status fail">FAIL</span><span>Diagnostics</span><div>Failed to run diagnostics</div></div>';
+            } finally {
+                btn.disabled = false;
+                btn.textContent = 'Run Diagnostics';
+            }
+        }
+
         // Copy SSH command to clipboard
         function copySSHCommand(cmd) {
             navigator.clipboard.writeText(cmd).then(() => {
@@ -244,7 +345,7 @@
 
         // Ping a peer
         async function pingPeer(vpnAddr) {
-            alert('Pinging ' + vpnAddr + '...\\n\\nRun in terminal:\\nping ' + vpnAddr);
+            alert('Pinging ' + vpnAddr + '...\\n\\nRun in terminal:\\nvpn ping ' + vpnAddr);
         }
 
         // Terminal state
@@ -436,6 +537,103 @@
                 terminal = null;
             }
             currentSSHTarget = null;
+            document.getElementById('terminal-upload').classList.remove('open');
+        }
+
+        // File upload: files dropped on the terminal are streamed to the
+        // peer over /ws/upload, one chunk per progress acknowledgement
+        const UPLOAD_CHUNK_SIZE = 256 * 1024;
+
+        function terminalDragOver(event) {
+            if (!currentSSHTarget) return;
+            event.preventDefault();
+            event.currentTarget.classList.add('dragover');
+        }
+
+        function terminalDragLeave(event) {
+            if (!event.currentTarget.contains(event.relatedTarget)) {
+                event.currentTarget.classList.remove('dragover');
+            }
+        }
+
+        function terminalDrop(event) {
+            event.preventDefault();
+            event.currentTarget.classList.remove('dragover');
+            if (!currentSSHTarget) return;
+
+            // Upload one file at a time
+            const target = currentSSHTarget;
+            Array.from(event.dataTransfer.files).reduce(
+                (prev, file) => prev.then(() => uploadFile(file, target)),
+                Promise.resolve()
+            );
+        }
+
+        function uploadFile(file, target) {
+            return new Promise(resolve => {
+                const panel = document.getElementById('terminal-upload');
+                const text = document.getElementById('terminal-upload-text');
+                const fill = document.getElementById('terminal-upload-fill');
+                panel.classList.add('open');
+                fill.className = 'terminal-upload-fill';
+                fill.style.width = '0%';
+                text.textContent = 'Uploading ' + file.name + ' to ' + target.host + '...';
+
+                const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
+                const ws = new WebSocket(protocol + '//' + window.location.host + '/ws/upload');
+                let offset = 0;
+                let finished = false;
+
+                const finish = (message, state) => {
+                    finished = true;
+                    text.textContent = message;
+                    fill.classList.add(state);
+                    ws.close();
+                    resolve();
+                };
+
+                // Read and send the next slice; the file is never loaded whole
+                const sendNext = async () => {
+                    if (offset >= file.size) return;
+                    const chunk = file.slice(offset, offset + UPLOAD_CHUNK_SIZE);
+                    offset += chunk.size;
+                    ws.send(await chunk.arrayBuffer());
+                };
+
+                ws.onopen = () => {
+                    ws.send(JSON.stringify({
+                        host: target.host,
+                        user: target.user,
+                        password: 'osopanda',
+                        name: file.name,
+                        size: file.size
+                    }));
+                    sendNext();
+                };
+
+                ws.onmessage = (event) => {
+                    const msg = JSON.parse(event.data);
+                    if (msg.type === 'progress') {
+                        const pct = file.size > 0 ? msg.bytes / file.size * 100 : 100;
+                        fill.style.width = pct.toFixed(1) + '%';
+                        text.textContent = 'Uploading ' + file.name + ': ' + formatBytes(msg.bytes) +
+                            ' / ' + formatBytes(file.size) + ' (' + Math.floor(pct) + '%)';
+                        sendNext();
+                    } else if (msg.type === 'done') {
+                        fill.style.width = '100%';
+                        const remote = msg.path.startsWith('/') ? msg.path : '~/' + msg.path;
+                        finish('Uploaded ' + file.name + ' (' + formatBytes(msg.bytes) + ') to ' + target.host + ':' + remote, 'done');
+                    } else if (msg.type === 'error') {
+                        finish('Upload of ' + file.name + ' failed: ' + msg.error, 'error');
+                    }
+                };
+
+                ws.onclose = () => {
+                    if (!finished) {
+                        finish('Upload of ' + file.name + ' interrupted', 'error');
+                    }
+                };
+            });
         }
 
         // Close on backdrop click
@@ -511,8 +709,11 @@
                     tbody.innerHTML = `<tr><td colspan="4" style="text-align:center;color:var(--text-secondary)">${message}</td></tr>`;
                 }
 
-                // Load bandwidth chart (only meaningful when VPN is active)
-                loadBandwidthChart();
+                // Load bandwidth chart (only meaningful when VPN is active);
+                // while the metrics stream is live it appends the points itself
+                if (!metricsStreamLive) {
+                    loadBandwidthChart();
+                }
             } catch (err) {
                 console.error('Failed to load overview:', err);
             }
@@ -542,10 +743,19 @@
                 const txData = (txSeries?.points || []).map(p => p.value / 1024);
                 const rxData = (rxSeries?.points || []).map(p => p.value / 1024);
 
+                // P95 over the node's 5-minute window, drawn dashed so spikes
+                // the instantaneous line hides between samples stay visible
+                const txP95Series = data.series?.find(s => s.name === 'bandwidth.tx_p95_bps');
+                const rxP95Series = data.series?.find(s => s.name === 'bandwidth.rx_p95_bps');
+                const txP95Data = (txP95Series?.points || []).map(p => p.value / 1024);
+                const rxP95Data = (rxP95Series?.points || []).map(p => p.value / 1024);
+
                 if (bandwidthChart) {
                     bandwidthChart.data.labels = labels;
                     bandwidthChart.data.datasets[0].data = txData;
                     bandwidthChart.data.datasets[1].data = rxData;
+                    bandwidthChart.data.datasets[2].data = txP95Data;
+                    bandwidthChart.data.datasets[3].data = rxP95Data;
                     bandwidthChart.update('none');
                 } else {
                     bandwidthChart = new Chart(ctx, {
@@ -566,6 +776,24 @@
                                 backgroundColor: 'rgba(34, 197, 94, 0.1)',
                                 fill: true,
                                 tension: 0.4
+                            }, {
+                                label: 'TX P95 (KB/s)',
+                                data: txP95Data,
+                                borderColor: '#3b82f6',
+                                borderDash: [6, 4],
+                                borderWidth: 1,
+                                pointRadius: 0,
+                                fill: false,
+                                tension: 0.4
+                            }, {
+                                label: 'RX P95 (KB/s)',
+                                data: rxP95Data,
+                                borderColor: '#22c55e',
+                                borderDash: [6, 4],
+                                borderWidth: 1,
+                                pointRadius: 0,
+                                fill: false,
+                                tension: 0.4
                             }]
                         },
                         options: {
@@ -586,7 +814,9 @@
 
         // Load observability
         async function loadObservability() {
-            loadMetricsCharts();
+            if (!metricsStreamLive) {
+                loadMetricsCharts();
+            }
             loadPeerFilterOptions();
             loadLogs();
         }
@@ -657,6 +887,90 @@
             }
         }
 
+        // Subscribe to live metrics. The charts are reloaded from /api/stats
+        // when the socket opens (to fill any gap) and then appended to; if the
+        // socket fails, the periodic refresh polls /api/stats until it reconnects.
+        function startMetricsStream() {
+            if (!window.WebSocket) return;
+
+            const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
+            const socket = new WebSocket(protocol + '//' + window.location.host + '/ws/metrics');
+            socket.onopen = () => {
+                metricsStreamLive = true;
+                loadBandwidthChart();
+                loadMetricsCharts();
+            };
+            socket.onmessage = (event) => {
+                try {
+                    appendMetricPoints(JSON.parse(event.data).points || []);
+                } catch (err) {
+                    console.error('Failed to render metrics update:', err);
+                }
+            };
+            socket.onclose = () => {
+                metricsStreamLive = false;
+                setTimeout(startMetricsStream, 10000);
+            };
+        }
+
+        // Append one collector run (all points share a timestamp) to the charts
+        function appendMetricPoints(points) {
+            if (!points.length) return;
+
+            const values = {};
+            points.forEach(p => values[p.name] = p.value);
+            const label = new Date(points[0].timestamp).toLocaleTimeString();
+            const kb = v => v === undefined ? null : v / 1024;
+            const val = v => v === undefined ? null : v;
+
+            const bwPoints = rangeSeconds(currentBandwidthRange);
+            const tx = values['bandwidth.tx_current_bps'] ?? values['bandwidth.tx_avg_bps'];
+            const rx = values['bandwidth.rx_current_bps'] ?? values['bandwidth.rx_avg_bps'];
+            if (tx !== undefined || rx !== undefined) {
+                pushChartPoint(bandwidthChart, label, [kb(tx), kb(rx),
+                    kb(values['bandwidth.tx_p95_bps']), kb(values['bandwidth.rx_p95_bps'])], bwPoints);
+            }
+
+            const obsPoints = rangeSeconds(currentMetricsRange);
+            if ('bandwidth.tx_current_bps' in values) {
+                pushChartPoint(obsBandwidthChart, label,
+                    [values['bandwidth.tx_current_bps'], val(values['bandwidth.rx_current_bps'])], obsPoints);
+            }
+            if ('vpn.bytes_sent' in values) {
+                pushChartPoint(bytesChart, label,
+                    [values['vpn.bytes_sent'], val(values['vpn.bytes_recv'])], obsPoints);
+            }
+            if ('vpn.packets_sent' in values) {
+                pushChartPoint(packetsChart, label,
+                    [values['vpn.packets_sent'], val(values['vpn.packets_recv'])], obsPoints);
+            }
+            if ('vpn.active_peers' in values) {
+                pushChartPoint(peersChart, label, [values['vpn.active_peers']], obsPoints);
+            }
+        }
+
+        // Add a point to each dataset, dropping the oldest beyond maxPoints
+        function pushChartPoint(chart, label, values, maxPoints) {
+            if (!chart) return;
+
+            chart.data.labels.push(label);
+            values.forEach((v, i) => chart.data.datasets[i]?.data.push(v));
+            while (chart.data.labels.length > maxPoints) {
+                chart.data.labels.shift();
+            }
+            chart.data.datasets.forEach(ds => {
+                while (ds.data.length > maxPoints) ds.data.shift();
+            });
+            chart.update('none');
+        }
+
+        // Seconds in a range like "-5m"; the collector records one point per second
+        function rangeSeconds(range) {
+            const m = /^-(\d+)([smhd])$/.exec(range);
+            if (!m) return 300;
+            return parseInt(m[1]) * { s: 1, m: 60, h: 3600, d: 86400 }[m[2]];
+        }
+
         function updateChart(canvasId, chart, setChart, series1, series2, label1, label2, color1, color2, formatFn) {
             const ctx = document.getElementById(canvasId).getContext('2d');
             const labels = (series1?.points || []).map(p => new Date(p.timestamp).toLocaleTimeString());
@@ -762,7 +1076,7 @@
                             <span class="log-time">${e.timestamp?.substring(0, 19) || ''}</span>
                             <span class="log-level ${e.level}">${e.level}</span>
                             <span class="log-component">[${e.component}]</span>
-                            <span class="log-message">${escapeHtml(e.message)}</span>
+                            <span class="log-message">${e.snippet ? highlightSnippet(e.snippet) : escapeHtml(e.message)}</span>
                         </div>
                     `).join('');
                 } else {
@@ -780,6 +1094,11 @@
             return str.replace(/&/g, '&amp;').replace(/</g, '&lt;').replace(/>/g, '&gt;');
         }
 
+        // Search matches come back between « and » (FTS5 snippets)
+        function highlightSnippet(snippet) {
+            return escapeHtml(snippet).replace(/«/g, '<mark>').replace(/»/g, '</mark>');
+        }
+
         // Leaflet map instance
         let networkMap = null;
         let mapMarkers = [];
@@ -789,6 +1108,11 @@
         let topologySortAsc = true;
         let myVpnAddr = null; // Current node's VPN address (for correct "YOU" detection)
 
+        // Topology is pushed over Server-Sent Events; polling is the fallback
+        let topologyStreamLive = false;
+        let topologyVPNActive = false;
+        let lastTopology = null;
+
         // Map tile layer - standard OpenStreetMap
         const mapTile = L.tileLayer('https://{s}.tile.openstreetmap.org/{z}/{x}/{y}.png', {
             maxZoom: 19
@@ -801,32 +1125,27 @@
                 const statusRes = await fetch('/api/status');
                 const statusData = await statusRes.json();
                 myVpnAddr = statusData.vpn_address;
+                if (statusData.server_ip) {
+                    HELSINKI_VPN_IP = statusData.server_ip;
+                }
 
                 // Check if VPN routing is enabled (this is what the toggle controls)
                 const connRes = await fetch('/api/connection');
                 const connStatus = await connRes.json();
-                const isVPNActive = connStatus.route_all; // VPN toggle is ON
-
-                // Then get topology
-                const res = await fetch('/api/topology');
-                const data = await res.json();
+                const wasVPNActive = topologyVPNActive;
+                topologyVPNActive = connStatus.route_all; // VPN toggle is ON
 
-                // If VPN routing is not active, only show ourselves
-                // This is consistent with Overview - only show peers when VPN is ON
-                if (!isVPNActive) {
-                    data.nodes = (data.nodes || []).filter(n => n.vpn_address === myVpnAddr);
-                    data.edges = [];
+                // While the stream is live it delivers the topology itself
+                if (topologyStreamLive) {
+                    if (lastTopology && wasVPNActive !== topologyVPNActive) {
+                        showTopology(lastTopology);
+                    }
+                    return;
                 }
 
-                topologyData = data;
-
-                renderNetworkMap(data);
-                renderTopologyTable(data.nodes || []);
-
-                // Update node count with VPN status
-                const statusText = isVPNActive ? '' : ' (VPN routing disabled)';
-                document.getElementById('topology-node-count').textContent =
-                    `${(data.nodes || []).length} nodes, ${(data.edges || []).length} connections${statusText}`;
+                // Then get topology
+                const res = await fetch('/api/topology');
+                showTopology(await res.json());
             } catch (err) {
                 console.error('Failed to load topology:', err);
                 document.getElementById('all-peers-tbody').innerHTML =
@@ -834,8 +1153,51 @@
             }
         }
 
+        // Render a topology snapshot (from /api/topology or the stream)
+        function showTopology(topology) {
+            lastTopology = topology;
+            const data = { nodes: topology.nodes || [], edges: topology.edges || [] };
+
+            // If VPN routing is not active, only show ourselves
+            // This is consistent with Overview - only show peers when VPN is ON
+            if (!topologyVPNActive) {
+                data.nodes = data.nodes.filter(n => n.vpn_address === myVpnAddr);
+                data.edges = [];
+            }
+
+            topologyData = data;
+
+            renderNetworkMap(data);
+            renderTopologyTable(data.nodes);
+
+            // Update node count with VPN status
+            const statusText = topologyVPNActive ? '' : ' (VPN routing disabled)';
+            document.getElementById('topology-node-count').textContent =
+                `${data.nodes.length} nodes, ${data.edges.length} connections${statusText}`;
+        }
+
+        // Subscribe to topology changes. EventSource reconnects by itself;
+        // until it does, loadPeers falls back to polling /api/topology.
+        function startTopologyStream() {
+            if (!window.EventSource) return;
+
+            const stream = new EventSource('/api/topology/stream');
+            stream.onmessage = (event) => {
+                topologyStreamLive = true;
+                try {
+                    showTopology(JSON.parse(event.data));
+                } catch (err) {
+                    console.error('Failed to render topology update:', err);
+                }
+            };
+            stream.onerror = () => {
+                topologyStreamLive = false;
+            };
+        }
+
         // Render Leaflet map with nodes and great circle arcs
-        const HELSINKI_VPN_IP = '10.8.0.1';
+        // Server's VPN IP, updated from /api/status (first host of the VPN subnet)
+        let HELSINKI_VPN_IP = '10.8.0.1';
         // Default Helsinki coordinates if geo not available
         const HELSINKI_DEFAULT = { lat: 60.1699, lon: 24.9384 };
 
@@ -1207,6 +1569,7 @@
                 document.querySelectorAll('.metrics-range').forEach(b => b.classList.remove('active'));
                 btn.classList.add('active');
                 currentMetricsRange = btn.dataset.range;
+                savePreferences({ default_metrics_range: currentMetricsRange });
                 loadMetricsCharts();
             });
         });
@@ -1217,6 +1580,7 @@
                 document.querySelectorAll('.time-btn').forEach(b => b.classList.remove('active'));
                 btn.classList.add('active');
                 currentLogRange = btn.dataset.range;
+                savePreferences({ default_log_range: currentLogRange });
                 loadLogs();
             });
         });
@@ -1240,7 +1604,28 @@
         function startRefresh() {
             refreshInterval = setInterval(() => {
                 loadDashboard();
-            }, 5000);
+            }, REFRESH_MS);
+            connectionInterval = setInterval(loadConnectionStatus, CONNECTION_REFRESH_MS);
+        }
+
+        function stopRefresh() {
+            clearInterval(refreshInterval);
+            clearInterval(connectionInterval);
+            refreshInterval = connectionInterval = null;
+        }
+
+        // Footer button: stop polling until resumed (live streams keep running)
+        function toggleRefreshPause() {
+            refreshPaused = !refreshPaused;
+            if (refreshPaused) {
+                stopRefresh();
+            } else {
+                loadDashboard();
+                loadConnectionStatus();
+                startRefresh();
+            }
+            document.getElementById('footer-refresh-label').textContent = refreshPaused ? 'Resume refresh' : 'Pause refresh';
+            document.getElementById('footer-refresh-icon').innerHTML = refreshPaused ? '&#9654;' : '&#9208;';
         }
 
         // VPN Toggle functions
@@ -1251,6 +1636,7 @@
                 if (statusRes.ok) {
                     const statusData = await statusRes.json();
                     isServerMode = statusData.server_mode || false;
+                    connectTo = statusData.connect_to || '';
                 }
 
                 const res = await fetch('/api/connection');
@@ -1281,6 +1667,7 @@
                 toggle.style.cursor = 'not-allowed';
                 statusText.textContent = 'Server mode';
                 statusText.style.color = 'var(--text-secondary)';
+                statusText.title = '';
                 return;
             }
 
@@ -1288,6 +1675,7 @@
             toggle.classList.remove('disabled');
             toggle.style.opacity = '1';
             toggle.style.cursor = 'pointer';
+            statusText.title = connectTo ? 'Server: ' + connectTo : '';
 
             if (vpnToggleLoading) {
                 toggle.classList.add('loading');
@@ -1369,15 +1757,63 @@
                 html.setAttribute('data-theme', 'light');
             }
 
-            // Save preference
+            // Save preference (locally, and on the node for other devices)
             localStorage.setItem('vpn-theme', newTheme);
+            savePreferences({ theme: newTheme });
         }
 
-        // Load saved theme preference
-        function loadTheme() {
-            const saved = localStorage.getItem('vpn-theme');
-            if (saved === 'light') {
+        function applyTheme(theme) {
+            if (theme === 'light') {
                 document.documentElement.setAttribute('data-theme', 'light');
+            } else {
+                document.documentElement.removeAttribute('data-theme');
+            }
+        }
+
+        // Mark the range button matching a saved default as active
+        function applyRange(selector, range) {
+            document.querySelectorAll(selector).forEach(b =>
+                b.classList.toggle('active', b.dataset.range === range));
+        }
+
+        // Load saved theme preference: the node's preferences win, so the
+        // theme follows the user across devices; localStorage is the
+        // fallback (and avoids a flash of the wrong theme while loading)
+        async function loadTheme() {
+            applyTheme(localStorage.getItem('vpn-theme'));
+
+            try {
+                const res = await fetch('/api/preferences');
+                if (!res.ok) return;
+                const prefs = await res.json();
+
+                if (prefs.theme) {
+                    applyTheme(prefs.theme);
+                    localStorage.setItem('vpn-theme', prefs.theme);
+                }
+                if (prefs.default_metrics_range) {
+                    currentMetricsRange = prefs.default_metrics_range;
+                    applyRange('.metrics-range', currentMetricsRange);
+                }
+                if (prefs.default_log_range) {
+                    currentLogRange = prefs.default_log_range;
+                    applyRange('.time-btn', currentLogRange);
+                }
+            } catch (err) {
+                console.error('Failed to load preferences:', err);
+            }
+        }
+
+        // Store preferences on the node; failures only lose cross-device sync
+        async function savePreferences(prefs) {
+            try {
+                await fetch('/api/preferences', {
+                    method: 'PUT',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify(prefs)
+                });
+            } catch (err) {
+                console.error('Failed to save preferences:', err);
             }
         }
 
@@ -1386,9 +1822,8 @@
         loadDashboard();
         loadConnectionStatus();
         startRefresh();
-
-        // Also refresh connection status periodically
-        setInterval(loadConnectionStatus, 10000);
+        startTopologyStream();
+        startMetricsStream();
 
         // Version tracking for auto-refresh on deployment
         let currentVersion = null;
@@ -2,27 +2,61 @@ package ui
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"sync"
-	"syscall"
-	"unsafe"
+	"time"
 
-	"github.com/creack/pty"
 	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/miguelemosreverte/vpn/internal/tunnel"
 )
 
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow connections from any origin (localhost)
-	},
+	CheckOrigin:     sameOrigin,
+}
+
+// sameOrigin accepts WebSocket requests from the dashboard's own pages (and
+// from non-browser clients, which send no Origin), so other websites open
+// in the browser cannot start SSH sessions through it.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
 }
 
+// sshUserPattern is what an SSH user name from the browser must look like.
+var sshUserPattern = regexp.MustCompile(`^[a-z_][a-z0-9_-]*$`)
+
+const (
+	// maxTerminalSessions limits concurrent SSH sessions across all dashboard clients
+	maxTerminalSessions = 4
+
+	// terminalRequestTimeout is how long a client has to send its TerminalRequest
+	terminalRequestTimeout = 10 * time.Second
+
+	// sshConnectTimeout bounds the SSH TCP connect and handshake
+	sshConnectTimeout = 10 * time.Second
+)
+
+// terminalSessions holds a slot for each running SSH session.
+var terminalSessions = make(chan struct{}, maxTerminalSessions)
+
 // TerminalRequest is sent by the frontend to start an SSH session.
 type TerminalRequest struct {
 	Host     string `json:"host"`     // VPN IP address
@@ -45,12 +79,22 @@ func (s *Server) handleTerminal(w http.ResponseWriter, r *http.Request) {
 	}
 	defer conn.Close()
 
+	select {
+	case terminalSessions <- struct{}{}:
+		defer func() { <-terminalSessions }()
+	default:
+		conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("Error: too many terminal sessions (max %d)\r\n", maxTerminalSessions)))
+		return
+	}
+
 	// Read the initial connection request
+	conn.SetReadDeadline(time.Now().Add(terminalRequestTimeout))
 	_, msg, err := conn.ReadMessage()
 	if err != nil {
 		log.Printf("Error reading terminal request: %v", err)
 		return
 	}
+	conn.SetReadDeadline(time.Time{})
 
 	var req TerminalRequest
 	if err := json.Unmarshal(msg, &req); err != nil {
@@ -62,79 +106,168 @@ func (s *Server) handleTerminal(w http.ResponseWriter, r *http.Request) {
 		conn.WriteMessage(websocket.TextMessage, []byte("Error: host and user are required\r\n"))
 		return
 	}
+	if err := s.checkSSHTarget(req.Host, req.User); err != nil {
+		conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("Error: %v\r\n", err)))
+		return
+	}
 
 	// Start SSH session
 	s.startSSHSession(conn, req)
 }
 
-// startSSHSession starts an SSH session and proxies I/O to the WebSocket.
-func (s *Server) startSSHSession(conn *websocket.Conn, req TerminalRequest) {
-	// Build SSH command with sshpass for password auth
-	var cmd *exec.Cmd
+// checkSSHTarget accepts only a VPN IP inside the node's subnet as host and
+// a plain user name as user.
+func (s *Server) checkSSHTarget(host, user string) error {
+	if !sshUserPattern.MatchString(user) {
+		return fmt.Errorf("invalid user %q", user)
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("host must be a VPN IP, got %q", host)
+	}
+	subnet := tunnel.DefaultSubnet
+	if client, err := s.getClient(); err == nil {
+		if status, err := client.Status(); err == nil && status.Subnet != "" {
+			subnet = status.Subnet
+		}
+		client.Close()
+	}
+	_, vpnNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return fmt.Errorf("invalid VPN subnet %q", subnet)
+	}
+	if !vpnNet.Contains(ip) {
+		return fmt.Errorf("host %s is not in the VPN subnet %s", host, subnet)
+	}
+	return nil
+}
+
+// dialSSH connects to req's peer with golang.org/x/crypto/ssh, offering the
+// UI host's ~/.ssh key and req's password, like vpn ssh --exec. The peer's
+// host key must be in ~/.ssh/known_hosts. The caller checks req with
+// checkSSHTarget.
+func dialSSH(req TerminalRequest) (*ssh.Client, error) {
+	var auth []ssh.AuthMethod
+	home, _ := os.UserHomeDir()
+	for _, name := range []string{"id_ed25519", "id_rsa"} {
+		data, err := os.ReadFile(filepath.Join(home, ".ssh", name))
+		if err != nil {
+			continue
+		}
+		if signer, err := ssh.ParsePrivateKey(data); err == nil {
+			auth = append(auth, ssh.PublicKeys(signer))
+			break
+		}
+	}
 	if req.Password != "" {
-		cmd = exec.Command("sshpass", "-p", req.Password, "ssh",
-			"-o", "StrictHostKeyChecking=no",
-			"-o", "UserKnownHostsFile=/dev/null",
-			"-o", "ServerAliveInterval=30",
-			fmt.Sprintf("%s@%s", req.User, req.Host))
-	} else {
-		// Try without password (key-based auth)
-		cmd = exec.Command("ssh",
-			"-o", "StrictHostKeyChecking=no",
-			"-o", "UserKnownHostsFile=/dev/null",
-			"-o", "ServerAliveInterval=30",
-			fmt.Sprintf("%s@%s", req.User, req.Host))
-	}
-
-	// Set environment
-	cmd.Env = append(os.Environ(), "TERM=xterm-256color")
-
-	// Start the command with a pty
-	ptmx, err := pty.Start(cmd)
+		auth = append(auth, ssh.Password(req.Password))
+	}
+
+	hostKeys, err := knownHostsCallback(filepath.Join(home, ".ssh", "known_hosts"))
 	if err != nil {
-		conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("Error starting SSH: %v\r\n", err)))
-		return
+		return nil, err
 	}
-	defer func() {
-		ptmx.Close()
-		cmd.Process.Kill()
-		cmd.Wait()
-	}()
 
-	// Set initial size
-	setWinsize(ptmx, 80, 24)
+	return ssh.Dial("tcp", net.JoinHostPort(req.Host, "22"), &ssh.ClientConfig{
+		User:            req.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeys,
+		Timeout:         sshConnectTimeout,
+	})
+}
 
-	var wg sync.WaitGroup
-	done := make(chan struct{})
+// knownHostsCallback checks host keys against the UI host's known_hosts
+// file, so a machine answering on a peer's VPN IP cannot collect the key
+// and password offered by dialSSH. Unknown and changed keys are refused
+// with a message saying how to trust the peer.
+func knownHostsCallback(path string) (ssh.HostKeyCallback, error) {
+	check, err := knownhosts.New(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no %s: connect once with ssh from this machine to trust the peer's host key", path)
+		}
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
 
-	// Read from PTY -> WebSocket
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		buf := make([]byte, 4096)
-		for {
-			select {
-			case <-done:
-				return
-			default:
-				n, err := ptmx.Read(buf)
-				if err != nil {
-					return
-				}
-				if n > 0 {
-					if err := conn.WriteMessage(websocket.BinaryMessage, buf[:n]); err != nil {
-						return
-					}
-				}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := check(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) {
+			host, _, _ := net.SplitHostPort(hostname)
+			if len(keyErr.Want) == 0 {
+				return fmt.Errorf("host key for %s is unknown (%s %s): run ssh %s once from this machine to trust it",
+					host, key.Type(), ssh.FingerprintSHA256(key), host)
 			}
+			return fmt.Errorf("host key for %s has CHANGED (now %s %s, expected per %s:%d): someone may be impersonating the peer; if the peer was reinstalled, remove the old key with ssh-keygen -R %s",
+				host, key.Type(), ssh.FingerprintSHA256(key), keyErr.Want[0].Filename, keyErr.Want[0].Line, host)
 		}
-	}()
+		return err
+	}, nil
+}
+
+// wsWriter sends everything written to it as binary WebSocket messages.
+// The session's stdout and stderr are copied concurrently, and a WebSocket
+// allows only one writer at a time.
+type wsWriter struct {
+	mu   sync.Mutex
+	conn *websocket.Conn
+}
+
+func (w *wsWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// startSSHSession starts an SSH session and proxies I/O to the WebSocket.
+func (s *Server) startSSHSession(conn *websocket.Conn, req TerminalRequest) {
+	client, err := dialSSH(req)
+	if err != nil {
+		conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("Error connecting to %s@%s: %v\r\n", req.User, req.Host, err)))
+		return
+	}
+	defer client.Close()
 
-	// Read from WebSocket -> PTY
+	session, err := client.NewSession()
+	if err != nil {
+		conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("Error starting SSH: %v\r\n", err)))
+		return
+	}
+	defer session.Close()
+
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	if err := session.RequestPty("xterm-256color", 24, 80, modes); err != nil {
+		conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("Error requesting terminal: %v\r\n", err)))
+		return
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("Error starting SSH: %v\r\n", err)))
+		return
+	}
+	out := &wsWriter{conn: conn}
+	session.Stdout = out
+	session.Stderr = out
+	if err := session.Shell(); err != nil {
+		conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("Error starting shell: %v\r\n", err)))
+		return
+	}
+
+	// Read from WebSocket -> SSH. When the browser goes away, close the
+	// connection so session.Wait below returns.
+	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		defer close(done)
+		defer client.Close()
 		for {
 			msgType, msg, err := conn.ReadMessage()
 			if err != nil {
@@ -146,44 +279,28 @@ func (s *Server) startSSHSession(conn *websocket.Conn, req TerminalRequest) {
 				// Check for resize message
 				var resize TerminalResize
 				if err := json.Unmarshal(msg, &resize); err == nil && resize.Cols > 0 && resize.Rows > 0 {
-					setWinsize(ptmx, resize.Cols, resize.Rows)
+					session.WindowChange(resize.Rows, resize.Cols)
 					continue
 				}
 				// Regular text input
-				if _, err := ptmx.Write(msg); err != nil {
+				if _, err := stdin.Write(msg); err != nil {
 					return
 				}
 			case websocket.BinaryMessage:
-				if _, err := ptmx.Write(msg); err != nil {
+				if _, err := stdin.Write(msg); err != nil {
 					return
 				}
 			}
 		}
 	}()
 
-	// Wait for process to exit
-	cmd.Wait()
-	close(done)
-	wg.Wait()
-
+	// Wait for the remote shell to exit
+	session.Wait()
+	out.mu.Lock()
 	conn.WriteMessage(websocket.TextMessage, []byte("\r\n[Connection closed]\r\n"))
-}
+	out.mu.Unlock()
 
-// setWinsize sets the terminal window size.
-func setWinsize(f *os.File, cols, rows int) {
-	ws := struct {
-		Row    uint16
-		Col    uint16
-		Xpixel uint16
-		Ypixel uint16
-	}{
-		Row: uint16(rows),
-		Col: uint16(cols),
-	}
-	syscall.Syscall(
-		syscall.SYS_IOCTL,
-		f.Fd(),
-		uintptr(syscall.TIOCSWINSZ),
-		uintptr(unsafe.Pointer(&ws)),
-	)
+	// Unblock the WebSocket reader
+	conn.Close()
+	wg.Wait()
 }
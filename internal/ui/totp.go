@@ -0,0 +1,256 @@
+package ui
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// totpStep and totpDigits are the RFC 6238 defaults authenticator apps use.
+	totpStep   = 30 * time.Second
+	totpDigits = 6
+
+	// sessionCookie holds "<expiry unix>.<hex HMAC>" after a successful login.
+	sessionCookie = "vpn_ui_session"
+	sessionTTL    = 12 * time.Hour
+
+	// Failed logins allowed per client IP and loginWindow before its codes
+	// are refused, so the million codes cannot be tried online.
+	maxLoginFailures = 5
+	loginWindow      = time.Minute
+)
+
+// totpAuth gates the dashboard behind a login page that checks a TOTP code
+// and sets a signed session cookie.
+type totpAuth struct {
+	secret     []byte // Shared with the authenticator app
+	sessionKey []byte // Signs session cookies; random per run, so a restart logs everyone out
+	secure     bool   // Mark the cookie Secure (served over HTTPS)
+
+	mu          sync.Mutex
+	lastCounter uint64                    // Time step of the last accepted code, which cannot be reused
+	failures    map[string]*loginFailures // By client IP
+}
+
+// loginFailures counts one client's wrong codes in the current loginWindow.
+type loginFailures struct {
+	count       int
+	windowStart time.Time
+}
+
+// newTOTPAuth parses a base32 secret as shown by authenticator apps (case
+// and spaces ignored, padding optional).
+func newTOTPAuth(secret string) (*totpAuth, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+	if err != nil {
+		return nil, fmt.Errorf("TOTP secret must be base32: %w", err)
+	}
+	if len(key) < 10 {
+		return nil, fmt.Errorf("TOTP secret too short (%d bytes, need at least 10)", len(key))
+	}
+
+	sessionKey := make([]byte, 32)
+	if _, err := rand.Read(sessionKey); err != nil {
+		return nil, err
+	}
+	return &totpAuth{secret: key, sessionKey: sessionKey, failures: make(map[string]*loginFailures)}, nil
+}
+
+// totpCode returns the code for a time step (RFC 4226 HOTP).
+func totpCode(secret []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// verify checks code from client IP ip against the current time step and
+// its neighbours (for clock drift), refusing steps at or before the last
+// accepted one and all codes from ip after too many failures. Other clients
+// are not locked out by one guessing codes.
+func (a *totpAuth) verify(code, ip string, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for client, f := range a.failures {
+		if now.Sub(f.windowStart) > loginWindow {
+			delete(a.failures, client)
+		}
+	}
+	f := a.failures[ip]
+	if f == nil {
+		f = &loginFailures{windowStart: now}
+	}
+	if f.count >= maxLoginFailures {
+		return false
+	}
+
+	code = strings.ReplaceAll(code, " ", "")
+	current := uint64(now.Unix()) / uint64(totpStep.Seconds())
+	for _, counter := range []uint64{current - 1, current, current + 1} {
+		if counter <= a.lastCounter {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(a.secret, counter)), []byte(code)) == 1 {
+			a.lastCounter = counter
+			return true
+		}
+	}
+	f.count++
+	a.failures[ip] = f
+	return false
+}
+
+// sign returns the session cookie value expiring at expiry.
+func (a *totpAuth) sign(expiry time.Time) string {
+	payload := strconv.FormatInt(expiry.Unix(), 10)
+	mac := hmac.New(sha256.New, a.sessionKey)
+	mac.Write([]byte(payload))
+	return payload + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// validSession reports whether r carries an unexpired session cookie.
+func (a *totpAuth) validSession(r *http.Request) bool {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return false
+	}
+	payload, _, ok := strings.Cut(cookie.Value, ".")
+	if !ok {
+		return false
+	}
+	expiry, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		return false
+	}
+	return hmac.Equal([]byte(cookie.Value), []byte(a.sign(time.Unix(expiry, 0))))
+}
+
+// require wraps next: /login and /logout are served here, /api/* and /ws/*
+// answer 401 without a session, and everything else redirects to /login.
+func (a *totpAuth) require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			a.handleLogin(w, r)
+			return
+		case "/logout":
+			// POST only, so other pages can't log the user out with a link
+			if r.Method != http.MethodPost {
+				w.Header().Set("Allow", http.MethodPost)
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		if a.validSession(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/") || strings.HasPrefix(r.URL.Path, "/ws/") {
+			// The dashboard's fetch wrapper sends the user to the login page
+			w.Header().Set("X-Login", "/login")
+			http.Error(w, "login required", http.StatusUnauthorized)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/static/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+	})
+}
+
+// handleLogin serves the login form (GET) and checks a submitted code (POST).
+func (a *totpAuth) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeLoginPage(w, http.StatusOK, "")
+		return
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !a.verify(r.PostFormValue("code"), ip, time.Now()) {
+		log.Printf("[ui] Failed TOTP login from %s", r.RemoteAddr)
+		writeLoginPage(w, http.StatusUnauthorized, "Invalid or expired code")
+		return
+	}
+
+	expiry := time.Now().Add(sessionTTL)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    a.sign(expiry),
+		Path:     "/",
+		Expires:  expiry,
+		HttpOnly: true,
+		Secure:   a.secure,
+		SameSite: http.SameSiteStrictMode,
+	})
+	log.Printf("[ui] TOTP login from %s", r.RemoteAddr)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func writeLoginPage(w http.ResponseWriter, status int, errMsg string) {
+	if errMsg != "" {
+		errMsg = `<p class="error">` + html.EscapeString(errMsg) + `</p>`
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, loginPage, errMsg)
+}
+
+const loginPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<title>VPN Dashboard - Login</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", sans-serif; background: #0f172a; color: #e2e8f0;
+       display: flex; align-items: center; justify-content: center; height: 100vh; margin: 0; }
+form { background: #1e293b; padding: 2rem; border-radius: 8px; width: 280px; }
+h1 { font-size: 1.2rem; margin: 0 0 1rem; }
+input { width: 100%%; box-sizing: border-box; padding: 0.6rem; font-size: 1.4rem; letter-spacing: 0.3rem;
+        text-align: center; border: 1px solid #475569; border-radius: 4px; background: #0f172a; color: #e2e8f0; }
+button { width: 100%%; margin-top: 1rem; padding: 0.6rem; border: 0; border-radius: 4px; background: #3b82f6;
+         color: white; font-size: 1rem; cursor: pointer; }
+.error { color: #f87171; font-size: 0.9rem; }
+</style>
+</head>
+<body>
+<form method="POST" action="/login">
+<h1>VPN Dashboard</h1>
+<p>Enter the code from your authenticator app.</p>
+%s
+<input name="code" inputmode="numeric" pattern="[0-9 ]*" autocomplete="one-time-code" autofocus required>
+<button type="submit">Log in</button>
+</form>
+</body>
+</html>
+`
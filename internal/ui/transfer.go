@@ -0,0 +1,166 @@
+package ui
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// uploadChunkSize is the largest binary message accepted during an
+	// upload; the browser sends files in slices of this size.
+	uploadChunkSize = 256 * 1024
+
+	// uploadIdleTimeout ends an upload whose browser stops sending data.
+	uploadIdleTimeout = 60 * time.Second
+)
+
+// UploadRequest is sent by the frontend to start copying a file to a peer.
+// The SSH fields match TerminalRequest; the file follows as binary messages.
+type UploadRequest struct {
+	Host     string `json:"host"`     // VPN IP address
+	User     string `json:"user"`     // SSH username
+	Password string `json:"password"` // SSH password
+	Name     string `json:"name"`     // File name (directories are stripped)
+	Size     int64  `json:"size"`     // File size in bytes
+	Dir      string `json:"dir"`      // Remote directory ("" = home directory)
+}
+
+// UploadProgress is sent to the frontend while a file is copied.
+type UploadProgress struct {
+	Type  string `json:"type"`            // "progress", "done" or "error"
+	Bytes int64  `json:"bytes"`           // Bytes written to the peer so far
+	Path  string `json:"path,omitempty"`  // Remote path (done)
+	Error string `json:"error,omitempty"` // Failure reason (error)
+}
+
+// handleUpload copies a file dropped on the terminal to the peer over SSH,
+// using the terminal's credentials. The file is streamed chunk by chunk
+// into "cat > path" on the peer, so it is never held in memory, and each
+// chunk is acknowledged with its progress.
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade error: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	fail := func(format string, args ...interface{}) {
+		conn.WriteJSON(UploadProgress{Type: "error", Error: fmt.Sprintf(format, args...)})
+	}
+
+	select {
+	case terminalSessions <- struct{}{}:
+		defer func() { <-terminalSessions }()
+	default:
+		fail("too many terminal sessions (max %d)", maxTerminalSessions)
+		return
+	}
+
+	conn.SetReadDeadline(time.Now().Add(terminalRequestTimeout))
+	var req UploadRequest
+	if err := conn.ReadJSON(&req); err != nil {
+		fail("invalid request: %v", err)
+		return
+	}
+
+	name := path.Base(strings.ReplaceAll(req.Name, "\\", "/"))
+	if req.Host == "" || req.User == "" {
+		fail("host and user are required")
+		return
+	}
+	if err := s.checkSSHTarget(req.Host, req.User); err != nil {
+		fail("%v", err)
+		return
+	}
+	if name == "" || name == "." || name == ".." || name == "/" {
+		fail("invalid file name %q", req.Name)
+		return
+	}
+	if req.Size < 0 {
+		fail("invalid size %d", req.Size)
+		return
+	}
+	remotePath := name
+	if req.Dir != "" {
+		// Relative paths start in the home directory; quoting would keep
+		// a leading ~ from expanding
+		remotePath = strings.TrimPrefix(path.Join(req.Dir, name), "~/")
+	}
+
+	client, err := dialSSH(TerminalRequest{Host: req.Host, User: req.User, Password: req.Password})
+	if err != nil {
+		fail("connecting to %s@%s: %v", req.User, req.Host, err)
+		return
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		fail("starting SSH: %v", err)
+		return
+	}
+	defer session.Close()
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		fail("%v", err)
+		return
+	}
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	if err := session.Start("cat > " + shellQuote(remotePath)); err != nil {
+		fail("starting SSH: %v", err)
+		return
+	}
+
+	conn.SetReadLimit(uploadChunkSize + 1024)
+	var written int64
+	for written < req.Size {
+		conn.SetReadDeadline(time.Now().Add(uploadIdleTimeout))
+		msgType, msg, err := conn.ReadMessage()
+		if err != nil {
+			// Browser gone or cancelled: closing the client (deferred)
+			// ends the remote cat
+			log.Printf("[ui] Upload of %s to %s@%s aborted after %d of %d bytes: %v", remotePath, req.User, req.Host, written, req.Size, err)
+			return
+		}
+		if msgType != websocket.BinaryMessage {
+			continue
+		}
+		if written+int64(len(msg)) > req.Size {
+			fail("received more than the announced %d bytes", req.Size)
+			return
+		}
+		if _, err := stdin.Write(msg); err != nil {
+			// The remote command exited early; its stderr says why
+			break
+		}
+		written += int64(len(msg))
+		conn.WriteJSON(UploadProgress{Type: "progress", Bytes: written})
+	}
+
+	stdin.Close()
+	if err := session.Wait(); err != nil || written < req.Size {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" && err != nil {
+			msg = err.Error()
+		}
+		fail("copy to %s@%s failed: %s", req.User, req.Host, msg)
+		return
+	}
+
+	log.Printf("[ui] Uploaded %s (%d bytes) to %s@%s", remotePath, written, req.User, req.Host)
+	conn.WriteJSON(UploadProgress{Type: "done", Bytes: written, Path: remotePath})
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -1159,16 +1159,20 @@ verify_connection() {
         return 1
     fi
 
-    # Try to get status
-    sleep 2
-    if ./bin/vpn status 2>/dev/null | grep -q "Connected"; then
+    # Wait until the tunnel is up (the macOS service runs with --route-all)
+    SELFTEST_ARGS=(--timeout 60s)
+    if [[ "$OS" == "macos" ]]; then
+        SELFTEST_ARGS+=(--route-all)
+    fi
+    if ./bin/vpn selftest "${SELFTEST_ARGS[@]}"; then
         print_success "VPN is connected!"
         echo ""
         ./bin/vpn status
     else
-        print_warning "VPN may still be connecting..."
+        print_error "VPN did not become healthy"
         echo ""
-        echo "Check status with: $INSTALL_DIR/bin/vpn status"
+        echo "Check logs with: sudo cat /var/log/vpn-node.log"
+        return 1
     fi
 }
 